@@ -170,7 +170,10 @@ func newStartedApp(
 		&inject.Object{Value: c},
 		&inject.Object{Value: lgr},
 		&inject.Object{Value: http.DefaultTransport, Name: "upstreamTransport"},
+		&inject.Object{Value: &transmit.ConnLifetime{}, Name: "connLifetime"},
 		&inject.Object{Value: transmit.NewDefaultTransmission(upstreamClient, metricsr, "upstream"), Name: "upstreamTransmission"},
+		&inject.Object{Value: &transmit.MockTransmission{}, Name: "mirrorTransmission"},
+		&inject.Object{Value: &transmit.MockTransmission{}, Name: "shadowTransmission"},
 		&inject.Object{Value: clockwork.NewRealClock()},
 		&inject.Object{Value: trace.Tracer(noop.Tracer{}), Name: "tracer"},
 		&inject.Object{Value: &cache.SpanCache_basic{}},
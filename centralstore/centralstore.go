@@ -13,7 +13,8 @@ import (
 // is used when a refinery needs to shut down; it can forward all its spans to the central
 // store for forwarding to whichever refinery makes the eventual decision.
 // IsRoot should be set to true if the span is the root of the trace (we don't ask the store
-// to make this decision; the refinery should know this).
+// to make this decision; the refinery should know this). CompletesTrace is set on a span
+// that marks its trace as complete; like the root span, it ends the trace's collection.
 type CentralSpan struct {
 	TraceID         string
 	SpanID          string // need access to this field for updating all fields
@@ -22,6 +23,7 @@ type CentralSpan struct {
 	KeyFields       map[string]interface{}
 	AllFields       map[string]interface{}
 	IsRoot          bool
+	CompletesTrace  bool
 }
 
 // Trace returns the trace ID of the span.
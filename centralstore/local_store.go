@@ -208,8 +208,10 @@ spanLoop:
 		}
 
 		if span.IsRoot {
-			// this is a root span, so we need to move it to the right state
 			lrs.traces[span.TraceID].Root = span
+		}
+		if span.IsRoot || span.CompletesTrace {
+			// the trace is complete, so we need to move it to the right state
 			switch state {
 			case Collecting:
 				lrs.changeTraceState(span.TraceID, Collecting, DecisionDelay)
@@ -223,7 +223,7 @@ func (r *RedisBasicStore) WriteSpans(ctx context.Context, spans []*CentralSpan)
 			shouldIncrementCounts = append(shouldIncrementCounts, span)
 			continue
 		case Collecting:
-			if span.IsRoot {
+			if span.IsRoot || span.CompletesTrace {
 				collecting.Add(span.TraceID)
 			}
 		case DecisionDelay, ReadyToDecide:
@@ -264,6 +264,50 @@ func TestBasicStoreOperation(t *testing.T) {
 	}
 }
 
+func TestCompletedTraceSkipsTraceTimeout(t *testing.T) {
+	for _, storeType := range []string{"local", "redis"} {
+		t.Run(storeType, func(t *testing.T) {
+			store, stopper, err := getAndStartSmartWrapper(storeType, nil)
+			require.NoError(t, err)
+			defer stopper()
+
+			ctx := context.Background()
+			traceID := "completed-trace"
+			require.NoError(t, store.WriteSpan(ctx, &CentralSpan{TraceID: traceID, SpanID: "span1"}))
+			assert.EventuallyWithT(t, func(collect *assert.CollectT) {
+				states, err := store.GetStatusForTraces(ctx, []string{traceID}, Collecting)
+				assert.NoError(collect, err)
+				assert.Equal(collect, 1, len(states))
+			}, 1*time.Second, 10*time.Millisecond)
+
+			// the marked span ends collection without waiting for the
+			// 500ms TraceTimeout, even though there's no root span
+			written := time.Now()
+			require.NoError(t, store.WriteSpan(ctx, &CentralSpan{TraceID: traceID, SpanID: "span2", CompletesTrace: true}))
+			assert.EventuallyWithT(t, func(collect *assert.CollectT) {
+				states, err := store.GetStatusForTraces(ctx, []string{traceID}, DecisionDelay)
+				assert.NoError(collect, err)
+				assert.Equal(collect, 1, len(states))
+			}, 1*time.Second, 10*time.Millisecond)
+			assert.Less(t, time.Since(written), 400*time.Millisecond)
+
+			// a span that arrives during the SendDelay is still part of the trace
+			require.NoError(t, store.WriteSpan(ctx, &CentralSpan{TraceID: traceID, SpanID: "span3"}))
+			assert.EventuallyWithT(t, func(collect *assert.CollectT) {
+				states, err := store.GetStatusForTraces(ctx, []string{traceID}, ReadyToDecide)
+				assert.NoError(collect, err)
+				assert.Equal(collect, 1, len(states))
+			}, 3*time.Second, 100*time.Millisecond)
+
+			traces, err := store.GetTraces(ctx, traceID)
+			require.NoError(t, err)
+			require.Len(t, traces, 1)
+			assert.Len(t, traces[0].Spans, 3)
+			assert.Nil(t, traces[0].Root)
+		})
+	}
+}
+
 func TestReadyForDecisionLoop(t *testing.T) {
 	for _, storeType := range []string{"local", "redis"} {
 		t.Run(storeType, func(t *testing.T) {
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -19,6 +21,7 @@ import (
 	"go.opentelemetry.io/otel/trace/noop"
 	_ "go.uber.org/automaxprocs"
 	"golang.org/x/exp/slices"
+	"golang.org/x/net/http/httpproxy"
 
 	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/honeycombio/refinery/app"
@@ -31,6 +34,8 @@ import (
 	"github.com/honeycombio/refinery/internal/health"
 	"github.com/honeycombio/refinery/internal/otelutil"
 	"github.com/honeycombio/refinery/internal/peer"
+	"github.com/honeycombio/refinery/internal/srv"
+	"github.com/honeycombio/refinery/internal/webhook"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
 	"github.com/honeycombio/refinery/redis"
@@ -120,37 +125,108 @@ func main() {
 
 	// upstreamTransport is the http transport used to send things on to Honeycomb
 	upstreamTransport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout: 10 * time.Second,
-		}).Dial,
+		Proxy:               upstreamProxy(cfg),
 		TLSHandshakeTimeout: 15 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:   cfg.GetTLSMinVersion(),
+			CipherSuites: cfg.GetTLSCipherSuites(),
+		},
+		MaxIdleConns:        cfg.GetUpstreamMaxIdleConns(),
+		MaxIdleConnsPerHost: cfg.GetUpstreamMaxIdleConnsPerHost(),
+		IdleConnTimeout:     cfg.GetUpstreamIdleConnTimeout(),
 	}
+	// srvResolver dials the targets of an SRV record when HoneycombAPI uses an
+	// srv+ scheme, and passes every other address through unchanged
+	srvResolver := &srv.Resolver{}
+	srvResolver.WrapTransport(upstreamTransport, &net.Dialer{
+		Timeout: 10 * time.Second,
+	})
 
 	genericMetricsRecorder := metrics.NewMetricsPrefixer("")
 	upstreamMetricsRecorder := metrics.NewMetricsPrefixer("libhoney_upstream")
 
+	// connLifetime replaces upstream connections older than
+	// UpstreamMaxConnLifetime; everything that uses upstreamTransport has to
+	// go through connLifetime.Wrap so that busy connections are left alone
+	connLifetime := transmit.LimitConnLifetime(upstreamTransport, cfg.GetUpstreamMaxConnLifetime(), upstreamMetricsRecorder)
+
 	userAgentAddition := "refinery/" + version
-	upstreamClient, err := libhoney.NewClient(libhoney.ClientConfig{
+	// destinations with their own BatchingByDestination settings get upstream
+	// clients of their own, made the same way
+	newUpstreamClient := func(maxBatchSize uint, batchTimeout time.Duration) (*libhoney.Client, error) {
+		return libhoney.NewClient(libhoney.ClientConfig{
+			Transmission: &transmission.Honeycomb{
+				MaxBatchSize:         maxBatchSize,
+				BatchTimeout:         batchTimeout,
+				MaxConcurrentBatches: cfg.GetUpstreamSendConcurrency(),
+				PendingWorkCapacity:  uint(cfg.GetUpstreamBufferSize()),
+				UserAgentAddition:    userAgentAddition,
+				Transport: transmit.NewSenderUtilizationTransport(
+					transmit.NewCompressionRatioTransport(connLifetime.Wrap(upstreamTransport), upstreamMetricsRecorder),
+					upstreamMetricsRecorder, cfg.GetUpstreamSendConcurrency()),
+				BlockOnSend:           true,
+				EnableMsgpackEncoding: true,
+				DisableCompression:    !cfg.GetCompressUpstreamCommunication(),
+				Metrics:               upstreamMetricsRecorder,
+			},
+		})
+	}
+	upstreamClient, err := newUpstreamClient(cfg.GetMaxBatchSize(), cfg.GetBatchTimeout())
+	if err != nil {
+		fmt.Printf("unable to initialize upstream libhoney client")
+		os.Exit(1)
+	}
+
+	// the mirror gets its own client so that a slow mirror can't hold up
+	// sending sampled data upstream
+	mirrorMetricsRecorder := metrics.NewMetricsPrefixer("libhoney_mirror")
+	mirrorClient, err := libhoney.NewClient(libhoney.ClientConfig{
 		Transmission: &transmission.Honeycomb{
 			MaxBatchSize:          cfg.GetMaxBatchSize(),
 			BatchTimeout:          cfg.GetBatchTimeout(),
-			MaxConcurrentBatches:  libhoney.DefaultMaxConcurrentBatches,
+			MaxConcurrentBatches:  cfg.GetUpstreamSendConcurrency(),
 			PendingWorkCapacity:   uint(cfg.GetUpstreamBufferSize()),
 			UserAgentAddition:     userAgentAddition,
-			Transport:             upstreamTransport,
+			Transport:             transmit.NewSenderUtilizationTransport(connLifetime.Wrap(upstreamTransport), mirrorMetricsRecorder, cfg.GetUpstreamSendConcurrency()),
 			BlockOnSend:           true,
 			EnableMsgpackEncoding: true,
-			Metrics:               upstreamMetricsRecorder,
+			DisableCompression:    !cfg.GetCompressUpstreamCommunication(),
+			Metrics:               mirrorMetricsRecorder,
 		},
 	})
 	if err != nil {
-		fmt.Printf("unable to initialize upstream libhoney client")
+		fmt.Printf("unable to initialize mirror libhoney client")
+		os.Exit(1)
+	}
+
+	// the shadow upstream gets its own client too, so that it's isolated from
+	// the primary upstream's buffer
+	shadowMetricsRecorder := metrics.NewMetricsPrefixer("libhoney_shadow")
+	shadowClient, err := libhoney.NewClient(libhoney.ClientConfig{
+		Transmission: &transmission.Honeycomb{
+			MaxBatchSize:          cfg.GetMaxBatchSize(),
+			BatchTimeout:          cfg.GetBatchTimeout(),
+			MaxConcurrentBatches:  cfg.GetUpstreamSendConcurrency(),
+			PendingWorkCapacity:   uint(cfg.GetUpstreamBufferSize()),
+			UserAgentAddition:     userAgentAddition,
+			Transport:             transmit.NewSenderUtilizationTransport(connLifetime.Wrap(upstreamTransport), shadowMetricsRecorder, cfg.GetUpstreamSendConcurrency()),
+			BlockOnSend:           true,
+			EnableMsgpackEncoding: true,
+			DisableCompression:    !cfg.GetCompressUpstreamCommunication(),
+			Metrics:               shadowMetricsRecorder,
+		},
+	})
+	if err != nil {
+		fmt.Printf("unable to initialize shadow libhoney client")
 		os.Exit(1)
 	}
 
 	stressRelief := &stressRelief.StressRelief{}
 	upstreamTransmission := transmit.NewDefaultTransmission(upstreamClient, upstreamMetricsRecorder, "upstream")
+	upstreamTransmission.EnableSpill = true
+	upstreamTransmission.NewClient = newUpstreamClient
+	mirrorTransmission := transmit.NewDefaultTransmission(mirrorClient, mirrorMetricsRecorder, "mirror")
+	shadowTransmission := transmit.NewDefaultTransmission(shadowClient, shadowMetricsRecorder, "shadow")
 
 	// we need to include all the metrics types so we can inject them in case they're needed
 	// but we only want to instantiate the ones that are enabled with non-null values
@@ -183,6 +259,11 @@ func main() {
 	}
 	smartStore := &centralstore.SmartWrapper{}
 
+	var peers peer.Peers = &peer.PeerStore{}
+	if cfg.GetPeerManagementType() == "consul" {
+		peers = &peer.ConsulPeerStore{}
+	}
+
 	resourceLib := "refinery"
 	resourceVer := version
 	var tracer trace.Tracer
@@ -211,7 +292,12 @@ func main() {
 		{Value: cfg},
 		{Value: lgr},
 		{Value: upstreamTransport, Name: "upstreamTransport"},
+		{Value: connLifetime, Name: "connLifetime"},
+		{Value: srvResolver},
+		{Value: &webhook.DecisionDispatcher{}},
 		{Value: upstreamTransmission, Name: "upstreamTransmission"},
+		{Value: mirrorTransmission, Name: "mirrorTransmission"},
+		{Value: shadowTransmission, Name: "shadowTransmission"},
 		{Value: &cache.SpanCache_basic{}},
 		{Value: centralcollector, Name: "collector"},
 		{Value: decisionCache},
@@ -221,11 +307,13 @@ func main() {
 		{Value: metricsSingleton, Name: "metrics"},
 		{Value: genericMetricsRecorder, Name: "genericMetrics"},
 		{Value: upstreamMetricsRecorder, Name: "upstreamMetrics"},
+		{Value: mirrorMetricsRecorder, Name: "mirrorMetrics"},
+		{Value: shadowMetricsRecorder, Name: "shadowMetrics"},
 		{Value: version, Name: "version"},
 		{Value: samplerFactory},
 		{Value: channels, Name: "gossip"},
 		{Value: stressRelief, Name: "stressRelief"},
-		{Value: &peer.PeerStore{}},
+		{Value: peers},
 		{Value: tracer, Name: "tracer"},
 		{Value: clockwork.NewRealClock()},
 		{Value: basicStore},
@@ -284,6 +372,12 @@ func main() {
 	}
 	for name, typ := range libhoneyMetricsName {
 		upstreamMetricsRecorder.Register(name, typ)
+		if cfg.GetMirrorAllSpans() {
+			mirrorMetricsRecorder.Register(name, typ)
+		}
+		if cfg.GetShadowConfig().Enabled() {
+			shadowMetricsRecorder.Register(name, typ)
+		}
 	}
 
 	metricsSingleton.Store("UPSTREAM_BUFFER_SIZE", float64(cfg.GetUpstreamBufferSize()))
@@ -299,3 +393,22 @@ func main() {
 	time.Sleep(100 * time.Millisecond)
 	a.Logger.Error().Logf("Caught signal \"%s\"", sig)
 }
+
+// upstreamProxy returns the Proxy function for the upstream transport. An
+// explicit UpstreamProxyURL is used for every request, except to the
+// UpstreamNoProxy hosts, whatever the proxy environment variables say;
+// otherwise the environment variables are used as usual.
+func upstreamProxy(cfg config.Config) func(*http.Request) (*url.URL, error) {
+	proxyURL := cfg.GetUpstreamProxyURL()
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    strings.Join(cfg.GetUpstreamNoProxy(), ","),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/metrics"
 	"github.com/honeycombio/refinery/types"
+	"github.com/jonboulle/clockwork"
 )
 
 // cuckooSentCache extends Refinery's legacy cache. It keeps the same records
@@ -139,12 +140,25 @@ func (t *cuckooDroppedRecord) Reason() uint {
 // Make sure it implements TraceSentRecord
 var _ TraceSentRecord = (*cuckooDroppedRecord)(nil)
 
+// retainedDecision is a decision that's remembered until expiresAt, even if
+// the kept or dropped cache has forgotten it to make room.
+type retainedDecision struct {
+	kept       *keptTraceCacheEntry // nil if the trace was dropped
+	recordedAt time.Time
+	expiresAt  time.Time
+}
+
 type CuckooSentCache struct {
 	Cfg     config.Config   `inject:""`
 	Met     metrics.Metrics `inject:"genericMetrics"`
+	Clock   clockwork.Clock `inject:""`
 	kept    *lru.Cache[string, *keptTraceCacheEntry]
 	dropped *CuckooTraceChecker
 
+	// retained holds the decisions that Retain was asked to keep; it's
+	// protected by keptMut
+	retained map[string]retainedDecision
+
 	// The done channel is used to decide when to terminate the monitor
 	// goroutine. When resizing the cache, we write to the channel, but
 	// when terminating the system, call Stop() to close the channel.
@@ -175,6 +189,10 @@ func (c *CuckooSentCache) Start() error {
 	c.kept = kept
 	c.dropped = NewCuckooTraceChecker(cfg.DroppedSize, c.Met)
 	c.sentReasons = NewSentReasonsCache(c.Met)
+	c.retained = make(map[string]retainedDecision)
+	if c.Clock == nil {
+		c.Clock = clockwork.NewRealClock()
+	}
 	c.done = make(chan struct{})
 
 	go c.monitor()
@@ -188,6 +206,7 @@ func (c *CuckooSentCache) monitor() {
 		select {
 		case <-ticker.C:
 			c.dropped.Maintain()
+			c.expireRetained()
 		case <-c.done:
 			ticker.Stop()
 			return
@@ -217,9 +236,64 @@ func (c *CuckooSentCache) Record(trace KeptTrace, keep bool, reason string) {
 	c.dropped.Add(trace.ID())
 }
 
+// Retain makes sure that the decision just recorded for traceID is
+// remembered for at least ttl, even if the kept or dropped cache would
+// otherwise forget it to make room for newer decisions.
+func (c *CuckooSentCache) Retain(traceID string, keep bool, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.keptMut.Lock()
+	defer c.keptMut.Unlock()
+
+	now := c.Clock.Now()
+	d := retainedDecision{recordedAt: now, expiresAt: now.Add(ttl)}
+	if keep {
+		entry, found := c.kept.Peek(traceID)
+		if !found {
+			return
+		}
+		d.kept = entry
+	}
+	c.retained[traceID] = d
+}
+
+// getRetained returns the retained decision for traceID, if it hasn't
+// expired. The caller must hold keptMut.
+func (c *CuckooSentCache) getRetained(traceID string) (retainedDecision, bool) {
+	d, found := c.retained[traceID]
+	if !found {
+		return d, false
+	}
+	if !c.Clock.Now().Before(d.expiresAt) {
+		delete(c.retained, traceID)
+		return d, false
+	}
+	return d, true
+}
+
+// expireRetained forgets the retained decisions that have expired.
+func (c *CuckooSentCache) expireRetained() {
+	c.keptMut.Lock()
+	defer c.keptMut.Unlock()
+
+	now := c.Clock.Now()
+	for traceID, d := range c.retained {
+		if !now.Before(d.expiresAt) {
+			delete(c.retained, traceID)
+		}
+	}
+}
+
 // fast check to see if we've already dropped this trace
 func (c *CuckooSentCache) Dropped(traceID string) bool {
-	return c.dropped.Check(traceID)
+	if c.dropped.Check(traceID) {
+		return true
+	}
+	c.keptMut.Lock()
+	defer c.keptMut.Unlock()
+	d, found := c.getRetained(traceID)
+	return found && d.kept == nil
 }
 
 func (c *CuckooSentCache) Check(span *types.Span) (TraceSentRecord, string, bool) {
@@ -237,6 +311,16 @@ func (c *CuckooSentCache) Check(span *types.Span) (TraceSentRecord, string, bool
 		reason, _ := c.sentReasons.Get(uint(sentRecord.reason))
 		return sentRecord, reason, true
 	}
+	// the caches may have made room by forgetting a decision we were asked
+	// to retain
+	if d, found := c.getRetained(span.TraceID); found {
+		if d.kept == nil {
+			return &cuckooDroppedRecord{}, "", false
+		}
+		d.kept.Count(span)
+		reason, _ := c.sentReasons.Get(uint(d.kept.reason))
+		return d.kept, reason, true
+	}
 	// we have no memory of this place
 	return nil, "", false
 }
@@ -256,6 +340,13 @@ func (c *CuckooSentCache) Test(traceID string) (TraceSentRecord, string, bool) {
 		reason, _ := c.sentReasons.Get(uint(sentRecord.reason))
 		return sentRecord, reason, true
 	}
+	if d, found := c.getRetained(traceID); found {
+		if d.kept == nil {
+			return &cuckooDroppedRecord{}, "", true
+		}
+		reason, _ := c.sentReasons.Get(uint(d.kept.reason))
+		return d.kept, reason, true
+	}
 	// we have no memory of this place
 	return nil, "", false
 }
@@ -293,14 +384,85 @@ func (c *CuckooSentCache) Resize(cfg config.SampleCacheConfig) error {
 	return nil
 }
 
+func (c *CuckooSentCache) ExportKept(fn func(KeptDecision) error) error {
+	c.keptMut.Lock()
+	keys := c.kept.Keys()
+	c.keptMut.Unlock()
+
+	// take the lock for each record rather than for the whole export, so
+	// that a slow reader doesn't hold up sampling decisions
+	for _, traceID := range keys {
+		c.keptMut.Lock()
+		entry, found := c.kept.Peek(traceID)
+		var d KeptDecision
+		if found {
+			reason, _ := c.sentReasons.Get(uint(entry.reason))
+			d = KeptDecision{
+				TraceID:         traceID,
+				Rate:            entry.rate,
+				DescendantCount: entry.eventCount,
+				SpanEventCount:  entry.spanEventCount,
+				SpanLinkCount:   entry.spanLinkCount,
+				SpanCount:       entry.spanCount,
+				Reason:          reason,
+			}
+		}
+		c.keptMut.Unlock()
+
+		// it may have been evicted since we got the keys
+		if !found {
+			continue
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CuckooSentCache) ImportKept(d KeptDecision) bool {
+	if d.TraceID == "" || c.dropped.Check(d.TraceID) {
+		return false
+	}
+
+	c.keptMut.Lock()
+	defer c.keptMut.Unlock()
+	if c.kept.Contains(d.TraceID) {
+		return false
+	}
+	c.kept.Add(d.TraceID, &keptTraceCacheEntry{
+		rate:           d.Rate,
+		eventCount:     d.DescendantCount,
+		spanEventCount: d.SpanEventCount,
+		spanLinkCount:  d.SpanLinkCount,
+		spanCount:      d.SpanCount,
+		reason:         uint32(c.sentReasons.Set(d.Reason)),
+	})
+	return true
+}
+
 func (c *CuckooSentCache) GetMetrics() (map[string]interface{}, error) {
 	cfg := c.Cfg.GetSampleCacheConfig()
+
+	c.keptMut.Lock()
+	retained := len(c.retained)
+	var oldest time.Duration
+	now := c.Clock.Now()
+	for _, d := range c.retained {
+		if age := now.Sub(d.recordedAt); age > oldest {
+			oldest = age
+		}
+	}
+	c.keptMut.Unlock()
+
 	metrics := map[string]interface{}{
-		"sent_cache_kept":             c.kept.Len(),
-		"sent_cache_kept_capacity":    cfg.KeptSize,
-		"sent_cache_dropped":          c.dropped.Count(),
-		"sent_cache_dropped_load":     c.dropped.LoadFactor(),
-		"sent_cache_dropped_capacity": cfg.DroppedSize,
+		"sent_cache_kept":                   c.kept.Len(),
+		"sent_cache_kept_capacity":          cfg.KeptSize,
+		"sent_cache_dropped":                c.dropped.Count(),
+		"sent_cache_dropped_load":           c.dropped.LoadFactor(),
+		"sent_cache_dropped_capacity":       cfg.DroppedSize,
+		"sent_cache_retained":               retained,
+		"sent_cache_retained_oldest_age_ms": oldest.Milliseconds(),
 	}
 	return metrics, nil
 }
@@ -7,6 +7,8 @@ import (
 
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/jonboulle/clockwork"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -91,3 +93,149 @@ func Test_cuckooSentCache_Record(t *testing.T) {
 	_, _, found = c.Test("traceXX")
 	assert.False(t, found)
 }
+
+func Test_cuckooSentCache_ExportImport(t *testing.T) {
+	newCache := func() *CuckooSentCache {
+		c := &CuckooSentCache{
+			Cfg: &config.MockConfig{
+				SampleCache: config.SampleCacheConfig{
+					KeptSize:          1000,
+					DroppedSize:       1000,
+					SizeCheckInterval: config.Duration(1 * time.Second),
+				},
+			},
+			Met: &metrics.NullMetrics{},
+		}
+		require.NoError(t, c.Start())
+		t.Cleanup(func() { c.Stop() })
+		return c
+	}
+
+	old := newCache()
+	for i := 0; i < 10; i++ {
+		old.Record(&testTrace{TraceID: fmt.Sprintf("trace%02d", i)}, i%2 == 1, "because")
+	}
+
+	var exported []KeptDecision
+	require.NoError(t, old.ExportKept(func(d KeptDecision) error {
+		exported = append(exported, d)
+		return nil
+	}))
+	// only the kept ones, oldest first
+	require.Len(t, exported, 5)
+	assert.Equal(t, KeptDecision{
+		TraceID:         "trace01",
+		Rate:            17,
+		DescendantCount: 6,
+		SpanEventCount:  1,
+		SpanLinkCount:   2,
+		SpanCount:       3,
+		Reason:          "because",
+	}, exported[0])
+	assert.Equal(t, "trace09", exported[4].TraceID)
+
+	restarted := newCache()
+	restarted.Record(&testTrace{TraceID: "trace03"}, true, "already decided")
+	imported := 0
+	for _, d := range exported {
+		if restarted.ImportKept(d) {
+			imported++
+		}
+	}
+	assert.Equal(t, 4, imported)
+
+	tr, reason, found := restarted.Test("trace01")
+	require.True(t, found)
+	assert.True(t, tr.Kept())
+	assert.Equal(t, uint(17), tr.Rate())
+	assert.Equal(t, "because", reason)
+
+	// the node's own decision wins
+	_, reason, found = restarted.Test("trace03")
+	require.True(t, found)
+	assert.Equal(t, "already decided", reason)
+
+	// stops at the first error
+	calls := 0
+	err := old.ExportKept(func(KeptDecision) error {
+		calls++
+		return fmt.Errorf("reader went away")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func Test_cuckooSentCache_Retain(t *testing.T) {
+	sampleCache := config.SampleCacheConfig{
+		KeptSize:             1,
+		DroppedSize:          1000,
+		SizeCheckInterval:    config.Duration(1 * time.Second),
+		DecisionTTL:          config.Duration(1 * time.Minute),
+		DecisionTTLByDataset: map[string]config.Duration{"slow": config.Duration(10 * time.Minute)},
+	}
+	clock := clockwork.NewFakeClock()
+	c := &CuckooSentCache{
+		Cfg:   &config.MockConfig{SampleCache: sampleCache},
+		Met:   &metrics.NullMetrics{},
+		Clock: clock,
+	}
+	require.NoError(t, c.Start())
+	t.Cleanup(func() { c.Stop() })
+
+	c.Record(&testTrace{TraceID: "slow"}, true, "because")
+	c.Retain("slow", true, sampleCache.GetDecisionTTL("slow"))
+	c.Record(&testTrace{TraceID: "fast"}, true, "because")
+	c.Retain("fast", true, sampleCache.GetDecisionTTL("fast"))
+	c.Record(&testTrace{TraceID: "dropped"}, false, "")
+	c.Retain("dropped", false, sampleCache.GetDecisionTTL("fast"))
+	// with room for only one kept trace, this evicts both of the others
+	c.Record(&testTrace{TraceID: "other"}, true, "because")
+
+	tr, reason, found := c.Check(&types.Span{TraceID: "slow"})
+	require.True(t, found, "retained decisions outlive eviction")
+	assert.True(t, tr.Kept())
+	assert.Equal(t, uint(17), tr.Rate())
+	assert.Equal(t, "because", reason)
+	_, _, found = c.Test("fast")
+	assert.True(t, found)
+	assert.True(t, c.Dropped("dropped"))
+
+	metrics, err := c.GetMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 3, metrics["sent_cache_retained"])
+
+	clock.Advance(2 * time.Minute)
+	_, _, found = c.Test("slow")
+	assert.True(t, found, "the slow dataset has a longer TTL")
+	_, _, found = c.Test("fast")
+	assert.False(t, found, "expired decisions are forgotten")
+
+	clock.Advance(10 * time.Minute)
+	_, _, found = c.Check(&types.Span{TraceID: "slow"})
+	assert.False(t, found)
+
+	c.expireRetained()
+	metrics, err = c.GetMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 0, metrics["sent_cache_retained"])
+}
+
+func Test_cuckooSentCache_RetainNotRecorded(t *testing.T) {
+	c := &CuckooSentCache{
+		Cfg: &config.MockConfig{
+			SampleCache: config.SampleCacheConfig{
+				KeptSize:          10,
+				DroppedSize:       1000,
+				SizeCheckInterval: config.Duration(1 * time.Second),
+			},
+		},
+		Met: &metrics.NullMetrics{},
+	}
+	require.NoError(t, c.Start())
+	t.Cleanup(func() { c.Stop() })
+
+	c.Retain("trace", true, time.Minute)
+	c.Retain("trace2", true, 0)
+	_, _, found := c.Test("trace")
+	assert.False(t, found, "kept decisions can only be retained once they're recorded")
+}
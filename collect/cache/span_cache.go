@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/honeycombio/refinery/types"
 	"github.com/jonboulle/clockwork"
 )
@@ -26,9 +28,15 @@ type SpanCache interface {
 	GetTraceIDs(n int) []string
 	// Returns all trace IDs in the cache that are older than a cutoff time.
 	GetOldTraceIDs() []string
+	// Returns all trace IDs in the cache that arrived more than age ago, and
+	// how long ago the oldest trace in the cache arrived.
+	GetTraceIDsOlderThan(age time.Duration) (ids []string, oldest time.Duration)
 	// Removes a trace from the cache by traceID. If no trace with the traceID exists,
 	// does nothing.
 	Remove(traceID string)
+	// Returns the number of spans of all kinds in the cache for a trace, or 0
+	// if the trace isn't in the cache.
+	DescendantCount(traceID string) uint32
 	// Returns the number of traces in the cache.
 	Len() int
 	// Clock returns the clock used by the cache.
@@ -175,6 +175,28 @@ func (sc *SpanCache_basic) GetOldTraceIDs() []string {
 	return ids
 }
 
+// GetTraceIDsOlderThan returns the traces that arrived more than age ago,
+// however they got that old, along with the age of the oldest trace.
+func (sc *SpanCache_basic) GetTraceIDsOlderThan(age time.Duration) ([]string, time.Duration) {
+	now := sc.Clock.Now()
+	var ids []string
+	var oldest time.Duration
+
+	sc.mut.RLock()
+	defer sc.mut.RUnlock()
+
+	for traceID, trace := range sc.cache {
+		traceAge := now.Sub(trace.ArrivalTime)
+		if traceAge > oldest {
+			oldest = traceAge
+		}
+		if age > 0 && traceAge > age {
+			ids = append(ids, traceID)
+		}
+	}
+	return ids, oldest
+}
+
 func (sc *SpanCache_basic) Remove(traceID string) {
 	sc.mut.Lock()
 	defer sc.mut.Unlock()
@@ -187,6 +209,16 @@ func (sc *SpanCache_basic) Remove(traceID string) {
 	delete(sc.cache, traceID)
 }
 
+func (sc *SpanCache_basic) DescendantCount(traceID string) uint32 {
+	sc.mut.RLock()
+	defer sc.mut.RUnlock()
+	trace, ok := sc.cache[traceID]
+	if !ok {
+		return 0
+	}
+	return trace.DescendantCount()
+}
+
 func (sc *SpanCache_basic) Len() int {
 	sc.mut.RLock()
 	defer sc.mut.RUnlock()
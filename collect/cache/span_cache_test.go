@@ -64,6 +64,8 @@ func TestSpanCache(t *testing.T) {
 			assert.Equal(t, "dataset", trace.Dataset)
 			// assert.Equal(t, c.Clock.Now(), trace.ArrivalTime)
 			assert.Equal(t, 1, c.Len())
+			assert.Equal(t, uint32(1), c.DescendantCount("trace1"))
+			assert.Equal(t, uint32(0), c.DescendantCount("trace2"))
 
 			// test that we can remove the span
 			c.Remove("trace1")
@@ -202,6 +204,37 @@ func TestGetOldest(t *testing.T) {
 	}
 }
 
+func TestGetTraceIDsOlderThan(t *testing.T) {
+	fakeClock := clockwork.NewFakeClock()
+	c := getCache("basic", fakeClock)
+	err := c.(startstop.Starter).Start()
+	require.NoError(t, err)
+
+	ids, oldest := c.GetTraceIDsOlderThan(time.Minute)
+	assert.Empty(t, ids)
+	assert.Equal(t, time.Duration(0), oldest)
+
+	for _, id := range []string{"trace1", "trace2", "trace3"} {
+		err := c.Set(&types.Span{
+			TraceID:     id,
+			Event:       types.Event{Dataset: "dataset"},
+			ArrivalTime: c.GetClock().Now(),
+		})
+		require.NoError(t, err)
+		fakeClock.Advance(time.Minute)
+	}
+
+	// the traces arrived 3m, 2m, and 1m ago
+	ids, oldest = c.GetTraceIDsOlderThan(90 * time.Second)
+	assert.ElementsMatch(t, []string{"trace1", "trace2"}, ids)
+	assert.Equal(t, 3*time.Minute, oldest)
+
+	// an age of 0 reports the oldest trace but doesn't return any
+	ids, oldest = c.GetTraceIDsOlderThan(0)
+	assert.Empty(t, ids)
+	assert.Equal(t, 3*time.Minute, oldest)
+}
+
 func BenchmarkSpanCacheAdd(b *testing.B) {
 	for _, typ := range []string{"basic"} {
 		c := getCache(typ, clockwork.NewFakeClock())
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/types"
 )
@@ -25,6 +27,9 @@ type TraceSentRecord interface {
 type TraceSentCache interface {
 	// Record preserves the record of a trace being sent or not.
 	Record(trace KeptTrace, keep bool, reason string)
+	// Retain makes sure the decision just recorded for a trace is remembered
+	// for at least ttl, however full the cache gets
+	Retain(traceID string, keep bool, ttl time.Duration)
 	// Fast check to see if a trace was dropped
 	Dropped(traceID string) bool
 	// Check tests if a trace corresponding to the span is in the cache; if
@@ -41,4 +46,23 @@ type TraceSentCache interface {
 	Resize(cfg config.SampleCacheConfig) error
 	// GetMetrics returns a map of metrics about the cache
 	GetMetrics() (map[string]interface{}, error)
+	// ExportKept calls fn with each kept decision in the cache, from oldest to
+	// newest, and stops at the first error. Dropped decisions can't be
+	// exported, because only a fingerprint of their trace IDs is kept.
+	ExportKept(fn func(KeptDecision) error) error
+	// ImportKept records a kept decision exported by another cache, unless
+	// this cache already has a decision for the trace. It returns false if
+	// the decision was not recorded.
+	ImportKept(d KeptDecision) bool
+}
+
+// KeptDecision is the exported form of a kept trace's record.
+type KeptDecision struct {
+	TraceID         string `json:"trace_id"`
+	Rate            uint32 `json:"rate"`
+	DescendantCount uint32 `json:"descendant_count"`
+	SpanEventCount  uint32 `json:"span_event_count"`
+	SpanLinkCount   uint32 `json:"span_link_count"`
+	SpanCount       uint32 `json:"span_count"`
+	Reason          string `json:"reason"`
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"slices"
@@ -19,6 +20,7 @@ import (
 	"github.com/honeycombio/refinery/internal/gossip"
 	"github.com/honeycombio/refinery/internal/health"
 	"github.com/honeycombio/refinery/internal/otelutil"
+	"github.com/honeycombio/refinery/internal/webhook"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
 	"github.com/honeycombio/refinery/sample"
@@ -38,6 +40,34 @@ type Collector interface {
 	AddSpan(*types.Span) error
 	Stressed() bool
 	ProcessSpanImmediately(*types.Span) (bool, error)
+	// RemainingTraceCount returns the number of traces (and queued spans)
+	// that are still buffered locally and have not yet been handed off.
+	RemainingTraceCount() int
+	// Drain is called once the router stops accepting new data; it starts
+	// sending the traces that are already buffered in the background.
+	Drain()
+	// GetDroppedSamples returns the dropped traces retained for debugging,
+	// newest first. enabled is false if DroppedSampleRetention is off.
+	GetDroppedSamples() (samples []DroppedSample, enabled bool)
+	// GetSamplerKeys returns the sampler keys that the sampler for selector
+	// has seen recently. found is false if there's no such sampler or it
+	// doesn't track keys.
+	GetSamplerKeys(selector string) (keys []sample.SamplerKey, found bool)
+	// Load reports how busy the collector is.
+	Load() Load
+	// SubscribeTraces returns a channel that's notified, without blocking the
+	// collector, of each trace that this node sends or drops.
+	SubscribeTraces(depth int) <-chan TraceNotification
+}
+
+// Load describes how busy a collector is, for reporting to load balancers.
+type Load struct {
+	IncomingQueueLength   int
+	IncomingQueueCapacity int
+	// StressLevel is this node's stress level, from 0 to 100, which is what
+	// stress relief is activated by.
+	StressLevel uint
+	Stressed    bool
 }
 
 func GetCollectorImplementation(c config.Config) Collector {
@@ -46,14 +76,75 @@ func GetCollectorImplementation(c config.Config) Collector {
 
 var ErrWouldBlock = errors.New("not adding span, channel buffer is full")
 
+// ErrTraceSpanLimit is returned by AddSpan for spans of a trace that already
+// has MaxSpansPerTrace spans.
+var ErrTraceSpanLimit = errors.New("not adding span, trace has reached the maximum number of spans")
+
 // These are the names of the metrics we use to track our send decisions.
 const (
 	TraceSendGotRoot        = "trace_send_got_root"
 	TraceSendExpired        = "trace_send_expired"
 	TraceSendEjectedMemsize = "trace_send_ejected_memsize"
 	TraceSendLateSpan       = "trace_send_late_span"
+	TraceSendMaxAge         = "trace_send_max_age"
 )
 
+const (
+	// forceKeepReason is the reason recorded for traces kept because of
+	// ForceKeepConditions.
+	forceKeepReason = "force keep"
+
+	// forceKeepKeyField marks a force-kept span in the central store, so that
+	// whichever refinery decides the trace knows to keep it.
+	forceKeepKeyField = "meta.refinery.force_keep"
+
+	// errorReason and errorKeyField do the same for traces with a span that
+	// matches the ErrorTraceCondition, when KeepErrorTraces is enabled.
+	errorReason   = "error"
+	errorKeyField = "meta.refinery.error_trace"
+
+	// syntheticReason and syntheticKeyField do the same for traces that match
+	// the SyntheticTraceCondition.
+	syntheticReason   = "synthetic"
+	syntheticKeyField = "meta.refinery.synthetic"
+
+	// dryRunKeyField marks the spans of environments that are sampled in dry
+	// run mode, because the decider doesn't know a trace's environment.
+	dryRunKeyField = "meta.refinery.dryrun"
+
+	// dryRunSampleRateField records the sample rate that a trace would have
+	// been sent with if it weren't for dry run mode.
+	dryRunSampleRateField = "meta.refinery.dryrun.sample_rate"
+
+	// spanLimitHitField marks the spans of a trace that had spans dropped
+	// because of MaxSpansPerTrace.
+	spanLimitHitField = "meta.refinery.span_limit_hit"
+	// intraTraceDroppedField is the number of spans intra-trace sampling
+	// dropped, and is added to the root span
+	intraTraceDroppedField = "meta.refinery.intra_trace_dropped"
+)
+
+// hasMarkedSpan reports whether any span of the trace has the marker key
+// field, such as forceKeepKeyField.
+func hasMarkedSpan(trace *centralstore.CentralTrace, marker string) bool {
+	hasMarker := func(sp *centralstore.CentralSpan) bool {
+		if sp == nil {
+			return false
+		}
+		_, ok := sp.KeyFields[marker]
+		return ok
+	}
+	if hasMarker(trace.Root) {
+		return true
+	}
+	for _, sp := range trace.Spans {
+		if hasMarker(sp) {
+			return true
+		}
+	}
+	return false
+}
+
 type traceForDecision struct {
 	*centralstore.CentralTrace
 	descendantCount uint32
@@ -79,16 +170,41 @@ type CentralCollector struct {
 	Health         health.Recorder             `inject:""`
 	SpanCache      cache.SpanCache             `inject:""`
 	Gossip         gossip.Gossiper             `inject:"gossip"`
+	DecisionHook   *webhook.DecisionDispatcher `inject:""`
+
+	// ShadowTransmission sends copies of a fraction of kept traces to the
+	// shadow upstream; it's only used if one is configured
+	ShadowTransmission transmit.Transmission `inject:"shadowTransmission"`
 
 	// whenever samplersByDestination is accessed, it should be protected by
 	// the mut mutex
 	mut                   sync.RWMutex
 	samplersByDestination map[string]sample.Sampler
 
+	// droppedSamples holds dropped traces retained for debugging; it's nil
+	// unless DroppedSampleRetention is set
+	droppedSamples *droppedSamples
+
+	traceSubscribers traceSubscribers
+
+	// shadowQueue holds copies of kept spans waiting to be sent to the
+	// shadow upstream; it's nil unless one is configured
+	shadowQueue chan *types.Span
+	shadowDone  chan struct{}
+	shadowWG    sync.WaitGroup
+
+	// debugSinkQueue holds kept traces waiting to be written to the debug
+	// trace sink; it's nil unless DebugTraceSinkEnabled is set
+	debugSinkQueue chan *debugSinkTrace
+	debugSinkDone  chan struct{}
+	debugSinkWG    sync.WaitGroup
+	debugSinkOut   io.Writer
+
 	incoming chan *types.Span
 	reload   chan struct{}
 
 	done         chan struct{}
+	drainOnce    sync.Once
 	eg           *errgroup.Group
 	senderCycle  *Cycle
 	deciderCycle *Cycle
@@ -138,6 +254,9 @@ func (c *CentralCollector) Start() error {
 	c.incoming = make(chan *types.Span, collectorCfg.GetIncomingQueueSize())
 	c.reload = make(chan struct{}, 1)
 	c.samplersByDestination = make(map[string]sample.Sampler)
+	if size := c.Config.GetDroppedSampleRetention(); size > 0 {
+		c.droppedSamples = newDroppedSamples(size)
+	}
 
 	// The cycles manage a periodic task and also provide some test hooks
 	c.metricsCycle = NewCycle(c.Clock, c.Config.GetSendTickerValue(), c.done)
@@ -154,12 +273,27 @@ func (c *CentralCollector) Start() error {
 	c.Metrics.Register("trace_send_kept_sample_rate", "histogram")
 	c.Metrics.Register("trace_duration_ms", "histogram")
 	c.Metrics.Register("trace_span_count", "histogram")
+	c.Metrics.Register("collector_span_limit_dropped", "counter")
+	c.Metrics.Register("collector_intra_trace_dropped", "counter")
+	c.Metrics.Register("collector_incoming_queue_wait_ms", "histogram")
+	c.Metrics.Register("stale_span_dropped", "counter")
 	c.Metrics.Register("trace_decision_kept", "counter")
 	c.Metrics.Register("trace_decision_dropped", "counter")
+	c.Metrics.Register("trace_sample_rate_clamped", "counter")
+	c.Metrics.Register("collector_dropped_sample_retained", "counter")
+	c.Metrics.Register("collector_trace_notifications_dropped", "counter")
+	c.Metrics.Register("collector_shadowed", "counter")
+	c.Metrics.Register("collector_shadow_dropped", "counter")
+	c.Metrics.Register("collector_debug_sink_written", "counter")
+	c.Metrics.Register("collector_debug_sink_dropped", "counter")
+	c.Metrics.Register("trace_decision_force_kept", "counter")
+	c.Metrics.Register("trace_decision_error_kept", "counter")
+	c.Metrics.Register("trace_decision_synthetic", "counter")
 	c.Metrics.Register("trace_decision_has_root", "counter")
 	c.Metrics.Register("trace_decision_no_root", "counter")
 	c.Metrics.Register("collector_incoming_queue", "histogram")
 	c.Metrics.Register("collector_incoming_queue_length", "gauge")
+	c.Metrics.Register("collector_incoming_queue_capacity", "gauge")
 	c.Metrics.Register("collector_cache_size", "gauge")
 	c.Metrics.Register("memory_heap_allocation", "gauge")
 	c.Metrics.Register("span_received", "counter")
@@ -170,6 +304,9 @@ func (c *CentralCollector) Start() error {
 	c.Metrics.Register("collector_keep_trace", "counter")
 	c.Metrics.Register("collector_drop_trace", "counter")
 	c.Metrics.Register("collector_drop_old_trace", "counter")
+	c.Metrics.Register("collector_max_trace_age_sent", "counter")
+	c.Metrics.Register("collector_max_trace_age_dropped", "counter")
+	c.Metrics.Register("collector_oldest_trace_age_ms", "gauge")
 	c.Metrics.Register("collector_decide_trace", "counter")
 	c.Metrics.Register("decider_decided_per_second", "histogram")
 	c.Metrics.Register("decider_considered_per_second", "histogram")
@@ -187,6 +324,9 @@ func (c *CentralCollector) Start() error {
 	}
 	c.Metrics.Store("INCOMING_CAP", float64(cap(c.incoming)))
 
+	c.startShadow()
+	c.startDebugSink()
+
 	// spin up one collector because this is a single threaded collector
 	c.eg = &errgroup.Group{}
 	c.eg.Go(c.receive)
@@ -265,6 +405,9 @@ func (c *CentralCollector) Stop() error {
 	if err := c.shutdown(ctx); err != nil {
 		c.Logger.Error().Logf("error shutting down collector: %s", err)
 	}
+	c.stopShadow()
+	c.stopDebugSink()
+	c.closeTraceSubscribers()
 
 	return nil
 }
@@ -333,11 +476,12 @@ func (c *CentralCollector) shutdown(ctx context.Context) error {
 		for _, sp := range trace.GetSpans() {
 			// send the spans to the central store
 			cs := &centralstore.CentralSpan{
-				TraceID:   id,
-				SpanID:    sp.ID,
-				Type:      sp.Type(),
-				AllFields: sp.Data,
-				IsRoot:    sp.IsRoot,
+				TraceID:        id,
+				SpanID:         sp.ID,
+				Type:           sp.Type(),
+				AllFields:      sp.Data,
+				IsRoot:         sp.IsRoot,
+				CompletesTrace: sp.TraceComplete,
 			}
 
 			cs.SetSamplerSelector(trace.GetSamplerSelector(c.Config.GetDatasetPrefix()))
@@ -367,7 +511,8 @@ func (c *CentralCollector) shutdown(ctx context.Context) error {
 
 // ProcessSpanImmediately determines if this trace should be part of the deterministic sample.
 // If it's part of the deterministic sample, the decision is written to the central store and
-// the span is enqueued for transmission.
+// the span is enqueued for transmission. Whether it's kept or not, the span's SampleRate is
+// then the rate that was applied to it.
 func (c *CentralCollector) ProcessSpanImmediately(sp *types.Span) (bool, error) {
 	_, span := otelutil.StartSpanWith(context.Background(), c.Tracer, "CentralCollector.ProcessSpanImmediately", "trace_id", sp.TraceID)
 
@@ -384,7 +529,17 @@ func (c *CentralCollector) ProcessSpanImmediately(sp *types.Span) (bool, error)
 
 	record, reason, found := c.DecisionCache.Check(sp)
 	if !found {
-		rate, keep, reason = c.StressRelief.GetSampleRate(sp.TraceID)
+		if sp.Synthetic {
+			rate, keep, reason = 1, true, syntheticReason
+		} else if sp.ForceKeep {
+			rate, keep, reason = 1, true, forceKeepReason
+		} else if sp.Error {
+			rate, keep, reason = 1, true, errorReason
+			c.Metrics.Increment("trace_decision_error_kept")
+		} else {
+			rate, keep, reason = c.StressRelief.GetSampleRate(sp.TraceID)
+			rate, keep = c.clampSampleRate(sp.TraceID, rate, keep, reason)
+		}
 	} else {
 		c.Metrics.Increment("collector_span_decision_cache_hit")
 		rate = record.Rate()
@@ -406,7 +561,26 @@ func (c *CentralCollector) ProcessSpanImmediately(sp *types.Span) (bool, error)
 	}
 
 	c.DecisionCache.Record(status, keep, reason)
+	if !found {
+		c.DecisionCache.Retain(sp.TraceID, keep, c.Config.GetSampleCacheConfig().GetDecisionTTL(sp.Dataset))
+	}
 
+	// report decisions made by stress relief too, but only the first time,
+	// rather than once for every span of the trace
+	if !found && c.DecisionHook.Enabled() {
+		c.DecisionHook.Enqueue(webhook.DecisionRecord{
+			TraceID:    sp.TraceID,
+			Dataset:    sp.Dataset,
+			Kept:       keep,
+			SampleRate: rate,
+			Reason:     reason,
+			Timestamp:  c.Clock.Now(),
+		})
+	}
+
+	// set the rate even if the span is dropped, so that the caller can see
+	// what was applied to it
+	mergeTraceAndSpanSampleRates(sp, rate)
 	if !keep {
 		c.Metrics.Increment("dropped_from_stress")
 		return true, nil
@@ -420,8 +594,12 @@ func (c *CentralCollector) ProcessSpanImmediately(sp *types.Span) (bool, error)
 	if c.hostname != "" {
 		sp.Data["meta.refinery.host.name"] = c.hostname
 	}
+	// the rest of the trace isn't here to agree with, so the span goes where
+	// its own field says
+	if sp.RoutedDataset != "" {
+		sp.Dataset = sp.RoutedDataset
+	}
 	c.addAdditionalAttributes(sp)
-	mergeTraceAndSpanSampleRates(sp, rate)
 	c.Transmission.EnqueueSpan(sp)
 	return true, nil
 
@@ -429,16 +607,68 @@ func (c *CentralCollector) ProcessSpanImmediately(sp *types.Span) (bool, error)
 
 // implement the Collector interface
 func (c *CentralCollector) AddSpan(span *types.Span) error {
+	if max := c.Config.GetMaxSpansPerTrace(); max > 0 && c.SpanCache.DescendantCount(span.TraceID) >= uint32(max) {
+		// spans still in the incoming queue aren't counted yet, so a trace can
+		// go a little over the limit, but not by much
+		if trace := c.SpanCache.Get(span.TraceID); trace != nil {
+			trace.MarkSpanLimitHit()
+		}
+		c.Metrics.Increment("collector_span_limit_dropped")
+		return ErrTraceSpanLimit
+	}
+	if !c.sampleIntraTrace(span) {
+		c.Metrics.Increment("collector_intra_trace_dropped")
+		return nil
+	}
+
+	span.EnqueuedAt = c.Clock.Now()
 	select {
 	case c.incoming <- span:
 		c.Metrics.Increment("span_received")
 		c.Metrics.Up("spans_waiting")
 		return nil
 	default:
+		// the receive loop may be too busy to update these, and a full queue
+		// is exactly when they matter
+		c.recordIncomingQueue()
 		return ErrWouldBlock
 	}
 }
 
+// sampleIntraTrace reports whether to keep span, given the size of its trace.
+// Once a trace has IntraTraceSamplingThreshold spans, only 1 in
+// IntraTraceSampleRate of its further spans are kept, apart from its root and
+// error spans, which decide whether the trace is kept at all.
+func (c *CentralCollector) sampleIntraTrace(span *types.Span) bool {
+	threshold := c.Config.GetIntraTraceSamplingThreshold()
+	if threshold <= 0 || span.IsRoot || span.Error || c.SpanCache.DescendantCount(span.TraceID) < uint32(threshold) {
+		return true
+	}
+	trace := c.SpanCache.Get(span.TraceID)
+	if trace == nil {
+		return true
+	}
+	return trace.SampleIntraTrace(uint32(c.Config.GetIntraTraceSampleRate()))
+}
+
+// isStale reports whether sp waited in the incoming queue for longer than
+// MaxIncomingQueueWait, and so is too late to be worth processing.
+func (c *CentralCollector) isStale(sp *types.Span) bool {
+	if sp.EnqueuedAt.IsZero() {
+		return false
+	}
+	wait := c.Clock.Since(sp.EnqueuedAt)
+	c.Metrics.Histogram("collector_incoming_queue_wait_ms", float64(wait.Milliseconds()))
+	max := c.Config.GetMaxIncomingQueueWait()
+	return max > 0 && wait > max
+}
+
+// recordIncomingQueue reports how full the incoming span queue is.
+func (c *CentralCollector) recordIncomingQueue() {
+	c.Metrics.Gauge("collector_incoming_queue_length", float64(len(c.incoming)))
+	c.Metrics.Gauge("collector_incoming_queue_capacity", float64(cap(c.incoming)))
+}
+
 func (c *CentralCollector) receive() error {
 	tickerDuration := time.Duration(c.Config.GetCollectionConfig().MemoryCycleDuration)
 	if tickerDuration <= 0 {
@@ -454,7 +684,7 @@ func (c *CentralCollector) receive() error {
 	for {
 		// record channel lengths as histogram but also as gauges
 		c.Metrics.Histogram("collector_incoming_queue", float64(len(c.incoming)))
-		c.Metrics.Gauge("collector_incoming_queue_length", float64(len(c.incoming)))
+		c.recordIncomingQueue()
 		c.Metrics.Increment("collector_receiver_runs")
 		c.Health.Ready(receiverHealth, true)
 
@@ -473,6 +703,11 @@ func (c *CentralCollector) receive() error {
 			if !ok {
 				return nil
 			}
+			if c.isStale(sp) {
+				c.Metrics.Increment("stale_span_dropped")
+				c.Metrics.Down("spans_waiting")
+				continue
+			}
 			_, span := otelutil.StartSpanMulti(context.Background(), c.Tracer, "CentralCollector.receive",
 				map[string]interface{}{
 					"incoming_queue_length": len(c.incoming),
@@ -506,6 +741,7 @@ func (c *CentralCollector) send() error {
 				return err
 			}
 		}
+		c.sweepOverAgeTraces()
 		c.Health.Ready(senderHealth, true)
 		c.Metrics.Increment("collector_sender_runs")
 
@@ -534,7 +770,8 @@ func (c *CentralCollector) sendTraces(ctx context.Context) error {
 	// if a trace is dropped, we don't want to send it to the central store
 	ids = slices.DeleteFunc(ids, func(id string) bool {
 		if c.DecisionCache.Dropped(id) {
-			c.SpanCache.Remove(id)
+			c.retainDropped(id)
+			c.evictTrace(id, false)
 			tracesConsidered++
 			c.Metrics.Increment("collector_drop_trace")
 
@@ -553,12 +790,13 @@ func (c *CentralCollector) sendTraces(ctx context.Context) error {
 		switch status.State {
 		case centralstore.DecisionKeep:
 			c.sendSpans(status)
-			c.SpanCache.Remove(status.TraceID)
+			c.evictTrace(status.TraceID, true)
 			tracesConsidered++
 			c.Metrics.Increment("collector_keep_trace")
 
 		case centralstore.DecisionDrop:
-			c.SpanCache.Remove(status.TraceID)
+			c.retainDropped(status.TraceID)
+			c.evictTrace(status.TraceID, false)
 			tracesConsidered++
 			c.Metrics.Increment("collector_drop_trace")
 		default:
@@ -674,7 +912,7 @@ func (c *CentralCollector) keepTraces(ids []string) []string {
 	idset := generics.NewSet(ids...)
 	for _, status := range statuses {
 		c.sendSpans(status)
-		c.SpanCache.Remove(status.TraceID)
+		c.evictTrace(status.TraceID, true)
 		idset.Remove(status.TraceID)
 		c.Metrics.Increment("collector_keep_trace")
 	}
@@ -687,7 +925,7 @@ func (c *CentralCollector) dropTraces(ids []string) []string {
 	_, span := otelutil.StartSpanWith(context.Background(), c.Tracer, "CentralCollector.dropTraces", "num_ids", len(ids))
 	defer span.End()
 	for _, traceID := range ids {
-		c.SpanCache.Remove(traceID)
+		c.evictTrace(traceID, false)
 		c.Metrics.Increment("collector_drop_trace")
 	}
 	return nil
@@ -699,6 +937,7 @@ func (c *CentralCollector) dropTraces(ids []string) []string {
 func (c *CentralCollector) cleanup() error {
 	return c.cleanupCycle.Run(context.Background(), func(ctx context.Context) error {
 		c.cleanupTraces(ctx)
+		c.sweepOverAgeTraces()
 		c.Metrics.Increment("collector_cleanup_runs")
 		return nil
 	})
@@ -728,7 +967,8 @@ func (c *CentralCollector) cleanupTraces(ctx context.Context) {
 	// if a trace is dropped, we don't want to send it to the central store
 	ids = slices.DeleteFunc(ids, func(id string) bool {
 		if c.DecisionCache.Dropped(id) {
-			c.SpanCache.Remove(id)
+			c.retainDropped(id)
+			c.evictTrace(id, false)
 			tracesConsidered++
 			c.Metrics.Increment("collector_drop_trace")
 
@@ -748,12 +988,13 @@ func (c *CentralCollector) cleanupTraces(ctx context.Context) {
 		switch status.State {
 		case centralstore.DecisionKeep:
 			c.sendSpans(status)
-			c.SpanCache.Remove(status.TraceID)
+			c.evictTrace(status.TraceID, true)
 			tracesConsidered++
 			c.Metrics.Increment("collector_keep_trace")
 
 		case centralstore.DecisionDrop:
-			c.SpanCache.Remove(status.TraceID)
+			c.retainDropped(status.TraceID)
+			c.evictTrace(status.TraceID, false)
 			tracesConsidered++
 			c.Metrics.Increment("collector_drop_trace")
 
@@ -761,7 +1002,7 @@ func (c *CentralCollector) cleanupTraces(ctx context.Context) {
 			// if we didn't find the trace, but it's already
 			// this old, we need to drop it so it doesn't live forever.
 			// but let's record that we did.
-			c.SpanCache.Remove(status.TraceID)
+			c.evictTrace(status.TraceID, false)
 			tracesConsidered++
 			c.Logger.Info().WithField("trace_id", status.TraceID).Logf("dropping old trace")
 			c.Metrics.Increment("collector_drop_old_trace")
@@ -770,6 +1011,50 @@ func (c *CentralCollector) cleanupTraces(ctx context.Context) {
 	}
 }
 
+// sweepOverAgeTraces removes traces that have been in the cache for longer
+// than MaxTraceAge, whether or not a decision has been made about them, so
+// that a trace that never gets a decision can't be held forever. Depending on
+// MaxTraceAgeAction, their spans are either dropped or sent as they are.
+func (c *CentralCollector) sweepOverAgeTraces() {
+	maxAge := c.Config.GetMaxTraceAge()
+	ids, oldest := c.SpanCache.GetTraceIDsOlderThan(maxAge)
+	c.Metrics.Gauge("collector_oldest_trace_age_ms", float64(oldest.Milliseconds()))
+	if maxAge == 0 || len(ids) == 0 {
+		return
+	}
+
+	send := c.Config.GetMaxTraceAgeAction() == "send"
+	var swept int
+	for _, id := range ids {
+		trace := c.SpanCache.Get(id)
+		if trace == nil || !trace.TryMarkTraceForSending() {
+			continue
+		}
+		if send {
+			for _, sp := range trace.GetSpans() {
+				if sp.Data == nil {
+					sp.Data = make(map[string]interface{})
+				}
+				if c.Config.GetAddRuleReasonToTrace() {
+					sp.Data["meta.refinery.send_reason"] = TraceSendMaxAge
+				}
+				mergeTraceAndSpanSampleRates(sp, 1)
+				c.addAdditionalAttributes(sp)
+				c.Transmission.EnqueueSpan(sp)
+			}
+			c.Metrics.Increment("collector_max_trace_age_sent")
+		} else {
+			c.retainDropped(id)
+			c.Metrics.Increment("collector_max_trace_age_dropped")
+		}
+		c.evictTrace(id, send)
+		swept++
+	}
+	if swept > 0 {
+		c.Logger.Warn().WithField("count", swept).WithString("action", c.Config.GetMaxTraceAgeAction()).Logf("removed traces older than MaxTraceAge")
+	}
+}
+
 func (c *CentralCollector) decide() error {
 	return c.deciderCycle.Run(context.Background(), func(ctx context.Context) error {
 		err := c.makeDecisions(ctx)
@@ -859,6 +1144,13 @@ func (c *CentralCollector) makeDecisions(ctx context.Context) error {
 
 	keptIDs := make([]string, 0)
 	droppedIDs := make([]string, 0)
+	// only gather decision records if someone is listening for them
+	reportDecisions := c.DecisionHook.Enabled()
+	sampleCacheCfg := c.Config.GetSampleCacheConfig()
+	var decisionRecords []webhook.DecisionRecord
+	if reportDecisions {
+		decisionRecords = make([]webhook.DecisionRecord, 0, len(traces))
+	}
 
 	for _, trace := range traces {
 		if trace == nil {
@@ -907,7 +1199,29 @@ func (c *CentralCollector) makeDecisions(ctx context.Context) error {
 		}
 
 		// make sampling decision and update the trace
-		rate, shouldSend, reason, key := sampler.GetSampleRate(tr)
+		var rate uint
+		var shouldSend bool
+		var reason, key string
+		if hasMarkedSpan(trace, syntheticKeyField) {
+			rate, shouldSend, reason = 1, true, syntheticReason
+			c.Metrics.Increment("trace_decision_synthetic")
+		} else if hasMarkedSpan(trace, forceKeepKeyField) {
+			rate, shouldSend, reason = 1, true, forceKeepReason
+			c.Metrics.Increment("trace_decision_force_kept")
+		} else if hasMarkedSpan(trace, errorKeyField) {
+			rate, shouldSend, reason = 1, true, errorReason
+			c.Metrics.Increment("trace_decision_error_kept")
+		} else {
+			rate, shouldSend, reason, key = sampler.GetSampleRate(tr)
+			rate, shouldSend = c.clampSampleRate(trace.TraceID, rate, shouldSend, reason)
+		}
+		if hasMarkedSpan(trace, dryRunKeyField) {
+			// record what would have happened, and send the trace at its
+			// incoming sample rate
+			status.Metadata[config.DryRunFieldName] = shouldSend
+			status.Metadata[dryRunSampleRateField] = rate
+			rate, shouldSend = 1, true
+		}
 		otelutil.AddSpanFields(span, map[string]interface{}{
 			"trace_id": trace.TraceID,
 			"rate":     rate,
@@ -961,6 +1275,26 @@ func (c *CentralCollector) makeDecisions(ctx context.Context) error {
 
 		c.DecisionCache.Record(status, shouldSend, reason)
 
+		// the dataset is only known if this node has some of the trace's
+		// spans; otherwise the default decision TTL applies
+		var dataset string
+		if localTrace := c.SpanCache.Get(trace.TraceID); localTrace != nil {
+			dataset = localTrace.Dataset
+		}
+		c.DecisionCache.Retain(trace.TraceID, shouldSend, sampleCacheCfg.GetDecisionTTL(dataset))
+
+		if reportDecisions {
+			decisionRecords = append(decisionRecords, webhook.DecisionRecord{
+				TraceID:         trace.TraceID,
+				Dataset:         dataset,
+				SamplerSelector: selector,
+				Kept:            shouldSend,
+				SampleRate:      rate,
+				Reason:          reason,
+				Timestamp:       now,
+			})
+		}
+
 		stateMap[status.TraceID] = status
 		c.Metrics.Increment("collector_decide_trace")
 		span.End()
@@ -978,6 +1312,11 @@ func (c *CentralCollector) makeDecisions(ctx context.Context) error {
 		return err
 	}
 
+	// the decisions are final now that they're in the store
+	for _, rec := range decisionRecords {
+		c.DecisionHook.Enqueue(rec)
+	}
+
 	gossip_keep_channel := c.Gossip.GetChannel(gossip.ChannelKeep)
 	gossip_drop_channel := c.Gossip.GetChannel(gossip.ChannelDrop)
 	if len(keptIDs) > 0 {
@@ -1031,10 +1370,11 @@ func (c *CentralCollector) processSpan(sp *types.Span) error {
 
 	// construct a central store span
 	cs := &centralstore.CentralSpan{
-		TraceID:   sp.TraceID,
-		SpanID:    sp.ID,
-		KeyFields: make(map[string]interface{}),
-		IsRoot:    sp.IsRoot,
+		TraceID:        sp.TraceID,
+		SpanID:         sp.ID,
+		KeyFields:      make(map[string]interface{}),
+		IsRoot:         sp.IsRoot,
+		CompletesTrace: sp.TraceComplete,
 	}
 	cs.Type = sp.Type()
 
@@ -1054,13 +1394,27 @@ func (c *CentralCollector) processSpan(sp *types.Span) error {
 		c.mut.Unlock()
 	}
 
-	// extract all key fields from the span
-	keyFields := sampler.GetKeyFields()
-	for _, keyField := range keyFields {
-		if val, ok := sp.Data[keyField]; ok {
-			cs.KeyFields[keyField] = val
+	// extract all key fields from the span; a synthetic trace never reaches
+	// the sampler, so its spans don't need them
+	if sp.Synthetic {
+		cs.KeyFields[syntheticKeyField] = true
+	} else {
+		keyFields := sampler.GetKeyFields()
+		for _, keyField := range keyFields {
+			if val, ok := sp.SamplerKeyValue(keyField); ok {
+				cs.KeyFields[keyField] = val
+			}
 		}
 	}
+	if sp.ForceKeep {
+		cs.KeyFields[forceKeepKeyField] = true
+	}
+	if sp.Error {
+		cs.KeyFields[errorKeyField] = true
+	}
+	if c.Config.GetIsDryRunForEnvironment(sp.Environment) {
+		cs.KeyFields[dryRunKeyField] = true
+	}
 
 	// send the span to the central store
 	ctx := context.Background()
@@ -1166,6 +1520,9 @@ func (c *CentralCollector) sendSpans(status *centralstore.CentralTraceStatus) {
 
 	c.Logger.Info().WithFields(logFields).Logf("Sending trace")
 
+	c.addRootEnrichment(trace)
+	routedDataset := traceRoutedDataset(trace)
+	debugTrace := c.newDebugSinkTrace(trace, status.Rate, status.KeepReason)
 	for _, sp := range trace.GetSpans() {
 		if sp.Data == nil {
 			sp.Data = make(map[string]interface{})
@@ -1201,6 +1558,12 @@ func (c *CentralCollector) sendSpans(status *centralstore.CentralTraceStatus) {
 		sp.Data["meta.span_link_count"] = int(status.SpanLinkCount())
 		sp.Data["meta.span_count"] = int(status.SpanCount())
 		sp.Data["meta.event_count"] = int(status.DescendantCount())
+		if trace.SpanLimitHit() {
+			sp.Data[spanLimitHitField] = true
+		}
+		if dropped := trace.IntraTraceDropped(); dropped > 0 && sp == trace.RootSpan {
+			sp.Data[intraTraceDroppedField] = int(dropped)
+		}
 		for k, v := range status.Metadata {
 			if k == "meta.refinery.decider.host.name" && !c.Config.GetAddHostMetadataToTrace() {
 				continue
@@ -1214,6 +1577,9 @@ func (c *CentralCollector) sendSpans(status *centralstore.CentralTraceStatus) {
 		if c.hostname != "" && c.Config.GetAddHostMetadataToTrace() {
 			sp.Data["meta.refinery.sender.host.name"] = c.hostname
 		}
+		if routedDataset != "" {
+			sp.Dataset = routedDataset
+		}
 
 		// if the trace doesn't have a sample rate and is kept, it
 		traceSampleRate := status.SampleRate()
@@ -1223,16 +1589,85 @@ func (c *CentralCollector) sendSpans(status *centralstore.CentralTraceStatus) {
 
 		mergeTraceAndSpanSampleRates(sp, traceSampleRate)
 		c.addAdditionalAttributes(sp)
+		c.shadowSpan(sp)
+		debugTrace.addSpan(sp)
 		c.Transmission.EnqueueSpan(sp)
 	}
+	c.sinkTrace(debugTrace)
+}
+
+// traceRoutedDataset returns the dataset that DatasetRoutingField sends the
+// trace's spans to, so that they all go to the same one: the root span's, or
+// if the root doesn't have the field, the first span's that does. It's ""
+// if none of them has the field.
+func traceRoutedDataset(trace *types.Trace) string {
+	if trace.RootSpan != nil && trace.RootSpan.RoutedDataset != "" {
+		return trace.RootSpan.RoutedDataset
+	}
+	for _, sp := range trace.GetSpans() {
+		if sp.RoutedDataset != "" {
+			return sp.RoutedDataset
+		}
+	}
+	return ""
 }
 
 func (c *CentralCollector) addAdditionalAttributes(sp *types.Span) {
-	for k, v := range c.Config.GetAdditionalAttributes() {
+	for k, v := range c.Config.GetAdditionalAttributesForEnvironment(sp.Environment) {
 		sp.Data[k] = v
 	}
 }
 
+// serviceNameFields are the fields that can name a span's service.
+var serviceNameFields = []string{"service.name", "service_name"}
+
+// addRootEnrichment adds the trace's wall-clock duration and the number of
+// distinct services in it to the root span, if they're configured.
+func (c *CentralCollector) addRootEnrichment(trace *types.Trace) {
+	root := trace.RootSpan
+	if root == nil {
+		return
+	}
+	addDuration := c.Config.GetAddTraceDurationToRoot()
+	addServiceCount := c.Config.GetAddServiceCountToRoot()
+	if !addDuration && !addServiceCount {
+		return
+	}
+	if root.Data == nil {
+		root.Data = make(map[string]interface{})
+	}
+
+	var start, end time.Time
+	services := make(map[string]struct{})
+	for _, sp := range trace.GetSpans() {
+		if !sp.Timestamp.IsZero() {
+			if start.IsZero() || sp.Timestamp.Before(start) {
+				start = sp.Timestamp
+			}
+			spanEnd := sp.Timestamp
+			if d, ok := sp.Data["duration_ms"]; ok {
+				spanEnd = spanEnd.Add(time.Duration(metrics.ConvertNumeric(d) * float64(time.Millisecond)))
+			}
+			if spanEnd.After(end) {
+				end = spanEnd
+			}
+		}
+		for _, field := range serviceNameFields {
+			if name, ok := sp.Data[field].(string); ok && name != "" {
+				services[name] = struct{}{}
+				break
+			}
+		}
+	}
+
+	if addDuration && !start.IsZero() {
+		root.Data["meta.refinery.trace_duration_ms"] = float64(end.Sub(start)) / float64(time.Millisecond)
+	}
+	if addServiceCount {
+		root.Data["meta.refinery.service_count"] = len(services)
+	}
+}
+
 func (c *CentralCollector) reloadConfig() {
 	c.Logger.Debug().Logf("reloading central collector config")
 
@@ -1251,6 +1686,63 @@ func (c *CentralCollector) Stressed() bool {
 	return c.StressRelief.Stressed()
 }
 
+func (c *CentralCollector) Load() Load {
+	return Load{
+		IncomingQueueLength:   len(c.incoming),
+		IncomingQueueCapacity: cap(c.incoming),
+		StressLevel:           c.StressRelief.StressLevel(),
+		Stressed:              c.StressRelief.Stressed(),
+	}
+}
+
+func (c *CentralCollector) RemainingTraceCount() int {
+	return c.SpanCache.Len() + len(c.incoming)
+}
+
+// Drain starts a background loop that runs the sender cycle once a second,
+// the same way shutdown does, until every buffered trace has been sent or
+// dropped. Runs go through the sender cycle so that they're never concurrent
+// with its regular runs. When decisions are gossiped, traces are already sent
+// as soon as their decision arrives, so there's nothing to speed up.
+func (c *CentralCollector) Drain() {
+	c.drainOnce.Do(func() {
+		c.Logger.Info().WithField("remaining_traces", c.RemainingTraceCount()).Logf("draining buffered traces")
+		if c.Config.GetCollectionConfig().UseDecisionGossip {
+			return
+		}
+		go func() {
+			ticker := c.Clock.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.done:
+					return
+				case <-ticker.Chan():
+					c.senderCycle.RunOnce()
+					if c.RemainingTraceCount() == 0 {
+						c.Logger.Info().Logf("finished draining buffered traces")
+						return
+					}
+				}
+			}
+		}()
+	})
+}
+
+func (c *CentralCollector) GetSamplerKeys(selector string) ([]sample.SamplerKey, bool) {
+	c.mut.RLock()
+	sampler, found := c.samplersByDestination[selector]
+	c.mut.RUnlock()
+	if !found {
+		return nil, false
+	}
+	reporter, ok := sampler.(sample.KeyReporter)
+	if !ok {
+		return nil, false
+	}
+	return reporter.SamplerKeys(), true
+}
+
 func mergeTraceAndSpanSampleRates(sp *types.Span, traceSampleRate uint) {
 	tempSampleRate := sp.SampleRate
 	if sp.SampleRate != 0 {
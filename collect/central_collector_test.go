@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"runtime"
 	"slices"
 	"strconv"
@@ -119,6 +120,28 @@ func TestCentralCollector_AddSpan(t *testing.T) {
 	}
 }
 
+func TestCentralCollector_IncomingQueueGauges(t *testing.T) {
+	m := &metrics.MockMetrics{}
+	m.Start()
+	coll := &CentralCollector{
+		Config:   &config.MockConfig{},
+		Metrics:  m,
+		Clock:    clockwork.NewFakeClock(),
+		incoming: make(chan *types.Span, 2),
+	}
+
+	require.NoError(t, coll.AddSpan(&types.Span{TraceID: "trace"}))
+	require.NoError(t, coll.AddSpan(&types.Span{TraceID: "trace"}))
+	require.ErrorIs(t, coll.AddSpan(&types.Span{TraceID: "trace"}), ErrWouldBlock)
+
+	length, ok := m.Get("collector_incoming_queue_length")
+	require.True(t, ok)
+	assert.Equal(t, float64(2), length)
+	capacity, ok := m.Get("collector_incoming_queue_capacity")
+	require.True(t, ok)
+	assert.Equal(t, float64(2), capacity)
+}
+
 func TestCentralCollector_ProcessTraces(t *testing.T) {
 	for _, storeType := range storeTypes {
 		t.Run(storeType, func(t *testing.T) {
@@ -1023,6 +1046,93 @@ func TestCentralCollector_AddAdditionalAttributes(t *testing.T) {
 	}
 }
 
+func TestCentralCollector_AddAdditionalAttributesForEnvironment(t *testing.T) {
+	coll := &CentralCollector{
+		Config: &config.MockConfig{
+			AdditionalAttributes: map[string]string{
+				"cluster": "main",
+				"region":  "unknown",
+			},
+			AdditionalAttributesByEnvironment: map[string]map[string]string{
+				"production": {"region": "us-east-1"},
+			},
+		},
+	}
+
+	sp := &types.Span{Event: types.Event{Environment: "production", Data: map[string]interface{}{}}}
+	coll.addAdditionalAttributes(sp)
+	assert.Equal(t, "main", sp.Data["cluster"])
+	assert.Equal(t, "us-east-1", sp.Data["region"], "environment attributes should override global ones")
+
+	sp = &types.Span{Event: types.Event{Environment: "staging", Data: map[string]interface{}{}}}
+	coll.addAdditionalAttributes(sp)
+	assert.Equal(t, "unknown", sp.Data["region"])
+}
+
+func TestTraceRoutedDataset(t *testing.T) {
+	newTrace := func(root string, others ...string) *types.Trace {
+		trace := &types.Trace{}
+		for _, dataset := range others {
+			trace.AddSpan(&types.Span{Event: types.Event{Data: map[string]interface{}{}}, RoutedDataset: dataset})
+		}
+		rootSpan := &types.Span{Event: types.Event{Data: map[string]interface{}{}}, IsRoot: true, RoutedDataset: root}
+		trace.RootSpan = rootSpan
+		trace.AddSpan(rootSpan)
+		return trace
+	}
+
+	assert.Equal(t, "root", traceRoutedDataset(newTrace("root", "first", "second")))
+	assert.Equal(t, "first", traceRoutedDataset(newTrace("", "", "first", "second")))
+	assert.Equal(t, "", traceRoutedDataset(newTrace("", "", "")))
+
+	noRoot := &types.Trace{}
+	noRoot.AddSpan(&types.Span{Event: types.Event{Data: map[string]interface{}{}}})
+	noRoot.AddSpan(&types.Span{Event: types.Event{Data: map[string]interface{}{}}, RoutedDataset: "second"})
+	assert.Equal(t, "second", traceRoutedDataset(noRoot))
+}
+
+func TestCentralCollector_AddRootEnrichment(t *testing.T) {
+	start := time.Now()
+	newTrace := func() *types.Trace {
+		trace := &types.Trace{}
+		trace.AddSpan(&types.Span{Event: types.Event{
+			Timestamp: start.Add(5 * time.Millisecond),
+			Data:      map[string]interface{}{"service.name": "api", "duration_ms": 100},
+		}})
+		trace.AddSpan(&types.Span{Event: types.Event{
+			Timestamp: start.Add(20 * time.Millisecond),
+			Data:      map[string]interface{}{"service_name": "db", "duration_ms": 200.5},
+		}})
+		root := &types.Span{IsRoot: true, Event: types.Event{
+			Timestamp: start,
+			Data:      map[string]interface{}{"service.name": "api", "duration_ms": 50},
+		}}
+		trace.AddSpan(root)
+		trace.RootSpan = root
+		return trace
+	}
+
+	coll := &CentralCollector{Config: &config.MockConfig{
+		AddTraceDurationToRoot: true,
+		AddServiceCountToRoot:  true,
+	}}
+	trace := newTrace()
+	coll.addRootEnrichment(trace)
+	assert.InDelta(t, 220.5, trace.RootSpan.Data["meta.refinery.trace_duration_ms"], 0.001, "duration should run from the earliest start to the latest end")
+	assert.Equal(t, 2, trace.RootSpan.Data["meta.refinery.service_count"])
+	for _, sp := range trace.GetSpans() {
+		if !sp.IsRoot {
+			assert.NotContains(t, sp.Data, "meta.refinery.trace_duration_ms", "only the root span is enriched")
+		}
+	}
+
+	coll = &CentralCollector{Config: &config.MockConfig{}}
+	trace = newTrace()
+	coll.addRootEnrichment(trace)
+	assert.NotContains(t, trace.RootSpan.Data, "meta.refinery.trace_duration_ms")
+	assert.NotContains(t, trace.RootSpan.Data, "meta.refinery.service_count")
+}
+
 func TestCentralCollector_SpanWithRuleReasons(t *testing.T) {
 	for _, storeType := range storeTypes {
 		t.Run(storeType, func(t *testing.T) {
@@ -1324,6 +1434,718 @@ func TestCentralCollector_ProcessSpanImmediately(t *testing.T) {
 	}
 }
 
+func TestCentralCollector_IncomingSampleRateSurvivesSampling(t *testing.T) {
+	// a span that an earlier Refinery has already sampled at 4, having
+	// received it at 5
+	sp := &types.Span{Event: types.Event{
+		SampleRate: 20,
+		Data:       map[string]interface{}{"meta.refinery.incoming_sample_rate": uint(5)},
+	}}
+	mergeTraceAndSpanSampleRates(sp, 10)
+	assert.Equal(t, uint(200), sp.SampleRate)
+	assert.Equal(t, uint(20), sp.Data["meta.refinery.original_sample_rate"])
+	assert.Equal(t, uint(5), sp.Data["meta.refinery.incoming_sample_rate"])
+}
+
+func TestCentralCollector_MaxEffectiveSampleRate(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			// a ceiling of 1 keeps everything, so the outcome of clamping
+			// doesn't depend on the trace IDs chosen
+			conf := &config.MockConfig{
+				GetSendDelayVal:    0,
+				GetTraceTimeoutVal: 60 * time.Second,
+				GetSamplerTypeVal:  &config.DeterministicSamplerConfig{SampleRate: 1_000_000},
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     1,
+				},
+				SendTickerVal:      2 * time.Millisecond,
+				ParentIdFieldNames: []string{"trace.parent_id", "parentId"},
+				SampleCache: config.SampleCacheConfig{
+					KeptSize:          100,
+					DroppedSize:       100,
+					SizeCheckInterval: config.Duration(1 * time.Second),
+				},
+				GetParallelismVal:      10,
+				MaxEffectiveSampleRate: 1,
+			}
+			transmission := &transmit.MockTransmission{}
+			coll := &CentralCollector{
+				Transmission: transmission,
+				StressRelief: &stressRelief.MockStressReliever{
+					SampleRate: 1_000_000,
+				},
+			}
+			stop := startCollector(t, conf, coll, storeType)
+			defer stop()
+
+			coll.deciderCycle.Pause()
+			coll.cleanupCycle.Pause()
+
+			traceIDs := make([]string, 0, 10)
+			for i := 0; i < 10; i++ {
+				span := &types.Span{
+					TraceID: fmt.Sprintf("trace-%v", i),
+					ID:      "span1",
+					Event: types.Event{
+						Dataset: "aoeu",
+						APIKey:  legacyAPIKey,
+						Data:    make(map[string]interface{}),
+					},
+				}
+				require.NoError(t, coll.AddSpan(span))
+				traceIDs = append(traceIDs, span.TraceID)
+			}
+			waitUntilReadyToDecide(t, coll, traceIDs)
+			coll.deciderCycle.RunOnce()
+			waitForTraceDecision(t, coll, traceIDs)
+
+			transmission.Mux.Lock()
+			require.Len(t, transmission.Events, 10)
+			for _, ev := range transmission.Events {
+				assert.Equal(t, uint(1), ev.SampleRate)
+			}
+			transmission.Mux.Unlock()
+			transmission.Flush()
+
+			// stress relief decisions are clamped the same way
+			coll.StressRelief.(*stressRelief.MockStressReliever).SampleDeterministically = true
+			processed, err := coll.ProcessSpanImmediately(&types.Span{
+				TraceID: "stressed",
+				ID:      "span1",
+				Event: types.Event{
+					Dataset: "aoeu",
+					Data:    make(map[string]interface{}),
+				},
+			})
+			require.NoError(t, err)
+			require.True(t, processed)
+			transmission.Mux.Lock()
+			require.Len(t, transmission.Events, 1)
+			assert.Equal(t, uint(1), transmission.Events[0].SampleRate)
+			transmission.Mux.Unlock()
+
+			assert.Equal(t, 11, coll.Metrics.(*metrics.MockMetrics).CounterIncrements["trace_sample_rate_clamped"])
+		})
+	}
+}
+
+func TestCentralCollector_clampSampleRate(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	coll := &CentralCollector{
+		Config:  &config.MockConfig{MaxEffectiveSampleRate: 10},
+		Logger:  &logger.NullLogger{},
+		Metrics: mockMetrics,
+	}
+
+	rate, keep := coll.clampSampleRate("trace", 5, false, "under")
+	assert.Equal(t, uint(5), rate)
+	assert.False(t, keep)
+
+	kept := 0
+	for i := 0; i < 10_000; i++ {
+		rate, keep = coll.clampSampleRate(fmt.Sprintf("trace-%d", i), 1_000_000, false, "over")
+		assert.Equal(t, uint(10), rate)
+		if keep {
+			kept++
+		}
+	}
+	assert.InDelta(t, 1000, kept, 150, "about one in ten traces should be kept at the ceiling")
+	assert.Equal(t, 10_000, mockMetrics.CounterIncrements["trace_sample_rate_clamped"])
+
+	coll.Config = &config.MockConfig{}
+	rate, _ = coll.clampSampleRate("trace", 1_000_000, false, "unlimited")
+	assert.Equal(t, uint(1_000_000), rate)
+}
+
+func TestCentralCollector_sweepOverAgeTraces(t *testing.T) {
+	for _, action := range []string{"drop", "send"} {
+		t.Run(action, func(t *testing.T) {
+			clock := clockwork.NewFakeClock()
+			conf := &config.MockConfig{
+				GetCollectionConfigVal: config.CollectionConfig{CacheCapacity: 100},
+				GetTraceTimeoutVal:     time.Minute,
+				MaxTraceAge:            10 * time.Minute,
+				MaxTraceAgeAction:      action,
+				AddRuleReasonToTrace:   true,
+			}
+			mockMetrics := &metrics.MockMetrics{}
+			mockMetrics.Start()
+			spanCache := &cache.SpanCache_basic{Cfg: conf, Clock: clock, Metrics: mockMetrics}
+			require.NoError(t, spanCache.Start())
+			transmission := &transmit.MockTransmission{}
+			transmission.Start()
+			coll := &CentralCollector{
+				Config:       conf,
+				Logger:       &logger.NullLogger{},
+				Metrics:      mockMetrics,
+				SpanCache:    spanCache,
+				Transmission: transmission,
+				Clock:        clock,
+			}
+
+			for _, id := range []string{"old", "new"} {
+				require.NoError(t, spanCache.Set(&types.Span{
+					TraceID:     id,
+					Event:       types.Event{Dataset: "aoeu", Data: map[string]interface{}{}},
+					ArrivalTime: clock.Now(),
+				}))
+				clock.Advance(8 * time.Minute)
+			}
+
+			coll.sweepOverAgeTraces()
+
+			assert.Nil(t, spanCache.Get("old"))
+			assert.NotNil(t, spanCache.Get("new"))
+			assert.Equal(t, float64((16 * time.Minute).Milliseconds()), mockMetrics.GaugeRecords["collector_oldest_trace_age_ms"])
+			if action == "send" {
+				require.Len(t, transmission.Events, 1)
+				assert.Equal(t, TraceSendMaxAge, transmission.Events[0].Data["meta.refinery.send_reason"])
+				assert.Equal(t, 1, mockMetrics.CounterIncrements["collector_max_trace_age_sent"])
+			} else {
+				assert.Empty(t, transmission.Events)
+				assert.Equal(t, 1, mockMetrics.CounterIncrements["collector_max_trace_age_dropped"])
+			}
+		})
+	}
+}
+
+func TestCentralCollector_ForceKeep(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				// high enough that the sampler drops every trace here
+				GetSamplerTypeVal:    &config.DeterministicSamplerConfig{SampleRate: 1_000_000_000},
+				SendTickerVal:        2 * time.Millisecond,
+				AddRuleReasonToTrace: true,
+				ParentIdFieldNames:   []string{"trace.parent_id", "parentId"},
+				GetParallelismVal:    10,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     2,
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+			collector.cleanupCycle.Pause()
+
+			traceids := []string{"forced", "sampled"}
+			for _, tid := range traceids {
+				child := &types.Span{
+					TraceID: tid,
+					ID:      "span1",
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{"trace.parent_id": "span0"},
+					},
+					ForceKeep: tid == "forced",
+				}
+				require.NoError(t, collector.AddSpan(child))
+				root := &types.Span{
+					TraceID: tid,
+					ID:      "span0",
+					IsRoot:  true,
+					Event:   types.Event{Dataset: "aoeu", Data: map[string]interface{}{}},
+				}
+				require.NoError(t, collector.AddSpan(root))
+			}
+
+			waitUntilReadyToDecide(t, collector, traceids)
+
+			ctx := context.Background()
+			collector.deciderCycle.RunOnce()
+			kept, err := collector.Store.GetStatusForTraces(ctx, traceids, centralstore.DecisionKeep)
+			require.NoError(t, err)
+			require.Len(t, kept, 1)
+			assert.Equal(t, "forced", kept[0].TraceID)
+			assert.Equal(t, uint(1), kept[0].Rate)
+			assert.Equal(t, forceKeepReason, kept[0].Metadata["meta.refinery.reason"])
+		})
+	}
+}
+
+func TestCentralCollector_KeepErrorTraces(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				// high enough that the sampler drops every trace here
+				GetSamplerTypeVal:    &config.DeterministicSamplerConfig{SampleRate: 1_000_000_000},
+				SendTickerVal:        2 * time.Millisecond,
+				AddRuleReasonToTrace: true,
+				ParentIdFieldNames:   []string{"trace.parent_id", "parentId"},
+				GetParallelismVal:    10,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     2,
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+			collector.cleanupCycle.Pause()
+
+			traceids := []string{"error", "ok"}
+			for _, tid := range traceids {
+				child := &types.Span{
+					TraceID: tid,
+					ID:      "span1",
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{"trace.parent_id": "span0"},
+					},
+					Error: tid == "error",
+				}
+				require.NoError(t, collector.AddSpan(child))
+				root := &types.Span{
+					TraceID: tid,
+					ID:      "span0",
+					IsRoot:  true,
+					Event:   types.Event{Dataset: "aoeu", Data: map[string]interface{}{}},
+				}
+				require.NoError(t, collector.AddSpan(root))
+			}
+
+			waitUntilReadyToDecide(t, collector, traceids)
+
+			ctx := context.Background()
+			collector.deciderCycle.RunOnce()
+			kept, err := collector.Store.GetStatusForTraces(ctx, traceids, centralstore.DecisionKeep)
+			require.NoError(t, err)
+			require.Len(t, kept, 1)
+			assert.Equal(t, "error", kept[0].TraceID)
+			assert.Equal(t, uint(1), kept[0].Rate)
+			assert.Equal(t, errorReason, kept[0].Metadata["meta.refinery.reason"])
+			count, _ := collector.Metrics.Get("trace_decision_error_kept")
+			assert.Equal(t, float64(1), count)
+
+			dropped, err := collector.Store.GetStatusForTraces(ctx, traceids, centralstore.DecisionDrop)
+			require.NoError(t, err)
+			require.Len(t, dropped, 1)
+			assert.Equal(t, "ok", dropped[0].TraceID)
+		})
+	}
+}
+
+func TestCentralCollector_Synthetic(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				GetSamplerTypeVal: &config.DynamicSamplerConfig{
+					SampleRate: 10,
+					FieldList:  []string{"http.route"},
+				},
+				SendTickerVal:        2 * time.Millisecond,
+				AddRuleReasonToTrace: true,
+				ParentIdFieldNames:   []string{"trace.parent_id", "parentId"},
+				GetParallelismVal:    10,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     2,
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+			collector.cleanupCycle.Pause()
+
+			traceids := []string{"synthetic", "sampled"}
+			for _, tid := range traceids {
+				root := &types.Span{
+					TraceID: tid,
+					ID:      "span0",
+					IsRoot:  true,
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{"http.route": "/" + tid},
+					},
+					Synthetic: tid == "synthetic",
+				}
+				require.NoError(t, collector.AddSpan(root))
+			}
+
+			waitUntilReadyToDecide(t, collector, traceids)
+
+			ctx := context.Background()
+			collector.deciderCycle.RunOnce()
+			kept, err := collector.Store.GetStatusForTraces(ctx, []string{"synthetic"}, centralstore.DecisionKeep)
+			require.NoError(t, err)
+			require.Len(t, kept, 1)
+			assert.Equal(t, uint(1), kept[0].Rate)
+			assert.Equal(t, syntheticReason, kept[0].Metadata["meta.refinery.reason"])
+
+			// only the sampled trace counts toward the sampler's keys
+			var keys []sample.SamplerKey
+			collector.mut.RLock()
+			for _, sampler := range collector.samplersByDestination {
+				keys = append(keys, sampler.(sample.KeyReporter).SamplerKeys()...)
+			}
+			collector.mut.RUnlock()
+			require.Len(t, keys, 1)
+			assert.Contains(t, keys[0].Key, "/sampled")
+		})
+	}
+}
+
+func TestCentralCollector_DryRunForEnvironment(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				// high enough that the sampler drops every trace here
+				GetSamplerTypeVal:   &config.DeterministicSamplerConfig{SampleRate: 1_000_000_000},
+				DryRunByEnvironment: map[string]bool{"staging": true},
+				SendTickerVal:       2 * time.Millisecond,
+				ParentIdFieldNames:  []string{"trace.parent_id", "parentId"},
+				GetParallelismVal:   10,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     2,
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+			collector.cleanupCycle.Pause()
+
+			envs := map[string]string{"staging-trace": "staging", "production-trace": "production"}
+			traceids := []string{"staging-trace", "production-trace"}
+			for _, tid := range traceids {
+				root := &types.Span{
+					TraceID: tid,
+					ID:      "span0",
+					IsRoot:  true,
+					Event: types.Event{
+						Dataset:     "aoeu",
+						Environment: envs[tid],
+						Data:        map[string]interface{}{},
+					},
+				}
+				require.NoError(t, collector.AddSpan(root))
+			}
+
+			waitUntilReadyToDecide(t, collector, traceids)
+
+			ctx := context.Background()
+			collector.deciderCycle.RunOnce()
+			kept, err := collector.Store.GetStatusForTraces(ctx, traceids, centralstore.DecisionKeep)
+			require.NoError(t, err)
+			require.Len(t, kept, 1)
+			assert.Equal(t, "staging-trace", kept[0].TraceID)
+			assert.Equal(t, uint(1), kept[0].Rate)
+			assert.Equal(t, false, kept[0].Metadata[config.DryRunFieldName])
+			assert.EqualValues(t, 1_000_000_000, kept[0].Metadata[dryRunSampleRateField])
+
+			dropped, err := collector.Store.GetStatusForTraces(ctx, traceids, centralstore.DecisionDrop)
+			require.NoError(t, err)
+			require.Len(t, dropped, 1)
+			assert.Equal(t, "production-trace", dropped[0].TraceID)
+			assert.NotContains(t, dropped[0].Metadata, config.DryRunFieldName)
+		})
+	}
+}
+
+func TestCentralCollector_SamplerKeyValues(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				GetSamplerTypeVal: &config.DynamicSamplerConfig{
+					SampleRate: 10,
+					FieldList:  []string{"http.route"},
+				},
+				SendTickerVal:      2 * time.Millisecond,
+				ParentIdFieldNames: []string{"trace.parent_id", "parentId"},
+				GetParallelismVal:  10,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+					AggregationCount:     2,
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+			collector.cleanupCycle.Pause()
+
+			// the raw values differ, but normalize to the same one
+			traceids := []string{"upper", "lower"}
+			for i, route := range []string{" /Users ", "/users"} {
+				root := &types.Span{
+					TraceID: traceids[i],
+					ID:      "span0",
+					IsRoot:  true,
+					Event: types.Event{
+						Dataset:          "aoeu",
+						Data:             map[string]interface{}{"http.route": route},
+						SamplerKeyValues: map[string]interface{}{"http.route": "/users"},
+					},
+				}
+				require.NoError(t, collector.AddSpan(root))
+			}
+
+			waitUntilReadyToDecide(t, collector, traceids)
+			collector.deciderCycle.RunOnce()
+
+			var keys []sample.SamplerKey
+			collector.mut.RLock()
+			for _, sampler := range collector.samplersByDestination {
+				keys = append(keys, sampler.(sample.KeyReporter).SamplerKeys()...)
+			}
+			collector.mut.RUnlock()
+			require.Len(t, keys, 1)
+			assert.Contains(t, keys[0].Key, "/users")
+			assert.NotContains(t, keys[0].Key, "Users")
+		})
+	}
+}
+
+func TestCentralCollector_MaxSpansPerTrace(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				GetSamplerTypeVal:  &config.DeterministicSamplerConfig{SampleRate: 1},
+				ParentIdFieldNames: []string{"trace.parent_id", "parentId"},
+				MaxSpansPerTrace:   3,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+
+			newSpan := func(traceID string, i int) *types.Span {
+				return &types.Span{
+					TraceID: traceID,
+					ID:      fmt.Sprintf("span%d", i),
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{"trace.parent_id": "span0"},
+					},
+				}
+			}
+			for i := 1; i <= 3; i++ {
+				require.NoError(t, collector.AddSpan(newSpan("big", i)))
+			}
+			require.Eventually(t, func() bool {
+				return collector.SpanCache.DescendantCount("big") == 3
+			}, time.Second, 5*time.Millisecond)
+
+			// the trace is full, but other traces aren't affected
+			assert.ErrorIs(t, collector.AddSpan(newSpan("big", 4)), ErrTraceSpanLimit)
+			assert.NoError(t, collector.AddSpan(newSpan("small", 1)))
+
+			assert.True(t, collector.SpanCache.Get("big").SpanLimitHit())
+			require.Eventually(t, func() bool {
+				return collector.SpanCache.Get("small") != nil
+			}, time.Second, 5*time.Millisecond)
+			assert.False(t, collector.SpanCache.Get("small").SpanLimitHit())
+		})
+	}
+}
+
+func TestCentralCollector_IntraTraceSampling(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		wantSent  int
+		// wantDropped is the root's meta.refinery.intra_trace_dropped, or
+		// nil if it shouldn't have one
+		wantDropped interface{}
+	}{
+		// 3 spans reach the threshold; of the 4 after them, 1 in 2 are kept
+		{"above threshold", 3, 3 + 2 + 1, 2},
+		{"below threshold", 10, 7 + 1, nil},
+		{"disabled", 0, 7 + 1, nil},
+	}
+	for _, storeType := range storeTypes {
+		for _, tt := range tests {
+			t.Run(storeType+"/"+tt.name, func(t *testing.T) {
+				conf := &config.MockConfig{
+					GetSendDelayVal:             10 * time.Millisecond,
+					GetTraceTimeoutVal:          60 * time.Second,
+					GetSamplerTypeVal:           &config.DeterministicSamplerConfig{SampleRate: 1},
+					SendTickerVal:               60 * time.Second,
+					ParentIdFieldNames:          []string{"trace.parent_id", "parentId"},
+					GetParallelismVal:           10,
+					IntraTraceSamplingThreshold: tt.threshold,
+					IntraTraceSampleRate:        2,
+					SampleCache: config.SampleCacheConfig{
+						KeptSize:          100,
+						DroppedSize:       100,
+						SizeCheckInterval: config.Duration(1 * time.Second),
+					},
+					GetCollectionConfigVal: config.CollectionConfig{
+						IncomingQueueSize:    100,
+						DeciderCycleDuration: config.Duration(1 * time.Second),
+						AggregationCount:     1,
+					},
+				}
+				transmission := &transmit.MockTransmission{}
+				coll := &CentralCollector{
+					Transmission: transmission,
+				}
+				stop := startCollector(t, conf, coll, storeType)
+				defer stop()
+				coll.deciderCycle.Pause()
+				coll.cleanupCycle.Pause()
+
+				const traceID = "bigtrace"
+				newSpan := func(i int) *types.Span {
+					return &types.Span{
+						TraceID: traceID,
+						ID:      fmt.Sprintf("span%d", i),
+						Event: types.Event{
+							Dataset: "aoeu",
+							Data:    map[string]interface{}{"trace.parent_id": "root"},
+							APIKey:  legacyAPIKey,
+						},
+					}
+				}
+				for i := 0; i < 3; i++ {
+					require.NoError(t, coll.AddSpan(newSpan(i)))
+				}
+				// spans are only counted once they're processed
+				require.Eventually(t, func() bool {
+					return coll.SpanCache.DescendantCount(traceID) == 3
+				}, time.Second, 5*time.Millisecond)
+				for i := 3; i < 7; i++ {
+					require.NoError(t, coll.AddSpan(newSpan(i)))
+				}
+
+				root := &types.Span{
+					TraceID: traceID,
+					ID:      "root",
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{},
+						APIKey:  legacyAPIKey,
+					},
+					IsRoot: true,
+				}
+				require.NoError(t, coll.AddSpan(root))
+
+				waitUntilReadyToDecide(t, coll, []string{traceID})
+				coll.deciderCycle.RunOnce()
+				waitForTraceDecision(t, coll, []string{traceID})
+
+				transmission.Mux.RLock()
+				defer transmission.Mux.RUnlock()
+				require.Len(t, transmission.Events, tt.wantSent)
+				for _, ev := range transmission.Events {
+					dropped, ok := ev.Data["meta.refinery.intra_trace_dropped"]
+					if ev.Data["trace.parent_id"] != nil || tt.wantDropped == nil {
+						assert.False(t, ok, "only the root span records the dropped count")
+						continue
+					}
+					assert.Equal(t, tt.wantDropped, dropped)
+				}
+			})
+		}
+	}
+}
+
+func TestCentralCollector_MaxIncomingQueueWait(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				GetSamplerTypeVal:    &config.DeterministicSamplerConfig{SampleRate: 1},
+				ParentIdFieldNames:   []string{"trace.parent_id", "parentId"},
+				MaxIncomingQueueWait: 100 * time.Millisecond,
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+				},
+			}
+			collector := &CentralCollector{}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+			collector.deciderCycle.Pause()
+
+			newSpan := func(traceID string) *types.Span {
+				return &types.Span{
+					TraceID: traceID,
+					ID:      "span1",
+					Event: types.Event{
+						Dataset: "aoeu",
+						Data:    map[string]interface{}{"trace.parent_id": "root"},
+					},
+				}
+			}
+
+			// as if the span had sat behind a backed-up queue
+			stale := newSpan("stale")
+			stale.EnqueuedAt = time.Now().Add(-time.Second)
+			collector.incoming <- stale
+			require.NoError(t, collector.AddSpan(newSpan("fresh")))
+
+			require.Eventually(t, func() bool {
+				return collector.SpanCache.Get("fresh") != nil
+			}, time.Second, 5*time.Millisecond)
+			assert.Nil(t, collector.SpanCache.Get("stale"))
+			dropped, _ := collector.Metrics.Get("stale_span_dropped")
+			assert.Equal(t, float64(1), dropped)
+		})
+	}
+}
+
+func TestCentralCollector_ProcessSpanImmediatelyForceKeep(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			conf := &config.MockConfig{
+				ParentIdFieldNames: []string{"trace.parent_id", "parentId"},
+				GetCollectionConfigVal: config.CollectionConfig{
+					IncomingQueueSize:    100,
+					DeciderCycleDuration: config.Duration(1 * time.Second),
+				},
+			}
+			transmission := &transmit.MockTransmission{}
+			collector := &CentralCollector{
+				Transmission: transmission,
+				// stress relief would drop everything
+				StressRelief: &stressRelief.MockStressReliever{
+					SampleDeterministically: true,
+					ShouldKeep:              false,
+					SampleRate:              100,
+				},
+			}
+			stop := startCollector(t, conf, collector, storeType)
+			defer stop()
+
+			span := &types.Span{
+				TraceID: "trace1",
+				ID:      "span1",
+				Event: types.Event{
+					Dataset: "aoeu",
+					Data:    make(map[string]interface{}),
+				},
+				ForceKeep: true,
+			}
+			processed, err := collector.ProcessSpanImmediately(span)
+			require.NoError(t, err)
+			require.True(t, processed)
+
+			transmission.Mux.Lock()
+			defer transmission.Mux.Unlock()
+			require.Len(t, transmission.Events, 1)
+			assert.Equal(t, uint(1), transmission.Events[0].SampleRate)
+		})
+	}
+}
+
 func startCollector(t *testing.T, cfg *config.MockConfig, collector *CentralCollector,
 	storeType string) func() {
 	if cfg == nil {
@@ -1428,6 +2250,8 @@ func startCollector(t *testing.T, cfg *config.MockConfig, collector *CentralColl
 		{Value: decisionCache},
 		{Value: spanCache},
 		{Value: collector.Transmission, Name: "upstreamTransmission"},
+		{Value: &transmit.MockTransmission{}, Name: "shadowTransmission"},
+		{Value: &http.Transport{}, Name: "upstreamTransport"},
 		{Value: samplerFactory},
 		{Value: redis, Name: "redis"},
 		{Value: collector.Clock},
@@ -0,0 +1,137 @@
+package collect
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/honeycombio/refinery/types"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// debugSinkSalt keeps the traces written to the debug sink independent
+	// of the ones that are shadowed.
+	debugSinkSalt = "refinery-debug-sink"
+
+	// debugSinkQueueSize is how many kept traces can be waiting to be
+	// written; beyond that, they're dropped rather than holding up sending.
+	debugSinkQueueSize = 100
+)
+
+// debugSinkTrace is what the debug sink writes for each kept trace: the
+// decision, and the fields of its spans as they're sent to Honeycomb.
+type debugSinkTrace struct {
+	TraceID    string                   `json:"trace_id"`
+	Dataset    string                   `json:"dataset"`
+	SampleRate uint                     `json:"sample_rate"`
+	Reason     string                   `json:"reason,omitempty"`
+	Spans      []map[string]interface{} `json:"spans"`
+}
+
+// startDebugSink starts writing the kept traces queued by sinkTrace, if
+// DebugTraceSinkEnabled is set. They're written to DebugTraceSinkPath, or to
+// stdout if there isn't one.
+func (c *CentralCollector) startDebugSink() {
+	if !c.Config.GetDebugTraceSinkEnabled() {
+		return
+	}
+	if c.debugSinkOut == nil {
+		c.debugSinkOut = os.Stdout
+		if path := c.Config.GetDebugTraceSinkPath(); path != "" {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				c.Logger.Error().WithString("path", path).WithString("error", err.Error()).
+					Logf("failed to open the debug trace sink; kept traces won't be written")
+				return
+			}
+			c.debugSinkOut = f
+		}
+	}
+	c.debugSinkQueue = make(chan *debugSinkTrace, debugSinkQueueSize)
+	c.debugSinkDone = make(chan struct{})
+	c.Logger.Warn().Logf("writing kept traces to the debug trace sink; this is for development, not production")
+
+	c.debugSinkWG.Add(1)
+	go func() {
+		defer c.debugSinkWG.Done()
+		for {
+			select {
+			case tr := <-c.debugSinkQueue:
+				c.writeDebugSinkTrace(tr)
+			case <-c.debugSinkDone:
+				// write whatever is already queued before we go
+				for {
+					select {
+					case tr := <-c.debugSinkQueue:
+						c.writeDebugSinkTrace(tr)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stopDebugSink writes what's left in the debug sink's queue, and closes the
+// file it was writing to.
+func (c *CentralCollector) stopDebugSink() {
+	if c.debugSinkQueue == nil {
+		return
+	}
+	close(c.debugSinkDone)
+	c.debugSinkWG.Wait()
+	if f, ok := c.debugSinkOut.(*os.File); ok && f != os.Stdout {
+		f.Close()
+	}
+}
+
+// newDebugSinkTrace returns the record of a kept trace for the debug sink,
+// with no spans yet, or nil if the trace isn't one of the
+// DebugTraceSinkFraction that are written.
+func (c *CentralCollector) newDebugSinkTrace(trace *types.Trace, rate uint, reason string) *debugSinkTrace {
+	if c.debugSinkQueue == nil || !traceInFraction(trace.TraceID, debugSinkSalt, c.Config.GetDebugTraceSinkFraction()) {
+		return nil
+	}
+	return &debugSinkTrace{
+		TraceID:    trace.TraceID,
+		Dataset:    trace.Dataset,
+		SampleRate: rate,
+		Reason:     reason,
+	}
+}
+
+// addSpan adds a copy of a span's fields to the record, so that the
+// transmission can't change what's written. It's safe to call on nil.
+func (tr *debugSinkTrace) addSpan(sp *types.Span) {
+	if tr != nil {
+		tr.Spans = append(tr.Spans, maps.Clone(sp.Data))
+	}
+}
+
+// sinkTrace queues a kept trace to be written to the debug sink. If the
+// queue is full, because the writer can't keep up, the trace is dropped.
+func (c *CentralCollector) sinkTrace(tr *debugSinkTrace) {
+	if tr == nil {
+		return
+	}
+	select {
+	case c.debugSinkQueue <- tr:
+		c.Metrics.Increment("collector_debug_sink_written")
+	default:
+		c.Metrics.Increment("collector_debug_sink_dropped")
+	}
+}
+
+func (c *CentralCollector) writeDebugSinkTrace(tr *debugSinkTrace) {
+	out, err := json.MarshalIndent(tr, "", "  ")
+	if err != nil {
+		c.Logger.Error().WithString("trace_id", tr.TraceID).WithString("error", err.Error()).
+			Logf("failed to marshal trace for the debug trace sink")
+		return
+	}
+	out = append(out, '\n')
+	if _, err := c.debugSinkOut.Write(out); err != nil {
+		c.Logger.Error().WithString("error", err.Error()).Logf("failed to write to the debug trace sink")
+	}
+}
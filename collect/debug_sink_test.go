@@ -0,0 +1,130 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a buffer that the test can hold up writes to.
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func TestCentralCollector_DebugSink(t *testing.T) {
+	newCollector := func(conf *config.MockConfig, out io.Writer) (*CentralCollector, *metrics.MockMetrics) {
+		mockMetrics := &metrics.MockMetrics{}
+		mockMetrics.Start()
+		coll := &CentralCollector{
+			Config:       conf,
+			Logger:       &logger.NullLogger{},
+			Metrics:      mockMetrics,
+			debugSinkOut: out,
+		}
+		coll.startDebugSink()
+		return coll, mockMetrics
+	}
+	sink := func(coll *CentralCollector, traceID string) bool {
+		tr := coll.newDebugSinkTrace(&types.Trace{TraceID: traceID, Dataset: "aoeu"}, 10, "rule 1")
+		tr.addSpan(&types.Span{Event: types.Event{Data: map[string]interface{}{"trace.trace_id": traceID}}})
+		coll.sinkTrace(tr)
+		return tr != nil
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		out := &lockedBuffer{}
+		coll, _ := newCollector(&config.MockConfig{DebugTraceSinkFraction: 1}, out)
+		assert.False(t, sink(coll, "trace"))
+		coll.stopDebugSink()
+		assert.Zero(t, out.Len())
+	})
+
+	t.Run("writes kept traces", func(t *testing.T) {
+		out := &lockedBuffer{}
+		coll, mockMetrics := newCollector(&config.MockConfig{
+			DebugTraceSinkEnabled:  true,
+			DebugTraceSinkFraction: 1,
+		}, out)
+		for i := 0; i < 3; i++ {
+			require.True(t, sink(coll, fmt.Sprintf("trace-%d", i)))
+		}
+		coll.stopDebugSink()
+
+		written := out.String()
+		assert.Contains(t, written, "\n  \"trace_id\"", "the JSON is pretty-printed")
+		dec := json.NewDecoder(strings.NewReader(written))
+		for i := 0; i < 3; i++ {
+			var tr debugSinkTrace
+			require.NoError(t, dec.Decode(&tr))
+			assert.Equal(t, debugSinkTrace{
+				TraceID:    fmt.Sprintf("trace-%d", i),
+				Dataset:    "aoeu",
+				SampleRate: 10,
+				Reason:     "rule 1",
+				Spans:      []map[string]interface{}{{"trace.trace_id": fmt.Sprintf("trace-%d", i)}},
+			}, tr)
+		}
+		assert.True(t, errors.Is(dec.Decode(&debugSinkTrace{}), io.EOF))
+		assert.Equal(t, 3, mockMetrics.CounterIncrements["collector_debug_sink_written"])
+	})
+
+	t.Run("writes the configured fraction", func(t *testing.T) {
+		out := &lockedBuffer{}
+		coll, _ := newCollector(&config.MockConfig{
+			DebugTraceSinkEnabled:  true,
+			DebugTraceSinkFraction: 0.25,
+		}, out)
+		var written int
+		for i := 0; i < 10_000; i++ {
+			traceID := fmt.Sprintf("trace-%d", i)
+			if coll.newDebugSinkTrace(&types.Trace{TraceID: traceID}, 1, "") != nil {
+				written++
+				assert.True(t, traceInFraction(traceID, debugSinkSalt, 0.5), "raising the fraction keeps the traces already chosen")
+			}
+		}
+		coll.stopDebugSink()
+		assert.InDelta(t, 2500, written, 250)
+
+		coll.Config.(*config.MockConfig).DebugTraceSinkFraction = 0
+		coll.startDebugSink()
+		assert.False(t, sink(coll, "trace"))
+		coll.stopDebugSink()
+	})
+
+	t.Run("drops traces when the writer is slow", func(t *testing.T) {
+		out := &lockedBuffer{}
+		coll, mockMetrics := newCollector(&config.MockConfig{
+			DebugTraceSinkEnabled:  true,
+			DebugTraceSinkFraction: 1,
+		}, out)
+		// hold up the writer so that the queue fills
+		out.Lock()
+		for i := 0; i < debugSinkQueueSize+10; i++ {
+			sink(coll, fmt.Sprintf("trace-%d", i))
+		}
+		out.Unlock()
+		coll.stopDebugSink()
+
+		assert.Equal(t, debugSinkQueueSize+10, mockMetrics.CounterIncrements["collector_debug_sink_written"]+mockMetrics.CounterIncrements["collector_debug_sink_dropped"])
+		assert.Positive(t, mockMetrics.CounterIncrements["collector_debug_sink_dropped"])
+	})
+}
@@ -0,0 +1,107 @@
+package collect
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// maxDroppedSampleSpans bounds the spans kept for each retained trace, so that
+// one enormous trace can't use up the memory for the whole buffer.
+const maxDroppedSampleSpans = 100
+
+// DroppedSample is a dropped trace retained for debugging.
+type DroppedSample struct {
+	TraceID     string                   `json:"trace_id"`
+	Dataset     string                   `json:"dataset"`
+	Environment string                   `json:"environment,omitempty"`
+	DroppedAt   time.Time                `json:"dropped_at"`
+	SpanCount   int                      `json:"span_count"`
+	Truncated   bool                     `json:"truncated"`
+	Spans       []map[string]interface{} `json:"spans"`
+}
+
+// droppedSamples is a ring buffer of the most recent retained dropped traces.
+type droppedSamples struct {
+	mut     sync.Mutex
+	samples []DroppedSample
+	next    int
+	full    bool
+}
+
+func newDroppedSamples(size int) *droppedSamples {
+	return &droppedSamples{samples: make([]DroppedSample, size)}
+}
+
+// add retains trace, overwriting the oldest sample once the buffer is full.
+func (d *droppedSamples) add(trace *types.Trace, droppedAt time.Time) {
+	spans := trace.GetSpans()
+	sample := DroppedSample{
+		TraceID:   trace.TraceID,
+		Dataset:   trace.Dataset,
+		DroppedAt: droppedAt,
+		SpanCount: len(spans),
+		Truncated: len(spans) > maxDroppedSampleSpans,
+	}
+	if sample.Truncated {
+		spans = spans[:maxDroppedSampleSpans]
+	}
+	sample.Spans = make([]map[string]interface{}, 0, len(spans))
+	for _, sp := range spans {
+		if sample.Environment == "" {
+			sample.Environment = sp.Environment
+		}
+		sample.Spans = append(sample.Spans, sp.Data)
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.samples[d.next] = sample
+	d.next = (d.next + 1) % len(d.samples)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// get returns the retained samples, newest first.
+func (d *droppedSamples) get() []DroppedSample {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	n := d.next
+	if d.full {
+		n = len(d.samples)
+	}
+	samples := make([]DroppedSample, 0, n)
+	for i := 1; i <= n; i++ {
+		samples = append(samples, d.samples[(d.next-i+len(d.samples))%len(d.samples)])
+	}
+	return samples
+}
+
+// retainDropped keeps a copy of the dropped trace with the given ID, one time
+// in DroppedSampleRate, if DroppedSampleRetention is enabled. It must be
+// called before the trace is removed from the span cache.
+func (c *CentralCollector) retainDropped(traceID string) {
+	if c.droppedSamples == nil {
+		return
+	}
+	if rate := c.Config.GetDroppedSampleRate(); rate > 1 && rand.Intn(rate) != 0 {
+		return
+	}
+	trace := c.SpanCache.Get(traceID)
+	if trace == nil {
+		return
+	}
+	c.droppedSamples.add(trace, c.Clock.Now())
+	c.Metrics.Increment("collector_dropped_sample_retained")
+}
+
+func (c *CentralCollector) GetDroppedSamples() ([]DroppedSample, bool) {
+	if c.droppedSamples == nil {
+		return nil, false
+	}
+	return c.droppedSamples.get(), true
+}
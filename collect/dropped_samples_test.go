@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDroppedSamples(t *testing.T) {
+	d := newDroppedSamples(2)
+	assert.Empty(t, d.get())
+
+	newTrace := func(id string, spans int) *types.Trace {
+		trace := &types.Trace{TraceID: id, Dataset: "aoeu"}
+		for i := 0; i < spans; i++ {
+			trace.AddSpan(&types.Span{
+				TraceID: id,
+				Event:   types.Event{Environment: "test", Data: map[string]interface{}{"i": i}},
+			})
+		}
+		return trace
+	}
+
+	now := time.Now()
+	d.add(newTrace("trace1", 1), now)
+	d.add(newTrace("trace2", 2), now)
+	d.add(newTrace("trace3", maxDroppedSampleSpans+1), now)
+
+	samples := d.get()
+	require.Len(t, samples, 2)
+	// newest first, and the oldest was overwritten
+	assert.Equal(t, "trace3", samples[0].TraceID)
+	assert.Equal(t, "trace2", samples[1].TraceID)
+
+	assert.Equal(t, "test", samples[1].Environment)
+	assert.Equal(t, 2, samples[1].SpanCount)
+	assert.Len(t, samples[1].Spans, 2)
+	assert.False(t, samples[1].Truncated)
+
+	assert.Equal(t, maxDroppedSampleSpans+1, samples[0].SpanCount)
+	assert.Len(t, samples[0].Spans, maxDroppedSampleSpans)
+	assert.True(t, samples[0].Truncated)
+}
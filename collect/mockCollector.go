@@ -1,11 +1,19 @@
 package collect
 
 import (
+	"github.com/honeycombio/refinery/sample"
 	"github.com/honeycombio/refinery/types"
 )
 
 type MockCollector struct {
-	Spans chan *types.Span
+	Spans          chan *types.Span
+	SamplerKeys    map[string][]sample.SamplerKey
+	DroppedSamples []DroppedSample
+	CurrentLoad    Load
+	Traces         chan TraceNotification
+	// StressedSampleRate, if set, makes the collector stressed, and every
+	// span is processed immediately at that rate
+	StressedSampleRate uint
 }
 
 func NewMockCollector() *MockCollector {
@@ -28,12 +36,43 @@ func (m *MockCollector) GetStressedSampleRate(traceID string) (rate uint, keep b
 	return 0, false, ""
 }
 
-func (m *MockCollector) ProcessSpanImmediately(sp *types.Span, keep bool, sampleRate uint, reason string) {
+func (m *MockCollector) ProcessSpanImmediately(sp *types.Span) (bool, error) {
+	if m.StressedSampleRate == 0 {
+		return false, nil
+	}
+	mergeTraceAndSpanSampleRates(sp, m.StressedSampleRate)
 	m.Spans <- sp
+	return true, nil
 }
 
 func (m *MockCollector) Stressed() bool {
-	return false
+	return m.StressedSampleRate != 0
+}
+
+func (m *MockCollector) RemainingTraceCount() int {
+	return len(m.Spans)
+}
+
+func (m *MockCollector) Drain() {}
+
+func (m *MockCollector) Load() Load {
+	return m.CurrentLoad
+}
+
+func (m *MockCollector) GetDroppedSamples() ([]DroppedSample, bool) {
+	return m.DroppedSamples, m.DroppedSamples != nil
+}
+
+func (m *MockCollector) GetSamplerKeys(selector string) ([]sample.SamplerKey, bool) {
+	keys, found := m.SamplerKeys[selector]
+	return keys, found
+}
+
+func (m *MockCollector) SubscribeTraces(depth int) <-chan TraceNotification {
+	if m.Traces == nil {
+		m.Traces = make(chan TraceNotification, depth)
+	}
+	return m.Traces
 }
 
 func (m *MockCollector) Flush() {
@@ -0,0 +1,35 @@
+package collect
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math"
+)
+
+// clampSalt keeps the decision made at the clamped rate independent of any
+// that a sampler made by hashing the trace ID.
+const clampSalt = "Vd2mQ7xk4LpE9sYc"
+
+// clampSampleRate applies MaxEffectiveSampleRate to a sampling decision. If
+// rate is above the ceiling, the trace is kept or dropped again at the
+// ceiling; the choice is made from the trace ID, so every node makes the same
+// one.
+func (c *CentralCollector) clampSampleRate(traceID string, rate uint, keep bool, reason string) (uint, bool) {
+	max := c.Config.GetMaxEffectiveSampleRate()
+	if max == 0 || rate <= max {
+		return rate, keep
+	}
+
+	sum := sha1.Sum([]byte(traceID + clampSalt))
+	clampedKeep := binary.BigEndian.Uint32(sum[:4]) <= math.MaxUint32/uint32(max)
+
+	c.Metrics.Increment("trace_sample_rate_clamped")
+	c.Logger.Warn().WithFields(map[string]interface{}{
+		"trace_id":                  traceID,
+		"sample_rate":               rate,
+		"max_effective_sample_rate": max,
+		"reason":                    reason,
+		"keep":                      clampedKeep,
+	}).Logf("sample rate is higher than MaxEffectiveSampleRate; deciding again at the lower rate")
+	return max, clampedKeep
+}
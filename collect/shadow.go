@@ -0,0 +1,103 @@
+package collect
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math"
+
+	"github.com/honeycombio/refinery/types"
+	"golang.org/x/exp/maps"
+)
+
+// shadowSalt keeps the choice of shadowed traces independent of other
+// choices made by hashing trace IDs, such as dataset sharding.
+const shadowSalt = "refinery-shadow"
+
+// startShadow starts sending the copies of kept spans queued by shadowSpan to
+// the shadow transmission, if a shadow upstream is configured. The queue is
+// bounded so that a slow shadow upstream can't hold up sending traces.
+func (c *CentralCollector) startShadow() {
+	conf := c.Config.GetShadowConfig()
+	if !conf.Enabled() {
+		return
+	}
+	c.shadowQueue = make(chan *types.Span, conf.QueueSize)
+	c.shadowDone = make(chan struct{})
+
+	c.shadowWG.Add(1)
+	go func() {
+		defer c.shadowWG.Done()
+		for {
+			select {
+			case sp := <-c.shadowQueue:
+				c.ShadowTransmission.EnqueueSpan(sp)
+			case <-c.shadowDone:
+				// send whatever is already queued before we go
+				for {
+					select {
+					case sp := <-c.shadowQueue:
+						c.ShadowTransmission.EnqueueSpan(sp)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stopShadow sends what's left in the shadow queue. It's called once the
+// collector has sent everything it's going to.
+func (c *CentralCollector) stopShadow() {
+	if c.shadowQueue == nil {
+		return
+	}
+	close(c.shadowDone)
+	c.shadowWG.Wait()
+}
+
+// shadowSpan queues a copy of a kept span for the shadow upstream, if its
+// trace is one of the ones chosen to be shadowed. The copy has its own Data,
+// so the primary transmission can't change what the shadow receives. If the
+// queue is full, the copy is dropped.
+func (c *CentralCollector) shadowSpan(sp *types.Span) {
+	if c.shadowQueue == nil {
+		return
+	}
+	conf := c.Config.GetShadowConfig()
+	if !shadowTrace(sp.TraceID, conf.SampleFraction) {
+		return
+	}
+
+	shadow := *sp
+	shadow.Data = maps.Clone(sp.Data)
+	shadow.APIHost = conf.APIHost
+	if conf.APIKey != "" {
+		shadow.APIKey = conf.APIKey
+	}
+
+	select {
+	case c.shadowQueue <- &shadow:
+		c.Metrics.Increment("collector_shadowed")
+	default:
+		c.Metrics.Increment("collector_shadow_dropped")
+	}
+}
+
+// shadowTrace reports whether traceID is one of the fraction of traces that
+// are shadowed. It depends only on its arguments, so every Refinery node
+// makes the same choice for a trace.
+func shadowTrace(traceID string, fraction float64) bool {
+	return traceInFraction(traceID, shadowSalt, fraction)
+}
+
+// traceInFraction reports whether traceID is one of a fraction of traces,
+// chosen by a hash of the trace ID and salt, so that different choices of a
+// fraction of traces are independent of each other.
+func traceInFraction(traceID string, salt string, fraction float64) bool {
+	if fraction >= 1 {
+		return true
+	}
+	sum := sha1.Sum([]byte(traceID + salt))
+	return float64(binary.BigEndian.Uint32(sum[:4])) < fraction*math.MaxUint32
+}
@@ -0,0 +1,97 @@
+package collect
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowTrace(t *testing.T) {
+	var shadowed int
+	for i := 0; i < 10_000; i++ {
+		traceID := fmt.Sprintf("trace-%d", i)
+		if shadowTrace(traceID, 0.25) {
+			shadowed++
+			assert.True(t, shadowTrace(traceID, 0.25), "the choice must be stable")
+			assert.True(t, shadowTrace(traceID, 0.5), "raising the fraction keeps the traces already chosen")
+		}
+		assert.False(t, shadowTrace(traceID, 0))
+		assert.True(t, shadowTrace(traceID, 1))
+	}
+	assert.InDelta(t, 2500, shadowed, 250)
+}
+
+func TestCentralCollector_ShadowSpan(t *testing.T) {
+	newSpan := func(traceID string) *types.Span {
+		return &types.Span{
+			TraceID: traceID,
+			Event: types.Event{
+				APIHost: "https://api.honeycomb.io",
+				APIKey:  "primary",
+				Dataset: "aoeu",
+				Data:    map[string]interface{}{"field": "value"},
+			},
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		shadow := &transmit.MockTransmission{}
+		shadow.Start()
+		coll := &CentralCollector{
+			Config:             &config.MockConfig{},
+			Metrics:            &metrics.NullMetrics{},
+			ShadowTransmission: shadow,
+		}
+		coll.startShadow()
+		coll.shadowSpan(newSpan("trace"))
+		coll.stopShadow()
+		assert.Empty(t, shadow.Events)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		shadow := &transmit.MockTransmission{}
+		shadow.Start()
+		mockMetrics := &metrics.MockMetrics{}
+		mockMetrics.Start()
+		coll := &CentralCollector{
+			Config: &config.MockConfig{
+				Shadow: config.ShadowConfig{
+					APIHost:        "https://shadow.example.com",
+					APIKey:         "shadow",
+					SampleFraction: 1,
+					QueueSize:      1,
+				},
+			},
+			Metrics:            mockMetrics,
+			ShadowTransmission: shadow,
+		}
+		coll.startShadow()
+		// hold up the sender so that the queue fills
+		shadow.Mux.Lock()
+		sp := newSpan("trace")
+		for i := 0; i < 3; i++ {
+			coll.shadowSpan(sp)
+		}
+		sp.Data["field"] = "changed"
+		shadow.Mux.Unlock()
+		coll.stopShadow()
+
+		require.NotEmpty(t, shadow.Events)
+		for _, ev := range shadow.Events {
+			assert.Equal(t, "https://shadow.example.com", ev.APIHost)
+			assert.Equal(t, "shadow", ev.APIKey)
+			assert.Equal(t, "aoeu", ev.Dataset)
+			assert.Equal(t, "value", ev.Data["field"])
+		}
+		assert.Equal(t, "primary", sp.APIKey)
+		assert.Equal(t, 3, mockMetrics.CounterIncrements["collector_shadowed"]+mockMetrics.CounterIncrements["collector_shadow_dropped"])
+		assert.Equal(t, len(shadow.Events), mockMetrics.CounterIncrements["collector_shadowed"])
+		assert.Positive(t, mockMetrics.CounterIncrements["collector_shadow_dropped"])
+	})
+}
@@ -1,8 +1,10 @@
 package stressRelief
 
 import (
+	"hash/fnv"
 	"math"
 
+	"github.com/dgryski/go-wyhash"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
@@ -13,6 +15,9 @@ type StressReliever interface {
 	UpdateFromConfig(cfg config.StressReliefConfig)
 	Recalc() uint
 	Stressed() bool
+	// StressLevel returns this node's most recently calculated stress level,
+	// from 0 to 100.
+	StressLevel() uint
 	GetSampleRate(traceID string) (rate uint, keep bool, reason string)
 	ShouldSampleDeterministically(traceID string) bool
 }
@@ -24,12 +29,14 @@ type MockStressReliever struct {
 	SampleDeterministically bool
 	SampleRate              uint
 	ShouldKeep              bool
+	Level                   uint
 }
 
 func (m *MockStressReliever) Start() error                                   { return nil }
 func (m *MockStressReliever) UpdateFromConfig(cfg config.StressReliefConfig) {}
 func (m *MockStressReliever) Recalc() uint                                   { return 0 }
 func (m *MockStressReliever) Stressed() bool                                 { return m.IsStressed }
+func (m *MockStressReliever) StressLevel() uint                              { return m.Level }
 func (m *MockStressReliever) GetSampleRate(traceID string) (rate uint, keep bool, reason string) {
 	return m.SampleRate, m.ShouldKeep, "mock"
 }
@@ -42,6 +49,23 @@ func (m *MockStressReliever) ShouldSampleDeterministically(traceID string) bool
 // all make the same sampling decisions during stress relief.
 const hashSeed = 34527861234
 
+const defaultHashAlgorithm = "wyhash"
+
+// hashAlgorithms are the hashes that can be chosen with the HashAlgorithm
+// setting. The result of each one must never change between versions, so that
+// a cluster in the middle of a rolling upgrade still makes consistent
+// decisions; add a new algorithm rather than changing an existing one.
+var hashAlgorithms = map[string]func(traceID string) uint64{
+	"wyhash": func(traceID string) uint64 {
+		return wyhash.Hash([]byte(traceID), hashSeed)
+	},
+	"fnv1a": func(traceID string) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(traceID))
+		return h.Sum64()
+	},
+}
+
 type StressReliefMode int
 
 const (
@@ -9,7 +9,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/dgryski/go-wyhash"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/internal/health"
 	"github.com/honeycombio/refinery/internal/peer"
@@ -40,13 +39,18 @@ type StressRelief struct {
 	activateLevel      uint
 	deactivateLevel    uint
 	overallStressLevel uint
+	stressLevel        uint
 	sampleRate         uint64
 	upperBound         uint64
 	reason             string
 	formula            string
+	hashAlgorithm      string
+	hash               func(traceID string) uint64
 	stressed           bool
 	stayOnUntil        time.Time
 	minDuration        time.Duration
+	startTime          time.Time
+	warmup             time.Duration
 	peerChan           <-chan peer.PeerInfo
 
 	eg *errgroup.Group
@@ -93,6 +97,9 @@ func (s *StressRelief) Start() error {
 
 	s.stressLevels = make(map[string]stressReport)
 	s.done = make(chan struct{})
+	s.lock.Lock()
+	s.startTime = s.Clock.Now()
+	s.lock.Unlock()
 
 	s.Health.Register(stressReliefHealthSource, 5*calculationInterval)
 
@@ -121,7 +128,8 @@ func (s *StressRelief) monitor() error {
 			if err != nil {
 				s.Logger.Error().Logf("error publishing stress level: %s", err)
 			} else {
-				s.Health.Ready(stressReliefHealthSource, true)
+				// hold off traffic until the warmup is over
+				s.Health.Ready(stressReliefHealthSource, !s.WarmingUp())
 			}
 
 		case msg := <-s.peerChan:
@@ -176,12 +184,24 @@ func (s *StressRelief) UpdateFromConfig(cfg config.StressReliefConfig) {
 		s.sampleRate = 1
 	}
 	s.minDuration = time.Duration(cfg.MinimumActivationDuration)
+	s.warmup = time.Duration(cfg.Warmup)
+
+	s.hashAlgorithm = cfg.HashAlgorithm
+	if _, ok := hashAlgorithms[s.hashAlgorithm]; !ok {
+		if s.hashAlgorithm != "" {
+			s.Logger.Error().Logf("StressRelief hash algorithm is '%s' which shouldn't happen", cfg.HashAlgorithm)
+		}
+		s.hashAlgorithm = defaultHashAlgorithm
+	}
+	s.hash = hashAlgorithms[s.hashAlgorithm]
 
 	s.Logger.Debug().
 		WithField("activation_level", s.activateLevel).
 		WithField("deactivation_level", s.deactivateLevel).
 		WithField("sampling_rate", s.sampleRate).
 		WithField("min_duration", s.minDuration).
+		WithField("warmup", s.warmup).
+		WithField("hash_algorithm", s.hashAlgorithm).
 		WithField("startup_duration", cfg.MinimumActivationDuration).
 		Logf("StressRelief parameters")
 
@@ -223,6 +243,7 @@ func (s *StressRelief) Recalc() uint {
 	defer s.lock.Unlock()
 
 	s.overallStressLevel = clusterStressLevel
+	s.stressLevel = level
 	s.reason = reason
 	s.formula = formula
 
@@ -232,6 +253,12 @@ func (s *StressRelief) Recalc() uint {
 	case Always:
 		s.stressed = true
 	case Monitor:
+		// Queues fill up right after startup, before everything is running
+		// at full speed, so don't let that activate it.
+		if s.warmingUp() {
+			s.stressed = false
+			break
+		}
 		// If it's off, should we activate it?
 		if !s.stressed && s.overallStressLevel >= s.activateLevel {
 			s.stressed = true
@@ -268,6 +295,19 @@ func (s *StressRelief) Recalc() uint {
 	return uint(level)
 }
 
+// WarmingUp indicates whether this node started less than the Warmup duration
+// ago, so that stress relief can't activate yet.
+func (s *StressRelief) WarmingUp() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.warmingUp()
+}
+
+// warmingUp is WarmingUp for callers that hold the lock.
+func (s *StressRelief) warmingUp() bool {
+	return s.warmup > 0 && s.Clock.Since(s.startTime) < s.warmup
+}
+
 // Stressed() indicates whether the system should act as if it's stressed.
 func (s *StressRelief) Stressed() bool {
 	s.lock.RLock()
@@ -275,13 +315,19 @@ func (s *StressRelief) Stressed() bool {
 	return s.stressed
 }
 
+func (s *StressRelief) StressLevel() uint {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.stressLevel
+}
+
 func (s *StressRelief) GetSampleRate(traceID string) (rate uint, keep bool, reason string) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	if s.sampleRate <= 1 {
 		return 1, true, "stress_relief/always"
 	}
-	hash := wyhash.Hash([]byte(traceID), hashSeed)
+	hash := s.hashTraceID(traceID)
 	return uint(s.sampleRate), hash <= s.upperBound, "stress_relief/deterministic/" + s.reason
 }
 
@@ -290,11 +336,22 @@ func (s *StressRelief) GetSampleRate(traceID string) (rate uint, keep bool, reas
 // to get a percentage. If the percentage is less than the deterministic fraction, it returns true.
 func (s *StressRelief) ShouldSampleDeterministically(traceID string) bool {
 	samplePercentage := s.deterministicFraction()
-	hash := wyhash.Hash([]byte(traceID), hashSeed)
+	s.lock.RLock()
+	hash := s.hashTraceID(traceID)
+	s.lock.RUnlock()
 
 	return float64(hash)/float64(math.MaxUint64)*100 < float64(samplePercentage)
 }
 
+// hashTraceID hashes a trace ID with the configured algorithm. The caller must
+// hold the lock.
+func (s *StressRelief) hashTraceID(traceID string) uint64 {
+	if s.hash == nil {
+		return hashAlgorithms[defaultHashAlgorithm](traceID)
+	}
+	return s.hash(traceID)
+}
+
 // deterministicFraction returns the fraction of traces that should be deterministic sampled
 // It calculates the result by using the stress level as the fraction between the activation
 // level and 100%. The result is rounded to the nearest integer.
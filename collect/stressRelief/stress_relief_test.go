@@ -109,6 +109,7 @@ func TestStressRelief_Peer(t *testing.T) {
 		PeerStore: peer.PeerStore{
 			Gossip: channel,
 			Clock:  clock,
+			Config: &config.MockConfig{},
 		},
 	}
 	peer2.Start()
@@ -137,6 +138,46 @@ func TestStressRelief_Peer(t *testing.T) {
 	}, 2*time.Second, 100*time.Millisecond, "stress relief should be false")
 }
 
+// TestStressRelief_Warmup tests that a queue that fills up right after startup
+// doesn't activate stress relief, and that the node isn't ready until the
+// warmup is over.
+func TestStressRelief_Warmup(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	sr, stop := newStressRelief(t, clock, &gossip.InMemoryGossip{})
+	defer stop()
+
+	sr.UpdateFromConfig(config.StressReliefConfig{
+		Mode:                      "monitor",
+		ActivationLevel:           80,
+		DeactivationLevel:         50,
+		SamplingRate:              2,
+		MinimumActivationDuration: config.Duration(5 * time.Second),
+		Warmup:                    config.Duration(30 * time.Second),
+	})
+	require.NoError(t, sr.Start())
+	defer sr.Stop()
+
+	sr.RefineryMetrics.Register("collector_incoming_queue_length", "gauge")
+	sr.RefineryMetrics.Store("INCOMING_CAP", 1200)
+
+	// the incoming queue fills up as soon as traffic arrives
+	sr.RefineryMetrics.Gauge("collector_incoming_queue_length", 1200)
+	sr.Recalc()
+	require.True(t, sr.WarmingUp())
+	require.False(t, sr.Stressed())
+	require.Eventually(t, func() bool {
+		return sr.Health.(*health.Health).IsAlive() && !sr.Health.(*health.Health).IsReady()
+	}, 2*time.Second, 100*time.Millisecond, "the node shouldn't be ready while warming up")
+
+	clock.Advance(31 * time.Second)
+	sr.Recalc()
+	require.False(t, sr.WarmingUp())
+	require.True(t, sr.Stressed())
+	require.Eventually(t, func() bool {
+		return sr.Health.(*health.Health).IsReady()
+	}, 2*time.Second, 100*time.Millisecond, "the node should be ready after warming up")
+}
+
 // TestStressRelief_Sample tests that traces are sampled deterministically
 // by traceID.
 // The test generates 10000 traceIDs and checks that the sampling rate is
@@ -209,6 +250,7 @@ func newStressRelief(t *testing.T, clock clockwork.Clock, channel gossip.Gossipe
 	peers := &peer.PeerStore{
 		Gossip: channel,
 		Clock:  clock,
+		Config: &config.MockConfig{},
 	}
 	require.NoError(t, channel.Start())
 	require.NoError(t, peers.Start())
@@ -228,3 +270,23 @@ func newStressRelief(t *testing.T, clock clockwork.Clock, channel gossip.Gossipe
 		require.NoError(t, healthCheck.Stop())
 	}
 }
+
+// TestStressRelief_HashAlgorithmsAreStable pins the output of each hash
+// algorithm, because nodes running different versions must agree on them.
+func TestStressRelief_HashAlgorithmsAreStable(t *testing.T) {
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	require.Equal(t, uint64(524610077099021362), hashAlgorithms["wyhash"](traceID))
+	require.Equal(t, uint64(13292644768665065618), hashAlgorithms["fnv1a"](traceID))
+	require.Equal(t, uint64(5001318591426613215), hashAlgorithms["wyhash"]("abc123"))
+	require.Equal(t, uint64(7119243511811735397), hashAlgorithms["fnv1a"]("abc123"))
+
+	sr := &StressRelief{Logger: &logger.NullLogger{}}
+
+	// an unset algorithm uses the default
+	sr.UpdateFromConfig(config.StressReliefConfig{SamplingRate: 2})
+	require.Equal(t, "wyhash", sr.hashAlgorithm)
+	require.Equal(t, hashAlgorithms["wyhash"](traceID), sr.hashTraceID(traceID))
+
+	sr.UpdateFromConfig(config.StressReliefConfig{SamplingRate: 2, HashAlgorithm: "fnv1a"})
+	require.Equal(t, hashAlgorithms["fnv1a"](traceID), sr.hashTraceID(traceID))
+}
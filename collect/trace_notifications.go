@@ -0,0 +1,83 @@
+package collect
+
+import "sync"
+
+// TraceNotification describes a trace that this node has finished with,
+// because its spans were sent or dropped.
+type TraceNotification struct {
+	TraceID string
+	Dataset string
+	Kept    bool
+	// SpanCount is the number of the trace's spans that this node held; other
+	// nodes report the rest.
+	SpanCount int
+}
+
+// traceSubscribers is the set of channels that get a TraceNotification for
+// every trace that leaves the span cache. Its zero value has no subscribers.
+type traceSubscribers struct {
+	mut      sync.RWMutex
+	channels []chan TraceNotification
+	closed   bool
+}
+
+// SubscribeTraces returns a channel that gets a notification for every trace
+// that this node sends or drops, buffered to hold depth of them. The collector
+// never waits for a subscriber: if the channel is full, the notification is
+// dropped and counted in the collector_trace_notifications_dropped metric. The
+// channel is closed when the collector stops.
+func (c *CentralCollector) SubscribeTraces(depth int) <-chan TraceNotification {
+	ch := make(chan TraceNotification, depth)
+	c.traceSubscribers.mut.Lock()
+	defer c.traceSubscribers.mut.Unlock()
+	if c.traceSubscribers.closed {
+		close(ch)
+		return ch
+	}
+	c.traceSubscribers.channels = append(c.traceSubscribers.channels, ch)
+	return ch
+}
+
+// evictTrace removes the trace from the span cache, after telling any
+// subscribers whether it was kept.
+func (c *CentralCollector) evictTrace(traceID string, kept bool) {
+	c.notifyTrace(traceID, kept)
+	c.SpanCache.Remove(traceID)
+}
+
+func (c *CentralCollector) notifyTrace(traceID string, kept bool) {
+	c.traceSubscribers.mut.RLock()
+	defer c.traceSubscribers.mut.RUnlock()
+	if len(c.traceSubscribers.channels) == 0 {
+		return
+	}
+	trace := c.SpanCache.Get(traceID)
+	if trace == nil {
+		return
+	}
+	n := TraceNotification{
+		TraceID:   trace.TraceID,
+		Dataset:   trace.Dataset,
+		Kept:      kept,
+		SpanCount: len(trace.GetSpans()),
+	}
+	for _, ch := range c.traceSubscribers.channels {
+		select {
+		case ch <- n:
+		default:
+			c.Metrics.Increment("collector_trace_notifications_dropped")
+		}
+	}
+}
+
+// closeTraceSubscribers closes the subscribers' channels once nothing more
+// will be sent to them.
+func (c *CentralCollector) closeTraceSubscribers() {
+	c.traceSubscribers.mut.Lock()
+	defer c.traceSubscribers.mut.Unlock()
+	for _, ch := range c.traceSubscribers.channels {
+		close(ch)
+	}
+	c.traceSubscribers.channels = nil
+	c.traceSubscribers.closed = true
+}
@@ -0,0 +1,64 @@
+package collect
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect/cache"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCentralCollector_SubscribeTraces(t *testing.T) {
+	conf := &config.MockConfig{
+		GetCollectionConfigVal: config.CollectionConfig{CacheCapacity: 100},
+	}
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	spanCache := &cache.SpanCache_basic{Cfg: conf, Clock: clockwork.NewFakeClock(), Metrics: mockMetrics}
+	require.NoError(t, spanCache.Start())
+	coll := &CentralCollector{
+		Config:    conf,
+		Logger:    &logger.NullLogger{},
+		Metrics:   mockMetrics,
+		SpanCache: spanCache,
+	}
+
+	// evicting traces without subscribers is fine
+	coll.evictTrace("missing", true)
+
+	fast := coll.SubscribeTraces(10)
+	slow := coll.SubscribeTraces(1)
+
+	for _, id := range []string{"kept", "kept", "dropped"} {
+		require.NoError(t, spanCache.Set(&types.Span{
+			TraceID: id,
+			Event:   types.Event{Dataset: "aoeu", Data: map[string]interface{}{}},
+		}))
+	}
+	coll.evictTrace("kept", true)
+	coll.evictTrace("dropped", false)
+	coll.evictTrace("missing", false)
+	assert.Nil(t, spanCache.Get("kept"))
+	assert.Nil(t, spanCache.Get("dropped"))
+
+	require.Len(t, fast, 2)
+	assert.Equal(t, TraceNotification{TraceID: "kept", Dataset: "aoeu", Kept: true, SpanCount: 2}, <-fast)
+	assert.Equal(t, TraceNotification{TraceID: "dropped", Dataset: "aoeu", Kept: false, SpanCount: 1}, <-fast)
+
+	// the slow subscriber's second notification was dropped rather than
+	// blocking the collector
+	require.Len(t, slow, 1)
+	assert.Equal(t, "kept", (<-slow).TraceID)
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["collector_trace_notifications_dropped"])
+
+	coll.closeTraceSubscribers()
+	_, ok := <-fast
+	assert.False(t, ok)
+	_, ok = <-coll.SubscribeTraces(1)
+	assert.False(t, ok, "subscribing after the collector stops gets a closed channel")
+}
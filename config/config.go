@@ -27,6 +27,14 @@ type Config interface {
 	// incoming events
 	GetListenAddr() string
 
+	// GetUnixSocketPath returns the path of a Unix domain socket on which to
+	// listen for incoming events, in addition to ListenAddr; empty means none
+	GetUnixSocketPath() string
+
+	// GetOTLPListenAddr returns the address and port on which to listen for
+	// OTLP/HTTP requests in addition to ListenAddr; empty means none
+	GetOTLPListenAddr() string
+
 	// GetPeerListenAddr returns the address and port on which to listen for
 	// peer traffic
 	GetPeerListenAddr() string
@@ -34,10 +42,18 @@ type Config interface {
 	// GetHTTPIdleTimeout returns the idle timeout for refinery's HTTP server
 	GetHTTPIdleTimeout() time.Duration
 
+	// GetProxyUnmatchedRequests returns true if requests for paths that Refinery
+	// doesn't handle itself should be forwarded to the upstream API
+	GetProxyUnmatchedRequests() bool
+
 	// GetCompressPeerCommunication will be true if refinery should compress
 	// data before forwarding it to a peer.
 	GetCompressPeerCommunication() bool
 
+	// GetCompressUpstreamCommunication will be true if refinery should
+	// compress data before sending it to Honeycomb.
+	GetCompressUpstreamCommunication() bool
+
 	// GetGRPCEnabled returns or not the GRPC server is enabled.
 	GetGRPCEnabled() bool
 
@@ -45,17 +61,84 @@ type Config interface {
 	// incoming events over gRPC
 	GetGRPCListenAddr() string
 
+	// GetGRPCUnixSocketPath returns the path of a Unix domain socket on which
+	// to listen for incoming events over gRPC; empty means none
+	GetGRPCUnixSocketPath() string
+
+	// GetGRPCReflectionEnabled returns whether the gRPC server reflection
+	// service is registered, so that tools like grpcurl can list its services
+	GetGRPCReflectionEnabled() bool
+
 	// Returns the entire GRPC config block
 	GetGRPCConfig() GRPCServerParameters
 
+	// GetGRPCMaxConcurrentExports returns the maximum number of gRPC export
+	// requests that may be handled at once; 0 means no limit
+	GetGRPCMaxConcurrentExports() int
+
+	// GetGRPCMaxConnections returns the maximum number of gRPC connections
+	// that may be open at once; 0 means no limit
+	GetGRPCMaxConnections() int
+
 	// IsAPIKeyValid checks if the given API key is valid according to the rules
 	IsAPIKeyValid(key string) bool
 
+	// GetAllowedDatasets returns the list of dataset name patterns that
+	// events are accepted for; an empty list means all datasets are allowed
+	GetAllowedDatasets() []string
+
+	// GetDeniedDatasets returns the list of dataset name patterns that events
+	// are rejected for, even if they also match GetAllowedDatasets
+	GetDeniedDatasets() []string
+
+	// GetAPIKeyQueryParam returns the name of a URL query parameter that may
+	// carry the API key when no API key header is present; empty means disabled
+	GetAPIKeyQueryParam() string
+
+	// GetAdditionalAPIKeyHeaders returns the names of headers, in priority
+	// order, that may carry the API key when the standard headers are absent
+	GetAdditionalAPIKeyHeaders() []string
+
+	// GetEnvironmentOverrideHeader returns the name of a request header that,
+	// when present, supplies the environment name instead of looking it up
+	// from the API key; empty means disabled
+	GetEnvironmentOverrideHeader() string
+
+	// GetRejectMissingAPIKey returns true if ingest requests that carry no API
+	// key should be rejected instead of processed
+	GetRejectMissingAPIKey() bool
+
+	// GetAPIKeyValidationCacheTTL returns how long a result of IsAPIKeyValid
+	// that accepted a key is cached; 0 means it isn't cached
+	GetAPIKeyValidationCacheTTL() time.Duration
+
+	// GetAPIKeyValidationNegativeCacheTTL returns how long a result of
+	// IsAPIKeyValid that rejected a key is cached; 0 means it isn't cached
+	GetAPIKeyValidationNegativeCacheTTL() time.Duration
+
+	// GetUpstreamAPIKeyForDest returns the API key to send upstream with
+	// events for the named environment or dataset, or the empty string if
+	// the client's own key should be used
+	GetUpstreamAPIKeyForDest(dest string) string
+
 	// GetPeers returns a list of other servers participating in this proxy cluster
 	GetPeers() []string
 
 	GetPeerManagementType() string
 
+	// GetMaxPeers returns the most peers that are counted, including this
+	// one, or 0 if there's no limit.
+	GetMaxPeers() int
+
+	// GetMaxPeerListShrinkPercent returns the largest drop in the number of
+	// peers, as a percentage, that's accepted without confirmation, or 0 if
+	// every drop is accepted right away.
+	GetMaxPeerListShrinkPercent() int
+
+	// GetPeerListShrinkConfirmations returns the number of consecutive polls
+	// that must see a larger drop before it's accepted.
+	GetPeerListShrinkConfirmations() int
+
 	// GetRedisHost returns the address of a Redis instance to use for peer
 	// management.
 	GetRedisHost() string
@@ -94,10 +177,81 @@ type Config interface {
 
 	GetRedisMetricsCycleRate() time.Duration
 
+	// GetTLSMinVersion returns the minimum TLS version, as a crypto/tls
+	// constant, for every TLS connection Refinery makes
+	GetTLSMinVersion() uint16
+
+	// GetTLSCipherSuites returns the crypto/tls IDs of the cipher suites
+	// allowed for TLS 1.2 connections, or nil to use Go's defaults
+	GetTLSCipherSuites() []uint16
+
+	// GetConsulPeerManagementConfig returns the config for finding peers
+	// through Consul, used when the peer management type is "consul"
+	GetConsulPeerManagementConfig() ConsulPeerManagementConfig
+
 	// GetHoneycombAPI returns the base URL (protocol, hostname, and port) of
 	// the upstream Honeycomb API server
 	GetHoneycombAPI() string
 
+	// GetSlowRequestThreshold returns how long a request may run before
+	// we warn that it's slow; 0 disables the warning
+	GetSlowRequestThreshold() time.Duration
+
+	// GetHoneycombAPIForEnvironment returns the upstream API URL configured
+	// for the named environment, or GetHoneycombAPI if there isn't one
+	GetHoneycombAPIForEnvironment(env string) string
+
+	// GetHoneycombAPISRVName returns the DNS SRV record name to resolve for
+	// upstream hosts if HoneycombAPI uses an srv+ scheme, or the empty string
+	GetHoneycombAPISRVName() string
+
+	// GetUpstreamSRVRefreshInterval returns how often the upstream SRV record
+	// is re-resolved
+	GetUpstreamSRVRefreshInterval() time.Duration
+
+	// GetUpstreamMaxIdleConns returns the maximum number of idle connections
+	// to keep open to the upstream API; 0 means no limit
+	GetUpstreamMaxIdleConns() int
+
+	// GetUpstreamMaxIdleConnsPerHost returns the maximum number of idle
+	// connections to keep open to each upstream host; 0 means Go's default of 2
+	GetUpstreamMaxIdleConnsPerHost() int
+
+	// GetUpstreamIdleConnTimeout returns how long an idle connection to the
+	// upstream API is kept open; 0 means forever
+	GetUpstreamIdleConnTimeout() time.Duration
+
+	// GetUpstreamMaxConnLifetime returns how long a connection to the upstream
+	// API may stay open before it's closed and replaced; 0 means forever
+	GetUpstreamMaxConnLifetime() time.Duration
+
+	// GetUpstreamProxyURL returns the proxy that outbound HTTP connections go
+	// through; empty means the proxy environment variables are used instead
+	GetUpstreamProxyURL() string
+
+	// GetUpstreamNoProxy returns the hosts that are reached directly, rather
+	// than through the UpstreamProxyURL
+	GetUpstreamNoProxy() []string
+
+	// GetUpstreamHealthCheckEnabled returns whether readiness should depend on
+	// being able to reach the upstream API
+	GetUpstreamHealthCheckEnabled() bool
+
+	// GetUpstreamHealthCheckInterval returns how often the upstream API is checked
+	GetUpstreamHealthCheckInterval() time.Duration
+
+	// GetUpstreamHealthCheckFailureThreshold returns how many checks in a row
+	// must fail before Refinery reports that it isn't ready
+	GetUpstreamHealthCheckFailureThreshold() int
+
+	// GetUpstreamCircuitBreakerThreshold returns how many sends in a row must
+	// fail before events are dropped instead of queued; 0 disables this
+	GetUpstreamCircuitBreakerThreshold() int
+
+	// GetUpstreamCircuitBreakerCooldown returns how long events are dropped
+	// for before a send is tried again
+	GetUpstreamCircuitBreakerCooldown() time.Duration
+
 	// GetSendDelay returns the number of seconds to pause after a trace is
 	// complete before sending it, to allow stragglers to arrive
 	GetSendDelay() time.Duration
@@ -113,6 +267,27 @@ type Config interface {
 	// GetMaxBatchSize is the number of events to be included in the batch for sending
 	GetMaxBatchSize() uint
 
+	// GetBatchingForDestination returns the batch size and timeout for events
+	// sent to dataset in environment, which are the global settings unless
+	// BatchingByDestination overrides them
+	GetBatchingForDestination(environment, dataset string) (uint, time.Duration)
+
+	// GetMaxEffectiveSampleRate is the highest sample rate that a sampling
+	// decision can use; 0 means there's no limit
+	GetMaxEffectiveSampleRate() uint
+
+	// GetMaxTraceAge is the longest that a trace may be buffered before it's
+	// evicted, whatever else happens to it; 0 means there's no limit
+	GetMaxTraceAge() time.Duration
+
+	// GetMaxTraceAgeAction is what happens to a trace evicted by MaxTraceAge:
+	// "send" or "drop"
+	GetMaxTraceAgeAction() string
+
+	// GetUpstreamSendConcurrency is the number of batches that can be sent
+	// upstream at once
+	GetUpstreamSendConcurrency() uint
+
 	// GetLoggerType returns the type of the logger to use. Valid types are in
 	// the logger package
 	GetLoggerType() string
@@ -120,6 +295,10 @@ type Config interface {
 	// GetLoggerLevel returns the level of the logger to use.
 	GetLoggerLevel() Level
 
+	// GetDebugLogSampleRate returns N such that the router writes its debug
+	// logs for only 1 in N requests
+	GetDebugLogSampleRate() int
+
 	// GetHoneycombLoggerConfig returns the config specific to the HoneycombLogger
 	GetHoneycombLoggerConfig() HoneycombLoggerConfig
 
@@ -133,6 +312,10 @@ type Config interface {
 	// the given destination (environment, or dataset in classic)
 	GetSamplerConfigForDestName(string) (interface{}, string, error)
 
+	// GetSamplerResolution explains how GetSamplerConfigForDestName chooses
+	// the sampler for the given destination
+	GetSamplerResolution(string) SamplerResolution
+
 	// GetAllSamplerRules returns all rules in a single map, including the default rules
 	GetAllSamplerRules() *V2SamplerConfig
 
@@ -154,6 +337,12 @@ type Config interface {
 	// GetPeerBufferSize returns the size of the libhoney buffer to use for the peer forwarding
 	// libhoney client
 	GetPeerBufferSize() int
+	// GetUpstreamSpillPath returns the directory where events are kept while
+	// the upstream buffer is full; empty means that they aren't
+	GetUpstreamSpillPath() string
+	// GetUpstreamSpillMaxSize returns the most disk space, in bytes, that
+	// the spill may use
+	GetUpstreamSpillMaxSize() int64
 
 	GetIdentifierInterfaceName() string
 
@@ -170,17 +359,130 @@ type Config interface {
 
 	GetIsDryRun() bool
 
+	// GetIsDryRunForEnvironment returns whether traces from env are sampled in
+	// dry run mode, which is DryRunByEnvironment's setting for env if it has
+	// one, and DryRun otherwise.
+	GetIsDryRunForEnvironment(env string) bool
+
+	// GetDroppedSampleRetention returns how many dropped traces are retained
+	// for /query/dropped-samples; 0 means none are
+	GetDroppedSampleRetention() int
+
+	// GetDroppedSampleRate returns N, where one in N dropped traces is
+	// retained
+	GetDroppedSampleRate() int
+
+	// GetDebugTraceSinkEnabled returns whether kept traces are written to the
+	// debug trace sink
+	GetDebugTraceSinkEnabled() bool
+
+	// GetDebugTraceSinkFraction returns the fraction of kept traces that are
+	// written to the debug trace sink
+	GetDebugTraceSinkFraction() float64
+
+	// GetDebugTraceSinkPath returns the file the debug trace sink writes to,
+	// or "" for stdout
+	GetDebugTraceSinkPath() string
+
+	// GetDebugEndpointsEnabled returns whether routes that deliberately
+	// misbehave, like /panic, are served
+	GetDebugEndpointsEnabled() bool
+
 	GetAddHostMetadataToTrace() bool
 
+	// GetAddNodeMetadataToTrace returns true if incoming events should be
+	// tagged with the identifier of the node that received them
+	GetAddNodeMetadataToTrace() bool
+
+	// GetAddIngestLagField returns true if incoming events should be tagged
+	// with how long ago their timestamp was when they arrived
+	GetAddIngestLagField() bool
+
+	// GetAllowNegativeIngestLag returns true if the ingest lag of events
+	// timestamped in the future should be recorded as negative rather than 0
+	GetAllowNegativeIngestLag() bool
+
 	GetAddRuleReasonToTrace() bool
 
+	// GetPreserveIncomingSampleRate returns true if incoming events should
+	// keep the sample rate they arrived with in a metadata field
+	GetPreserveIncomingSampleRate() bool
+
+	// GetMetricsPerEnvironmentEnabled returns true if ingest counters should
+	// also be recorded per environment
+	GetMetricsPerEnvironmentEnabled() bool
+
+	// GetMaxMetricsEnvironments returns the maximum number of distinct
+	// environments tracked by per-environment metrics
+	GetMaxMetricsEnvironments() int
+
 	GetEnvironmentCacheTTL() time.Duration
 
+	// GetMaxSpansPerTrace returns the maximum number of spans buffered for a
+	// single trace; 0 means no limit
+	GetMaxSpansPerTrace() int
+
+	// GetIntraTraceSamplingThreshold returns the number of spans a trace can
+	// have before only some of its further spans are kept; 0 means all are
+	GetIntraTraceSamplingThreshold() int
+
+	// GetIntraTraceSampleRate returns N, where 1 in N of a trace's spans
+	// beyond the intra-trace sampling threshold are kept
+	GetIntraTraceSampleRate() int
+
+	// GetMaxIncomingQueueWait returns how long a span may wait in the
+	// collector's incoming queue before it's dropped; 0 means no limit
+	GetMaxIncomingQueueWait() time.Duration
+
+	// GetMaxConcurrentAuthLookups returns the maximum number of environment
+	// lookups that may be in progress at once; 0 means no limit
+	GetMaxConcurrentAuthLookups() int
+
+	// GetAuthLookupWaitTimeout returns how long an environment lookup waits
+	// for a free slot before it fails
+	GetAuthLookupWaitTimeout() time.Duration
+
+	// GetAuthLookupTimeout returns how long an environment lookup waits for
+	// Honeycomb to answer
+	GetAuthLookupTimeout() time.Duration
+
+	// GetMaxAuthResponseSize returns the largest environment lookup response
+	// that will be read
+	GetMaxAuthResponseSize() MemorySize
+
+	// GetMaxDistinctEnvironments returns the number of distinct environments
+	// that are expected; 0 means no limit
+	GetMaxDistinctEnvironments() int
+
+	// GetRejectExcessEnvironments is true if data for environments beyond
+	// MaxDistinctEnvironments should be rejected rather than just reported
+	GetRejectExcessEnvironments() bool
+
+	// GetMaxDistinctDatasets returns the number of distinct datasets that
+	// data is accepted for; 0 means no limit
+	GetMaxDistinctDatasets() int
+
+	// GetCatchAllDataset returns the dataset that events for datasets beyond
+	// MaxDistinctDatasets are sent to; if empty, they're rejected
+	GetCatchAllDataset() string
+
+	// GetZstdDecoderWaitTimeout returns how long a zstd-compressed request
+	// waits for a free decoder before it fails
+	GetZstdDecoderWaitTimeout() time.Duration
+
+	// GetMaxConcurrentDecompressions returns the number of compressed request
+	// bodies that may be decompressed at once; 0 means no limit
+	GetMaxConcurrentDecompressions() int
+
 	GetDatasetPrefix() string
 
 	// GetQueryAuthToken returns the token that must be used to access the /query endpoints
 	GetQueryAuthToken() string
 
+	// GetQueryAuthTokens returns further tokens for the /query endpoints,
+	// each with the scope it's limited to
+	GetQueryAuthTokens() map[string]QueryScope
+
 	GetPeerTimeout() time.Duration
 
 	GetAdditionalErrorFields() []string
@@ -189,18 +491,231 @@ type Config interface {
 
 	GetAddCountsToRoot() bool
 
+	// GetAddTraceDurationToRoot is true if the trace's wall-clock duration
+	// should be added to its root span.
+	GetAddTraceDurationToRoot() bool
+
+	// GetAddServiceCountToRoot is true if the number of distinct services in
+	// the trace should be added to its root span.
+	GetAddServiceCountToRoot() bool
+
 	GetConfigMetadata() []ConfigMetadata
 
 	GetSampleCacheConfig() SampleCacheConfig
 
 	GetStressReliefConfig() StressReliefConfig
 
+	// GetStressReliefHashAlgorithm returns the name of the hash that stress
+	// relief applies to trace IDs to choose which traces to keep
+	GetStressReliefHashAlgorithm() string
+
 	GetAdditionalAttributes() map[string]string
 
+	// GetAdditionalAttributesForEnvironment returns the AdditionalAttributes
+	// with the environment's own attributes merged over them
+	GetAdditionalAttributesForEnvironment(env string) map[string]string
+
+	// GetDecodeJSONNumbers returns true if numbers in incoming JSON events
+	// should be decoded as json.Number instead of float64
+	GetDecodeJSONNumbers() bool
+
+	// GetStrictContentType returns true if requests to the event and batch
+	// endpoints must have a Content-Type that Refinery can decode
+	GetStrictContentType() bool
+
+	// GetVerifyBodyChecksum returns true if the bodies of event and batch
+	// requests are checked against their X-Content-SHA256 header
+	GetVerifyBodyChecksum() bool
+
+	// GetBodyChecksumStage returns "decompressed" or "compressed", which is
+	// the form of the body that VerifyBodyChecksum checks
+	GetBodyChecksumStage() string
+
+	// GetJaegerDefaultDataset returns the dataset for Jaeger spans whose
+	// process has no service name
+	GetJaegerDefaultDataset() string
+
+	// GetDefaultDataset returns the dataset for events and OTLP requests that
+	// don't name one, or "" if they should be rejected
+	GetDefaultDataset() string
+
+	// GetDegradedLoadThreshold returns the load score at which /ready reports
+	// that the node is degraded; 0 means that it never does
+	GetDegradedLoadThreshold() int
+
+	// GetMaxSpanAttributes returns the maximum number of fields an event may
+	// have, or 0 for no limit
+	GetMaxSpanAttributes() int
+
+	// GetMaxSpanBytes returns the maximum approximate size of an event's
+	// fields, or 0 for no limit
+	GetMaxSpanBytes() int
+
+	// GetMaxFieldValueLength returns the length, in bytes, that longer string
+	// field values are truncated to, or 0 for no limit
+	GetMaxFieldValueLength() int
+
+	// GetMaxFieldValueLengths returns the limits that replace
+	// MaxFieldValueLength for individual fields
+	GetMaxFieldValueLengths() map[string]int
+
+	// GetMaxRequestBodySize returns the largest event or batch request body
+	// that is read, after decompression
+	GetMaxRequestBodySize() int
+
+	// GetMaxOTLPEventsPerRequest returns the maximum number of events that
+	// are processed from a single OTLP request, or 0 for no limit
+	GetMaxOTLPEventsPerRequest() int
+
+	// GetOversizedSpanAction returns "truncate" or "reject", controlling what
+	// happens to events that exceed the span limits
+	GetOversizedSpanAction() string
+
+	// GetEmptyTraceIDAction returns "passthrough", "reject", or "generate",
+	// controlling what happens to events whose trace ID field is empty
+	GetEmptyTraceIDAction() string
+
+	// GetInvalidOTLPIDAction returns "accept", "drop", or "nontrace", which is
+	// what happens to OTLP spans with zero or malformed trace or span IDs
+	GetInvalidOTLPIDAction() string
+
+	// GetNonTraceEventHandling returns "forward", "drop", or "route",
+	// controlling what happens to events that aren't part of a trace
+	GetNonTraceEventHandling() string
+
+	// GetNonTraceEventDataset returns the dataset that events that aren't
+	// part of a trace are sent to when NonTraceEventHandling is "route"
+	GetNonTraceEventDataset() string
+
+	// GetOnEnvironmentLookupFailure returns "reject" or "route", controlling
+	// what happens to events whose API key's environment couldn't be looked up
+	GetOnEnvironmentLookupFailure() string
+
+	// GetEnvironmentLookupFailureDataset returns the dataset that events are
+	// sent to when OnEnvironmentLookupFailure is "route"
+	GetEnvironmentLookupFailureDataset() string
+
+	// GetMaxEventTimeSkewFuture returns how far ahead of now an event's
+	// timestamp may be; 0 means there's no limit
+	GetMaxEventTimeSkewFuture() time.Duration
+
+	// GetMaxEventTimeSkewPast returns how far behind now an event's timestamp
+	// may be; 0 means there's no limit
+	GetMaxEventTimeSkewPast() time.Duration
+
+	// GetEventTimeSkewAction returns "clamp" or "reject", controlling what
+	// happens to events whose timestamps are outside those limits
+	GetEventTimeSkewAction() string
+
+	// GetEventTimeSources returns the sources of an event's timestamp, in
+	// order of precedence; see EventTimeSources
+	GetEventTimeSources() []string
+
+	// GetOTLPResourceAttributeAllowlist returns the patterns of the OTLP
+	// resource attributes that are copied onto events; empty means all of them
+	GetOTLPResourceAttributeAllowlist() []string
+
+	// GetRedactedFields returns the list of field name patterns that are
+	// removed from every event before it is buffered or sent
+	GetRedactedFields() []string
+
+	// GetRedactionPlaceholder returns the value that replaces redacted
+	// fields, or "" if they should be deleted instead
+	GetRedactionPlaceholder() string
+
+	// GetMaxLoggedBodyBytes returns how much of a request body that fails to
+	// parse is included in the debug log
+	GetMaxLoggedBodyBytes() int
+
+	// GetRedactLoggedBodies is true if the values of RedactedFields are
+	// replaced in request bodies that are logged
+	GetRedactLoggedBodies() bool
+
+	// GetForceKeepConditions returns the conditions that make a span's trace
+	// be kept at a sample rate of 1, whatever the sampler would decide
+	GetForceKeepConditions() []ForceKeepCondition
+
+	// GetKeepErrorTraces is true if traces with a span that matches the
+	// ErrorTraceCondition are always kept
+	GetKeepErrorTraces() bool
+
+	// GetErrorTraceCondition returns the condition that marks a span as an
+	// error
+	GetErrorTraceCondition() ForceKeepCondition
+
+	// GetSyntheticTraceCondition returns the condition that marks a span's
+	// trace as synthetic, and false if there isn't one
+	GetSyntheticTraceCondition() (ForceKeepCondition, bool)
+
+	// GetTraceCompleteCondition returns the condition that marks the span
+	// that completes its trace, and false if there isn't one
+	GetTraceCompleteCondition() (ForceKeepCondition, bool)
+
+	// GetDatasetTransforms returns the DatasetTransforms entries, which are
+	// parsed with ParseDatasetTransform
+	GetDatasetTransforms() []string
+
+	// GetDatasetRoutingField returns the field whose value decides the
+	// dataset that a trace is sent to, or "" if there isn't one
+	GetDatasetRoutingField() string
+
+	// GetDatasetRoutingTemplate returns the template that makes a dataset
+	// name from the value of the DatasetRoutingField
+	GetDatasetRoutingTemplate() string
+
+	// GetDatasetShards returns the number of physical datasets that each
+	// sharded dataset's traces are spread across
+	GetDatasetShards() map[string]int
+
+	// GetSamplerKeyNormalizations returns the SamplerKeyNormalizations
+	// entries, which are parsed with ParseKeyNormalization
+	GetSamplerKeyNormalizations() []string
+
+	// GetFieldTypeCoercions returns the fields whose values are converted to
+	// another type as events arrive, mapped to the name of that type
+	GetFieldTypeCoercions() map[string]string
+
+	// GetStoreNormalizedValues returns whether the values normalized by
+	// SamplerKeyNormalizations replace the originals in the event
+	GetStoreNormalizedValues() bool
+
 	GetTraceIdFieldNames() []string
 
+	// GetTraceIdHeaders returns a map of request header names that may carry
+	// a trace ID to the regular expression used to extract it
+	GetTraceIdHeaders() map[string]string
+
+	// GetTraceIdConflictAction returns "ignore", "warn", or "prefer",
+	// controlling what happens when an event has more than one trace ID field
+	// and their values differ
+	GetTraceIdConflictAction() string
+
+	// GetPreferredTraceIdFieldName returns the trace ID field that wins a
+	// conflict when the conflict action is "prefer"
+	GetPreferredTraceIdFieldName() string
+
 	GetParentIdFieldNames() []string
 
+	GetSpanIdFieldNames() []string
+
+	// GetOTLPFieldMappings returns the field names used to normalize span
+	// status and trace_state values on events converted from OTLP
+	GetOTLPFieldMappings() OTLPFieldMappingsConfig
+
+	// GetDecisionWebhookConfig returns the config for the webhook that is
+	// notified of sampling decisions
+	GetDecisionWebhookConfig() DecisionWebhookConfig
+
+	// GetMirrorAllSpans is true if an unsampled copy of every incoming event
+	// should be sent to the mirror
+	GetMirrorAllSpans() bool
+
+	// GetMirrorConfig returns the config for the mirror
+	GetMirrorConfig() MirrorConfig
+
+	// GetShadowConfig returns the config for the shadow upstream
+	GetShadowConfig() ShadowConfig
+
 	GetCentralStoreOptions() SmartWrapperOptions
 }
 
@@ -211,6 +726,9 @@ type ConfigMetadata struct {
 	ID       string `json:"id"`
 	Hash     string `json:"hash"`
 	LoadedAt string `json:"loaded_at"`
+	// ValidationErrors are the problems that kept the most recent reload of
+	// the file from being used; the previously loaded version is still in use
+	ValidationErrors []string `json:"validation_errors,omitempty"`
 }
 
 type RedisConfig interface {
@@ -244,6 +762,10 @@ type RedisConfig interface {
 	// UseTLSInsecure returns true when certificate checks are disabled
 	GetUseTLSInsecure() bool
 
+	GetTLSMinVersion() uint16
+
+	GetTLSCipherSuites() []uint16
+
 	GetRedisMaxIdle() int
 
 	GetRedisMaxActive() int
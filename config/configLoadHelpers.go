@@ -7,15 +7,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/creasty/defaults"
 	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
 
@@ -185,9 +190,82 @@ func validateConfig(opts *CmdEnv) ([]string, error) {
 
 	// and finally validate the rewritten config
 	failures = metadata.Validate(rewrittenUserData)
+	failures = append(failures, validatePeerListenAddr(&config)...)
+	failures = append(failures, validatePreferredTraceName(&config)...)
 	return failures, nil
 }
 
+// validatePreferredTraceName checks that the field preferred in a trace ID
+// conflict is one of the trace ID fields.
+func validatePreferredTraceName(config *configContents) []string {
+	ids := config.IDFieldNames
+	if ids.TraceNameConflictAction != "prefer" {
+		return nil
+	}
+	if ids.PreferredTraceName == "" {
+		return []string{"PreferredTraceName must be set when TraceNameConflictAction is prefer"}
+	}
+	if !slices.Contains(ids.TraceNames, ids.PreferredTraceName) {
+		return []string{fmt.Sprintf("PreferredTraceName %s is not one of the TraceNames %v", ids.PreferredTraceName, ids.TraceNames)}
+	}
+	return nil
+}
+
+// validatePeerListenAddr checks that the peer address can't be confused with
+// any of the addresses that we accept incoming traffic on.
+func validatePeerListenAddr(config *configContents) []string {
+	peerAddr := config.Network.PeerListenAddr
+	if peerAddr == "" {
+		return nil
+	}
+	var failures []string
+	incoming := map[string]string{
+		"Network.ListenAddr":              config.Network.ListenAddr,
+		"Network.OTLPListenAddr":          config.Network.OTLPListenAddr,
+		"GRPCServerParameters.ListenAddr": config.GRPCServerParameters.ListenAddr,
+	}
+	for name, addr := range incoming {
+		if listenAddrsCollide(peerAddr, addr) {
+			failures = append(failures, fmt.Sprintf("field Network.PeerListenAddr (%s) collides with %s (%s)", peerAddr, name, addr))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+// listenAddrsCollide reports whether listening on both a and b would bind the
+// same port on the same interface. An empty or unspecified host binds every
+// interface, so it collides with any host on the same port.
+func listenAddrsCollide(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	if isWildcardHost(hostA) || isWildcardHost(hostB) {
+		return true
+	}
+	// compare addresses instead of strings so that equivalent spellings,
+	// including their interface zones, match
+	addrA, errA := netip.ParseAddr(hostA)
+	addrB, errB := netip.ParseAddr(hostB)
+	if errA == nil && errB == nil {
+		return addrA == addrB
+	}
+	return strings.EqualFold(hostA, hostB)
+}
+
+func isWildcardHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsUnspecified()
+}
+
 func validateRules(location string) ([]string, error) {
 	r, format, err := getReaderFor(location)
 	if err != nil {
@@ -209,6 +287,62 @@ func validateRules(location string) ([]string, error) {
 	return failures, nil
 }
 
+// validateSamplers checks the loaded rules for problems that the metadata
+// can't catch, such as a condition whose regexp doesn't compile, so that
+// they're reported when the rules are loaded instead of when the first
+// matching trace arrives. Each failure names the sampler and rule it's in.
+func validateSamplers(rules *V2SamplerConfig) []string {
+	if rules == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(rules.Samplers))
+	for k := range rules.Samplers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var failures []string
+	for _, key := range keys {
+		choice := rules.Samplers[key]
+		if choice == nil {
+			failures = append(failures, fmt.Sprintf("Samplers.%s has no sampler", key))
+			continue
+		}
+		sampler, name := choice.Sampler()
+		if sampler == nil {
+			failures = append(failures, fmt.Sprintf("Samplers.%s has no known sampler type", key))
+			continue
+		}
+		rb, ok := sampler.(*RulesBasedSamplerConfig)
+		if !ok {
+			continue
+		}
+		for i, rule := range rb.Rules {
+			if rule == nil {
+				continue
+			}
+			location := fmt.Sprintf("Samplers.%s.%s rule %d (%q)", key, name, i+1, rule.Name)
+			for j, cond := range rule.Conditions {
+				if cond == nil {
+					continue
+				}
+				// Init modifies the condition, and the sampler will run it
+				// again when it starts, so check a copy
+				c := *cond
+				if err := c.Init(); err != nil {
+					failures = append(failures, fmt.Sprintf("%s condition %d: %s", location, j+1, err))
+				}
+			}
+			if ds := rule.Sampler; ds != nil && ds.DynamicSampler == nil && ds.EMADynamicSampler == nil &&
+				ds.EMAThroughputSampler == nil && ds.WindowedThroughputSampler == nil &&
+				ds.TotalThroughputSampler == nil && ds.DeterministicSampler == nil {
+				failures = append(failures, fmt.Sprintf("%s has a downstream Sampler with no known sampler type", location))
+			}
+		}
+	}
+	return failures
+}
+
 // readConfigInto reads the config from the given location and applies it to the given struct.
 func readConfigInto(dest any, location string, opts *CmdEnv) (string, error) {
 	r, format, err := getReaderFor(location)
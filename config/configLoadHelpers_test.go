@@ -147,3 +147,59 @@ func Test_ConfigHashMetrics(t *testing.T) {
 		})
 	}
 }
+
+func Test_listenAddrsCollide(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"different ports", "0.0.0.0:8081", "0.0.0.0:8080", false},
+		{"same address", "10.0.0.1:8081", "10.0.0.1:8081", true},
+		{"wildcard", "0.0.0.0:8081", "10.0.0.1:8081", true},
+		{"empty host", ":8081", "10.0.0.1:8081", true},
+		{"ipv6 wildcard", "10.0.0.1:8081", "[::]:8081", true},
+		{"different interfaces", "10.0.0.1:8081", "192.168.0.1:8081", false},
+		{"same zone", "[fe80::1%eth1]:8081", "[fe80::1%eth1]:8081", true},
+		{"different zones", "[fe80::1%eth1]:8081", "[fe80::1%eth0]:8081", false},
+		{"hostnames", "Peer.example.com:8081", "peer.example.com:8081", true},
+		{"unset", "0.0.0.0:8081", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listenAddrsCollide(tt.a, tt.b); got != tt.want {
+				t.Errorf("listenAddrsCollide(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validatePeerListenAddr(t *testing.T) {
+	config := &configContents{}
+	config.Network.ListenAddr = "0.0.0.0:8080"
+	config.Network.PeerListenAddr = "10.0.0.1:8081"
+	require.Empty(t, validatePeerListenAddr(config))
+
+	config.GRPCServerParameters.ListenAddr = "0.0.0.0:8081"
+	failures := validatePeerListenAddr(config)
+	require.Len(t, failures, 1)
+	require.Contains(t, failures[0], "GRPCServerParameters.ListenAddr")
+}
+
+func Test_validatePreferredTraceName(t *testing.T) {
+	config := &configContents{}
+	config.IDFieldNames.TraceNames = []string{"trace.trace_id", "traceId"}
+	config.IDFieldNames.TraceNameConflictAction = "warn"
+	require.Empty(t, validatePreferredTraceName(config))
+
+	config.IDFieldNames.TraceNameConflictAction = "prefer"
+	require.Len(t, validatePreferredTraceName(config), 1)
+
+	config.IDFieldNames.PreferredTraceName = "traceId"
+	require.Empty(t, validatePreferredTraceName(config))
+
+	config.IDFieldNames.PreferredTraceName = "trace_id"
+	failures := validatePreferredTraceName(config)
+	require.Len(t, failures, 1)
+	require.Contains(t, failures[0], "not one of the TraceNames")
+}
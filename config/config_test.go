@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"strings"
@@ -428,6 +429,35 @@ func TestHTTPIdleTimeout(t *testing.T) {
 	}
 }
 
+func TestUpstreamIdleConns(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 100, c.GetUpstreamMaxIdleConns())
+	assert.Equal(t, 0, c.GetUpstreamMaxIdleConnsPerHost())
+	assert.Equal(t, 90*time.Second, c.GetUpstreamIdleConnTimeout())
+
+	cm = makeYAML("General.ConfigurationVersion", 2,
+		"Network.UpstreamMaxIdleConns", 50,
+		"Network.UpstreamMaxIdleConnsPerHost", 20,
+		"Network.UpstreamIdleConnTimeout", "30s",
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err = getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 50, c.GetUpstreamMaxIdleConns())
+	assert.Equal(t, 20, c.GetUpstreamMaxIdleConnsPerHost())
+	assert.Equal(t, 30*time.Second, c.GetUpstreamIdleConnTimeout())
+}
+
 func TestDryRun(t *testing.T) {
 	cm := makeYAML("General.ConfigurationVersion", 2, "Debugging.DryRun", true)
 	rm := makeYAML("ConfigVersion", 2)
@@ -570,6 +600,73 @@ func TestGetSamplerTypes(t *testing.T) {
 	}
 }
 
+func TestNoDefaultSampler(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML(
+		"ConfigVersion", 2,
+		"Samplers.dataset1.DeterministicSampler.SampleRate", 5,
+	)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	_, _, err = c.GetSamplerConfigForDestName("dataset1")
+	assert.NoError(t, err)
+
+	d, name, err := c.GetSamplerConfigForDestName("nonexistent")
+	assert.ErrorIs(t, err, ErrNoSamplerFound)
+	assert.Nil(t, d)
+	assert.Equal(t, "not found", name)
+}
+
+func TestGetSamplerResolution(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML(
+		"ConfigVersion", 2,
+		"Samplers.__default__.DeterministicSampler.SampleRate", 1,
+		"Samplers.dataset1.DynamicSampler.SampleRate", 5,
+	)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	res := c.GetSamplerResolution("dataset1")
+	assert.True(t, res.Found)
+	assert.False(t, res.UsedDefault)
+	assert.Equal(t, "dataset1", res.Matched)
+	assert.Equal(t, "DynamicSampler", res.Name)
+	assert.Equal(t, []SamplerCandidate{{Key: "dataset1", Present: true}}, res.Checked)
+
+	res = c.GetSamplerResolution("dataset2")
+	assert.True(t, res.Found)
+	assert.True(t, res.UsedDefault)
+	assert.Equal(t, "__default__", res.Matched)
+	assert.Equal(t, "DeterministicSampler", res.Name)
+	assert.Equal(t, []SamplerCandidate{{Key: "dataset2"}, {Key: "__default__", Present: true}}, res.Checked)
+	assert.IsType(t, &DeterministicSamplerConfig{}, res.Sampler)
+
+	rm = makeYAML(
+		"ConfigVersion", 2,
+		"Samplers.dataset1.DynamicSampler.SampleRate", 5,
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err = getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	res = c.GetSamplerResolution("dataset2")
+	assert.False(t, res.Found)
+	assert.False(t, res.UsedDefault)
+	assert.Empty(t, res.Matched)
+	assert.Equal(t, []SamplerCandidate{{Key: "dataset2"}, {Key: "__default__"}}, res.Checked)
+	assert.Contains(t, res.Reason, "no __default__ sampler")
+}
+
 func TestDefaultSampler(t *testing.T) {
 	t.Skip("This tests for a default sampler, but we are currently not requiring explicit default samplers.")
 	cm := makeYAML("General.ConfigurationVersion", 2)
@@ -664,6 +761,8 @@ func TestHoneycombGRPCConfigDefaults(t *testing.T) {
 	assert.Equal(t, 20*time.Second, time.Duration(grpcConfig.KeepAliveTimeout))
 	assert.Equal(t, MemorySize(5*1_000_000), grpcConfig.MaxSendMsgSize)
 	assert.Equal(t, MemorySize(5*1_000_000), grpcConfig.MaxRecvMsgSize)
+	assert.Equal(t, 0, grpcConfig.MaxConcurrentStreams)
+	assert.Equal(t, "none", grpcConfig.ResponseCompression)
 }
 
 func TestStdoutLoggerConfig(t *testing.T) {
@@ -736,6 +835,72 @@ func TestQueryAuthToken(t *testing.T) {
 	assert.Equal(t, "MySeekretToken", c.GetQueryAuthToken())
 }
 
+func TestTLSConfig(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint16(tls.VersionTLS12), c.GetTLSMinVersion())
+	assert.Nil(t, c.GetTLSCipherSuites())
+
+	cm = makeYAML("General.ConfigurationVersion", 2,
+		"TLS.MinVersion", "1.3",
+		"TLS.CipherSuites", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err = getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint16(tls.VersionTLS13), c.GetTLSMinVersion())
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, c.GetTLSCipherSuites())
+}
+
+func TestTLSCipherSuitesValidated(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2, "TLS.CipherSuites", []string{"TLS_NOT_A_CIPHER"})
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err := getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, "TLS_NOT_A_CIPHER")
+}
+
+func TestForceKeepConditions(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2, "Specialized.ForceKeepConditions", []string{"error=true", "force_keep", " http.status_code = 503 "})
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ForceKeepCondition{
+		{Field: "error", Value: "true", HasValue: true},
+		{Field: "force_keep"},
+		{Field: "http.status_code", Value: "503", HasValue: true},
+	}, c.GetForceKeepConditions())
+}
+
+func TestSyntheticTraceCondition(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2, "Specialized.SyntheticTraceCondition", "synthetic=true")
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	cond, ok := c.GetSyntheticTraceCondition()
+	assert.True(t, ok)
+	assert.Equal(t, ForceKeepCondition{Field: "synthetic", Value: "true", HasValue: true}, cond)
+}
+
 func TestGRPCServerParameters(t *testing.T) {
 	cm := makeYAML(
 		"General.ConfigurationVersion", 2,
@@ -744,6 +909,8 @@ func TestGRPCServerParameters(t *testing.T) {
 		"GRPCServerParameters.MaxConnectionAgeGrace", "3m",
 		"GRPCServerParameters.KeepAlive", "4m",
 		"GRPCServerParameters.KeepAliveTimeout", "5m",
+		"GRPCServerParameters.MaxConcurrentStreams", 500,
+		"GRPCServerParameters.ResponseCompression", "gzip",
 		"GRPCServerParameters.ListenAddr", "localhost:4317",
 		"GRPCServerParameters.Enabled", true,
 	)
@@ -761,6 +928,8 @@ func TestGRPCServerParameters(t *testing.T) {
 	assert.Equal(t, 3*time.Minute, time.Duration(gc.MaxConnectionAgeGrace))
 	assert.Equal(t, 4*time.Minute, time.Duration(gc.KeepAlive))
 	assert.Equal(t, 5*time.Minute, time.Duration(gc.KeepAliveTimeout))
+	assert.Equal(t, 500, gc.MaxConcurrentStreams)
+	assert.Equal(t, "gzip", gc.ResponseCompression)
 	assert.Equal(t, true, c.GetGRPCEnabled())
 	addr := c.GetGRPCListenAddr()
 	assert.Equal(t, "localhost:4317", addr)
@@ -847,6 +1016,102 @@ func TestAdditionalAttributes(t *testing.T) {
 	assert.Equal(t, map[string]string{"name": "foo", "other": "bar", "another": "OneHundred"}, c.GetAdditionalAttributes())
 }
 
+func TestAdditionalAttributesForEnvironment(t *testing.T) {
+	cm := makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Specialized.AdditionalAttributes", map[string]string{
+			"cluster": "main",
+			"region":  "unknown",
+		},
+		"Specialized.AdditionalAttributesByEnvironment", map[string]map[string]string{
+			"production": {"region": "us-east-1"},
+		},
+	)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"cluster": "main", "region": "us-east-1"}, c.GetAdditionalAttributesForEnvironment("production"))
+	assert.Equal(t, map[string]string{"cluster": "main", "region": "unknown"}, c.GetAdditionalAttributesForEnvironment("staging"))
+	assert.Equal(t, map[string]string{"cluster": "main", "region": "unknown"}, c.GetAdditionalAttributesForEnvironment(""))
+	// the global map isn't changed by the merge
+	assert.Equal(t, map[string]string{"cluster": "main", "region": "unknown"}, c.GetAdditionalAttributes())
+
+	cm = makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Specialized.AdditionalAttributesByEnvironment", map[string]string{
+			"production": "us-east-1",
+		},
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err = getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, "AdditionalAttributesByEnvironment")
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	cm := makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Network.UpstreamProxyURL", "http://proxy.internal:3128",
+		"Network.UpstreamNoProxy", []string{"localhost", ".internal"},
+	)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "http://proxy.internal:3128", c.GetUpstreamProxyURL())
+	assert.Equal(t, []string{"localhost", ".internal"}, c.GetUpstreamNoProxy())
+
+	cm = makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Network.UpstreamProxyURL", "proxy.internal:3128",
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err = getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, "UpstreamProxyURL")
+}
+
+func TestHoneycombAPIByEnvironment(t *testing.T) {
+	cm := makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Network.HoneycombAPI", "https://api.example.com",
+		"Network.HoneycombAPIByEnvironment", map[string]string{
+			"tenant-a": "https://tenant-a.example.com",
+		},
+	)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "https://tenant-a.example.com", c.GetHoneycombAPIForEnvironment("tenant-a"))
+	assert.Equal(t, "https://api.example.com", c.GetHoneycombAPIForEnvironment("tenant-b"))
+	assert.Equal(t, "https://api.example.com", c.GetHoneycombAPIForEnvironment(""))
+
+	cm = makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Network.HoneycombAPIByEnvironment", map[string]string{
+			"tenant-a": "ftp://tenant-a.example.com",
+		},
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err = getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, "HoneycombAPIByEnvironment")
+}
+
 func TestHoneycombIdFieldsConfig(t *testing.T) {
 	cm := makeYAML(
 		"General.ConfigurationVersion", 2,
@@ -875,6 +1140,7 @@ func TestHoneycombIdFieldsConfigDefault(t *testing.T) {
 
 	assert.Equal(t, []string{"trace.trace_id", "traceId"}, c.GetTraceIdFieldNames())
 	assert.Equal(t, []string{"trace.parent_id", "parentId"}, c.GetParentIdFieldNames())
+	assert.Equal(t, []string{"span.span_id", "spanId"}, c.GetSpanIdFieldNames())
 }
 
 func TestOverrideConfigDefaults(t *testing.T) {
@@ -902,6 +1168,30 @@ func TestOverrideConfigDefaults(t *testing.T) {
 	assert.Equal(t, false, c.GetGRPCEnabled())
 }
 
+func TestCompressUpstreamCommunication(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err := getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+	assert.Equal(t, true, c.GetCompressUpstreamCommunication())
+	assert.Equal(t, true, c.GetCompressPeerCommunication())
+
+	cm = makeYAML(
+		"General.ConfigurationVersion", 2,
+		"Specialized.CompressUpstreamCommunication", false,
+	)
+	config, rules = createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	c, err = getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+	assert.Equal(t, false, c.GetCompressUpstreamCommunication())
+	assert.Equal(t, true, c.GetCompressPeerCommunication())
+}
+
 func TestMemorySizeUnmarshal(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1122,3 +1412,50 @@ func TestMemorySizeMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestDatasetTransformsValidated(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2, "Specialized.DatasetTransforms", []string{"lowercase", "uppercase"})
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err := getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, `unknown dataset transform "uppercase"`)
+}
+
+func TestSamplerKeyNormalizationsValidated(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2, "Specialized.SamplerKeyNormalizations", []string{"http.method=trim", "status=uppercase"})
+	rm := makeYAML("ConfigVersion", 2)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+	_, err := getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, `unknown transform "uppercase"`)
+}
+
+func TestSamplerRulesAreCheckedOnLoad(t *testing.T) {
+	cm := makeYAML("General.ConfigurationVersion", 2)
+	rm := makeYAML(
+		"RulesVersion", 2,
+		"Samplers.__default__.RulesBasedSampler.Rules", []map[string]any{
+			{
+				"Name":       "bad regexp",
+				"SampleRate": 1,
+				"Conditions": []map[string]any{
+					{"Field": "http.route", "Operator": "matches", "Value": "/api/(v1"},
+				},
+			},
+		},
+	)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+
+	_, err := getConfig([]string{"--config", config, "--rules_config", rules})
+	assert.ErrorContains(t, err, `Samplers.__default__.RulesBasedSampler rule 1 ("bad regexp") condition 1`)
+	assert.ErrorContains(t, err, "valid Go regexp")
+
+	// the same rules are accepted when validation is skipped
+	_, err = getConfig([]string{"--no-validate", "--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+}
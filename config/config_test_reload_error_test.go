@@ -71,3 +71,57 @@ func TestErrorReloading(t *testing.T) {
 		t.Error("received", d, "expected", "DeterministicSampler")
 	}
 }
+
+func TestReloadWithBrokenSamplerRules(t *testing.T) {
+	cm := makeYAML(
+		"General.ConfigurationVersion", 2,
+		"General.ConfigReloadInterval", Duration(1*time.Second),
+	)
+	rm := makeYAML(
+		"RulesVersion", 2,
+		"Samplers.__default__.DeterministicSampler.SampleRate", 5,
+	)
+	config, rules := createTempConfigs(t, cm, rm)
+	defer os.Remove(rules)
+	defer os.Remove(config)
+
+	opts, err := NewCmdEnvOptions([]string{"--config", config, "--rules_config", rules})
+	assert.NoError(t, err)
+
+	ch := make(chan error, 1)
+	c, err := NewConfig(opts, func(err error) {
+		select {
+		case ch <- err:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, c.GetConfigMetadata()[1].ValidationErrors)
+
+	rm2 := makeYAML(
+		"RulesVersion", 2,
+		"Samplers.__default__.RulesBasedSampler.Rules", []map[string]any{
+			{
+				"Name":       "bad regexp",
+				"SampleRate": 1,
+				"Conditions": []map[string]any{
+					{"Field": "http.route", "Operator": "matches", "Value": "/api/(v1"},
+				},
+			},
+		},
+	)
+	assert.NoError(t, os.WriteFile(rules, []byte(rm2), 0644))
+
+	select {
+	case err := <-ch:
+		assert.ErrorContains(t, err, "bad regexp")
+	case <-time.After(5 * time.Second):
+		t.Fatal("No error callback")
+	}
+
+	// the broken rules weren't swapped in, and why is reported
+	_, name, _ := c.GetSamplerConfigForDestName("dataset5")
+	assert.Equal(t, "DeterministicSampler", name)
+	assert.Len(t, c.GetConfigMetadata()[1].ValidationErrors, 1)
+	assert.Empty(t, c.GetConfigMetadata()[0].ValidationErrors)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DatasetTransform is one step of DatasetTransforms, which rewrite incoming
+// dataset names into a canonical form.
+type DatasetTransform struct {
+	// Kind is one of lowercase, trimprefix, addprefix, or replace.
+	Kind string
+	// Arg is the prefix for trimprefix and addprefix, and the replacement
+	// for replace.
+	Arg string
+	// Pattern is the expression that replace matches.
+	Pattern *regexp.Regexp
+}
+
+// ParseDatasetTransform parses an entry of DatasetTransforms, which is one of
+// lowercase, trimprefix:<prefix>, addprefix:<prefix>, or
+// replace:<regex>:<replacement>. The replacement follows the last colon, so
+// the expression may contain colons but the replacement may not.
+func ParseDatasetTransform(s string) (DatasetTransform, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	t := DatasetTransform{Kind: kind, Arg: arg}
+	switch kind {
+	case "lowercase":
+		if arg != "" {
+			return t, fmt.Errorf("dataset transform %q takes no argument", s)
+		}
+	case "trimprefix", "addprefix":
+		if arg == "" {
+			return t, fmt.Errorf("dataset transform %q needs a prefix", s)
+		}
+	case "replace":
+		i := strings.LastIndex(arg, ":")
+		if i < 0 {
+			return t, fmt.Errorf("dataset transform %q must look like replace:<regex>:<replacement>", s)
+		}
+		re, err := regexp.Compile(arg[:i])
+		if err != nil {
+			return t, fmt.Errorf("dataset transform %q has an invalid expression: %w", s, err)
+		}
+		t.Pattern, t.Arg = re, arg[i+1:]
+	default:
+		return t, fmt.Errorf("unknown dataset transform %q", s)
+	}
+	return t, nil
+}
+
+// Apply returns dataset with the transform applied.
+func (t DatasetTransform) Apply(dataset string) string {
+	switch t.Kind {
+	case "lowercase":
+		return strings.ToLower(dataset)
+	case "trimprefix":
+		return strings.TrimPrefix(dataset, t.Arg)
+	case "addprefix":
+		if strings.HasPrefix(dataset, t.Arg) {
+			return dataset
+		}
+		return t.Arg + dataset
+	case "replace":
+		return t.Pattern.ReplaceAllString(dataset, t.Arg)
+	}
+	return dataset
+}
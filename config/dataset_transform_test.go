@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatasetTransform(t *testing.T) {
+	tests := []struct {
+		transform string
+		in, want  string
+	}{
+		{"lowercase", "MyService", "myservice"},
+		{"trimprefix:prod-", "prod-myservice", "myservice"},
+		{"trimprefix:prod-", "myservice", "myservice"},
+		{"addprefix:team-", "myservice", "team-myservice"},
+		{"addprefix:team-", "team-myservice", "team-myservice"},
+		{"replace:[^a-z0-9]+:_", "my service.v2", "my_service_v2"},
+		{"replace:^(\\w+)-(\\w+)$:${2}-${1}", "prod-myservice", "myservice-prod"},
+		{"replace:a:b:", "a:b", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.transform, func(t *testing.T) {
+			transform, err := ParseDatasetTransform(tt.transform)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, transform.Apply(tt.in))
+		})
+	}
+
+	for _, bad := range []string{"", "uppercase", "lowercase:x", "trimprefix", "addprefix:", "replace:nocolon", "replace:[:x"} {
+		_, err := ParseDatasetTransform(bad)
+		assert.Error(t, err, bad)
+	}
+}
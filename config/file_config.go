@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -44,32 +45,42 @@ type fileConfig struct {
 	ticker        *time.Ticker
 	mux           sync.RWMutex
 	lastLoadTime  time.Time
+	// the validation failures from the most recent reload that failed
+	// validation, if the files haven't loaded successfully since
+	configFailures []string
+	rulesFailures  []string
 }
 
 type configContents struct {
-	General              GeneralConfig             `yaml:"General"`
-	Network              NetworkConfig             `yaml:"Network"`
-	AccessKeys           AccessKeyConfig           `yaml:"AccessKeys"`
-	Telemetry            RefineryTelemetryConfig   `yaml:"RefineryTelemetry"`
-	Traces               TracesConfig              `yaml:"Traces"`
-	Debugging            DebuggingConfig           `yaml:"Debugging"`
-	Logger               LoggerConfig              `yaml:"Logger"`
-	HoneycombLogger      HoneycombLoggerConfig     `yaml:"HoneycombLogger"`
-	StdoutLogger         StdoutLoggerConfig        `yaml:"StdoutLogger"`
-	PrometheusMetrics    PrometheusMetricsConfig   `yaml:"PrometheusMetrics"`
-	LegacyMetrics        LegacyMetricsConfig       `yaml:"LegacyMetrics"`
-	OTelMetrics          OTelMetricsConfig         `yaml:"OTelMetrics"`
-	OTelTracing          OTelTracingConfig         `yaml:"OTelTracing"`
-	PeerManagement       PeerManagementConfig      `yaml:"PeerManagement"`
-	RedisPeerManagement  RedisPeerManagementConfig `yaml:"RedisPeerManagement"`
-	Collection           CollectionConfig          `yaml:"Collection"`
-	BufferSizes          BufferSizeConfig          `yaml:"BufferSizes"`
-	Specialized          SpecializedConfig         `yaml:"Specialized"`
-	IDFieldNames         IDFieldsConfig            `yaml:"IDFields"`
-	GRPCServerParameters GRPCServerParameters      `yaml:"GRPCServerParameters"`
-	SampleCache          SampleCacheConfig         `yaml:"SampleCache"`
-	StressRelief         StressReliefConfig        `yaml:"StressRelief"`
-	CentralStore         SmartWrapperOptions       `yaml:"CentralStore"`
+	General              GeneralConfig              `yaml:"General"`
+	Network              NetworkConfig              `yaml:"Network"`
+	TLS                  TLSConfig                  `yaml:"TLS"`
+	AccessKeys           AccessKeyConfig            `yaml:"AccessKeys"`
+	Telemetry            RefineryTelemetryConfig    `yaml:"RefineryTelemetry"`
+	Traces               TracesConfig               `yaml:"Traces"`
+	Debugging            DebuggingConfig            `yaml:"Debugging"`
+	Logger               LoggerConfig               `yaml:"Logger"`
+	HoneycombLogger      HoneycombLoggerConfig      `yaml:"HoneycombLogger"`
+	StdoutLogger         StdoutLoggerConfig         `yaml:"StdoutLogger"`
+	PrometheusMetrics    PrometheusMetricsConfig    `yaml:"PrometheusMetrics"`
+	LegacyMetrics        LegacyMetricsConfig        `yaml:"LegacyMetrics"`
+	OTelMetrics          OTelMetricsConfig          `yaml:"OTelMetrics"`
+	OTelTracing          OTelTracingConfig          `yaml:"OTelTracing"`
+	PeerManagement       PeerManagementConfig       `yaml:"PeerManagement"`
+	RedisPeerManagement  RedisPeerManagementConfig  `yaml:"RedisPeerManagement"`
+	ConsulPeerManagement ConsulPeerManagementConfig `yaml:"ConsulPeerManagement"`
+	Collection           CollectionConfig           `yaml:"Collection"`
+	BufferSizes          BufferSizeConfig           `yaml:"BufferSizes"`
+	Specialized          SpecializedConfig          `yaml:"Specialized"`
+	IDFieldNames         IDFieldsConfig             `yaml:"IDFields"`
+	OTLPFieldMappings    OTLPFieldMappingsConfig    `yaml:"OTLPFieldMappings"`
+	DecisionWebhook      DecisionWebhookConfig      `yaml:"DecisionWebhook"`
+	Mirror               MirrorConfig               `yaml:"Mirror"`
+	Shadow               ShadowConfig               `yaml:"Shadow"`
+	GRPCServerParameters GRPCServerParameters       `yaml:"GRPCServerParameters"`
+	SampleCache          SampleCacheConfig          `yaml:"SampleCache"`
+	StressRelief         StressReliefConfig         `yaml:"StressRelief"`
+	CentralStore         SmartWrapperOptions        `yaml:"CentralStore"`
 }
 
 type GeneralConfig struct {
@@ -82,14 +93,72 @@ type GeneralConfig struct {
 type NetworkConfig struct {
 	ListenAddr      string   `yaml:"ListenAddr" default:"0.0.0.0:8080" cmdenv:"HTTPListenAddr"`
 	PeerListenAddr  string   `yaml:"PeerListenAddr" default:"0.0.0.0:8081" cmdenv:"PeerListenAddr"`
+	UnixSocketPath  string   `yaml:"UnixSocketPath"`
+	OTLPListenAddr  string   `yaml:"OTLPListenAddr" cmdenv:"OTLPListenAddr"`
 	HoneycombAPI    string   `yaml:"HoneycombAPI" default:"https://api.honeycomb.io" cmdenv:"HoneycombAPI"`
 	HTTPIdleTimeout Duration `yaml:"HTTPIdleTimeout"`
+
+	SlowRequestThreshold Duration `yaml:"SlowRequestThreshold" default:"10s"`
+
+	HoneycombAPIByEnvironment map[string]string `yaml:"HoneycombAPIByEnvironment" default:"{}"`
+
+	ProxyUnmatchedRequests *DefaultTrue `yaml:"ProxyUnmatchedRequests" default:"true"` // Avoid pointer woe on access, use GetProxyUnmatchedRequests() instead.
+
+	UpstreamSRVRefreshInterval Duration `yaml:"UpstreamSRVRefreshInterval" default:"30s"`
+
+	UpstreamMaxIdleConns        int      `yaml:"UpstreamMaxIdleConns" default:"100"`
+	UpstreamMaxIdleConnsPerHost int      `yaml:"UpstreamMaxIdleConnsPerHost"`
+	UpstreamIdleConnTimeout     Duration `yaml:"UpstreamIdleConnTimeout" default:"90s"`
+	UpstreamMaxConnLifetime     Duration `yaml:"UpstreamMaxConnLifetime"`
+
+	UpstreamProxyURL string   `yaml:"UpstreamProxyURL"`
+	UpstreamNoProxy  []string `yaml:"UpstreamNoProxy" default:"[]"`
+
+	UpstreamHealthCheckEnabled          bool     `yaml:"UpstreamHealthCheckEnabled"`
+	UpstreamHealthCheckInterval         Duration `yaml:"UpstreamHealthCheckInterval" default:"10s"`
+	UpstreamHealthCheckFailureThreshold int      `yaml:"UpstreamHealthCheckFailureThreshold" default:"3"`
+	UpstreamCircuitBreakerThreshold     int      `yaml:"UpstreamCircuitBreakerThreshold"`
+	UpstreamCircuitBreakerCooldown      Duration `yaml:"UpstreamCircuitBreakerCooldown" default:"30s"`
+}
+
+// srvSchemePrefix marks an upstream URL whose host is a DNS SRV record name,
+// as in srv+https://_honeycomb._tcp.example.com
+const srvSchemePrefix = "srv+"
+
+// srvNameFromURL returns the SRV record name from an srv+ URL, or the empty
+// string if the URL doesn't use an srv+ scheme.
+func srvNameFromURL(raw string) string {
+	if !strings.HasPrefix(raw, srvSchemePrefix) {
+		return ""
+	}
+	u, err := url.Parse(strings.TrimPrefix(raw, srvSchemePrefix))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+type TLSConfig struct {
+	MinVersion   string   `yaml:"MinVersion" default:"1.2"`
+	CipherSuites []string `yaml:"CipherSuites"`
 }
 
 type AccessKeyConfig struct {
 	ReceiveKeys          []string `yaml:"ReceiveKeys" default:"[]"`
 	AcceptOnlyListedKeys bool     `yaml:"AcceptOnlyListedKeys"`
-	keymap               generics.Set[string]
+	AllowedDatasets      []string `yaml:"AllowedDatasets" default:"[]"`
+	DeniedDatasets       []string `yaml:"DeniedDatasets" default:"[]"`
+	APIKeyQueryParam     string   `yaml:"APIKeyQueryParam"`
+
+	EnvironmentOverrideHeader string            `yaml:"EnvironmentOverrideHeader"`
+	UpstreamAPIKeys           map[string]string `yaml:"UpstreamAPIKeys" default:"{}"`
+	RejectMissingAPIKey       *DefaultTrue      `yaml:"RejectMissingAPIKey" default:"true"` // Avoid pointer woe on access, use GetRejectMissingAPIKey() instead.
+	keymap                    generics.Set[string]
+
+	ValidationCacheTTL         Duration `yaml:"ValidationCacheTTL"`
+	ValidationNegativeCacheTTL Duration `yaml:"ValidationNegativeCacheTTL"`
+
+	AdditionalAPIKeyHeaders []string `yaml:"AdditionalAPIKeyHeaders" default:"[]"`
 }
 
 type DefaultTrue bool
@@ -117,18 +186,58 @@ func (dt *DefaultTrue) UnmarshalText(text []byte) error {
 }
 
 type RefineryTelemetryConfig struct {
-	AddRuleReasonToTrace   bool         `yaml:"AddRuleReasonToTrace"`
-	AddSpanCountToRoot     *DefaultTrue `yaml:"AddSpanCountToRoot" default:"true"` // Avoid pointer woe on access, use GetAddSpanCountToRoot() instead.
-	AddCountsToRoot        bool         `yaml:"AddCountsToRoot"`
-	AddHostMetadataToTrace *DefaultTrue `yaml:"AddHostMetadataToTrace" default:"true"` // Avoid pointer woe on access, use GetAddHostMetadataToTrace() instead.
+	AddRuleReasonToTrace       bool         `yaml:"AddRuleReasonToTrace"`
+	AddSpanCountToRoot         *DefaultTrue `yaml:"AddSpanCountToRoot" default:"true"` // Avoid pointer woe on access, use GetAddSpanCountToRoot() instead.
+	AddCountsToRoot            bool         `yaml:"AddCountsToRoot"`
+	AddTraceDurationToRoot     bool         `yaml:"AddTraceDurationToRoot"`
+	AddServiceCountToRoot      bool         `yaml:"AddServiceCountToRoot"`
+	AddHostMetadataToTrace     *DefaultTrue `yaml:"AddHostMetadataToTrace" default:"true"` // Avoid pointer woe on access, use GetAddHostMetadataToTrace() instead.
+	AddNodeMetadataToTrace     bool         `yaml:"AddNodeMetadataToTrace"`
+	PreserveIncomingSampleRate bool         `yaml:"PreserveIncomingSampleRate"`
+	MetricsPerEnvironment      bool         `yaml:"MetricsPerEnvironment"`
+	MaxMetricsEnvironments     int          `yaml:"MaxMetricsEnvironments" default:"50"`
+
+	AddIngestLagField      bool `yaml:"AddIngestLagField"`
+	AllowNegativeIngestLag bool `yaml:"AllowNegativeIngestLag"`
 }
 
 type TracesConfig struct {
-	SendDelay    Duration `yaml:"SendDelay" default:"2s"`
-	BatchTimeout Duration `yaml:"BatchTimeout" default:"100ms"`
-	TraceTimeout Duration `yaml:"TraceTimeout" default:"60s"`
-	MaxBatchSize uint     `yaml:"MaxBatchSize" default:"500"`
-	SendTicker   Duration `yaml:"SendTicker" default:"100ms"`
+	SendDelay               Duration `yaml:"SendDelay" default:"2s"`
+	BatchTimeout            Duration `yaml:"BatchTimeout" default:"100ms"`
+	TraceTimeout            Duration `yaml:"TraceTimeout" default:"60s"`
+	MaxBatchSize            uint     `yaml:"MaxBatchSize" default:"500"`
+	UpstreamSendConcurrency uint     `yaml:"UpstreamSendConcurrency" default:"80"`
+	SendTicker              Duration `yaml:"SendTicker" default:"100ms"`
+	MaxEffectiveSampleRate  uint     `yaml:"MaxEffectiveSampleRate"`
+	MaxTraceAge             Duration `yaml:"MaxTraceAge"`
+	MaxTraceAgeAction       string   `yaml:"MaxTraceAgeAction" default:"drop"`
+
+	BatchingByDestination map[string]BatchingConfig `yaml:"BatchingByDestination" default:"{}"`
+}
+
+// BatchingConfig overrides MaxBatchSize and BatchTimeout for the events sent to
+// one dataset or environment. Zero values use the global settings.
+type BatchingConfig struct {
+	MaxBatchSize uint     `yaml:"MaxBatchSize"`
+	BatchTimeout Duration `yaml:"BatchTimeout"`
+}
+
+// resolveBatching returns the batch size and timeout for events sent to
+// dataset in environment: the dataset's BatchingByDestination entry if it has
+// one, then the environment's, and the global settings for anything that
+// neither sets.
+func resolveBatching(overrides map[string]BatchingConfig, environment, dataset string, maxBatchSize uint, batchTimeout time.Duration) (uint, time.Duration) {
+	override, ok := overrides[dataset]
+	if !ok {
+		override = overrides[environment]
+	}
+	if override.MaxBatchSize > 0 {
+		maxBatchSize = override.MaxBatchSize
+	}
+	if override.BatchTimeout > 0 {
+		batchTimeout = time.Duration(override.BatchTimeout)
+	}
+	return maxBatchSize, batchTimeout
 }
 
 type DebuggingConfig struct {
@@ -136,11 +245,40 @@ type DebuggingConfig struct {
 	QueryAuthToken        string   `yaml:"QueryAuthToken" cmdenv:"QueryAuthToken"`
 	AdditionalErrorFields []string `yaml:"AdditionalErrorFields" default:"[\"trace.span_id\"]"`
 	DryRun                bool     `yaml:"DryRun" `
+
+	DryRunByEnvironment map[string]bool `yaml:"DryRunByEnvironment" default:"{}"`
+
+	DroppedSampleRetention int `yaml:"DroppedSampleRetention"`
+	DroppedSampleRate      int `yaml:"DroppedSampleRate" default:"100"`
+
+	DebugEndpointsEnabled bool `yaml:"DebugEndpointsEnabled"`
+
+	QueryAuthTokens map[string]QueryScope `yaml:"QueryAuthTokens" default:"{}"`
+
+	DebugTraceSinkEnabled  bool    `yaml:"DebugTraceSinkEnabled"`
+	DebugTraceSinkFraction float64 `yaml:"DebugTraceSinkFraction" default:"1.0"`
+	DebugTraceSinkPath     string  `yaml:"DebugTraceSinkPath"`
+}
+
+// QueryScope limits what one of the QueryAuthTokens can query. A scope that
+// lists no environments or datasets is unrestricted, like QueryAuthToken;
+// otherwise the token can only use the per-dataset /query endpoints, for the
+// datasets or environments that match one of the scope's patterns.
+type QueryScope struct {
+	Environments []string `yaml:"Environments"`
+	Datasets     []string `yaml:"Datasets"`
+}
+
+// Unrestricted reports whether the scope allows every /query endpoint.
+func (s QueryScope) Unrestricted() bool {
+	return len(s.Environments) == 0 && len(s.Datasets) == 0
 }
 
 type LoggerConfig struct {
 	Type  string `yaml:"Type" default:"stdout"`
 	Level Level  `yaml:"Level" default:"warn"`
+
+	DebugSampleRate int `yaml:"DebugSampleRate" default:"1"`
 }
 
 type HoneycombLoggerConfig struct {
@@ -199,6 +337,10 @@ type PeerManagementConfig struct {
 	IdentifierInterfaceName string   `yaml:"IdentifierInterfaceName"`
 	UseIPV6Identifier       bool     `yaml:"UseIPV6Identifier"`
 	Peers                   []string `yaml:"Peers"`
+
+	MaxPeers                    int `yaml:"MaxPeers"`
+	MaxPeerListShrinkPercent    int `yaml:"MaxPeerListShrinkPercent"`
+	PeerListShrinkConfirmations int `yaml:"PeerListShrinkConfirmations" default:"3"`
 }
 
 type RedisPeerManagementConfig struct {
@@ -217,6 +359,12 @@ type RedisPeerManagementConfig struct {
 	MetricsCycleRate Duration `yaml:"MetricsCycleRate" default:"1m"`
 }
 
+type ConsulPeerManagementConfig struct {
+	Address     string `yaml:"Address" default:"127.0.0.1:8500" cmdenv:"ConsulAddress"`
+	ServiceName string `yaml:"ServiceName" default:"refinery"`
+	Token       string `yaml:"Token" cmdenv:"ConsulToken"`
+}
+
 type CollectionConfig struct {
 	CacheCapacity           int        `yaml:"CacheCapacity" default:"10_000"`
 	IncomingQueueSize       int        `yaml:"IncomingQueueSize"`
@@ -236,6 +384,11 @@ type CollectionConfig struct {
 	AggregationInterval     Duration   `yaml:"AggregationInterval" default:"50ms"`
 	AggregationCount        int        `yaml:"AggregationCount" default:"500"`
 	AggregationConcurrency  int        `yaml:"AggregationConcurrency" default:"4"`
+	MaxSpansPerTrace        int        `yaml:"MaxSpansPerTrace"`
+
+	IntraTraceSamplingThreshold int      `yaml:"IntraTraceSamplingThreshold"`
+	IntraTraceSampleRate        int      `yaml:"IntraTraceSampleRate" default:"10"`
+	MaxIncomingQueueWait        Duration `yaml:"MaxIncomingQueueWait"`
 }
 
 type SmartWrapperOptions struct {
@@ -307,20 +460,159 @@ func (c CollectionConfig) GetDeciderBatchSize() int {
 }
 
 type BufferSizeConfig struct {
-	UpstreamBufferSize int `yaml:"UpstreamBufferSize" default:"10_000"`
-	PeerBufferSize     int `yaml:"PeerBufferSize" default:"100_000"`
+	UpstreamBufferSize   int        `yaml:"UpstreamBufferSize" default:"10_000"`
+	PeerBufferSize       int        `yaml:"PeerBufferSize" default:"100_000"`
+	UpstreamSpillPath    string     `yaml:"UpstreamSpillPath"`
+	UpstreamSpillMaxSize MemorySize `yaml:"UpstreamSpillMaxSize" default:"1GB"`
 }
 
 type SpecializedConfig struct {
-	EnvironmentCacheTTL       Duration          `yaml:"EnvironmentCacheTTL" default:"1h"`
-	CompressPeerCommunication *DefaultTrue      `yaml:"CompressPeerCommunication" default:"true"` // Avoid pointer woe on access, use GetCompressPeerCommunication() instead.
-	AdditionalAttributes      map[string]string `yaml:"AdditionalAttributes" default:"{}"`
+	EnvironmentCacheTTL               Duration                     `yaml:"EnvironmentCacheTTL" default:"1h"`
+	MaxConcurrentAuthLookups          int                          `yaml:"MaxConcurrentAuthLookups" default:"32"`
+	AuthLookupWaitTimeout             Duration                     `yaml:"AuthLookupWaitTimeout" default:"1s"`
+	AuthLookupTimeout                 Duration                     `yaml:"AuthLookupTimeout" default:"5s"`
+	MaxAuthResponseSize               MemorySize                   `yaml:"MaxAuthResponseSize" default:"16KB"`
+	MaxDistinctEnvironments           int                          `yaml:"MaxDistinctEnvironments"`
+	RejectExcessEnvironments          bool                         `yaml:"RejectExcessEnvironments"`
+	MaxDistinctDatasets               int                          `yaml:"MaxDistinctDatasets"`
+	CatchAllDataset                   string                       `yaml:"CatchAllDataset"`
+	ZstdDecoderWaitTimeout            Duration                     `yaml:"ZstdDecoderWaitTimeout" default:"500ms"`
+	MaxConcurrentDecompressions       int                          `yaml:"MaxConcurrentDecompressions"`
+	CompressPeerCommunication         *DefaultTrue                 `yaml:"CompressPeerCommunication" default:"true"`     // Avoid pointer woe on access, use GetCompressPeerCommunication() instead.
+	CompressUpstreamCommunication     *DefaultTrue                 `yaml:"CompressUpstreamCommunication" default:"true"` // Avoid pointer woe on access, use GetCompressUpstreamCommunication() instead.
+	AdditionalAttributes              map[string]string            `yaml:"AdditionalAttributes" default:"{}"`
+	AdditionalAttributesByEnvironment map[string]map[string]string `yaml:"AdditionalAttributesByEnvironment" default:"{}"`
+	DecodeJSONNumbers                 bool                         `yaml:"DecodeJSONNumbers"`
+	JaegerDefaultDataset              string                       `yaml:"JaegerDefaultDataset" default:"unknown_service"`
+	DefaultDataset                    string                       `yaml:"DefaultDataset"`
+	MaxSpanAttributes                 int                          `yaml:"MaxSpanAttributes"`
+	MaxSpanBytes                      MemorySize                   `yaml:"MaxSpanBytes"`
+	MaxRequestBodySize                MemorySize                   `yaml:"MaxRequestBodySize" default:"20MB"`
+	MaxOTLPEventsPerRequest           int                          `yaml:"MaxOTLPEventsPerRequest"`
+	OversizedSpanAction               string                       `yaml:"OversizedSpanAction" default:"truncate"`
+	EmptyTraceIDAction                string                       `yaml:"EmptyTraceIDAction" default:"passthrough"`
+	InvalidOTLPIDAction               string                       `yaml:"InvalidOTLPIDAction" default:"accept"`
+	MaxEventTimeSkewFuture            Duration                     `yaml:"MaxEventTimeSkewFuture"`
+	MaxEventTimeSkewPast              Duration                     `yaml:"MaxEventTimeSkewPast"`
+	EventTimeSkewAction               string                       `yaml:"EventTimeSkewAction" default:"clamp"`
+	EventTimeSources                  []string                     `yaml:"EventTimeSources" default:"[\"header\",\"body\"]"`
+	DegradedLoadThreshold             int                          `yaml:"DegradedLoadThreshold"`
+	MaxLoggedBodyBytes                MemorySize                   `yaml:"MaxLoggedBodyBytes" default:"1KB"`
+	RedactLoggedBodies                bool                         `yaml:"RedactLoggedBodies"`
+	DatasetShards                     map[string]int               `yaml:"DatasetShards" default:"{}"`
+
+	OTLPResourceAttributeAllowlist []string `yaml:"OTLPResourceAttributeAllowlist" default:"[]"`
+	RedactedFields                 []string `yaml:"RedactedFields" default:"[]"`
+	RedactionPlaceholder           string   `yaml:"RedactionPlaceholder"`
+	ForceKeepConditions            []string `yaml:"ForceKeepConditions" default:"[]"`
+	DatasetTransforms              []string `yaml:"DatasetTransforms" default:"[]"`
+	SamplerKeyNormalizations       []string `yaml:"SamplerKeyNormalizations" default:"[]"`
+	StoreNormalizedValues          bool     `yaml:"StoreNormalizedValues"`
+	SyntheticTraceCondition        string   `yaml:"SyntheticTraceCondition"`
+	NonTraceEventHandling          string   `yaml:"NonTraceEventHandling" default:"forward"`
+	NonTraceEventDataset           string   `yaml:"NonTraceEventDataset"`
+
+	FieldTypeCoercions map[string]string `yaml:"FieldTypeCoercions" default:"{}"`
+
+	OnEnvironmentLookupFailure      string `yaml:"OnEnvironmentLookupFailure" default:"reject"`
+	EnvironmentLookupFailureDataset string `yaml:"EnvironmentLookupFailureDataset"`
+
+	TraceCompleteCondition string `yaml:"TraceCompleteCondition"`
+
+	StrictContentType bool `yaml:"StrictContentType"`
+
+	VerifyBodyChecksum bool   `yaml:"VerifyBodyChecksum"`
+	BodyChecksumStage  string `yaml:"BodyChecksumStage" default:"decompressed"`
+
+	KeepErrorTraces     bool   `yaml:"KeepErrorTraces"`
+	ErrorTraceCondition string `yaml:"ErrorTraceCondition" default:"error=true"`
+
+	DatasetRoutingField    string `yaml:"DatasetRoutingField"`
+	DatasetRoutingTemplate string `yaml:"DatasetRoutingTemplate"`
+
+	MaxFieldValueLength  int            `yaml:"MaxFieldValueLength"`
+	MaxFieldValueLengths map[string]int `yaml:"MaxFieldValueLengths" default:"{}"`
+}
+
+// FieldTypes are the types that FieldTypeCoercions can convert values to.
+var FieldTypes = []string{"int", "float", "bool", "string"}
+
+// EventTimeSources are the places an event's timestamp can come from: the
+// X-Honeycomb-Event-Time header, the event's own time, and the time it was
+// received.
+var EventTimeSources = []string{"header", "body", "received"}
+
+// ForceKeepCondition matches spans that are always kept. A span matches if it
+// has the field and, when HasValue is set, the field's value formats as Value.
+type ForceKeepCondition struct {
+	Field    string
+	Value    string
+	HasValue bool
+}
+
+// parseForceKeepCondition parses an entry of ForceKeepConditions, which is
+// either a field name or field=value.
+func parseForceKeepCondition(s string) ForceKeepCondition {
+	field, value, hasValue := strings.Cut(s, "=")
+	return ForceKeepCondition{
+		Field:    strings.TrimSpace(field),
+		Value:    strings.TrimSpace(value),
+		HasValue: hasValue,
+	}
 }
 
 type IDFieldsConfig struct {
 	TraceNames  []string `yaml:"TraceNames" default:"[\"trace.trace_id\",\"traceId\"]"`
 	ParentNames []string `yaml:"ParentNames" default:"[\"trace.parent_id\",\"parentId\"]"`
 	SpanNames   []string `yaml:"SpanNames" default:"[\"span.span_id\",\"spanId\"]"`
+
+	TraceHeaders map[string]string `yaml:"TraceHeaders" default:"{}"`
+
+	TraceNameConflictAction string `yaml:"TraceNameConflictAction" default:"ignore"`
+	PreferredTraceName      string `yaml:"PreferredTraceName"`
+}
+
+// OTLPFieldMappingsConfig controls the stable field names that span status and
+// W3C trace_state values are copied to after OTLP spans have been converted to
+// events.
+type OTLPFieldMappingsConfig struct {
+	StatusCode       string `yaml:"StatusCode" default:"status.code"`
+	StatusMessage    string `yaml:"StatusMessage" default:"status.message"`
+	TraceStatePrefix string `yaml:"TraceStatePrefix" default:"trace_state."`
+}
+
+// DecisionWebhookConfig controls the optional webhook that receives a record of
+// each trace sampling decision.
+type DecisionWebhookConfig struct {
+	URL       string   `yaml:"URL"`
+	QueueSize int      `yaml:"QueueSize" default:"10_000"`
+	BatchSize int      `yaml:"BatchSize" default:"100"`
+	Timeout   Duration `yaml:"Timeout" default:"5s"`
+}
+
+// MirrorConfig controls the optional mirror, which receives an unsampled copy
+// of every incoming event.
+type MirrorConfig struct {
+	MirrorAllSpans bool   `yaml:"MirrorAllSpans"`
+	APIHost        string `yaml:"APIHost"`
+	APIKey         string `yaml:"APIKey" cmdenv:"MirrorAPIKey"`
+	Dataset        string `yaml:"Dataset"`
+	QueueSize      int    `yaml:"QueueSize" default:"10_000"`
+}
+
+// ShadowConfig controls the optional shadow upstream, which receives a copy
+// of a fraction of the traces that are kept.
+type ShadowConfig struct {
+	APIHost        string  `yaml:"APIHost"`
+	APIKey         string  `yaml:"APIKey" cmdenv:"ShadowAPIKey"`
+	SampleFraction float64 `yaml:"SampleFraction"`
+	QueueSize      int     `yaml:"QueueSize" default:"10_000"`
+}
+
+// Enabled is true if a shadow upstream is configured and some traces are
+// to be sent to it.
+func (s ShadowConfig) Enabled() bool {
+	return s.APIHost != "" && s.SampleFraction > 0
 }
 
 // GRPCServerParameters allow you to configure the GRPC ServerParameters used
@@ -329,6 +621,7 @@ type IDFieldsConfig struct {
 type GRPCServerParameters struct {
 	Enabled               *DefaultTrue `yaml:"Enabled" default:"true"` // Avoid pointer woe on access, use GetGRPCEnabled() instead.
 	ListenAddr            string       `yaml:"ListenAddr" cmdenv:"GRPCListenAddr"`
+	UnixSocketPath        string       `yaml:"UnixSocketPath"`
 	MaxConnectionIdle     Duration     `yaml:"MaxConnectionIdle" default:"1m"`
 	MaxConnectionAge      Duration     `yaml:"MaxConnectionAge" default:"3m"`
 	MaxConnectionAgeGrace Duration     `yaml:"MaxConnectionAgeGrace" default:"1m"`
@@ -336,12 +629,32 @@ type GRPCServerParameters struct {
 	KeepAliveTimeout      Duration     `yaml:"KeepAliveTimeout" default:"20s"`
 	MaxSendMsgSize        MemorySize   `yaml:"MaxSendMsgSize" default:"5MB"`
 	MaxRecvMsgSize        MemorySize   `yaml:"MaxRecvMsgSize" default:"5MB"`
+	MaxLogsRecvMsgSize    MemorySize   `yaml:"MaxLogsRecvMsgSize"`
+	MaxConcurrentStreams  int          `yaml:"MaxConcurrentStreams"`
+	MaxConcurrentExports  int          `yaml:"MaxConcurrentExports"`
+	MaxConnections        int          `yaml:"MaxConnections"`
+	ResponseCompression   string       `yaml:"ResponseCompression" default:"none"`
+
+	ReflectionEnabled bool `yaml:"ReflectionEnabled"`
 }
 
 type SampleCacheConfig struct {
 	KeptSize          uint     `yaml:"KeptSize" default:"10_000"`
 	DroppedSize       uint     `yaml:"DroppedSize" default:"1_000_000"`
 	SizeCheckInterval Duration `yaml:"SizeCheckInterval" default:"10s"`
+
+	DecisionTTL          Duration            `yaml:"DecisionTTL"`
+	DecisionTTLByDataset map[string]Duration `yaml:"DecisionTTLByDataset" default:"{}"`
+}
+
+// GetDecisionTTL returns how long the decision for a trace in dataset is
+// retained, whatever the sizes of the caches: its DecisionTTLByDataset entry
+// if it has one, and DecisionTTL otherwise.
+func (c SampleCacheConfig) GetDecisionTTL(dataset string) time.Duration {
+	if ttl, ok := c.DecisionTTLByDataset[dataset]; ok {
+		return time.Duration(ttl)
+	}
+	return time.Duration(c.DecisionTTL)
 }
 
 type StressReliefConfig struct {
@@ -351,6 +664,8 @@ type StressReliefConfig struct {
 	SamplingRate              uint64   `yaml:"SamplingRate" default:"100"`
 	MinimumActivationDuration Duration `yaml:"MinimumActivationDuration" default:"10s"`
 	MinimumStartupDuration    Duration `yaml:"MinimumStartupDuration" default:"3s"`
+	HashAlgorithm             string   `yaml:"HashAlgorithm" default:"wyhash"`
+	Warmup                    Duration `yaml:"Warmup"`
 }
 
 type FileConfigError struct {
@@ -426,6 +741,15 @@ func newFileConfig(opts *CmdEnv) (*fileConfig, error) {
 		return nil, err
 	}
 
+	if !opts.NoValidate {
+		if fails := validateSamplers(rulesconf); len(fails) > 0 {
+			return nil, &FileConfigError{
+				RulesLocation: opts.RulesLocation,
+				RulesFailures: fails,
+			}
+		}
+	}
+
 	cfg := &fileConfig{
 		mainConfig:  mainconf,
 		mainHash:    mainhash,
@@ -500,10 +824,24 @@ func (f *fileConfig) monitor() {
 			// reread the configs
 			cfg, err := newFileConfig(f.opts)
 			if err != nil {
+				// keep running with what we have, but remember why the new
+				// files weren't used so that it can be queried
+				var fcErr *FileConfigError
+				if errors.As(err, &fcErr) {
+					f.mux.Lock()
+					f.configFailures = fcErr.ConfigFailures
+					f.rulesFailures = fcErr.RulesFailures
+					f.mux.Unlock()
+				}
 				f.errorCallback(err)
 				continue
 			}
 
+			f.mux.Lock()
+			f.configFailures = nil
+			f.rulesFailures = nil
+			f.mux.Unlock()
+
 			// if nothing's changed, we're fine
 			if f.mainHash == cfg.mainHash && f.rulesHash == cfg.rulesHash {
 				continue
@@ -553,6 +891,20 @@ func (f *fileConfig) GetListenAddr() string {
 	return f.mainConfig.Network.ListenAddr
 }
 
+func (f *fileConfig) GetOTLPListenAddr() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Network.OTLPListenAddr
+}
+
+func (f *fileConfig) GetUnixSocketPath() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Network.UnixSocketPath
+}
+
 func (f *fileConfig) GetPeerListenAddr() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
@@ -574,6 +926,20 @@ func (f *fileConfig) GetCompressPeerCommunication() bool {
 	return f.mainConfig.Specialized.CompressPeerCommunication.Get()
 }
 
+func (f *fileConfig) GetCompressUpstreamCommunication() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.CompressUpstreamCommunication.Get()
+}
+
+func (f *fileConfig) GetProxyUnmatchedRequests() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Network.ProxyUnmatchedRequests.Get()
+}
+
 func (f *fileConfig) GetGRPCEnabled() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
@@ -588,6 +954,20 @@ func (f *fileConfig) GetGRPCListenAddr() string {
 	return f.mainConfig.GRPCServerParameters.ListenAddr
 }
 
+func (f *fileConfig) GetGRPCUnixSocketPath() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.GRPCServerParameters.UnixSocketPath
+}
+
+func (f *fileConfig) GetGRPCReflectionEnabled() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.GRPCServerParameters.ReflectionEnabled
+}
+
 func (f *fileConfig) GetGRPCConfig() GRPCServerParameters {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
@@ -595,6 +975,20 @@ func (f *fileConfig) GetGRPCConfig() GRPCServerParameters {
 	return f.mainConfig.GRPCServerParameters
 }
 
+func (f *fileConfig) GetGRPCMaxConcurrentExports() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.GRPCServerParameters.MaxConcurrentExports
+}
+
+func (f *fileConfig) GetGRPCMaxConnections() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.GRPCServerParameters.MaxConnections
+}
+
 func (f *fileConfig) IsAPIKeyValid(key string) bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
@@ -611,373 +1005,953 @@ func (f *fileConfig) IsAPIKeyValid(key string) bool {
 	return f.mainConfig.AccessKeys.keymap.Contains(key)
 }
 
-func (f *fileConfig) GetPeerManagementType() string {
+func (f *fileConfig) GetAllowedDatasets() []string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PeerManagement.Type
+	return f.mainConfig.AccessKeys.AllowedDatasets
 }
 
-func (f *fileConfig) GetPeers() []string {
+func (f *fileConfig) GetDeniedDatasets() []string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PeerManagement.Peers
+	return f.mainConfig.AccessKeys.DeniedDatasets
 }
 
-func (f *fileConfig) GetRedisHost() string {
+func (f *fileConfig) GetAPIKeyQueryParam() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Host
+	return f.mainConfig.AccessKeys.APIKeyQueryParam
 }
 
-func (f *fileConfig) GetRedisUsername() string {
+func (f *fileConfig) GetAdditionalAPIKeyHeaders() []string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Username
+	return f.mainConfig.AccessKeys.AdditionalAPIKeyHeaders
 }
 
-func (f *fileConfig) GetRedisPrefix() string {
+func (f *fileConfig) GetEnvironmentOverrideHeader() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Prefix
+	return f.mainConfig.AccessKeys.EnvironmentOverrideHeader
 }
 
-func (f *fileConfig) GetRedisPassword() string {
+func (f *fileConfig) GetRejectMissingAPIKey() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Password
+	return f.mainConfig.AccessKeys.RejectMissingAPIKey.Get()
 }
 
-func (f *fileConfig) GetRedisAuthCode() string {
+func (f *fileConfig) GetAPIKeyValidationCacheTTL() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.AuthCode
+	return time.Duration(f.mainConfig.AccessKeys.ValidationCacheTTL)
 }
 
-func (f *fileConfig) GetRedisDatabase() int {
+func (f *fileConfig) GetAPIKeyValidationNegativeCacheTTL() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Database
+	return time.Duration(f.mainConfig.AccessKeys.ValidationNegativeCacheTTL)
 }
 
-func (f *fileConfig) GetUseTLS() bool {
+func (f *fileConfig) GetUpstreamAPIKeyForDest(dest string) string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.UseTLS
+	if dest == "" {
+		return ""
+	}
+	return f.mainConfig.AccessKeys.UpstreamAPIKeys[dest]
 }
 
-func (f *fileConfig) GetUseTLSInsecure() bool {
+func (f *fileConfig) GetPeerManagementType() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.UseTLSInsecure
+	return f.mainConfig.PeerManagement.Type
 }
 
-func (f *fileConfig) GetParallelism() int {
+func (f *fileConfig) GetPeers() []string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.Parallelism
+	return f.mainConfig.PeerManagement.Peers
 }
 
-func (f *fileConfig) GetRedisMetricsCycleRate() time.Duration {
+func (f *fileConfig) GetMaxPeers() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.RedisPeerManagement.MetricsCycleRate)
+	return f.mainConfig.PeerManagement.MaxPeers
 }
 
-func (f *fileConfig) GetIdentifierInterfaceName() string {
+func (f *fileConfig) GetMaxPeerListShrinkPercent() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PeerManagement.IdentifierInterfaceName
+	return f.mainConfig.PeerManagement.MaxPeerListShrinkPercent
 }
 
-func (f *fileConfig) GetUseIPV6Identifier() bool {
+func (f *fileConfig) GetPeerListShrinkConfirmations() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PeerManagement.UseIPV6Identifier
+	return f.mainConfig.PeerManagement.PeerListShrinkConfirmations
 }
 
-func (f *fileConfig) GetRedisIdentifier() string {
+func (f *fileConfig) GetRedisHost() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PeerManagement.Identifier
+	return f.mainConfig.RedisPeerManagement.Host
 }
 
-func (f *fileConfig) GetRedisMaxActive() int {
+func (f *fileConfig) GetRedisUsername() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.MaxActive
+	return f.mainConfig.RedisPeerManagement.Username
 }
 
-func (f *fileConfig) GetRedisMaxIdle() int {
+func (f *fileConfig) GetRedisPrefix() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.RedisPeerManagement.MaxIdle
+	return f.mainConfig.RedisPeerManagement.Prefix
 }
 
-func (f *fileConfig) GetHoneycombAPI() string {
+func (f *fileConfig) GetRedisPassword() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Network.HoneycombAPI
+	return f.mainConfig.RedisPeerManagement.Password
 }
 
-func (f *fileConfig) GetLoggerLevel() Level {
+func (f *fileConfig) GetRedisAuthCode() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Logger.Level
+	return f.mainConfig.RedisPeerManagement.AuthCode
 }
 
-func (f *fileConfig) GetLoggerType() string {
+func (f *fileConfig) GetRedisDatabase() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Logger.Type
+	return f.mainConfig.RedisPeerManagement.Database
 }
 
-func (f *fileConfig) GetHoneycombLoggerConfig() HoneycombLoggerConfig {
+func (f *fileConfig) GetUseTLS() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.HoneycombLogger
+	return f.mainConfig.RedisPeerManagement.UseTLS
 }
 
-func (f *fileConfig) GetStdoutLoggerConfig() StdoutLoggerConfig {
+func (f *fileConfig) GetUseTLSInsecure() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.StdoutLogger
+	return f.mainConfig.RedisPeerManagement.UseTLSInsecure
 }
 
-func (f *fileConfig) GetAllSamplerRules() *V2SamplerConfig {
+func (f *fileConfig) GetParallelism() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	// This is probably good enough for debug; if not we can extend it.
-	return f.rulesConfig
+	return f.mainConfig.RedisPeerManagement.Parallelism
 }
 
-// GetSamplerConfigForDestName returns the sampler config for the given
-// destination (environment, or dataset in classic mode), as well as the name of
-// the sampler type. If the specific destination is not found, it returns the
-// default sampler config.
-func (f *fileConfig) GetSamplerConfigForDestName(destname string) (any, string, error) {
+func (f *fileConfig) GetRedisMetricsCycleRate() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	nameToUse := "__default__"
-	if _, ok := f.rulesConfig.Samplers[destname]; ok {
-		nameToUse = destname
-	}
-
-	err := errors.New("no sampler found and no default configured")
-	name := "not found"
-	var cfg any
-	if sampler, ok := f.rulesConfig.Samplers[nameToUse]; ok {
-		cfg, name = sampler.Sampler()
-		if cfg != nil {
-			err = nil
-		}
-	}
-	return cfg, name, err
+	return time.Duration(f.mainConfig.RedisPeerManagement.MetricsCycleRate)
 }
 
-func (f *fileConfig) GetCollectionConfig() CollectionConfig {
+func (f *fileConfig) GetIdentifierInterfaceName() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Collection
+	return f.mainConfig.PeerManagement.IdentifierInterfaceName
 }
 
-func (f *fileConfig) GetLegacyMetricsConfig() LegacyMetricsConfig {
+func (f *fileConfig) GetUseIPV6Identifier() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.LegacyMetrics
+	return f.mainConfig.PeerManagement.UseIPV6Identifier
 }
 
-func (f *fileConfig) GetPrometheusMetricsConfig() PrometheusMetricsConfig {
+func (f *fileConfig) GetRedisIdentifier() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.PrometheusMetrics
+	return f.mainConfig.PeerManagement.Identifier
 }
 
-func (f *fileConfig) GetOTelMetricsConfig() OTelMetricsConfig {
+func (f *fileConfig) GetTLSMinVersion() uint16 {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.OTelMetrics
+	return tlsVersions[f.mainConfig.TLS.MinVersion]
 }
 
-func (f *fileConfig) GetOTelTracingConfig() OTelTracingConfig {
+func (f *fileConfig) GetTLSCipherSuites() []uint16 {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.OTelTracing
+	if len(f.mainConfig.TLS.CipherSuites) == 0 {
+		return nil
+	}
+	// unknown names were rejected when the config was validated
+	suites := make([]uint16, 0, len(f.mainConfig.TLS.CipherSuites))
+	for _, name := range f.mainConfig.TLS.CipherSuites {
+		if id, ok := tlsCipherSuiteID(name); ok {
+			suites = append(suites, id)
+		}
+	}
+	return suites
 }
 
-func (f *fileConfig) GetSendDelay() time.Duration {
+func (f *fileConfig) GetConsulPeerManagementConfig() ConsulPeerManagementConfig {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.Traces.SendDelay)
+	return f.mainConfig.ConsulPeerManagement
 }
 
-func (f *fileConfig) GetBatchTimeout() time.Duration {
+func (f *fileConfig) GetRedisMaxActive() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.Traces.BatchTimeout)
+	return f.mainConfig.RedisPeerManagement.MaxActive
 }
 
-func (f *fileConfig) GetTraceTimeout() time.Duration {
+func (f *fileConfig) GetRedisMaxIdle() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.Traces.TraceTimeout)
+	return f.mainConfig.RedisPeerManagement.MaxIdle
 }
 
-func (f *fileConfig) GetMaxBatchSize() uint {
+func (f *fileConfig) GetHoneycombAPI() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Traces.MaxBatchSize
+	// an srv+ scheme is resolved at dial time, so everything else sees a plain URL
+	return strings.TrimPrefix(f.mainConfig.Network.HoneycombAPI, srvSchemePrefix)
 }
 
-func (f *fileConfig) GetUpstreamBufferSize() int {
+func (f *fileConfig) GetSlowRequestThreshold() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.BufferSizes.UpstreamBufferSize
+	return time.Duration(f.mainConfig.Network.SlowRequestThreshold)
 }
 
-func (f *fileConfig) GetPeerBufferSize() int {
+func (f *fileConfig) GetHoneycombAPIForEnvironment(env string) string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.BufferSizes.PeerBufferSize
+	if api, ok := f.mainConfig.Network.HoneycombAPIByEnvironment[env]; ok && env != "" {
+		return api
+	}
+	return strings.TrimPrefix(f.mainConfig.Network.HoneycombAPI, srvSchemePrefix)
 }
 
-func (f *fileConfig) GetSendTickerValue() time.Duration {
+func (f *fileConfig) GetHoneycombAPISRVName() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.Traces.SendTicker)
+	return srvNameFromURL(f.mainConfig.Network.HoneycombAPI)
 }
 
-func (f *fileConfig) GetDebugServiceAddr() string {
+func (f *fileConfig) GetUpstreamSRVRefreshInterval() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Debugging.DebugServiceAddr
+	return time.Duration(f.mainConfig.Network.UpstreamSRVRefreshInterval)
 }
 
-func (f *fileConfig) GetIsDryRun() bool {
+func (f *fileConfig) GetUpstreamMaxIdleConns() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Debugging.DryRun
+	return f.mainConfig.Network.UpstreamMaxIdleConns
 }
 
-func (f *fileConfig) GetAddHostMetadataToTrace() bool {
+func (f *fileConfig) GetUpstreamMaxIdleConnsPerHost() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Telemetry.AddHostMetadataToTrace.Get()
+	return f.mainConfig.Network.UpstreamMaxIdleConnsPerHost
 }
 
-func (f *fileConfig) GetAddRuleReasonToTrace() bool {
+func (f *fileConfig) GetUpstreamIdleConnTimeout() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Telemetry.AddRuleReasonToTrace
+	return time.Duration(f.mainConfig.Network.UpstreamIdleConnTimeout)
 }
 
-func (f *fileConfig) GetEnvironmentCacheTTL() time.Duration {
+func (f *fileConfig) GetUpstreamMaxConnLifetime() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.Specialized.EnvironmentCacheTTL)
+	return time.Duration(f.mainConfig.Network.UpstreamMaxConnLifetime)
 }
 
-func (f *fileConfig) GetDatasetPrefix() string {
+func (f *fileConfig) GetUpstreamProxyURL() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.General.DatasetPrefix
+	return f.mainConfig.Network.UpstreamProxyURL
 }
 
-func (f *fileConfig) GetQueryAuthToken() string {
+func (f *fileConfig) GetUpstreamNoProxy() []string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Debugging.QueryAuthToken
+	return f.mainConfig.Network.UpstreamNoProxy
 }
 
-func (f *fileConfig) GetPeerTimeout() time.Duration {
+func (f *fileConfig) GetUpstreamHealthCheckEnabled() bool {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return time.Duration(f.mainConfig.RedisPeerManagement.Timeout)
+	return f.mainConfig.Network.UpstreamHealthCheckEnabled
 }
 
-func (f *fileConfig) GetAdditionalErrorFields() []string {
+func (f *fileConfig) GetUpstreamHealthCheckInterval() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Debugging.AdditionalErrorFields
+	return time.Duration(f.mainConfig.Network.UpstreamHealthCheckInterval)
 }
 
-func (f *fileConfig) GetAddSpanCountToRoot() bool {
+func (f *fileConfig) GetUpstreamHealthCheckFailureThreshold() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Telemetry.AddSpanCountToRoot.Get()
+	return f.mainConfig.Network.UpstreamHealthCheckFailureThreshold
 }
 
-func (f *fileConfig) GetAddCountsToRoot() bool {
+func (f *fileConfig) GetUpstreamCircuitBreakerThreshold() int {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.Telemetry.AddCountsToRoot
+	return f.mainConfig.Network.UpstreamCircuitBreakerThreshold
 }
 
-func (f *fileConfig) GetSampleCacheConfig() SampleCacheConfig {
+func (f *fileConfig) GetUpstreamCircuitBreakerCooldown() time.Duration {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.SampleCache
+	return time.Duration(f.mainConfig.Network.UpstreamCircuitBreakerCooldown)
 }
 
-func (f *fileConfig) GetStressReliefConfig() StressReliefConfig {
+func (f *fileConfig) GetLoggerLevel() Level {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.StressRelief
+	return f.mainConfig.Logger.Level
 }
 
-func (f *fileConfig) GetTraceIdFieldNames() []string {
+func (f *fileConfig) GetLoggerType() string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
 
-	return f.mainConfig.IDFieldNames.TraceNames
+	return f.mainConfig.Logger.Type
+}
+
+func (f *fileConfig) GetDebugLogSampleRate() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Logger.DebugSampleRate
+}
+
+func (f *fileConfig) GetHoneycombLoggerConfig() HoneycombLoggerConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.HoneycombLogger
+}
+
+func (f *fileConfig) GetStdoutLoggerConfig() StdoutLoggerConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.StdoutLogger
+}
+
+func (f *fileConfig) GetAllSamplerRules() *V2SamplerConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	// This is probably good enough for debug; if not we can extend it.
+	return f.rulesConfig
+}
+
+// ErrNoSamplerFound is returned by GetSamplerConfigForDestName when there is
+// no sampler for the destination and no default sampler either.
+var ErrNoSamplerFound = errors.New("no sampler found and no default configured")
+
+// GetSamplerConfigForDestName returns the sampler config for the given
+// destination (environment, or dataset in classic mode), as well as the name of
+// the sampler type. If the specific destination is not found, it returns the
+// default sampler config.
+func (f *fileConfig) GetSamplerConfigForDestName(destname string) (any, string, error) {
+	res := f.GetSamplerResolution(destname)
+	if !res.Found {
+		return nil, res.Name, ErrNoSamplerFound
+	}
+	return res.Sampler, res.Name, nil
+}
+
+// defaultSamplerKey is the Samplers entry used for destinations that don't
+// have one of their own.
+const defaultSamplerKey = "__default__"
+
+// SamplerResolution describes how a destination's sampler was chosen.
+type SamplerResolution struct {
+	// Destination is the environment, or dataset in classic, being resolved.
+	Destination string `json:"destination" yaml:"destination"`
+	// Checked lists the Samplers entries that were looked for, in order.
+	Checked []SamplerCandidate `json:"checked" yaml:"checked"`
+	// Matched is the Samplers entry that was used, if any.
+	Matched string `json:"matched,omitempty" yaml:"matched,omitempty"`
+	// UsedDefault is set if the destination has no entry of its own.
+	UsedDefault bool `json:"used_default" yaml:"used_default"`
+	// Name is the type of the sampler, as GetSamplerConfigForDestName returns it.
+	Name string `json:"name" yaml:"name"`
+	// Sampler is the sampler's config.
+	Sampler any    `json:"sampler,omitempty" yaml:"sampler,omitempty"`
+	Found   bool   `json:"found" yaml:"found"`
+	Reason  string `json:"reason" yaml:"reason"`
+}
+
+// SamplerCandidate is a Samplers entry checked while resolving a sampler.
+type SamplerCandidate struct {
+	Key     string `json:"key" yaml:"key"`
+	Present bool   `json:"present" yaml:"present"`
+}
+
+func (f *fileConfig) GetSamplerResolution(destname string) SamplerResolution {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	res := SamplerResolution{Destination: destname, Name: "not found"}
+	nameToUse := defaultSamplerKey
+	_, ok := f.rulesConfig.Samplers[destname]
+	res.Checked = append(res.Checked, SamplerCandidate{Key: destname, Present: ok})
+	if ok {
+		nameToUse = destname
+	} else if destname != defaultSamplerKey {
+		_, ok := f.rulesConfig.Samplers[defaultSamplerKey]
+		res.Checked = append(res.Checked, SamplerCandidate{Key: defaultSamplerKey, Present: ok})
+		res.UsedDefault = true
+	}
+
+	sampler, ok := f.rulesConfig.Samplers[nameToUse]
+	if !ok {
+		res.UsedDefault = false
+		res.Reason = fmt.Sprintf("no sampler is configured for %s and there is no %s sampler", destname, defaultSamplerKey)
+		return res
+	}
+	res.Matched = nameToUse
+	cfg, name := sampler.Sampler()
+	res.Sampler, res.Name, res.Found = cfg, name, cfg != nil
+	switch {
+	case !res.Found:
+		res.Reason = fmt.Sprintf("the %s entry doesn't configure a sampler", nameToUse)
+	case res.UsedDefault:
+		res.Reason = fmt.Sprintf("no sampler is configured for %s, so the %s sampler is used", destname, defaultSamplerKey)
+	default:
+		res.Reason = fmt.Sprintf("%s has its own sampler", destname)
+	}
+	return res
+}
+
+func (f *fileConfig) GetCollectionConfig() CollectionConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Collection
+}
+
+func (f *fileConfig) GetLegacyMetricsConfig() LegacyMetricsConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.LegacyMetrics
+}
+
+func (f *fileConfig) GetPrometheusMetricsConfig() PrometheusMetricsConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.PrometheusMetrics
+}
+
+func (f *fileConfig) GetOTelMetricsConfig() OTelMetricsConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.OTelMetrics
+}
+
+func (f *fileConfig) GetOTelTracingConfig() OTelTracingConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.OTelTracing
+}
+
+func (f *fileConfig) GetSendDelay() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Traces.SendDelay)
+}
+
+func (f *fileConfig) GetBatchTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Traces.BatchTimeout)
+}
+
+func (f *fileConfig) GetTraceTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Traces.TraceTimeout)
+}
+
+func (f *fileConfig) GetMaxBatchSize() uint {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Traces.MaxBatchSize
+}
+
+func (f *fileConfig) GetBatchingForDestination(environment, dataset string) (uint, time.Duration) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	traces := f.mainConfig.Traces
+	return resolveBatching(traces.BatchingByDestination, environment, dataset, traces.MaxBatchSize, time.Duration(traces.BatchTimeout))
+}
+
+func (f *fileConfig) GetMaxEffectiveSampleRate() uint {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Traces.MaxEffectiveSampleRate
+}
+
+func (f *fileConfig) GetMaxTraceAge() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Traces.MaxTraceAge)
+}
+
+func (f *fileConfig) GetMaxTraceAgeAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Traces.MaxTraceAgeAction
+}
+
+func (f *fileConfig) GetUpstreamSendConcurrency() uint {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Traces.UpstreamSendConcurrency
+}
+
+func (f *fileConfig) GetUpstreamBufferSize() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.BufferSizes.UpstreamBufferSize
+}
+
+func (f *fileConfig) GetPeerBufferSize() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.BufferSizes.PeerBufferSize
+}
+
+func (f *fileConfig) GetUpstreamSpillPath() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.BufferSizes.UpstreamSpillPath
+}
+
+func (f *fileConfig) GetUpstreamSpillMaxSize() int64 {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return int64(f.mainConfig.BufferSizes.UpstreamSpillMaxSize)
+}
+
+func (f *fileConfig) GetSendTickerValue() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Traces.SendTicker)
+}
+
+func (f *fileConfig) GetDebugServiceAddr() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DebugServiceAddr
+}
+
+func (f *fileConfig) GetIsDryRun() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DryRun
+}
+
+func (f *fileConfig) GetIsDryRunForEnvironment(env string) bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if dryRun, ok := f.mainConfig.Debugging.DryRunByEnvironment[env]; ok {
+		return dryRun
+	}
+	return f.mainConfig.Debugging.DryRun
+}
+
+func (f *fileConfig) GetDroppedSampleRetention() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DroppedSampleRetention
+}
+
+func (f *fileConfig) GetDroppedSampleRate() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DroppedSampleRate
+}
+
+func (f *fileConfig) GetDebugTraceSinkEnabled() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DebugTraceSinkEnabled
+}
+
+func (f *fileConfig) GetDebugTraceSinkFraction() float64 {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DebugTraceSinkFraction
+}
+
+func (f *fileConfig) GetDebugTraceSinkPath() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DebugTraceSinkPath
+}
+
+func (f *fileConfig) GetDebugEndpointsEnabled() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.DebugEndpointsEnabled
+}
+
+func (f *fileConfig) GetAddHostMetadataToTrace() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddHostMetadataToTrace.Get()
+}
+
+func (f *fileConfig) GetAddNodeMetadataToTrace() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddNodeMetadataToTrace
+}
+
+func (f *fileConfig) GetAddIngestLagField() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddIngestLagField
+}
+
+func (f *fileConfig) GetAllowNegativeIngestLag() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AllowNegativeIngestLag
+}
+
+func (f *fileConfig) GetPreserveIncomingSampleRate() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.PreserveIncomingSampleRate
+}
+
+func (f *fileConfig) GetAddRuleReasonToTrace() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddRuleReasonToTrace
+}
+
+func (f *fileConfig) GetMetricsPerEnvironmentEnabled() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.MetricsPerEnvironment
+}
+
+func (f *fileConfig) GetMaxMetricsEnvironments() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.MaxMetricsEnvironments
+}
+
+func (f *fileConfig) GetMaxSpansPerTrace() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Collection.MaxSpansPerTrace
+}
+
+func (f *fileConfig) GetIntraTraceSamplingThreshold() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Collection.IntraTraceSamplingThreshold
+}
+
+func (f *fileConfig) GetIntraTraceSampleRate() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Collection.IntraTraceSampleRate
+}
+
+func (f *fileConfig) GetMaxIncomingQueueWait() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Collection.MaxIncomingQueueWait)
+}
+
+func (f *fileConfig) GetEnvironmentCacheTTL() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.EnvironmentCacheTTL)
+}
+
+func (f *fileConfig) GetMaxConcurrentAuthLookups() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxConcurrentAuthLookups
+}
+
+func (f *fileConfig) GetAuthLookupWaitTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.AuthLookupWaitTimeout)
+}
+
+func (f *fileConfig) GetAuthLookupTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.AuthLookupTimeout)
+}
+
+func (f *fileConfig) GetMaxAuthResponseSize() MemorySize {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxAuthResponseSize
+}
+
+func (f *fileConfig) GetMaxDistinctEnvironments() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxDistinctEnvironments
+}
+
+func (f *fileConfig) GetRejectExcessEnvironments() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.RejectExcessEnvironments
+}
+
+func (f *fileConfig) GetMaxDistinctDatasets() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxDistinctDatasets
+}
+
+func (f *fileConfig) GetCatchAllDataset() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.CatchAllDataset
+}
+
+func (f *fileConfig) GetZstdDecoderWaitTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.ZstdDecoderWaitTimeout)
+}
+
+func (f *fileConfig) GetMaxConcurrentDecompressions() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxConcurrentDecompressions
+}
+
+func (f *fileConfig) GetDatasetPrefix() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.General.DatasetPrefix
+}
+
+func (f *fileConfig) GetQueryAuthToken() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.QueryAuthToken
+}
+
+func (f *fileConfig) GetQueryAuthTokens() map[string]QueryScope {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.QueryAuthTokens
+}
+
+func (f *fileConfig) GetPeerTimeout() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.RedisPeerManagement.Timeout)
+}
+
+func (f *fileConfig) GetAdditionalErrorFields() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Debugging.AdditionalErrorFields
+}
+
+func (f *fileConfig) GetAddSpanCountToRoot() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddSpanCountToRoot.Get()
+}
+
+func (f *fileConfig) GetAddCountsToRoot() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddCountsToRoot
+}
+
+func (f *fileConfig) GetAddTraceDurationToRoot() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddTraceDurationToRoot
+}
+
+func (f *fileConfig) GetAddServiceCountToRoot() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Telemetry.AddServiceCountToRoot
+}
+
+func (f *fileConfig) GetSampleCacheConfig() SampleCacheConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.SampleCache
+}
+
+func (f *fileConfig) GetStressReliefConfig() StressReliefConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.StressRelief
+}
+
+func (f *fileConfig) GetStressReliefHashAlgorithm() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.StressRelief.HashAlgorithm
+}
+
+func (f *fileConfig) GetTraceIdFieldNames() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.IDFieldNames.TraceNames
+}
+
+func (f *fileConfig) GetTraceIdHeaders() map[string]string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.IDFieldNames.TraceHeaders
+}
+
+func (f *fileConfig) GetTraceIdConflictAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.IDFieldNames.TraceNameConflictAction
+}
+
+func (f *fileConfig) GetPreferredTraceIdFieldName() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.IDFieldNames.PreferredTraceName
 }
 
 func (f *fileConfig) GetParentIdFieldNames() []string {
@@ -987,23 +1961,370 @@ func (f *fileConfig) GetParentIdFieldNames() []string {
 	return f.mainConfig.IDFieldNames.ParentNames
 }
 
+func (f *fileConfig) GetSpanIdFieldNames() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.IDFieldNames.SpanNames
+}
+
+func (f *fileConfig) GetOTLPFieldMappings() OTLPFieldMappingsConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.OTLPFieldMappings
+}
+
+func (f *fileConfig) GetDecisionWebhookConfig() DecisionWebhookConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.DecisionWebhook
+}
+
+func (f *fileConfig) GetMirrorAllSpans() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Mirror.MirrorAllSpans
+}
+
+func (f *fileConfig) GetMirrorConfig() MirrorConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Mirror
+}
+
+func (f *fileConfig) GetShadowConfig() ShadowConfig {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Shadow
+}
+
 func (f *fileConfig) GetConfigMetadata() []ConfigMetadata {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
 	ret := make([]ConfigMetadata, 2)
 	ret[0] = ConfigMetadata{
-		Type:     "config",
-		ID:       f.opts.ConfigLocation,
-		Hash:     f.mainHash,
-		LoadedAt: f.lastLoadTime.Format(time.RFC3339),
+		Type:             "config",
+		ID:               f.opts.ConfigLocation,
+		Hash:             f.mainHash,
+		LoadedAt:         f.lastLoadTime.Format(time.RFC3339),
+		ValidationErrors: f.configFailures,
 	}
 	ret[1] = ConfigMetadata{
-		Type:     "rules",
-		ID:       f.opts.RulesLocation,
-		Hash:     f.rulesHash,
-		LoadedAt: f.lastLoadTime.Format(time.RFC3339),
+		Type:             "rules",
+		ID:               f.opts.RulesLocation,
+		Hash:             f.rulesHash,
+		LoadedAt:         f.lastLoadTime.Format(time.RFC3339),
+		ValidationErrors: f.rulesFailures,
 	}
 	return ret
 }
 
+func (f *fileConfig) GetJaegerDefaultDataset() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.JaegerDefaultDataset
+}
+
+func (f *fileConfig) GetDefaultDataset() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DefaultDataset
+}
+
+func (f *fileConfig) GetDegradedLoadThreshold() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DegradedLoadThreshold
+}
+
+func (f *fileConfig) GetMaxSpanAttributes() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxSpanAttributes
+}
+
+func (f *fileConfig) GetMaxSpanBytes() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return int(f.mainConfig.Specialized.MaxSpanBytes)
+}
+
+func (f *fileConfig) GetMaxFieldValueLength() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxFieldValueLength
+}
+
+func (f *fileConfig) GetMaxFieldValueLengths() map[string]int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxFieldValueLengths
+}
+
+func (f *fileConfig) GetMaxRequestBodySize() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return int(f.mainConfig.Specialized.MaxRequestBodySize)
+}
+
+func (f *fileConfig) GetMaxOTLPEventsPerRequest() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.MaxOTLPEventsPerRequest
+}
+
+func (f *fileConfig) GetOversizedSpanAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.OversizedSpanAction
+}
+
+func (f *fileConfig) GetMaxEventTimeSkewFuture() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.MaxEventTimeSkewFuture)
+}
+
+func (f *fileConfig) GetMaxEventTimeSkewPast() time.Duration {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return time.Duration(f.mainConfig.Specialized.MaxEventTimeSkewPast)
+}
+
+func (f *fileConfig) GetEventTimeSkewAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.EventTimeSkewAction
+}
+
+func (f *fileConfig) GetEventTimeSources() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.EventTimeSources
+}
+
+func (f *fileConfig) GetEmptyTraceIDAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.EmptyTraceIDAction
+}
+
+func (f *fileConfig) GetInvalidOTLPIDAction() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.InvalidOTLPIDAction
+}
+
+func (f *fileConfig) GetNonTraceEventHandling() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.NonTraceEventHandling
+}
+
+func (f *fileConfig) GetNonTraceEventDataset() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.NonTraceEventDataset
+}
+
+func (f *fileConfig) GetOnEnvironmentLookupFailure() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.OnEnvironmentLookupFailure
+}
+
+func (f *fileConfig) GetEnvironmentLookupFailureDataset() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.EnvironmentLookupFailureDataset
+}
+
+func (f *fileConfig) GetOTLPResourceAttributeAllowlist() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.OTLPResourceAttributeAllowlist
+}
+
+func (f *fileConfig) GetRedactedFields() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.RedactedFields
+}
+
+func (f *fileConfig) GetRedactionPlaceholder() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.RedactionPlaceholder
+}
+
+func (f *fileConfig) GetMaxLoggedBodyBytes() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return int(f.mainConfig.Specialized.MaxLoggedBodyBytes)
+}
+
+func (f *fileConfig) GetRedactLoggedBodies() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.RedactLoggedBodies
+}
+
+func (f *fileConfig) GetForceKeepConditions() []ForceKeepCondition {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if len(f.mainConfig.Specialized.ForceKeepConditions) == 0 {
+		return nil
+	}
+	conditions := make([]ForceKeepCondition, 0, len(f.mainConfig.Specialized.ForceKeepConditions))
+	for _, s := range f.mainConfig.Specialized.ForceKeepConditions {
+		conditions = append(conditions, parseForceKeepCondition(s))
+	}
+	return conditions
+}
+
+func (f *fileConfig) GetKeepErrorTraces() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.KeepErrorTraces
+}
+
+func (f *fileConfig) GetErrorTraceCondition() ForceKeepCondition {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return parseForceKeepCondition(f.mainConfig.Specialized.ErrorTraceCondition)
+}
+
+func (f *fileConfig) GetSyntheticTraceCondition() (ForceKeepCondition, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if f.mainConfig.Specialized.SyntheticTraceCondition == "" {
+		return ForceKeepCondition{}, false
+	}
+	return parseForceKeepCondition(f.mainConfig.Specialized.SyntheticTraceCondition), true
+}
+
+func (f *fileConfig) GetTraceCompleteCondition() (ForceKeepCondition, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if f.mainConfig.Specialized.TraceCompleteCondition == "" {
+		return ForceKeepCondition{}, false
+	}
+	return parseForceKeepCondition(f.mainConfig.Specialized.TraceCompleteCondition), true
+}
+
+func (f *fileConfig) GetDatasetTransforms() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DatasetTransforms
+}
+
+func (f *fileConfig) GetDatasetRoutingField() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DatasetRoutingField
+}
+
+func (f *fileConfig) GetDatasetRoutingTemplate() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DatasetRoutingTemplate
+}
+
+func (f *fileConfig) GetDatasetShards() map[string]int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DatasetShards
+}
+
+func (f *fileConfig) GetFieldTypeCoercions() map[string]string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.FieldTypeCoercions
+}
+
+func (f *fileConfig) GetSamplerKeyNormalizations() []string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.SamplerKeyNormalizations
+}
+
+func (f *fileConfig) GetStoreNormalizedValues() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.StoreNormalizedValues
+}
+
+func (f *fileConfig) GetDecodeJSONNumbers() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.DecodeJSONNumbers
+}
+
+func (f *fileConfig) GetStrictContentType() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.StrictContentType
+}
+
+func (f *fileConfig) GetVerifyBodyChecksum() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.VerifyBodyChecksum
+}
+
+func (f *fileConfig) GetBodyChecksumStage() string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return f.mainConfig.Specialized.BodyChecksumStage
+}
+
 func (f *fileConfig) GetAdditionalAttributes() map[string]string {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
@@ -1011,6 +2332,30 @@ func (f *fileConfig) GetAdditionalAttributes() map[string]string {
 	return f.mainConfig.Specialized.AdditionalAttributes
 }
 
+func (f *fileConfig) GetAdditionalAttributesForEnvironment(env string) map[string]string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return mergeAttributes(f.mainConfig.Specialized.AdditionalAttributes, f.mainConfig.Specialized.AdditionalAttributesByEnvironment[env])
+}
+
+// mergeAttributes returns the global attributes overridden by the
+// environment's. The global map is returned as is if there's nothing to
+// merge, so callers mustn't modify the result.
+func mergeAttributes(global, env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return global
+	}
+	merged := make(map[string]string, len(global)+len(env))
+	for k, v := range global {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	return merged
+}
+
 func (f *fileConfig) GetCentralStoreOptions() SmartWrapperOptions {
 	f.mux.RLock()
 	defer f.mux.RUnlock()
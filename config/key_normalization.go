@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyNormalization is an entry of SamplerKeyNormalizations, which cleans up
+// the values of a field that samplers key on.
+type KeyNormalization struct {
+	Field     string
+	Trim      bool
+	Lowercase bool
+}
+
+// ParseKeyNormalization parses an entry of SamplerKeyNormalizations, which
+// looks like <field>=<transforms>, where the transforms are a comma separated
+// list of trim and lowercase. The transforms follow the last equals sign, so
+// the field name may contain one.
+func ParseKeyNormalization(s string) (KeyNormalization, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return KeyNormalization{}, fmt.Errorf("sampler key normalization %q must look like <field>=<transforms>", s)
+	}
+	n := KeyNormalization{Field: strings.TrimSpace(s[:i])}
+	if n.Field == "" {
+		return n, fmt.Errorf("sampler key normalization %q needs a field name", s)
+	}
+	for _, transform := range strings.Split(s[i+1:], ",") {
+		switch strings.TrimSpace(transform) {
+		case "trim":
+			n.Trim = true
+		case "lowercase":
+			n.Lowercase = true
+		default:
+			return n, fmt.Errorf("sampler key normalization %q has unknown transform %q", s, transform)
+		}
+	}
+	return n, nil
+}
+
+// Apply returns value with the transforms applied.
+func (n KeyNormalization) Apply(value string) string {
+	if n.Trim {
+		value = strings.TrimSpace(value)
+	}
+	if n.Lowercase {
+		value = strings.ToLower(value)
+	}
+	return value
+}
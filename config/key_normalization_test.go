@@ -0,0 +1,35 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyNormalization(t *testing.T) {
+	tests := []struct {
+		normalization string
+		field         string
+		in, want      string
+	}{
+		{"http.method=trim", "http.method", " GET ", "GET"},
+		{"http.method=lowercase", "http.method", " GET ", " get "},
+		{"http.method=trim,lowercase", "http.method", " GET ", "get"},
+		{" name = lowercase , trim ", "name", "Get\t", "get"},
+		{"a=b=trim", "a=b", " x ", "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.normalization, func(t *testing.T) {
+			n, err := ParseKeyNormalization(tt.normalization)
+			require.NoError(t, err)
+			assert.Equal(t, tt.field, n.Field)
+			assert.Equal(t, tt.want, n.Apply(tt.in))
+		})
+	}
+
+	for _, bad := range []string{"", "http.method", "=trim", "http.method=", "http.method=uppercase", "http.method=trim,"} {
+		_, err := ParseKeyNormalization(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -8,75 +8,202 @@ import (
 // MockConfig will respond with whatever config it's set to do during
 // initialization
 type MockConfig struct {
-	Callbacks                        []ConfigReloadCallback
-	IsAPIKeyValidFunc                func(string) bool
-	GetCollectorTypeVal              string
-	GetCollectionConfigVal           CollectionConfig
-	GetHoneycombAPIVal               string
-	GetListenAddrVal                 string
-	GetPeerListenAddrVal             string
-	GetHTTPIdleTimeoutVal            time.Duration
-	GetCompressPeerCommunicationsVal bool
-	GetGRPCEnabledVal                bool
-	GetGRPCListenAddrVal             string
-	GetGRPCServerParameters          GRPCServerParameters
-	GetLoggerTypeVal                 string
-	GetHoneycombLoggerConfigVal      HoneycombLoggerConfig
-	GetStdoutLoggerConfigVal         StdoutLoggerConfig
-	GetLoggerLevelVal                Level
-	GetPeersVal                      []string
-	GetRedisHostVal                  string
-	GetRedisUsernameVal              string
-	GetRedisPasswordVal              string
-	GetRedisAuthCodeVal              string
-	GetRedisDatabaseVal              int
-	GetRedisPrefixVal                string
-	GetRedisMaxActiveVal             int
-	GetRedisMaxIdleVal               int
-	GetRedisTimeoutVal               time.Duration
-	GetParallelismVal                int
-	GetRedisMetricsCycleRateVal      time.Duration
-	GetUseTLSVal                     bool
-	GetUseTLSInsecureVal             bool
-	GetSamplerTypeErr                error //keep
-	GetSamplerTypeName               string
-	GetSamplerTypeVal                interface{}
-	GetMetricsTypeVal                string
-	GetLegacyMetricsConfigVal        LegacyMetricsConfig
-	GetPrometheusMetricsConfigVal    PrometheusMetricsConfig
-	GetOTelMetricsConfigVal          OTelMetricsConfig
-	GetOTelTracingConfigVal          OTelTracingConfig
-	GetSendDelayVal                  time.Duration
-	GetBatchTimeoutVal               time.Duration
-	GetTraceTimeoutVal               time.Duration
-	GetMaxBatchSizeVal               uint
-	GetUpstreamBufferSizeVal         int
-	GetPeerBufferSizeVal             int
-	SendTickerVal                    time.Duration
-	IdentifierInterfaceName          string
-	UseIPV6Identifier                bool
-	RedisIdentifier                  string
-	PeerManagementType               string
-	DebugServiceAddr                 string
-	DryRun                           bool
-	DryRunFieldName                  string
-	AddHostMetadataToTrace           bool
-	AddRuleReasonToTrace             bool
-	EnvironmentCacheTTL              time.Duration
-	DatasetPrefix                    string
-	QueryAuthToken                   string
-	PeerTimeout                      time.Duration
-	AdditionalErrorFields            []string
-	AddSpanCountToRoot               bool
-	AddCountsToRoot                  bool
-	CacheOverrunStrategy             string
-	SampleCache                      SampleCacheConfig
-	StressRelief                     StressReliefConfig
-	AdditionalAttributes             map[string]string
-	TraceIdFieldNames                []string
-	ParentIdFieldNames               []string
-	CfgMetadata                      []ConfigMetadata
-	StoreOptions                     SmartWrapperOptions
+	Callbacks                           []ConfigReloadCallback
+	IsAPIKeyValidFunc                   func(string) bool
+	GetCollectorTypeVal                 string
+	GetCollectionConfigVal              CollectionConfig
+	GetHoneycombAPIVal                  string
+	GetListenAddrVal                    string
+	GetPeerListenAddrVal                string
+	GetHTTPIdleTimeoutVal               time.Duration
+	GetCompressPeerCommunicationsVal    bool
+	GetGRPCEnabledVal                   bool
+	GetGRPCListenAddrVal                string
+	GetGRPCServerParameters             GRPCServerParameters
+	GetLoggerTypeVal                    string
+	GetHoneycombLoggerConfigVal         HoneycombLoggerConfig
+	GetStdoutLoggerConfigVal            StdoutLoggerConfig
+	GetLoggerLevelVal                   Level
+	DebugLogSampleRate                  int
+	GetPeersVal                         []string
+	MaxPeers                            int
+	MaxPeerListShrinkPercent            int
+	PeerListShrinkConfirmations         int
+	GetRedisHostVal                     string
+	GetRedisUsernameVal                 string
+	GetRedisPasswordVal                 string
+	GetRedisAuthCodeVal                 string
+	GetRedisDatabaseVal                 int
+	GetRedisPrefixVal                   string
+	GetRedisMaxActiveVal                int
+	GetRedisMaxIdleVal                  int
+	GetRedisTimeoutVal                  time.Duration
+	GetParallelismVal                   int
+	GetRedisMetricsCycleRateVal         time.Duration
+	GetUseTLSVal                        bool
+	GetUseTLSInsecureVal                bool
+	GetSamplerTypeErr                   error //keep
+	GetSamplerTypeName                  string
+	GetSamplerTypeVal                   interface{}
+	GetMetricsTypeVal                   string
+	GetLegacyMetricsConfigVal           LegacyMetricsConfig
+	GetPrometheusMetricsConfigVal       PrometheusMetricsConfig
+	GetOTelMetricsConfigVal             OTelMetricsConfig
+	GetOTelTracingConfigVal             OTelTracingConfig
+	GetSendDelayVal                     time.Duration
+	GetBatchTimeoutVal                  time.Duration
+	GetTraceTimeoutVal                  time.Duration
+	GetMaxBatchSizeVal                  uint
+	BatchingByDestination               map[string]BatchingConfig
+	GetUpstreamBufferSizeVal            int
+	GetPeerBufferSizeVal                int
+	SendTickerVal                       time.Duration
+	IdentifierInterfaceName             string
+	UseIPV6Identifier                   bool
+	RedisIdentifier                     string
+	PeerManagementType                  string
+	DebugServiceAddr                    string
+	DryRun                              bool
+	DryRunByEnvironment                 map[string]bool
+	DryRunFieldName                     string
+	AddHostMetadataToTrace              bool
+	AddRuleReasonToTrace                bool
+	EnvironmentCacheTTL                 time.Duration
+	DatasetPrefix                       string
+	QueryAuthToken                      string
+	QueryAuthTokens                     map[string]QueryScope
+	PeerTimeout                         time.Duration
+	AdditionalErrorFields               []string
+	AddSpanCountToRoot                  bool
+	AddCountsToRoot                     bool
+	CacheOverrunStrategy                string
+	SampleCache                         SampleCacheConfig
+	StressRelief                        StressReliefConfig
+	AdditionalAttributes                map[string]string
+	AdditionalAttributesByEnvironment   map[string]map[string]string
+	TraceIdFieldNames                   []string
+	ParentIdFieldNames                  []string
+	SpanIdFieldNames                    []string
+	OTLPFieldMappings                   OTLPFieldMappingsConfig
+	CfgMetadata                         []ConfigMetadata
+	StoreOptions                        SmartWrapperOptions
+	AllowedDatasets                     []string
+	DeniedDatasets                      []string
+	APIKeyQueryParam                    string
+	AdditionalAPIKeyHeaders             []string
+	MetricsPerEnvironment               bool
+	MaxMetricsEnvironments              int
+	DecodeJSONNumbers                   bool
+	StrictContentType                   bool
+	VerifyBodyChecksum                  bool
+	BodyChecksumStage                   string
+	HoneycombAPISRVName                 string
+	UpstreamSRVRefreshInterval          time.Duration
+	EnvironmentOverrideHeader           string
+	TraceIdHeaders                      map[string]string
+	DecisionWebhook                     DecisionWebhookConfig
+	JaegerDefaultDataset                string
+	MaxSpanAttributes                   int
+	MaxSpanBytes                        int
+	MaxFieldValueLength                 int
+	MaxFieldValueLengths                map[string]int
+	OversizedSpanAction                 string
+	DisableProxyUnmatchedRequests       bool // inverted so the zero value matches the default of true
+	GetConsulPeerManagementConfigVal    ConsulPeerManagementConfig
+	RedactedFields                      []string
+	RedactionPlaceholder                string
+	UnixSocketPath                      string
+	GRPCUnixSocketPath                  string
+	GRPCReflectionEnabled               bool
+	UpstreamMaxIdleConns                int
+	UpstreamMaxIdleConnsPerHost         int
+	UpstreamIdleConnTimeout             time.Duration
+	UpstreamMaxConnLifetime             time.Duration
+	UpstreamHealthCheckEnabled          bool
+	UpstreamHealthCheckInterval         time.Duration
+	UpstreamHealthCheckFailureThreshold int
+	GetTLSMinVersionVal                 uint16
+	GetTLSCipherSuitesVal               []uint16
+	ForceKeepConditions                 []ForceKeepCondition
+	SyntheticTraceCondition             ForceKeepCondition
+	TraceCompleteCondition              ForceKeepCondition
+	KeepErrorTraces                     bool
+	ErrorTraceCondition                 ForceKeepCondition
+	MaxConcurrentAuthLookups            int
+	AuthLookupWaitTimeout               time.Duration
+	OTLPListenAddr                      string
+	MaxSpansPerTrace                    int
+	IntraTraceSamplingThreshold         int
+	IntraTraceSampleRate                int
+	MaxIncomingQueueWait                time.Duration
+	EmptyTraceIDAction                  string
+	InvalidOTLPIDAction                 string
+	NonTraceEventHandling               string
+	NonTraceEventDataset                string
+	OnEnvironmentLookupFailure          string
+	EnvironmentLookupFailureDataset     string
+	OTLPResourceAttributeAllowlist      []string
+	HoneycombAPIByEnvironment           map[string]string
+	UpstreamAPIKeys                     map[string]string
+	AllowMissingAPIKey                  bool // inverted so the zero value matches the default of true
+	APIKeyValidationCacheTTL            time.Duration
+	APIKeyValidationNegativeCacheTTL    time.Duration
+	SlowRequestThreshold                time.Duration
+	AddNodeMetadataToTrace              bool
+	AddIngestLagField                   bool
+	AllowNegativeIngestLag              bool
+	ZstdDecoderWaitTimeout              time.Duration
+	MaxConcurrentDecompressions         int
+	MaxOTLPEventsPerRequest             int
+	DatasetTransforms                   []string
+	DatasetShards                       map[string]int
+	DatasetRoutingField                 string
+	DatasetRoutingTemplate              string
+	FieldTypeCoercions                  map[string]string
+	TraceIdConflictAction               string
+	PreferredTraceIdFieldName           string
+	GRPCMaxConcurrentExports            int
+	UpstreamProxyURL                    string
+	UpstreamNoProxy                     []string
+	DroppedSampleRetention              int
+	DroppedSampleRate                   int
+	CompressUpstreamCommunication       bool
+	AddTraceDurationToRoot              bool
+	AddServiceCountToRoot               bool
+	MirrorAllSpans                      bool
+	Mirror                              MirrorConfig
+	Shadow                              ShadowConfig
+	AuthLookupTimeout                   time.Duration
+	MaxAuthResponseSize                 MemorySize
+	MaxDistinctEnvironments             int
+	RejectExcessEnvironments            bool
+	MaxDistinctDatasets                 int
+	CatchAllDataset                     string
+	UpstreamSendConcurrency             uint
+	MaxEffectiveSampleRate              uint
+	MaxEventTimeSkewFuture              time.Duration
+	MaxEventTimeSkewPast                time.Duration
+	EventTimeSkewAction                 string
+	EventTimeSources                    []string
+	DebugEndpointsEnabled               bool
+	DebugTraceSinkEnabled               bool
+	DebugTraceSinkFraction              float64
+	DebugTraceSinkPath                  string
+	GRPCMaxConnections                  int
+	SamplerKeyNormalizations            []string
+	StoreNormalizedValues               bool
+	MaxRequestBodySize                  int
+	DefaultDataset                      string
+	UpstreamCircuitBreakerThreshold     int
+	UpstreamCircuitBreakerCooldown      time.Duration
+	UpstreamSpillPath                   string
+	UpstreamSpillMaxSize                int64
+	PreserveIncomingSampleRate          bool
+	DegradedLoadThreshold               int
+	MaxTraceAge                         time.Duration
+	MaxTraceAgeAction                   string
+	MaxLoggedBodyBytes                  int
+	RedactLoggedBodies                  bool
 
 	Mux sync.RWMutex
 }
@@ -204,6 +331,16 @@ func (m *MockConfig) GetLoggerLevel() Level {
 	return m.GetLoggerLevelVal
 }
 
+func (m *MockConfig) GetDebugLogSampleRate() int {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	if m.DebugLogSampleRate == 0 {
+		return 1
+	}
+	return m.DebugLogSampleRate
+}
+
 func (m *MockConfig) GetPeers() []string {
 	m.Mux.RLock()
 	defer m.Mux.RUnlock()
@@ -211,6 +348,30 @@ func (m *MockConfig) GetPeers() []string {
 	return m.GetPeersVal
 }
 
+func (m *MockConfig) GetMaxPeers() int {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	return m.MaxPeers
+}
+
+func (m *MockConfig) GetMaxPeerListShrinkPercent() int {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	return m.MaxPeerListShrinkPercent
+}
+
+func (m *MockConfig) GetPeerListShrinkConfirmations() int {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	if m.PeerListShrinkConfirmations == 0 {
+		return 3
+	}
+	return m.PeerListShrinkConfirmations
+}
+
 func (m *MockConfig) GetRedisHost() string {
 	m.Mux.RLock()
 	defer m.Mux.RUnlock()
@@ -362,6 +523,13 @@ func (m *MockConfig) GetMaxBatchSize() uint {
 	return m.GetMaxBatchSizeVal
 }
 
+func (m *MockConfig) GetBatchingForDestination(environment, dataset string) (uint, time.Duration) {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	return resolveBatching(m.BatchingByDestination, environment, dataset, m.GetMaxBatchSizeVal, m.GetBatchTimeoutVal)
+}
+
 // TODO: allow per-dataset mock values
 func (m *MockConfig) GetSamplerConfigForDestName(dataset string) (interface{}, string, error) {
 	m.Mux.RLock()
@@ -370,6 +538,21 @@ func (m *MockConfig) GetSamplerConfigForDestName(dataset string) (interface{}, s
 	return m.GetSamplerTypeVal, m.GetSamplerTypeName, m.GetSamplerTypeErr
 }
 
+func (m *MockConfig) GetSamplerResolution(dataset string) SamplerResolution {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	res := SamplerResolution{Destination: dataset, Name: m.GetSamplerTypeName}
+	if m.GetSamplerTypeErr == nil {
+		res.Checked = []SamplerCandidate{{Key: dataset, Present: true}}
+		res.Matched, res.Sampler, res.Found = dataset, m.GetSamplerTypeVal, true
+		res.Reason = dataset + " has its own sampler"
+	} else {
+		res.Reason = m.GetSamplerTypeErr.Error()
+	}
+	return res
+}
+
 // GetAllSamplerRules normally returns all dataset rules, including the default
 // In this mock, it returns only the rules for "dataset1" according to the type of the value field
 func (m *MockConfig) GetAllSamplerRules() *V2SamplerConfig {
@@ -462,6 +645,16 @@ func (m *MockConfig) GetIsDryRun() bool {
 	return m.DryRun
 }
 
+func (m *MockConfig) GetIsDryRunForEnvironment(env string) bool {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+
+	if dryRun, ok := m.DryRunByEnvironment[env]; ok {
+		return dryRun
+	}
+	return m.DryRun
+}
+
 func (m *MockConfig) GetAddHostMetadataToTrace() bool {
 	m.Mux.RLock()
 	defer m.Mux.RUnlock()
@@ -497,6 +690,13 @@ func (f *MockConfig) GetQueryAuthToken() string {
 	return f.QueryAuthToken
 }
 
+func (f *MockConfig) GetQueryAuthTokens() map[string]QueryScope {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.QueryAuthTokens
+}
+
 func (f *MockConfig) GetGRPCConfig() GRPCServerParameters {
 	f.Mux.RLock()
 	defer f.Mux.RUnlock()
@@ -545,6 +745,13 @@ func (f *MockConfig) GetStressReliefConfig() StressReliefConfig {
 
 	return f.StressRelief
 }
+
+func (f *MockConfig) GetStressReliefHashAlgorithm() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.StressRelief.HashAlgorithm
+}
 func (f *MockConfig) GetTraceIdFieldNames() []string {
 	f.Mux.RLock()
 	defer f.Mux.RUnlock()
@@ -562,6 +769,20 @@ func (f *MockConfig) GetParentIdFieldNames() []string {
 	return f.ParentIdFieldNames
 }
 
+func (f *MockConfig) GetSpanIdFieldNames() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.SpanIdFieldNames
+}
+
+func (f *MockConfig) GetOTLPFieldMappings() OTLPFieldMappingsConfig {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.OTLPFieldMappings
+}
+
 func (f *MockConfig) GetConfigMetadata() []ConfigMetadata {
 	f.Mux.RLock()
 	defer f.Mux.RUnlock()
@@ -576,6 +797,13 @@ func (f *MockConfig) GetAdditionalAttributes() map[string]string {
 	return f.AdditionalAttributes
 }
 
+func (f *MockConfig) GetAdditionalAttributesForEnvironment(env string) map[string]string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return mergeAttributes(f.AdditionalAttributes, f.AdditionalAttributesByEnvironment[env])
+}
+
 func (f *MockConfig) GetCentralStoreOptions() SmartWrapperOptions {
 	f.Mux.RLock()
 	defer f.Mux.RUnlock()
@@ -590,3 +818,855 @@ func (f *MockConfig) GetCentralStoreOptions() SmartWrapperOptions {
 
 	return f.StoreOptions
 }
+
+func (f *MockConfig) GetAllowedDatasets() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AllowedDatasets
+}
+
+func (f *MockConfig) GetDeniedDatasets() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DeniedDatasets
+}
+
+func (f *MockConfig) GetAPIKeyQueryParam() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.APIKeyQueryParam
+}
+
+func (f *MockConfig) GetAdditionalAPIKeyHeaders() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AdditionalAPIKeyHeaders
+}
+
+func (f *MockConfig) GetMetricsPerEnvironmentEnabled() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MetricsPerEnvironment
+}
+
+func (f *MockConfig) GetMaxMetricsEnvironments() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxMetricsEnvironments
+}
+
+func (f *MockConfig) GetDecodeJSONNumbers() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DecodeJSONNumbers
+}
+
+func (f *MockConfig) GetStrictContentType() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.StrictContentType
+}
+
+func (f *MockConfig) GetVerifyBodyChecksum() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.VerifyBodyChecksum
+}
+
+func (f *MockConfig) GetBodyChecksumStage() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.BodyChecksumStage == "" {
+		return "decompressed"
+	}
+	return f.BodyChecksumStage
+}
+
+func (f *MockConfig) GetHoneycombAPISRVName() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.HoneycombAPISRVName
+}
+
+func (f *MockConfig) GetUpstreamSRVRefreshInterval() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamSRVRefreshInterval
+}
+
+func (f *MockConfig) GetEnvironmentOverrideHeader() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.EnvironmentOverrideHeader
+}
+
+func (f *MockConfig) GetTraceIdHeaders() map[string]string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.TraceIdHeaders
+}
+
+func (f *MockConfig) GetDecisionWebhookConfig() DecisionWebhookConfig {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DecisionWebhook
+}
+
+func (f *MockConfig) GetJaegerDefaultDataset() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.JaegerDefaultDataset
+}
+
+func (f *MockConfig) GetMaxSpanAttributes() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxSpanAttributes
+}
+
+func (f *MockConfig) GetMaxSpanBytes() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxSpanBytes
+}
+
+func (f *MockConfig) GetMaxFieldValueLength() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxFieldValueLength
+}
+
+func (f *MockConfig) GetMaxFieldValueLengths() map[string]int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxFieldValueLengths
+}
+
+func (f *MockConfig) GetOversizedSpanAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.OversizedSpanAction
+}
+
+func (f *MockConfig) GetProxyUnmatchedRequests() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return !f.DisableProxyUnmatchedRequests
+}
+
+func (f *MockConfig) GetConsulPeerManagementConfig() ConsulPeerManagementConfig {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GetConsulPeerManagementConfigVal
+}
+
+func (f *MockConfig) GetRedactedFields() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.RedactedFields
+}
+
+func (f *MockConfig) GetRedactionPlaceholder() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.RedactionPlaceholder
+}
+
+func (f *MockConfig) GetUnixSocketPath() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UnixSocketPath
+}
+
+func (f *MockConfig) GetGRPCUnixSocketPath() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GRPCUnixSocketPath
+}
+
+func (f *MockConfig) GetGRPCReflectionEnabled() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GRPCReflectionEnabled
+}
+
+func (f *MockConfig) GetUpstreamMaxIdleConns() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamMaxIdleConns
+}
+
+func (f *MockConfig) GetUpstreamMaxIdleConnsPerHost() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamMaxIdleConnsPerHost
+}
+
+func (f *MockConfig) GetUpstreamIdleConnTimeout() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamIdleConnTimeout
+}
+
+func (f *MockConfig) GetUpstreamMaxConnLifetime() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamMaxConnLifetime
+}
+
+func (f *MockConfig) GetUpstreamHealthCheckEnabled() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamHealthCheckEnabled
+}
+
+func (f *MockConfig) GetUpstreamHealthCheckInterval() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamHealthCheckInterval
+}
+
+func (f *MockConfig) GetUpstreamHealthCheckFailureThreshold() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamHealthCheckFailureThreshold
+}
+
+func (f *MockConfig) GetTLSMinVersion() uint16 {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GetTLSMinVersionVal
+}
+
+func (f *MockConfig) GetTLSCipherSuites() []uint16 {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GetTLSCipherSuitesVal
+}
+
+func (f *MockConfig) GetForceKeepConditions() []ForceKeepCondition {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.ForceKeepConditions
+}
+
+func (f *MockConfig) GetKeepErrorTraces() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.KeepErrorTraces
+}
+
+func (f *MockConfig) GetErrorTraceCondition() ForceKeepCondition {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.ErrorTraceCondition.Field == "" {
+		return ForceKeepCondition{Field: "error", Value: "true", HasValue: true}
+	}
+	return f.ErrorTraceCondition
+}
+
+func (f *MockConfig) GetSyntheticTraceCondition() (ForceKeepCondition, bool) {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.SyntheticTraceCondition, f.SyntheticTraceCondition.Field != ""
+}
+
+func (f *MockConfig) GetTraceCompleteCondition() (ForceKeepCondition, bool) {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.TraceCompleteCondition, f.TraceCompleteCondition.Field != ""
+}
+
+func (f *MockConfig) GetMaxConcurrentAuthLookups() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxConcurrentAuthLookups
+}
+
+func (f *MockConfig) GetAuthLookupWaitTimeout() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AuthLookupWaitTimeout
+}
+
+func (f *MockConfig) GetOTLPListenAddr() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.OTLPListenAddr
+}
+
+func (f *MockConfig) GetMaxSpansPerTrace() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxSpansPerTrace
+}
+
+func (f *MockConfig) GetIntraTraceSamplingThreshold() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.IntraTraceSamplingThreshold
+}
+
+func (f *MockConfig) GetIntraTraceSampleRate() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.IntraTraceSampleRate == 0 {
+		return 10
+	}
+	return f.IntraTraceSampleRate
+}
+
+func (f *MockConfig) GetMaxIncomingQueueWait() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxIncomingQueueWait
+}
+
+func (f *MockConfig) GetEmptyTraceIDAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.EmptyTraceIDAction
+}
+
+func (f *MockConfig) GetInvalidOTLPIDAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.InvalidOTLPIDAction == "" {
+		return "accept"
+	}
+	return f.InvalidOTLPIDAction
+}
+
+func (f *MockConfig) GetNonTraceEventHandling() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.NonTraceEventHandling == "" {
+		return "forward"
+	}
+	return f.NonTraceEventHandling
+}
+
+func (f *MockConfig) GetNonTraceEventDataset() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.NonTraceEventDataset
+}
+
+func (f *MockConfig) GetOnEnvironmentLookupFailure() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.OnEnvironmentLookupFailure == "" {
+		return "reject"
+	}
+	return f.OnEnvironmentLookupFailure
+}
+
+func (f *MockConfig) GetEnvironmentLookupFailureDataset() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.EnvironmentLookupFailureDataset
+}
+
+func (f *MockConfig) GetOTLPResourceAttributeAllowlist() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.OTLPResourceAttributeAllowlist
+}
+
+func (f *MockConfig) GetHoneycombAPIForEnvironment(env string) string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if api, ok := f.HoneycombAPIByEnvironment[env]; ok && env != "" {
+		return api
+	}
+	return f.GetHoneycombAPIVal
+}
+
+func (f *MockConfig) GetRejectMissingAPIKey() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return !f.AllowMissingAPIKey
+}
+
+func (f *MockConfig) GetAPIKeyValidationCacheTTL() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.APIKeyValidationCacheTTL
+}
+
+func (f *MockConfig) GetAPIKeyValidationNegativeCacheTTL() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.APIKeyValidationNegativeCacheTTL
+}
+
+func (f *MockConfig) GetUpstreamAPIKeyForDest(dest string) string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if dest == "" {
+		return ""
+	}
+	return f.UpstreamAPIKeys[dest]
+}
+
+func (f *MockConfig) GetSlowRequestThreshold() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.SlowRequestThreshold
+}
+
+func (f *MockConfig) GetAddNodeMetadataToTrace() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AddNodeMetadataToTrace
+}
+
+func (f *MockConfig) GetAddIngestLagField() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AddIngestLagField
+}
+
+func (f *MockConfig) GetAllowNegativeIngestLag() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AllowNegativeIngestLag
+}
+
+func (f *MockConfig) GetZstdDecoderWaitTimeout() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.ZstdDecoderWaitTimeout
+}
+
+func (f *MockConfig) GetMaxConcurrentDecompressions() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxConcurrentDecompressions
+}
+
+func (f *MockConfig) GetMaxOTLPEventsPerRequest() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxOTLPEventsPerRequest
+}
+
+func (f *MockConfig) GetDatasetTransforms() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DatasetTransforms
+}
+
+func (f *MockConfig) GetTraceIdConflictAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.TraceIdConflictAction
+}
+
+func (f *MockConfig) GetPreferredTraceIdFieldName() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.PreferredTraceIdFieldName
+}
+
+func (f *MockConfig) GetGRPCMaxConcurrentExports() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GRPCMaxConcurrentExports
+}
+
+func (f *MockConfig) GetUpstreamProxyURL() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamProxyURL
+}
+
+func (f *MockConfig) GetUpstreamNoProxy() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamNoProxy
+}
+
+func (f *MockConfig) GetDroppedSampleRetention() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DroppedSampleRetention
+}
+
+func (f *MockConfig) GetDroppedSampleRate() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DroppedSampleRate
+}
+
+func (f *MockConfig) GetCompressUpstreamCommunication() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.CompressUpstreamCommunication
+}
+
+func (f *MockConfig) GetAddTraceDurationToRoot() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AddTraceDurationToRoot
+}
+
+func (f *MockConfig) GetAddServiceCountToRoot() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AddServiceCountToRoot
+}
+
+func (f *MockConfig) GetMirrorAllSpans() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MirrorAllSpans
+}
+
+func (f *MockConfig) GetMirrorConfig() MirrorConfig {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.Mirror
+}
+
+func (f *MockConfig) GetShadowConfig() ShadowConfig {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.Shadow
+}
+
+func (f *MockConfig) GetAuthLookupTimeout() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.AuthLookupTimeout
+}
+
+func (f *MockConfig) GetMaxAuthResponseSize() MemorySize {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxAuthResponseSize
+}
+
+func (f *MockConfig) GetMaxDistinctEnvironments() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxDistinctEnvironments
+}
+
+func (f *MockConfig) GetRejectExcessEnvironments() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.RejectExcessEnvironments
+}
+
+func (f *MockConfig) GetMaxDistinctDatasets() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxDistinctDatasets
+}
+
+func (f *MockConfig) GetCatchAllDataset() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.CatchAllDataset
+}
+
+func (f *MockConfig) GetUpstreamSendConcurrency() uint {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamSendConcurrency
+}
+
+func (f *MockConfig) GetMaxEffectiveSampleRate() uint {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxEffectiveSampleRate
+}
+
+func (f *MockConfig) GetMaxEventTimeSkewFuture() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxEventTimeSkewFuture
+}
+
+func (f *MockConfig) GetMaxEventTimeSkewPast() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxEventTimeSkewPast
+}
+
+func (f *MockConfig) GetEventTimeSkewAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.EventTimeSkewAction
+}
+
+func (f *MockConfig) GetEventTimeSources() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.EventTimeSources == nil {
+		return []string{"header", "body"}
+	}
+	return f.EventTimeSources
+}
+
+func (f *MockConfig) GetDebugTraceSinkEnabled() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DebugTraceSinkEnabled
+}
+
+func (f *MockConfig) GetDebugTraceSinkFraction() float64 {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DebugTraceSinkFraction
+}
+
+func (f *MockConfig) GetDebugTraceSinkPath() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DebugTraceSinkPath
+}
+
+func (f *MockConfig) GetDebugEndpointsEnabled() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DebugEndpointsEnabled
+}
+
+func (f *MockConfig) GetGRPCMaxConnections() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.GRPCMaxConnections
+}
+
+func (f *MockConfig) GetSamplerKeyNormalizations() []string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.SamplerKeyNormalizations
+}
+
+func (f *MockConfig) GetStoreNormalizedValues() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.StoreNormalizedValues
+}
+
+func (f *MockConfig) GetMaxRequestBodySize() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.MaxRequestBodySize == 0 {
+		return 20 * 1024 * 1024
+	}
+	return f.MaxRequestBodySize
+}
+
+func (f *MockConfig) GetDefaultDataset() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DefaultDataset
+}
+
+func (f *MockConfig) GetUpstreamCircuitBreakerThreshold() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamCircuitBreakerThreshold
+}
+
+func (f *MockConfig) GetUpstreamCircuitBreakerCooldown() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamCircuitBreakerCooldown
+}
+
+func (f *MockConfig) GetUpstreamSpillPath() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamSpillPath
+}
+
+func (f *MockConfig) GetUpstreamSpillMaxSize() int64 {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.UpstreamSpillMaxSize
+}
+
+func (f *MockConfig) GetPreserveIncomingSampleRate() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.PreserveIncomingSampleRate
+}
+
+func (f *MockConfig) GetDegradedLoadThreshold() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DegradedLoadThreshold
+}
+
+func (f *MockConfig) GetMaxTraceAge() time.Duration {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxTraceAge
+}
+
+func (f *MockConfig) GetMaxTraceAgeAction() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.MaxTraceAgeAction
+}
+
+func (f *MockConfig) GetMaxLoggedBodyBytes() int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	if f.MaxLoggedBodyBytes == 0 {
+		return 1024
+	}
+	return f.MaxLoggedBodyBytes
+}
+
+func (f *MockConfig) GetRedactLoggedBodies() bool {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.RedactLoggedBodies
+}
+
+func (f *MockConfig) GetDatasetRoutingField() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DatasetRoutingField
+}
+
+func (f *MockConfig) GetDatasetRoutingTemplate() string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DatasetRoutingTemplate
+}
+
+func (f *MockConfig) GetDatasetShards() map[string]int {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.DatasetShards
+}
+
+func (f *MockConfig) GetFieldTypeCoercions() map[string]string {
+	f.Mux.RLock()
+	defer f.Mux.RUnlock()
+
+	return f.FieldTypeCoercions
+}
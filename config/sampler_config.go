@@ -176,6 +176,7 @@ type DynamicSamplerConfig struct {
 	FieldList      []string `json:"fieldlist" yaml:"FieldList,omitempty" validate:"required"`
 	MaxKeys        int      `json:"maxkeys" yaml:"MaxKeys,omitempty"`
 	UseTraceLength bool     `json:"usetracelength" yaml:"UseTraceLength,omitempty"`
+	MaxSampleRate  int      `json:"maxsamplerate" yaml:"MaxSampleRate,omitempty"`
 }
 
 func (d *DynamicSamplerConfig) GetSamplingFields() []string {
@@ -194,6 +195,7 @@ type EMADynamicSamplerConfig struct {
 	FieldList           []string `json:"fieldlist" yaml:"FieldList,omitempty" validate:"required"`
 	MaxKeys             int      `json:"maxkeys" yaml:"MaxKeys,omitempty"`
 	UseTraceLength      bool     `json:"usetracelength" yaml:"UseTraceLength,omitempty"`
+	MaxSampleRate       int      `json:"maxsamplerate" yaml:"MaxSampleRate,omitempty"`
 }
 
 func (d *EMADynamicSamplerConfig) GetSamplingFields() []string {
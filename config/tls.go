@@ -0,0 +1,21 @@
+package config
+
+import "crypto/tls"
+
+// tlsVersions maps the TLS.MinVersion choices to their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuiteID returns the ID of the cipher suite with the given IANA
+// name, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only the suites that Go
+// considers secure are allowed.
+func tlsCipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
@@ -163,18 +163,22 @@ func validateDatatype(k string, v any, typ string) string {
 				return fmt.Sprintf("field %s (%v) must be a hostport: %v", k, v, err)
 			}
 		}
-	case "url":
+	case "url", "urlOrBlank", "srvurl":
 		if !isString(v) {
 			return fmt.Sprintf("field %s must be a URL", k)
 		}
-		if typ == "url" && v.(string) == "" {
+		if (typ == "url" || typ == "srvurl") && v.(string) == "" {
 			return fmt.Sprintf("field %s may not be blank", k)
 		}
 		if typ == "urlOrBlank" && v.(string) == "" {
 			return ""
 		}
 
-		u, err := url.Parse(v.(string))
+		raw := v.(string)
+		if typ == "srvurl" {
+			raw = strings.TrimPrefix(raw, srvSchemePrefix)
+		}
+		u, err := url.Parse(raw)
 		if err != nil {
 			return fmt.Sprintf("field %s (%v) must be a valid URL: %v", k, v, err)
 		}
@@ -184,6 +188,13 @@ func validateDatatype(k string, v any, typ string) string {
 		if u.Scheme != "http" && u.Scheme != "https" {
 			return fmt.Sprintf("field %s (%v) must use an http or https scheme", k, v)
 		}
+	case "regex":
+		if !isString(v) {
+			return fmt.Sprintf("field %s must be a regular expression", k)
+		}
+		if _, err := regexp.Compile(v.(string)); err != nil {
+			return fmt.Sprintf("field %s (%v) must be a valid regular expression: %v", k, v, err)
+		}
 	case "defaulttrue":
 		switch val := v.(type) {
 		case bool:
@@ -355,6 +366,49 @@ func (m *Metadata) Validate(data map[string]any) []string {
 				default:
 					errors = append(errors, fmt.Sprintf("field %s must be a map or array", k))
 				}
+			case "tlsCipherSuites":
+				if arr, ok := v.([]any); ok {
+					for _, vv := range arr {
+						name, _ := vv.(string)
+						if _, ok := tlsCipherSuiteID(name); !ok {
+							errors = append(errors, fmt.Sprintf("field %s contains unknown or insecure TLS cipher suite %v", k, vv))
+						}
+					}
+				}
+			case "datasetTransforms":
+				if arr, ok := v.([]any); ok {
+					for _, vv := range arr {
+						entry, _ := vv.(string)
+						if _, err := ParseDatasetTransform(entry); err != nil {
+							errors = append(errors, fmt.Sprintf("field %s: %v", k, err))
+						}
+					}
+				}
+			case "fieldTypes":
+				if m, ok := v.(map[string]any); ok {
+					for kk, vv := range m {
+						if name, _ := vv.(string); !slices.Contains(FieldTypes, name) {
+							errors = append(errors, fmt.Sprintf("field %s[%s] (%v) must be one of %v", k, kk, vv, FieldTypes))
+						}
+					}
+				}
+			case "eventTimeSources":
+				if arr, ok := v.([]any); ok {
+					for _, vv := range arr {
+						if name, _ := vv.(string); !slices.Contains(EventTimeSources, name) {
+							errors = append(errors, fmt.Sprintf("field %s (%v) must only contain %v", k, vv, EventTimeSources))
+						}
+					}
+				}
+			case "keyNormalizations":
+				if arr, ok := v.([]any); ok {
+					for _, vv := range arr {
+						entry, _ := vv.(string)
+						if _, err := ParseKeyNormalization(entry); err != nil {
+							errors = append(errors, fmt.Sprintf("field %s: %v", k, err))
+						}
+					}
+				}
 			case "validChildren":
 				if _, ok := v.(map[string]any); ok {
 					for kk := range v.(map[string]any) {
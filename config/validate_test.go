@@ -80,6 +80,13 @@ func Test_validateType(t *testing.T) {
 		{"url blank", "k", "", "url", `field k may not be blank`},
 		{"url noscheme", "k", "example.com", "url", `field k (example.com) must be a valid URL with a host`},
 		{"url badscheme", "k", "ftp://example.com", "url", `field k (ftp://example.com) must use an http or https scheme`},
+		{"url srv", "k", "srv+https://example.com", "url", `field k (srv+https://example.com) must use an http or https scheme`},
+		{"srvurl", "k", "srv+https://_api._tcp.example.com", "srvurl", ""},
+		{"srvurl plain", "k", "https://example.com", "srvurl", ""},
+		{"srvurl badscheme", "k", "srv+ftp://example.com", "srvurl", `field k (srv+ftp://example.com) must use an http or https scheme`},
+		{"urlOrBlank", "k", "https://example.com/hook", "urlOrBlank", ""},
+		{"urlOrBlank blank", "k", "", "urlOrBlank", ""},
+		{"urlOrBlank bad", "k", "example.com", "urlOrBlank", `field k (example.com) must be a valid URL with a host`},
 		{"invalid memorysize", "k", "test", "memorysize", `field k (test) must be a valid memory size like '1Gb' or '100_000_000'`},
 		{"valid memorysize G", "k", "1G", "memorysize", ""},
 		{"valid memorysize Gi", "k", "1Gi", "memorysize", ""},
@@ -219,6 +226,15 @@ groups:
         validations:
           - type: elementType
             arg: string
+      - name: ARegexMap
+        type: map
+        validations:
+          - type: elementType
+            arg: regex
+      - name: ACipherSuites
+        type: stringarray
+        validations:
+          - type: tlsCipherSuites
 
 `
 
@@ -314,8 +330,13 @@ func Test_validate(t *testing.T) {
 		{"good conflicts with A", mm("ConflictTest.FieldA", 2), ""},
 		{"good slice elementType", mm("Traces.AStringArray", []any{"0.0.0.0:8080", "192.168.1.1:8080"}), ""},
 		{"bad slice elementType", mm("Traces.AStringArray", []any{"0.0.0.0"}), "field Traces.AStringArray[0] (0.0.0.0) must be a hostport: address 0.0.0.0: missing port in address"},
+		{"good tlsCipherSuites", mm("Traces.ACipherSuites", []any{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}), ""},
+		{"unknown tlsCipherSuites", mm("Traces.ACipherSuites", []any{"TLS_MADE_UP"}), "field Traces.ACipherSuites contains unknown or insecure TLS cipher suite TLS_MADE_UP"},
+		{"insecure tlsCipherSuites", mm("Traces.ACipherSuites", []any{"TLS_RSA_WITH_RC4_128_SHA"}), "field Traces.ACipherSuites contains unknown or insecure TLS cipher suite TLS_RSA_WITH_RC4_128_SHA"},
 		{"good map elementType", mm("Traces.AStringMap", map[string]any{"k": "v"}), ""},
 		{"bad map elementType", mm("Traces.AStringMap", map[string]any{"k": 1}), "field Traces.AStringMap[k] must be a string"},
+		{"good regex elementType", mm("Traces.ARegexMap", map[string]any{"k": "Root=([^;]+)", "e": ""}), ""},
+		{"bad regex elementType", mm("Traces.ARegexMap", map[string]any{"k": "Root=("}), "field Traces.ARegexMap[k] (Root=() must be a valid regular expression"},
 		{"bad peer url", mm("PeerManagement.Peers", []any{"0.0.0.0:8082", "http://192.168.1.1:8088"}), "must be a valid UR"},
 		{"good peer url", mm("PeerManagement.Peers", []any{"http://0.0.0.0:8082", "http://192.168.1.1:8088"}), ""},
 	}
@@ -0,0 +1,317 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// consulWaitTime is how long a blocking query for the service's healthy
+	// instances waits for a change before Consul answers anyway.
+	consulWaitTime = 30 * time.Second
+
+	// consulRetryInterval is how long to wait before asking Consul again
+	// after a failed query.
+	consulRetryInterval = 5 * time.Second
+
+	// consulDeregisterAfter is how long Consul keeps a registration whose
+	// health check is failing, in case the node didn't get to deregister.
+	consulDeregisterAfter = time.Minute
+)
+
+var _ Peers = (*ConsulPeerStore)(nil)
+
+// ConsulPeerStore is a Peers implementation that registers this node as a
+// Consul service and counts the service's healthy instances as the peers.
+// Peer info is still exchanged over gossip, as with PeerStore.
+type ConsulPeerStore struct {
+	PeerStore `inject:"inline"`
+
+	client  *http.Client
+	baseURL string
+	service string
+	token   string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// the IDs of the healthy instances from the last successful query; they
+	// are kept if Consul stops answering
+	consulPeersLock sync.RWMutex
+	consulPeers     map[string]struct{}
+}
+
+type consulServiceRegistration struct {
+	ID      string
+	Name    string
+	Address string `json:",omitempty"`
+	Port    int    `json:",omitempty"`
+	Check   consulServiceCheck
+}
+
+type consulServiceCheck struct {
+	CheckID                        string
+	TTL                            string
+	DeregisterCriticalServiceAfter string
+}
+
+type consulServiceEntry struct {
+	Service struct {
+		ID string
+	}
+}
+
+func (c *ConsulPeerStore) Start() error {
+	if err := c.PeerStore.Start(); err != nil {
+		return err
+	}
+
+	cfg := c.Config.GetConsulPeerManagementConfig()
+	c.baseURL = cfg.Address
+	if !strings.Contains(c.baseURL, "://") {
+		c.baseURL = "http://" + c.baseURL
+	}
+	c.service = cfg.ServiceName
+	c.token = cfg.Token
+	// blocking queries are held open by Consul for up to consulWaitTime
+	c.client = &http.Client{Timeout: consulWaitTime + 10*time.Second}
+	c.consulPeers = make(map[string]struct{})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	if err := c.register(); err != nil {
+		c.cancel()
+		c.PeerStore.Stop()
+		return fmt.Errorf("failed to register with consul at %s: %w", cfg.Address, err)
+	}
+
+	c.wg.Add(2)
+	go c.keepAlive()
+	go c.watch()
+	return nil
+}
+
+// Stop deregisters this node from Consul, so that its peers stop counting it
+// right away instead of waiting for its health check to fail.
+func (c *ConsulPeerStore) Stop() error {
+	c.cancel()
+	c.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.request(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(c.identification), nil, nil, nil)
+	if err != nil {
+		logrus.WithError(err).Error("failed to deregister from consul")
+	}
+
+	return c.PeerStore.Stop()
+}
+
+// GetPeerCount returns the number of healthy instances of the service,
+// including this host even if Consul hasn't seen it pass its check yet.
+func (c *ConsulPeerStore) GetPeerCount() int {
+	c.consulPeersLock.RLock()
+	defer c.consulPeersLock.RUnlock()
+
+	return c.peerGuard.capCount(c.Config, c.countPeers(c.consulPeers))
+}
+
+// GetPeers returns the IDs of the healthy instances of the service, and this
+// host's, sorted.
+func (c *ConsulPeerStore) GetPeers() []string {
+	c.consulPeersLock.RLock()
+	defer c.consulPeersLock.RUnlock()
+
+	ids := make([]string, 0, len(c.consulPeers)+1)
+	for id := range c.consulPeers {
+		ids = append(ids, id)
+	}
+	if _, ok := c.consulPeers[c.identification]; !ok {
+		ids = append(ids, c.identification)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *ConsulPeerStore) countPeers(peers map[string]struct{}) int {
+	count := len(peers)
+	if _, ok := peers[c.identification]; !ok {
+		count++
+	}
+	return count
+}
+
+func (c *ConsulPeerStore) checkID() string {
+	return "service:" + c.identification
+}
+
+func (c *ConsulPeerStore) register() error {
+	reg := consulServiceRegistration{
+		ID:   c.identification,
+		Name: c.service,
+		Check: consulServiceCheck{
+			CheckID:                        c.checkID(),
+			TTL:                            peerEntryTimeout.String(),
+			DeregisterCriticalServiceAfter: consulDeregisterAfter.String(),
+		},
+	}
+
+	// advertise the address that peers should use to reach us
+	reg.Address = c.Config.GetRedisIdentifier()
+	if reg.Address == "" {
+		reg.Address, _ = os.Hostname()
+	}
+	if _, port, err := net.SplitHostPort(c.Config.GetPeerListenAddr()); err == nil {
+		reg.Port, _ = strconv.Atoi(port)
+	}
+
+	if err := c.request(c.ctx, http.MethodPut, "/v1/agent/service/register", nil, reg, nil); err != nil {
+		return err
+	}
+	// pass the check right away so that we're counted without waiting for the
+	// first keepalive
+	return c.passCheck()
+}
+
+func (c *ConsulPeerStore) passCheck() error {
+	return c.request(c.ctx, http.MethodPut, "/v1/agent/check/pass/"+url.PathEscape(c.checkID()), nil, nil, nil)
+}
+
+// keepAlive passes the TTL health check for this node until stopped.
+func (c *ConsulPeerStore) keepAlive() {
+	defer c.wg.Done()
+
+	tk := c.Clock.NewTicker(keepAliveInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-tk.Chan():
+			if err := c.passCheck(); err != nil && c.ctx.Err() == nil {
+				logrus.WithError(err).Error("failed to pass consul health check")
+			}
+		}
+	}
+}
+
+// watch runs blocking queries for the healthy instances of the service, and
+// updates the peer list whenever it changes. If Consul can't be reached, the
+// last known peers are kept.
+func (c *ConsulPeerStore) watch() {
+	defer c.wg.Done()
+
+	var index uint64
+	for {
+		newIndex, err := c.fetchPeers(index)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("failed to get peers from consul; keeping the last known peers")
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-c.Clock.After(consulRetryInterval):
+			}
+			continue
+		}
+		// per Consul's guidance, start over if the index goes backwards
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+	}
+}
+
+// fetchPeers waits for the healthy instances of the service to change from
+// those at index, updates the peer list, and returns the new index.
+func (c *ConsulPeerStore) fetchPeers(index uint64) (uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	query.Set("wait", consulWaitTime.String())
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+	}
+
+	var entries []consulServiceEntry
+	var header http.Header
+	err := c.request(c.ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(c.service), query, nil, func(resp *http.Response) error {
+		header = resp.Header
+		return json.NewDecoder(resp.Body).Decode(&entries)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	peers := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		peers[entry.Service.ID] = struct{}{}
+	}
+	c.consulPeersLock.Lock()
+	if c.peerGuard.allowShrink(c.Config, c.countPeers(c.consulPeers), c.countPeers(peers)) {
+		c.consulPeers = peers
+	}
+	c.consulPeersLock.Unlock()
+
+	newIndex, err := strconv.ParseUint(header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid X-Consul-Index header: %w", err)
+	}
+	return newIndex, nil
+}
+
+// request makes a call to the Consul HTTP API. If body is not nil, it's sent
+// as JSON; if handle is not nil, it's called with a successful response.
+func (c *ConsulPeerStore) request(ctx context.Context, method, path string, query url.Values, body interface{}, handle func(*http.Response) error) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if handle != nil {
+		return handle(resp)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package peer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/internal/gossip"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsul answers the few Consul API calls that ConsulPeerStore makes.
+type fakeConsul struct {
+	mut          sync.Mutex
+	services     map[string]string
+	passes       map[string]int
+	deregistered []string
+	tokens       []string
+	down         atomic.Bool
+	index        atomic.Uint64
+}
+
+func newFakeConsul() *fakeConsul {
+	f := &fakeConsul{
+		services: make(map[string]string),
+		passes:   make(map[string]int),
+	}
+	f.index.Store(1)
+	return f
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if f.down.Load() {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.tokens = append(f.tokens, req.Header.Get("X-Consul-Token"))
+
+	switch {
+	case req.URL.Path == "/v1/agent/service/register":
+		var reg consulServiceRegistration
+		if err := json.NewDecoder(req.Body).Decode(&reg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.services[reg.ID] = reg.Name
+		f.index.Add(1)
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/check/pass/"):
+		f.passes[strings.TrimPrefix(req.URL.Path, "/v1/agent/check/pass/")]++
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")
+		delete(f.services, id)
+		f.deregistered = append(f.deregistered, id)
+		f.index.Add(1)
+	case strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		name := strings.TrimPrefix(req.URL.Path, "/v1/health/service/")
+		entries := make([]consulServiceEntry, 0)
+		for id, svc := range f.services {
+			if svc == name {
+				var entry consulServiceEntry
+				entry.Service.ID = id
+				entries = append(entries, entry)
+			}
+		}
+		// block briefly if nothing has changed, like a real blocking query
+		current := strconv.FormatUint(f.index.Load(), 10)
+		if req.URL.Query().Get("index") == current {
+			f.mut.Unlock()
+			select {
+			case <-req.Context().Done():
+			case <-time.After(20 * time.Millisecond):
+			}
+			f.mut.Lock()
+		}
+		w.Header().Set("X-Consul-Index", current)
+		json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) register(id, name string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.services[id] = name
+}
+
+func newTestConsulPeerStore(t *testing.T, addr string) *ConsulPeerStore {
+	g := &gossip.InMemoryGossip{}
+	require.NoError(t, g.Start())
+	return &ConsulPeerStore{
+		PeerStore: PeerStore{
+			identification: "peer1",
+			Clock:          clockwork.NewFakeClock(),
+			Gossip:         g,
+			Config: &config.MockConfig{
+				GetPeerListenAddrVal: "0.0.0.0:8081",
+				GetConsulPeerManagementConfigVal: config.ConsulPeerManagementConfig{
+					Address:     addr,
+					ServiceName: "refinery",
+					Token:       "secret",
+				},
+			},
+		},
+	}
+}
+
+func TestConsulPeers(t *testing.T) {
+	consul := newFakeConsul()
+	consul.register("peer2", "refinery")
+	consul.register("peer3", "refinery")
+	consul.register("other", "other-service")
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	store := newTestConsulPeerStore(t, strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, store.Start())
+
+	assert.Eventually(t, func() bool {
+		return store.GetPeerCount() == 3
+	}, time.Second, 10*time.Millisecond)
+
+	consul.mut.Lock()
+	assert.Equal(t, 1, consul.passes["service:peer1"])
+	assert.NotContains(t, consul.tokens, "")
+	assert.Contains(t, consul.tokens, "secret")
+	consul.mut.Unlock()
+
+	// an outage keeps the last known peers
+	consul.down.Store(true)
+	store.Clock.(clockwork.FakeClock).Advance(consulRetryInterval)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, store.GetPeerCount())
+	consul.down.Store(false)
+
+	require.NoError(t, store.Stop())
+	consul.mut.Lock()
+	defer consul.mut.Unlock()
+	assert.Equal(t, []string{"peer1"}, consul.deregistered)
+	assert.NotContains(t, consul.services, "peer1")
+}
+
+func TestConsulPeersRegistrationFails(t *testing.T) {
+	consul := newFakeConsul()
+	consul.down.Store(true)
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	store := newTestConsulPeerStore(t, server.URL)
+	assert.Error(t, store.Start())
+}
@@ -0,0 +1,66 @@
+package peer
+
+import (
+	"sync"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/sirupsen/logrus"
+)
+
+// peerListGuard protects the peer list from peer discovery flapping. A
+// hiccup in Redis or Consul can make most peers seem to disappear at once,
+// and acting on that would change every node's view of the cluster, only to
+// change it back moments later.
+type peerListGuard struct {
+	mut sync.Mutex
+	// shrinkPolls is the number of consecutive polls that have seen the
+	// peer list shrink by more than MaxPeerListShrinkPercent
+	shrinkPolls int
+	capped      bool
+}
+
+// allowShrink reports whether a poll that found proposed peers may replace
+// the current list of current peers. A shrink of more than
+// MaxPeerListShrinkPercent is only allowed once PeerListShrinkConfirmations
+// consecutive polls have seen it; until then, the current list is kept.
+func (g *peerListGuard) allowShrink(cfg config.Config, current, proposed int) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	limit := cfg.GetMaxPeerListShrinkPercent()
+	if limit <= 0 || proposed >= current || (current-proposed)*100 <= current*limit {
+		g.shrinkPolls = 0
+		return true
+	}
+
+	g.shrinkPolls++
+	fields := logrus.Fields{
+		"current_peers":  current,
+		"proposed_peers": proposed,
+		"polls":          g.shrinkPolls,
+	}
+	if g.shrinkPolls < cfg.GetPeerListShrinkConfirmations() {
+		logrus.WithFields(fields).Warn("suppressing a sudden drop in the number of peers until it's confirmed")
+		return false
+	}
+	logrus.WithFields(fields).Warn("accepting a confirmed drop in the number of peers")
+	g.shrinkPolls = 0
+	return true
+}
+
+// capCount limits count to MaxPeers, if it's set.
+func (g *peerListGuard) capCount(cfg config.Config, count int) int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	maxPeers := cfg.GetMaxPeers()
+	if maxPeers <= 0 || count <= maxPeers {
+		g.capped = false
+		return count
+	}
+	if !g.capped {
+		logrus.WithFields(logrus.Fields{"peers": count, "max_peers": maxPeers}).Warn("more peers than MaxPeers; ignoring the extra peers")
+		g.capped = true
+	}
+	return maxPeers
+}
@@ -0,0 +1,49 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerListGuard_AllowShrink(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		g := &peerListGuard{}
+		assert.True(t, g.allowShrink(&config.MockConfig{}, 10, 1))
+	})
+
+	cfg := &config.MockConfig{MaxPeerListShrinkPercent: 50, PeerListShrinkConfirmations: 3}
+
+	t.Run("small changes are accepted", func(t *testing.T) {
+		g := &peerListGuard{}
+		assert.True(t, g.allowShrink(cfg, 10, 20))
+		assert.True(t, g.allowShrink(cfg, 10, 5))
+	})
+
+	t.Run("large drops need confirmation", func(t *testing.T) {
+		g := &peerListGuard{}
+		assert.False(t, g.allowShrink(cfg, 10, 1))
+		assert.False(t, g.allowShrink(cfg, 10, 2))
+		assert.True(t, g.allowShrink(cfg, 10, 1), "the third poll confirms the drop")
+		assert.False(t, g.allowShrink(cfg, 10, 1), "each drop is confirmed separately")
+	})
+
+	t.Run("a flap resets the confirmations", func(t *testing.T) {
+		g := &peerListGuard{}
+		assert.False(t, g.allowShrink(cfg, 10, 1))
+		assert.False(t, g.allowShrink(cfg, 10, 1))
+		assert.True(t, g.allowShrink(cfg, 10, 10))
+		assert.False(t, g.allowShrink(cfg, 10, 1))
+	})
+}
+
+func TestPeerListGuard_CapCount(t *testing.T) {
+	g := &peerListGuard{}
+	assert.Equal(t, 100, g.capCount(&config.MockConfig{}, 100))
+
+	cfg := &config.MockConfig{MaxPeers: 5}
+	assert.Equal(t, 3, g.capCount(cfg, 3))
+	assert.Equal(t, 5, g.capCount(cfg, 5))
+	assert.Equal(t, 5, g.capCount(cfg, 100))
+}
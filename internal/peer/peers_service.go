@@ -18,6 +18,9 @@ type Peers interface {
 	GetPeerCount() int
 	// HostID returns the unique identifier for the local peer.
 	HostID() string
+	// GetPeers returns the identifiers of the active peers, sorted. It
+	// includes the local peer.
+	GetPeers() []string
 
 	startstop.Starter
 	startstop.Stopper
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/internal/gossip"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/jonboulle/clockwork"
@@ -19,12 +20,14 @@ func TestPeers(t *testing.T) {
 		identification: "peer1",
 		Clock:          clock,
 		Gossip:         gossip,
+		Config:         &config.MockConfig{},
 	}
 
 	peer2 := &PeerStore{
 		identification: "peer2",
 		Clock:          clock,
 		Gossip:         gossip,
+		Config:         &config.MockConfig{},
 	}
 	require.NoError(t, peer1.Start())
 	require.NoError(t, peer2.Start())
@@ -52,6 +55,8 @@ func TestPeers(t *testing.T) {
 
 	require.Equal(t, 2, peer1.GetPeerCount())
 	require.Equal(t, 2, peer2.GetPeerCount())
+	require.Equal(t, []string{"peer1", "peer2"}, peer1.GetPeers())
+	require.Equal(t, []string{"peer1", "peer2"}, peer2.GetPeers())
 }
 
 func TestPeer_GetPeerCount(t *testing.T) {
@@ -65,6 +70,7 @@ func TestPeer_GetPeerCount(t *testing.T) {
 		identification: "peer1",
 		Clock:          peer1Clock,
 		Gossip:         gossip,
+		Config:         &config.MockConfig{},
 	}
 
 	peer2Clock := clockwork.NewFakeClock()
@@ -72,6 +78,7 @@ func TestPeer_GetPeerCount(t *testing.T) {
 		identification: "peer2",
 		Clock:          peer2Clock,
 		Gossip:         gossip,
+		Config:         &config.MockConfig{},
 	}
 	require.NoError(t, peer1.Start())
 	require.NoError(t, peer2.Start())
@@ -85,8 +92,10 @@ func TestPeer_GetPeerCount(t *testing.T) {
 
 	}, 200*time.Millisecond, 10*time.Millisecond)
 
+	// quiet peers are expired on the next tick of the poll, not when the
+	// count is read
 	require.EventuallyWithT(t, func(collect *assert.CollectT) {
 		peer1Clock.Advance(10 * peerEntryTimeout)
-		require.Equal(t, 1, peer1.GetPeerCount())
-	}, 100*time.Millisecond, 10*time.Millisecond)
+		assert.Equal(collect, 1, peer1.GetPeerCount())
+	}, 500*time.Millisecond, 10*time.Millisecond)
 }
@@ -4,10 +4,12 @@ import (
 	"errors"
 	"maps"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/internal/gossip"
 	"github.com/jonboulle/clockwork"
 
@@ -33,14 +35,16 @@ var _ Peers = (*PeerStore)(nil)
 type PeerStore struct {
 	Gossip gossip.Gossiper `inject:"gossip"`
 	Clock  clockwork.Clock `inject:""`
+	Config config.Config   `inject:""`
 
 	done           chan struct{}
 	identification string
 
 	peerChan chan []byte
 
-	peerLock sync.RWMutex
-	peers    map[string]time.Time
+	peerLock  sync.RWMutex
+	peers     map[string]time.Time
+	peerGuard peerListGuard
 
 	publishChan chan PeerInfo
 
@@ -99,19 +103,51 @@ func (p *PeerStore) PublishPeerInfo(info PeerInfo) error {
 // GetPeerCount returns the number of active peers in the cluster.
 // This includes the current host.
 func (p *PeerStore) GetPeerCount() int {
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+
+	// We're not in the peer list, so we add ourselves.
+	return p.peerGuard.capCount(p.Config, len(p.peers)+1)
+}
+
+// GetPeers returns the identifiers of the peers that have checked in
+// recently, and this host's, sorted.
+func (p *PeerStore) GetPeers() []string {
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+
+	ids := make([]string, 0, len(p.peers)+1)
+	for id := range p.peers {
+		ids = append(ids, id)
+	}
+	ids = append(ids, p.identification)
+	sort.Strings(ids)
+	return ids
+}
+
+// expirePeers removes the peers that haven't checked in for peerEntryTimeout,
+// unless so many have gone quiet at once that peerGuard suspects a problem
+// with gossip rather than with the peers.
+func (p *PeerStore) expirePeers() {
 	p.peerLock.Lock()
 	defer p.peerLock.Unlock()
 
-	maps.DeleteFunc(p.peers, func(id string, ts time.Time) bool {
+	expired := func(id string, ts time.Time) bool {
 		return p.Clock.Since(ts) > peerEntryTimeout
-	})
-
-	// If we're the only peer, we won't be in the peer list, so we should return 1.
-	if len(p.peers) == 0 {
-		return 1
 	}
-
-	return len(p.peers) + 1
+	var count int
+	for id, ts := range p.peers {
+		if expired(id, ts) {
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	current := len(p.peers) + 1
+	if p.peerGuard.allowShrink(p.Config, current, current-count) {
+		maps.DeleteFunc(p.peers, expired)
+	}
 }
 
 // HostID returns the unique identifier for this host.
@@ -166,12 +202,19 @@ func (p *PeerStore) publish() {
 	}
 }
 
-// watchPeers listens for new peer info from the cluster and triggers callbacks.
+// watchPeers listens for new peer info from the cluster and triggers
+// callbacks, and periodically expires peers that have stopped checking in.
 func (p *PeerStore) watchPeers() {
 	defer p.wg.Done()
 
+	tk := p.Clock.NewTicker(keepAliveInterval)
+	defer tk.Stop()
+
 	for {
 		select {
+		case <-tk.Chan():
+			p.expirePeers()
+
 		case msg := <-p.peerChan:
 			info, err := newPeerInfoFromBytes(msg)
 			if err != nil {
@@ -0,0 +1,247 @@
+package srv
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/jonboulle/clockwork"
+)
+
+// Resolver spreads upstream connections across the targets of a DNS SRV
+// record. When HoneycombAPI uses an srv+ scheme, the rest of Refinery sees a
+// plain URL whose host is the SRV record name; Resolver is installed as the
+// dialer on the upstream transport and substitutes one of the resolved
+// targets whenever a new connection to that host is made.
+//
+// Targets are re-resolved every UpstreamSRVRefreshInterval. If a lookup fails
+// or returns no targets, the last good set is kept.
+type Resolver struct {
+	Config config.Config   `inject:""`
+	Logger logger.Logger   `inject:""`
+	Clock  clockwork.Clock `inject:""`
+
+	// lookupSRV is overridable for tests
+	lookupSRV func(ctx context.Context, name string) ([]*net.SRV, error)
+
+	mut     sync.RWMutex
+	name    string
+	targets []string
+	next    atomic.Uint64
+
+	// refreshNow asks the watch loop for an immediate refresh
+	refreshNow chan struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (r *Resolver) Start() error {
+	if r.lookupSRV == nil {
+		r.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return addrs, err
+		}
+	}
+	r.done = make(chan struct{})
+	r.refreshNow = make(chan struct{}, 1)
+	r.refresh()
+
+	r.wg.Add(1)
+	go r.watch()
+	return nil
+}
+
+func (r *Resolver) Stop() error {
+	if r.done != nil {
+		close(r.done)
+		r.wg.Wait()
+	}
+	return nil
+}
+
+// WrapTransport makes t dial through the resolver. Connections to any host
+// other than the SRV record name are dialed normally.
+//
+// Setting DialTLSContext makes the transport ignore its TLSClientConfig and
+// TLSHandshakeTimeout, so dialTLS applies both itself, for every host.
+func (r *Resolver) WrapTransport(t *http.Transport, dialer *net.Dialer) {
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		target, _ := r.targetFor(addr)
+		return dialer.DialContext(ctx, network, target)
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		target, _ := r.targetFor(addr)
+		return dialTLS(ctx, t, dialer, network, target)
+	}
+}
+
+// dialTLS connects to addr and performs a TLS handshake the same way
+// http.Transport would without a DialTLSContext: it uses the transport's
+// TLSClientConfig, verifies the certificate against the host being dialed
+// unless a ServerName is configured, and limits the handshake to
+// TLSHandshakeTimeout. For SRV targets, that means the certificate is checked
+// against the target we're actually talking to.
+func dialTLS(ctx context.Context, t *http.Transport, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// targetFor returns the address to dial for addr, and whether it was replaced
+// by an SRV target. It never blocks on DNS; if there are no targets yet, addr
+// is returned unchanged and a refresh is requested in the background.
+func (r *Resolver) targetFor(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, false
+	}
+
+	r.mut.RLock()
+	name, targets := r.name, r.targets
+	r.mut.RUnlock()
+
+	if name == "" || !strings.EqualFold(host, name) {
+		return addr, false
+	}
+	if len(targets) == 0 {
+		// we've never had a good resolution; try again now rather than
+		// waiting for the next refresh
+		select {
+		case r.refreshNow <- struct{}{}:
+		default:
+		}
+		return addr, false
+	}
+
+	n := r.next.Add(1)
+	return targets[int(n%uint64(len(targets)))], true
+}
+
+func (r *Resolver) watch() {
+	defer r.wg.Done()
+
+	interval := r.Config.GetUpstreamSRVRefreshInterval()
+	ticker := r.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.refreshNow:
+			r.refresh()
+		case <-ticker.Chan():
+			r.refresh()
+			// pick up changes to the interval after a config reload
+			if newInterval := r.Config.GetUpstreamSRVRefreshInterval(); newInterval != interval {
+				interval = newInterval
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// refresh resolves the configured SRV record and replaces the target set if
+// the lookup succeeds with at least one target.
+func (r *Resolver) refresh() {
+	name := r.Config.GetHoneycombAPISRVName()
+	if name == "" {
+		r.mut.Lock()
+		r.name = ""
+		r.targets = nil
+		r.mut.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := r.lookupSRV(ctx, name)
+	targets := targetsFromSRV(addrs)
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if !strings.EqualFold(r.name, name) {
+		// the record name changed, so the old targets are no longer relevant
+		r.name = name
+		r.targets = nil
+	}
+	if err != nil || len(targets) == 0 {
+		entry := r.Logger.Error().WithString("srv_name", name).WithField("last_good_targets", len(r.targets))
+		if err != nil {
+			entry = entry.WithString("error", err.Error())
+		}
+		entry.Logf("failed to resolve upstream SRV record; keeping previous targets")
+		return
+	}
+
+	if !slices.Equal(r.targets, targets) {
+		r.Logger.Info().WithString("srv_name", name).WithField("targets", targets).Logf("upstream SRV targets changed")
+	}
+	r.targets = targets
+}
+
+// targetsFromSRV returns the host:port addresses of the records that share the
+// best (lowest) priority, in a stable order. Weights are not considered;
+// targets are used in round-robin order.
+func targetsFromSRV(addrs []*net.SRV) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	best := addrs[0].Priority
+	for _, a := range addrs {
+		if a.Priority < best {
+			best = a.Priority
+		}
+	}
+
+	targets := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a.Priority != best {
+			continue
+		}
+		host := strings.TrimSuffix(a.Target, ".")
+		if host == "" {
+			continue
+		}
+		targets = append(targets, net.JoinHostPort(host, strconv.Itoa(int(a.Port))))
+	}
+	sort.Strings(targets)
+	return targets
+}
@@ -0,0 +1,148 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	var records []*net.SRV
+	var lookupErr error
+	r := &Resolver{
+		Config: &config.MockConfig{HoneycombAPISRVName: "_api._tcp.example.com"},
+		Logger: &logger.NullLogger{},
+		Clock:  clockwork.NewFakeClock(),
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			return records, lookupErr
+		},
+	}
+
+	records = []*net.SRV{
+		{Target: "b.example.com.", Port: 443, Priority: 10},
+		{Target: "a.example.com.", Port: 443, Priority: 10},
+		{Target: "backup.example.com.", Port: 443, Priority: 20},
+	}
+	r.refresh()
+
+	// only the best priority targets are used, in round-robin order
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		target, replaced := r.targetFor("_api._tcp.example.com:443")
+		assert.True(t, replaced)
+		seen[target]++
+	}
+	assert.Equal(t, map[string]int{"a.example.com:443": 2, "b.example.com:443": 2}, seen)
+
+	// other hosts are untouched
+	target, replaced := r.targetFor("other.example.com:443")
+	assert.False(t, replaced)
+	assert.Equal(t, "other.example.com:443", target)
+
+	// failed or empty resolutions keep the last good set
+	lookupErr = errors.New("boom")
+	r.refresh()
+	assert.Equal(t, []string{"a.example.com:443", "b.example.com:443"}, r.targets)
+	lookupErr = nil
+	records = nil
+	r.refresh()
+	assert.Equal(t, []string{"a.example.com:443", "b.example.com:443"}, r.targets)
+
+	// changes are picked up
+	records = []*net.SRV{{Target: "c.example.com.", Port: 8443, Priority: 1}}
+	r.refresh()
+	target, _ = r.targetFor("_api._tcp.example.com:443")
+	assert.Equal(t, "c.example.com:8443", target)
+}
+
+func TestResolverDisabled(t *testing.T) {
+	r := &Resolver{
+		Config: &config.MockConfig{},
+		Logger: &logger.NullLogger{},
+		Clock:  clockwork.NewFakeClock(),
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			t.Error("should not look up SRV records when not configured")
+			return nil, nil
+		},
+	}
+	r.refresh()
+	target, replaced := r.targetFor("api.honeycomb.io:443")
+	assert.False(t, replaced)
+	assert.Equal(t, "api.honeycomb.io:443", target)
+}
+
+func TestResolverNoTargetsDoesNotBlock(t *testing.T) {
+	r := &Resolver{
+		Config:     &config.MockConfig{HoneycombAPISRVName: "_api._tcp.example.com"},
+		Logger:     &logger.NullLogger{},
+		Clock:      clockwork.NewFakeClock(),
+		refreshNow: make(chan struct{}, 1),
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			return nil, errors.New("boom")
+		},
+	}
+	r.refresh()
+
+	// with nothing resolved yet, the address is used as is and a refresh is
+	// requested from the watch loop instead of being done inline
+	target, replaced := r.targetFor("_api._tcp.example.com:443")
+	assert.False(t, replaced)
+	assert.Equal(t, "_api._tcp.example.com:443", target)
+	assert.Len(t, r.refreshNow, 1)
+}
+
+func TestWrapTransportTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	r := &Resolver{
+		Config: &config.MockConfig{HoneycombAPISRVName: "_api._tcp.example.com"},
+		Logger: &logger.NullLogger{},
+		Clock:  clockwork.NewFakeClock(),
+	}
+
+	t.Run("uses the transport's TLS config for other hosts", func(t *testing.T) {
+		transport := &http.Transport{
+			TLSClientConfig:     server.Client().Transport.(*http.Transport).TLSClientConfig.Clone(),
+			TLSHandshakeTimeout: time.Second,
+		}
+		r.WrapTransport(transport, &net.Dialer{})
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("honors TLSHandshakeTimeout", func(t *testing.T) {
+		// a listener that accepts connections but never completes a handshake
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		transport := &http.Transport{TLSHandshakeTimeout: 100 * time.Millisecond}
+		r.WrapTransport(transport, &net.Dialer{})
+		start := time.Now()
+		_, err = (&http.Client{Transport: transport}).Get("https://" + l.Addr().String())
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
+)
+
+const (
+	counterSent    = "decision_webhook_sent"
+	counterDropped = "decision_webhook_dropped"
+	counterErrors  = "decision_webhook_errors"
+)
+
+// DecisionRecord is the compact description of a sampling decision that is
+// sent to the webhook.
+type DecisionRecord struct {
+	TraceID         string    `json:"trace_id"`
+	Dataset         string    `json:"dataset,omitempty"`
+	SamplerSelector string    `json:"sampler_selector"`
+	Kept            bool      `json:"kept"`
+	SampleRate      uint      `json:"sample_rate"`
+	Reason          string    `json:"reason"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// DecisionDispatcher sends DecisionRecords to the URL configured in
+// DecisionWebhook. Records are queued and sent by a single background worker;
+// if the queue is full, records are dropped rather than making the caller
+// wait, so a slow or broken webhook can never hold up trace processing.
+//
+// If no URL is configured, the dispatcher does nothing.
+type DecisionDispatcher struct {
+	Config    config.Config   `inject:""`
+	Logger    logger.Logger   `inject:""`
+	Metrics   metrics.Metrics `inject:"genericMetrics"`
+	Transport *http.Transport `inject:"upstreamTransport"`
+
+	url       string
+	batchSize int
+	client    *http.Client
+	records   chan DecisionRecord
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (d *DecisionDispatcher) Start() error {
+	d.Metrics.Register(counterSent, "counter")
+	d.Metrics.Register(counterDropped, "counter")
+	d.Metrics.Register(counterErrors, "counter")
+
+	cfg := d.Config.GetDecisionWebhookConfig()
+	if cfg.URL == "" {
+		return nil
+	}
+
+	d.url = cfg.URL
+	d.batchSize = cfg.BatchSize
+	if d.batchSize < 1 {
+		d.batchSize = 1
+	}
+	d.client = &http.Client{
+		Timeout: time.Duration(cfg.Timeout),
+	}
+	// a nil *http.Transport would not be a nil RoundTripper
+	if d.Transport != nil {
+		d.client.Transport = d.Transport
+	}
+	d.records = make(chan DecisionRecord, cfg.QueueSize)
+	d.done = make(chan struct{})
+
+	d.wg.Add(1)
+	go d.run()
+
+	d.Logger.Info().WithString("url", d.url).Logf("sending sampling decisions to webhook")
+	return nil
+}
+
+func (d *DecisionDispatcher) Stop() error {
+	if d.done != nil {
+		close(d.done)
+		d.wg.Wait()
+	}
+	return nil
+}
+
+// Enabled reports whether the dispatcher is sending records, so that callers
+// can skip building them when it isn't. It is safe to call on a nil
+// dispatcher.
+func (d *DecisionDispatcher) Enabled() bool {
+	return d != nil && d.records != nil
+}
+
+// Enqueue queues a record to be sent. It never blocks; if the queue is full
+// the record is dropped. It is safe to call on a nil or disabled dispatcher.
+func (d *DecisionDispatcher) Enqueue(rec DecisionRecord) {
+	if !d.Enabled() {
+		return
+	}
+	select {
+	case d.records <- rec:
+	default:
+		d.Metrics.Increment(counterDropped)
+	}
+}
+
+func (d *DecisionDispatcher) run() {
+	defer d.wg.Done()
+
+	batch := make([]DecisionRecord, 0, d.batchSize)
+	for {
+		select {
+		case <-d.done:
+			return
+		case rec := <-d.records:
+			batch = append(batch[:0], rec)
+			// pick up whatever else is already waiting
+		fill:
+			for len(batch) < d.batchSize {
+				select {
+				case rec := <-d.records:
+					batch = append(batch, rec)
+				default:
+					break fill
+				}
+			}
+			if err := d.send(batch); err != nil {
+				d.Metrics.Count(counterErrors, len(batch))
+				d.Logger.Error().
+					WithString("url", d.url).
+					WithField("records", len(batch)).
+					WithString("error", err.Error()).
+					Logf("failed to send sampling decisions to webhook")
+				continue
+			}
+			d.Metrics.Count(counterSent, len(batch))
+		}
+	}
+}
+
+func (d *DecisionDispatcher) send(batch []DecisionRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	// abandon the request if we're asked to stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
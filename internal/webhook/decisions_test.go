@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDispatcher(t *testing.T, cfg config.DecisionWebhookConfig) (*DecisionDispatcher, *metrics.MockMetrics) {
+	m := &metrics.MockMetrics{}
+	m.Start()
+	d := &DecisionDispatcher{
+		Config:  &config.MockConfig{DecisionWebhook: cfg},
+		Logger:  &logger.NullLogger{},
+		Metrics: m,
+	}
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { d.Stop() })
+	return d, m
+}
+
+func TestDecisionDispatcherSends(t *testing.T) {
+	var mut sync.Mutex
+	var received []DecisionRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var batch []DecisionRecord
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mut.Lock()
+		received = append(received, batch...)
+		mut.Unlock()
+	}))
+	defer server.Close()
+
+	d, m := newTestDispatcher(t, config.DecisionWebhookConfig{
+		URL:       server.URL,
+		QueueSize: 100,
+		BatchSize: 10,
+		Timeout:   config.Duration(time.Second),
+	})
+	assert.True(t, d.Enabled())
+
+	for i := 0; i < 25; i++ {
+		d.Enqueue(DecisionRecord{TraceID: "trace", Kept: i%2 == 0, SampleRate: 10, Reason: "rules"})
+	}
+
+	assert.Eventually(t, func() bool {
+		n, _ := m.Get(counterSent)
+		return n == 25
+	}, time.Second, 10*time.Millisecond)
+
+	mut.Lock()
+	defer mut.Unlock()
+	require.Len(t, received, 25)
+	assert.Equal(t, DecisionRecord{TraceID: "trace", Kept: true, SampleRate: 10, Reason: "rules"}, received[0])
+}
+
+func TestDecisionDispatcherNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	d, m := newTestDispatcher(t, config.DecisionWebhookConfig{
+		URL:       server.URL,
+		QueueSize: 5,
+		BatchSize: 1,
+		Timeout:   config.Duration(time.Second),
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			d.Enqueue(DecisionRecord{TraceID: "trace"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked on a stuck webhook")
+	}
+
+	dropped, _ := m.Get(counterDropped)
+	assert.GreaterOrEqual(t, dropped, float64(100-5-1))
+}
+
+func TestDecisionDispatcherDisabled(t *testing.T) {
+	d, _ := newTestDispatcher(t, config.DecisionWebhookConfig{})
+	// must not panic or block
+	d.Enqueue(DecisionRecord{TraceID: "trace"})
+	assert.False(t, d.Enabled())
+
+	var nilDispatcher *DecisionDispatcher
+	nilDispatcher.Enqueue(DecisionRecord{TraceID: "trace"})
+	assert.False(t, nilDispatcher.Enabled())
+}
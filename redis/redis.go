@@ -141,7 +141,8 @@ func buildOptions(c config.RedisConfig) []redis.DialOption {
 	tlsInsecure := c.GetUseTLSInsecure()
 	if useTLS {
 		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
+			MinVersion:   c.GetTLSMinVersion(),
+			CipherSuites: c.GetTLSCipherSuites(),
 		}
 
 		if tlsInsecure {
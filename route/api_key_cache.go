@@ -0,0 +1,85 @@
+package route
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCachedAPIKeys bounds the memory used by the API key validation cache,
+// which could otherwise be filled by clients sending made-up keys.
+const maxCachedAPIKeys = 10_000
+
+// apiKeyValidationCache remembers the results of Config.IsAPIKeyValid. Its
+// zero value is an empty cache.
+type apiKeyValidationCache struct {
+	mut   sync.RWMutex
+	items map[string]apiKeyValidation
+}
+
+type apiKeyValidation struct {
+	valid     bool
+	expiresAt time.Time
+}
+
+// get returns the cached result for key, if there's one that hasn't expired.
+func (c *apiKeyValidationCache) get(key string, now time.Time) (valid bool, ok bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	item, ok := c.items[key]
+	if !ok || !now.Before(item.expiresAt) {
+		return false, false
+	}
+	return item.valid, true
+}
+
+func (c *apiKeyValidationCache) set(key string, valid bool, expiresAt time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.items == nil || len(c.items) >= maxCachedAPIKeys {
+		c.items = make(map[string]apiKeyValidation)
+	}
+	c.items[key] = apiKeyValidation{valid: valid, expiresAt: expiresAt}
+}
+
+func (c *apiKeyValidationCache) clear() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.items = nil
+}
+
+// isAPIKeyValid is Config.IsAPIKeyValid, with its results cached for
+// ValidationCacheTTL if the key is accepted, or ValidationNegativeCacheTTL
+// if it's rejected.
+func (r *Router) isAPIKeyValid(key string) bool {
+	positiveTTL := r.Config.GetAPIKeyValidationCacheTTL()
+	negativeTTL := r.Config.GetAPIKeyValidationNegativeCacheTTL()
+	if positiveTTL <= 0 && negativeTTL <= 0 {
+		return r.Config.IsAPIKeyValid(key)
+	}
+
+	now := time.Now()
+	if valid, ok := r.apiKeyCache.get(key, now); ok {
+		r.Metrics.Increment("api_key_validation_cache_hit")
+		return valid
+	}
+	r.Metrics.Increment("api_key_validation_cache_miss")
+
+	valid := r.Config.IsAPIKeyValid(key)
+	ttl := negativeTTL
+	if valid {
+		ttl = positiveTTL
+	}
+	if ttl > 0 {
+		r.apiKeyCache.set(key, valid, now.Add(ttl))
+	}
+	return valid
+}
+
+// clearAPIKeyCache discards the cached validation results when the config is
+// reloaded, since the keys that are accepted may have changed.
+func (r *Router) clearAPIKeyCache(cfgHash, rulesHash string) {
+	r.apiKeyCache.clear()
+}
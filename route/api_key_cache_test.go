@@ -0,0 +1,82 @@
+package route
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyValidationCache(t *testing.T) {
+	c := &apiKeyValidationCache{}
+	now := time.Now()
+
+	_, ok := c.get("key", now)
+	assert.False(t, ok)
+
+	c.set("key", true, now.Add(time.Minute))
+	valid, ok := c.get("key", now)
+	assert.True(t, ok)
+	assert.True(t, valid)
+
+	_, ok = c.get("key", now.Add(time.Minute))
+	assert.False(t, ok, "expired results aren't returned")
+
+	c.set("key", false, now.Add(time.Minute))
+	c.clear()
+	_, ok = c.get("key", now)
+	assert.False(t, ok)
+}
+
+func TestIsAPIKeyValidCached(t *testing.T) {
+	calls := make(map[string]int)
+	conf := &config.MockConfig{
+		IsAPIKeyValidFunc: func(key string) bool {
+			calls[key]++
+			return key == "good"
+		},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, conf)
+		assert.True(t, router.isAPIKeyValid("good"))
+		assert.True(t, router.isAPIKeyValid("good"))
+		assert.Equal(t, 2, calls["good"])
+		assert.Equal(t, 0, mockMetrics.CounterIncrements["api_key_validation_cache_miss"])
+	})
+
+	clear(calls)
+	conf.APIKeyValidationCacheTTL = time.Hour
+	conf.APIKeyValidationNegativeCacheTTL = time.Hour
+
+	t.Run("enabled", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, conf)
+		conf.RegisterReloadCallback(router.clearAPIKeyCache)
+		for i := 0; i < 3; i++ {
+			assert.True(t, router.isAPIKeyValid("good"))
+			assert.False(t, router.isAPIKeyValid("bad"))
+		}
+		assert.Equal(t, 1, calls["good"])
+		assert.Equal(t, 1, calls["bad"])
+		assert.Equal(t, 4, mockMetrics.CounterIncrements["api_key_validation_cache_hit"])
+		assert.Equal(t, 2, mockMetrics.CounterIncrements["api_key_validation_cache_miss"])
+
+		conf.ReloadConfig()
+		assert.True(t, router.isAPIKeyValid("good"))
+		assert.Equal(t, 2, calls["good"], "reloading the config clears the cache")
+	})
+
+	clear(calls)
+	conf.APIKeyValidationNegativeCacheTTL = 0
+
+	t.Run("positive only", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, conf)
+		for i := 0; i < 3; i++ {
+			assert.True(t, router.isAPIKeyValid("good"))
+			assert.False(t, router.isAPIKeyValid("bad"))
+		}
+		assert.Equal(t, 1, calls["good"])
+		assert.Equal(t, 3, calls["bad"])
+	})
+}
@@ -0,0 +1,71 @@
+package route
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// bodyChecksumHeader is the header that carries the hex SHA-256 of a request
+// body, for VerifyBodyChecksum.
+const bodyChecksumHeader = "X-Content-SHA256"
+
+// ErrBodyChecksumMismatch is returned when a request body doesn't match the
+// checksum in its X-Content-SHA256 header.
+var ErrBodyChecksumMismatch = errors.New("request body doesn't match its " + bodyChecksumHeader + " header")
+
+// readCheckedBody returns the decompressed body of an event or batch request.
+// If VerifyBodyChecksum is set and the request has an X-Content-SHA256 header,
+// the body is read in full and checked against it, either as it arrived or
+// after decompression, as BodyChecksumStage says; otherwise it's the same as
+// decompressRequestBody.
+func (r *Router) readCheckedBody(req *http.Request) (io.Reader, error) {
+	want := req.Header.Get(bodyChecksumHeader)
+	if want == "" || !r.Config.GetVerifyBodyChecksum() {
+		return r.decompressRequestBody(req)
+	}
+
+	h := sha256.New()
+	compressed := r.Config.GetBodyChecksumStage() == "compressed"
+	if compressed {
+		req.Body = hashingBody{Reader: io.TeeReader(req.Body, h), Closer: req.Body}
+	}
+	bodyReader, err := r.decompressRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		// a decompressor can stop before the end of the body, but whatever
+		// follows was sent too
+		if _, err := io.CopyN(io.Discard, req.Body, int64(r.Config.GetMaxRequestBodySize())); err != nil && err != io.EOF {
+			return nil, err
+		}
+	} else {
+		h.Write(body)
+	}
+	if !checksumMatches(h, want) {
+		r.Metrics.Increment("body_checksum_mismatch")
+		return nil, ErrBodyChecksumMismatch
+	}
+	return bytes.NewReader(body), nil
+}
+
+// checksumMatches reports whether the hex checksum want is the sum of h.
+func checksumMatches(h hash.Hash, want string) bool {
+	return strings.EqualFold(hex.EncodeToString(h.Sum(nil)), strings.TrimSpace(want))
+}
+
+// hashingBody is a request body that hashes what's read from it.
+type hashingBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,109 @@
+package route
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyBodyChecksum(t *testing.T) {
+	batch := []byte(`[{"data":{"trace.trace_id":"abc"}}]`)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(batch)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	sum := func(b []byte) string {
+		s := sha256.Sum256(b)
+		return hex.EncodeToString(s[:])
+	}
+	send := func(router *Router, body []byte, gzipped bool, checksum string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/1/batch/dataset", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if checksum != "" {
+			req.Header.Set(bodyChecksumHeader, checksum)
+		}
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.batch(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		stage    string
+		body     []byte
+		gzipped  bool
+		checksum string
+		wantCode int
+	}{
+		{"matching", "decompressed", batch, false, sum(batch), http.StatusOK},
+		{"matching upper case", "decompressed", batch, false, strings.ToUpper(sum(batch)), http.StatusOK},
+		{"matching decompressed", "decompressed", gzipped.Bytes(), true, sum(batch), http.StatusOK},
+		{"matching compressed", "compressed", gzipped.Bytes(), true, sum(gzipped.Bytes()), http.StatusOK},
+		{"uncompressed at the compressed stage", "compressed", batch, false, sum(batch), http.StatusOK},
+		{"mismatching", "decompressed", batch, false, sum([]byte("something else")), http.StatusUnprocessableEntity},
+		{"compressed checksum at the decompressed stage", "decompressed", gzipped.Bytes(), true, sum(gzipped.Bytes()), http.StatusUnprocessableEntity},
+		{"decompressed checksum at the compressed stage", "compressed", gzipped.Bytes(), true, sum(batch), http.StatusUnprocessableEntity},
+		{"malformed checksum", "decompressed", batch, false, "not-a-checksum", http.StatusUnprocessableEntity},
+		{"missing header", "decompressed", batch, false, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:  []string{"trace.trace_id"},
+				VerifyBodyChecksum: true,
+				BodyChecksumStage:  tt.stage,
+			})
+			w := send(router, tt.body, tt.gzipped, tt.checksum)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode == http.StatusUnprocessableEntity {
+				assert.Contains(t, w.Body.String(), bodyChecksumHeader)
+				assert.Equal(t, 1, mockMetrics.CounterIncrements["body_checksum_mismatch"])
+			} else {
+				assert.Zero(t, mockMetrics.CounterIncrements["body_checksum_mismatch"])
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+		})
+		w := send(router, batch, false, sum([]byte("something else")))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Zero(t, mockMetrics.CounterIncrements["body_checksum_mismatch"])
+	})
+
+	t.Run("single events", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:  []string{"trace.trace_id"},
+			VerifyBodyChecksum: true,
+		})
+		event := []byte(`{"trace.trace_id":"abc"}`)
+		req := httptest.NewRequest("POST", "/1/events/dataset", bytes.NewReader(event))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(bodyChecksumHeader, sum([]byte(`{"trace.trace_id":"def"}`)))
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.event(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
@@ -0,0 +1,65 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// checkContentType returns an error if StrictContentType is set and req's
+// Content-Type isn't one that the event endpoints can decode: JSON or msgpack,
+// or for batches, newline-delimited JSON too. Otherwise, anything that isn't
+// msgpack is decoded as JSON.
+func (r *Router) checkContentType(req *http.Request, batch bool) error {
+	if !r.Config.GetStrictContentType() {
+		return nil
+	}
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("unsupported content type %q", contentType)
+	}
+	switch {
+	case mediaType == "application/json", isMsgpackContentType(mediaType):
+		return nil
+	case batch && isNDJSONContentType(mediaType):
+		return nil
+	}
+	return fmt.Errorf("unsupported content type %q", contentType)
+}
+
+// responseIsMsgpack reports whether the response to an event request should be
+// msgpack rather than JSON: if its Accept header names msgpack before JSON,
+// or, if it names neither, if the request itself was msgpack. Quality values
+// in Accept are ignored.
+func responseIsMsgpack(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch {
+		case isMsgpackContentType(mediaType):
+			return true
+		case mediaType == "application/json":
+			return false
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return isMsgpackContentType(mediaType)
+}
+
+// marshalResponse encodes v as the response to req, in the format that
+// responseIsMsgpack chooses, and returns it along with its Content-Type.
+func marshalResponse(req *http.Request, v interface{}) ([]byte, string, error) {
+	if responseIsMsgpack(req) {
+		body, err := msgpack.Marshal(v)
+		return body, "application/msgpack", err
+	}
+	body, err := json.Marshal(v)
+	return body, "application/json", err
+}
@@ -0,0 +1,175 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestStrictContentType(t *testing.T) {
+	event := map[string]interface{}{"trace.trace_id": "abc"}
+	jsonEvent := []byte(`{"trace.trace_id":"abc"}`)
+	msgpackEvent, err := msgpack.Marshal(event)
+	require.NoError(t, err)
+	jsonBatch := []byte(`[{"data":{"trace.trace_id":"abc"}}]`)
+	msgpackBatch, err := msgpack.Marshal([]map[string]interface{}{{"data": event}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		event       []byte
+		batch       []byte
+		strictOK    bool
+	}{
+		{"json", "application/json", jsonEvent, jsonBatch, true},
+		{"json with charset", "application/json; charset=utf-8", jsonEvent, jsonBatch, true},
+		{"msgpack", "application/msgpack", msgpackEvent, msgpackBatch, true},
+		{"x-msgpack", "application/x-msgpack", msgpackEvent, msgpackBatch, true},
+		{"text", "text/plain", jsonEvent, jsonBatch, false},
+		{"xml", "application/xml", jsonEvent, jsonBatch, false},
+		{"missing", "", jsonEvent, jsonBatch, false},
+	}
+
+	send := func(router *Router, handler func(http.ResponseWriter, *http.Request), path string, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, strict := range []bool{false, true} {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			StrictContentType: strict,
+		})
+		for _, tt := range tests {
+			mode := "permissive"
+			if strict {
+				mode = "strict"
+			}
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				// in permissive mode, everything that isn't msgpack is
+				// decoded as JSON, as it always has been
+				accepted := !strict || tt.strictOK
+
+				w := send(router, router.event, "/1/events/dataset", tt.contentType, tt.event)
+				if accepted {
+					assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+				} else {
+					assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+					assert.Contains(t, w.Body.String(), "unsupported content type")
+				}
+
+				w = send(router, router.batch, "/1/batch/dataset", tt.contentType, tt.batch)
+				if accepted {
+					assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				} else {
+					assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+				}
+			})
+		}
+	}
+
+	t.Run("strict/ndjson", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			StrictContentType: true,
+		})
+		w := send(router, router.batch, "/1/batch/dataset", "application/x-ndjson", []byte(`{"data":{"trace.trace_id":"abc"}}`))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// there's no newline-delimited form of a single event
+		w = send(router, router.event, "/1/events/dataset", "application/x-ndjson", jsonEvent)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+}
+
+func TestResponseFormat(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		DeniedDatasets:    []string{"denied"},
+	})
+	msgpackBatch, err := msgpack.Marshal([]map[string]interface{}{
+		{"data": map[string]interface{}{"trace.trace_id": "abc"}},
+		{"data": map[string]interface{}{"trace.trace_id": "def"}},
+	})
+	require.NoError(t, err)
+
+	send := func(handler func(http.ResponseWriter, *http.Request), dataset string, contentType string, accept string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/1/batch/"+dataset, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": dataset})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("msgpack batch round-trips", func(t *testing.T) {
+		w := send(router.batch, "dataset", "application/msgpack", "", msgpackBatch)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+		var responses []BatchResponse
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &responses))
+		assert.Equal(t, []BatchResponse{{Status: http.StatusAccepted, SampleRate: 1}, {Status: http.StatusAccepted, SampleRate: 1}}, responses)
+	})
+
+	t.Run("msgpack batch failures round-trip", func(t *testing.T) {
+		w := send(router.batch, "denied", "application/x-msgpack", "", msgpackBatch)
+		require.Equal(t, http.StatusOK, w.Code)
+		var responses []BatchResponse
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &responses))
+		require.Len(t, responses, 2)
+		assert.Equal(t, http.StatusForbidden, responses[0].Status)
+		assert.Equal(t, BatchCodeDatasetDenied, responses[0].Code)
+		assert.Contains(t, responses[0].Error, "dataset not allowed")
+	})
+
+	t.Run("Accept wins over Content-Type", func(t *testing.T) {
+		w := send(router.batch, "dataset", "application/msgpack", "application/json", msgpackBatch)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var responses []BatchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+		assert.Len(t, responses, 2)
+
+		w = send(router.batch, "dataset", "application/json", "application/msgpack, application/json;q=0.5", []byte(`[{"data":{"trace.trace_id":"abc"}}]`))
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &responses))
+		assert.Len(t, responses, 1)
+	})
+
+	t.Run("JSON by default", func(t *testing.T) {
+		w := send(router.batch, "dataset", "application/json", "*/*", []byte(`[{"data":{"trace.trace_id":"abc"}}]`))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `[{"status":202,"sample_rate":1}]`, w.Body.String())
+	})
+
+	t.Run("msgpack event", func(t *testing.T) {
+		event, err := msgpack.Marshal(map[string]interface{}{"trace.trace_id": "abc"})
+		require.NoError(t, err)
+		w := send(router.event, "dataset", "application/msgpack", "", event)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+		var resp BatchResponse
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, BatchResponse{Status: http.StatusAccepted, SampleRate: 1}, resp)
+	})
+}
@@ -0,0 +1,81 @@
+package route
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// datasetKey identifies a dataset; datasets with the same name in different
+// environments are different datasets.
+type datasetKey struct {
+	environment string
+	dataset     string
+}
+
+// datasetLimit tracks the distinct datasets the router has accepted events
+// for, so that events for more than MaxDistinctDatasets can be rerouted or
+// refused.
+type datasetLimit struct {
+	mut  sync.RWMutex
+	seen map[datasetKey]struct{}
+	// warned is set once we've logged that datasets are over the limit, so
+	// that a flood of new datasets doesn't flood the log too
+	warned bool
+}
+
+func newDatasetLimit() *datasetLimit {
+	return &datasetLimit{seen: make(map[datasetKey]struct{})}
+}
+
+// checkDatasetLimit records ev's dataset as seen. If it's one too many, ev is
+// moved to CatchAllDataset, or, if there isn't one, an error wrapping
+// ErrDatasetNotAllowed is returned.
+func (r *Router) checkDatasetLimit(ev *types.Event) error {
+	max := r.Config.GetMaxDistinctDatasets()
+	if max <= 0 || r.datasetLimit == nil {
+		return nil
+	}
+	catchAll := r.Config.GetCatchAllDataset()
+	if catchAll != "" && ev.Dataset == catchAll {
+		return nil
+	}
+	l := r.datasetLimit
+	key := datasetKey{environment: ev.Environment, dataset: ev.Dataset}
+
+	l.mut.RLock()
+	_, ok := l.seen[key]
+	l.mut.RUnlock()
+	if ok {
+		return nil
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if _, ok := l.seen[key]; ok {
+		return nil
+	}
+	if len(l.seen) < max {
+		l.seen[key] = struct{}{}
+		r.Metrics.Gauge("distinct_datasets", len(l.seen))
+		return nil
+	}
+
+	if !l.warned {
+		l.warned = true
+		r.iopLogger.Warn().
+			WithString("dataset", ev.Dataset).
+			WithString("environment", ev.Environment).
+			WithField("max_distinct_datasets", max).
+			WithString("catch_all_dataset", catchAll).
+			Logf("receiving events for datasets beyond MaxDistinctDatasets; further ones are only counted")
+	}
+	if catchAll == "" {
+		r.Metrics.Increment("dataset_limit_rejected")
+		return fmt.Errorf("%w: %s is beyond MaxDistinctDatasets", ErrDatasetNotAllowed, ev.Dataset)
+	}
+	r.Metrics.Increment("dataset_limit_routed")
+	ev.Dataset = catchAll
+	return nil
+}
@@ -0,0 +1,89 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatasetLimit(t *testing.T) {
+	newRouter := func(catchAll string) (*Router, *metrics.MockMetrics, func(environment, dataset string) (string, error)) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:   []string{"trace.trace_id"},
+			MaxDistinctDatasets: 2,
+			CatchAllDataset:     catchAll,
+		})
+		router.datasetLimit = newDatasetLimit()
+		return router, mockMetrics, func(environment, dataset string) (string, error) {
+			err := router.processEvent(&types.Event{
+				Dataset:     dataset,
+				Environment: environment,
+				Data:        map[string]interface{}{"trace.trace_id": "abc"},
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			span := <-router.Collector.(*collect.MockCollector).Spans
+			return span.Dataset, nil
+		}
+	}
+
+	t.Run("rejects datasets beyond the limit", func(t *testing.T) {
+		_, mockMetrics, send := newRouter("")
+		_, err := send("prod", "a")
+		require.NoError(t, err)
+		_, err = send("prod", "b")
+		require.NoError(t, err)
+
+		_, err = send("prod", "c")
+		assert.ErrorIs(t, err, ErrDatasetNotAllowed)
+		// the same name in another environment is another dataset
+		_, err = send("staging", "a")
+		assert.ErrorIs(t, err, ErrDatasetNotAllowed)
+
+		dataset, err := send("prod", "a")
+		assert.NoError(t, err, "datasets already seen are still accepted")
+		assert.Equal(t, "a", dataset)
+
+		v, _ := mockMetrics.Get("distinct_datasets")
+		assert.Equal(t, float64(2), v)
+		v, _ = mockMetrics.Get("dataset_limit_rejected")
+		assert.Equal(t, float64(2), v)
+	})
+
+	t.Run("routes datasets beyond the limit to the catch-all", func(t *testing.T) {
+		_, mockMetrics, send := newRouter("overflow")
+		for _, dataset := range []string{"a", "b"} {
+			got, err := send("prod", dataset)
+			require.NoError(t, err)
+			assert.Equal(t, dataset, got)
+		}
+
+		got, err := send("prod", "c")
+		require.NoError(t, err)
+		assert.Equal(t, "overflow", got)
+		// the catch-all doesn't count toward the limit
+		got, err = send("prod", "overflow")
+		require.NoError(t, err)
+		assert.Equal(t, "overflow", got)
+
+		v, _ := mockMetrics.Get("distinct_datasets")
+		assert.Equal(t, float64(2), v)
+		v, _ = mockMetrics.Get("dataset_limit_routed")
+		assert.Equal(t, float64(1), v)
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		router, _, send := newRouter("")
+		router.Config.(*config.MockConfig).MaxDistinctDatasets = 0
+		for _, dataset := range []string{"a", "b", "c", "d"} {
+			_, err := send("prod", dataset)
+			require.NoError(t, err)
+		}
+	})
+}
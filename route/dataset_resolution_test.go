@@ -0,0 +1,122 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	resource "go.opentelemetry.io/proto/otlp/resource/v1"
+	trace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestDatasetResolutionAcrossProtocols checks that the same dataset ends up
+// with the same name, and so the same sampler, whichever way it's sent.
+func TestDatasetResolutionAcrossProtocols(t *testing.T) {
+	const envAPIKey = "my-api-key"
+	traceID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	otlpRequest := func(serviceName string) *collectortrace.ExportTraceServiceRequest {
+		rs := &trace.ResourceSpans{
+			ScopeSpans: []*trace.ScopeSpans{{
+				Spans: []*trace.Span{{Name: "span", TraceId: traceID, SpanId: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+			}},
+		}
+		if serviceName != "" {
+			rs.Resource = &resource.Resource{Attributes: []*common.KeyValue{{
+				Key:   "service.name",
+				Value: &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: serviceName}},
+			}}}
+		}
+		return &collectortrace.ExportTraceServiceRequest{ResourceSpans: []*trace.ResourceSpans{rs}}
+	}
+
+	senders := map[string]func(t *testing.T, r *Router, apiKey, dataset string){
+		"batch": func(t *testing.T, r *Router, apiKey, dataset string) {
+			req := httptest.NewRequest("POST", "/1/batch/"+dataset, strings.NewReader(`[{"data":{"trace.trace_id":"abc"}}]`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, apiKey)
+			req = mux.SetURLVars(req, map[string]string{"datasetName": dataset})
+			w := httptest.NewRecorder()
+			r.batch(w, req)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		},
+		"event": func(t *testing.T, r *Router, apiKey, dataset string) {
+			req := httptest.NewRequest("POST", "/1/events/"+dataset, strings.NewReader(`{"trace.trace_id":"abc"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, apiKey)
+			req = mux.SetURLVars(req, map[string]string{"datasetName": dataset})
+			w := httptest.NewRecorder()
+			r.event(w, req)
+			require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		},
+		"OTLP/HTTP": func(t *testing.T, r *Router, apiKey, dataset string) {
+			serviceName := ""
+			if !types.IsLegacyAPIKey(apiKey) {
+				serviceName = dataset
+			}
+			body, err := proto.Marshal(otlpRequest(serviceName))
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/v1/traces", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/protobuf")
+			req.Header.Set("x-honeycomb-team", apiKey)
+			if serviceName == "" {
+				req.Header.Set("x-honeycomb-dataset", dataset)
+			}
+			w := httptest.NewRecorder()
+			r.postOTLPTrace(w, req)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		},
+		"OTLP/gRPC": func(t *testing.T, r *Router, apiKey, dataset string) {
+			serviceName := ""
+			md := metadata.New(map[string]string{"x-honeycomb-team": apiKey})
+			if types.IsLegacyAPIKey(apiKey) {
+				md.Set("x-honeycomb-dataset", dataset)
+			} else {
+				serviceName = dataset
+			}
+			_, err := NewTraceServer(r).Export(metadata.NewIncomingContext(context.Background(), md), otlpRequest(serviceName))
+			require.NoError(t, err)
+		},
+	}
+
+	for _, tt := range []struct {
+		name         string
+		apiKey       string
+		wantSelector string
+	}{
+		{"classic", legacyAPIKey, "classic.myservice"},
+		{"environment", envAPIKey, "local"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for protocol, send := range senders {
+				router, _ := newBatchTestRouter(t, &config.MockConfig{
+					TraceIdFieldNames: []string{"trace.trace_id"},
+					DatasetPrefix:     "classic",
+					DatasetTransforms: []string{"lowercase", "trimprefix:prod-"},
+				})
+				router.environmentCache.addItem(envAPIKey, "local", time.Minute)
+
+				send(t, router, tt.apiKey, "Prod-MyService")
+				spans := router.Collector.(*collect.MockCollector).Spans
+				require.Len(t, spans, 1, protocol)
+				sp := <-spans
+				assert.Equal(t, "myservice", sp.Dataset, protocol)
+				assert.Equal(t, tt.wantSelector,
+					types.SamplerSelector(sp.APIKey, sp.Dataset, sp.Environment, router.Config.GetDatasetPrefix()), protocol)
+			}
+		})
+	}
+}
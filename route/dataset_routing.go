@@ -0,0 +1,43 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// datasetRoutingPlaceholder is replaced with the field's value in a
+// DatasetRoutingTemplate.
+const datasetRoutingPlaceholder = "{value}"
+
+// routedDataset returns the dataset that DatasetRoutingField says ev goes to,
+// or "" if it isn't configured or ev doesn't have the field. Values are used
+// by their text, like the ForceKeepConditions.
+func (r *Router) routedDataset(ev *types.Event) string {
+	field := r.Config.GetDatasetRoutingField()
+	if field == "" {
+		return ""
+	}
+	val, ok := lookupField(ev.Data, field)
+	if !ok || val == nil {
+		return ""
+	}
+	value, isString := val.(string)
+	if !isString {
+		value = fmt.Sprint(val)
+	}
+	if value == "" {
+		return ""
+	}
+	return datasetFromTemplate(r.Config.GetDatasetRoutingTemplate(), value)
+}
+
+// datasetFromTemplate puts value in place of the placeholder in template. An
+// empty template is just the value.
+func datasetFromTemplate(template string, value string) string {
+	if template == "" {
+		return value
+	}
+	return strings.ReplaceAll(template, datasetRoutingPlaceholder, value)
+}
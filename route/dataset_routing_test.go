@@ -0,0 +1,80 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutedDataset(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		template string
+		data     map[string]interface{}
+		want     string
+	}{
+		{"not configured", "", "", map[string]interface{}{"tenant": "acme"}, ""},
+		{"value", "tenant", "", map[string]interface{}{"tenant": "acme"}, "acme"},
+		{"template", "tenant", "tenant-{value}", map[string]interface{}{"tenant": "acme"}, "tenant-acme"},
+		{"number", "tenant", "tenant-{value}", map[string]interface{}{"tenant": int64(42)}, "tenant-42"},
+		{"nested field", "resource.tenant", "", map[string]interface{}{"resource": map[string]interface{}{"tenant": "acme"}}, "acme"},
+		{"field absent", "tenant", "tenant-{value}", map[string]interface{}{"service": "api"}, ""},
+		{"empty value", "tenant", "tenant-{value}", map[string]interface{}{"tenant": ""}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newBatchTestRouter(t, &config.MockConfig{
+				DatasetRoutingField:    tt.field,
+				DatasetRoutingTemplate: tt.template,
+			})
+			assert.Equal(t, tt.want, router.routedDataset(&types.Event{Data: tt.data}))
+		})
+	}
+}
+
+func TestProcessEventDatasetRouting(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:      []string{"trace.trace_id"},
+		DatasetRoutingField:    "tenant",
+		DatasetRoutingTemplate: "tenant-{value}",
+	})
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "trace1",
+		"tenant":         "acme",
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.Equal(t, "tenant-acme", span.RoutedDataset)
+	// the collector changes the dataset once the whole trace is known
+	assert.Equal(t, "dataset", span.Dataset)
+
+	t.Run("falls back to the request's dataset", func(t *testing.T) {
+		require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+			"trace.trace_id": "trace2",
+		}}, nil))
+		span := <-router.Collector.(*collect.MockCollector).Spans
+		assert.Empty(t, span.RoutedDataset)
+		assert.Equal(t, "dataset", span.Dataset)
+	})
+
+	t.Run("non-trace events", func(t *testing.T) {
+		require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+			"tenant": "acme",
+		}}, nil))
+		require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+			"service": "api",
+		}}, nil))
+		events := router.UpstreamTransmission.(*transmit.MockTransmission).Events
+		require.Len(t, events, 2)
+		assert.Equal(t, "tenant-acme", events[0].Dataset)
+		assert.Equal(t, "dataset", events[1].Dataset)
+	})
+
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_dataset_routed"])
+}
@@ -0,0 +1,29 @@
+package route
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"strconv"
+)
+
+// datasetShardSalt keeps the shard that a trace is assigned to independent of
+// the deterministic sampler's decision about it.
+const datasetShardSalt = "k2Ws9DqTfR4bLx7e"
+
+// datasetShard returns the physical dataset that traceID is sent to, and true,
+// if DatasetShards spreads dataset's traces across several datasets.
+func (r *Router) datasetShard(dataset string, traceID string) (string, bool) {
+	shards := r.Config.GetDatasetShards()[dataset]
+	if shards < 2 {
+		return dataset, false
+	}
+	return shardDatasetName(dataset, traceID, shards), true
+}
+
+// shardDatasetName picks one of shards datasets for traceID. It depends only
+// on its arguments, so every Refinery node picks the same one for a trace.
+func shardDatasetName(dataset string, traceID string, shards int) string {
+	sum := sha1.Sum([]byte(traceID + datasetShardSalt))
+	shard := binary.BigEndian.Uint32(sum[:4]) % uint32(shards)
+	return dataset + "-" + strconv.FormatUint(uint64(shard), 10)
+}
@@ -0,0 +1,58 @@
+package route
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardDatasetName(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 0; i < 10_000; i++ {
+		traceID := fmt.Sprintf("trace-%d", i)
+		shard := shardDatasetName("big", traceID, 4)
+		assert.Equal(t, shard, shardDatasetName("big", traceID, 4), "the shard must be stable")
+		counts[shard]++
+	}
+	require.Len(t, counts, 4)
+	for _, shard := range []string{"big-0", "big-1", "big-2", "big-3"} {
+		assert.InDelta(t, 2500, counts[shard], 250, shard)
+	}
+}
+
+func TestDatasetShards(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		DatasetShards:     map[string]int{"big": 8, "small": 1},
+	})
+
+	send := func(dataset string, body string) {
+		req := httptest.NewRequest("POST", "/1/batch/"+dataset, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": dataset})
+		router.batch(httptest.NewRecorder(), req)
+	}
+
+	send("big", `[{"data":{"trace.trace_id":"a"}},{"data":{"trace.trace_id":"a"}},{"data":{"trace.trace_id":"b"}}]`)
+	spans := router.Collector.(*collect.MockCollector).Spans
+	require.Len(t, spans, 3)
+	a1, a2, b := <-spans, <-spans, <-spans
+	assert.Equal(t, shardDatasetName("big", "a", 8), a1.Dataset)
+	assert.Equal(t, a1.Dataset, a2.Dataset, "spans of a trace go to the same shard")
+	assert.Equal(t, shardDatasetName("big", "b", 8), b.Dataset)
+
+	send("small", `[{"data":{"trace.trace_id":"a"}}]`)
+	send("other", `[{"data":{"trace.trace_id":"a"}}]`)
+	require.Len(t, spans, 2)
+	assert.Equal(t, "small", (<-spans).Dataset)
+	assert.Equal(t, "other", (<-spans).Dataset)
+}
@@ -0,0 +1,54 @@
+package route
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/types"
+)
+
+// debugLogSalt keeps the requests whose debug logs are written independent of
+// the traces the deterministic sampler keeps.
+const debugLogSalt = "Vq8mZ3cLr1Tn6HsX"
+
+var skippedDebugLog logger.Entry = &logger.NullLoggerEntry{}
+
+// eventDebugLog returns the entry that processEvent writes ev's debug logs
+// to. If DebugSampleRate is more than 1, most events get an entry that
+// discards everything, so that debug logging can be left on under load.
+func (r *Router) eventDebugLog(ev *types.Event, reqID interface{}) logger.Entry {
+	if !r.debugLogSampled(r.debugLogKey(ev, reqID)) {
+		return skippedDebugLog
+	}
+	return r.iopLogger.Debug().WithField("request_id", reqID)
+}
+
+// debugLogKey returns what decides whether ev's debug logs are written: the
+// request ID, so that a request's logs are all written or all skipped, or the
+// trace ID for requests without one, such as those over gRPC.
+func (r *Router) debugLogKey(ev *types.Event, reqID interface{}) string {
+	if id, ok := reqID.(string); ok && id != "" {
+		return id
+	}
+	// not traceIDFieldName, which would count trace ID conflicts twice
+	for _, field := range r.idFields().trace {
+		if value, ok := lookupField(ev.Data, field); ok {
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// debugLogSampled reports whether the debug logs identified by key are
+// written. It depends only on key and DebugSampleRate, so every line with
+// the same key gets the same answer.
+func (r *Router) debugLogSampled(key string) bool {
+	rate := r.Config.GetDebugLogSampleRate()
+	if rate <= 1 {
+		return true
+	}
+	sum := sha1.Sum([]byte(key + debugLogSalt))
+	return binary.BigEndian.Uint32(sum[:4])%uint32(rate) == 0
+}
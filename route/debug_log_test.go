@@ -0,0 +1,82 @@
+package route
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugLogSampling(t *testing.T) {
+	newRouter := func(rate int) (*Router, *logger.MockLogger) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:  []string{"trace.trace_id"},
+			DebugLogSampleRate: rate,
+		})
+		mockLogger := &logger.MockLogger{}
+		router.iopLogger.Logger = mockLogger
+		return router, mockLogger
+	}
+	// probes are dropped with one debug line each
+	probe := func(traceID string) *types.Event {
+		return &types.Event{
+			Dataset: "dataset",
+			Data:    map[string]interface{}{"meta.refinery.probe": true, "trace.trace_id": traceID},
+		}
+	}
+
+	t.Run("every request by default", func(t *testing.T) {
+		router, mockLogger := newRouter(0)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, router.processEvent(probe("abc"), fmt.Sprintf("req%d", i)))
+		}
+		assert.Len(t, mockLogger.Events, 10)
+	})
+
+	t.Run("all or none of a request's lines", func(t *testing.T) {
+		router, mockLogger := newRouter(4)
+		const requests, eventsPerRequest = 200, 3
+		for i := 0; i < requests; i++ {
+			reqID := fmt.Sprintf("req%d", i)
+			for j := 0; j < eventsPerRequest; j++ {
+				// each event has its own trace, so only the request ID can
+				// keep the decision consistent
+				require.NoError(t, router.processEvent(probe(fmt.Sprintf("trace%d-%d", i, j)), reqID))
+			}
+		}
+
+		lines := make(map[interface{}]int)
+		for _, ev := range mockLogger.Events {
+			lines[ev.Fields["request_id"]]++
+		}
+		for reqID, n := range lines {
+			assert.Equal(t, eventsPerRequest, n, "request %v", reqID)
+			assert.True(t, router.debugLogSampled(reqID.(string)), "request %v", reqID)
+		}
+		// about a quarter of the requests are logged
+		assert.Greater(t, len(lines), requests/8)
+		assert.Less(t, len(lines), requests/2)
+	})
+
+	t.Run("trace ID without a request ID", func(t *testing.T) {
+		router, mockLogger := newRouter(4)
+		var sampled, skipped string
+		for i := 0; sampled == "" || skipped == ""; i++ {
+			traceID := fmt.Sprintf("trace%d", i)
+			if router.debugLogSampled(traceID) {
+				sampled = traceID
+			} else {
+				skipped = traceID
+			}
+		}
+		for i := 0; i < 3; i++ {
+			require.NoError(t, router.processEvent(probe(sampled), nil))
+			require.NoError(t, router.processEvent(probe(skipped), nil))
+		}
+		assert.Len(t, mockLogger.Events, 3)
+	})
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/honeycombio/refinery/collect/cache"
+)
+
+// maxDecisionRecordSize bounds the size of each record accepted by the import
+// endpoint, so that the whole request is bounded by KeptSize records.
+const maxDecisionRecordSize = 1024
+
+// exportDecisions streams the kept decisions in the decision cache as
+// newline-delimited JSON, oldest first, so that they can be imported into
+// another node with importDecisions.
+func (r *Router) exportDecisions(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	count := 0
+	err := r.DecisionCache.ExportKept(func(d cache.KeptDecision) error {
+		count++
+		return enc.Encode(d)
+	})
+	if err != nil {
+		// the response has already started, so all we can do is stop
+		r.iopLogger.Error().WithField("exported", count).Logf("failed to export decisions: %s", err)
+		return
+	}
+	r.iopLogger.Debug().WithField("exported", count).Logf("exported kept decisions")
+}
+
+// importDecisions reads newline-delimited JSON records in the format written
+// by exportDecisions, and adds them to the decision cache. Decisions this node
+// has already made are left alone, and at most KeptSize records are read. The
+// body may be compressed, like the body of an event or batch.
+func (r *Router) importDecisions(w http.ResponseWriter, req *http.Request) {
+	limit := int(r.Config.GetSampleCacheConfig().KeptSize)
+	req.Body = http.MaxBytesReader(w, req.Body, int64(limit)*maxDecisionRecordSize)
+	body, err := r.decompressRequestBody(req)
+	if err != nil {
+		r.handlerReturnWithError(w, bodyReadError(err), err)
+		return
+	}
+	dec := json.NewDecoder(body)
+
+	imported, skipped := 0, 0
+	for imported+skipped < limit {
+		var d cache.KeptDecision
+		if err := dec.Decode(&d); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			r.handlerReturnWithError(w, ErrJSONFailed, err)
+			return
+		}
+		if r.DecisionCache.ImportKept(d) {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+	// anything past the limit wouldn't fit in the cache anyway
+	truncated := dec.More()
+
+	r.iopLogger.Info().
+		WithField("imported", imported).
+		WithField("skipped", skipped).
+		WithField("truncated", truncated).
+		Logf("imported kept decisions")
+	r.marshalToFormat(w, map[string]interface{}{
+		"source":    "refinery",
+		"imported":  imported,
+		"skipped":   skipped,
+		"truncated": truncated,
+	}, "json")
+}
@@ -0,0 +1,127 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/collect/cache"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type decisionTestTrace struct {
+	id     string
+	reason uint
+}
+
+func (t *decisionTestTrace) ID() string              { return t.id }
+func (t *decisionTestTrace) SampleRate() uint        { return 10 }
+func (t *decisionTestTrace) DescendantCount() uint32 { return 4 }
+func (t *decisionTestTrace) SpanEventCount() uint32  { return 0 }
+func (t *decisionTestTrace) SpanLinkCount() uint32   { return 0 }
+func (t *decisionTestTrace) SpanCount() uint32       { return 4 }
+func (t *decisionTestTrace) SetSentReason(r uint)    { t.reason = r }
+func (t *decisionTestTrace) SentReason() uint        { return t.reason }
+
+func TestExportImportDecisions(t *testing.T) {
+	newRouter := func(keptSize uint) *Router {
+		conf := &config.MockConfig{
+			SampleCache: config.SampleCacheConfig{
+				KeptSize:          keptSize,
+				DroppedSize:       1000,
+				SizeCheckInterval: config.Duration(time.Second),
+			},
+		}
+		router, _ := newBatchTestRouter(t, conf)
+		decisions := &cache.CuckooSentCache{Cfg: conf, Met: &metrics.NullMetrics{}}
+		require.NoError(t, decisions.Start())
+		t.Cleanup(func() { decisions.Stop() })
+		router.DecisionCache = decisions
+		return router
+	}
+
+	old := newRouter(100)
+	for i := 0; i < 3; i++ {
+		old.DecisionCache.Record(&decisionTestTrace{id: fmt.Sprintf("trace%d", i)}, true, "rules/keep")
+	}
+
+	rr := httptest.NewRecorder()
+	old.exportDecisions(rr, httptest.NewRequest("GET", "/query/decisions/export", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.JSONEq(t, `{"trace_id":"trace0","rate":10,"descendant_count":4,"span_event_count":0,"span_link_count":0,"span_count":4,"reason":"rules/keep"}`, lines[0])
+
+	t.Run("import", func(t *testing.T) {
+		restarted := newRouter(100)
+		rr := httptest.NewRecorder()
+		restarted.importDecisions(rr, httptest.NewRequest("POST", "/query/decisions/import", strings.NewReader(strings.Join(lines, "\n"))))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, float64(3), result["imported"])
+		assert.Equal(t, false, result["truncated"])
+
+		record, reason, found := restarted.DecisionCache.Test("trace2")
+		require.True(t, found)
+		assert.True(t, record.Kept())
+		assert.Equal(t, "rules/keep", reason)
+	})
+
+	t.Run("zstd compressed", func(t *testing.T) {
+		restarted := newRouter(100)
+		enc, err := zstd.NewWriter(nil)
+		require.NoError(t, err)
+		compressed := enc.EncodeAll([]byte(strings.Join(lines, "\n")), nil)
+		enc.Close()
+
+		req := httptest.NewRequest("POST", "/query/decisions/import", bytes.NewReader(compressed))
+		req.Header.Set("Content-Encoding", "zstd")
+		rr := httptest.NewRecorder()
+		restarted.importDecisions(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, float64(3), result["imported"])
+	})
+
+	t.Run("bad compression", func(t *testing.T) {
+		restarted := newRouter(100)
+		req := httptest.NewRequest("POST", "/query/decisions/import", strings.NewReader(strings.Join(lines, "\n")))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		restarted.importDecisions(rr, req)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("bounded by KeptSize", func(t *testing.T) {
+		small := newRouter(2)
+		rr := httptest.NewRecorder()
+		small.importDecisions(rr, httptest.NewRequest("POST", "/query/decisions/import", strings.NewReader(strings.Join(lines, "\n"))))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, float64(2), result["imported"])
+		assert.Equal(t, true, result["truncated"])
+	})
+
+	t.Run("bad JSON", func(t *testing.T) {
+		restarted := newRouter(100)
+		rr := httptest.NewRecorder()
+		restarted.importDecisions(rr, httptest.NewRequest("POST", "/query/decisions/import", strings.NewReader(`{"trace_id":`)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,205 @@
+package route
+
+import (
+	"net/http"
+)
+
+// getEffectiveConfig reports the values Refinery is actually running with,
+// after defaults, environment variables, and command line flags have been
+// applied. It's grouped like the config file. Secrets are redacted, so the
+// output is safe to share; API keys keep their last few characters so that
+// it's still possible to tell which key is in use.
+func (r *Router) getEffectiveConfig(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	r.marshalToFormat(w, r.effectiveConfig(), format)
+}
+
+func (r *Router) effectiveConfig() map[string]interface{} {
+	c := r.Config
+
+	consul := c.GetConsulPeerManagementConfig()
+	consul.Token = redactSecret(consul.Token)
+	logger := c.GetHoneycombLoggerConfig()
+	logger.APIKey = redactKey(logger.APIKey)
+	legacyMetrics := c.GetLegacyMetricsConfig()
+	legacyMetrics.APIKey = redactKey(legacyMetrics.APIKey)
+	otelMetrics := c.GetOTelMetricsConfig()
+	otelMetrics.APIKey = redactKey(otelMetrics.APIKey)
+	otelTracing := c.GetOTelTracingConfig()
+	otelTracing.APIKey = redactKey(otelTracing.APIKey)
+	mirror := c.GetMirrorConfig()
+	mirror.APIKey = redactKey(mirror.APIKey)
+	shadow := c.GetShadowConfig()
+	shadow.APIKey = redactKey(shadow.APIKey)
+
+	sampler, samplerName, err := c.GetSamplerConfigForDestName("__default__")
+	defaultSampler := map[string]interface{}{"Type": samplerName, "Config": sampler}
+	if err != nil {
+		defaultSampler["Error"] = err.Error()
+	}
+
+	return map[string]interface{}{
+		"Network": map[string]interface{}{
+			"ListenAddr":                      c.GetListenAddr(),
+			"UnixSocketPath":                  c.GetUnixSocketPath(),
+			"PeerListenAddr":                  c.GetPeerListenAddr(),
+			"HoneycombAPI":                    c.GetHoneycombAPI(),
+			"HTTPIdleTimeout":                 c.GetHTTPIdleTimeout().String(),
+			"SlowRequestThreshold":            c.GetSlowRequestThreshold().String(),
+			"UpstreamMaxIdleConns":            c.GetUpstreamMaxIdleConns(),
+			"UpstreamMaxIdleConnsPerHost":     c.GetUpstreamMaxIdleConnsPerHost(),
+			"UpstreamIdleConnTimeout":         c.GetUpstreamIdleConnTimeout().String(),
+			"UpstreamMaxConnLifetime":         c.GetUpstreamMaxConnLifetime().String(),
+			"UpstreamHealthCheckEnabled":      c.GetUpstreamHealthCheckEnabled(),
+			"UpstreamHealthCheckInterval":     c.GetUpstreamHealthCheckInterval().String(),
+			"UpstreamCircuitBreakerThreshold": c.GetUpstreamCircuitBreakerThreshold(),
+			"UpstreamCircuitBreakerCooldown":  c.GetUpstreamCircuitBreakerCooldown().String(),
+			"CompressPeerCommunication":       c.GetCompressPeerCommunication(),
+			"CompressUpstreamCommunication":   c.GetCompressUpstreamCommunication(),
+		},
+		"AccessKeys": map[string]interface{}{
+			"APIKeyQueryParam":        c.GetAPIKeyQueryParam(),
+			"AdditionalAPIKeyHeaders": c.GetAdditionalAPIKeyHeaders(),
+			"RejectMissingAPIKey":     c.GetRejectMissingAPIKey(),
+			"QueryAuthToken":          redactSecret(c.GetQueryAuthToken()),
+			// the tokens are secrets, so only say how many there are
+			"QueryAuthTokens":            len(c.GetQueryAuthTokens()),
+			"ValidationCacheTTL":         c.GetAPIKeyValidationCacheTTL().String(),
+			"ValidationNegativeCacheTTL": c.GetAPIKeyValidationNegativeCacheTTL().String(),
+		},
+		"GRPCServerParameters": map[string]interface{}{
+			"Enabled":              c.GetGRPCEnabled(),
+			"ListenAddr":           c.GetGRPCListenAddr(),
+			"MaxConcurrentExports": c.GetGRPCMaxConcurrentExports(),
+			"ReflectionEnabled":    c.GetGRPCReflectionEnabled(),
+		},
+		"PeerManagement": map[string]interface{}{
+			"Type":                        c.GetPeerManagementType(),
+			"Peers":                       c.GetPeers(),
+			"MaxPeers":                    c.GetMaxPeers(),
+			"MaxPeerListShrinkPercent":    c.GetMaxPeerListShrinkPercent(),
+			"PeerListShrinkConfirmations": c.GetPeerListShrinkConfirmations(),
+			"IdentifierInterfaceName":     c.GetIdentifierInterfaceName(),
+			"PeerTimeout":                 c.GetPeerTimeout().String(),
+			"Consul":                      consul,
+		},
+		"RedisPeerManagement": map[string]interface{}{
+			"Host":           c.GetRedisHost(),
+			"Username":       c.GetRedisUsername(),
+			"Password":       redactSecret(c.GetRedisPassword()),
+			"AuthCode":       redactSecret(c.GetRedisAuthCode()),
+			"Prefix":         c.GetRedisPrefix(),
+			"Database":       c.GetRedisDatabase(),
+			"UseTLS":         c.GetUseTLS(),
+			"UseTLSInsecure": c.GetUseTLSInsecure(),
+		},
+		"Traces": map[string]interface{}{
+			"SendDelay":                   c.GetSendDelay().String(),
+			"BatchTimeout":                c.GetBatchTimeout().String(),
+			"TraceTimeout":                c.GetTraceTimeout().String(),
+			"MaxBatchSize":                c.GetMaxBatchSize(),
+			"SendTicker":                  c.GetSendTickerValue().String(),
+			"MaxEffectiveSampleRate":      c.GetMaxEffectiveSampleRate(),
+			"MaxTraceAge":                 c.GetMaxTraceAge().String(),
+			"MaxTraceAgeAction":           c.GetMaxTraceAgeAction(),
+			"UpstreamSendConcurrency":     c.GetUpstreamSendConcurrency(),
+			"MaxSpansPerTrace":            c.GetMaxSpansPerTrace(),
+			"IntraTraceSamplingThreshold": c.GetIntraTraceSamplingThreshold(),
+			"IntraTraceSampleRate":        c.GetIntraTraceSampleRate(),
+			"MaxIncomingQueueWait":        c.GetMaxIncomingQueueWait().String(),
+			"AddSpanCountToRoot":          c.GetAddSpanCountToRoot(),
+			"AddTraceDurationToRoot":      c.GetAddTraceDurationToRoot(),
+			"AddServiceCountToRoot":       c.GetAddServiceCountToRoot(),
+			"AddRuleReasonToTrace":        c.GetAddRuleReasonToTrace(),
+			"AddHostMetadataToTrace":      c.GetAddHostMetadataToTrace(),
+			"AddIngestLagField":           c.GetAddIngestLagField(),
+			"AllowNegativeIngestLag":      c.GetAllowNegativeIngestLag(),
+			"DefaultSampler":              defaultSampler,
+			"DroppedSampleRetention":      c.GetDroppedSampleRetention(),
+			"DroppedSampleRate":           c.GetDroppedSampleRate(),
+			"DebugTraceSinkEnabled":       c.GetDebugTraceSinkEnabled(),
+			"DryRun":                      c.GetIsDryRun(),
+			"AdditionalErrorFields":       c.GetAdditionalErrorFields(),
+			"PreferredTraceIdField":       c.GetPreferredTraceIdFieldName(),
+			"TraceIdFieldNames":           c.GetTraceIdFieldNames(),
+			"ParentIdFieldNames":          c.GetParentIdFieldNames(),
+			"EnvironmentCacheTTL":         c.GetEnvironmentCacheTTL().String(),
+			"MaxDistinctEnvironments":     c.GetMaxDistinctEnvironments(),
+			"RejectExcessEnvironments":    c.GetRejectExcessEnvironments(),
+			"MaxDistinctDatasets":         c.GetMaxDistinctDatasets(),
+			"CatchAllDataset":             c.GetCatchAllDataset(),
+		},
+		"Collection": c.GetCollectionConfig(),
+		"BufferSizes": map[string]interface{}{
+			"UpstreamBufferSize":   c.GetUpstreamBufferSize(),
+			"PeerBufferSize":       c.GetPeerBufferSize(),
+			"UpstreamSpillPath":    c.GetUpstreamSpillPath(),
+			"UpstreamSpillMaxSize": c.GetUpstreamSpillMaxSize(),
+		},
+		"SampleCache":  c.GetSampleCacheConfig(),
+		"StressRelief": c.GetStressReliefConfig(),
+		"Logger": map[string]interface{}{
+			"Type":            c.GetLoggerType(),
+			"Level":           c.GetLoggerLevel().String(),
+			"DebugSampleRate": c.GetDebugLogSampleRate(),
+			"Honeycomb":       logger,
+		},
+		"LegacyMetrics": legacyMetrics,
+		"OTelMetrics":   otelMetrics,
+		"OTelTracing":   otelTracing,
+		"Mirror":        mirror,
+		"Shadow":        shadow,
+		"Specialized": map[string]interface{}{
+			"MaxSpanAttributes":               c.GetMaxSpanAttributes(),
+			"MaxSpanBytes":                    c.GetMaxSpanBytes(),
+			"MaxFieldValueLength":             c.GetMaxFieldValueLength(),
+			"MaxFieldValueLengths":            c.GetMaxFieldValueLengths(),
+			"MaxOTLPEventsPerRequest":         c.GetMaxOTLPEventsPerRequest(),
+			"MaxConcurrentDecompressions":     c.GetMaxConcurrentDecompressions(),
+			"OversizedSpanAction":             c.GetOversizedSpanAction(),
+			"EmptyTraceIDAction":              c.GetEmptyTraceIDAction(),
+			"InvalidOTLPIDAction":             c.GetInvalidOTLPIDAction(),
+			"NonTraceEventHandling":           c.GetNonTraceEventHandling(),
+			"NonTraceEventDataset":            c.GetNonTraceEventDataset(),
+			"OnEnvironmentLookupFailure":      c.GetOnEnvironmentLookupFailure(),
+			"EnvironmentLookupFailureDataset": c.GetEnvironmentLookupFailureDataset(),
+			"MaxEventTimeSkewFuture":          c.GetMaxEventTimeSkewFuture().String(),
+			"MaxEventTimeSkewPast":            c.GetMaxEventTimeSkewPast().String(),
+			"EventTimeSkewAction":             c.GetEventTimeSkewAction(),
+			"EventTimeSources":                c.GetEventTimeSources(),
+			"DegradedLoadThreshold":           c.GetDegradedLoadThreshold(),
+			"RedactedFields":                  c.GetRedactedFields(),
+			"MaxLoggedBodyBytes":              c.GetMaxLoggedBodyBytes(),
+			"RedactLoggedBodies":              c.GetRedactLoggedBodies(),
+			"DatasetPrefix":                   c.GetDatasetPrefix(),
+			"DatasetShards":                   c.GetDatasetShards(),
+			"DatasetRoutingField":             c.GetDatasetRoutingField(),
+			"DatasetRoutingTemplate":          c.GetDatasetRoutingTemplate(),
+			"FieldTypeCoercions":              c.GetFieldTypeCoercions(),
+			"StrictContentType":               c.GetStrictContentType(),
+			"VerifyBodyChecksum":              c.GetVerifyBodyChecksum(),
+			"BodyChecksumStage":               c.GetBodyChecksumStage(),
+			"KeepErrorTraces":                 c.GetKeepErrorTraces(),
+		},
+	}
+}
+
+// redactSecret hides a configured secret entirely; an unset one is left
+// empty so that it's clear it isn't configured.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "****"
+}
+
+// redactKey is like redactSecret, but keeps the end of API keys.
+func redactKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return redactAPIKey(key)
+}
@@ -0,0 +1,49 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEffectiveConfig(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		GetTraceTimeoutVal:          30 * time.Second,
+		GetMaxBatchSizeVal:          500,
+		GetRedisPasswordVal:         "hunter2",
+		QueryAuthToken:              "query-token",
+		GetSamplerTypeName:          "DeterministicSampler",
+		GetSamplerTypeVal:           &config.DeterministicSamplerConfig{SampleRate: 10},
+		GetHoneycombLoggerConfigVal: config.HoneycombLoggerConfig{APIKey: "abcdefgh12345678"},
+	})
+
+	w := httptest.NewRecorder()
+	router.getEffectiveConfig(w, httptest.NewRequest("GET", "/query/config", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.NotContains(t, body, "hunter2")
+	assert.NotContains(t, body, "query-token")
+	assert.NotContains(t, body, "abcdefgh")
+
+	var got map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "30s", got["Traces"]["TraceTimeout"])
+	assert.Equal(t, float64(500), got["Traces"]["MaxBatchSize"])
+	assert.Equal(t, "DeterministicSampler", got["Traces"]["DefaultSampler"].(map[string]interface{})["Type"])
+	assert.Equal(t, "****", got["RedisPeerManagement"]["Password"])
+	assert.Equal(t, "", got["RedisPeerManagement"]["AuthCode"], "unset secrets stay empty")
+	assert.Equal(t, "****", got["AccessKeys"]["QueryAuthToken"])
+	assert.Equal(t, "****5678", got["Logger"]["Honeycomb"].(map[string]interface{})["APIKey"])
+
+	w = httptest.NewRecorder()
+	router.getEffectiveConfig(w, httptest.NewRequest("GET", "/query/config?format=yaml", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "hunter2")
+}
@@ -0,0 +1,69 @@
+package route
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrEnvironmentLimitExceeded is returned for data sent to an environment
+// beyond MaxDistinctEnvironments when RejectExcessEnvironments is set.
+var ErrEnvironmentLimitExceeded = errors.New("too many distinct environments")
+
+// environmentLimit tracks the distinct environment names the router has
+// served, so that serving more than MaxDistinctEnvironments can be reported
+// and, optionally, refused.
+type environmentLimit struct {
+	mut  sync.RWMutex
+	seen map[string]struct{}
+	// warnedRejecting is set once we've logged that environments are being
+	// rejected, so that rejections don't flood the log
+	warnedRejecting bool
+}
+
+func newEnvironmentLimit() *environmentLimit {
+	return &environmentLimit{seen: make(map[string]struct{})}
+}
+
+// checkEnvironmentLimit records environment as served, and returns
+// ErrEnvironmentLimitExceeded if it's one too many and should be rejected.
+func (r *Router) checkEnvironmentLimit(environment string) error {
+	max := r.Config.GetMaxDistinctEnvironments()
+	if environment == "" || max <= 0 || r.environmentLimit == nil {
+		return nil
+	}
+	l := r.environmentLimit
+
+	l.mut.RLock()
+	_, ok := l.seen[environment]
+	l.mut.RUnlock()
+	if ok {
+		return nil
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if _, ok := l.seen[environment]; ok {
+		return nil
+	}
+	if len(l.seen) >= max {
+		if r.Config.GetRejectExcessEnvironments() {
+			r.Metrics.Increment("environment_limit_rejected")
+			if !l.warnedRejecting {
+				l.warnedRejecting = true
+				r.iopLogger.Warn().
+					WithString("environment", environment).
+					WithField("max_distinct_environments", max).
+					Logf("rejecting data for environments beyond MaxDistinctEnvironments; further rejections are only counted")
+			}
+			return ErrEnvironmentLimitExceeded
+		}
+		r.Metrics.Increment("environment_limit_exceeded")
+		r.iopLogger.Warn().
+			WithString("environment", environment).
+			WithField("max_distinct_environments", max).
+			Logf("serving more distinct environments than MaxDistinctEnvironments")
+	}
+	l.seen[environment] = struct{}{}
+	r.Metrics.Gauge("distinct_environments", len(l.seen))
+	return nil
+}
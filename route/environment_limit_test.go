@@ -0,0 +1,73 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestEnvironmentLimit(t *testing.T) {
+	newRouter := func(reject bool) (*Router, *metrics.MockMetrics, func(string) error) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			MaxDistinctEnvironments:  2,
+			RejectExcessEnvironments: reject,
+		})
+		router.environmentLimit = newEnvironmentLimit()
+		router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+			return key + "-env", nil
+		})
+		return router, mockMetrics, func(key string) error {
+			_, err := router.getEnvironmentNameWithOverride(key, "")
+			return err
+		}
+	}
+
+	t.Run("reports environments beyond the limit", func(t *testing.T) {
+		_, mockMetrics, lookup := newRouter(false)
+		require.NoError(t, lookup("a"))
+		require.NoError(t, lookup("b"))
+		require.NoError(t, lookup("c"))
+		require.NoError(t, lookup("c"))
+		// classic keys have no environment, so they aren't counted
+		require.NoError(t, lookup(legacyAPIKey))
+
+		v, _ := mockMetrics.Get("distinct_environments")
+		assert.Equal(t, float64(3), v)
+		v, _ = mockMetrics.Get("environment_limit_exceeded")
+		assert.Equal(t, float64(1), v, "each excess environment is counted once")
+	})
+
+	t.Run("rejects environments beyond the limit", func(t *testing.T) {
+		router, mockMetrics, lookup := newRouter(true)
+		require.NoError(t, lookup("a"))
+		require.NoError(t, lookup("b"))
+		assert.ErrorIs(t, lookup("c"), ErrEnvironmentLimitExceeded)
+		assert.ErrorIs(t, lookup("c"), ErrEnvironmentLimitExceeded)
+		assert.NoError(t, lookup("a"), "environments already seen are still accepted")
+
+		v, _ := mockMetrics.Get("environment_limit_rejected")
+		assert.Equal(t, float64(2), v)
+
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"foo":"bar"}}]`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, "d")
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.batch(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		otlpErr := newOTLPError(ErrEnvironmentLimitExceeded)
+		assert.Equal(t, http.StatusForbidden, otlpErr.HTTPStatusCode)
+		assert.Equal(t, codes.PermissionDenied, otlpErr.GRPCStatusCode)
+	})
+}
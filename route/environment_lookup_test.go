@@ -0,0 +1,97 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	huskyotlp "github.com/honeycombio/husky/otlp"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestEnvironmentLookupFailure(t *testing.T) {
+	const envAPIKey = "abcdefghijklmnopqrstuv"
+
+	newRouter := func(conf *config.MockConfig) (*Router, *metrics.MockMetrics) {
+		conf.TraceIdFieldNames = []string{"trace.trace_id"}
+		router, mockMetrics := newBatchTestRouter(t, conf)
+		router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+			return "", errors.New("connection refused")
+		})
+		return router, mockMetrics
+	}
+	sendBatch := func(router *Router, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"trace.trace_id":"abc"}}]`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, apiKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.batch(w, req)
+		return w
+	}
+	otlpBatches := func() []huskyotlp.Batch {
+		return []huskyotlp.Batch{{
+			Dataset: "dataset",
+			Events: []huskyotlp.Event{
+				{Timestamp: time.Now(), Attributes: map[string]interface{}{"trace.trace_id": "abc"}},
+			},
+		}}
+	}
+
+	t.Run("legacy keys aren't looked up", func(t *testing.T) {
+		router, mockMetrics := newRouter(&config.MockConfig{})
+		w := sendBatch(router, legacyAPIKey)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		span := <-router.Collector.(*collect.MockCollector).Spans
+		assert.Equal(t, "dataset", span.Dataset)
+		assert.Equal(t, "", span.Environment)
+		assert.Zero(t, mockMetrics.CounterIncrements["incoming_router_environment_lookup_failed"])
+
+		require.NoError(t, router.processOTLPRequest(context.Background(), otlpBatches(), legacyAPIKey, ""))
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 1)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		router, mockMetrics := newRouter(&config.MockConfig{})
+		w := sendBatch(router, envAPIKey)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		err := router.processOTLPRequest(context.Background(), otlpBatches(), envAPIKey, "")
+		assert.ErrorIs(t, err, ErrEnvironmentLookupFailed, "the batch isn't silently dropped")
+		assert.Empty(t, router.Collector.(*collect.MockCollector).Spans)
+		assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_environment_lookup_failed"])
+
+		otlpErr := newOTLPError(err)
+		assert.Equal(t, http.StatusServiceUnavailable, otlpErr.HTTPStatusCode)
+		assert.Equal(t, codes.Unavailable, otlpErr.GRPCStatusCode)
+	})
+
+	t.Run("route", func(t *testing.T) {
+		router, mockMetrics := newRouter(&config.MockConfig{
+			OnEnvironmentLookupFailure:      "route",
+			EnvironmentLookupFailureDataset: "unknown-environment",
+		})
+		w := sendBatch(router, envAPIKey)
+		require.Equal(t, http.StatusOK, w.Code)
+		span := <-router.Collector.(*collect.MockCollector).Spans
+		assert.Equal(t, "unknown-environment", span.Dataset)
+		assert.Equal(t, "", span.Environment)
+
+		require.NoError(t, router.processOTLPRequest(context.Background(), otlpBatches(), envAPIKey, ""))
+		span = <-router.Collector.(*collect.MockCollector).Spans
+		assert.Equal(t, "unknown-environment", span.Dataset)
+		assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_environment_lookup_failed"])
+	})
+}
@@ -0,0 +1,103 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/honeycombio/refinery/metrics"
+)
+
+// The sentinel labels start with two underscores, which sanitizeMetricLabel
+// never produces, so they can't collide with a real environment's label.
+const (
+	classicEnvironmentLabel  = "__classic"
+	overflowEnvironmentLabel = "__other"
+)
+
+// environmentMetrics records counters that are suffixed with the environment
+// an event was sent to. The metrics backends have no notion of labels, so each
+// environment gets its own metric name; to keep that bounded, only the first
+// maxEnvironments distinct environments are tracked and the rest are counted
+// as "__other".
+type environmentMetrics struct {
+	metrics         metrics.Metrics
+	maxEnvironments int
+
+	mut        sync.Mutex
+	labels     map[string]string
+	owners     map[string]string
+	registered map[string]struct{}
+}
+
+func newEnvironmentMetrics(m metrics.Metrics, maxEnvironments int) *environmentMetrics {
+	return &environmentMetrics{
+		metrics:         m,
+		maxEnvironments: maxEnvironments,
+		labels:          make(map[string]string),
+		owners:          make(map[string]string),
+		registered:      make(map[string]struct{}),
+	}
+}
+
+// increment bumps the counter named base + "_env_" + the label for environment,
+// registering it first if this is the first time it has been seen.
+func (e *environmentMetrics) increment(base string, environment string) {
+	e.mut.Lock()
+	name := base + "_env_" + e.labelFor(environment)
+	if _, ok := e.registered[name]; !ok {
+		e.metrics.Register(name, "counter")
+		e.registered[name] = struct{}{}
+	}
+	e.mut.Unlock()
+
+	e.metrics.Increment(name)
+}
+
+// labelFor returns the metric name suffix to use for an environment. Must be
+// called with the lock held.
+func (e *environmentMetrics) labelFor(environment string) string {
+	if label, ok := e.labels[environment]; ok {
+		return label
+	}
+	if len(e.labels) >= e.maxEnvironments {
+		return overflowEnvironmentLabel
+	}
+
+	label := classicEnvironmentLabel
+	if environment != "" {
+		label = sanitizeMetricLabel(environment)
+		// environments that differ only by case sanitize to the same label;
+		// give later ones a numbered suffix so their counts stay separate
+		base := label
+		for n := 2; ; n++ {
+			if owner, ok := e.owners[label]; !ok || owner == environment {
+				break
+			}
+			label = fmt.Sprintf("%s__%d", base, n)
+		}
+	}
+	e.labels[environment] = label
+	e.owners[label] = environment
+	return label
+}
+
+// sanitizeMetricLabel lowercases s and escapes every byte that isn't a letter
+// or digit as an underscore followed by two hex digits, so that names like
+// "prod-us" and "prod.us" get distinct labels. The result never contains two
+// underscores in a row.
+func sanitizeMetricLabel(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte(c - 'A' + 'a')
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentMetrics(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	em := newEnvironmentMetrics(mockMetrics, 2)
+
+	em.increment("incoming_router_span", "Prod-US")
+	em.increment("incoming_router_span", "Prod-US")
+	em.increment("incoming_router_span", "")
+	em.increment("incoming_router_span", "staging")
+	em.increment("incoming_router_span", "dev")
+	em.increment("incoming_router_event", "Prod-US")
+
+	count, ok := mockMetrics.Get("incoming_router_span_env_prod_2dus")
+	assert.True(t, ok)
+	assert.Equal(t, float64(2), count)
+
+	count, _ = mockMetrics.Get("incoming_router_span_env___classic")
+	assert.Equal(t, float64(1), count)
+
+	// the cap of 2 environments has been reached, so new ones are bucketed
+	count, _ = mockMetrics.Get("incoming_router_span_env___other")
+	assert.Equal(t, float64(2), count)
+
+	count, _ = mockMetrics.Get("incoming_router_event_env_prod_2dus")
+	assert.Equal(t, float64(1), count)
+}
+
+func TestEnvironmentMetricsLabelsAreDistinct(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	em := newEnvironmentMetrics(mockMetrics, 10)
+
+	for _, env := range []string{"prod-us", "prod.us", "prod_us", "Prod-US", "other", "classic", ""} {
+		em.increment("incoming_router_span", env)
+	}
+
+	for _, name := range []string{
+		"incoming_router_span_env_prod_2dus",
+		"incoming_router_span_env_prod_2eus",
+		"incoming_router_span_env_prod_5fus",
+		"incoming_router_span_env_prod_2dus__2",
+		"incoming_router_span_env_other",
+		"incoming_router_span_env_classic",
+		"incoming_router_span_env___classic",
+	} {
+		count, ok := mockMetrics.Get(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, float64(1), count, name)
+	}
+}
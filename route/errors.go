@@ -1,12 +1,14 @@
 package route
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"runtime/debug"
 
 	husky "github.com/honeycombio/husky/otlp"
+	"github.com/honeycombio/refinery/types"
 )
 
 type handlerError struct {
@@ -26,17 +28,34 @@ type handlerError struct {
 var ErrGenericMessage = "unexpected error!"
 
 var (
-	ErrCaughtPanic         = handlerError{nil, "caught panic", http.StatusInternalServerError, false, false}
-	ErrJSONFailed          = handlerError{nil, "failed to parse JSON", http.StatusBadRequest, false, true}
-	ErrJSONBuildFailed     = handlerError{nil, "failed to build JSON response", http.StatusInternalServerError, false, true}
-	ErrPostBody            = handlerError{nil, "failed to read request body", http.StatusInternalServerError, false, false}
-	ErrAuthNeeded          = handlerError{nil, "unknown API key - check your credentials", http.StatusBadRequest, true, true}
-	ErrConfigReadFailed    = handlerError{nil, "failed to read config", http.StatusBadRequest, false, false}
-	ErrUpstreamFailed      = handlerError{nil, "failed to create upstream request", http.StatusServiceUnavailable, true, true}
-	ErrUpstreamUnavailable = handlerError{nil, "upstream target unavailable", http.StatusServiceUnavailable, true, true}
-	ErrReqToEvent          = handlerError{nil, "failed to parse event", http.StatusBadRequest, false, true}
-	ErrBatchToEvent        = handlerError{nil, "failed to parse event within batch", http.StatusBadRequest, false, true}
-	ErrInvalidContentType  = handlerError{nil, husky.ErrInvalidContentType.Message, husky.ErrInvalidContentType.HTTPStatusCode, false, true}
+	ErrCaughtPanic              = handlerError{nil, "caught panic", http.StatusInternalServerError, false, false}
+	ErrJSONFailed               = handlerError{nil, "failed to parse JSON", http.StatusBadRequest, false, true}
+	ErrJSONBuildFailed          = handlerError{nil, "failed to build JSON response", http.StatusInternalServerError, false, true}
+	ErrPostBody                 = handlerError{nil, "failed to read request body", http.StatusInternalServerError, false, false}
+	ErrAuthNeeded               = handlerError{nil, "unknown API key - check your credentials", http.StatusBadRequest, true, true}
+	ErrMissingAPIKey            = handlerError{nil, "missing API key - set the " + types.APIKeyHeader + " header", http.StatusUnauthorized, false, true}
+	ErrConfigReadFailed         = handlerError{nil, "failed to read config", http.StatusBadRequest, false, false}
+	ErrUpstreamFailed           = handlerError{nil, "failed to create upstream request", http.StatusServiceUnavailable, true, true}
+	ErrUpstreamUnavailable      = handlerError{nil, "upstream target unavailable", http.StatusServiceUnavailable, true, true}
+	ErrReqToEvent               = handlerError{nil, "failed to parse event", http.StatusBadRequest, false, true}
+	ErrBatchToEvent             = handlerError{nil, "failed to parse event within batch", http.StatusBadRequest, false, true}
+	ErrThriftFailed             = handlerError{nil, "failed to parse Thrift", http.StatusBadRequest, true, true}
+	ErrDatasetNotAllowedRequest = handlerError{nil, "dataset not allowed", http.StatusForbidden, false, true}
+	ErrQueryScope               = handlerError{nil, "query token not allowed", http.StatusForbidden, true, true}
+	ErrDrainingRequest          = handlerError{nil, "refinery is draining", http.StatusServiceUnavailable, false, true}
+	ErrNotReadyRequest          = handlerError{nil, "refinery is not ready yet, try again", http.StatusServiceUnavailable, false, true}
+	ErrEnvironmentBusy          = handlerError{nil, "too many environment lookups in progress, try again", http.StatusServiceUnavailable, false, true}
+	ErrEnvironmentUnknown       = handlerError{nil, "failed to look up the environment for the API key, try again", http.StatusServiceUnavailable, false, true}
+	ErrEnvironmentLimit         = handlerError{nil, "too many distinct environments", http.StatusForbidden, false, true}
+	ErrDecoderBusy              = handlerError{nil, "too many compressed requests in progress, try again", http.StatusServiceUnavailable, false, true}
+	ErrCollectorBusy            = handlerError{nil, "collector is too busy to accept more data", http.StatusTooManyRequests, true, true}
+	ErrSpanTooLargeRequest      = handlerError{nil, "span is too large", http.StatusRequestEntityTooLarge, true, true}
+	ErrTraceTooLarge            = handlerError{nil, "trace has too many spans", http.StatusRequestEntityTooLarge, true, true}
+	ErrRequestTooLarge          = handlerError{nil, "request body is too large", http.StatusRequestEntityTooLarge, false, true}
+	ErrBodyChecksum             = handlerError{nil, "request body doesn't match its " + bodyChecksumHeader + " header", http.StatusUnprocessableEntity, false, true}
+	ErrMethodNotAllowed         = handlerError{nil, "method not allowed", http.StatusMethodNotAllowed, true, true}
+	ErrInvalidContentType       = handlerError{nil, husky.ErrInvalidContentType.Message, husky.ErrInvalidContentType.HTTPStatusCode, false, true}
+	ErrUnknownContentType       = handlerError{nil, "unsupported content type - send application/json or application/msgpack", http.StatusUnsupportedMediaType, true, true}
 )
 
 func (r *Router) handlerReturnWithError(w http.ResponseWriter, he handlerError, err error) {
@@ -56,8 +75,12 @@ func (r *Router) handlerReturnWithError(w http.ResponseWriter, he handlerError,
 		fields["error.stack_trace"] = string(debug.Stack())
 	}
 
-	r.Logger.Error().WithFields(fields).Logf("handler returning error")
+	// the logger is nil if the request arrived before the router was wired up
+	if r.Logger != nil {
+		r.Logger.Error().WithFields(fields).Logf("handler returning error")
+	}
 
+	setRetryAfter(w, he.err)
 	w.WriteHeader(he.status)
 
 	errmsg := he.msg
@@ -75,8 +98,20 @@ func (r *Router) handlerReturnWithError(w http.ResponseWriter, he handlerError,
 	w.Write(jsonErrMsg)
 }
 
+// setRetryAfter tells the client when to retry a request that failed with err,
+// if it's worth retrying soon.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if isDecoderBusy(err) || errors.Is(err, ErrEnvironmentLookupBusy) || errors.Is(err, ErrNotReady) {
+		w.Header().Set("Retry-After", "1")
+	}
+}
+
 func (r *Router) handleOTLPFailureResponse(w http.ResponseWriter, req *http.Request, otlpErr husky.OTLPError) {
 	r.Logger.Error().Logf(otlpErr.Error())
+	if isMsgpackContentType(req.Header.Get("Content-Type")) {
+		r.writeOTLPMsgpackResponse(w, otlpErr.HTTPStatusCode, map[string]any{"message": otlpErr.Error()})
+		return
+	}
 	if err := husky.WriteOtlpHttpFailureResponse(w, req, otlpErr); err != nil {
 		// If we made it here we had a problem writing an OTLP HTTP response
 		resp := fmt.Sprintf("failed to write otlp http response, %v", err.Error())
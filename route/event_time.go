@@ -0,0 +1,35 @@
+package route
+
+import (
+	"time"
+)
+
+// Where an event's timestamp can come from; see config.EventTimeSources.
+const (
+	eventTimeFromHeader   = "header"
+	eventTimeFromBody     = "body"
+	eventTimeFromReceived = "received"
+)
+
+// chooseEventTime returns the time from the first of sources that has one,
+// and which source that was. header is the time from the
+// X-Honeycomb-Event-Time header and body is the event's own time; either can
+// be zero if the event doesn't have it. If none of the sources has a time,
+// the zero time is returned, which leaves it to Honeycomb to pick one.
+func chooseEventTime(sources []string, header, body time.Time) (time.Time, string) {
+	for _, source := range sources {
+		switch source {
+		case eventTimeFromHeader:
+			if !header.IsZero() {
+				return header, source
+			}
+		case eventTimeFromBody:
+			if !body.IsZero() {
+				return body, source
+			}
+		case eventTimeFromReceived:
+			return time.Now().UTC(), source
+		}
+	}
+	return time.Time{}, ""
+}
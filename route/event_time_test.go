@@ -0,0 +1,130 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChooseEventTime(t *testing.T) {
+	header := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		sources    []string
+		header     time.Time
+		body       time.Time
+		want       time.Time
+		wantSource string
+	}{
+		{"header first", []string{"header", "body"}, header, body, header, "header"},
+		{"header first without a header", []string{"header", "body"}, time.Time{}, body, body, "body"},
+		{"body first", []string{"body", "header"}, header, body, body, "body"},
+		{"body first without a body", []string{"body", "header"}, header, time.Time{}, header, "header"},
+		{"header only", []string{"header"}, time.Time{}, body, time.Time{}, ""},
+		{"neither", []string{"header", "body"}, time.Time{}, time.Time{}, time.Time{}, ""},
+		{"nothing", []string{}, header, body, time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, source := chooseEventTime(tt.sources, tt.header, tt.body)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+
+	t.Run("received", func(t *testing.T) {
+		before := time.Now().UTC()
+		got, source := chooseEventTime([]string{"received", "header"}, header, body)
+		assert.Equal(t, "received", source)
+		assert.False(t, got.Before(before))
+
+		got, source = chooseEventTime([]string{"body", "received"}, header, body)
+		assert.Equal(t, body, got)
+		assert.Equal(t, "body", source)
+
+		got, source = chooseEventTime([]string{"header", "received"}, time.Time{}, body)
+		assert.Equal(t, "received", source)
+		assert.False(t, got.Before(before))
+	})
+}
+
+func TestEventTimeSources(t *testing.T) {
+	const (
+		headerTime = "2024-01-01T00:00:00Z"
+		bodyTime   = "2024-02-02T00:00:00Z"
+	)
+	header, _ := time.Parse(time.RFC3339, headerTime)
+	body, _ := time.Parse(time.RFC3339, bodyTime)
+
+	send := func(t *testing.T, sources []string) *types.Span {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			EventTimeSources:  sources,
+		})
+		req := httptest.NewRequest("POST", "/1/events/dataset", bytes.NewReader([]byte(`{"trace.trace_id":"abc","time":"`+bodyTime+`"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req.Header.Set(types.TimestampHeader, headerTime)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.event(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+		select {
+		case sp := <-router.Collector.(*collect.MockCollector).Spans:
+			return sp
+		case <-time.After(time.Second):
+			t.Fatal("span wasn't sent to the collector")
+			return nil
+		}
+	}
+
+	t.Run("header then body by default", func(t *testing.T) {
+		sp := send(t, nil)
+		assert.Equal(t, header, sp.Timestamp)
+		// the body's time wasn't used, so it's kept as data
+		assert.Equal(t, bodyTime, sp.Data["time"])
+	})
+
+	t.Run("body then header", func(t *testing.T) {
+		sp := send(t, []string{"body", "header"})
+		assert.Equal(t, body, sp.Timestamp)
+		assert.NotContains(t, sp.Data, "time")
+	})
+
+	t.Run("received only", func(t *testing.T) {
+		before := time.Now().UTC()
+		sp := send(t, []string{"received"})
+		assert.False(t, sp.Timestamp.Before(before))
+		assert.Equal(t, bodyTime, sp.Data["time"])
+	})
+
+	t.Run("batch events ignore the header", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			EventTimeSources:  []string{"header", "received"},
+		})
+		before := time.Now().UTC()
+		req := httptest.NewRequest("POST", "/1/batch/dataset", bytes.NewReader([]byte(`[{"time":"`+bodyTime+`","data":{"trace.trace_id":"abc"}}]`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.batch(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		sp := <-router.Collector.(*collect.MockCollector).Spans
+		assert.False(t, sp.Timestamp.Before(before))
+	})
+}
@@ -0,0 +1,130 @@
+package route
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// coerceFieldTypes converts the values of the fields listed in
+// FieldTypeCoercions to their configured types, so that sampler rules see
+// consistent types however the events were instrumented. Values that can't
+// be converted are left alone and counted.
+func (r *Router) coerceFieldTypes(ev *types.Event) {
+	coercions := r.Config.GetFieldTypeCoercions()
+	if len(coercions) == 0 {
+		return
+	}
+	for field, typ := range coercions {
+		v, ok := ev.Data[field]
+		if !ok || v == nil {
+			continue
+		}
+		coerced, ok := coerceValue(v, typ)
+		if !ok {
+			r.Metrics.Increment("incoming_router_field_coercion_failed")
+			continue
+		}
+		ev.Data[field] = coerced
+	}
+}
+
+// coerceValue converts v to typ, which is one of config.FieldTypes. Numbers
+// are converted to int64 or float64, whatever type they started as.
+func coerceValue(v interface{}, typ string) (interface{}, bool) {
+	switch typ {
+	case "int":
+		switch v := v.(type) {
+		case string:
+			s := strings.TrimSpace(v)
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, true
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				if i, ok := floatToInt(f); ok {
+					return i, true
+				}
+			}
+		case float64:
+			if i, ok := floatToInt(v); ok {
+				return i, true
+			}
+		case float32:
+			if i, ok := floatToInt(float64(v)); ok {
+				return i, true
+			}
+		case int:
+			return int64(v), true
+		case int64:
+			return v, true
+		case int32:
+			return int64(v), true
+		case uint64:
+			if v <= math.MaxInt64 {
+				return int64(v), true
+			}
+		}
+	case "float":
+		switch v := v.(type) {
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, true
+			}
+		case float64:
+			return v, true
+		case float32:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		case int64:
+			return float64(v), true
+		case int32:
+			return float64(v), true
+		case uint64:
+			return float64(v), true
+		}
+	case "bool":
+		switch v := v.(type) {
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, true
+			}
+		case bool:
+			return v, true
+		default:
+			if f, ok := coerceValue(v, "float"); ok && (f == 0.0 || f == 1.0) {
+				return f == 1.0, true
+			}
+		}
+	case "string":
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case bool:
+			return strconv.FormatBool(v), true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case float32:
+			return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+		case int:
+			return strconv.Itoa(v), true
+		case int64:
+			return strconv.FormatInt(v, 10), true
+		case int32:
+			return strconv.FormatInt(int64(v), 10), true
+		case uint64:
+			return strconv.FormatUint(v, 10), true
+		}
+	}
+	return v, false
+}
+
+// floatToInt converts f to an int64 if it's a whole number in range.
+func floatToInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
@@ -0,0 +1,96 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		typ   string
+		want  interface{}
+		ok    bool
+	}{
+		{"int from string", "500", "int", int64(500), true},
+		{"int from padded string", " 500 ", "int", int64(500), true},
+		{"int from whole float string", "500.0", "int", int64(500), true},
+		{"int from fractional string", "1.5", "int", "1.5", false},
+		{"int from bad string", "abc", "int", "abc", false},
+		{"int from whole float", float64(500), "int", int64(500), true},
+		{"int from fractional float", 1.5, "int", 1.5, false},
+		{"int from int", 500, "int", int64(500), true},
+		{"int from bool", true, "int", true, false},
+
+		{"float from string", "1.5", "float", 1.5, true},
+		{"float from bad string", "abc", "float", "abc", false},
+		{"float from int", int64(2), "float", float64(2), true},
+		{"float from float", 1.5, "float", 1.5, true},
+		{"float from bool", true, "float", true, false},
+
+		{"bool from string", "true", "bool", true, true},
+		{"bool from numeric string", "0", "bool", false, true},
+		{"bool from bad string", "yes", "bool", "yes", false},
+		{"bool from one", float64(1), "bool", true, true},
+		{"bool from zero", int64(0), "bool", false, true},
+		{"bool from other number", float64(2), "bool", float64(2), false},
+		{"bool from bool", false, "bool", false, true},
+
+		{"string from int", int64(500), "string", "500", true},
+		{"string from float", 1.5, "string", "1.5", true},
+		{"string from whole float", float64(500), "string", "500", true},
+		{"string from bool", true, "string", "true", true},
+		{"string from string", "abc", "string", "abc", true},
+		{"string from map", map[string]interface{}{}, "string", map[string]interface{}{}, false},
+
+		{"unknown type", "500", "duration", "500", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := coerceValue(tt.value, tt.typ)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCoerceFieldTypes(t *testing.T) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		FieldTypeCoercions: map[string]string{
+			"status_code": "int",
+			"duration_ms": "float",
+			"error":       "bool",
+			"user.id":     "string",
+			"retries":     "int",
+		},
+	}
+	router, mockMetrics := newBatchTestRouter(t, conf)
+	require.NoError(t, router.processEvent(&types.Event{
+		Dataset: "dataset",
+		Data: map[string]interface{}{
+			"trace.trace_id": "trace",
+			"status_code":    "500",
+			"duration_ms":    "12.5",
+			"error":          "true",
+			"user.id":        float64(42),
+			"retries":        "many",
+			"other":          "500",
+		},
+	}, nil))
+
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.Equal(t, int64(500), span.Data["status_code"])
+	assert.Equal(t, 12.5, span.Data["duration_ms"])
+	assert.Equal(t, true, span.Data["error"])
+	assert.Equal(t, "42", span.Data["user.id"])
+	assert.Equal(t, "many", span.Data["retries"], "values that can't be converted are left alone")
+	assert.Equal(t, "500", span.Data["other"], "unlisted fields are untouched")
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_field_coercion_failed"])
+}
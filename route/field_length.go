@@ -0,0 +1,58 @@
+package route
+
+import (
+	"slices"
+	"unicode/utf8"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// truncationMarker ends every string value that truncateFieldValues shortens.
+const truncationMarker = "…[truncated]"
+
+// truncateFieldValues shortens the string values in ev that are longer than
+// MaxFieldValueLength, or their field's entry in MaxFieldValueLengths, so that
+// with truncationMarker added they fit. The ID fields are left alone, so that
+// the trace still assembles.
+func (r *Router) truncateFieldValues(ev *types.Event) {
+	maxLength := r.Config.GetMaxFieldValueLength()
+	overrides := r.Config.GetMaxFieldValueLengths()
+	if maxLength <= 0 && len(overrides) == 0 {
+		return
+	}
+
+	idFields := r.idFields()
+	for k, v := range ev.Data {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		limit := maxLength
+		if l, ok := overrides[k]; ok {
+			limit = l
+		}
+		if limit <= 0 || len(s) <= limit {
+			continue
+		}
+		if _, ok := idFields.traceAndParent[k]; ok || slices.Contains(idFields.span, k) {
+			continue
+		}
+		ev.Data[k] = truncateString(s, limit)
+		r.Metrics.Increment("incoming_router_field_truncated")
+	}
+}
+
+// truncateString cuts s so that, with truncationMarker added, it's no more
+// than limit bytes long, without splitting a UTF-8 character. If limit is too
+// small for the marker, s is just cut to limit bytes.
+func truncateString(s string, limit int) string {
+	marker := truncationMarker
+	if limit <= len(marker) {
+		marker = ""
+	}
+	n := limit - len(marker)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + marker
+}
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"ascii", strings.Repeat("a", 100), 64, strings.Repeat("a", 64-len(truncationMarker)) + truncationMarker},
+		// "é" is two bytes, and the limit would split the last one
+		{"multibyte", strings.Repeat("é", 50), 65, strings.Repeat("é", 25) + truncationMarker},
+		{"limit shorter than the marker", "abcdefghijklmnopqrstuvwxyz", 5, "abcde"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateString(tt.s, tt.limit)
+			assert.Equal(t, tt.want, got)
+			assert.LessOrEqual(t, len(got), tt.limit)
+		})
+	}
+}
+
+func TestProcessEventTruncatesFieldValues(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:    []string{"trace.trace_id"},
+		ParentIdFieldNames:   []string{"trace.parent_id"},
+		SpanIdFieldNames:     []string{"trace.span_id"},
+		MaxFieldValueLength:  100,
+		MaxFieldValueLengths: map[string]int{"db.statement": 50, "exception.stacktrace": 0},
+	})
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id":       long,
+		"trace.parent_id":      long,
+		"trace.span_id":        long,
+		"message":              long,
+		"short":                "short",
+		"db.statement":         long,
+		"exception.stacktrace": long,
+		"count":                int64(12345),
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+
+	// the ID fields are never truncated
+	assert.Equal(t, long, span.Data["trace.trace_id"])
+	assert.Equal(t, long, span.Data["trace.parent_id"])
+	assert.Equal(t, long, span.Data["trace.span_id"])
+
+	assert.Equal(t, strings.Repeat("x", 100-len(truncationMarker))+truncationMarker, span.Data["message"])
+	assert.Equal(t, strings.Repeat("x", 50-len(truncationMarker))+truncationMarker, span.Data["db.statement"])
+	assert.Equal(t, long, span.Data["exception.stacktrace"], "a limit of 0 turns truncation off for the field")
+	assert.Equal(t, "short", span.Data["short"])
+	assert.Equal(t, int64(12345), span.Data["count"])
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_field_truncated"])
+}
+
+func TestProcessEventFieldValuesUnlimited(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+	})
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "abc",
+		"message":        long,
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.Equal(t, long, span.Data["message"])
+	assert.Zero(t, mockMetrics.CounterIncrements["incoming_router_field_truncated"])
+}
@@ -0,0 +1,52 @@
+package route
+
+import (
+	"fmt"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+)
+
+// isForceKept reports whether an event matches any of the
+// ForceKeepConditions. Values are compared by their text, since a field like
+// error may arrive as either a boolean or a string.
+func (r *Router) isForceKept(ev *types.Event) bool {
+	for _, cond := range r.Config.GetForceKeepConditions() {
+		if matchesCondition(ev, cond) {
+			return true
+		}
+	}
+	return false
+}
+
+// isErrorSpan reports whether an event is an error whose trace is kept
+// because of KeepErrorTraces.
+func (r *Router) isErrorSpan(ev *types.Event) bool {
+	return r.Config.GetKeepErrorTraces() && matchesCondition(ev, r.Config.GetErrorTraceCondition())
+}
+
+// isSynthetic reports whether an event matches the SyntheticTraceCondition.
+func (r *Router) isSynthetic(ev *types.Event) bool {
+	cond, ok := r.Config.GetSyntheticTraceCondition()
+	return ok && matchesCondition(ev, cond)
+}
+
+// isTraceComplete reports whether an event matches the TraceCompleteCondition.
+func (r *Router) isTraceComplete(ev *types.Event) bool {
+	cond, ok := r.Config.GetTraceCompleteCondition()
+	return ok && matchesCondition(ev, cond)
+}
+
+func matchesCondition(ev *types.Event, cond config.ForceKeepCondition) bool {
+	val, ok := ev.Data[cond.Field]
+	if !ok {
+		return false
+	}
+	if !cond.HasValue {
+		return true
+	}
+	if s, isString := val.(string); isString {
+		return s == cond.Value
+	}
+	return fmt.Sprint(val) == cond.Value
+}
@@ -0,0 +1,146 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsForceKept(t *testing.T) {
+	conditions := []config.ForceKeepCondition{
+		{Field: "error", Value: "true", HasValue: true},
+		{Field: "force_keep"},
+		{Field: "http.status_code", Value: "503", HasValue: true},
+	}
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want bool
+	}{
+		{"no match", map[string]interface{}{"name": "span"}, false},
+		{"bool value", map[string]interface{}{"error": true}, true},
+		{"string value", map[string]interface{}{"error": "true"}, true},
+		{"wrong value", map[string]interface{}{"error": false}, false},
+		{"field present", map[string]interface{}{"force_keep": false}, true},
+		{"int value", map[string]interface{}{"http.status_code": int64(503)}, true},
+		{"float value", map[string]interface{}{"http.status_code": float64(503)}, true},
+		{"other int value", map[string]interface{}{"http.status_code": int64(200)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newBatchTestRouter(t, &config.MockConfig{ForceKeepConditions: conditions})
+			assert.Equal(t, tt.want, router.isForceKept(&types.Event{Data: tt.data}))
+		})
+	}
+}
+
+func TestProcessEventForceKeep(t *testing.T) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames:   []string{"trace.trace_id"},
+		ParentIdFieldNames:  []string{"trace.parent_id"},
+		ForceKeepConditions: []config.ForceKeepCondition{{Field: "error", Value: "true", HasValue: true}},
+	}
+	router, mockMetrics := newBatchTestRouter(t, conf)
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "trace1",
+		"error":          true,
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.True(t, span.ForceKeep)
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "trace2",
+	}}, nil))
+	span = <-router.Collector.(*collect.MockCollector).Spans
+	assert.False(t, span.ForceKeep)
+
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_force_kept"])
+}
+
+func TestProcessEventSynthetic(t *testing.T) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames:       []string{"trace.trace_id"},
+		SyntheticTraceCondition: config.ForceKeepCondition{Field: "synthetic", Value: "true", HasValue: true},
+	}
+	router, _ := newBatchTestRouter(t, conf)
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "trace1",
+		"synthetic":      true,
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.True(t, span.Synthetic)
+	assert.False(t, span.ForceKeep)
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id": "trace2",
+		"synthetic":      "false",
+	}}, nil))
+	span = <-router.Collector.(*collect.MockCollector).Spans
+	assert.False(t, span.Synthetic)
+}
+
+func TestProcessEventErrorTraces(t *testing.T) {
+	send := func(router *Router, data map[string]interface{}) *types.Span {
+		require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: data}, nil))
+		return <-router.Collector.(*collect.MockCollector).Spans
+	}
+
+	t.Run("default condition", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			KeepErrorTraces:   true,
+		})
+		assert.True(t, send(router, map[string]interface{}{"trace.trace_id": "trace1", "error": true}).Error)
+		assert.False(t, send(router, map[string]interface{}{"trace.trace_id": "trace2"}).Error)
+		assert.False(t, send(router, map[string]interface{}{"trace.trace_id": "trace3", "error": false}).Error)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_error_spans"])
+	})
+
+	t.Run("configured condition", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:   []string{"trace.trace_id"},
+			KeepErrorTraces:     true,
+			ErrorTraceCondition: config.ForceKeepCondition{Field: "status_code", Value: "2", HasValue: true},
+		})
+		assert.True(t, send(router, map[string]interface{}{"trace.trace_id": "trace1", "status_code": int64(2)}).Error)
+		assert.False(t, send(router, map[string]interface{}{"trace.trace_id": "trace2", "error": true}).Error)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+		})
+		assert.False(t, send(router, map[string]interface{}{"trace.trace_id": "trace1", "error": true}).Error)
+	})
+}
+
+func TestProcessEventTraceComplete(t *testing.T) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames:      []string{"trace.trace_id"},
+		ParentIdFieldNames:     []string{"trace.parent_id"},
+		TraceCompleteCondition: config.ForceKeepCondition{Field: "trace.complete", Value: "true", HasValue: true},
+	}
+	router, _ := newBatchTestRouter(t, conf)
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id":  "trace1",
+		"trace.parent_id": "parent",
+		"trace.complete":  true,
+	}}, nil))
+	span := <-router.Collector.(*collect.MockCollector).Spans
+	assert.True(t, span.TraceComplete)
+	assert.False(t, span.IsRoot, "marking the trace complete doesn't make the span its root")
+
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{
+		"trace.trace_id":  "trace1",
+		"trace.parent_id": "parent",
+	}}, nil))
+	span = <-router.Collector.(*collect.MockCollector).Spans
+	assert.False(t, span.TraceComplete)
+}
@@ -0,0 +1,68 @@
+package route
+
+import (
+	"net"
+	"sync"
+)
+
+// grpcConnLimitListener wraps the gRPC server's listeners so that no more
+// than MaxConnections connections are open at once, across all of them.
+// Unlike netutil.LimitListener, which stops accepting and leaves excess
+// connections waiting in the kernel's backlog, connections beyond the limit
+// are accepted and closed straight away, so that they can be counted and the
+// client finds out at once rather than hanging.
+type grpcConnLimitListener struct {
+	net.Listener
+	r *Router
+}
+
+func (r *Router) limitGRPCConnections(l net.Listener) net.Listener {
+	return &grpcConnLimitListener{Listener: l, r: r}
+}
+
+func (l *grpcConnLimitListener) Accept() (net.Conn, error) {
+	r := l.r
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		limit := r.Config.GetGRPCMaxConnections()
+		open := r.grpcConnections.Add(1)
+		if limit > 0 && open > int64(limit) {
+			r.grpcConnections.Add(-1)
+			conn.Close()
+			r.Metrics.Increment("incoming_router_grpc_connections_rejected")
+			// only log when we start rejecting, so a client stuck in a
+			// reconnect loop doesn't flood the log
+			if r.grpcRejectingConnections.CompareAndSwap(false, true) {
+				r.iopLogger.Warn().
+					WithString("remote_addr", conn.RemoteAddr().String()).
+					WithField("max_connections", limit).
+					Logf("rejecting gRPC connections beyond MaxConnections; further rejections are only counted until a connection is accepted")
+			}
+			continue
+		}
+		r.grpcRejectingConnections.Store(false)
+		r.Metrics.Gauge("incoming_router_grpc_connections", open)
+		return &grpcLimitedConn{Conn: conn, r: r}, nil
+	}
+}
+
+// grpcLimitedConn releases its place in the connection limit when it's
+// closed. gRPC may close a connection more than once, so that only happens
+// the first time.
+type grpcLimitedConn struct {
+	net.Conn
+	r    *Router
+	once sync.Once
+}
+
+func (c *grpcLimitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.r.Metrics.Gauge("incoming_router_grpc_connections", c.r.grpcConnections.Add(-1))
+	})
+	return err
+}
@@ -0,0 +1,70 @@
+package route
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCConnectionLimit(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{GRPCMaxConnections: 2})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	limited := router.limitGRPCConnections(l)
+	defer limited.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	// the server's side of a rejected connection is closed, so reads fail
+	isClosed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		return err != nil && !isTimeout(err)
+	}
+
+	dial()
+	dial()
+	first := <-accepted
+	<-accepted
+	assert.True(t, isClosed(dial()), "a connection over the limit is closed")
+	assert.Eventually(t, func() bool {
+		v, _ := mockMetrics.Get("incoming_router_grpc_connections_rejected")
+		return v == 1
+	}, time.Second, 10*time.Millisecond)
+	v, _ := mockMetrics.Get("incoming_router_grpc_connections")
+	assert.Equal(t, float64(2), v)
+
+	// closing a connection, even twice, frees exactly one place
+	first.Close()
+	first.Close()
+	v, _ = mockMetrics.Get("incoming_router_grpc_connections")
+	assert.Equal(t, float64(1), v)
+	conn := dial()
+	<-accepted
+	assert.False(t, isClosed(conn))
+	assert.True(t, isClosed(dial()))
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,28 @@
+package route
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPCExport counts a gRPC export request for service ("traces" or
+// "logs") as in flight, and returns a function to call once it's done. If
+// MaxConcurrentExports requests are already in flight, the request is shed
+// with ResourceExhausted instead, so that the client backs off. Requests that
+// arrive before the router is ready get Unavailable.
+func (r *Router) startGRPCExport(service string) (done func(), err error) {
+	if !r.dependenciesReady() {
+		return nil, status.Error(codes.Unavailable, ErrNotReady.Error())
+	}
+	limit := r.Config.GetGRPCMaxConcurrentExports()
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	if r.grpcExportsInFlight.Add(1) > int64(limit) {
+		r.grpcExportsInFlight.Add(-1)
+		r.Metrics.Increment("incoming_router_grpc_" + service + "_shed")
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"refinery is already handling %d export requests; retry %s export later", limit, service)
+	}
+	return func() { r.grpcExportsInFlight.Add(-1) }, nil
+}
@@ -0,0 +1,258 @@
+package route
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+	trace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	healthserver "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// compressionRecorder records the compression of the responses a client
+// receives.
+type compressionRecorder struct {
+	mut          sync.Mutex
+	compressions []string
+}
+
+func (c *compressionRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *compressionRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		c.mut.Lock()
+		c.compressions = append(c.compressions, h.Compression)
+		c.mut.Unlock()
+	}
+}
+
+func (c *compressionRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *compressionRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func startTestGRPCServer(t *testing.T, grpcConfig config.GRPCServerParameters, opts ...grpc.DialOption) grpc_health_v1.HealthClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpcServerOptions(grpcConfig)...)
+	health := healthserver.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(l.Addr().String(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func TestGRPCServerOptions(t *testing.T) {
+	t.Run("response compression", func(t *testing.T) {
+		for _, compression := range []string{"none", "gzip"} {
+			recorder := &compressionRecorder{}
+			client := startTestGRPCServer(t, config.GRPCServerParameters{
+				MaxSendMsgSize:      config.MemorySize(5_000_000),
+				MaxRecvMsgSize:      config.MemorySize(5_000_000),
+				ResponseCompression: compression,
+			}, grpc.WithStatsHandler(recorder))
+
+			_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			require.NoError(t, err)
+
+			want := ""
+			if compression == "gzip" {
+				want = "gzip"
+			}
+			recorder.mut.Lock()
+			assert.Equal(t, []string{want}, recorder.compressions, compression)
+			recorder.mut.Unlock()
+		}
+	})
+
+	t.Run("max concurrent streams", func(t *testing.T) {
+		client := startTestGRPCServer(t, config.GRPCServerParameters{
+			MaxSendMsgSize:       config.MemorySize(5_000_000),
+			MaxRecvMsgSize:       config.MemorySize(5_000_000),
+			MaxConcurrentStreams: 1,
+		})
+		// make sure the connection is up and the server's settings have arrived
+		_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err)
+
+		// hold the only stream open
+		watchCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch, err := client.Watch(watchCtx, &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = watch.Recv()
+		require.NoError(t, err)
+
+		ctx, cancelCheck := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancelCheck()
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+		// once the stream is released, calls go through again
+		cancel()
+		assert.Eventually(t, func() bool {
+			_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+type stubLogsServer struct {
+	collectorlogs.UnimplementedLogsServiceServer
+}
+
+func (stubLogsServer) Export(context.Context, *collectorlogs.ExportLogsServiceRequest) (*collectorlogs.ExportLogsServiceResponse, error) {
+	return &collectorlogs.ExportLogsServiceResponse{}, nil
+}
+
+type stubTraceServer struct {
+	collectortrace.UnimplementedTraceServiceServer
+}
+
+func (stubTraceServer) Export(context.Context, *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+func TestGRPCMaxRecvMsgSize(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpcServerOptions(config.GRPCServerParameters{
+		MaxSendMsgSize:     config.MemorySize(5_000_000),
+		MaxRecvMsgSize:     config.MemorySize(1_000_000),
+		MaxLogsRecvMsgSize: config.MemorySize(3_000_000),
+	})...)
+	collectorlogs.RegisterLogsServiceServer(server, stubLogsServer{})
+	collectortrace.RegisterTraceServiceServer(server, stubTraceServer{})
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// compresses to almost nothing, so only the decompressed size is over
+	body := strings.Repeat("x", 2_000_000)
+
+	_, err = collectorlogs.NewLogsServiceClient(conn).Export(context.Background(), &collectorlogs.ExportLogsServiceRequest{
+		ResourceLogs: []*logs.ResourceLogs{{
+			ScopeLogs: []*logs.ScopeLogs{{
+				LogRecords: []*logs.LogRecord{{
+					Body: &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: body}},
+				}},
+			}},
+		}},
+	})
+	assert.NoError(t, err)
+
+	_, err = collectortrace.NewTraceServiceClient(conn).Export(context.Background(), &collectortrace.ExportTraceServiceRequest{
+		ResourceSpans: []*trace.ResourceSpans{{
+			ScopeSpans: []*trace.ScopeSpans{{
+				Spans: []*trace.Span{{Name: body}},
+			}},
+		}},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "limit of 1000000 bytes")
+}
+
+func TestGRPCMaxConcurrentExports(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:        []string{"trace.trace_id"},
+		GRPCMaxConcurrentExports: 1,
+	})
+
+	// one export is already in progress
+	done, err := router.startGRPCExport("traces")
+	require.NoError(t, err)
+
+	_, err = NewTraceServer(router).Export(context.Background(), &collectortrace.ExportTraceServiceRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = NewLogsServer(router).Export(context.Background(), &collectorlogs.ExportLogsServiceRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_grpc_traces_shed"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_grpc_logs_shed"])
+
+	// once it's finished, there's room again; the request fails for
+	// other reasons, but it isn't shed
+	done()
+	_, err = NewTraceServer(router).Export(context.Background(), &collectortrace.ExportTraceServiceRequest{})
+	assert.NotEqual(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, int64(0), router.grpcExportsInFlight.Load())
+}
+
+func TestGRPCReflection(t *testing.T) {
+	listServices := func(t *testing.T, enabled bool) ([]string, error) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{GRPCReflectionEnabled: enabled})
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server := grpc.NewServer()
+		router.registerGRPCServices(server)
+		go server.Serve(l)
+		t.Cleanup(server.Stop)
+
+		conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		stream, err := grpc_reflection_v1.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+		require.NoError(t, err)
+		err = stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+			MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{},
+		})
+		require.NoError(t, err)
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, svc := range resp.GetListServicesResponse().GetService() {
+			names = append(names, svc.GetName())
+		}
+		return names, nil
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := listServices(t, false)
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		names, err := listServices(t, true)
+		require.NoError(t, err)
+		assert.Contains(t, names, "opentelemetry.proto.collector.trace.v1.TraceService")
+		assert.Contains(t, names, "opentelemetry.proto.collector.logs.v1.LogsService")
+		assert.Contains(t, names, "grpc.health.v1.Health")
+	})
+}
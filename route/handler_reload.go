@@ -0,0 +1,42 @@
+package route
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// swappableHandler is an http.Handler that passes requests on to a handler
+// that can be replaced while the server is running. Requests already being
+// served finish with the handler they started with, and connections are
+// never closed by a swap.
+type swappableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func newSwappableHandler(h http.Handler) *swappableHandler {
+	s := &swappableHandler{}
+	s.swap(h)
+	return s
+}
+
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, req)
+}
+
+func (s *swappableHandler) swap(h http.Handler) {
+	s.handler.Store(&h)
+}
+
+// reloadRoutes rebuilds the servers' routes from the reloaded config, so that
+// settings that only change which routes are served, like
+// DebugEndpointsEnabled, take effect without a restart. Listen addresses are
+// only read at startup.
+func (r *Router) reloadRoutes(cfgHash, rulesHash string) {
+	if r.handler != nil {
+		r.handler.swap(r.mainMuxxer())
+	}
+	if r.otlpHandler != nil {
+		r.otlpHandler.swap(r.otlpOnlyMuxxer())
+	}
+	r.Logger.Debug().Logf("rebuilt routes after config reload")
+}
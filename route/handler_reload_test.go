@@ -0,0 +1,56 @@
+package route
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadRoutes(t *testing.T) {
+	conf := &config.MockConfig{DisableProxyUnmatchedRequests: true}
+	router, _ := newBatchTestRouter(t, conf)
+	router.handler = newSwappableHandler(router.mainMuxxer())
+	conf.RegisterReloadCallback(router.reloadRoutes)
+
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(router.handler)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	client := server.Client()
+
+	get := func() int {
+		resp, err := client.Get(server.URL + "/panic")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(), "debug endpoints are off")
+
+	conf.Mux.Lock()
+	conf.DebugEndpointsEnabled = true
+	conf.Mux.Unlock()
+	conf.ReloadConfig()
+	assert.Equal(t, http.StatusInternalServerError, get(), "the panic endpoint is served after reload")
+
+	conf.Mux.Lock()
+	conf.DebugEndpointsEnabled = false
+	conf.Mux.Unlock()
+	conf.ReloadConfig()
+	assert.Equal(t, http.StatusNotFound, get())
+
+	assert.Equal(t, int32(1), connections.Load(), "every request used the same connection")
+}
@@ -0,0 +1,55 @@
+package route
+
+// idFieldNames is a snapshot of the configured ID field names, read on every
+// event without calling into the config. It's never modified once it's been
+// stored; a config reload replaces it.
+type idFieldNames struct {
+	trace  []string
+	parent []string
+	span   []string
+
+	// traceAndParent holds the trace and parent ID field names, which are
+	// never redacted or decoded as numbers
+	traceAndParent map[string]struct{}
+}
+
+func newIDFieldNames(trace, parent, span []string) *idFieldNames {
+	names := &idFieldNames{
+		trace:          trace,
+		parent:         parent,
+		span:           span,
+		traceAndParent: make(map[string]struct{}, len(trace)+len(parent)),
+	}
+	for _, name := range trace {
+		names.traceAndParent[name] = struct{}{}
+	}
+	for _, name := range parent {
+		names.traceAndParent[name] = struct{}{}
+	}
+	return names
+}
+
+// idFields returns the current snapshot of the ID field names, taking it from
+// the config the first time it's needed.
+func (r *Router) idFields() *idFieldNames {
+	if names := r.idFieldNames.Load(); names != nil {
+		return names
+	}
+	return r.refreshIDFieldNames()
+}
+
+func (r *Router) refreshIDFieldNames() *idFieldNames {
+	names := newIDFieldNames(
+		r.Config.GetTraceIdFieldNames(),
+		r.Config.GetParentIdFieldNames(),
+		r.Config.GetSpanIdFieldNames(),
+	)
+	r.idFieldNames.Store(names)
+	return names
+}
+
+// reloadIDFieldNames replaces the snapshot of the ID field names when the
+// config is reloaded.
+func (r *Router) reloadIDFieldNames(cfgHash, rulesHash string) {
+	r.refreshIDFieldNames()
+}
@@ -0,0 +1,70 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDFieldNamesReload(t *testing.T) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames:  []string{"trace.trace_id"},
+		ParentIdFieldNames: []string{"trace.parent_id"},
+		SpanIdFieldNames:   []string{"trace.span_id"},
+	}
+	router, _ := newBatchTestRouter(t, conf)
+	conf.RegisterReloadCallback(router.reloadIDFieldNames)
+
+	names := router.idFields()
+	assert.Equal(t, []string{"trace.trace_id"}, names.trace)
+	assert.Equal(t, []string{"trace.parent_id"}, names.parent)
+	assert.Equal(t, []string{"trace.span_id"}, names.span)
+	assert.Equal(t, map[string]struct{}{"trace.trace_id": {}, "trace.parent_id": {}}, names.traceAndParent)
+
+	conf.Mux.Lock()
+	conf.TraceIdFieldNames = []string{"traceId"}
+	conf.Mux.Unlock()
+	assert.Same(t, names, router.idFields(), "the snapshot is only replaced on reload")
+
+	conf.ReloadConfig()
+	reloaded := router.idFields()
+	assert.Equal(t, []string{"traceId"}, reloaded.trace)
+	assert.Equal(t, map[string]struct{}{"traceId": {}, "trace.parent_id": {}}, reloaded.traceAndParent)
+	assert.Equal(t, []string{"trace.trace_id"}, names.trace, "the old snapshot is unchanged")
+}
+
+// BenchmarkIDFieldNames compares reading the ID fields from the config on
+// every event, as redaction and JSON number decoding used to, with reading
+// the router's snapshot.
+func BenchmarkIDFieldNames(b *testing.B) {
+	conf := &config.MockConfig{
+		TraceIdFieldNames:  []string{"trace.trace_id", "traceId"},
+		ParentIdFieldNames: []string{"trace.parent_id", "parentId"},
+		SpanIdFieldNames:   []string{"trace.span_id"},
+	}
+	router := &Router{Config: conf}
+
+	b.Run("config", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			protected := make(map[string]struct{})
+			for _, name := range router.Config.GetTraceIdFieldNames() {
+				protected[name] = struct{}{}
+			}
+			for _, name := range router.Config.GetParentIdFieldNames() {
+				protected[name] = struct{}{}
+			}
+			_ = router.Config.GetSpanIdFieldNames()
+		}
+	})
+
+	b.Run("snapshot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			names := router.idFields()
+			_ = names.traceAndParent
+			_ = names.span
+		}
+	})
+}
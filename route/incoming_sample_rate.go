@@ -0,0 +1,32 @@
+package route
+
+import "github.com/honeycombio/refinery/types"
+
+const incomingSampleRateFieldName = "meta.refinery.incoming_sample_rate"
+
+// addIncomingSampleRate records the sample rate that ev arrived with, if
+// PreserveIncomingSampleRate is on, before sampling multiplies it. The field
+// isn't replaced if it's already there, so that when Refineries are chained,
+// or a span is forwarded to a peer, it keeps the rate from the first
+// Refinery that received it.
+func (r *Router) addIncomingSampleRate(ev *types.Event) {
+	if !r.Config.GetPreserveIncomingSampleRate() {
+		return
+	}
+	if ev.Data == nil {
+		ev.Data = make(map[string]interface{})
+	}
+	if _, ok := ev.Data[incomingSampleRateFieldName]; ok {
+		return
+	}
+	ev.Data[incomingSampleRateFieldName] = incomingSampleRate(ev)
+}
+
+// incomingSampleRate returns the sample rate that ev arrived with.
+func incomingSampleRate(ev *types.Event) uint {
+	if ev.SampleRate < 1 {
+		// missing or 0 means that the event wasn't sampled
+		return 1
+	}
+	return ev.SampleRate
+}
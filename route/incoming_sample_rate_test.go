@@ -0,0 +1,98 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddIncomingSampleRate(t *testing.T) {
+	body := `[{"samplerate":5,"data":{"trace.trace_id":"a"}},` +
+		`{"data":{"trace.trace_id":"a"}},` +
+		`{"samplerate":5,"data":{"trace.trace_id":"a","meta.refinery.incoming_sample_rate":20}}]`
+	for _, enabled := range []bool{true, false} {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:          []string{"trace.trace_id"},
+			PreserveIncomingSampleRate: enabled,
+		})
+
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		router.batch(httptest.NewRecorder(), req)
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 3)
+		sampled, unsampled, chained := <-spans, <-spans, <-spans
+		if !enabled {
+			assert.NotContains(t, sampled.Data, incomingSampleRateFieldName)
+			continue
+		}
+		assert.Equal(t, uint(5), sampled.Data[incomingSampleRateFieldName])
+		assert.Equal(t, uint(1), unsampled.Data[incomingSampleRateFieldName])
+		assert.Equal(t, float64(20), chained.Data[incomingSampleRateFieldName], "an earlier Refinery's value is kept")
+	}
+}
+
+func TestBatchResponseSampleRate(t *testing.T) {
+	body := `[{"samplerate":5,"data":{"trace.trace_id":"a"}},` +
+		`{"data":{"trace.trace_id":"b"}},` +
+		`{"data":{}}]`
+	send := func(router *Router) []BatchResponse {
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.batch(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var responses []BatchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+		require.Len(t, responses, 3)
+		return responses
+	}
+
+	t.Run("buffered", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+		})
+		responses := send(router)
+		// sampling happens later, so it's the rate the events arrived with
+		assert.Equal(t, uint(5), responses[0].SampleRate)
+		assert.Equal(t, uint(1), responses[1].SampleRate)
+		assert.Equal(t, uint(1), responses[2].SampleRate, "events that aren't spans get one too")
+	})
+
+	t.Run("processed immediately", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+		})
+		router.Collector.(*collect.MockCollector).StressedSampleRate = 10
+		responses := send(router)
+		// Stress Relief has sampled the spans already
+		assert.Equal(t, uint(50), responses[0].SampleRate)
+		assert.Equal(t, uint(10), responses[1].SampleRate)
+		assert.Equal(t, uint(1), responses[2].SampleRate, "events that aren't spans aren't sampled")
+	})
+
+	t.Run("not for failures", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+			DeniedDatasets:    []string{"dataset"},
+		})
+		for _, resp := range send(router) {
+			assert.Equal(t, http.StatusForbidden, resp.Status)
+			assert.Zero(t, resp.SampleRate)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package route
+
+import (
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// ingestLagFieldName holds how long before it arrived an event was timestamped.
+const ingestLagFieldName = "meta.refinery.ingest_lag_ms"
+
+// addIngestLag tags ev with the milliseconds between its timestamp and now, if
+// AddIngestLagField is on. Events from the future get a lag of 0 unless
+// AllowNegativeIngestLag is on, since the negative value mostly measures how
+// wrong the producer's clock is.
+func (r *Router) addIngestLag(ev *types.Event) {
+	if ev.Timestamp.IsZero() || !r.Config.GetAddIngestLagField() {
+		return
+	}
+	lag := time.Since(ev.Timestamp)
+	if lag < 0 && !r.Config.GetAllowNegativeIngestLag() {
+		lag = 0
+	}
+	if ev.Data == nil {
+		ev.Data = make(map[string]interface{})
+	}
+	ev.Data[ingestLagFieldName] = float64(lag) / float64(time.Millisecond)
+}
@@ -0,0 +1,65 @@
+package route
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddIngestLag(t *testing.T) {
+	tests := []struct {
+		name          string
+		offset        time.Duration
+		allowNegative bool
+		// the lag must be within a second above min, to allow for a slow test
+		min float64
+	}{
+		{"past", -5 * time.Second, false, 5000},
+		{"present", 0, false, 0},
+		{"future clamped", 5 * time.Second, false, 0},
+		{"future negative", 5 * time.Second, true, -5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:      []string{"trace.trace_id"},
+				AddIngestLagField:      true,
+				AllowNegativeIngestLag: tt.allowNegative,
+			})
+			require.NoError(t, router.processEvent(&types.Event{
+				Dataset:   "dataset",
+				Timestamp: time.Now().Add(tt.offset),
+				Data:      map[string]interface{}{"trace.trace_id": "abc"},
+			}, nil))
+
+			span := <-router.Collector.(*collect.MockCollector).Spans
+			lag, ok := span.Data[ingestLagFieldName].(float64)
+			require.True(t, ok, "ingest lag should be a float64")
+			if tt.min == 0 && tt.offset > 0 {
+				assert.Equal(t, float64(0), lag)
+				return
+			}
+			assert.GreaterOrEqual(t, lag, tt.min)
+			assert.Less(t, lag, tt.min+1000)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames: []string{"trace.trace_id"},
+		})
+		require.NoError(t, router.processEvent(&types.Event{
+			Dataset:   "dataset",
+			Timestamp: time.Now().Add(-5 * time.Second),
+			Data:      map[string]interface{}{"trace.trace_id": "abc"},
+		}, nil))
+
+		span := <-router.Collector.(*collect.MockCollector).Spans
+		assert.NotContains(t, span.Data, ingestLagFieldName)
+	})
+}
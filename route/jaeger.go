@@ -0,0 +1,187 @@
+package route
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// postJaegerThrift accepts the Thrift encoded batches that Jaeger clients
+// send to a Jaeger collector's /api/traces endpoint.
+func (r *Router) postJaegerThrift(w http.ResponseWriter, req *http.Request) {
+	r.Metrics.Increment("incoming_router_jaeger")
+	defer req.Body.Close()
+
+	ct := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil || (mediaType != "application/x-thrift" && mediaType != "application/vnd.apache.thrift.binary") {
+		r.handlerReturnWithError(w, ErrInvalidContentType, fmt.Errorf("unsupported content type %q", ct))
+		return
+	}
+
+	bodyReader, err := r.decompressRequestBody(req)
+	if err != nil {
+		r.handlerReturnWithError(w, bodyReadError(err), err)
+		return
+	}
+	reqBod, err := io.ReadAll(bodyReader)
+	if err != nil {
+		r.handlerReturnWithError(w, bodyReadError(err), err)
+		return
+	}
+
+	batch, err := decodeJaegerBatch(reqBod)
+	if err != nil {
+		r.handlerReturnWithError(w, ErrThriftFailed, err)
+		return
+	}
+
+	// draining can only fail the request before any of the batch is enqueued
+	if r.draining.Load() {
+		r.handlerReturnWithError(w, ErrDrainingRequest, ErrDraining)
+		return
+	}
+
+	apiKey := r.getAPIKey(req)
+	environment, fallbackDataset, err := r.resolveEnvironment(apiKey, r.getEnvironmentOverride(req.Header.Get))
+	if err != nil {
+		r.handlerReturnWithError(w, environmentError(err), err)
+		return
+	}
+
+	dataset := batch.Process.ServiceName
+	if dataset == "" {
+		dataset = r.Config.GetJaegerDefaultDataset()
+	}
+	if fallbackDataset != "" {
+		dataset = fallbackDataset
+	}
+
+	apiHost := r.Config.GetHoneycombAPIForEnvironment(environment)
+	reqID := req.Context().Value(types.RequestIDContextKey{})
+	timeSources := r.Config.GetEventTimeSources()
+	var rejected int
+	for _, data := range jaegerBatchToEvents(batch) {
+		eventTime, _ := chooseEventTime(timeSources, time.Time{}, data.timestamp)
+		ev := &types.Event{
+			Context:     req.Context(),
+			APIHost:     apiHost,
+			APIKey:      apiKey,
+			Dataset:     dataset,
+			Environment: environment,
+			SampleRate:  1,
+			Timestamp:   eventTime,
+			Data:        data.fields,
+		}
+		// once any span is enqueued, failing the request would have the
+		// client resend the whole batch, so the spans that can't be taken
+		// are dropped and counted instead
+		if err := r.processEvent(ev, reqID); err != nil {
+			rejected++
+		}
+	}
+
+	if rejected > 0 {
+		r.Metrics.Count("incoming_router_jaeger_rejected", rejected)
+		r.iopLogger.Debug().
+			WithString("dataset", dataset).
+			WithField("rejected", rejected).
+			Logf("rejected spans from Jaeger batch")
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type jaegerEvent struct {
+	timestamp time.Time
+	fields    map[string]interface{}
+}
+
+// jaegerBatchToEvents converts the spans in a Jaeger batch into event fields,
+// using the same field names that OTLP spans are given. Each span log becomes
+// a separate span event.
+func jaegerBatchToEvents(batch *jaegerBatch) []jaegerEvent {
+	events := make([]jaegerEvent, 0, len(batch.Spans))
+	for _, sp := range batch.Spans {
+		traceID := jaegerTraceID(sp.TraceIDHigh, sp.TraceIDLow)
+		spanID := jaegerSpanID(sp.SpanID)
+
+		fields := make(map[string]interface{}, len(batch.Process.Tags)+len(sp.Tags)+8)
+		// span tags take precedence over process tags
+		for _, tag := range batch.Process.Tags {
+			fields[tag.Key] = tag.Value
+		}
+		for _, tag := range sp.Tags {
+			fields[tag.Key] = tag.Value
+		}
+		fields["trace.trace_id"] = traceID
+		fields["trace.span_id"] = spanID
+		if parentID := jaegerParentID(sp); parentID != 0 {
+			fields["trace.parent_id"] = jaegerSpanID(parentID)
+		}
+		fields["name"] = sp.OperationName
+		fields["duration_ms"] = float64(sp.Duration) / 1000.0
+		if batch.Process.ServiceName != "" {
+			fields["service.name"] = batch.Process.ServiceName
+		}
+		if len(sp.Logs) > 0 {
+			fields["span.num_events"] = len(sp.Logs)
+		}
+		events = append(events, jaegerEvent{
+			timestamp: time.UnixMicro(sp.StartTime).UTC(),
+			fields:    fields,
+		})
+
+		for _, l := range sp.Logs {
+			logFields := make(map[string]interface{}, len(l.Fields)+6)
+			for _, tag := range l.Fields {
+				logFields[tag.Key] = tag.Value
+			}
+			name, ok := logFields["event"].(string)
+			if !ok {
+				name = "log"
+			}
+			logFields["trace.trace_id"] = traceID
+			logFields["trace.parent_id"] = spanID
+			logFields["name"] = name
+			logFields["parent_name"] = sp.OperationName
+			logFields["meta.annotation_type"] = "span_event"
+			if batch.Process.ServiceName != "" {
+				logFields["service.name"] = batch.Process.ServiceName
+			}
+			events = append(events, jaegerEvent{
+				timestamp: time.UnixMicro(l.Timestamp).UTC(),
+				fields:    logFields,
+			})
+		}
+	}
+	return events
+}
+
+// jaegerParentID returns the parent span ID, falling back to the first
+// CHILD_OF reference for clients that only send references.
+func jaegerParentID(sp jaegerSpan) int64 {
+	if sp.ParentSpanID != 0 {
+		return sp.ParentSpanID
+	}
+	for _, ref := range sp.References {
+		if ref.RefType == jaegerRefChildOf && ref.SpanID != 0 {
+			return ref.SpanID
+		}
+	}
+	return 0
+}
+
+// jaegerTraceID formats a trace ID as 32 hex digits, the same way a 16 byte
+// OTLP trace ID is formatted, so that spans of one trace match no matter
+// which protocol sent them.
+func jaegerTraceID(high, low int64) string {
+	return fmt.Sprintf("%016x%016x", uint64(high), uint64(low))
+}
+
+func jaegerSpanID(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
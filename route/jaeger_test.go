@@ -0,0 +1,295 @@
+package route
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thriftWriter writes just enough of the Thrift binary protocol to build
+// Jaeger batches for tests.
+type thriftWriter struct {
+	bytes.Buffer
+}
+
+func (w *thriftWriter) field(typ byte, id int16) {
+	w.WriteByte(typ)
+	binary.Write(w, binary.BigEndian, id)
+}
+
+func (w *thriftWriter) stop() { w.WriteByte(thriftStop) }
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(thriftI32, id)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(thriftI64, id)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) str(id int16, v string) {
+	w.field(thriftString, id)
+	binary.Write(w, binary.BigEndian, int32(len(v)))
+	w.WriteString(v)
+}
+
+func (w *thriftWriter) list(id int16, elemType byte, n int) {
+	w.field(thriftList, id)
+	w.WriteByte(elemType)
+	binary.Write(w, binary.BigEndian, int32(n))
+}
+
+func (w *thriftWriter) tags(id int16, tags []jaegerTag) {
+	w.list(id, thriftStruct, len(tags))
+	for _, tag := range tags {
+		w.str(1, tag.Key)
+		switch v := tag.Value.(type) {
+		case string:
+			w.i32(2, jaegerTagString)
+			w.str(3, v)
+		case float64:
+			w.i32(2, jaegerTagDouble)
+			w.field(thriftDouble, 4)
+			binary.Write(w, binary.BigEndian, math.Float64bits(v))
+		case bool:
+			w.i32(2, jaegerTagBool)
+			w.field(thriftBool, 5)
+			if v {
+				w.WriteByte(1)
+			} else {
+				w.WriteByte(0)
+			}
+		case int64:
+			w.i32(2, jaegerTagLong)
+			w.i64(6, v)
+		}
+		w.stop()
+	}
+}
+
+func encodeJaegerBatch(batch jaegerBatch) []byte {
+	w := &thriftWriter{}
+	w.field(thriftStruct, 1)
+	w.str(1, batch.Process.ServiceName)
+	w.tags(2, batch.Process.Tags)
+	w.stop()
+
+	w.list(2, thriftStruct, len(batch.Spans))
+	for _, sp := range batch.Spans {
+		w.i64(1, sp.TraceIDLow)
+		w.i64(2, sp.TraceIDHigh)
+		w.i64(3, sp.SpanID)
+		w.i64(4, sp.ParentSpanID)
+		w.str(5, sp.OperationName)
+		w.list(6, thriftStruct, len(sp.References))
+		for _, ref := range sp.References {
+			w.i32(1, ref.RefType)
+			w.i64(2, ref.TraceIDLow)
+			w.i64(3, ref.TraceIDHigh)
+			w.i64(4, ref.SpanID)
+			w.stop()
+		}
+		w.i32(7, sp.Flags)
+		w.i64(8, sp.StartTime)
+		w.i64(9, sp.Duration)
+		w.tags(10, sp.Tags)
+		w.list(11, thriftStruct, len(sp.Logs))
+		for _, l := range sp.Logs {
+			w.i64(1, l.Timestamp)
+			w.tags(2, l.Fields)
+			w.stop()
+		}
+		// a field from the future that should be skipped
+		w.str(99, "ignored")
+		w.stop()
+	}
+	// seqNo, which we don't use
+	w.i64(3, 7)
+	w.stop()
+	return w.Bytes()
+}
+
+func testJaegerBatch() jaegerBatch {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return jaegerBatch{
+		Process: jaegerProcess{
+			ServiceName: "checkout",
+			Tags:        []jaegerTag{{Key: "hostname", Value: "host-1"}, {Key: "region", Value: "us"}},
+		},
+		Spans: []jaegerSpan{
+			{
+				TraceIDHigh:   1,
+				TraceIDLow:    2,
+				SpanID:        3,
+				OperationName: "GET /cart",
+				StartTime:     start.UnixMicro(),
+				Duration:      1500,
+				Tags: []jaegerTag{
+					{Key: "http.status_code", Value: int64(200)},
+					{Key: "error", Value: false},
+					{Key: "ratio", Value: 0.5},
+					{Key: "region", Value: "eu"},
+				},
+				Logs: []jaegerLog{{
+					Timestamp: start.Add(time.Millisecond).UnixMicro(),
+					Fields:    []jaegerTag{{Key: "event", Value: "cache miss"}},
+				}},
+			},
+			{
+				TraceIDHigh:   1,
+				TraceIDLow:    2,
+				SpanID:        4,
+				OperationName: "SELECT",
+				References:    []jaegerSpanRef{{RefType: jaegerRefChildOf, TraceIDHigh: 1, TraceIDLow: 2, SpanID: 3}},
+				StartTime:     start.UnixMicro(),
+				Duration:      500,
+			},
+		},
+	}
+}
+
+func TestDecodeJaegerBatch(t *testing.T) {
+	want := testJaegerBatch()
+	got, err := decodeJaegerBatch(encodeJaegerBatch(want))
+	require.NoError(t, err)
+	assert.Equal(t, want.Process, got.Process)
+	require.Len(t, got.Spans, 2)
+	assert.Equal(t, want.Spans[0], got.Spans[0])
+	assert.Equal(t, want.Spans[1].References, got.Spans[1].References)
+
+	data := encodeJaegerBatch(want)
+	for _, n := range []int{0, 1, 10, len(data) / 2, len(data) - 1} {
+		_, err := decodeJaegerBatch(data[:n])
+		assert.Error(t, err, "truncated to %d bytes", n)
+	}
+}
+
+func TestPostJaegerThrift(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:    []string{"trace.trace_id"},
+		ParentIdFieldNames:   []string{"trace.parent_id"},
+		JaegerDefaultDataset: "unknown_service",
+	})
+
+	req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(testJaegerBatch())))
+	req.Header.Set("Content-Type", "application/x-thrift")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	rr := httptest.NewRecorder()
+	router.postJaegerThrift(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	spans := router.Collector.(*collect.MockCollector).Spans
+	require.Len(t, spans, 3)
+	root := <-spans
+	event := <-spans
+	child := <-spans
+
+	assert.Equal(t, "checkout", root.Dataset)
+	assert.Equal(t, "00000000000000010000000000000002", root.TraceID)
+	assert.True(t, root.IsRoot)
+	assert.Equal(t, "0000000000000003", root.Data["trace.span_id"])
+	assert.Equal(t, "GET /cart", root.Data["name"])
+	assert.Equal(t, 1.5, root.Data["duration_ms"])
+	assert.Equal(t, int64(200), root.Data["http.status_code"])
+	assert.Equal(t, false, root.Data["error"])
+	assert.Equal(t, "eu", root.Data["region"])
+	assert.Equal(t, "host-1", root.Data["hostname"])
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), root.Timestamp)
+
+	assert.Equal(t, "span_event", event.Data["meta.annotation_type"])
+	assert.Equal(t, "cache miss", event.Data["name"])
+	assert.Equal(t, "0000000000000003", event.Data["trace.parent_id"])
+
+	assert.False(t, child.IsRoot)
+	assert.Equal(t, "0000000000000003", child.Data["trace.parent_id"])
+
+	t.Run("default dataset", func(t *testing.T) {
+		batch := testJaegerBatch()
+		batch.Process.ServiceName = ""
+		req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(batch)))
+		req.Header.Set("Content-Type", "application/vnd.apache.thrift.binary")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		rr := httptest.NewRecorder()
+		router.postJaegerThrift(rr, req)
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, "unknown_service", (<-spans).Dataset)
+		}
+	})
+
+	t.Run("bad payload", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader([]byte{thriftStruct, 0}))
+		req.Header.Set("Content-Type", "application/x-thrift")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		rr := httptest.NewRecorder()
+		router.postJaegerThrift(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	for _, ct := range []string{"application/json", ""} {
+		t.Run("bad content type "+ct, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(testJaegerBatch())))
+			if ct != "" {
+				req.Header.Set("Content-Type", ct)
+			}
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			rr := httptest.NewRecorder()
+			router.postJaegerThrift(rr, req)
+			assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+		})
+	}
+
+	t.Run("busy collector", func(t *testing.T) {
+		busyRouter, busyMetrics := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+		busyRouter.Collector = busyCollector{collect.NewMockCollector()}
+		req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(testJaegerBatch())))
+		req.Header.Set("Content-Type", "application/x-thrift")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		rr := httptest.NewRecorder()
+		busyRouter.postJaegerThrift(rr, req)
+		// some of a batch may already be enqueued, so it isn't retried
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		count, _ := busyMetrics.Get("incoming_router_jaeger_rejected")
+		assert.Equal(t, float64(3), count)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		drainingRouter, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+		drainingRouter.draining.Store(true)
+		req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(testJaegerBatch())))
+		req.Header.Set("Content-Type", "application/x-thrift")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		rr := httptest.NewRecorder()
+		drainingRouter.postJaegerThrift(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Empty(t, drainingRouter.Collector.(*collect.MockCollector).Spans)
+	})
+
+	t.Run("rejected spans are counted", func(t *testing.T) {
+		rejectRouter, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:   []string{"trace.trace_id"},
+			MaxSpanAttributes:   1,
+			OversizedSpanAction: "reject",
+		})
+		req := httptest.NewRequest("POST", "/api/traces", bytes.NewReader(encodeJaegerBatch(testJaegerBatch())))
+		req.Header.Set("Content-Type", "application/x-thrift")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		rr := httptest.NewRecorder()
+		rejectRouter.postJaegerThrift(rr, req)
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		count, _ := mockMetrics.Get("incoming_router_jaeger_rejected")
+		assert.Equal(t, float64(3), count)
+	})
+}
@@ -0,0 +1,482 @@
+package route
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file contains a minimal decoder for the Thrift binary protocol,
+// covering just enough to read the jaeger.thrift Batch struct that Jaeger
+// clients POST to /api/traces. Unknown fields are skipped, so newer clients
+// that add fields still decode.
+//
+// https://github.com/jaegertracing/jaeger-idl/blob/main/thrift/jaeger.thrift
+
+// Thrift binary protocol type IDs
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftByte   = 3
+	thriftDouble = 4
+	thriftI16    = 6
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftMap    = 13
+	thriftSet    = 14
+	thriftList   = 15
+)
+
+// maxThriftDepth bounds how deeply nested unknown structs can be, so that a
+// malicious payload can't exhaust the stack while being skipped.
+const maxThriftDepth = 32
+
+var errThriftTruncated = errors.New("thrift: unexpected end of data")
+
+// Jaeger tag value types
+const (
+	jaegerTagString = 0
+	jaegerTagDouble = 1
+	jaegerTagBool   = 2
+	jaegerTagLong   = 3
+	jaegerTagBinary = 4
+)
+
+// Jaeger span reference types
+const (
+	jaegerRefChildOf = 0
+)
+
+type jaegerTag struct {
+	Key   string
+	Value interface{}
+}
+
+type jaegerLog struct {
+	Timestamp int64
+	Fields    []jaegerTag
+}
+
+type jaegerSpanRef struct {
+	RefType     int32
+	TraceIDLow  int64
+	TraceIDHigh int64
+	SpanID      int64
+}
+
+type jaegerSpan struct {
+	TraceIDLow    int64
+	TraceIDHigh   int64
+	SpanID        int64
+	ParentSpanID  int64
+	OperationName string
+	References    []jaegerSpanRef
+	Flags         int32
+	StartTime     int64 // microseconds since the epoch
+	Duration      int64 // microseconds
+	Tags          []jaegerTag
+	Logs          []jaegerLog
+}
+
+type jaegerProcess struct {
+	ServiceName string
+	Tags        []jaegerTag
+}
+
+type jaegerBatch struct {
+	Process jaegerProcess
+	Spans   []jaegerSpan
+}
+
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+// decodeJaegerBatch decodes a Thrift binary encoded jaeger.thrift Batch.
+func decodeJaegerBatch(data []byte) (*jaegerBatch, error) {
+	r := &thriftReader{data: data}
+	batch := &jaegerBatch{}
+	err := r.readStruct(func(id int16, typ byte) (bool, error) {
+		switch {
+		case id == 1 && typ == thriftStruct:
+			return true, r.readProcess(&batch.Process)
+		case id == 2 && typ == thriftList:
+			return true, r.readList(thriftStruct, func() error {
+				var sp jaegerSpan
+				if err := r.readSpan(&sp); err != nil {
+					return err
+				}
+				batch.Spans = append(batch.Spans, sp)
+				return nil
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (r *thriftReader) readProcess(p *jaegerProcess) error {
+	return r.readStruct(func(id int16, typ byte) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typ == thriftString:
+			p.ServiceName, err = r.readString()
+		case id == 2 && typ == thriftList:
+			p.Tags, err = r.readTags()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *thriftReader) readSpan(sp *jaegerSpan) error {
+	return r.readStruct(func(id int16, typ byte) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI64:
+			sp.TraceIDLow, err = r.readI64()
+		case id == 2 && typ == thriftI64:
+			sp.TraceIDHigh, err = r.readI64()
+		case id == 3 && typ == thriftI64:
+			sp.SpanID, err = r.readI64()
+		case id == 4 && typ == thriftI64:
+			sp.ParentSpanID, err = r.readI64()
+		case id == 5 && typ == thriftString:
+			sp.OperationName, err = r.readString()
+		case id == 6 && typ == thriftList:
+			err = r.readList(thriftStruct, func() error {
+				var ref jaegerSpanRef
+				if err := r.readSpanRef(&ref); err != nil {
+					return err
+				}
+				sp.References = append(sp.References, ref)
+				return nil
+			})
+		case id == 7 && typ == thriftI32:
+			sp.Flags, err = r.readI32()
+		case id == 8 && typ == thriftI64:
+			sp.StartTime, err = r.readI64()
+		case id == 9 && typ == thriftI64:
+			sp.Duration, err = r.readI64()
+		case id == 10 && typ == thriftList:
+			sp.Tags, err = r.readTags()
+		case id == 11 && typ == thriftList:
+			err = r.readList(thriftStruct, func() error {
+				var l jaegerLog
+				if err := r.readLog(&l); err != nil {
+					return err
+				}
+				sp.Logs = append(sp.Logs, l)
+				return nil
+			})
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *thriftReader) readSpanRef(ref *jaegerSpanRef) error {
+	return r.readStruct(func(id int16, typ byte) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			ref.RefType, err = r.readI32()
+		case id == 2 && typ == thriftI64:
+			ref.TraceIDLow, err = r.readI64()
+		case id == 3 && typ == thriftI64:
+			ref.TraceIDHigh, err = r.readI64()
+		case id == 4 && typ == thriftI64:
+			ref.SpanID, err = r.readI64()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *thriftReader) readLog(l *jaegerLog) error {
+	return r.readStruct(func(id int16, typ byte) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI64:
+			l.Timestamp, err = r.readI64()
+		case id == 2 && typ == thriftList:
+			l.Fields, err = r.readTags()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *thriftReader) readTags() ([]jaegerTag, error) {
+	var tags []jaegerTag
+	err := r.readList(thriftStruct, func() error {
+		var key string
+		var vType int32
+		values := make(map[int16]interface{})
+		err := r.readStruct(func(id int16, typ byte) (bool, error) {
+			var err error
+			var v interface{}
+			switch {
+			case id == 1 && typ == thriftString:
+				key, err = r.readString()
+				return true, err
+			case id == 2 && typ == thriftI32:
+				vType, err = r.readI32()
+				return true, err
+			case id == 3 && typ == thriftString:
+				v, err = r.readString()
+			case id == 4 && typ == thriftDouble:
+				v, err = r.readDouble()
+			case id == 5 && typ == thriftBool:
+				v, err = r.readBool()
+			case id == 6 && typ == thriftI64:
+				v, err = r.readI64()
+			case id == 7 && typ == thriftString:
+				// binary values are read as strings; they're encoded the same way
+				v, err = r.readString()
+			default:
+				return false, nil
+			}
+			values[id] = v
+			return true, err
+		})
+		if err != nil {
+			return err
+		}
+
+		// the value lives in the field that corresponds to the tag type
+		tag := jaegerTag{Key: key}
+		switch vType {
+		case jaegerTagString:
+			tag.Value = values[3]
+		case jaegerTagDouble:
+			tag.Value = values[4]
+		case jaegerTagBool:
+			tag.Value = values[5]
+		case jaegerTagLong:
+			tag.Value = values[6]
+		case jaegerTagBinary:
+			tag.Value = values[7]
+		}
+		if tag.Value != nil {
+			tags = append(tags, tag)
+		}
+		return nil
+	})
+	return tags, err
+}
+
+// readStruct reads fields until the stop marker, calling fn for each one.
+// fn returns false if it didn't consume the field, in which case it's skipped.
+func (r *thriftReader) readStruct(fn func(id int16, typ byte) (bool, error)) error {
+	for {
+		typ, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		if typ == thriftStop {
+			return nil
+		}
+		id, err := r.readI16()
+		if err != nil {
+			return err
+		}
+		handled, err := fn(id, typ)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			if err := r.skip(typ, 0); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readList reads a list header and calls fn once per element. Elements that
+// aren't of the expected type are skipped.
+func (r *thriftReader) readList(elemType byte, fn func() error) error {
+	typ, size, err := r.readListHeader()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		if typ != elemType {
+			if err := r.skip(typ, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *thriftReader) readListHeader() (byte, int, error) {
+	typ, err := r.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := r.readSize()
+	return typ, size, err
+}
+
+func (r *thriftReader) skip(typ byte, depth int) error {
+	if depth > maxThriftDepth {
+		return errors.New("thrift: maximum nesting depth exceeded")
+	}
+	switch typ {
+	case thriftBool, thriftByte:
+		return r.advance(1)
+	case thriftI16:
+		return r.advance(2)
+	case thriftI32:
+		return r.advance(4)
+	case thriftDouble, thriftI64:
+		return r.advance(8)
+	case thriftString:
+		n, err := r.readSize()
+		if err != nil {
+			return err
+		}
+		return r.advance(n)
+	case thriftStruct:
+		return r.readStruct(func(_ int16, typ byte) (bool, error) {
+			return true, r.skip(typ, depth+1)
+		})
+	case thriftMap:
+		keyType, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		valueType, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		size, err := r.readSize()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := r.skip(keyType, depth+1); err != nil {
+				return err
+			}
+			if err := r.skip(valueType, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftSet, thriftList:
+		elemType, size, err := r.readListHeader()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := r.skip(elemType, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("thrift: unknown type %d", typ)
+	}
+}
+
+func (r *thriftReader) advance(n int) error {
+	if n < 0 || len(r.data)-r.pos < n {
+		return errThriftTruncated
+	}
+	r.pos += n
+	return nil
+}
+
+func (r *thriftReader) next(n int) ([]byte, error) {
+	start := r.pos
+	if err := r.advance(n); err != nil {
+		return nil, err
+	}
+	return r.data[start:r.pos], nil
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *thriftReader) readBool() (bool, error) {
+	b, err := r.readByte()
+	return b != 0, err
+}
+
+func (r *thriftReader) readI16() (int16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(b)), nil
+}
+
+func (r *thriftReader) readI32() (int32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *thriftReader) readI64() (int64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (r *thriftReader) readDouble() (float64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+// readSize reads a length prefix, rejecting sizes that couldn't possibly fit
+// in the rest of the data so that we never allocate based on a bogus length.
+func (r *thriftReader) readSize() (int, error) {
+	n, err := r.readI32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || int(n) > len(r.data)-r.pos {
+		return 0, errThriftTruncated
+	}
+	return int(n), nil
+}
+
+func (r *thriftReader) readString() (string, error) {
+	n, err := r.readSize()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,92 @@
+package route
+
+import (
+	"sync"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+)
+
+// maxTrackedKeyValues bounds the memory used to report how many distinct
+// values SamplerKeyNormalizations folds together.
+const maxTrackedKeyValues = 10_000
+
+// keyNormalizationStats tracks the distinct values seen in normalized fields,
+// before and after normalization, so that the reduction can be reported.
+// Only the first maxTrackedKeyValues distinct raw values are tracked.
+type keyNormalizationStats struct {
+	mut        sync.Mutex
+	raw        map[string]struct{}
+	normalized map[string]struct{}
+}
+
+// normalizeKeyFields applies SamplerKeyNormalizations to ev. The normalized
+// values go in ev.SamplerKeyValues, or replace those in ev.Data if
+// StoreNormalizedValues is set.
+func (r *Router) normalizeKeyFields(ev *types.Event) {
+	entries := r.Config.GetSamplerKeyNormalizations()
+	if len(entries) == 0 {
+		return
+	}
+	store := r.Config.GetStoreNormalizedValues()
+	for _, entry := range entries {
+		n := r.keyNormalization(entry)
+		if n == nil {
+			continue
+		}
+		raw, ok := ev.Data[n.Field].(string)
+		if !ok {
+			continue
+		}
+		normalized := n.Apply(raw)
+		r.recordKeyNormalization(n.Field, raw, normalized)
+		if normalized == raw {
+			continue
+		}
+		r.Metrics.Increment("sampler_key_values_normalized")
+		if store {
+			ev.Data[n.Field] = normalized
+			continue
+		}
+		if ev.SamplerKeyValues == nil {
+			ev.SamplerKeyValues = make(map[string]interface{})
+		}
+		ev.SamplerKeyValues[n.Field] = normalized
+	}
+}
+
+// keyNormalization returns the parsed form of a SamplerKeyNormalizations
+// entry, or nil if it's invalid.
+func (r *Router) keyNormalization(entry string) *config.KeyNormalization {
+	if cached, ok := r.keyNormalizations.Load(entry); ok {
+		return cached.(*config.KeyNormalization)
+	}
+	var normalization *config.KeyNormalization
+	n, err := config.ParseKeyNormalization(entry)
+	if err != nil {
+		r.Logger.Error().WithString("normalization", entry).WithString("error", err.Error()).Logf("invalid SamplerKeyNormalizations entry")
+		// cache it anyway, so we only log once
+	} else {
+		normalization = &n
+	}
+	r.keyNormalizations.Store(entry, normalization)
+	return normalization
+}
+
+func (r *Router) recordKeyNormalization(field, raw, normalized string) {
+	s := &r.keyNormalizationStats
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.raw == nil {
+		s.raw = make(map[string]struct{})
+		s.normalized = make(map[string]struct{})
+	}
+	rawKey := field + "\x00" + raw
+	if _, ok := s.raw[rawKey]; ok || len(s.raw) >= maxTrackedKeyValues {
+		return
+	}
+	s.raw[rawKey] = struct{}{}
+	s.normalized[field+"\x00"+normalized] = struct{}{}
+	r.Metrics.Gauge("sampler_key_distinct_raw_values", len(s.raw))
+	r.Metrics.Gauge("sampler_key_distinct_normalized_values", len(s.normalized))
+}
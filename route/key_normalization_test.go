@@ -0,0 +1,61 @@
+package route
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplerKeyNormalization(t *testing.T) {
+	send := func(t *testing.T, store bool) []*types.Span {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:        []string{"trace.trace_id"},
+			SamplerKeyNormalizations: []string{"http.method=trim,lowercase", "bad", "status=trim"},
+			StoreNormalizedValues:    store,
+		})
+		body := `[{"data":{"trace.trace_id":"a","http.method":" GET ","status":200}},` +
+			`{"data":{"trace.trace_id":"a","http.method":"get"}},` +
+			`{"data":{"trace.trace_id":"a","http.method":"Get"}}]`
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		router.batch(httptest.NewRecorder(), req)
+
+		assert.Equal(t, 2, mockMetrics.CounterIncrements["sampler_key_values_normalized"])
+		v, _ := mockMetrics.Get("sampler_key_distinct_raw_values")
+		assert.Equal(t, float64(3), v)
+		v, _ = mockMetrics.Get("sampler_key_distinct_normalized_values")
+		assert.Equal(t, float64(1), v)
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 3)
+		return []*types.Span{<-spans, <-spans, <-spans}
+	}
+
+	t.Run("for keying only", func(t *testing.T) {
+		spans := send(t, false)
+		assert.Equal(t, " GET ", spans[0].Data["http.method"], "the data sent on is unchanged")
+		for _, sp := range spans {
+			val, ok := sp.SamplerKeyValue("http.method")
+			assert.True(t, ok)
+			assert.Equal(t, "get", val)
+		}
+		val, _ := spans[0].SamplerKeyValue("status")
+		assert.Equal(t, 200.0, val, "only strings are normalized")
+	})
+
+	t.Run("stored", func(t *testing.T) {
+		for _, sp := range send(t, true) {
+			assert.Equal(t, "get", sp.Data["http.method"])
+			assert.Nil(t, sp.SamplerKeyValues)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/refinery/transmit"
+)
+
+// loadReport is what /query/load returns.
+type loadReport struct {
+	// Load is a score from 0, idle, to 100, as busy as it can be.
+	Load              int    `json:"load"`
+	IncomingQueueFill int    `json:"incoming_queue_fill"`
+	StressLevel       uint   `json:"stress_level"`
+	StressRelief      bool   `json:"stress_relief_active"`
+	CircuitBreaker    string `json:"circuit_breaker"`
+	Degraded          bool   `json:"degraded"`
+	DegradedThreshold int    `json:"degraded_threshold,omitempty"`
+}
+
+// load works out how busy this node is. The score is the larger of how full
+// the collector's incoming queue is, as a percentage, and this node's stress
+// level; it's 100 while the upstream circuit breaker isn't closed, because
+// nothing this node accepts can be sent on.
+func (r *Router) load() loadReport {
+	l := r.Collector.Load()
+	report := loadReport{
+		StressLevel:    l.StressLevel,
+		StressRelief:   l.Stressed,
+		CircuitBreaker: transmit.CircuitClosed,
+	}
+	if l.IncomingQueueCapacity > 0 {
+		report.IncomingQueueFill = 100 * l.IncomingQueueLength / l.IncomingQueueCapacity
+	}
+	report.Load = max(report.IncomingQueueFill, int(l.StressLevel))
+	if reporter, ok := r.UpstreamTransmission.(transmit.CircuitBreakerReporter); ok {
+		report.CircuitBreaker = reporter.CircuitBreakerStats().State
+		if report.CircuitBreaker != transmit.CircuitClosed {
+			report.Load = 100
+		}
+	}
+	report.Load = min(report.Load, 100)
+	if threshold := r.Config.GetDegradedLoadThreshold(); threshold > 0 {
+		report.DegradedThreshold = threshold
+		report.Degraded = report.Load >= threshold
+	}
+	return report
+}
+
+// getLoad reports how busy this node is, for load balancers that can weight
+// their routing.
+func (r *Router) getLoad(w http.ResponseWriter, req *http.Request) {
+	r.marshalToFormat(w, r.load(), "json")
+}
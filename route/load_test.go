@@ -0,0 +1,76 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/internal/health"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	coll := router.Collector.(*collect.MockCollector)
+	breaker := &circuitBreakerTransmission{stats: transmit.CircuitBreakerStats{State: transmit.CircuitClosed}}
+	router.UpstreamTransmission = breaker
+
+	assert.Equal(t, 0, router.load().Load)
+
+	coll.CurrentLoad = collect.Load{IncomingQueueLength: 30, IncomingQueueCapacity: 100, StressLevel: 20}
+	assert.Equal(t, 30, router.load().Load, "queue fill is the larger")
+
+	coll.CurrentLoad.StressLevel = 65
+	coll.CurrentLoad.Stressed = true
+	report := router.load()
+	assert.Equal(t, 65, report.Load, "stress level is the larger")
+	assert.Equal(t, 30, report.IncomingQueueFill)
+	assert.True(t, report.StressRelief)
+	assert.False(t, report.Degraded)
+
+	breaker.stats.State = transmit.CircuitOpen
+	assert.Equal(t, 100, router.load().Load, "nothing can be sent on")
+
+	rr := httptest.NewRecorder()
+	router.getLoad(rr, httptest.NewRequest("GET", "/query/load", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, float64(100), got["load"])
+	assert.Equal(t, transmit.CircuitOpen, got["circuit_breaker"])
+}
+
+func TestReadyDegraded(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	h := &health.Health{Clock: clockwork.NewFakeClock()}
+	require.NoError(t, h.Start())
+	defer h.Stop()
+	h.Register("test", time.Second)
+	h.Ready("test", true)
+	router.Health = h
+	coll := router.Collector.(*collect.MockCollector)
+	coll.CurrentLoad = collect.Load{IncomingQueueLength: 90, IncomingQueueCapacity: 100}
+
+	ready := func() string {
+		rr := httptest.NewRecorder()
+		router.ready(rr, httptest.NewRequest("GET", "/ready", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	// without a threshold, it's only ever ready or not
+	assert.JSONEq(t, `{"source":"refinery","ready":"yes"}`, ready())
+
+	router.Config.(*config.MockConfig).DegradedLoadThreshold = 80
+	assert.JSONEq(t, `{"source":"refinery","ready":"degraded","load":90}`, ready())
+
+	coll.CurrentLoad.IncomingQueueLength = 10
+	assert.JSONEq(t, `{"source":"refinery","ready":"yes"}`, ready())
+}
@@ -0,0 +1,64 @@
+package route
+
+import (
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// loggedFieldRegex matches a JSON key and the value that follows it, if that
+// value is a string or some other single value, such as a number.
+var loggedFieldRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)("(?:[^"\\]|\\.)*"|[^\s,:{}\[\]"]+)`)
+
+// logParseFailure logs, at debug level, a request body that couldn't be
+// parsed, cut to MaxLoggedBodyBytes and with its RedactedFields replaced if
+// RedactLoggedBodies is set.
+func (r *Router) logParseFailure(req *http.Request, body []byte, err error) {
+	r.iopLogger.Debug().
+		WithField("request_id", req.Context().Value(types.RequestIDContextKey{})).
+		WithField("error", err.Error()).
+		WithString("request.url", r.redactedURL(req)).
+		WithField("body_size", len(body)).
+		WithString("json_body", r.loggedBody(body)).
+		Logf("error parsing json")
+}
+
+// loggedBody returns as much of body as may be logged.
+func (r *Router) loggedBody(body []byte) string {
+	s := string(body)
+	if r.Config.GetRedactLoggedBodies() {
+		s = redactBody(s, r.Config.GetRedactedFields(), r.Config.GetRedactionPlaceholder())
+	}
+
+	limit := r.Config.GetMaxLoggedBodyBytes()
+	if len(s) <= limit {
+		return s
+	}
+	// don't split a multibyte character
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit] + "..."
+}
+
+// redactBody replaces the values of the fields in body whose names match
+// patterns. The body has already failed to parse, so this works on the text.
+func redactBody(body string, patterns []string, placeholder string) string {
+	if len(patterns) == 0 {
+		return body
+	}
+	if placeholder == "" {
+		placeholder = "REDACTED"
+	}
+	return loggedFieldRegex.ReplaceAllStringFunc(body, func(field string) string {
+		m := loggedFieldRegex.FindStringSubmatch(field)
+		for _, pattern := range patterns {
+			if matchPattern(pattern, m[1]) {
+				return `"` + m[1] + `"` + m[2] + `"` + placeholder + `"`
+			}
+		}
+		return field
+	})
+}
@@ -0,0 +1,60 @@
+package route
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggedBody(t *testing.T) {
+	body := `[{"data":{"user.email":"someone@example.com","user.id":42,"name":"a \"quoted\" name","user.tags":["a","b"]}`
+
+	t.Run("unchanged", func(t *testing.T) {
+		router := &Router{Config: &config.MockConfig{RedactedFields: []string{"user.*"}}}
+		assert.Equal(t, body, router.loggedBody([]byte(body)))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		router := &Router{Config: &config.MockConfig{MaxLoggedBodyBytes: 10}}
+		assert.Equal(t, `[{"data":{...`, router.loggedBody([]byte(body)))
+
+		// a multibyte character at the limit isn't split
+		assert.Equal(t, "aaaaaaaaa...", router.loggedBody([]byte("aaaaaaaaa€€€")))
+
+		long := strings.Repeat("x", 10_000)
+		router = &Router{Config: &config.MockConfig{}}
+		assert.Len(t, router.loggedBody([]byte(long)), 1024+len("..."))
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		router := &Router{Config: &config.MockConfig{
+			RedactedFields:     []string{"user.*"},
+			RedactLoggedBodies: true,
+		}}
+		assert.Equal(t,
+			`[{"data":{"user.email":"REDACTED","user.id":"REDACTED","name":"a \"quoted\" name","user.tags":["a","b"]}`,
+			router.loggedBody([]byte(body)))
+	})
+
+	t.Run("redacted with placeholder", func(t *testing.T) {
+		router := &Router{Config: &config.MockConfig{
+			RedactedFields:       []string{"name"},
+			RedactionPlaceholder: "***",
+			RedactLoggedBodies:   true,
+		}}
+		assert.Equal(t,
+			`[{"data":{"user.email":"someone@example.com","user.id":42,"name":"***","user.tags":["a","b"]}`,
+			router.loggedBody([]byte(body)))
+	})
+
+	t.Run("redacted before truncation", func(t *testing.T) {
+		router := &Router{Config: &config.MockConfig{
+			RedactedFields:     []string{"user.email"},
+			RedactLoggedBodies: true,
+			MaxLoggedBodyBytes: 30,
+		}}
+		assert.Equal(t, `[{"data":{"user.email":"REDACT...`, router.loggedBody([]byte(body)))
+	})
+}
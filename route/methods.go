@@ -0,0 +1,25 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// refuseOtherMethods makes route answer requests with any method but the
+// allowed ones with 405 Method Not Allowed and an Allow header. Without it, a
+// request with the wrong method for one of Refinery's own paths falls through
+// to the proxy and is sent upstream. OPTIONS requests are left for the proxy,
+// because browsers send them to check CORS before using the events APIs.
+func (r *Router) refuseOtherMethods(route *mux.Route, allowed ...string) *mux.Route {
+	allow := strings.Join(allowed, ", ")
+	return route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+		return req.Method != http.MethodOptions && !slices.Contains(allowed, req.Method)
+	}).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", allow)
+		r.handlerReturnWithError(w, ErrMethodNotAllowed, errors.New(req.Method+" "+req.URL.Path+" allows "+allow))
+	})
+}
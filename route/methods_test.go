@@ -0,0 +1,62 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefuseOtherMethods(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	muxxer := mux.NewRouter()
+	router.addEventRoutes(muxxer)
+	router.AddOTLPMuxxer(muxxer)
+	muxxer.HandleFunc("/query/drain", func(w http.ResponseWriter, req *http.Request) {}).Methods("GET", "POST")
+	router.refuseOtherMethods(muxxer.Path("/query/drain"), "GET", "POST")
+	router.refuseOtherMethods(muxxer.PathPrefix("/query/"), "GET")
+	proxied := false
+	muxxer.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, req *http.Request) { proxied = true })
+
+	for _, tt := range []struct {
+		method, path, allow string
+	}{
+		{"GET", "/1/batch/dataset", "POST"},
+		{"PUT", "/1/batch/dataset/", "POST"},
+		{"GET", "/1/events/dataset", "POST"},
+		{"DELETE", "/1/events/dataset/", "POST"},
+		{"GET", "/v1/traces", "POST"},
+		{"GET", "/v1/traces/", "POST"},
+		{"GET", "/v1/logs", "POST"},
+		{"PATCH", "/v1/logs/", "POST"},
+		{"DELETE", "/query/drain", "GET, POST"},
+		{"POST", "/query/config", "GET"},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			proxied = false
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			muxxer.ServeHTTP(w, req)
+
+			assert.False(t, proxied, "the request shouldn't be proxied")
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, tt.allow, w.Header().Get("Allow"))
+		})
+	}
+
+	t.Run("other requests are still proxied", func(t *testing.T) {
+		for _, req := range []*http.Request{
+			httptest.NewRequest("OPTIONS", "/1/batch/dataset", nil),
+			httptest.NewRequest("GET", "/1/markers/dataset", nil),
+			httptest.NewRequest("GET", "/1/batch/dataset/extra", nil),
+		} {
+			proxied = false
+			w := httptest.NewRecorder()
+			muxxer.ServeHTTP(w, req)
+			assert.True(t, proxied, req.Method+" "+req.URL.Path)
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/types"
 )
 
@@ -20,15 +21,23 @@ func init() {
 func (r *Router) queryTokenChecker(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		requiredToken := r.Config.GetQueryAuthToken()
-		if requiredToken == "" {
+		scopedTokens := r.Config.GetQueryAuthTokens()
+		if requiredToken == "" && len(scopedTokens) == 0 {
 			err := fmt.Errorf("/query endpoint is not authorized for use (specify QueryAuthToken in config)")
 			r.handlerReturnWithError(w, ErrAuthNeeded, err)
 			return
 		}
 
 		token := req.Header.Get(types.QueryTokenHeader)
-		if token == requiredToken {
-			// if they're equal (including both blank) we're good
+		if requiredToken != "" && token == requiredToken {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if scope, ok := scopedTokens[token]; ok && token != "" {
+			if err := checkQueryScope(scope, req); err != nil {
+				r.handlerReturnWithError(w, ErrQueryScope, err)
+				return
+			}
 			next.ServeHTTP(w, req)
 			return
 		}
@@ -38,18 +47,36 @@ func (r *Router) queryTokenChecker(next http.Handler) http.Handler {
 	})
 }
 
+// checkQueryScope returns an error unless scope allows req. A restricted scope
+// only allows the endpoints for a single dataset, which have a dataset in
+// their path, and only for the datasets or environments it matches.
+func checkQueryScope(scope config.QueryScope, req *http.Request) error {
+	if scope.Unrestricted() {
+		return nil
+	}
+	dataset, ok := mux.Vars(req)["dataset"]
+	if !ok {
+		return fmt.Errorf("token in %s is limited to the /query endpoints for a single dataset", types.QueryTokenHeader)
+	}
+	for _, patterns := range [][]string{scope.Datasets, scope.Environments} {
+		for _, pattern := range patterns {
+			if matchPattern(pattern, dataset) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("token in %s is not allowed to query %s", types.QueryTokenHeader, dataset)
+}
+
 func (r *Router) apiKeyChecker(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		apiKey := req.Header.Get(types.APIKeyHeader)
-		if apiKey == "" {
-			apiKey = req.Header.Get(types.APIKeyHeaderShort)
-		}
-		if apiKey == "" {
+		apiKey := r.getAPIKey(req)
+		if apiKey == "" && r.Config.GetRejectMissingAPIKey() {
 			err := errors.New("no " + types.APIKeyHeader + " header found from within authing middleware")
-			r.handlerReturnWithError(w, ErrAuthNeeded, err)
+			r.handlerReturnWithError(w, ErrMissingAPIKey, err)
 			return
 		}
-		if r.Config.IsAPIKeyValid(apiKey) {
+		if r.isAPIKeyValid(apiKey) {
 			next.ServeHTTP(w, req)
 			return
 		}
@@ -91,13 +118,34 @@ func (r *Router) requestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		arrivalTime := time.Now()
 		remoteIP := req.RemoteAddr
-		url := req.URL.String()
+		url := r.redactedURL(req)
 		method := req.Method
 		route := mux.CurrentRoute(req)
 
-		// generate a request ID and put it in the context for logging
-		reqID := randStringBytes(8)
+		// use the client's request ID if it sent one, or generate one, and put
+		// it in the context for logging; it's returned so that clients can
+		// match their requests to our logs
+		reqID := req.Header.Get(types.RequestIDHeader)
+		if !validRequestID(reqID) {
+			reqID = randStringBytes(8)
+		}
 		req = req.WithContext(context.WithValue(req.Context(), types.RequestIDContextKey{}, reqID))
+		w.Header().Set(types.RequestIDHeader, reqID)
+
+		// warn if the handler runs too long, without interrupting it; the
+		// timer costs nothing unless it fires
+		if threshold := r.Config.GetSlowRequestThreshold(); threshold > 0 {
+			watchdog := time.AfterFunc(threshold, func() {
+				r.Logger.Warn().WithFields(map[string]interface{}{
+					"request_id": reqID,
+					"method":     method,
+					"path":       req.URL.Path,
+					"elapsed":    time.Since(arrivalTime).String(),
+				}).Logf("request is taking longer than SlowRequestThreshold")
+				r.Metrics.Increment("slow_request")
+			})
+			defer watchdog.Stop()
+		}
 
 		// go ahead and process the request
 		wrapped := statusRecorder{w, 200}
@@ -106,6 +154,10 @@ func (r *Router) requestLogger(next http.Handler) http.Handler {
 		// calculate duration
 		dur := float64(time.Since(arrivalTime)) / float64(time.Millisecond)
 
+		if route != nil {
+			r.responseMetrics.count(route.GetName(), wrapped.status)
+		}
+
 		// log that we did so TODO better formatted http log line
 		r.Logger.Debug().Logf("handled %s request %s %s %s %s %f %d", route.GetName(), reqID, remoteIP, method, url, dur, wrapped.status)
 	})
@@ -124,6 +176,23 @@ func (r *Router) setResponseHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// maxRequestIDLength is the longest client-supplied request ID we'll echo.
+const maxRequestIDLength = 128
+
+// validRequestID reports whether a client-supplied request ID is safe to log
+// and echo: not empty, not too long, and only printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 // randStringBytes makes us a request ID for logging.
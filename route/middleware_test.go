@@ -1,14 +1,21 @@
 package route
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
 	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
 )
 
 type dummyHandler struct{}
@@ -72,3 +79,256 @@ func TestRouter_queryTokenChecker(t *testing.T) {
 		})
 	}
 }
+
+func TestRouter_queryTokenCheckerScopes(t *testing.T) {
+	router := &Router{
+		Logger: &logger.NullLogger{},
+		Config: &config.MockConfig{QueryAuthTokens: map[string]config.QueryScope{
+			"admin":   {},
+			"team-a":  {Datasets: []string{"team-a-*"}},
+			"staging": {Environments: []string{"staging"}},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		dataset string // empty for an endpoint that isn't for a single dataset
+		want    int
+	}{
+		{"unscoped token, dataset", "admin", "team-b-api", http.StatusOK},
+		{"unscoped token, global endpoint", "admin", "", http.StatusOK},
+		{"scoped token, allowed dataset", "team-a", "team-a-api", http.StatusOK},
+		{"scoped token, denied dataset", "team-a", "team-b-api", http.StatusForbidden},
+		{"scoped token, global endpoint", "team-a", "", http.StatusForbidden},
+		{"environment scope, allowed environment", "staging", "staging", http.StatusOK},
+		{"environment scope, denied environment", "staging", "production", http.StatusForbidden},
+		{"unknown token", "team-b", "team-b-api", http.StatusBadRequest},
+		{"no token", "", "team-a-api", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/query/config", nil)
+			if tt.dataset != "" {
+				req = httptest.NewRequest("GET", "/query/rules/json/"+tt.dataset, nil)
+				req = mux.SetURLVars(req, map[string]string{"format": "json", "dataset": tt.dataset})
+			}
+			req.Header.Set(types.QueryTokenHeader, tt.token)
+			rr := httptest.NewRecorder()
+			router.queryTokenChecker(&dummyHandler{}).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.want, rr.Code, rr.Body.String())
+			if tt.want == http.StatusOK {
+				assert.Equal(t, "good", rr.Body.String())
+			} else {
+				assert.NotContains(t, rr.Body.String(), "good")
+			}
+		})
+	}
+
+	t.Run("global token still works", func(t *testing.T) {
+		router.Config.(*config.MockConfig).QueryAuthToken = "global"
+		defer func() { router.Config.(*config.MockConfig).QueryAuthToken = "" }()
+		req := httptest.NewRequest("GET", "/query/config", nil)
+		req.Header.Set(types.QueryTokenHeader, "global")
+		rr := httptest.NewRecorder()
+		router.queryTokenChecker(&dummyHandler{}).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+func TestRouter_apiKeyCheckerQueryParam(t *testing.T) {
+	tests := []struct {
+		name         string
+		queryParam   string
+		url          string
+		header       string
+		allowMissing bool
+		want         int
+	}{
+		{"header", "", "/1/events/ds", "testkey", false, 200},
+		{"wrong key", "", "/1/events/ds", "badkey", false, 400},
+		{"query param disabled", "", "/1/events/ds?api_key=testkey", "", false, 401},
+		{"query param enabled", "api_key", "/1/events/ds?api_key=testkey", "", false, 200},
+		{"query param enabled but missing", "api_key", "/1/events/ds?other=testkey", "", false, 401},
+		{"header wins over query param", "api_key", "/1/events/ds?api_key=badkey", "testkey", false, 200},
+		{"missing key allowed", "", "/1/events/ds", "", true, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{
+				Logger: &logger.NullLogger{},
+				Config: &config.MockConfig{
+					APIKeyQueryParam:   tt.queryParam,
+					AllowMissingAPIKey: tt.allowMissing,
+					IsAPIKeyValidFunc: func(key string) bool {
+						return key == "testkey" || key == ""
+					},
+				},
+			}
+
+			req := httptest.NewRequest("POST", tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set(types.APIKeyHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			handler := router.apiKeyChecker(&dummyHandler{})
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_getAPIKeyAdditionalHeaders(t *testing.T) {
+	router := &Router{
+		Config: &config.MockConfig{
+			APIKeyQueryParam:        "api_key",
+			AdditionalAPIKeyHeaders: []string{"X-Forwarded-Team", "X-Api-Key"},
+		},
+	}
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		want    string
+	}{
+		{"custom header", "/1/events/ds", map[string]string{"X-Api-Key": "custom"}, "custom"},
+		{"custom headers in order", "/1/events/ds", map[string]string{"X-Api-Key": "second", "X-Forwarded-Team": "first"}, "first"},
+		{"standard header wins", "/1/events/ds", map[string]string{types.APIKeyHeader: "standard", "X-Forwarded-Team": "custom"}, "standard"},
+		{"short header wins", "/1/events/ds", map[string]string{types.APIKeyHeaderShort: "short", "X-Forwarded-Team": "custom"}, "short"},
+		{"custom header wins over query param", "/1/events/ds?api_key=param", map[string]string{"X-Api-Key": "custom"}, "custom"},
+		{"query param", "/1/events/ds?api_key=param", nil, "param"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, router.getAPIKey(req))
+		})
+	}
+
+	t.Run("grpc metadata", func(t *testing.T) {
+		md := metadata.Pairs("x-api-key", "second", "x-forwarded-team", "first")
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		assert.Equal(t, "first", router.getAdditionalAPIKeyFromMetadata(ctx))
+
+		ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-other", "key"))
+		assert.Equal(t, "", router.getAdditionalAPIKeyFromMetadata(ctx))
+		assert.Equal(t, "", router.getAdditionalAPIKeyFromMetadata(context.Background()))
+	})
+}
+
+func TestRouter_redactedURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		queryParam string
+		url        string
+		want       string
+	}{
+		{"disabled", "", "/1/events/ds?api_key=testkey", "/1/events/ds?api_key=testkey"},
+		{"no key in url", "api_key", "/1/events/ds?other=1", "/1/events/ds?other=1"},
+		{"key redacted", "api_key", "/1/events/ds?api_key=testkey&other=1", "/1/events/ds?api_key=REDACTED&other=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{
+				Config: &config.MockConfig{APIKeyQueryParam: tt.queryParam},
+			}
+			req := httptest.NewRequest("POST", tt.url, nil)
+			if got := router.redactedURL(req); got != tt.want {
+				t.Errorf("redactedURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_requestLoggerRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		echoed bool
+	}{
+		{"generated", "", false},
+		{"echoed", "client-id-123", true},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"not printable", "bad\tid", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{Logger: &logger.NullLogger{}, Config: &config.MockConfig{}}
+			var seen any
+			muxxer := mux.NewRouter()
+			muxxer.Use(router.requestLogger)
+			muxxer.HandleFunc("/test", func(w http.ResponseWriter, req *http.Request) {
+				seen = req.Context().Value(types.RequestIDContextKey{})
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.header != "" {
+				req.Header.Set(types.RequestIDHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			muxxer.ServeHTTP(rr, req)
+
+			got := rr.Header().Get(types.RequestIDHeader)
+			if got == "" {
+				t.Fatal("no request ID header in response")
+			}
+			if got != seen {
+				t.Errorf("response request ID %q doesn't match the logged one %v", got, seen)
+			}
+			if tt.echoed && got != tt.header {
+				t.Errorf("request ID = %q, want %q", got, tt.header)
+			}
+			if !tt.echoed && got == tt.header {
+				t.Errorf("request ID %q should not have been echoed", got)
+			}
+		})
+	}
+}
+
+func TestRouter_requestLoggerSlowRequest(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	mockLogger := &logger.MockLogger{}
+	router := &Router{
+		Logger:  mockLogger,
+		Metrics: mockMetrics,
+		Config:  &config.MockConfig{SlowRequestThreshold: 10 * time.Millisecond},
+	}
+	muxxer := mux.NewRouter()
+	muxxer.Use(router.requestLogger)
+	muxxer.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		// stay stuck until the watchdog notices
+		assert.Eventually(t, func() bool {
+			v, _ := mockMetrics.Get("slow_request")
+			return v == 1
+		}, time.Second, 5*time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	muxxer.HandleFunc("/fast", func(w http.ResponseWriter, req *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/slow", nil)
+	req.Header.Set(types.RequestIDHeader, "slow-id")
+	rr := httptest.NewRecorder()
+	muxxer.ServeHTTP(rr, req)
+
+	// the request wasn't cancelled
+	assert.Equal(t, "done", rr.Body.String())
+	// the warning comes before the debug line logged when the request ends
+	require.Len(t, mockLogger.Events, 2)
+	fields := mockLogger.Events[0].Fields
+	assert.Equal(t, "slow-id", fields["request_id"])
+	assert.Equal(t, "POST", fields["method"])
+	assert.Equal(t, "/slow", fields["path"])
+
+	muxxer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	time.Sleep(20 * time.Millisecond)
+	v, _ := mockMetrics.Get("slow_request")
+	assert.Equal(t, float64(1), v)
+}
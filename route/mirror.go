@@ -0,0 +1,101 @@
+package route
+
+import (
+	"github.com/honeycombio/refinery/types"
+)
+
+// startMirror starts sending the copies of incoming events queued by
+// mirrorEvent to the mirror transmission, if MirrorAllSpans is set. The queue
+// is bounded so that a slow mirror can't hold up ingestion.
+func (r *Router) startMirror() {
+	if !r.Config.GetMirrorAllSpans() {
+		return
+	}
+	r.mirrorQueue = make(chan *types.Event, r.Config.GetMirrorConfig().QueueSize)
+
+	r.doneWG.Add(1)
+	go func() {
+		defer r.doneWG.Done()
+		for {
+			select {
+			case ev := <-r.mirrorQueue:
+				r.MirrorTransmission.EnqueueEvent(ev)
+			case <-r.donech:
+				// send whatever is already queued before we go
+				for {
+					select {
+					case ev := <-r.mirrorQueue:
+						r.MirrorTransmission.EnqueueEvent(ev)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// mirrorEvent queues a copy of ev for the mirror. The copy has its own Data,
+// so nothing done to ev as it's sampled changes what the mirror receives. If
+// the queue is full, the copy is dropped.
+func (r *Router) mirrorEvent(ev *types.Event) {
+	// self test events are counted when they're sent, so they aren't copied
+	if r.mirrorQueue == nil || ev.SelfTest() != nil {
+		return
+	}
+
+	conf := r.Config.GetMirrorConfig()
+	mirror := &types.Event{
+		Context:     ev.Context,
+		APIHost:     ev.APIHost,
+		APIKey:      ev.APIKey,
+		Dataset:     ev.Dataset,
+		Environment: ev.Environment,
+		SampleRate:  ev.SampleRate,
+		Timestamp:   ev.Timestamp,
+		Data:        copyData(ev.Data),
+	}
+	if conf.APIHost != "" {
+		mirror.APIHost = conf.APIHost
+	}
+	if conf.APIKey != "" {
+		mirror.APIKey = conf.APIKey
+	}
+	if conf.Dataset != "" {
+		mirror.Dataset = conf.Dataset
+	}
+
+	select {
+	case r.mirrorQueue <- mirror:
+		r.Metrics.Increment("incoming_router_mirrored")
+	default:
+		r.Metrics.Increment("incoming_router_mirror_dropped")
+	}
+}
+
+// copyData makes a deep copy of an event's data, including any nested maps
+// and slices.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyData(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, elem := range v {
+			c[i] = copyValue(elem)
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,70 @@
+package route
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorAllSpans(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		MirrorAllSpans:    true,
+		Mirror:            config.MirrorConfig{Dataset: "archive", QueueSize: 1},
+	})
+	mirror := &transmit.MockTransmission{}
+	mirror.Start()
+	router.MirrorTransmission = mirror
+	router.donech = make(chan struct{})
+	router.startMirror()
+	// hold the sender up so that the queue fills
+	mirror.Mux.Lock()
+
+	body := `[{"data":{"trace.trace_id":"abc","nested":{"a":1}}},{"data":{"trace.trace_id":"abc"}},{"data":{"trace.trace_id":"abc"}}]`
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	router.batch(httptest.NewRecorder(), req)
+
+	// everything is still sampled as usual
+	spans := router.Collector.(*collect.MockCollector).Spans
+	require.Len(t, spans, 3)
+	primary := <-spans
+	primary.Data["nested"].(map[string]interface{})["a"] = 2
+	primary.Data["added"] = true
+
+	mirror.Mux.Unlock()
+	close(router.donech)
+	router.doneWG.Wait()
+
+	mirrored := mockMetrics.CounterIncrements["incoming_router_mirrored"]
+	dropped := mockMetrics.CounterIncrements["incoming_router_mirror_dropped"]
+	assert.Equal(t, 3, mirrored+dropped)
+	assert.GreaterOrEqual(t, dropped, 1, "a full queue should drop copies rather than block")
+	require.Len(t, mirror.Events, mirrored)
+
+	first := mirror.Events[0]
+	assert.Equal(t, "archive", first.Dataset)
+	assert.Equal(t, "dataset", primary.Dataset)
+	assert.Equal(t, 1.0, first.Data["nested"].(map[string]interface{})["a"], "changes to the original shouldn't reach the copy")
+	assert.NotContains(t, first.Data, "added")
+}
+
+func TestMirrorDisabled(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+	router.donech = make(chan struct{})
+	router.startMirror()
+	assert.Nil(t, router.mirrorQueue)
+
+	// with no queue, mirroring is a no-op
+	router.mirrorEvent(&types.Event{Data: map[string]interface{}{}})
+}
@@ -0,0 +1,64 @@
+package route
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+func isNDJSONContentType(contentType string) bool {
+	switch contentType {
+	case "application/x-ndjson", "application/ndjson":
+		return true
+	default:
+		return false
+	}
+}
+
+// batchNDJSON handles a batch whose body is newline-delimited JSON, with one
+// batch entry on each line, rather than a JSON array. The lines are decoded
+// and processed one at a time; the response has an entry for each line that
+// isn't blank, in order, and a line that can't be decoded only fails its own
+// entry. If the body can't be read to the end, the request fails, but the
+// events on the lines before the failure have already been processed.
+func (r *Router) batchNDJSON(w http.ResponseWriter, req *http.Request, body io.Reader) {
+	dest, ok := r.batchDestination(w, req)
+	if !ok {
+		return
+	}
+
+	reqID := req.Context().Value(types.RequestIDContextKey{})
+	batchedResponses := make([]*BatchResponse, 0)
+	reader := bufio.NewReader(body)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			r.handlerReturnWithError(w, bodyReadError(err), err)
+			return
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			batchedResponses = append(batchedResponses, r.processNDJSONLine(req, dest, line, lineNum, reqID))
+		}
+		if err != nil {
+			break
+		}
+	}
+	r.Metrics.Count("incoming_router_batch_ndjson_events", int64(len(batchedResponses)))
+	r.writeBatchResponses(w, req, batchedResponses)
+}
+
+func (r *Router) processNDJSONLine(req *http.Request, dest batchDestination, line []byte, lineNum int, reqID interface{}) *BatchResponse {
+	var bev batchedEvent
+	if err := r.unmarshalBody(req, bytes.NewReader(line), &bev); err != nil {
+		r.logParseFailure(req, line, err)
+		resp := newBatchResponse(fmt.Errorf("failed to parse line %d: %w", lineNum, err))
+		return &resp
+	}
+	resp := r.processBatchEvent(dest.event(req, bev), reqID)
+	return &resp
+}
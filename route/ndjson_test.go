@@ -0,0 +1,88 @@
+package route
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchNDJSON(t *testing.T) {
+	const body = `{"data":{"trace.trace_id":"abc"},"samplerate":2}
+{"data":{"trace.trace_id":"def"},"time":"2024-03-01T12:30:00Z"}
+
+{"data":{"trace.trace_id":
+{"data":{"trace.trace_id":"ghi"}}`
+
+	send := func(t *testing.T, router *Router, body io.Reader, encoding string) []BatchResponse {
+		req := httptest.NewRequest("POST", "/1/batch/dataset", body)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		rr := httptest.NewRecorder()
+		router.batch(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var responses []BatchResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+		return responses
+	}
+	check := func(t *testing.T, router *Router, responses []BatchResponse) {
+		require.Len(t, responses, 4, "blank lines are skipped")
+		assert.Equal(t, http.StatusAccepted, responses[0].Status)
+		assert.Equal(t, http.StatusAccepted, responses[1].Status)
+		assert.Equal(t, http.StatusBadRequest, responses[2].Status)
+		assert.Equal(t, BatchCodeInvalidEvent, responses[2].Code)
+		assert.Contains(t, responses[2].Error, "line 4")
+		assert.Equal(t, http.StatusAccepted, responses[3].Status)
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 3)
+		span := <-spans
+		assert.Equal(t, "abc", span.TraceID)
+		assert.Equal(t, "dataset", span.Dataset)
+		assert.Equal(t, uint(2), span.SampleRate)
+		span = <-spans
+		assert.Equal(t, "def", span.TraceID)
+		assert.Equal(t, 2024, span.Timestamp.Year())
+		span = <-spans
+		assert.Equal(t, "ghi", span.TraceID)
+	}
+
+	t.Run("multiple lines", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+		check(t, router, send(t, router, strings.NewReader(body), ""))
+		assert.Equal(t, 4, mockMetrics.CounterIncrements["incoming_router_batch_ndjson_events"])
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		_, err := gz.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		check(t, router, send(t, router, buf, "gzip"))
+	})
+
+	t.Run("trailing newline", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+		responses := send(t, router, strings.NewReader("{\"data\":{\"trace.trace_id\":\"abc\"}}\r\n"), "")
+		require.Len(t, responses, 1)
+		assert.Equal(t, http.StatusAccepted, responses[0].Status)
+	})
+}
@@ -0,0 +1,60 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// receivedByFieldName names the node that first received an event.
+const receivedByFieldName = "meta.refinery.received_by"
+
+// addNodeMetadata tags ev with this node's identifier, if
+// AddNodeMetadataToTrace is on.
+func (r *Router) addNodeMetadata(ev *types.Event) {
+	if r.nodeID == "" || !r.Config.GetAddNodeMetadataToTrace() {
+		return
+	}
+	if ev.Data == nil {
+		ev.Data = make(map[string]interface{})
+	}
+	ev.Data[receivedByFieldName] = r.nodeID
+}
+
+// nodeIdentifier works out how this node identifies itself: the configured
+// identifier if there is one, or else the address of the configured network
+// interface, or else the hostname.
+func (r *Router) nodeIdentifier() (string, error) {
+	if id := r.Config.GetRedisIdentifier(); id != "" {
+		return id, nil
+	}
+	if ifaceName := r.Config.GetIdentifierInterfaceName(); ifaceName != "" {
+		return interfaceAddress(ifaceName, r.Config.GetUseIPV6Identifier())
+	}
+	return os.Hostname()
+}
+
+// interfaceAddress returns the first IPv4 (or, if useIPV6 is set, IPv6)
+// address of the named network interface.
+func interfaceAddress(ifaceName string, useIPV6 bool) (string, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find network interface %s: %w", ifaceName, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses of network interface %s: %w", ifaceName, err)
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if isIPV4 := ipnet.IP.To4() != nil; isIPV4 != useIPV6 {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no matching address found on network interface %s", ifaceName)
+}
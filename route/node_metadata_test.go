@@ -0,0 +1,55 @@
+package route
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddNodeMetadata(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:      []string{"trace.trace_id"},
+			AddNodeMetadataToTrace: enabled,
+		})
+		router.nodeID = "node-1"
+
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"trace.trace_id":"abc"}}]`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		router.batch(httptest.NewRecorder(), req)
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 1)
+		span := <-spans
+		if enabled {
+			assert.Equal(t, "node-1", span.Data[receivedByFieldName])
+		} else {
+			assert.NotContains(t, span.Data, receivedByFieldName)
+		}
+	}
+}
+
+func TestNodeIdentifier(t *testing.T) {
+	router := &Router{Config: &config.MockConfig{RedisIdentifier: "configured", IdentifierInterfaceName: "lo"}}
+	id, err := router.nodeIdentifier()
+	require.NoError(t, err)
+	assert.Equal(t, "configured", id)
+
+	router = &Router{Config: &config.MockConfig{IdentifierInterfaceName: "lo"}}
+	id, err = router.nodeIdentifier()
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", id)
+
+	router = &Router{Config: &config.MockConfig{IdentifierInterfaceName: "no-such-interface"}}
+	_, err = router.nodeIdentifier()
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package route
+
+import (
+	"strings"
+)
+
+// otlpIDsValid reports whether the trace and span IDs that husky put in the
+// attributes of an event translated from OTLP are usable. OTLP trace IDs are
+// 16 bytes and span IDs 8, which husky encodes as 32 or 16 characters (an 8
+// byte trace ID, or one with 8 leading zero bytes, becomes 16); an ID of any
+// other length is malformed, and an ID of all zeroes is invalid by the spec.
+// Log records without a trace ID have neither field, which is fine.
+func otlpIDsValid(attrs map[string]interface{}) bool {
+	if traceID, ok := attrs["trace.trace_id"]; ok {
+		id, _ := traceID.(string)
+		if (len(id) != 16 && len(id) != 32) || strings.Trim(id, "0") == "" {
+			return false
+		}
+	}
+	if spanID, ok := attrs["trace.span_id"]; ok {
+		id, _ := spanID.(string)
+		if len(id) != 16 || strings.Trim(id, "0") == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOTLPIDs applies InvalidOTLPIDAction to an event translated from OTLP
+// whose IDs aren't valid, so that unrelated spans aren't collected together
+// as a trace keyed on a zero or truncated ID. It returns false if the event
+// should be dropped.
+func (r *Router) checkOTLPIDs(attrs map[string]interface{}, action string) bool {
+	if otlpIDsValid(attrs) {
+		return true
+	}
+	r.Metrics.Increment("incoming_router_otlp_invalid_id")
+	switch action {
+	case "drop":
+		r.Metrics.Increment("incoming_router_otlp_invalid_id_dropped")
+		return false
+	case "nontrace":
+		// without a trace ID, it's handled like any other non-trace event
+		delete(attrs, "trace.trace_id")
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package route
+
+import (
+	"context"
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	trace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestOTLPIDsValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]interface{}
+		want  bool
+	}{
+		{"valid", map[string]interface{}{"trace.trace_id": "0102030405060708090a0b0c0d0e0f10", "trace.span_id": "0102030405060708"}, true},
+		{"short trace ID", map[string]interface{}{"trace.trace_id": "0102030405060708", "trace.span_id": "0102030405060708"}, true},
+		{"no IDs", map[string]interface{}{}, true},
+		{"zero trace ID", map[string]interface{}{"trace.trace_id": "0000000000000000", "trace.span_id": "0102030405060708"}, false},
+		{"empty trace ID", map[string]interface{}{"trace.trace_id": "", "trace.span_id": "0102030405060708"}, false},
+		{"truncated trace ID", map[string]interface{}{"trace.trace_id": "0102030405", "trace.span_id": "0102030405060708"}, false},
+		{"zero span ID", map[string]interface{}{"trace.trace_id": "0102030405060708090a0b0c0d0e0f10", "trace.span_id": "0000000000000000"}, false},
+		{"truncated span ID", map[string]interface{}{"trace.trace_id": "0102030405060708090a0b0c0d0e0f10", "trace.span_id": "0102"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, otlpIDsValid(tt.attrs))
+		})
+	}
+}
+
+func TestOTLPInvalidIDs(t *testing.T) {
+	spans := []*trace.Span{
+		{Name: "valid", TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, SpanId: []byte{1, 1, 1, 1, 1, 1, 1, 1}},
+		{Name: "zero trace ID", TraceId: make([]byte, 16), SpanId: []byte{2, 2, 2, 2, 2, 2, 2, 2}},
+		{Name: "zero span ID", TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, SpanId: make([]byte, 8)},
+		{Name: "malformed trace ID", TraceId: []byte{1, 2, 3, 4, 5}, SpanId: []byte{3, 3, 3, 3, 3, 3, 3, 3}},
+	}
+	send := func(t *testing.T, action string) (*Router, *metrics.MockMetrics) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:   []string{"trace.trace_id"},
+			InvalidOTLPIDAction: action,
+		})
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+		req := &collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{
+				ScopeSpans: []*trace.ScopeSpans{{Spans: spans}},
+			}},
+		}
+		_, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), req)
+		require.NoError(t, err)
+		assert.Equal(t, 3, mockMetrics.CounterIncrements["incoming_router_otlp_invalid_id"])
+		return router, mockMetrics
+	}
+	names := func(ch chan *types.Span) []string {
+		var names []string
+		for len(ch) > 0 {
+			names = append(names, (<-ch).Data["name"].(string))
+		}
+		return names
+	}
+
+	t.Run("accept", func(t *testing.T) {
+		router, _ := send(t, "accept")
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, len(spans))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		router, mockMetrics := send(t, "drop")
+		assert.Equal(t, []string{"valid"}, names(router.Collector.(*collect.MockCollector).Spans))
+		assert.Empty(t, router.UpstreamTransmission.(*transmit.MockTransmission).Events)
+		assert.Equal(t, 3, mockMetrics.CounterIncrements["incoming_router_otlp_invalid_id_dropped"])
+	})
+
+	t.Run("nontrace", func(t *testing.T) {
+		router, _ := send(t, "nontrace")
+		assert.Equal(t, []string{"valid"}, names(router.Collector.(*collect.MockCollector).Spans))
+		events := router.UpstreamTransmission.(*transmit.MockTransmission).Events
+		require.Len(t, events, 3)
+		for _, ev := range events {
+			assert.NotContains(t, ev.Data, "trace.trace_id")
+		}
+	})
+}
@@ -15,7 +15,27 @@ import (
 
 func (r *Router) postOTLPLogs(w http.ResponseWriter, req *http.Request) {
 	ri := huskyotlp.GetRequestInfoFromHttpHeaders(req.Header)
+	if ri.ApiKey == "" {
+		// fall back to AdditionalAPIKeyHeaders and APIKeyQueryParam, the
+		// same way apiKeyChecker does
+		ri.ApiKey = r.getAPIKey(req)
+	}
+	normalizeOTLPContentEncoding(req, &ri)
+
+	msgpackRequest := isMsgpackContentType(ri.ContentType)
+	if msgpackRequest {
+		if err := r.translateOTLPMsgpackRequest(req, &ri); err != nil {
+			if isDecoderBusy(err) {
+				setRetryAfter(w, err)
+				r.handleOTLPFailureResponse(w, req, newOTLPError(err))
+			} else {
+				r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusBadRequest})
+			}
+			return
+		}
+	}
 
+	r.applyDefaultOTLPDataset(&ri)
 	if err := ri.ValidateLogsHeaders(); err != nil {
 		if errors.Is(err, huskyotlp.ErrInvalidContentType) {
 			r.handlerReturnWithError(w, ErrInvalidContentType, err)
@@ -25,23 +45,30 @@ func (r *Router) postOTLPLogs(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !r.Config.IsAPIKeyValid(ri.ApiKey) {
+	if !r.isAPIKeyValid(ri.ApiKey) {
 		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: fmt.Sprintf("api key %s not found in list of authorized keys", ri.ApiKey), HTTPStatusCode: http.StatusUnauthorized})
 		return
 	}
 
-	result, err := huskyotlp.TranslateLogsRequestFromReader(req.Context(), req.Body, ri)
+	result, err := r.translateOTLPLogsRequest(req, ri)
 	if err != nil {
-		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusInternalServerError})
+		setRetryAfter(w, err)
+		r.handleOTLPFailureResponse(w, req, newOTLPError(err))
 		return
 	}
 
-	if err := r.processOTLPRequest(req.Context(), result.Batches, ri.ApiKey); err != nil {
-		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusInternalServerError})
+	err = r.processOTLPRequest(req.Context(), result.Batches, ri.ApiKey, r.getEnvironmentOverride(req.Header.Get))
+	var rejectedErr *otlpRejectedError
+	if err != nil && !errors.As(err, &rejectedErr) {
+		r.handleOTLPFailureResponse(w, req, newOTLPError(err))
 		return
 	}
 
-	_ = huskyotlp.WriteOtlpHttpTraceSuccessResponse(w, req)
+	if msgpackRequest {
+		r.writeOTLPMsgpackResponse(w, http.StatusOK, rejectedErr.msgpackResponse("rejectedLogRecords"))
+		return
+	}
+	_ = huskyotlp.WriteOtlpHttpResponse(w, req, http.StatusOK, logsExportResponse(rejectedErr))
 }
 
 type LogsServer struct {
@@ -55,23 +82,48 @@ func NewLogsServer(router *Router) *LogsServer {
 }
 
 func (l *LogsServer) Export(ctx context.Context, req *collectorlogs.ExportLogsServiceRequest) (*collectorlogs.ExportLogsServiceResponse, error) {
+	done, err := l.router.startGRPCExport("logs")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	ri := huskyotlp.GetRequestInfoFromGrpcMetadata(ctx)
+	if ri.ApiKey == "" {
+		ri.ApiKey = l.router.getAdditionalAPIKeyFromMetadata(ctx)
+	}
+	l.router.applyDefaultOTLPDataset(&ri)
 	if err := ri.ValidateLogsHeaders(); err != nil {
 		return nil, huskyotlp.AsGRPCError(err)
 	}
 
-	if !l.router.Config.IsAPIKeyValid(ri.ApiKey) {
+	if !l.router.isAPIKeyValid(ri.ApiKey) {
 		return nil, status.Error(codes.Unauthenticated, fmt.Sprintf("api key %s not found in list of authorized keys", ri.ApiKey))
 	}
 
-	result, err := huskyotlp.TranslateLogsRequest(ctx, req, ri)
+	result, err := l.router.translateOTLPLogsMessage(ctx, req, ri)
 	if err != nil {
 		return nil, huskyotlp.AsGRPCError(err)
 	}
 
-	if err := l.router.processOTLPRequest(ctx, result.Batches, ri.ApiKey); err != nil {
-		return nil, huskyotlp.AsGRPCError(err)
+	err = l.router.processOTLPRequest(ctx, result.Batches, ri.ApiKey, l.router.getEnvironmentOverrideFromMetadata(ctx))
+	var rejectedErr *otlpRejectedError
+	if err != nil && !errors.As(err, &rejectedErr) {
+		return nil, huskyotlp.AsGRPCError(newOTLPError(err))
 	}
 
-	return &collectorlogs.ExportLogsServiceResponse{}, nil
+	return logsExportResponse(rejectedErr), nil
+}
+
+// logsExportResponse is the response to an export request, which reports any
+// rejected log records as a partial success.
+func logsExportResponse(rejectedErr *otlpRejectedError) *collectorlogs.ExportLogsServiceResponse {
+	resp := &collectorlogs.ExportLogsServiceResponse{}
+	if rejectedErr != nil {
+		resp.PartialSuccess = &collectorlogs.ExportLogsPartialSuccess{
+			RejectedLogRecords: int64(rejectedErr.rejected),
+			ErrorMessage:       rejectedErr.Error(),
+		}
+	}
+	return resp
 }
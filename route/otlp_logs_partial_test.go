@@ -0,0 +1,47 @@
+package route
+
+import (
+	"context"
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLogsOTLPPartialSuccessWhenCollectorFull(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+	})
+	router.Collector = busyCollector{collect.NewMockCollector()}
+
+	req := &collectorlogs.ExportLogsServiceRequest{
+		ResourceLogs: []*logs.ResourceLogs{{
+			ScopeLogs: []*logs.ScopeLogs{{
+				LogRecords: []*logs.LogRecord{
+					{
+						TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+						SpanId:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+					},
+					{
+						TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+						SpanId:  []byte{1, 2, 3, 4, 5, 6, 7, 9},
+					},
+					// not part of a trace, so it goes straight upstream
+					{},
+				},
+			}},
+		}},
+	}
+
+	md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+	resp, err := NewLogsServer(router).Export(metadata.NewIncomingContext(context.Background(), md), req)
+	require.NoError(t, err)
+	require.NotNil(t, resp.PartialSuccess)
+	assert.Equal(t, int64(2), resp.PartialSuccess.RejectedLogRecords)
+	assert.Contains(t, resp.PartialSuccess.ErrorMessage, collect.ErrWouldBlock.Error())
+}
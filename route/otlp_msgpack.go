@@ -0,0 +1,115 @@
+package route
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	huskyotlp "github.com/honeycombio/husky/otlp"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// OTLP/HTTP requests can be sent as msgpack, for clients where encoding
+// protobuf is too expensive. The msgpack document has the same structure and
+// field names as the OTLP JSON encoding, except that trace and span IDs may
+// be sent as binary rather than hex strings. We convert it to OTLP JSON so
+// that it's parsed exactly like a JSON request.
+
+// otlpIDFields are the OTLP fields whose binary values are hex encoded in
+// OTLP JSON; any other binary value is base64 encoded.
+var otlpIDFields = map[string]struct{}{
+	"traceId":      {},
+	"spanId":       {},
+	"parentSpanId": {},
+}
+
+// translateOTLPMsgpackRequest replaces the msgpack body of req with its OTLP
+// JSON equivalent, and updates ri to match.
+func (r *Router) translateOTLPMsgpackRequest(req *http.Request, ri *huskyotlp.RequestInfo) error {
+	body, err := r.decompressRequestBody(req)
+	if err != nil {
+		return err
+	}
+	// loose decoding would turn binary IDs into strings
+	var doc any
+	if err := msgpack.NewDecoder(body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse msgpack request body: %w", err)
+	}
+	if _, ok := doc.(map[string]any); !ok {
+		return fmt.Errorf("failed to parse msgpack request body: expected a map, got %T", doc)
+	}
+	converted, err := json.Marshal(otlpMsgpackToJSON("", doc))
+	if err != nil {
+		return fmt.Errorf("failed to convert msgpack request body: %w", err)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(converted))
+	ri.ContentType = "application/json"
+	ri.ContentEncoding = ""
+	req.Header.Del("Content-Encoding")
+	return nil
+}
+
+// otlpMsgpackToJSON converts a decoded msgpack value into one that marshals
+// to OTLP JSON. key is the name of the field holding v, if any.
+func otlpMsgpackToJSON(key string, v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			val[k] = otlpMsgpackToJSON(k, item)
+		}
+		return val
+	case map[any]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			ks := fmt.Sprint(k)
+			m[ks] = otlpMsgpackToJSON(ks, item)
+		}
+		return m
+	case []any:
+		for i, item := range val {
+			val[i] = otlpMsgpackToJSON(key, item)
+		}
+		return val
+	case []byte:
+		if _, ok := otlpIDFields[key]; ok {
+			return hex.EncodeToString(val)
+		}
+		// encoding/json encodes []byte as base64, as OTLP JSON expects
+		return val
+	default:
+		return val
+	}
+}
+
+// writeOTLPMsgpackResponse writes an OTLP/HTTP response to a msgpack request.
+// husky only writes protobuf and JSON responses.
+func (r *Router) writeOTLPMsgpackResponse(w http.ResponseWriter, statusCode int, body map[string]any) {
+	encoded, err := msgpack.Marshal(body)
+	if err != nil {
+		r.Logger.Error().Logf("failed to write otlp msgpack response, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/msgpack")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(encoded)
+}
+
+// msgpackResponse is the body of the response to a msgpack export request,
+// which reports the rejected events under rejectedField, as the JSON
+// encoding does.
+func (e *otlpRejectedError) msgpackResponse(rejectedField string) map[string]any {
+	if e == nil {
+		return map[string]any{}
+	}
+	return map[string]any{
+		"partialSuccess": map[string]any{
+			rejectedField:  e.rejected,
+			"errorMessage": e.Error(),
+		},
+	}
+}
@@ -0,0 +1,151 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	huskyotlp "github.com/honeycombio/husky/otlp"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	resource "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// husky copies every resource attribute onto every event. To keep only the
+// ones in OTLPResourceAttributeAllowlist, we remove the others from the
+// request before husky translates it.
+//
+// We parse OTLP/HTTP request bodies ourselves rather than letting husky read
+// them, so that they're decompressed by decompressRequestBody like every other
+// request body. husky applies its size limit to the compressed body, which
+// cut large compressed exports short so that they failed to parse, and it
+// doesn't use the pool of zstd decoders.
+
+// serviceNameAttribute is always kept, because husky uses it to choose the
+// dataset.
+const serviceNameAttribute = "service.name"
+
+// translateOTLPTraceRequest is huskyotlp.TranslateTraceRequestFromReader with
+// OTLPResourceAttributeAllowlist applied.
+func (r *Router) translateOTLPTraceRequest(req *http.Request, ri huskyotlp.RequestInfo) (*huskyotlp.TranslateOTLPRequestResult, error) {
+	request := &collectortrace.ExportTraceServiceRequest{}
+	if err := r.parseOTLPRequestBody(req, ri, request); err != nil {
+		return nil, err
+	}
+	return r.translateOTLPTraceMessage(req.Context(), request, ri)
+}
+
+// translateOTLPTraceMessage is huskyotlp.TranslateTraceRequest with
+// OTLPResourceAttributeAllowlist applied.
+func (r *Router) translateOTLPTraceMessage(ctx context.Context, request *collectortrace.ExportTraceServiceRequest, ri huskyotlp.RequestInfo) (*huskyotlp.TranslateOTLPRequestResult, error) {
+	if allowlist := r.Config.GetOTLPResourceAttributeAllowlist(); len(allowlist) > 0 {
+		for _, rs := range request.ResourceSpans {
+			filterResourceAttributes(rs.Resource, allowlist)
+		}
+	}
+	return huskyotlp.TranslateTraceRequest(ctx, request, ri)
+}
+
+// translateOTLPLogsRequest is huskyotlp.TranslateLogsRequestFromReader with
+// OTLPResourceAttributeAllowlist applied.
+func (r *Router) translateOTLPLogsRequest(req *http.Request, ri huskyotlp.RequestInfo) (*huskyotlp.TranslateOTLPRequestResult, error) {
+	request := &collectorlogs.ExportLogsServiceRequest{}
+	if err := r.parseOTLPRequestBody(req, ri, request); err != nil {
+		return nil, err
+	}
+	return r.translateOTLPLogsMessage(req.Context(), request, ri)
+}
+
+// translateOTLPLogsMessage is huskyotlp.TranslateLogsRequest with
+// OTLPResourceAttributeAllowlist applied.
+func (r *Router) translateOTLPLogsMessage(ctx context.Context, request *collectorlogs.ExportLogsServiceRequest, ri huskyotlp.RequestInfo) (*huskyotlp.TranslateOTLPRequestResult, error) {
+	if allowlist := r.Config.GetOTLPResourceAttributeAllowlist(); len(allowlist) > 0 {
+		for _, rl := range request.ResourceLogs {
+			filterResourceAttributes(rl.Resource, allowlist)
+		}
+	}
+	return huskyotlp.TranslateLogsRequest(ctx, request, ri)
+}
+
+// parseOTLPRequestBody decodes an OTLP/HTTP request body into msg the same way
+// husky does. Like other request bodies, it may be no larger than
+// MaxRequestBodySize once it's decompressed.
+func (r *Router) parseOTLPRequestBody(req *http.Request, ri huskyotlp.RequestInfo, msg proto.Message) error {
+	defer req.Body.Close()
+
+	body, err := r.decompressRequestBody(req)
+	if isDecoderBusy(err) || errors.Is(err, ErrRequestBodyTooLarge) {
+		return err
+	}
+	if err != nil {
+		return huskyotlp.ErrFailedParseBody
+	}
+	data, err := io.ReadAll(body)
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		return err
+	}
+	if err != nil {
+		return huskyotlp.ErrFailedParseBody
+	}
+
+	if ri.ContentType == "application/json" {
+		err = protojson.Unmarshal(data, msg)
+	} else {
+		err = proto.Unmarshal(data, msg)
+	}
+	if err != nil {
+		return huskyotlp.ErrFailedParseBody
+	}
+	return nil
+}
+
+// normalizeOTLPContentEncoding makes the Content-Encoding of an OTLP/HTTP
+// request one that decompressRequestBody understands. Some clients name the
+// compression in a grpc-encoding header instead, as they would for OTLP/gRPC,
+// and encodings aren't case sensitive.
+func normalizeOTLPContentEncoding(req *http.Request, ri *huskyotlp.RequestInfo) {
+	encoding := req.Header.Get("Content-Encoding")
+	if encoding == "" {
+		encoding = req.Header.Get("grpc-encoding")
+	}
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "identity" {
+		encoding = ""
+	}
+	if encoding == "" {
+		req.Header.Del("Content-Encoding")
+	} else {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	ri.ContentEncoding = encoding
+}
+
+// filterResourceAttributes removes the attributes of res whose names don't
+// match any of the patterns in allowlist.
+func filterResourceAttributes(res *resource.Resource, allowlist []string) {
+	if res == nil {
+		return
+	}
+	kept := res.Attributes[:0]
+	for _, attr := range res.Attributes {
+		if attr.Key == serviceNameAttribute {
+			kept = append(kept, attr)
+			continue
+		}
+		for _, pattern := range allowlist {
+			if matchPattern(pattern, attr.Key) {
+				kept = append(kept, attr)
+				break
+			}
+		}
+	}
+	// don't hold on to the dropped attributes
+	for i := len(kept); i < len(res.Attributes); i++ {
+		res.Attributes[i] = nil
+	}
+	res.Attributes = kept
+}
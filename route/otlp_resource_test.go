@@ -0,0 +1,218 @@
+package route
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+	resource "go.opentelemetry.io/proto/otlp/resource/v1"
+	trace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPResourceAttributeAllowlist(t *testing.T) {
+	stringAttr := func(k, v string) *common.KeyValue {
+		return &common.KeyValue{Key: k, Value: &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: v}}}
+	}
+	newRequest := func() *collectortrace.ExportTraceServiceRequest {
+		return &collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{
+				Resource: &resource.Resource{Attributes: []*common.KeyValue{
+					stringAttr("service.name", "svc"),
+					stringAttr("k8s.pod.name", "pod-1"),
+					stringAttr("k8s.node.name", "node-1"),
+					stringAttr("host.arch", "arm64"),
+				}},
+				ScopeSpans: []*trace.ScopeSpans{{
+					Spans: []*trace.Span{{
+						TraceId:    []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+						SpanId:     []byte{1, 2, 3, 4, 5, 6, 7, 8},
+						Name:       "span",
+						Attributes: []*common.KeyValue{stringAttr("host.name", "span-host")},
+					}},
+				}},
+			}},
+		}
+	}
+	newRouter := func(allowlist []string) *Router {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:              []string{"trace.trace_id"},
+			OTLPResourceAttributeAllowlist: allowlist,
+		})
+		return router
+	}
+	receivedData := func(t *testing.T, router *Router) map[string]interface{} {
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 1)
+		return (<-spans).Data
+	}
+
+	t.Run("empty allowlist keeps everything", func(t *testing.T) {
+		router := newRouter(nil)
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+		_, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), newRequest())
+		require.NoError(t, err)
+
+		data := receivedData(t, router)
+		assert.Equal(t, "pod-1", data["k8s.pod.name"])
+		assert.Equal(t, "node-1", data["k8s.node.name"])
+		assert.Equal(t, "arm64", data["host.arch"])
+	})
+
+	t.Run("gRPC", func(t *testing.T) {
+		router := newRouter([]string{"k8s.pod.*"})
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+		_, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), newRequest())
+		require.NoError(t, err)
+
+		data := receivedData(t, router)
+		assert.Equal(t, "svc", data["service.name"])
+		assert.Equal(t, "pod-1", data["k8s.pod.name"])
+		assert.NotContains(t, data, "k8s.node.name")
+		assert.NotContains(t, data, "host.arch")
+		// span attributes aren't affected
+		assert.Equal(t, "span-host", data["host.name"])
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		router := newRouter([]string{"k8s.*"})
+		body, err := proto.Marshal(newRequest())
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write(body)
+		gz.Close()
+
+		request := httptest.NewRequest("POST", "/v1/traces", buf)
+		request.Header.Set("content-type", "application/protobuf")
+		request.Header.Set("content-encoding", "gzip")
+		request.Header.Set("x-honeycomb-team", legacyAPIKey)
+		request.Header.Set("x-honeycomb-dataset", "dataset")
+		w := httptest.NewRecorder()
+		router.postOTLPTrace(w, request)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		data := receivedData(t, router)
+		assert.Equal(t, "pod-1", data["k8s.pod.name"])
+		assert.Equal(t, "node-1", data["k8s.node.name"])
+		assert.NotContains(t, data, "host.arch")
+	})
+
+	t.Run("HTTP with a bad body", func(t *testing.T) {
+		router := newRouter([]string{"k8s.*"})
+		request := httptest.NewRequest("POST", "/v1/traces", bytes.NewReader([]byte("not protobuf")))
+		request.Header.Set("content-type", "application/protobuf")
+		request.Header.Set("x-honeycomb-team", legacyAPIKey)
+		request.Header.Set("x-honeycomb-dataset", "dataset")
+		w := httptest.NewRecorder()
+		router.postOTLPTrace(w, request)
+		assert.NotEqual(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestOTLPCompressedBodies(t *testing.T) {
+	traceBody, err := proto.Marshal(&collectortrace.ExportTraceServiceRequest{
+		ResourceSpans: []*trace.ResourceSpans{{
+			ScopeSpans: []*trace.ScopeSpans{{Spans: helperOTLPRequestSpansWithStatus()}},
+		}},
+	})
+	require.NoError(t, err)
+	logsBody, err := proto.Marshal(&collectorlogs.ExportLogsServiceRequest{
+		ResourceLogs: []*logs.ResourceLogs{{
+			ScopeLogs: []*logs.ScopeLogs{{LogRecords: createLogsRecords()}},
+		}},
+	})
+	require.NoError(t, err)
+
+	compress := func(t *testing.T, encoding string, body []byte) []byte {
+		buf := &bytes.Buffer{}
+		switch encoding {
+		case "gzip":
+			w := gzip.NewWriter(buf)
+			_, err := w.Write(body)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		case "zstd":
+			w, err := zstd.NewWriter(buf)
+			require.NoError(t, err)
+			_, err = w.Write(body)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+		return buf.Bytes()
+	}
+	post := func(router *Router, path string, body []byte, header, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/protobuf")
+		req.Header.Set(header, encoding)
+		req.Header.Set("x-honeycomb-team", legacyAPIKey)
+		req.Header.Set("x-honeycomb-dataset", "dataset")
+		w := httptest.NewRecorder()
+		if path == "/v1/logs" {
+			router.postOTLPLogs(w, req)
+		} else {
+			router.postOTLPTrace(w, req)
+		}
+		return w
+	}
+
+	for _, tt := range []struct {
+		header, value, encoding string
+	}{
+		{"Content-Encoding", "gzip", "gzip"},
+		{"Content-Encoding", "zstd", "zstd"},
+		{"Content-Encoding", "GZIP", "gzip"},
+		{"grpc-encoding", "gzip", "gzip"},
+		{"grpc-encoding", "zstd", "zstd"},
+	} {
+		t.Run(tt.header+" "+tt.value, func(t *testing.T) {
+			for _, endpoint := range []struct {
+				path   string
+				body   []byte
+				events int
+			}{
+				{"/v1/traces", traceBody, 2},
+				{"/v1/logs", logsBody, 1},
+			} {
+				router, _ := newBatchTestRouter(t, &config.MockConfig{})
+				w := post(router, endpoint.path, compress(t, tt.encoding, endpoint.body), tt.header, tt.value)
+				require.Equal(t, http.StatusOK, w.Code, endpoint.path+": "+w.Body.String())
+				assert.Len(t, router.UpstreamTransmission.(*transmit.MockTransmission).Events, endpoint.events, endpoint.path)
+			}
+		})
+	}
+
+	t.Run("too large once decompressed", func(t *testing.T) {
+		spans := helperOTLPRequestSpansWithStatus()
+		spans[0].Attributes = []*common.KeyValue{{
+			Key:   "big",
+			Value: &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: strings.Repeat("a", 10_000)}},
+		}}
+		body, err := proto.Marshal(&collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{ScopeSpans: []*trace.ScopeSpans{{Spans: spans}}}},
+		})
+		require.NoError(t, err)
+		compressed := compress(t, "gzip", body)
+		require.Less(t, len(compressed), 1024)
+
+		router, _ := newBatchTestRouter(t, &config.MockConfig{MaxRequestBodySize: 1024})
+		w := post(router, "/v1/traces", compressed, "Content-Encoding", "gzip")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Empty(t, router.UpstreamTransmission.(*transmit.MockTransmission).Events)
+	})
+}
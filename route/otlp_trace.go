@@ -15,7 +15,27 @@ import (
 
 func (r *Router) postOTLPTrace(w http.ResponseWriter, req *http.Request) {
 	ri := huskyotlp.GetRequestInfoFromHttpHeaders(req.Header)
+	if ri.ApiKey == "" {
+		// fall back to AdditionalAPIKeyHeaders and APIKeyQueryParam, the
+		// same way apiKeyChecker does
+		ri.ApiKey = r.getAPIKey(req)
+	}
+	normalizeOTLPContentEncoding(req, &ri)
+
+	msgpackRequest := isMsgpackContentType(ri.ContentType)
+	if msgpackRequest {
+		if err := r.translateOTLPMsgpackRequest(req, &ri); err != nil {
+			if isDecoderBusy(err) {
+				setRetryAfter(w, err)
+				r.handleOTLPFailureResponse(w, req, newOTLPError(err))
+			} else {
+				r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusBadRequest})
+			}
+			return
+		}
+	}
 
+	r.applyDefaultOTLPDataset(&ri)
 	if err := ri.ValidateTracesHeaders(); err != nil {
 		if errors.Is(err, huskyotlp.ErrInvalidContentType) {
 			r.handleOTLPFailureResponse(w, req, huskyotlp.ErrInvalidContentType)
@@ -25,23 +45,30 @@ func (r *Router) postOTLPTrace(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !r.Config.IsAPIKeyValid(ri.ApiKey) {
+	if !r.isAPIKeyValid(ri.ApiKey) {
 		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: fmt.Sprintf("api key %s not found in list of authorized keys", ri.ApiKey), HTTPStatusCode: http.StatusUnauthorized})
 		return
 	}
 
-	result, err := huskyotlp.TranslateTraceRequestFromReader(req.Context(), req.Body, ri)
+	result, err := r.translateOTLPTraceRequest(req, ri)
 	if err != nil {
-		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusInternalServerError})
+		setRetryAfter(w, err)
+		r.handleOTLPFailureResponse(w, req, newOTLPError(err))
 		return
 	}
 
-	if err := r.processOTLPRequest(req.Context(), result.Batches, ri.ApiKey); err != nil {
-		r.handleOTLPFailureResponse(w, req, huskyotlp.OTLPError{Message: err.Error(), HTTPStatusCode: http.StatusInternalServerError})
+	err = r.processOTLPRequest(req.Context(), result.Batches, ri.ApiKey, r.getEnvironmentOverride(req.Header.Get))
+	var rejectedErr *otlpRejectedError
+	if err != nil && !errors.As(err, &rejectedErr) {
+		r.handleOTLPFailureResponse(w, req, newOTLPError(err))
 		return
 	}
 
-	_ = huskyotlp.WriteOtlpHttpTraceSuccessResponse(w, req)
+	if msgpackRequest {
+		r.writeOTLPMsgpackResponse(w, http.StatusOK, rejectedErr.msgpackResponse("rejectedSpans"))
+		return
+	}
+	_ = huskyotlp.WriteOtlpHttpResponse(w, req, http.StatusOK, traceExportResponse(rejectedErr))
 }
 
 type TraceServer struct {
@@ -55,23 +82,48 @@ func NewTraceServer(router *Router) *TraceServer {
 }
 
 func (t *TraceServer) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	done, err := t.router.startGRPCExport("traces")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	ri := huskyotlp.GetRequestInfoFromGrpcMetadata(ctx)
+	if ri.ApiKey == "" {
+		ri.ApiKey = t.router.getAdditionalAPIKeyFromMetadata(ctx)
+	}
+	t.router.applyDefaultOTLPDataset(&ri)
 	if err := ri.ValidateTracesHeaders(); err != nil {
 		return nil, huskyotlp.AsGRPCError(err)
 	}
 
-	if !t.router.Config.IsAPIKeyValid(ri.ApiKey) {
+	if !t.router.isAPIKeyValid(ri.ApiKey) {
 		return nil, status.Error(codes.Unauthenticated, fmt.Sprintf("api key %s not found in list of authorized keys", ri.ApiKey))
 	}
 
-	result, err := huskyotlp.TranslateTraceRequest(ctx, req, ri)
+	result, err := t.router.translateOTLPTraceMessage(ctx, req, ri)
 	if err != nil {
 		return nil, huskyotlp.AsGRPCError(err)
 	}
 
-	if err := t.router.processOTLPRequest(ctx, result.Batches, ri.ApiKey); err != nil {
-		return nil, huskyotlp.AsGRPCError(err)
+	err = t.router.processOTLPRequest(ctx, result.Batches, ri.ApiKey, t.router.getEnvironmentOverrideFromMetadata(ctx))
+	var rejectedErr *otlpRejectedError
+	if err != nil && !errors.As(err, &rejectedErr) {
+		return nil, huskyotlp.AsGRPCError(newOTLPError(err))
 	}
 
-	return &collectortrace.ExportTraceServiceResponse{}, nil
+	return traceExportResponse(rejectedErr), nil
+}
+
+// traceExportResponse is the response to an export request, which reports any
+// rejected spans as a partial success.
+func traceExportResponse(rejectedErr *otlpRejectedError) *collectortrace.ExportTraceServiceResponse {
+	resp := &collectortrace.ExportTraceServiceResponse{}
+	if rejectedErr != nil {
+		resp.PartialSuccess = &collectortrace.ExportTracePartialSuccess{
+			RejectedSpans: int64(rejectedErr.rejected),
+			ErrorMessage:  rejectedErr.Error(),
+		}
+	}
+	return resp
 }
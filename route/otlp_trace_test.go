@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -13,13 +14,16 @@ import (
 	"time"
 
 	huskyotlp "github.com/honeycombio/husky/otlp"
+	"github.com/honeycombio/refinery/collect"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
 	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
 	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
 	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 	common "go.opentelemetry.io/proto/otlp/common/v1"
 	resource "go.opentelemetry.io/proto/otlp/resource/v1"
@@ -379,6 +383,75 @@ func TestOTLPHandler(t *testing.T) {
 		mockTransmission.Flush()
 	})
 
+	t.Run("accepts OTLP over HTTP/msgpack", func(t *testing.T) {
+		traceID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		doc := map[string]any{
+			"resourceSpans": []any{map[string]any{
+				"scopeSpans": []any{map[string]any{
+					"spans": []any{map[string]any{
+						"traceId":           traceID,
+						"spanId":            []byte{1, 2, 3, 4, 5, 6, 7, 8},
+						"name":              "msgpack span",
+						"startTimeUnixNano": uint64(1700000000000000000),
+						"endTimeUnixNano":   uint64(1700000001000000000),
+						"attributes": []any{map[string]any{
+							"key":   "payload",
+							"value": map[string]any{"bytesValue": []byte("hi")},
+						}},
+					}},
+				}},
+			}},
+		}
+		body, err := msgpack.Marshal(doc)
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		_, err = gz.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		request, _ := http.NewRequest("POST", "/v1/traces", buf)
+		request.Header = http.Header{}
+		request.Header.Set("content-type", "application/msgpack")
+		request.Header.Set("content-encoding", "gzip")
+		request.Header.Set("x-honeycomb-team", legacyAPIKey)
+		request.Header.Set("x-honeycomb-dataset", "dataset")
+
+		w := httptest.NewRecorder()
+		router.postOTLPTrace(w, request)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+		var resp map[string]any
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Empty(t, resp)
+
+		require.Equal(t, 1, len(mockTransmission.Events))
+		ev := mockTransmission.Events[0]
+		assert.Equal(t, hex.EncodeToString(traceID), ev.Data["trace.trace_id"])
+		assert.Equal(t, "0102030405060708", ev.Data["trace.span_id"])
+		assert.Equal(t, "msgpack span", ev.Data["name"])
+		mockTransmission.Flush()
+	})
+
+	t.Run("rejects invalid OTLP over HTTP/msgpack", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", "/v1/traces", strings.NewReader("not msgpack"))
+		request.Header = http.Header{}
+		request.Header.Set("content-type", "application/msgpack")
+		request.Header.Set("x-honeycomb-team", legacyAPIKey)
+		request.Header.Set("x-honeycomb-dataset", "dataset")
+
+		w := httptest.NewRecorder()
+		router.postOTLPTrace(w, request)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+		var resp map[string]any
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Contains(t, resp["message"], "msgpack")
+
+		assert.Equal(t, 0, len(mockTransmission.Events))
+	})
+
 	t.Run("events created with legacy keys use dataset header", func(t *testing.T) {
 		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "my-dataset"})
 		ctx := metadata.NewIncomingContext(context.Background(), md)
@@ -409,6 +482,34 @@ func TestOTLPHandler(t *testing.T) {
 		mockTransmission.Flush()
 	})
 
+	t.Run("legacy keys with no dataset use DefaultDataset", func(t *testing.T) {
+		mockConfig := router.Config.(*config.MockConfig)
+		defer func() { mockConfig.DefaultDataset = "" }()
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey})
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		req := &collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{
+				ScopeSpans: []*trace.ScopeSpans{{
+					Spans: []*trace.Span{{Name: "my-span"}},
+				}},
+			}},
+		}
+		traceServer := NewTraceServer(router)
+
+		// without it, they're rejected as before
+		_, err := traceServer.Export(ctx, req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, 0, len(mockTransmission.Events))
+
+		mockConfig.DefaultDataset = "catch-all"
+		_, err = traceServer.Export(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(mockTransmission.Events))
+		assert.Equal(t, "catch-all", mockTransmission.Events[0].Dataset)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_default_dataset"])
+		mockTransmission.Flush()
+	})
+
 	t.Run("events created with non-legacy keys lookup and use environment name", func(t *testing.T) {
 		apiKey := "my-api-key"
 		md := metadata.New(map[string]string{"x-honeycomb-team": apiKey})
@@ -488,6 +589,40 @@ func TestOTLPHandler(t *testing.T) {
 		assert.Equal(t, 0, len(mockTransmission.Events))
 		mockTransmission.Flush()
 	})
+
+	t.Run("missing API keys", func(t *testing.T) {
+		mockConfig := router.Config.(*config.MockConfig)
+		mockConfig.IsAPIKeyValidFunc = nil
+		defer func() { mockConfig.AllowMissingAPIKey = false }()
+		req := &collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{
+				ScopeSpans: []*trace.ScopeSpans{{
+					Spans: helperOTLPRequestSpansWithStatus(),
+				}},
+			}},
+		}
+		body, err := protojson.Marshal(req)
+		require.NoError(t, err)
+		noKeyCtx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{}))
+		traceServer := NewTraceServer(router)
+
+		// OTLP can't be translated without a key, so AllowMissingAPIKey
+		// makes no difference
+		for _, allow := range []bool{false, true} {
+			mockConfig.AllowMissingAPIKey = allow
+			_, err = traceServer.Export(noKeyCtx, req)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			assert.Contains(t, err.Error(), huskyotlp.ErrMissingAPIKeyHeader.Message)
+
+			request, _ := http.NewRequest("POST", "/v1/traces", bytes.NewReader(body))
+			request.Header = http.Header{}
+			request.Header.Set("content-type", "application/json")
+			w := httptest.NewRecorder()
+			router.postOTLPTrace(w, request)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		assert.Equal(t, 0, len(mockTransmission.Events))
+	})
 }
 
 func helperOTLPRequestSpansWithoutStatus() []*trace.Span {
@@ -534,3 +669,211 @@ func helperOTLPRequestSpansWithStatus() []*trace.Span {
 		},
 	}
 }
+
+func TestOTLPRejectedWhileDraining(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+	router.draining.Store(true)
+
+	req := &collectortrace.ExportTraceServiceRequest{
+		ResourceSpans: []*trace.ResourceSpans{{
+			ScopeSpans: []*trace.ScopeSpans{{
+				Spans: helperOTLPRequestSpansWithStatus(),
+			}},
+		}},
+	}
+
+	md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	_, err := NewTraceServer(router).Export(ctx, req)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	body, err := proto.Marshal(req)
+	require.NoError(t, err)
+	request, _ := http.NewRequest("POST", "/v1/traces", bytes.NewReader(body))
+	request.Header.Set("content-type", "application/protobuf")
+	request.Header.Set("x-honeycomb-team", legacyAPIKey)
+	request.Header.Set("x-honeycomb-dataset", "dataset")
+	w := httptest.NewRecorder()
+	router.postOTLPTrace(w, request)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// drainingCollector starts the router draining once it has taken a span.
+type drainingCollector struct {
+	*collect.MockCollector
+	router *Router
+}
+
+func (c drainingCollector) AddSpan(sp *types.Span) error {
+	err := c.MockCollector.AddSpan(sp)
+	c.router.draining.Store(true)
+	return err
+}
+
+func TestOTLPDrainingPartwayThroughRequest(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+	mockCollector := collect.NewMockCollector()
+	router.Collector = drainingCollector{mockCollector, router}
+
+	spans := make([]*trace.Span, 3)
+	for i := range spans {
+		spans[i] = &trace.Span{
+			TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanId:  []byte{1, 2, 3, 4, 5, 6, 7, byte(i)},
+			Name:    "span",
+		}
+	}
+	req := &collectortrace.ExportTraceServiceRequest{
+		ResourceSpans: []*trace.ResourceSpans{{
+			ScopeSpans: []*trace.ScopeSpans{{Spans: spans}},
+		}},
+	}
+
+	// the span that was enqueued mustn't be resent, so the request succeeds
+	// and reports the rest as rejected
+	md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+	resp, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), req)
+	require.NoError(t, err)
+	require.NotNil(t, resp.PartialSuccess)
+	assert.Equal(t, int64(2), resp.PartialSuccess.RejectedSpans)
+	assert.Contains(t, resp.PartialSuccess.ErrorMessage, ErrDraining.Error())
+	assert.Len(t, mockCollector.Spans, 1)
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_otlp_rejected"])
+}
+
+func TestNewOTLPError(t *testing.T) {
+	tests := []struct {
+		err      error
+		httpCode int
+		grpcCode codes.Code
+	}{
+		{collect.ErrWouldBlock, http.StatusTooManyRequests, codes.ResourceExhausted},
+		{ErrDraining, http.StatusServiceUnavailable, codes.Unavailable},
+		{fmt.Errorf("%w: 10 fields", ErrSpanTooLarge), http.StatusRequestEntityTooLarge, codes.InvalidArgument},
+		{errors.New("something else"), http.StatusInternalServerError, codes.Internal},
+	}
+	for _, tt := range tests {
+		otlpErr := newOTLPError(tt.err)
+		assert.Equal(t, tt.httpCode, otlpErr.HTTPStatusCode, tt.err.Error())
+		assert.Equal(t, tt.grpcCode, otlpErr.GRPCStatusCode, tt.err.Error())
+	}
+}
+
+func TestOTLPMaxEventsPerRequest(t *testing.T) {
+	newRequest := func() *collectortrace.ExportTraceServiceRequest {
+		spans := make([]*trace.Span, 5)
+		for i := range spans {
+			spans[i] = &trace.Span{
+				TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+				SpanId:  []byte{1, 2, 3, 4, 5, 6, 7, byte(i)},
+				Name:    "span",
+			}
+		}
+		return &collectortrace.ExportTraceServiceRequest{
+			ResourceSpans: []*trace.ResourceSpans{{
+				ScopeSpans: []*trace.ScopeSpans{{Spans: spans[:3]}, {Spans: spans[3:]}},
+			}},
+		}
+	}
+	newRouter := func() (*Router, *metrics.MockMetrics) {
+		return newBatchTestRouter(t, &config.MockConfig{
+			TraceIdFieldNames:       []string{"trace.trace_id"},
+			MaxOTLPEventsPerRequest: 2,
+		})
+	}
+
+	t.Run("gRPC", func(t *testing.T) {
+		router, mockMetrics := newRouter()
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+		resp, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), newRequest())
+		require.NoError(t, err)
+
+		require.NotNil(t, resp.PartialSuccess)
+		assert.Equal(t, int64(3), resp.PartialSuccess.RejectedSpans)
+		assert.NotEmpty(t, resp.PartialSuccess.ErrorMessage)
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 2)
+		assert.Equal(t, 3, mockMetrics.CounterIncrements["incoming_router_otlp_over_limit"])
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		router, _ := newRouter()
+		body, err := protojson.Marshal(newRequest())
+		require.NoError(t, err)
+
+		request := httptest.NewRequest("POST", "/v1/traces", bytes.NewReader(body))
+		request.Header.Set("content-type", "application/json")
+		request.Header.Set("x-honeycomb-team", legacyAPIKey)
+		request.Header.Set("x-honeycomb-dataset", "dataset")
+		w := httptest.NewRecorder()
+		router.postOTLPTrace(w, request)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		resp := &collectortrace.ExportTraceServiceResponse{}
+		require.NoError(t, protojson.Unmarshal(w.Body.Bytes(), resp))
+		require.NotNil(t, resp.PartialSuccess)
+		assert.Equal(t, int64(3), resp.PartialSuccess.RejectedSpans)
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 2)
+	})
+
+	t.Run("under the limit", func(t *testing.T) {
+		router, _ := newRouter()
+		router.Config.(*config.MockConfig).MaxOTLPEventsPerRequest = 5
+		md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+		resp, err := NewTraceServer(router).Export(metadata.NewIncomingContext(context.Background(), md), newRequest())
+		require.NoError(t, err)
+		assert.Nil(t, resp.PartialSuccess)
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 5)
+	})
+}
+
+func TestOTLPRootDetection(t *testing.T) {
+	// the parent ID field names are for other sources; OTLP's own parent span
+	// ID decides which spans are roots
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:  []string{"trace.trace_id"},
+		ParentIdFieldNames: []string{"parentId"},
+	})
+	md := metadata.New(map[string]string{"x-honeycomb-team": legacyAPIKey, "x-honeycomb-dataset": "ds"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	traceID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	spans := []*trace.Span{
+		{Name: "no parent", TraceId: traceID, SpanId: []byte{1, 1, 1, 1, 1, 1, 1, 1}},
+		{Name: "empty parent", TraceId: traceID, SpanId: []byte{2, 2, 2, 2, 2, 2, 2, 2}, ParentSpanId: []byte{}},
+		{Name: "zero parent", TraceId: traceID, SpanId: []byte{3, 3, 3, 3, 3, 3, 3, 3}, ParentSpanId: make([]byte, 8)},
+		{Name: "child", TraceId: traceID, SpanId: []byte{4, 4, 4, 4, 4, 4, 4, 4}, ParentSpanId: []byte{1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	req := &collectortrace.ExportTraceServiceRequest{
+		ResourceSpans: []*trace.ResourceSpans{{
+			ScopeSpans: []*trace.ScopeSpans{{Spans: spans}},
+		}},
+	}
+	_, err := NewTraceServer(router).Export(ctx, req)
+	require.NoError(t, err)
+
+	received := router.Collector.(*collect.MockCollector).Spans
+	require.Len(t, received, len(spans))
+	roots := map[string]bool{}
+	for range spans {
+		sp := <-received
+		roots[sp.Data["name"].(string)] = sp.IsRoot
+		if sp.Data["name"] == "empty parent" {
+			assert.NotContains(t, sp.Data, "trace.parent_id", "an empty parent ID shouldn't be sent on")
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"no parent":    true,
+		"empty parent": true,
+		"zero parent":  true,
+		"child":        false,
+	}, roots)
+}
+
+func TestOTLPSpanIsRoot(t *testing.T) {
+	assert.True(t, otlpSpanIsRoot(map[string]interface{}{}))
+	assert.True(t, otlpSpanIsRoot(map[string]interface{}{"trace.parent_id": ""}))
+	assert.True(t, otlpSpanIsRoot(map[string]interface{}{"trace.parent_id": "0000000000000000"}))
+	assert.False(t, otlpSpanIsRoot(map[string]interface{}{"trace.parent_id": "0102030405060708"}))
+	// a span attribute that replaced the parent ID with something else
+	assert.False(t, otlpSpanIsRoot(map[string]interface{}{"trace.parent_id": 12}))
+}
@@ -0,0 +1,40 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+)
+
+// peersReport is what /query/peers returns. There's no hash ring to describe:
+// peers don't divide traces between them, so each node samples the traces it
+// receives, and the peer count only divides the throughput targets of the
+// samplers that have them.
+type peersReport struct {
+	HostID                  string   `json:"host_id"`
+	NodeID                  string   `json:"node_id,omitempty"`
+	RedisIdentifier         string   `json:"redis_identifier,omitempty"`
+	IdentifierInterfaceName string   `json:"identifier_interface_name,omitempty"`
+	PeerManagement          string   `json:"peer_management"`
+	Peers                   []string `json:"peers"`
+	// PeerCount is the count the samplers use, which MaxPeers can limit, so
+	// it may be less than the number of Peers.
+	PeerCount int `json:"peer_count"`
+}
+
+// getPeers reports the peers this node can see, and how it identifies itself,
+// for diagnosing nodes that disagree about the size of the cluster.
+func (r *Router) getPeers(w http.ResponseWriter, req *http.Request) {
+	if r.Peers == nil {
+		r.handlerReturnWithError(w, ErrNotReadyRequest, errors.New("peer list isn't available"))
+		return
+	}
+	r.marshalToFormat(w, peersReport{
+		HostID:                  r.Peers.HostID(),
+		NodeID:                  r.nodeID,
+		RedisIdentifier:         r.Config.GetRedisIdentifier(),
+		IdentifierInterfaceName: r.Config.GetIdentifierInterfaceName(),
+		PeerManagement:          r.Config.GetPeerManagementType(),
+		Peers:                   r.Peers.GetPeers(),
+		PeerCount:               r.Peers.GetPeerCount(),
+	}, "json")
+}
@@ -0,0 +1,61 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/internal/gossip"
+	"github.com/honeycombio/refinery/internal/peer"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPeers(t *testing.T) {
+	conf := &config.MockConfig{
+		RedisIdentifier:    "10.0.0.1",
+		PeerManagementType: "redis",
+	}
+	channel := &gossip.InMemoryGossip{}
+	require.NoError(t, channel.Start())
+	defer channel.Stop()
+	peers := &peer.MockPeerStore{
+		PeerStore: peer.PeerStore{
+			Gossip: channel,
+			Clock:  clockwork.NewFakeClock(),
+			Config: conf,
+		},
+		Identification: "local",
+		PeerCount:      1,
+	}
+	require.NoError(t, peers.Start())
+	defer peers.Stop()
+
+	router, _ := newBatchTestRouter(t, conf)
+	router.Peers = peers
+	router.nodeID = "10.0.0.1"
+
+	rr := httptest.NewRecorder()
+	router.getPeers(rr, httptest.NewRequest("GET", "/query/peers", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got peersReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, peersReport{
+		HostID:          "local",
+		NodeID:          "10.0.0.1",
+		RedisIdentifier: "10.0.0.1",
+		PeerManagement:  "redis",
+		Peers:           []string{"local"},
+		PeerCount:       1,
+	}, got)
+
+	t.Run("before the peer store is injected", func(t *testing.T) {
+		router.Peers = nil
+		rr := httptest.NewRecorder()
+		router.getPeers(rr, httptest.NewRequest("GET", "/query/peers", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+}
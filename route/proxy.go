@@ -9,8 +9,17 @@ import (
 
 // proxy will pass the request through to Honeycomb unchanged and relay the
 // response, blocking until it gets one. This is used for all non-event traffic
-// (eg team api key verification, markers, etc.)
+// (eg team api key verification, markers, etc.), unless ProxyUnmatchedRequests
+// is false, in which case it returns a 404.
 func (r *Router) proxy(w http.ResponseWriter, req *http.Request) {
+	if !r.Config.GetProxyUnmatchedRequests() {
+		r.Metrics.Increment("incoming_router_unmatched")
+		r.Logger.Debug().Logf("not proxying request for unknown path %s", req.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "error": "not found"}, "json")
+		return
+	}
+
 	r.Metrics.Increment("incoming_router_proxied")
 	r.Logger.Debug().Logf("proxying request for %s", req.URL.Path)
 	upstreamTarget := r.Config.GetHoneycombAPI()
@@ -0,0 +1,46 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyUnmatchedRequests(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamCalls.Add(1)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"upstream":true}`))
+	}))
+	defer upstream.Close()
+
+	for _, proxyUnmatched := range []bool{true, false} {
+		upstreamCalls.Store(0)
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+			GetHoneycombAPIVal:            upstream.URL,
+			DisableProxyUnmatchedRequests: !proxyUnmatched,
+		})
+		router.proxyClient = upstream.Client()
+
+		rr := httptest.NewRecorder()
+		router.proxy(rr, httptest.NewRequest("GET", "/1/auth", nil))
+
+		if proxyUnmatched {
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, `{"upstream":true}`, rr.Body.String())
+			assert.Equal(t, int32(1), upstreamCalls.Load())
+			assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_proxied"])
+		} else {
+			require.Equal(t, http.StatusNotFound, rr.Code)
+			assert.JSONEq(t, `{"source":"refinery","error":"not found"}`, rr.Body.String())
+			assert.Equal(t, int32(0), upstreamCalls.Load())
+			assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_unmatched"])
+		}
+	}
+}
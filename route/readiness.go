@@ -0,0 +1,32 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrNotReady is returned for events that arrive before the router has
+// everything it needs to handle them, such as while it's still being started.
+var ErrNotReady = errors.New("refinery is not ready to accept data yet")
+
+// dependenciesReady reports whether the router has been given everything that
+// handling a request depends on. Requests can arrive between the listeners
+// starting and the rest of refinery being wired up; without this they'd panic
+// instead of being told to retry. Spans need the collector too, which
+// processEvent checks for itself, so that events that aren't part of a trace
+// can still be sent on without it.
+func (r *Router) dependenciesReady() bool {
+	return r.Config != nil && r.UpstreamTransmission != nil
+}
+
+// readinessChecker answers requests for the data endpoints with a 503 until
+// the router's dependencies are in place.
+func (r *Router) readinessChecker(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.dependenciesReady() {
+			r.handlerReturnWithError(w, ErrNotReadyRequest, ErrNotReady)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
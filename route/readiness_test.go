@@ -0,0 +1,74 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNotReadyBeforeInjection(t *testing.T) {
+	// nothing has been injected yet
+	router := &Router{}
+	handled := false
+	handler := router.readinessChecker(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handled = true
+	}))
+
+	for _, path := range []string{"/1/events/dataset", "/1/batch/dataset", "/v1/traces"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest("POST", path, bytes.NewReader([]byte(`{}`)))
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.False(t, handled)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+			assert.Contains(t, w.Body.String(), "not ready")
+		})
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ready(w, httptest.NewRequest("GET", "/ready", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"source":"refinery","ready":"no"}`, w.Body.String())
+	})
+
+	t.Run("events", func(t *testing.T) {
+		err := router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{"trace.trace_id": "abc"}}, nil)
+		require.ErrorIs(t, err, ErrNotReady)
+		assert.Equal(t, BatchResponse{Status: http.StatusServiceUnavailable, Code: BatchCodeNotReady, Error: err.Error()}, newBatchResponse(err))
+		assert.Equal(t, codes.Unavailable, newOTLPError(err).GRPCStatusCode)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		_, err := router.startGRPCExport("traces")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestSpansNotReadyWithoutCollector(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+	router.Collector = nil
+	require.True(t, router.dependenciesReady())
+
+	err := router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{"trace.trace_id": "abc"}}, nil)
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	// events that aren't part of a trace don't need the collector
+	require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: map[string]interface{}{"message": "hi"}}, nil))
+	assert.Len(t, router.UpstreamTransmission.(*transmit.MockTransmission).Events, 1)
+
+	w := httptest.NewRecorder()
+	router.ready(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
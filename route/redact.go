@@ -0,0 +1,38 @@
+package route
+
+import (
+	"github.com/honeycombio/refinery/types"
+)
+
+// redactFields removes the fields that match RedactedFields from an event, or
+// replaces their values with RedactionPlaceholder if it's set. The trace and
+// parent ID fields are never redacted, so that the trace still assembles.
+func (r *Router) redactFields(ev *types.Event) {
+	patterns := r.Config.GetRedactedFields()
+	if len(patterns) == 0 {
+		return
+	}
+
+	protected := r.idFields().traceAndParent
+	placeholder := r.Config.GetRedactionPlaceholder()
+	redacted := 0
+	for k := range ev.Data {
+		if _, ok := protected[k]; ok {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matchPattern(pattern, k) {
+				if placeholder == "" {
+					delete(ev.Data, k)
+				} else {
+					ev.Data[k] = placeholder
+				}
+				redacted++
+				break
+			}
+		}
+	}
+	if redacted > 0 {
+		r.Metrics.Count("incoming_router_redacted_fields", redacted)
+	}
+}
@@ -0,0 +1,70 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactFields(t *testing.T) {
+	newConf := func() *config.MockConfig {
+		return &config.MockConfig{
+			TraceIdFieldNames:  []string{"trace.trace_id"},
+			ParentIdFieldNames: []string{"trace.parent_id"},
+			RedactedFields:     []string{"http.request.header.authorization", "user.*", "trace.*"},
+		}
+	}
+	makeData := func() map[string]interface{} {
+		return map[string]interface{}{
+			"trace.trace_id":                    "trace",
+			"trace.parent_id":                   "parent",
+			"trace.span_id":                     "span",
+			"http.request.header.authorization": "Bearer secret",
+			"user.email":                        "someone@example.com",
+			"user.id":                           42,
+			"name":                              "span name",
+		}
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, newConf())
+		ev := &types.Event{Data: makeData()}
+		router.redactFields(ev)
+
+		assert.Equal(t, map[string]interface{}{
+			"trace.trace_id":  "trace",
+			"trace.parent_id": "parent",
+			"name":            "span name",
+		}, ev.Data)
+		assert.Equal(t, 4, mockMetrics.CounterIncrements["incoming_router_redacted_fields"])
+	})
+
+	t.Run("placeholder", func(t *testing.T) {
+		conf := newConf()
+		conf.RedactionPlaceholder = "REDACTED"
+		router, _ := newBatchTestRouter(t, conf)
+		ev := &types.Event{Data: makeData()}
+		router.redactFields(ev)
+
+		assert.Equal(t, "REDACTED", ev.Data["http.request.header.authorization"])
+		assert.Equal(t, "REDACTED", ev.Data["user.email"])
+		assert.Equal(t, "REDACTED", ev.Data["user.id"])
+		assert.Equal(t, "REDACTED", ev.Data["trace.span_id"])
+		assert.Equal(t, "trace", ev.Data["trace.trace_id"])
+		assert.Equal(t, "span name", ev.Data["name"])
+	})
+
+	t.Run("before the collector sees the span", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		require.NoError(t, router.processEvent(&types.Event{Dataset: "dataset", Data: makeData()}, nil))
+
+		span := <-router.Collector.(*collect.MockCollector).Spans
+		assert.Equal(t, "trace", span.TraceID)
+		assert.NotContains(t, span.Data, "http.request.header.authorization")
+		assert.NotContains(t, span.Data, "user.email")
+	})
+}
@@ -0,0 +1,112 @@
+package route
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/metrics"
+)
+
+// statusClasses are the buckets that responses are counted in. 429 is split
+// out of 4xx because it means we shed load, not that the client erred.
+var statusClasses = []string{"2xx", "3xx", "4xx", "429", "5xx"}
+
+// responseMetrics counts responses by route and status class, as
+// incoming_router_response_<route>_<class>. The metrics backends have no
+// notion of labels, so each pair gets its own metric name; only the routes
+// registered with registerRoutes are counted, which keeps that bounded.
+type responseMetrics struct {
+	metrics metrics.Metrics
+
+	mut    sync.RWMutex
+	labels map[string]string
+}
+
+func newResponseMetrics(m metrics.Metrics) *responseMetrics {
+	return &responseMetrics{
+		metrics: m,
+		labels:  make(map[string]string),
+	}
+}
+
+// registerRoutes registers the response counters for every named route in
+// muxxer, including those in its subrouters. Like count, it does nothing on a
+// nil responseMetrics.
+func (rm *responseMetrics) registerRoutes(muxxer *mux.Router) {
+	if rm == nil {
+		return
+	}
+	rm.mut.Lock()
+	defer rm.mut.Unlock()
+	muxxer.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		if name == "" {
+			return nil
+		}
+		if _, ok := rm.labels[name]; ok {
+			return nil
+		}
+		label := routeMetricLabel(name)
+		rm.labels[name] = label
+		for _, class := range statusClasses {
+			rm.metrics.Register("incoming_router_response_"+label+"_"+class, "counter")
+		}
+		return nil
+	})
+}
+
+// count records a response with the given status for the named route.
+// Responses for routes that weren't registered aren't counted.
+func (rm *responseMetrics) count(routeName string, status int) {
+	if rm == nil {
+		return
+	}
+	rm.mut.RLock()
+	label, ok := rm.labels[routeName]
+	rm.mut.RUnlock()
+	if !ok {
+		return
+	}
+	rm.metrics.Increment("incoming_router_response_" + label + "_" + statusClass(status))
+}
+
+func statusClass(status int) string {
+	switch {
+	case status == 429:
+		return "429"
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
+
+// routeMetricLabel turns a route name like "get configuration metadata" into
+// something usable in a metric name: lowercase, with each run of other
+// characters replaced by a single underscore.
+func routeMetricLabel(name string) string {
+	var b strings.Builder
+	underscore := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+			underscore = false
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte(c - 'A' + 'a')
+			underscore = false
+		default:
+			if !underscore && b.Len() > 0 {
+				b.WriteByte('_')
+				underscore = true
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
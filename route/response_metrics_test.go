@@ -0,0 +1,48 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseMetrics(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{})
+	router.responseMetrics = newResponseMetrics(router.Metrics)
+
+	muxxer := mux.NewRouter()
+	muxxer.Use(router.requestLogger)
+	muxxer.HandleFunc("/ok", func(w http.ResponseWriter, req *http.Request) {}).Name("batch")
+	muxxer.HandleFunc("/status/{code}", func(w http.ResponseWriter, req *http.Request) {
+		switch mux.Vars(req)["code"] {
+		case "429":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "400":
+			w.WriteHeader(http.StatusBadRequest)
+		case "503":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}).Name("get Status: code")
+	muxxer.HandleFunc("/unnamed", func(w http.ResponseWriter, req *http.Request) {})
+	router.responseMetrics.registerRoutes(muxxer)
+
+	for _, path := range []string{"/ok", "/ok", "/status/429", "/status/400", "/status/503", "/unnamed"} {
+		muxxer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["incoming_router_response_batch_2xx"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_response_get_status_code_429"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_response_get_status_code_4xx"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_response_get_status_code_5xx"])
+	assert.Len(t, mockMetrics.Registrations, 2*len(statusClasses), "unnamed routes aren't counted")
+}
+
+func TestRouteMetricLabel(t *testing.T) {
+	assert.Equal(t, "get_debug_information_for_given_trace_id", routeMetricLabel("get debug information for given trace ID"))
+	assert.Equal(t, "otlp_traces", routeMetricLabel("otlp_traces"))
+	assert.Equal(t, "a_b", routeMetricLabel(" a -- b! "))
+}
@@ -12,9 +12,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -22,23 +26,31 @@ import (
 	"github.com/klauspost/compress/zstd"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/exp/maps"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	healthserver "google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"gopkg.in/yaml.v3"
 
 	// grpc/gzip compressor, auto registers on import
-	_ "google.golang.org/grpc/encoding/gzip"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
 
 	huskyotlp "github.com/honeycombio/husky/otlp"
 
 	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/collect/cache"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/internal/health"
+	"github.com/honeycombio/refinery/internal/peer"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/sample"
 	"github.com/honeycombio/refinery/transmit"
 	"github.com/honeycombio/refinery/types"
 
@@ -57,14 +69,56 @@ const (
 	defaultSampleRate      = 1
 )
 
+// ErrDatasetNotAllowed is returned when an event's dataset is rejected by the
+// AllowedDatasets or DeniedDatasets configuration. newBatchResponse reports it
+// as ErrDatasetNotAllowedRequest.
+var ErrDatasetNotAllowed = errors.New("dataset not allowed")
+
+// ErrEnvironmentLookupBusy is returned when an API key's environment can't be
+// looked up because too many lookups are already in progress. It's temporary,
+// so clients should retry.
+var ErrEnvironmentLookupBusy = errors.New("too many environment lookups in progress")
+
+// ErrEnvironmentLookupFailed is returned when an API key's environment can't
+// be looked up from Honeycomb. Unlike a classic key, which has no environment,
+// the key may well have one, so clients should retry.
+var ErrEnvironmentLookupFailed = errors.New("failed to look up environment")
+
+// ErrZstdDecoderBusy is returned when a zstd request body can't be
+// decompressed because every decoder stayed in use for ZstdDecoderWaitTimeout.
+// It's temporary, so clients should retry.
+var ErrZstdDecoderBusy = errors.New("no zstd decoder available")
+
+// ErrDecompressionBusy is returned when a compressed request body can't be
+// decompressed because MaxConcurrentDecompressions others already are. It's
+// temporary, so clients should retry.
+var ErrDecompressionBusy = errors.New("too many request bodies being decompressed")
+
+// ErrRequestBodyTooLarge is returned when a request body, once decompressed,
+// is larger than MaxRequestBodySize.
+var ErrRequestBodyTooLarge = errors.New("request body is too large")
+
+// ErrInvalidTraceID is returned by processEvent for events whose trace ID
+// field has a value that can't be used as a trace ID.
+var ErrInvalidTraceID = errors.New("invalid trace ID")
+
+// ErrDraining is returned for new events once the router has been asked to
+// drain in preparation for shutdown.
+var ErrDraining = errors.New("refinery is draining and not accepting new data")
+
 type Router struct {
-	Config               config.Config         `inject:""`
-	Logger               logger.Logger         `inject:""`
-	Health               health.Reporter       `inject:""`
-	HTTPTransport        *http.Transport       `inject:"upstreamTransport"`
-	UpstreamTransmission transmit.Transmission `inject:"upstreamTransmission"`
-	Collector            collect.Collector     `inject:"collector"`
-	Metrics              metrics.Metrics       `inject:"genericMetrics"`
+	Config               config.Config          `inject:""`
+	Logger               logger.Logger          `inject:""`
+	Health               health.Reporter        `inject:""`
+	HealthRecorder       health.Recorder        `inject:""`
+	HTTPTransport        *http.Transport        `inject:"upstreamTransport"`
+	ConnLifetime         *transmit.ConnLifetime `inject:"connLifetime"`
+	UpstreamTransmission transmit.Transmission  `inject:"upstreamTransmission"`
+	MirrorTransmission   transmit.Transmission  `inject:"mirrorTransmission"`
+	Collector            collect.Collector      `inject:"collector"`
+	Metrics              metrics.Metrics        `inject:"genericMetrics"`
+	DecisionCache        cache.TraceSentCache   `inject:""`
+	Peers                peer.Peers             `inject:""`
 
 	// version is set on startup so that the router may answer HTTP requests for
 	// the version
@@ -75,20 +129,188 @@ type Router struct {
 	// iopLogger is a logger that knows whether it's incoming or peer
 	iopLogger iopLogger
 
+	// nodeID identifies this node in meta.refinery.received_by
+	nodeID string
+
 	zstdDecoders chan *zstd.Decoder
+	// decompressions is the number of request bodies being decompressed
+	decompressions atomic.Int64
 
 	server     *http.Server
+	otlpServer *http.Server
 	grpcServer *grpc.Server
 	doneWG     sync.WaitGroup
 	donech     chan struct{}
 
-	environmentCache *environmentCache
-	hsrv             *healthserver.Server
+	// the handlers of server and otlpServer, rebuilt on reload
+	handler     *swappableHandler
+	otlpHandler *swappableHandler
+
+	// the Unix domain sockets we're listening on, removed on Stop
+	socketPaths []string
+
+	environmentCache   *environmentCache
+	environmentMetrics *environmentMetrics
+	responseMetrics    *responseMetrics
+	environmentLimit   *environmentLimit
+	datasetLimit       *datasetLimit
+
+	// apiKeyCache holds the results of IsAPIKeyValid, if they're cached
+	apiKeyCache apiKeyValidationCache
+
+	// idFieldNames is the snapshot of the ID field names used on the hot
+	// path; use idFields() to read it
+	idFieldNames atomic.Pointer[idFieldNames]
+
+	// traceHeaderRegexes caches compiled TraceHeaders expressions by pattern
+	traceHeaderRegexes sync.Map
+
+	// datasetTransforms caches parsed DatasetTransforms entries
+	datasetTransforms sync.Map
+
+	// keyNormalizations caches parsed SamplerKeyNormalizations entries
+	keyNormalizations     sync.Map
+	keyNormalizationStats keyNormalizationStats
+
+	// draining is set when the router should stop accepting new data while
+	// the collector flushes the traces it already has.
+	draining atomic.Bool
+	hsrv     *healthserver.Server
+
+	// selfTestRunning is set while a /query/selftest request is generating
+	// spans, so that only one runs at a time
+	selfTestRunning atomic.Bool
+
+	// grpcExportsInFlight counts the gRPC export requests being handled, for
+	// MaxConcurrentExports
+	grpcExportsInFlight atomic.Int64
+
+	// grpcConnections counts the open gRPC connections, for MaxConnections;
+	// grpcRejectingConnections is set while connections are being rejected
+	grpcConnections          atomic.Int64
+	grpcRejectingConnections atomic.Bool
+
+	// mirrorQueue holds the copies of incoming events waiting to be sent to
+	// the mirror; it's nil unless MirrorAllSpans is set
+	mirrorQueue chan *types.Event
 }
 
+// BatchResponse is the outcome of a single event. Code is a stable,
+// machine-readable description of a failure, so that clients can decide
+// whether to retry without parsing Error, which is meant for people.
+//
+// SampleRate is set for accepted events. Most are sampled later, along with
+// the rest of their trace, so it's the rate the event arrived with; for those
+// that Stress Relief samples as they arrive, it's the rate that was applied.
 type BatchResponse struct {
-	Status int    `json:"status"`
-	Error  string `json:"error,omitempty"`
+	Status     int    `json:"status" msgpack:"status"`
+	Code       string `json:"code,omitempty" msgpack:"code,omitempty"`
+	Error      string `json:"error,omitempty" msgpack:"error,omitempty"`
+	SampleRate uint   `json:"sample_rate,omitempty" msgpack:"sample_rate,omitempty"`
+}
+
+// The values of BatchResponse.Code. Events rejected with BatchCodeRateLimited,
+// BatchCodeDraining or BatchCodeNotReady can be retried, the draining ones
+// against another instance; retrying the others won't help.
+const (
+	// BatchCodeRateLimited means refinery is too busy to accept the event
+	BatchCodeRateLimited = "rate_limited"
+	// BatchCodeDraining means refinery is shutting down
+	BatchCodeDraining = "draining"
+	// BatchCodeNotReady means refinery is still starting up
+	BatchCodeNotReady = "not_ready"
+	// BatchCodeDatasetDenied means the dataset isn't allowed by
+	// AllowedDatasets or DeniedDatasets
+	BatchCodeDatasetDenied = "dataset_denied"
+	// BatchCodeSpanTooLarge means the event exceeds the configured span limits
+	BatchCodeSpanTooLarge = "span_too_large"
+	// BatchCodeTraceTooLarge means the event's trace already has
+	// MaxSpansPerTrace spans
+	BatchCodeTraceTooLarge = "trace_too_large"
+	// BatchCodeInvalidTraceID means the event's trace ID couldn't be used
+	BatchCodeInvalidTraceID = "invalid_trace_id"
+	// BatchCodeTimeSkewed means the event's timestamp is too far in the future
+	// or the past
+	BatchCodeTimeSkewed = "time_skewed"
+	// BatchCodeInvalidEvent means the event was rejected for any other reason
+	BatchCodeInvalidEvent = "invalid_event"
+)
+
+// newBatchResponse describes the outcome of processing a single event, given
+// the error (if any) returned by processEvent. The status codes match the
+// handlerErrors that describe the same failures.
+func newBatchResponse(err error) BatchResponse {
+	var he handlerError
+	var code string
+	switch {
+	case err == nil:
+		return BatchResponse{Status: http.StatusAccepted}
+	case errors.Is(err, collect.ErrWouldBlock):
+		he, code = ErrCollectorBusy, BatchCodeRateLimited
+	case errors.Is(err, ErrDatasetNotAllowed):
+		he, code = ErrDatasetNotAllowedRequest, BatchCodeDatasetDenied
+	case errors.Is(err, ErrDraining):
+		he, code = ErrDrainingRequest, BatchCodeDraining
+	case errors.Is(err, ErrNotReady):
+		he, code = ErrNotReadyRequest, BatchCodeNotReady
+	case errors.Is(err, ErrSpanTooLarge):
+		he, code = ErrSpanTooLargeRequest, BatchCodeSpanTooLarge
+	case errors.Is(err, collect.ErrTraceSpanLimit):
+		he, code = ErrTraceTooLarge, BatchCodeTraceTooLarge
+	case errors.Is(err, ErrInvalidTraceID):
+		he, code = ErrReqToEvent, BatchCodeInvalidTraceID
+	case errors.Is(err, ErrEventTimeSkewed):
+		he, code = ErrReqToEvent, BatchCodeTimeSkewed
+	default:
+		he, code = ErrReqToEvent, BatchCodeInvalidEvent
+	}
+	return BatchResponse{Status: he.status, Code: code, Error: err.Error()}
+}
+
+// processBatchEvent processes ev and describes the outcome as a
+// BatchResponse, including the sample rate of an accepted event.
+func (r *Router) processBatchEvent(ev *types.Event, reqID interface{}) BatchResponse {
+	resp := newBatchResponse(r.processEvent(ev, reqID))
+	if resp.Status == http.StatusAccepted {
+		resp.SampleRate = incomingSampleRate(ev)
+	}
+	return resp
+}
+
+// newOTLPError describes an error returned by processOTLPRequest as an
+// OTLPError, so that OTLP clients get the same HTTP status the event and batch
+// endpoints return for the same failure, or the matching gRPC code.
+func newOTLPError(err error) huskyotlp.OTLPError {
+	otlpErr := huskyotlp.OTLPError{
+		Message:        err.Error(),
+		HTTPStatusCode: http.StatusInternalServerError,
+		GRPCStatusCode: codes.Internal,
+	}
+	switch {
+	case errors.Is(err, collect.ErrWouldBlock):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrCollectorBusy.status, codes.ResourceExhausted
+	case errors.Is(err, ErrDraining):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrDrainingRequest.status, codes.Unavailable
+	case errors.Is(err, ErrNotReady):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrNotReadyRequest.status, codes.Unavailable
+	case errors.Is(err, ErrEnvironmentLookupBusy):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrEnvironmentBusy.status, codes.Unavailable
+	case errors.Is(err, ErrEnvironmentLookupFailed):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrEnvironmentUnknown.status, codes.Unavailable
+	case errors.Is(err, ErrEnvironmentLimitExceeded):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrEnvironmentLimit.status, codes.PermissionDenied
+	case isDecoderBusy(err):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrDecoderBusy.status, codes.Unavailable
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrRequestTooLarge.status, codes.ResourceExhausted
+	case errors.Is(err, ErrSpanTooLarge):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrSpanTooLargeRequest.status, codes.InvalidArgument
+	case errors.Is(err, collect.ErrTraceSpanLimit):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrTraceTooLarge.status, codes.InvalidArgument
+	case errors.Is(err, ErrEventTimeSkewed):
+		otlpErr.HTTPStatusCode, otlpErr.GRPCStatusCode = ErrReqToEvent.status, codes.InvalidArgument
+	}
+	return otlpErr
 }
 
 type iopLogger struct {
@@ -118,14 +340,30 @@ func (r *Router) SetVersion(ver string) {
 // prioritized.
 func (r *Router) LnS() {
 	r.iopLogger = iopLogger{
-		Logger: r.Logger,
+		Logger:         r.Logger,
+		incomingOrPeer: "incoming",
 	}
 
 	r.proxyClient = &http.Client{
 		Timeout:   time.Second * 10,
-		Transport: r.HTTPTransport,
+		Transport: r.ConnLifetime.Wrap(r.HTTPTransport),
 	}
 	r.environmentCache = newEnvironmentCache(r.Config.GetEnvironmentCacheTTL(), r.lookupEnvironment)
+	r.environmentCache.limitLookups(r.Config.GetMaxConcurrentAuthLookups(), r.Config.GetAuthLookupWaitTimeout(), r.Metrics)
+	r.Config.RegisterReloadCallback(r.reloadEnvironmentCacheTTL)
+	r.Config.RegisterReloadCallback(r.clearAPIKeyCache)
+	r.refreshIDFieldNames()
+	r.Config.RegisterReloadCallback(r.reloadIDFieldNames)
+	r.environmentMetrics = newEnvironmentMetrics(r.Metrics, r.Config.GetMaxMetricsEnvironments())
+	r.environmentLimit = newEnvironmentLimit()
+	r.datasetLimit = newDatasetLimit()
+	r.responseMetrics = newResponseMetrics(r.Metrics)
+
+	if nodeID, err := r.nodeIdentifier(); err != nil {
+		r.iopLogger.Error().Logf("couldn't determine node identifier for %s: %s", receivedByFieldName, err)
+	} else {
+		r.nodeID = nodeID
+	}
 
 	var err error
 	r.zstdDecoders, err = makeDecoders(numZstdDecoders)
@@ -134,16 +372,203 @@ func (r *Router) LnS() {
 		return
 	}
 
+	if param := r.Config.GetAPIKeyQueryParam(); param != "" {
+		r.Logger.Warn().Logf("accepting API keys from the %q query parameter; API keys in URLs may be exposed in logs", param)
+	}
+
 	r.Metrics.Register("incoming_router_proxied", "counter")
+	r.Metrics.Register("incoming_router_unmatched", "counter")
 	r.Metrics.Register("incoming_router_event", "counter")
 	r.Metrics.Register("incoming_router_batch", "counter")
+	r.Metrics.Register("incoming_router_batch_ndjson_events", "counter")
+	r.Metrics.Register("incoming_router_jaeger", "counter")
+	r.Metrics.Register("incoming_router_jaeger_rejected", "counter")
 	r.Metrics.Register("incoming_router_nonspan", "counter")
+	r.Metrics.Register("incoming_router_nonspan_dropped", "counter")
+	r.Metrics.Register("incoming_router_nonspan_routed", "counter")
 	r.Metrics.Register("incoming_router_span", "counter")
 	r.Metrics.Register("incoming_router_peer", "counter")
 	r.Metrics.Register("incoming_router_dropped", "counter")
+	r.Metrics.Register("incoming_router_dataset_denied", "counter")
+	r.Metrics.Register("incoming_router_span_truncated", "counter")
+	r.Metrics.Register("incoming_router_field_truncated", "counter")
+	r.Metrics.Register("incoming_router_span_rejected", "counter")
+	r.Metrics.Register("incoming_router_redacted_fields", "counter")
+	r.Metrics.Register("incoming_router_field_coercion_failed", "counter")
+	r.Metrics.Register("incoming_router_force_kept", "counter")
+	r.Metrics.Register("incoming_router_error_spans", "counter")
+	r.Metrics.Register("incoming_router_dataset_routed", "counter")
+	r.Metrics.Register("body_checksum_mismatch", "counter")
+	r.Metrics.Register("incoming_router_otlp_invalid_id", "counter")
+	r.Metrics.Register("incoming_router_otlp_invalid_id_dropped", "counter")
+	r.Metrics.Register("incoming_router_empty_traceid", "counter")
+	r.Metrics.Register("incoming_router_time_skew_clamped", "counter")
+	r.Metrics.Register("incoming_router_time_skew_rejected", "counter")
+	r.Metrics.Register("incoming_router_traceid_conflict", "counter")
+	r.Metrics.Register("incoming_router_otlp_over_limit", "counter")
+	r.Metrics.Register("incoming_router_otlp_rejected", "counter")
+	r.Metrics.Register("incoming_router_grpc_traces_shed", "counter")
+	r.Metrics.Register("incoming_router_grpc_logs_shed", "counter")
+	r.Metrics.Register("incoming_router_grpc_connections", "gauge")
+	r.Metrics.Register("incoming_router_grpc_connections_rejected", "counter")
+	r.Metrics.Register("incoming_router_mirrored", "counter")
+	r.Metrics.Register("incoming_router_default_dataset", "counter")
+	r.Metrics.Register("sampler_key_values_normalized", "counter")
+	r.Metrics.Register("sampler_key_distinct_raw_values", "gauge")
+	r.Metrics.Register("sampler_key_distinct_normalized_values", "gauge")
+	r.Metrics.Register("incoming_router_mirror_dropped", "counter")
+	r.Metrics.Register("environment_lookups_in_flight", "gauge")
+	r.Metrics.Register("api_key_validation_cache_hit", "counter")
+	r.Metrics.Register("api_key_validation_cache_miss", "counter")
+	r.Metrics.Register("environment_lookup_rejected", "counter")
+	r.Metrics.Register("environment_lookups_waiting", "gauge")
+	r.Metrics.Register("incoming_router_environment_lookup_failed", "counter")
+	r.Metrics.Register("distinct_environments", "gauge")
+	r.Metrics.Register("environment_limit_exceeded", "counter")
+	r.Metrics.Register("environment_limit_rejected", "counter")
+	r.Metrics.Register("distinct_datasets", "gauge")
+	r.Metrics.Register("dataset_limit_rejected", "counter")
+	r.Metrics.Register("dataset_limit_routed", "counter")
+	r.Metrics.Register("upstream_health_check_failed", "counter")
+	r.Metrics.Register("slow_request", "counter")
+	r.Metrics.Register("zstd_decoder_wait", "counter")
+	r.Metrics.Register("zstd_decoder_timeout", "counter")
+	r.Metrics.Register("decompressions_in_flight", "gauge")
+	r.Metrics.Register("decompression_rejected", "counter")
 	r.Metrics.Register("is_alive", "gauge")
 	r.Metrics.Register("is_ready", "gauge")
+	r.Metrics.Register("is_degraded", "gauge")
+
+	listenAddr := r.Config.GetListenAddr()
+	if err != nil {
+		r.iopLogger.Error().Logf("failed to get listen addr config: %s", err)
+		return
+	}
+	// GRPC listen addr is optional, err means addr was not empty and invalid
+	grpcAddr := r.Config.GetGRPCListenAddr()
+	if err != nil {
+		r.iopLogger.Error().Logf("failed to get grpc listen addr config: %s", err)
+		return
+	}
+
+	r.iopLogger.Info().Logf("Listening on %s", listenAddr)
+	r.checkPeerListenAddr()
+	// the servers' handlers are swapped on reload, so that routes that
+	// depend on the config can change without dropping connections
+	r.handler = newSwappableHandler(r.mainMuxxer())
+	r.server = &http.Server{
+		Addr:        listenAddr,
+		Handler:     r.handler,
+		IdleTimeout: r.Config.GetHTTPIdleTimeout(),
+	}
+
+	// OTLP can also have a port of its own, so that it can have its own
+	// network policy
+	if otlpAddr := r.Config.GetOTLPListenAddr(); otlpAddr != "" {
+		r.iopLogger.Info().Logf("OTLP listening on %s", otlpAddr)
+		r.otlpHandler = newSwappableHandler(r.otlpOnlyMuxxer())
+		r.otlpServer = &http.Server{
+			Addr:        otlpAddr,
+			Handler:     r.otlpHandler,
+			IdleTimeout: r.Config.GetHTTPIdleTimeout(),
+		}
+	}
+	r.Config.RegisterReloadCallback(r.reloadRoutes)
 
+	r.donech = make(chan struct{})
+	if r.Config.GetUpstreamHealthCheckEnabled() {
+		r.startUpstreamHealthCheck()
+	}
+	r.startMirror()
+	grpcSocketPath := r.Config.GetGRPCUnixSocketPath()
+	if r.Config.GetGRPCEnabled() && (len(grpcAddr) > 0 || len(grpcSocketPath) > 0) {
+		r.grpcServer = grpc.NewServer(grpcServerOptions(r.Config.GetGRPCConfig())...)
+		r.registerGRPCServices(r.grpcServer)
+
+		if len(grpcAddr) > 0 {
+			l, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				r.iopLogger.Error().Logf("failed to listen to grpc addr: " + grpcAddr)
+			} else {
+				r.iopLogger.Info().Logf("gRPC listening on %s", grpcAddr)
+				go r.grpcServer.Serve(r.limitGRPCConnections(l))
+			}
+		}
+		if len(grpcSocketPath) > 0 {
+			l, err := r.listenUnix(grpcSocketPath)
+			if err != nil {
+				r.iopLogger.Error().Logf("failed to listen on grpc unix socket %s: %s", grpcSocketPath, err)
+			} else {
+				r.iopLogger.Info().Logf("gRPC listening on unix socket %s", grpcSocketPath)
+				go r.grpcServer.Serve(r.limitGRPCConnections(l))
+			}
+		}
+	}
+
+	if socketPath := r.Config.GetUnixSocketPath(); len(socketPath) > 0 {
+		l, err := r.listenUnix(socketPath)
+		if err != nil {
+			r.iopLogger.Error().Logf("failed to listen on unix socket %s: %s", socketPath, err)
+		} else {
+			r.iopLogger.Info().Logf("Listening on unix socket %s", socketPath)
+			r.doneWG.Add(1)
+			go func() {
+				defer r.doneWG.Done()
+
+				err := r.server.Serve(l)
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					r.iopLogger.Error().Logf("failed to serve on unix socket: %s", err)
+				}
+			}()
+		}
+	}
+
+	if r.otlpServer != nil {
+		r.doneWG.Add(1)
+		go func() {
+			defer r.doneWG.Done()
+
+			err := r.otlpServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				r.iopLogger.Error().Logf("failed to ListenAndServe for OTLP: %s", err)
+			}
+		}()
+	}
+
+	r.doneWG.Add(1)
+	go func() {
+		defer r.doneWG.Done()
+
+		err = r.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.iopLogger.Error().Logf("failed to ListenAndServe: %s", err)
+		}
+	}()
+}
+
+// checkPeerListenAddr makes sure that PeerListenAddr, which is the address
+// advertised to peers, resolves, and logs it apart from the incoming
+// addresses.
+func (r *Router) checkPeerListenAddr() {
+	peerAddr := r.Config.GetPeerListenAddr()
+	if peerAddr == "" {
+		return
+	}
+	peerLogger := iopLogger{
+		Logger:         r.Logger,
+		incomingOrPeer: "peer",
+	}
+	addr, err := net.ResolveTCPAddr("tcp", peerAddr)
+	if err != nil {
+		peerLogger.Error().Logf("peer listen address %s doesn't resolve: %s", peerAddr, err)
+		return
+	}
+	peerLogger.Info().Logf("Peer listen address %s (%s)", peerAddr, addr)
+}
+
+// mainMuxxer returns the handler for the main server, with every route that
+// the config enables.
+func (r *Router) mainMuxxer() *mux.Router {
 	muxxer := mux.NewRouter()
 
 	muxxer.Use(r.setResponseHeaders)
@@ -153,7 +578,9 @@ func (r *Router) LnS() {
 	// answer a basic health check locally
 	muxxer.HandleFunc("/alive", r.alive).Name("local health")
 	muxxer.HandleFunc("/ready", r.ready).Name("local readiness")
-	muxxer.HandleFunc("/panic", r.panic).Name("intentional panic")
+	if r.Config.GetDebugEndpointsEnabled() {
+		muxxer.HandleFunc("/panic", r.panic).Name("intentional panic")
+	}
 	muxxer.HandleFunc("/version", r.version).Name("report version info")
 
 	// require a local auth for query usage
@@ -163,86 +590,162 @@ func (r *Router) LnS() {
 	queryMuxxer.HandleFunc("/trace/{traceID}", r.debugTrace).Name("get debug information for given trace ID")
 	queryMuxxer.HandleFunc("/rules/{format}/{dataset}", r.getSamplerRules).Name("get formatted sampler rules for given dataset")
 	queryMuxxer.HandleFunc("/allrules/{format}", r.getAllSamplerRules).Name("get formatted sampler rules for all datasets")
+	queryMuxxer.HandleFunc("/sampler-resolution/{dataset}", r.getSamplerResolution).Name("explain which sampler applies to given dataset")
+	queryMuxxer.HandleFunc("/sampler-keys/{dataset}", r.getSamplerKeys).Name("get observed sampler keys for given dataset")
+	queryMuxxer.HandleFunc("/dropped-samples", r.getDroppedSamples).Name("get retained samples of dropped traces")
 	queryMuxxer.HandleFunc("/configmetadata", r.getConfigMetadata).Name("get configuration metadata")
-
-	// require an auth header for events and batches
-	authedMuxxer := muxxer.PathPrefix("/1/").Methods("POST").Subrouter()
-	authedMuxxer.UseEncodedPath()
-	authedMuxxer.Use(r.apiKeyChecker)
-
-	// handle events and batches
-	authedMuxxer.HandleFunc("/events/{datasetName}", r.event).Name("event")
-	authedMuxxer.HandleFunc("/batch/{datasetName}", r.batch).Name("batch")
+	queryMuxxer.HandleFunc("/config", r.getEffectiveConfig).Name("get effective configuration with secrets redacted")
+	queryMuxxer.HandleFunc("/stats", r.getStats).Name("get transmission stats")
+	queryMuxxer.HandleFunc("/load", r.getLoad).Name("get load score")
+	queryMuxxer.HandleFunc("/peers", r.getPeers).Name("get peer list")
+	queryMuxxer.HandleFunc("/drain", r.getDrainStatus).Name("get drain progress")
+	queryMuxxer.HandleFunc("/decisions/export", r.exportDecisions).Name("export kept decisions")
+	muxxer.Handle("/query/decisions/import", r.queryTokenChecker(http.HandlerFunc(r.importDecisions))).Methods("POST").Name("import kept decisions")
+	muxxer.Handle("/query/drain", r.queryTokenChecker(http.HandlerFunc(r.startDrain))).Methods("POST").Name("start draining")
+	muxxer.Handle("/query/selftest", r.queryTokenChecker(http.HandlerFunc(r.selfTest))).Methods("POST").Name("run synthetic self test")
+	r.refuseOtherMethods(muxxer.Path("/query/decisions/import"), "POST").Name("method not allowed")
+	r.refuseOtherMethods(muxxer.Path("/query/drain"), "GET", "POST").Name("method not allowed")
+	r.refuseOtherMethods(muxxer.Path("/query/selftest"), "POST").Name("method not allowed")
+	r.refuseOtherMethods(muxxer.PathPrefix("/query/"), "GET").Name("method not allowed")
+
+	r.addEventRoutes(muxxer)
 
 	// require an auth header for OTLP requests
 	r.AddOTLPMuxxer(muxxer)
 
+	// handle Jaeger Thrift requests
+	muxxer.Handle("/api/traces", r.apiKeyChecker(http.HandlerFunc(r.postJaegerThrift))).Methods("POST").Name("jaeger_traces")
+	r.refuseOtherMethods(muxxer.Path("/api/traces"), "POST").Name("method not allowed")
+
 	// pass everything else through unmolested
 	muxxer.PathPrefix("/").HandlerFunc(r.proxy).Name("proxy")
+	r.responseMetrics.registerRoutes(muxxer)
+	return muxxer
+}
 
-	listenAddr := r.Config.GetListenAddr()
-	if err != nil {
-		r.iopLogger.Error().Logf("failed to get listen addr config: %s", err)
-		return
-	}
-	// GRPC listen addr is optional, err means addr was not empty and invalid
-	grpcAddr := r.Config.GetGRPCListenAddr()
-	if err != nil {
-		r.iopLogger.Error().Logf("failed to get grpc listen addr config: %s", err)
-		return
-	}
+// otlpOnlyMuxxer returns the handler for the OTLPListenAddr server, which
+// serves only OTLP/HTTP requests and health checks.
+func (r *Router) otlpOnlyMuxxer() *mux.Router {
+	muxxer := mux.NewRouter()
 
-	r.iopLogger.Info().Logf("Listening on %s", listenAddr)
-	r.server = &http.Server{
-		Addr:        listenAddr,
-		Handler:     muxxer,
-		IdleTimeout: r.Config.GetHTTPIdleTimeout(),
-	}
+	muxxer.Use(r.setResponseHeaders)
+	muxxer.Use(r.requestLogger)
+	muxxer.Use(r.panicCatcher)
 
-	r.donech = make(chan struct{})
-	if r.Config.GetGRPCEnabled() && len(grpcAddr) > 0 {
-		l, err := net.Listen("tcp", grpcAddr)
-		if err != nil {
-			r.iopLogger.Error().Logf("failed to listen to grpc addr: " + grpcAddr)
-		}
+	muxxer.HandleFunc("/alive", r.alive).Name("local health")
+	muxxer.HandleFunc("/ready", r.ready).Name("local readiness")
+
+	r.AddOTLPMuxxer(muxxer)
+	r.responseMetrics.registerRoutes(muxxer)
+	return muxxer
+}
 
-		r.iopLogger.Info().Logf("gRPC listening on %s", grpcAddr)
-		grpcConfig := r.Config.GetGRPCConfig()
-		serverOpts := []grpc.ServerOption{
-			grpc.MaxSendMsgSize(int(grpcConfig.MaxSendMsgSize)),
-			grpc.MaxRecvMsgSize(int(grpcConfig.MaxRecvMsgSize)),
-			grpc.KeepaliveParams(keepalive.ServerParameters{
-				MaxConnectionIdle:     time.Duration(grpcConfig.MaxConnectionIdle),
-				MaxConnectionAge:      time.Duration(grpcConfig.MaxConnectionAge),
-				MaxConnectionAgeGrace: time.Duration(grpcConfig.MaxConnectionAgeGrace),
-				Time:                  time.Duration(grpcConfig.KeepAlive),
-				Timeout:               time.Duration(grpcConfig.KeepAliveTimeout),
-			}),
+// listenUnix listens on a Unix domain socket at path. A socket left behind by
+// a process that didn't shut down cleanly is removed first, but one that
+// something is still listening on is left alone.
+func (r *Router) listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
 		}
-		r.grpcServer = grpc.NewServer(serverOpts...)
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		r.iopLogger.Info().Logf("removing stale unix socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
 
-		traceServer := NewTraceServer(r)
-		collectortrace.RegisterTraceServiceServer(r.grpcServer, traceServer)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	r.socketPaths = append(r.socketPaths, path)
+	return l, nil
+}
 
-		logsServer := NewLogsServer(r)
-		collectorlogs.RegisterLogsServiceServer(r.grpcServer, logsServer)
+// registerGRPCServices registers the services Refinery serves over gRPC on
+// server, along with the reflection service if it's enabled.
+func (r *Router) registerGRPCServices(server *grpc.Server) {
+	collectortrace.RegisterTraceServiceServer(server, NewTraceServer(r))
+	collectorlogs.RegisterLogsServiceServer(server, NewLogsServer(r))
+	grpc_health_v1.RegisterHealthServer(server, r)
 
-		grpc_health_v1.RegisterHealthServer(r.grpcServer, r)
-		go r.grpcServer.Serve(l)
+	if r.Config.GetGRPCReflectionEnabled() {
+		reflection.Register(server)
 	}
+}
 
-	r.doneWG.Add(1)
-	go func() {
-		defer r.doneWG.Done()
+// grpcServerOptions translates the GRPCServerParameters config into options
+// for the gRPC server.
+func grpcServerOptions(grpcConfig config.GRPCServerParameters) []grpc.ServerOption {
+	maxRecv := int(grpcConfig.MaxRecvMsgSize)
+	maxLogsRecv := int(grpcConfig.MaxLogsRecvMsgSize)
+	if maxLogsRecv == 0 {
+		maxLogsRecv = maxRecv
+	}
+	// the server's limit has to allow the larger of the two; the smaller one is
+	// enforced per service by the interceptor
+	serverMaxRecv := max(maxRecv, maxLogsRecv)
+
+	interceptors := []grpc.UnaryServerInterceptor{maxRecvSizeInterceptor(maxRecv, maxLogsRecv)}
+	serverOpts := []grpc.ServerOption{
+		grpc.MaxSendMsgSize(int(grpcConfig.MaxSendMsgSize)),
+		grpc.MaxRecvMsgSize(serverMaxRecv),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     time.Duration(grpcConfig.MaxConnectionIdle),
+			MaxConnectionAge:      time.Duration(grpcConfig.MaxConnectionAge),
+			MaxConnectionAgeGrace: time.Duration(grpcConfig.MaxConnectionAgeGrace),
+			Time:                  time.Duration(grpcConfig.KeepAlive),
+			Timeout:               time.Duration(grpcConfig.KeepAliveTimeout),
+		}),
+	}
+	if grpcConfig.MaxConcurrentStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(uint32(grpcConfig.MaxConcurrentStreams)))
+	}
+	if grpcConfig.ResponseCompression == grpcgzip.Name {
+		interceptors = append(interceptors, gzipResponseInterceptor)
+	}
+	serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(interceptors...))
+	return serverOpts
+}
 
-		err = r.server.ListenAndServe()
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			r.iopLogger.Error().Logf("failed to ListenAndServe: %s", err)
+var logsServicePrefix = "/" + collectorlogs.LogsService_ServiceDesc.ServiceName + "/"
+
+// maxRecvSizeInterceptor rejects requests whose decompressed size is over the
+// limit for their service, with a status that says how big they were.
+func maxRecvSizeInterceptor(maxRecv, maxLogsRecv int) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		limit := maxRecv
+		if strings.HasPrefix(info.FullMethod, logsServicePrefix) {
+			limit = maxLogsRecv
 		}
-	}()
+		if msg, ok := req.(proto.Message); ok && limit > 0 {
+			if size := proto.Size(msg); size > limit {
+				return nil, status.Errorf(codes.ResourceExhausted,
+					"%s: decompressed message is %d bytes, which is larger than the limit of %d bytes",
+					info.FullMethod, size, limit)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// gzipResponseInterceptor compresses responses with gzip when the client
+// accepts it; otherwise the response is compressed the same way as the request.
+func gzipResponseInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	// SetSendCompressor only fails if the client doesn't accept gzip, in
+	// which case we just leave the default in place
+	_ = grpc.SetSendCompressor(ctx, grpcgzip.Name)
+	return handler(ctx, req)
 }
 
 func (r *Router) Stop() error {
+	// stop accepting new data before shutting down the listeners
+	r.drain()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
@@ -250,11 +753,30 @@ func (r *Router) Stop() error {
 	if err != nil {
 		return err
 	}
+	if r.otlpServer != nil {
+		if err := r.otlpServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
 	if r.grpcServer != nil {
 		r.grpcServer.GracefulStop()
 	}
 	close(r.donech)
 	r.doneWG.Wait()
+
+	// the servers are down, so every decoder is back in the pool or about
+	// to be; close them so that a router that's stopped doesn't hold onto
+	// their memory
+	if err := closeDecoders(ctx, r.zstdDecoders, numZstdDecoders); err != nil {
+		r.iopLogger.Error().Logf("not all zstd decoders were returned before shutdown: %s", err)
+	}
+
+	// closing the listeners normally removes the sockets, but make sure
+	for _, path := range r.socketPaths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.iopLogger.Error().Logf("failed to remove unix socket %s: %s", path, err)
+		}
+	}
 	return nil
 }
 
@@ -272,18 +794,82 @@ func (r *Router) alive(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Router) ready(w http.ResponseWriter, req *http.Request) {
+	// until everything's been injected, there's nothing to ask
+	if !r.dependenciesReady() || r.Collector == nil || r.Health == nil || r.Metrics == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "ready": "no"}, "json")
+		return
+	}
 	r.iopLogger.Debug().Logf("answered /ready check")
 
-	ready := r.Health.IsReady()
+	ready := r.Health.IsReady() && !r.draining.Load()
 	r.Metrics.Gauge("is_ready", ready)
 	if !ready {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "ready": "no"}, "json")
 		return
 	}
+	// a node that's ready but busy says so, so that load balancers that can
+	// weight their routing send it less
+	if r.Config.GetDegradedLoadThreshold() > 0 {
+		if load := r.load(); load.Degraded {
+			r.Metrics.Gauge("is_degraded", true)
+			r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "ready": "degraded", "load": load.Load}, "json")
+			return
+		}
+		r.Metrics.Gauge("is_degraded", false)
+	}
 	r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "ready": "yes"}, "json")
 }
 
+// startDrain puts the router into draining mode: /ready starts failing so that
+// load balancers stop sending traffic, and new events are rejected, while the
+// collector continues to send the traces it has already buffered.
+func (r *Router) startDrain(w http.ResponseWriter, req *http.Request) {
+	r.drain()
+	w.WriteHeader(http.StatusAccepted)
+	r.writeDrainStatus(w)
+}
+
+// drain stops the router accepting new data and, the first time it's called,
+// asks the collector to start flushing what it has buffered.
+func (r *Router) drain() {
+	if !r.draining.Swap(true) {
+		r.iopLogger.Info().Logf("draining: no longer accepting new data")
+		r.Collector.Drain()
+	}
+}
+
+// getDrainStatus reports whether the router is draining and how many traces
+// are still buffered.
+func (r *Router) getDrainStatus(w http.ResponseWriter, req *http.Request) {
+	r.writeDrainStatus(w)
+}
+
+func (r *Router) writeDrainStatus(w http.ResponseWriter) {
+	r.marshalToFormat(w, map[string]interface{}{
+		"source":           "refinery",
+		"draining":         r.draining.Load(),
+		"remaining_traces": r.Collector.RemainingTraceCount(),
+	}, "json")
+}
+
+// getStats reports the state of the upstream and mirror transmissions.
+func (r *Router) getStats(w http.ResponseWriter, req *http.Request) {
+	stats := map[string]interface{}{}
+	for name, t := range map[string]transmit.Transmission{
+		"upstream": r.UpstreamTransmission,
+		"mirror":   r.MirrorTransmission,
+	} {
+		if reporter, ok := t.(transmit.CircuitBreakerReporter); ok {
+			stats[name] = map[string]interface{}{
+				"circuit_breaker": reporter.CircuitBreakerStats(),
+			}
+		}
+	}
+	r.marshalToFormat(w, stats, "json")
+}
+
 func (r *Router) panic(w http.ResponseWriter, req *http.Request) {
 	panic("panic? never!")
 }
@@ -292,9 +878,28 @@ func (r *Router) version(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte(fmt.Sprintf(`{"source":"refinery","version":"%s"}`, r.versionStr)))
 }
 
+// debugTrace reports what Refinery knows about a trace without looking it up:
+// for now, the shard that it's assigned to in each sharded dataset.
 func (r *Router) debugTrace(w http.ResponseWriter, req *http.Request) {
 	traceID := mux.Vars(req)["traceID"]
-	w.Write([]byte(fmt.Sprintf(`{"traceID":"%s"}`, traceID)))
+	info := struct {
+		TraceID       string            `json:"traceID"`
+		DatasetShards map[string]string `json:"datasetShards,omitempty"`
+	}{TraceID: traceID}
+	for dataset := range r.Config.GetDatasetShards() {
+		if shard, ok := r.datasetShard(dataset, traceID); ok {
+			if info.DatasetShards == nil {
+				info.DatasetShards = make(map[string]string)
+			}
+			info.DatasetShards[dataset] = shard
+		}
+	}
+	body, err := json.Marshal(info)
+	if err != nil {
+		r.handlerReturnWithError(w, ErrJSONBuildFailed, err)
+		return
+	}
+	w.Write(body)
 }
 
 func (r *Router) getSamplerRules(w http.ResponseWriter, req *http.Request) {
@@ -302,19 +907,77 @@ func (r *Router) getSamplerRules(w http.ResponseWriter, req *http.Request) {
 	dataset := mux.Vars(req)["dataset"]
 	cfg, name, err := r.Config.GetSamplerConfigForDestName(dataset)
 	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrNoSamplerFound) {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
 		w.Write([]byte(fmt.Sprintf("got error %v trying to fetch config for dataset %s\n", err, dataset)))
-		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	r.marshalToFormat(w, map[string]interface{}{name: cfg}, format)
 }
 
+// getSamplerResolution reports which Samplers entry applies to the given
+// dataset (or environment) and why, including whether it fell back to the
+// default. If no sampler applies, the explanation comes with a 404.
+func (r *Router) getSamplerResolution(w http.ResponseWriter, req *http.Request) {
+	dataset := mux.Vars(req)["dataset"]
+	res := r.Config.GetSamplerResolution(dataset)
+	if !res.Found {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+	}
+	r.marshalToFormat(w, res, "json")
+}
+
 func (r *Router) getAllSamplerRules(w http.ResponseWriter, req *http.Request) {
 	format := strings.ToLower(mux.Vars(req)["format"])
 	cfgs := r.Config.GetAllSamplerRules()
 	r.marshalToFormat(w, cfgs, format)
 }
 
+// maxSamplerKeysResponse bounds how many sampler keys getSamplerKeys returns.
+const maxSamplerKeysResponse = 1000
+
+// getSamplerKeys reports the sampler keys, with counts, that the sampler for
+// the given dataset (or environment) has seen recently, most frequent first.
+func (r *Router) getSamplerKeys(w http.ResponseWriter, req *http.Request) {
+	dataset := mux.Vars(req)["dataset"]
+	keys, found := r.Collector.GetSamplerKeys(dataset)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("no key-based sampler is active for dataset %s\n", dataset)))
+		return
+	}
+	truncated := len(keys) > maxSamplerKeysResponse
+	if truncated {
+		keys = keys[:maxSamplerKeysResponse]
+	}
+	if keys == nil {
+		keys = []sample.SamplerKey{}
+	}
+	r.marshalToFormat(w, map[string]interface{}{
+		"dataset":   dataset,
+		"keys":      keys,
+		"truncated": truncated,
+	}, "json")
+}
+
+// getDroppedSamples reports the dropped traces retained because of
+// DroppedSampleRetention, newest first.
+func (r *Router) getDroppedSamples(w http.ResponseWriter, req *http.Request) {
+	samples, enabled := r.Collector.GetDroppedSamples()
+	if !enabled {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("dropped trace samples are not being retained; set DroppedSampleRetention to enable them\n"))
+		return
+	}
+	r.marshalToFormat(w, map[string]interface{}{
+		"samples": samples,
+	}, "json")
+}
+
 func (r *Router) getConfigMetadata(w http.ResponseWriter, req *http.Request) {
 	cm := r.Config.GetConfigMetadata()
 	r.marshalToFormat(w, cm, "json")
@@ -323,100 +986,153 @@ func (r *Router) getConfigMetadata(w http.ResponseWriter, req *http.Request) {
 func (r *Router) marshalToFormat(w http.ResponseWriter, obj interface{}, format string) {
 	var body []byte
 	var err error
+	contentType := "application/" + format
 	switch format {
 	case "json":
 		body, err = json.Marshal(obj)
-		if err != nil {
-			w.Write([]byte(fmt.Sprintf("got error %v trying to marshal to json\n", err)))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
+	case "canonical":
+		body, err = canonicalJSON(obj)
+		contentType = "application/json"
 	case "toml":
 		body, err = toml.Marshal(obj)
-		if err != nil {
-			w.Write([]byte(fmt.Sprintf("got error %v trying to marshal to toml\n", err)))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 	case "yaml":
 		body, err = yaml.Marshal(obj)
-		if err != nil {
-			w.Write([]byte(fmt.Sprintf("got error %v trying to marshal to toml\n", err)))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 	default:
-		w.Write([]byte(fmt.Sprintf("invalid format '%s' when marshaling\n", format)))
 		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid format '%s' when marshaling\n", format)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("got error %v trying to marshal to %s\n", err, format)))
 		return
 	}
-	w.Header().Set("Content-Type", "application/"+format)
+	w.Header().Set("Content-Type", contentType)
 	w.Write(body)
 }
 
+// canonicalJSON marshals obj as indented JSON, with the keys of every object
+// sorted and null values left out, so that equal configs always produce
+// identical output that's easy to compare. Arrays keep their order, because
+// the order of rules matters.
+func canonicalJSON(obj interface{}) ([]byte, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// keep numbers exactly as they were
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	// maps are marshaled with their keys sorted
+	body, err := json.MarshalIndent(dropNulls(generic), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// dropNulls removes null values from the objects in v, at any depth.
+func dropNulls(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range val {
+			if vv == nil {
+				delete(val, k)
+				continue
+			}
+			val[k] = dropNulls(vv)
+		}
+	case []interface{}:
+		for i, vv := range val {
+			val[i] = dropNulls(vv)
+		}
+	}
+	return v
+}
+
 // event is handler for /1/event/
 func (r *Router) event(w http.ResponseWriter, req *http.Request) {
 	r.Metrics.Increment("incoming_router_event")
 	defer req.Body.Close()
 
-	bodyReader, err := r.getMaybeCompressedBody(req)
+	if err := r.checkContentType(req, false); err != nil {
+		r.handlerReturnWithError(w, ErrUnknownContentType, err)
+		return
+	}
+
+	bodyReader, err := r.readCheckedBody(req)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrPostBody, err)
+		r.handlerReturnWithError(w, bodyReadError(err), err)
 		return
 	}
 
 	reqBod, err := io.ReadAll(bodyReader)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrPostBody, err)
+		r.handlerReturnWithError(w, bodyReadError(err), err)
 		return
 	}
 
 	ev, err := r.requestToEvent(req, reqBod)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrReqToEvent, err)
+		r.handlerReturnWithError(w, environmentError(err), err)
 		return
 	}
 
+	r.incrementEnvironmentMetric("incoming_router_event", ev.Environment)
+
 	reqID := req.Context().Value(types.RequestIDContextKey{})
-	err = r.processEvent(ev, reqID)
+	resp := r.processBatchEvent(ev, reqID)
+
+	// describe the outcome the same way a batch describes each of its events
+	response, contentType, err := marshalResponse(req, resp)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrReqToEvent, err)
+		r.handlerReturnWithError(w, ErrJSONBuildFailed, err)
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(resp.Status)
+	w.Write(response)
 }
 
 func (r *Router) requestToEvent(req *http.Request, reqBod []byte) (*types.Event, error) {
 	// get necessary bits out of the incoming event
-	apiKey := req.Header.Get(types.APIKeyHeader)
-	if apiKey == "" {
-		apiKey = req.Header.Get(types.APIKeyHeaderShort)
-	}
+	apiKey := r.getAPIKey(req)
 	sampleRate, err := strconv.Atoi(req.Header.Get(types.SampleRateHeader))
 	if err != nil {
 		sampleRate = 1
 	}
-	eventTime := getEventTime(req.Header.Get(types.TimestampHeader))
-	dataset, err := getDatasetFromRequest(req)
-	if err != nil {
-		return nil, err
-	}
-
-	apiHost, err := r.Config.GetHoneycombAPI()
+	headerTime := getEventTime(req.Header.Get(types.TimestampHeader))
+	dataset, err := r.getDatasetFromRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
 	// get environment name - will be empty for legacy keys
-	environment, err := r.getEnvironmentName(apiKey)
+	environment, fallbackDataset, err := r.resolveEnvironment(apiKey, r.getEnvironmentOverride(req.Header.Get))
 	if err != nil {
 		return nil, err
 	}
+	if fallbackDataset != "" {
+		dataset = fallbackDataset
+	}
+	apiHost := r.Config.GetHoneycombAPIForEnvironment(environment)
 
 	data := map[string]interface{}{}
-	err = unmarshal(req, bytes.NewReader(reqBod), &data)
+	err = r.unmarshalBody(req, bytes.NewReader(reqBod), &data)
 	if err != nil {
+		r.logParseFailure(req, reqBod, err)
 		return nil, err
 	}
+	r.addTraceIDFromHeaders(data, req.Header)
+	eventTime, source := chooseEventTime(r.Config.GetEventTimeSources(), headerTime, bodyEventTime(data))
+	if source == eventTimeFromBody {
+		// the field is the event's time, not part of its data
+		delete(data, "time")
+	}
 
 	return &types.Event{
 		Context:     req.Context(),
@@ -435,126 +1151,327 @@ func (r *Router) batch(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	reqID := req.Context().Value(types.RequestIDContextKey{})
-	debugLog := r.iopLogger.Debug().WithField("request_id", reqID)
 
-	bodyReader, err := r.getMaybeCompressedBody(req)
+	if err := r.checkContentType(req, true); err != nil {
+		r.handlerReturnWithError(w, ErrUnknownContentType, err)
+		return
+	}
+
+	bodyReader, err := r.readCheckedBody(req)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrPostBody, err)
+		r.handlerReturnWithError(w, bodyReadError(err), err)
+		return
+	}
+
+	if isNDJSONContentType(req.Header.Get("Content-Type")) {
+		r.batchNDJSON(w, req, bodyReader)
 		return
 	}
 
 	reqBod, err := io.ReadAll(bodyReader)
 	if err != nil {
-		r.handlerReturnWithError(w, ErrPostBody, err)
+		r.handlerReturnWithError(w, bodyReadError(err), err)
 		return
 	}
 
 	batchedEvents := make([]batchedEvent, 0)
-	err = unmarshal(req, bytes.NewReader(reqBod), &batchedEvents)
+	err = r.unmarshalBody(req, bytes.NewReader(reqBod), &batchedEvents)
+	if err != nil && !isMsgpackContentType(req.Header.Get("Content-Type")) && looksLikeMsgpack(reqBod) {
+		// the body doesn't parse as JSON but starts like a msgpack array or
+		// map; assume the client forgot to set the Content-Type and retry
+		batchedEvents = make([]batchedEvent, 0)
+		if msgpackErr := unmarshalMsgpack(bytes.NewReader(reqBod), &batchedEvents); msgpackErr == nil {
+			r.Logger.Warn().WithField("request_id", reqID).WithString("content_type", req.Header.Get("Content-Type")).
+				Logf("batch body was msgpack but Content-Type was not application/msgpack")
+			err = nil
+		}
+	}
 	if err != nil {
-		debugLog.WithField("error", err.Error()).WithField("request.url", req.URL).WithField("json_body", string(reqBod)).Logf("error parsing json")
+		r.logParseFailure(req, reqBod, err)
 		r.handlerReturnWithError(w, ErrJSONFailed, err)
 		return
 	}
 
-	dataset, err := getDatasetFromRequest(req)
-	if err != nil {
-		r.handlerReturnWithError(w, ErrReqToEvent, err)
+	dest, ok := r.batchDestination(w, req)
+	if !ok {
+		return
+	}
+
+	batchedResponses := make([]*BatchResponse, 0, len(batchedEvents))
+	for _, bev := range batchedEvents {
+		resp := r.processBatchEvent(dest.event(req, bev), reqID)
+		batchedResponses = append(batchedResponses, &resp)
 	}
-	apiHost, err := r.Config.GetHoneycombAPI()
+	r.writeBatchResponses(w, req, batchedResponses)
+}
+
+// batchDestination is where the events of a batch request are sent.
+type batchDestination struct {
+	apiHost     string
+	apiKey      string
+	dataset     string
+	environment string
+	timeSources []string
+}
+
+// batchDestination works out where the events of a batch request go. If it
+// can't, it responds to the request with the error and returns false.
+func (r *Router) batchDestination(w http.ResponseWriter, req *http.Request) (batchDestination, bool) {
+	dataset, err := r.getDatasetFromRequest(req)
 	if err != nil {
 		r.handlerReturnWithError(w, ErrReqToEvent, err)
+		return batchDestination{}, false
 	}
 
-	apiKey := req.Header.Get(types.APIKeyHeader)
-	if apiKey == "" {
-		apiKey = req.Header.Get(types.APIKeyHeaderShort)
-	}
+	apiKey := r.getAPIKey(req)
 
 	// get environment name - will be empty for legacy keys
-	environment, err := r.getEnvironmentName(apiKey)
+	environment, fallbackDataset, err := r.resolveEnvironment(apiKey, r.getEnvironmentOverride(req.Header.Get))
 	if err != nil {
-		r.handlerReturnWithError(w, ErrReqToEvent, err)
-	}
+		r.handlerReturnWithError(w, environmentError(err), err)
+		return batchDestination{}, false
+	}
+	if fallbackDataset != "" {
+		dataset = fallbackDataset
+	}
+	return batchDestination{
+		apiHost:     r.Config.GetHoneycombAPIForEnvironment(environment),
+		apiKey:      apiKey,
+		dataset:     dataset,
+		environment: environment,
+		timeSources: r.Config.GetEventTimeSources(),
+	}, true
+}
 
-	batchedResponses := make([]*BatchResponse, 0, len(batchedEvents))
-	for _, bev := range batchedEvents {
-		ev := &types.Event{
-			Context:     req.Context(),
-			APIHost:     apiHost,
-			APIKey:      apiKey,
-			Dataset:     dataset,
-			Environment: environment,
-			SampleRate:  bev.getSampleRate(),
-			Timestamp:   bev.getEventTime(),
-			Data:        bev.Data,
-		}
-
-		err = r.processEvent(ev, reqID)
-
-		var resp BatchResponse
-		switch {
-		case errors.Is(err, collect.ErrWouldBlock):
-			resp.Status = http.StatusTooManyRequests
-			resp.Error = err.Error()
-		case err != nil:
-			resp.Status = http.StatusBadRequest
-			resp.Error = err.Error()
-		default:
-			resp.Status = http.StatusAccepted
-		}
-		batchedResponses = append(batchedResponses, &resp)
+// event returns the event for one entry of a batch request.
+func (d batchDestination) event(req *http.Request, bev batchedEvent) *types.Event {
+	// batched events have no header of their own
+	eventTime, _ := chooseEventTime(d.timeSources, time.Time{}, bev.getEventTime())
+	return &types.Event{
+		Context:     req.Context(),
+		APIHost:     d.apiHost,
+		APIKey:      d.apiKey,
+		Dataset:     d.dataset,
+		Environment: d.environment,
+		SampleRate:  bev.getSampleRate(),
+		Timestamp:   eventTime,
+		Data:        bev.Data,
 	}
-	response, err := json.Marshal(batchedResponses)
+}
+
+func (r *Router) writeBatchResponses(w http.ResponseWriter, req *http.Request, batchedResponses []*BatchResponse) {
+	response, contentType, err := marshalResponse(req, batchedResponses)
 	if err != nil {
 		r.handlerReturnWithError(w, ErrJSONBuildFailed, err)
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
 	w.Write(response)
 }
 
 func (router *Router) processOTLPRequest(
 	ctx context.Context,
 	batches []huskyotlp.Batch,
-	apiKey string) error {
+	apiKey string,
+	environmentOverride string) error {
 
-	var requestID types.RequestIDContextKey
-	apiHost, err := router.Config.GetHoneycombAPI()
-	if err != nil {
-		router.Logger.Error().Logf("Unable to retrieve APIHost from config while processing OTLP batch")
-		return err
+	if !router.dependenciesReady() {
+		return ErrNotReady
+	}
+	// this is the only point at which draining fails the request: once any
+	// spans are enqueued, failing it would have the client resend them
+	if router.draining.Load() {
+		return ErrDraining
 	}
 
+	requestID := ctx.Value(types.RequestIDContextKey{})
 	// get environment name - will be empty for legacy keys
-	environment, err := router.getEnvironmentName(apiKey)
+	environment, fallbackDataset, err := router.resolveEnvironment(apiKey, environmentOverride)
 	if err != nil {
-		return nil
+		return err
 	}
+	apiHost := router.Config.GetHoneycombAPIForEnvironment(environment)
 
+	fieldMappings := router.Config.GetOTLPFieldMappings()
+	timeSources := router.Config.GetEventTimeSources()
+	invalidIDAction := router.Config.GetInvalidOTLPIDAction()
+	maxEvents := router.Config.GetMaxOTLPEventsPerRequest()
+	var firstErr error
+	var processed, rejected int
+
+events:
 	for _, batch := range batches {
+		dataset := batch.Dataset
+		if dataset == "" && len(batch.Events) > 0 {
+			if d, err := router.defaultDataset(); err == nil {
+				dataset = d
+			}
+		}
+		if fallbackDataset != "" {
+			dataset = fallbackDataset
+		}
 		for _, ev := range batch.Events {
+			if maxEvents > 0 && processed >= maxEvents {
+				overLimit := countOTLPEvents(batches) - processed
+				router.Metrics.Count("incoming_router_otlp_over_limit", overLimit)
+				rejected += overLimit
+				if firstErr == nil {
+					firstErr = &otlpEventLimitError{limit: maxEvents, rejected: overLimit}
+				}
+				break events
+			}
+			processed++
+
+			if !router.checkOTLPIDs(ev.Attributes, invalidIDAction) {
+				continue
+			}
+			isRoot := otlpSpanIsRoot(ev.Attributes)
+			normalizeOTLPFields(ev.Attributes, fieldMappings)
+			eventTime, _ := chooseEventTime(timeSources, time.Time{}, ev.Timestamp)
 			event := &types.Event{
 				Context:     ctx,
 				APIHost:     apiHost,
 				APIKey:      apiKey,
-				Dataset:     batch.Dataset,
+				Dataset:     dataset,
 				Environment: environment,
 				SampleRate:  uint(ev.SampleRate),
-				Timestamp:   ev.Timestamp,
+				Timestamp:   eventTime,
 				Data:        ev.Attributes,
 			}
-			if err = router.processEvent(event, requestID); err != nil {
-				router.Logger.Error().Logf("Error processing event: " + err.Error())
+			if err := router.processEventWithRoot(event, requestID, &isRoot); err != nil {
+				// keep going so that one bad span doesn't cost the rest of
+				// the request, and report the rejections as a partial success
+				router.Metrics.Increment("incoming_router_otlp_rejected")
+				rejected++
+				if firstErr == nil {
+					firstErr = err
+				}
 			}
 		}
 	}
 
+	if rejected > 0 {
+		return &otlpRejectedError{rejected: rejected, err: firstErr}
+	}
 	return nil
 }
 
+// otlpRejectedError is returned by processOTLPRequest when some of the events
+// in a request were rejected, and the rest were processed. It's reported to
+// the client as a partial success, since failing the request would have it
+// resend the events that were processed. err is the first rejection.
+type otlpRejectedError struct {
+	rejected int
+	err      error
+}
+
+func (e *otlpRejectedError) Error() string {
+	return fmt.Sprintf("rejected %d events: %v", e.rejected, e.err)
+}
+
+func (e *otlpRejectedError) Unwrap() error {
+	return e.err
+}
+
+// otlpEventLimitError is the rejection processOTLPRequest reports when a
+// request has more than MaxOTLPEventsPerRequest events. The events up to the
+// limit were processed, and the rest were rejected.
+type otlpEventLimitError struct {
+	limit    int
+	rejected int
+}
+
+func (e *otlpEventLimitError) Error() string {
+	return fmt.Sprintf("request has more than %d events; rejected %d", e.limit, e.rejected)
+}
+
+func countOTLPEvents(batches []huskyotlp.Batch) int {
+	var n int
+	for _, batch := range batches {
+		n += len(batch.Events)
+	}
+	return n
+}
+
+// normalizeOTLPFields copies the span status and W3C trace_state values that
+// husky produces into the stable field names configured in OTLPFieldMappings,
+// so that sampler rules can key on them regardless of whether the span arrived
+// over HTTP or gRPC. Fields that already have a value are never overwritten.
+func normalizeOTLPFields(data map[string]interface{}, mappings config.OTLPFieldMappingsConfig) {
+	setIfAbsent := func(key string, value interface{}) {
+		if key == "" {
+			return
+		}
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+
+	if code, ok := data["status_code"]; ok {
+		setIfAbsent(mappings.StatusCode, code)
+	}
+	if msg, ok := data["status_message"]; ok {
+		setIfAbsent(mappings.StatusMessage, msg)
+	}
+
+	if mappings.TraceStatePrefix == "" {
+		return
+	}
+	traceState, ok := data["trace.trace_state"].(string)
+	if !ok {
+		return
+	}
+	// tracestate is a comma-separated list of key=value pairs, with optional
+	// whitespace around each entry: https://www.w3.org/TR/trace-context/#tracestate-header
+	for _, entry := range strings.Split(traceState, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || key == "" {
+			continue
+		}
+		setIfAbsent(mappings.TraceStatePrefix+key, value)
+	}
+}
+
+// otlpSpanIsRoot reports whether a span translated from OTLP is a root, from
+// the parent span ID that husky put in its attributes. OTLP leaves the parent
+// span ID empty for a root, but husky still sets the field if the ID is
+// present but empty, and an ID of all zeroes is invalid, so neither of those
+// counts as a parent. An empty parent ID is removed so that Honeycomb sees the
+// span as a root too.
+func otlpSpanIsRoot(attrs map[string]interface{}) bool {
+	parentID, ok := attrs["trace.parent_id"]
+	if !ok {
+		return true
+	}
+	id, isString := parentID.(string)
+	if !isString {
+		return false
+	}
+	if strings.Trim(id, "0") != "" {
+		return false
+	}
+	if id == "" {
+		delete(attrs, "trace.parent_id")
+	}
+	return true
+}
+
 func (r *Router) processEvent(ev *types.Event, reqID interface{}) error {
-	debugLog := r.iopLogger.Debug().
-		WithField("request_id", reqID).
+	return r.processEventWithRoot(ev, reqID, nil)
+}
+
+// processEventWithRoot is processEvent for events whose source has already
+// determined whether they're a root span. If isRoot is nil, an event is a
+// root if it has none of the ParentIdFieldNames.
+func (r *Router) processEventWithRoot(ev *types.Event, reqID interface{}, isRoot *bool) error {
+	if !r.dependenciesReady() {
+		return ErrNotReady
+	}
+
+	// everything downstream, sampling included, keys on the canonical name
+	ev.Dataset = r.transformDataset(ev.Dataset)
+
+	debugLog := r.eventDebugLog(ev, reqID).
 		WithString("api_host", ev.APIHost).
 		WithString("dataset", ev.Dataset).
 		WithString("environment", ev.Environment)
@@ -565,108 +1482,481 @@ func (r *Router) processEvent(ev *types.Event, reqID interface{}) error {
 		return nil
 	}
 
-	// extract trace ID
-	var traceID string
-	for _, traceIdFieldName := range r.Config.GetTraceIdFieldNames() {
-		if trID, ok := ev.Data[traceIdFieldName]; ok {
-			traceID = trID.(string)
-			break
+	if r.draining.Load() {
+		debugLog.Logf("rejecting event while draining")
+		return ErrDraining
+	}
+
+	if !r.isDatasetAllowed(ev.Dataset) {
+		r.Metrics.Increment("incoming_router_dataset_denied")
+		debugLog.Logf("rejecting event for dataset that is not allowed")
+		return fmt.Errorf("%w: %s", ErrDatasetNotAllowed, ev.Dataset)
+	}
+
+	if err := r.checkDatasetLimit(ev); err != nil {
+		debugLog.Logf("rejecting event for dataset beyond MaxDistinctDatasets")
+		return err
+	}
+
+	// before checkEventTime, which might clamp the timestamp that the lag
+	// is measured from
+	r.addIngestLag(ev)
+	if err := r.checkEventTime(ev); err != nil {
+		debugLog.Logf("rejecting event with skewed timestamp")
+		return err
+	}
+
+	// redact first, so that nothing sensitive is kept or sent no matter what
+	// happens to the event
+	r.redactFields(ev)
+	r.coerceFieldTypes(ev)
+	r.truncateFieldValues(ev)
+
+	if err := r.enforceSpanLimits(ev, debugLog); err != nil {
+		debugLog.Logf("rejecting oversized event")
+		return err
+	}
+
+	r.normalizeKeyFields(ev)
+
+	if key := r.upstreamAPIKey(ev); key != ev.APIKey {
+		debugLog.WithString("upstream_api_key", redactAPIKey(key)).Logf("using configured upstream API key")
+		ev.APIKey = key
+	}
+
+	r.addNodeMetadata(ev)
+	r.addIncomingSampleRate(ev)
+	r.mirrorEvent(ev)
+
+	// extract trace ID
+	var traceID string
+	if traceIdFieldName, ok := r.traceIDFieldName(ev); ok {
+		var err error
+		value, _ := lookupField(ev.Data, traceIdFieldName)
+		if traceID, err = traceIDToString(value); err != nil {
+			debugLog.WithString("trace_id_field", traceIdFieldName).Logf("rejecting event with invalid trace ID")
+			return fmt.Errorf("%w in field %s: %w", ErrInvalidTraceID, traceIdFieldName, err)
+		}
+		if traceID == "" {
+			r.Metrics.Increment("incoming_router_empty_traceid")
+			switch r.Config.GetEmptyTraceIDAction() {
+			case "reject":
+				debugLog.WithString("trace_id_field", traceIdFieldName).Logf("rejecting event with empty trace ID")
+				return fmt.Errorf("%w: field %s is empty", ErrInvalidTraceID, traceIdFieldName)
+			case "generate":
+				traceID = types.GenerateSpanID()
+				setField(ev.Data, traceIdFieldName, traceID)
+				debugLog.WithString("trace_id_field", traceIdFieldName).Logf("generated trace ID for event with empty trace ID")
+			}
+		}
+	}
+	if traceID == "" {
+		// not part of a trace. send along upstream, unless it's configured
+		// to be dropped or sent somewhere else
+		r.Metrics.Increment("incoming_router_nonspan")
+		r.incrementEnvironmentMetric("incoming_router_nonspan", ev.Environment)
+		// there's no trace to keep consistent, so the event's own field
+		// decides where it goes
+		if dataset := r.routedDataset(ev); dataset != "" {
+			r.Metrics.Increment("incoming_router_dataset_routed")
+			ev.Dataset = dataset
+		}
+		switch r.Config.GetNonTraceEventHandling() {
+		case "drop":
+			r.Metrics.Increment("incoming_router_nonspan_dropped")
+			debugLog.Logf("dropping non-trace event from batch")
+			return nil
+		case "route":
+			if dataset := r.Config.GetNonTraceEventDataset(); dataset != "" {
+				r.Metrics.Increment("incoming_router_nonspan_routed")
+				ev.Dataset = dataset
+			}
+		}
+		debugLog.WithString("api_host", ev.APIHost).
+			WithString("dataset", ev.Dataset).
+			Logf("sending non-trace event from batch")
+		r.UpstreamTransmission.EnqueueEvent(ev)
+		return nil
+	}
+
+	if shard, ok := r.datasetShard(ev.Dataset, traceID); ok {
+		debugLog = debugLog.WithString("dataset_shard", shard)
+		ev.Dataset = shard
+	}
+
+	uniqueID := types.GenerateSpanID()
+	debugLog = debugLog.WithString("trace_id", traceID).WithString("unique_id", uniqueID)
+
+	// check if this is a root span; if we weren't told and can't find a
+	// parent ID, it is.
+	root := true
+	if isRoot != nil {
+		root = *isRoot
+	} else {
+		for _, parentIdFieldName := range r.idFields().parent {
+			if _, hasParent := lookupField(ev.Data, parentIdFieldName); hasParent {
+				root = false
+				break
+			}
+		}
+	}
+
+	span := &types.Span{
+		Event:         *ev,
+		TraceID:       traceID,
+		ID:            uniqueID,
+		IsRoot:        root,
+		RoutedDataset: r.routedDataset(ev),
+		ForceKeep:     r.isForceKept(ev),
+		Error:         r.isErrorSpan(ev),
+		Synthetic:     r.isSynthetic(ev),
+		TraceComplete: r.isTraceComplete(ev),
+	}
+	if span.ForceKeep {
+		r.Metrics.Increment("incoming_router_force_kept")
+		debugLog.Logf("span matches a force keep condition")
+	}
+	if span.RoutedDataset != "" {
+		r.Metrics.Increment("incoming_router_dataset_routed")
+		debugLog.WithString("routed_dataset", span.RoutedDataset).Logf("span routes its trace to a dataset")
+	}
+	if span.Error {
+		r.Metrics.Increment("incoming_router_error_spans")
+		debugLog.Logf("span matches the error trace condition")
+	}
+	if span.Synthetic {
+		debugLog.Logf("span matches the synthetic trace condition")
+	}
+
+	if r.Collector == nil {
+		debugLog.Logf("rejecting span before the collector is ready")
+		return ErrNotReady
+	}
+
+	// we know we're a span, but we need to check if we're in Stress Relief mode;
+	// if we are, then we hash the trace ID to determine if we should process it immediately
+	// based on the hash and current stress levels.
+	// The decision is only saved in the in-memory cache so that we can make the same decision
+	// for all spans in the same trace.
+	// If it's not a trace we should process immediately, we'll add it to the collector
+	if r.Collector.Stressed() {
+		processed, err := r.Collector.ProcessSpanImmediately(span)
+		if err != nil {
+			return err
+		}
+		if processed {
+			// the span was sampled already, so tell the caller the rate
+			// that was applied rather than the one it arrived with
+			ev.SampleRate = span.SampleRate
+			return nil
+		}
+	}
+
+	// we're supposed to handle it normally
+	if err := r.Collector.AddSpan(span); err != nil {
+		r.Metrics.Increment("incoming_router_dropped")
+		debugLog.Logf("Dropping span from batch: %s", err)
+		return err
+	}
+
+	r.Metrics.Increment("incoming_router_span")
+	r.incrementEnvironmentMetric("incoming_router_span", ev.Environment)
+
+	debugLog.WithField("source", "incoming").Logf("Accepting span from batch for collection into a trace")
+	return nil
+}
+
+// incrementEnvironmentMetric records a per-environment copy of the named
+// counter if MetricsPerEnvironment is enabled.
+func (r *Router) incrementEnvironmentMetric(name string, environment string) {
+	if r.environmentMetrics == nil || !r.Config.GetMetricsPerEnvironmentEnabled() {
+		return
+	}
+	r.environmentMetrics.increment(name, environment)
+}
+
+// addTraceIDFromHeaders sets the trace ID field on data from one of the
+// configured TraceHeaders, but only if none of the trace ID fields is already
+// present in the body. It's only used for single events; a header applies to
+// the whole request, so it can't identify the trace of each event in a batch.
+// The expressions are checked when the config is validated.
+func (r *Router) addTraceIDFromHeaders(data map[string]interface{}, header http.Header) {
+	headers := r.Config.GetTraceIdHeaders()
+	if len(headers) == 0 {
+		return
+	}
+	fieldNames := r.idFields().trace
+	if len(fieldNames) == 0 {
+		return
+	}
+	for _, name := range fieldNames {
+		if _, ok := lookupField(data, name); ok {
+			return
+		}
+	}
+
+	names := maps.Keys(headers)
+	sort.Strings(names)
+	for _, name := range names {
+		if traceID := r.extractTraceIDFromHeader(header.Get(name), headers[name]); traceID != "" {
+			data[fieldNames[0]] = traceID
+			return
+		}
+	}
+}
+
+// extractTraceIDFromHeader applies pattern to value and returns the first
+// capture group, or the whole match if there are no groups. An empty pattern
+// returns value unchanged.
+func (r *Router) extractTraceIDFromHeader(value string, pattern string) string {
+	if value == "" || pattern == "" {
+		return value
+	}
+
+	var re *regexp.Regexp
+	if cached, ok := r.traceHeaderRegexes.Load(pattern); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			r.Logger.Error().WithString("pattern", pattern).WithString("error", err.Error()).Logf("invalid TraceHeaders expression")
+			// cache a regexp that never matches so we only log once
+			re = regexp.MustCompile(`a^`)
+		}
+		r.traceHeaderRegexes.Store(pattern, re)
+	}
+
+	match := re.FindStringSubmatch(value)
+	switch {
+	case match == nil:
+		return ""
+	case len(match) > 1:
+		return match[1]
+	default:
+		return match[0]
+	}
+}
+
+// transformDataset applies the DatasetTransforms steps to dataset in order.
+func (r *Router) transformDataset(dataset string) string {
+	for _, entry := range r.Config.GetDatasetTransforms() {
+		var transform *config.DatasetTransform
+		if cached, ok := r.datasetTransforms.Load(entry); ok {
+			transform = cached.(*config.DatasetTransform)
+		} else {
+			t, err := config.ParseDatasetTransform(entry)
+			if err != nil {
+				r.Logger.Error().WithString("transform", entry).WithString("error", err.Error()).Logf("invalid DatasetTransforms entry")
+				// cache it anyway, so we only log once
+			} else {
+				transform = &t
+			}
+			r.datasetTransforms.Store(entry, transform)
+		}
+		if transform != nil {
+			dataset = transform.Apply(dataset)
+		}
+	}
+	return dataset
+}
+
+// isDatasetAllowed reports whether events for the given dataset may be
+// accepted according to the configured allow and deny lists. Deny patterns take
+// precedence, and an empty allow list allows everything.
+func (r *Router) isDatasetAllowed(dataset string) bool {
+	for _, pattern := range r.Config.GetDeniedDatasets() {
+		if matchPattern(pattern, dataset) {
+			return false
 		}
 	}
-	if traceID == "" {
-		// not part of a trace. send along upstream
-		r.Metrics.Increment("incoming_router_nonspan")
-		debugLog.WithString("api_host", ev.APIHost).
-			WithString("dataset", ev.Dataset).
-			Logf("sending non-trace event from batch")
-		r.UpstreamTransmission.EnqueueEvent(ev)
-		return nil
+	allowed := r.Config.GetAllowedDatasets()
+	if len(allowed) == 0 {
+		return true
 	}
+	for _, pattern := range allowed {
+		if matchPattern(pattern, dataset) {
+			return true
+		}
+	}
+	return false
+}
 
-	uniqueID := types.GenerateSpanID()
-	debugLog = debugLog.WithString("trace_id", traceID).WithString("unique_id", uniqueID)
-
-	// check if this is a root span; if we can't find a parent ID, it is.
-	isRoot := true
-	for _, parentIdFieldName := range r.Config.GetParentIdFieldNames() {
-		if _, hasParent := ev.Data[parentIdFieldName]; hasParent {
-			isRoot = false
-			break
+// matchPattern reports whether name matches pattern, where each '*' in
+// pattern matches any sequence of characters (including '/').
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(name, part)
+		if idx < 0 {
+			return false
 		}
+		name = name[idx+len(part):]
 	}
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
 
-	span := &types.Span{
-		Event:   *ev,
-		TraceID: traceID,
-		ID:      uniqueID,
-		IsRoot:  isRoot,
+// decompressRequestBody returns the body of req, decompressed according to its
+// Content-Encoding header. Any handler that accepts a request body can use it.
+func (r *Router) decompressRequestBody(req *http.Request) (io.Reader, error) {
+	// refuse bodies that are too large even before decompression without
+	// reading them at all
+	limit := r.Config.GetMaxRequestBodySize()
+	if req.ContentLength > int64(limit) {
+		return nil, ErrRequestBodyTooLarge
 	}
 
-	// we know we're a span, but we need to check if we're in Stress Relief mode;
-	// if we are, then we hash the trace ID to determine if we should process it immediately
-	// based on the hash and current stress levels.
-	// The decision is only saved in the in-memory cache so that we can make the same decision
-	// for all spans in the same trace.
-	// If it's not a trace we should process immediately, we'll add it to the collector
-	if r.Collector.Stressed() {
-		processed, err := r.Collector.ProcessSpanImmediately(span)
+	switch encoding := req.Header.Get("Content-Encoding"); encoding {
+	case "gzip", "zstd":
+		done, err := r.startDecompression()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if processed {
-			return nil
+		defer done()
+		if encoding == "gzip" {
+			return decompressGzip(req, limit)
 		}
+		return r.decompressZstd(req, limit)
+	default:
+		// the body may be chunked, with no Content-Length to reject it by up
+		// front, so it's bounded as it's read
+		return newBodyLimitReader(req.Body, limit), nil
 	}
+}
 
-	// we're supposed to handle it normally
-	if err := r.Collector.AddSpan(span); err != nil {
-		r.Metrics.Increment("incoming_router_dropped")
-		debugLog.Logf("Dropping span from batch, channel full")
-		return err
+// decompressGzip decompresses req's gzip body.
+func decompressGzip(req *http.Request, limit int) (io.Reader, error) {
+	gzipReader, err := gzip.NewReader(req.Body)
+	if err != nil {
+		return nil, err
 	}
+	defer gzipReader.Close()
 
-	r.Metrics.Increment("incoming_router_span")
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, newBodyLimitReader(gzipReader, limit)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
 
-	debugLog.WithField("source", "incoming").Logf("Accepting span from batch for collection into a trace")
-	return nil
+// decompressZstd decompresses req's zstd body with a decoder from the pool.
+func (r *Router) decompressZstd(req *http.Request, limit int) (io.Reader, error) {
+	zReader, err := r.getZstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	defer func(zReader *zstd.Decoder) {
+		zReader.Reset(nil)
+		r.zstdDecoders <- zReader
+	}(zReader)
+
+	err = zReader.Reset(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, newBodyLimitReader(zReader, limit)); err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
 
-func (r *Router) getMaybeCompressedBody(req *http.Request) (io.Reader, error) {
-	var reader io.Reader
-	switch req.Header.Get("Content-Encoding") {
-	case "gzip":
-		gzipReader, err := gzip.NewReader(req.Body)
-		if err != nil {
-			return nil, err
-		}
-		defer gzipReader.Close()
+// startDecompression counts a request body as being decompressed until the
+// returned func is called. If MaxConcurrentDecompressions bodies already are,
+// it returns ErrDecompressionBusy instead; without a limit, nothing is counted.
+func (r *Router) startDecompression() (func(), error) {
+	max := r.Config.GetMaxConcurrentDecompressions()
+	if max <= 0 {
+		return func() {}, nil
+	}
+	n := r.decompressions.Add(1)
+	if n > int64(max) {
+		r.decompressions.Add(-1)
+		r.Metrics.Increment("decompression_rejected")
+		return nil, ErrDecompressionBusy
+	}
+	r.Metrics.Gauge("decompressions_in_flight", n)
+	return func() {
+		r.Metrics.Gauge("decompressions_in_flight", r.decompressions.Add(-1))
+	}, nil
+}
 
-		buf := &bytes.Buffer{}
-		if _, err := io.Copy(buf, gzipReader); err != nil {
-			return nil, err
-		}
-		reader = buf
-	case "zstd":
-		zReader := <-r.zstdDecoders
-		defer func(zReader *zstd.Decoder) {
-			zReader.Reset(nil)
-			r.zstdDecoders <- zReader
-		}(zReader)
+// isDecoderBusy reports whether err means that a request body couldn't be
+// decompressed for now, so the request should be retried.
+func isDecoderBusy(err error) bool {
+	return errors.Is(err, ErrZstdDecoderBusy) || errors.Is(err, ErrDecompressionBusy)
+}
 
-		err := zReader.Reset(req.Body)
-		if err != nil {
-			return nil, err
-		}
-		buf := &bytes.Buffer{}
-		if _, err := io.Copy(buf, zReader); err != nil {
-			return nil, err
-		}
+// bodyLimitReader reads from r until more than limit bytes have been read,
+// then fails with ErrRequestBodyTooLarge. Unlike io.LimitReader, it doesn't
+// silently truncate the body.
+type bodyLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newBodyLimitReader(r io.Reader, limit int) *bodyLimitReader {
+	return &bodyLimitReader{r: r, remaining: int64(limit)}
+}
+
+func (l *bodyLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrRequestBodyTooLarge
+	}
+	// read one byte more than is allowed, so that a body of exactly the
+	// limit isn't rejected
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrRequestBodyTooLarge
+	}
+	return n, err
+}
 
-		reader = buf
+// getZstdDecoder takes a decoder from the pool. If they're all in use, it
+// waits up to ZstdDecoderWaitTimeout for one to be returned.
+func (r *Router) getZstdDecoder() (*zstd.Decoder, error) {
+	select {
+	case zReader := <-r.zstdDecoders:
+		return zReader, nil
 	default:
-		reader = req.Body
 	}
-	return reader, nil
+
+	r.Metrics.Increment("zstd_decoder_wait")
+	timer := time.NewTimer(r.Config.GetZstdDecoderWaitTimeout())
+	defer timer.Stop()
+	select {
+	case zReader := <-r.zstdDecoders:
+		return zReader, nil
+	case <-timer.C:
+		r.Metrics.Increment("zstd_decoder_timeout")
+		return nil, ErrZstdDecoderBusy
+	}
+}
+
+// bodyReadError chooses the error to return when decompressRequestBody fails.
+func bodyReadError(err error) handlerError {
+	if isDecoderBusy(err) {
+		return ErrDecoderBusy
+	}
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		return ErrRequestTooLarge
+	}
+	if errors.Is(err, ErrBodyChecksumMismatch) {
+		return ErrBodyChecksum
+	}
+	return ErrPostBody
 }
 
 type batchedEvent struct {
@@ -684,6 +1974,20 @@ func (b *batchedEvent) getEventTime() time.Time {
 	return getEventTime(b.Timestamp)
 }
 
+// bodyEventTime returns the time given by a "time" field in the body of a
+// single event, which msgpack clients can send as a timestamp, the same as the
+// time of an event in a batch; other clients can send it as a string.
+func bodyEventTime(data map[string]interface{}) time.Time {
+	var eventTime time.Time
+	switch t := data["time"].(type) {
+	case time.Time:
+		eventTime = t.UTC()
+	case string:
+		eventTime = getEventTime(t)
+	}
+	return eventTime
+}
+
 func (b *batchedEvent) getSampleRate() uint {
 	if b.SampleRate == 0 {
 		return defaultSampleRate
@@ -735,6 +2039,23 @@ func getEventTime(etHeader string) time.Time {
 	return eventTime.UTC()
 }
 
+// closeDecoders takes all num decoders made by makeDecoders out of the pool
+// and closes them, waiting for any that are still in use until ctx is done.
+func closeDecoders(ctx context.Context, zstdDecoders chan *zstd.Decoder, num int) error {
+	if zstdDecoders == nil {
+		return nil
+	}
+	for i := 0; i < num; i++ {
+		select {
+		case zReader := <-zstdDecoders:
+			zReader.Close()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 func makeDecoders(num int) (chan *zstd.Decoder, error) {
 	zstdDecoders := make(chan *zstd.Decoder, num)
 	for i := 0; i < num; i++ {
@@ -753,14 +2074,142 @@ func makeDecoders(num int) (chan *zstd.Decoder, error) {
 }
 
 func unmarshal(r *http.Request, data io.Reader, v interface{}) error {
-	switch r.Header.Get("Content-Type") {
+	if isMsgpackContentType(r.Header.Get("Content-Type")) {
+		return unmarshalMsgpack(data, v)
+	}
+	return jsoniter.NewDecoder(data).Decode(v)
+}
+
+// unmarshalBody is like unmarshal, but honors DecodeJSONNumbers so that large
+// integer IDs survive decoding. Numbers are decoded as json.Number and then
+// converted: the trace and parent ID fields become strings with the exact
+// digits that were sent, and every other number goes back to being an int64
+// (if it's an integer that fits) or a float64, so that it's sent upstream as
+// a number and sampler rules can still compare it.
+func (r *Router) unmarshalBody(req *http.Request, data io.Reader, v interface{}) error {
+	if !r.Config.GetDecodeJSONNumbers() || isMsgpackContentType(req.Header.Get("Content-Type")) {
+		return unmarshal(req, data, v)
+	}
+
+	decoder := jsoniter.NewDecoder(data)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	idFields := r.idFields().traceAndParent
+	switch v := v.(type) {
+	case *map[string]interface{}:
+		convertJSONNumbers(*v, idFields)
+	case *[]batchedEvent:
+		for _, bev := range *v {
+			convertJSONNumbers(bev.Data, idFields)
+		}
+	case *batchedEvent:
+		convertJSONNumbers(v.Data, idFields)
+	}
+	return nil
+}
+
+// convertJSONNumbers replaces the json.Number values in data, as described for
+// unmarshalBody. Nested objects and arrays are converted too, but only the
+// top-level fields are considered to be IDs.
+func convertJSONNumbers(data map[string]interface{}, idFields map[string]struct{}) {
+	// ID fields in nested objects are found by path, since the loop below
+	// only sees the top-level keys
+	for name := range idFields {
+		if !strings.Contains(name, ".") {
+			continue
+		}
+		if n, ok := lookupPath(data, name); ok {
+			if n, ok := n.(json.Number); ok {
+				setField(data, name, n.String())
+			}
+		}
+	}
+	for k, v := range data {
+		if n, ok := v.(json.Number); ok {
+			if _, isID := idFields[k]; isID {
+				data[k] = n.String()
+				continue
+			}
+		}
+		data[k] = convertJSONNumberValue(v)
+	}
+}
+
+func convertJSONNumberValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+	case map[string]interface{}:
+		convertJSONNumbers(value, nil)
+	case []interface{}:
+		for i, elem := range value {
+			value[i] = convertJSONNumberValue(elem)
+		}
+	}
+	return v
+}
+
+// traceIDToString returns the trace ID held in an event field. Trace IDs are
+// normally strings, but numeric IDs are accepted too.
+func traceIDToString(v interface{}) (string, error) {
+	switch id := v.(type) {
+	case string:
+		return id, nil
+	case json.Number:
+		return id.String(), nil
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(id, 10), nil
+	case uint64:
+		return strconv.FormatUint(id, 10), nil
+	case int:
+		return strconv.Itoa(id), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+func isMsgpackContentType(contentType string) bool {
+	switch contentType {
 	case "application/x-msgpack", "application/msgpack":
-		decoder := msgpack.NewDecoder(data)
-		decoder.UseLooseInterfaceDecoding(true)
-		return decoder.Decode(v)
+		return true
 	default:
-		return jsoniter.NewDecoder(data).Decode(v)
+		return false
+	}
+}
+
+func unmarshalMsgpack(data io.Reader, v interface{}) error {
+	decoder := msgpack.NewDecoder(data)
+	decoder.UseLooseInterfaceDecoding(true)
+	return decoder.Decode(v)
+}
+
+// looksLikeMsgpack reports whether the body begins with a msgpack array or map
+// marker. None of these bytes can start a valid JSON document.
+func looksLikeMsgpack(body []byte) bool {
+	if len(body) == 0 {
+		return false
 	}
+	b := body[0]
+	switch {
+	case b >= 0x80 && b <= 0x9f: // fixmap, fixarray
+		return true
+	case b == 0xdc, b == 0xdd: // array 16, array 32
+		return true
+	case b == 0xde, b == 0xdf: // map 16, map 32
+		return true
+	}
+	return false
 }
 
 func getAPIKeyAndDatasetFromMetadata(md metadata.MD) (apiKey string, dataset string) {
@@ -787,18 +2236,57 @@ type environmentCache struct {
 	items map[string]*cacheItem
 	ttl   time.Duration
 	getFn func(string) (string, error)
+
+	// pending holds the lookups in progress, so that concurrent misses for
+	// the same key share a single call to getFn
+	pending map[string]*pendingLookup
+
+	// lookups limits how many calls to getFn are in flight at once; if it's
+	// nil there is no limit. A lookup waits up to lookupWait for a slot.
+	lookups    chan struct{}
+	lookupWait time.Duration
+	metrics    metrics.Metrics
+	// waiting counts the lookups waiting for a slot
+	waiting atomic.Int64
+}
+
+type pendingLookup struct {
+	done  chan struct{}
+	value string
+	err   error
 }
 
 func (r *Router) SetEnvironmentCache(ttl time.Duration, getFn func(string) (string, error)) {
 	r.environmentCache = newEnvironmentCache(ttl, getFn)
 }
 
+// reloadEnvironmentCacheTTL picks up a changed EnvironmentCacheTTL without
+// discarding the entries that are already cached.
+func (r *Router) reloadEnvironmentCacheTTL(cfgHash, rulesHash string) {
+	ttl := r.Config.GetEnvironmentCacheTTL()
+	if r.environmentCache.setTTL(ttl) {
+		r.Logger.Info().WithField("ttl", ttl.String()).Logf("environment cache TTL changed")
+	}
+}
+
 func newEnvironmentCache(ttl time.Duration, getFn func(string) (string, error)) *environmentCache {
 	return &environmentCache{
-		items: make(map[string]*cacheItem),
-		ttl:   ttl,
-		getFn: getFn,
+		items:   make(map[string]*cacheItem),
+		ttl:     ttl,
+		getFn:   getFn,
+		pending: make(map[string]*pendingLookup),
+	}
+}
+
+// limitLookups caps the number of calls to getFn in flight at once. A lookup
+// that can't start within wait fails with ErrEnvironmentLookupBusy. A max of
+// 0 means no limit. It must be called before the cache is used.
+func (c *environmentCache) limitLookups(max int, wait time.Duration, m metrics.Metrics) {
+	if max > 0 {
+		c.lookups = make(chan struct{}, max)
 	}
+	c.lookupWait = wait
+	c.metrics = m
 }
 
 type cacheItem struct {
@@ -806,6 +2294,19 @@ type cacheItem struct {
 	value     string
 }
 
+// setTTL changes the TTL used for new entries; existing entries keep the
+// expiry they were given. It returns true if the TTL changed.
+func (c *environmentCache) setTTL(ttl time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if ttl == c.ttl {
+		return false
+	}
+	c.ttl = ttl
+	return true
+}
+
 // get queries the cached items, returning cache hits that have not expired.
 // Cache missed use the configured getFn to populate the cache.
 func (c *environmentCache) get(key string) (string, error) {
@@ -824,26 +2325,87 @@ func (c *environmentCache) get(key string) (string, error) {
 		return val, nil
 	}
 
-	// get write lock early so we don't execute getFn in parallel so the
-	// the result will be cached before the next lock is acquired to prevent
-	// subsequent calls to getFn for the same key
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	// check if the cache has been populated while waiting for a write lock
 	if item, ok := c.items[key]; ok {
 		if time.Now().Before(item.expiresAt) {
+			c.mutex.Unlock()
 			return item.value, nil
 		}
 	}
+	// if someone else is already looking up this key, wait for their answer
+	if p, ok := c.pending[key]; ok {
+		c.mutex.Unlock()
+		<-p.done
+		return p.value, p.err
+	}
+	p := &pendingLookup{done: make(chan struct{})}
+	c.pending[key] = p
+	c.mutex.Unlock()
 
-	val, err := c.getFn(key)
-	if err != nil {
-		return "", err
+	p.value, p.err = c.lookup(key)
+
+	c.mutex.Lock()
+	if p.err == nil {
+		c.addItem(key, p.value, c.ttl)
+	}
+	delete(c.pending, key)
+	c.mutex.Unlock()
+	close(p.done)
+
+	return p.value, p.err
+}
+
+// lookup calls getFn once there's a free lookup slot.
+func (c *environmentCache) lookup(key string) (string, error) {
+	if c.lookups == nil {
+		return c.getFn(key)
+	}
+
+	select {
+	case c.lookups <- struct{}{}:
+	default:
+		// every slot is taken, so wait for one along with any other lookups
+		// that are queued
+		if err := c.waitForLookup(); err != nil {
+			return "", err
+		}
+	}
+	if c.metrics != nil {
+		c.metrics.Gauge("environment_lookups_in_flight", len(c.lookups))
+	}
+	defer func() {
+		<-c.lookups
+		if c.metrics != nil {
+			c.metrics.Gauge("environment_lookups_in_flight", len(c.lookups))
+		}
+	}()
+
+	return c.getFn(key)
+}
+
+// waitForLookup waits up to lookupWait for a free lookup slot and takes it.
+func (c *environmentCache) waitForLookup() error {
+	c.setWaiting(c.waiting.Add(1))
+	defer func() { c.setWaiting(c.waiting.Add(-1)) }()
+
+	timer := time.NewTimer(c.lookupWait)
+	defer timer.Stop()
+	select {
+	case c.lookups <- struct{}{}:
+		return nil
+	case <-timer.C:
+		if c.metrics != nil {
+			c.metrics.Increment("environment_lookup_rejected")
+		}
+		return ErrEnvironmentLookupBusy
 	}
+}
 
-	c.addItem(key, val, c.ttl)
-	return val, nil
+func (c *environmentCache) setWaiting(n int64) {
+	if c.metrics != nil {
+		c.metrics.Gauge("environment_lookups_waiting", n)
+	}
 }
 
 // addItem create a new cache entry in the environment cache.
@@ -877,11 +2439,111 @@ func (r *Router) getEnvironmentName(apiKey string) (string, error) {
 
 	env, err := r.environmentCache.get(apiKey)
 	if err != nil {
-		return "", err
+		if errors.Is(err, ErrEnvironmentLookupBusy) {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %w", ErrEnvironmentLookupFailed, err)
 	}
 	return env, nil
 }
 
+// getEnvironmentOverride returns the value of the configured
+// EnvironmentOverrideHeader using the given header accessor, or the empty
+// string if the override is disabled or the header isn't present.
+func (r *Router) getEnvironmentOverride(getHeader func(string) string) string {
+	header := r.Config.GetEnvironmentOverrideHeader()
+	if header == "" {
+		return ""
+	}
+	return getHeader(header)
+}
+
+// getEnvironmentOverrideFromMetadata is getEnvironmentOverride for gRPC
+// requests, reading the header from the incoming metadata.
+func (r *Router) getEnvironmentOverrideFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return r.getEnvironmentOverride(func(key string) string {
+		return getFirstValueFromMetadata(key, md)
+	})
+}
+
+// getAdditionalAPIKey returns the value of the first of the
+// AdditionalAPIKeyHeaders that getHeader finds, for requests without either
+// of the standard API key headers.
+func (r *Router) getAdditionalAPIKey(getHeader func(string) string) string {
+	for _, header := range r.Config.GetAdditionalAPIKeyHeaders() {
+		if apiKey := getHeader(header); apiKey != "" {
+			return apiKey
+		}
+	}
+	return ""
+}
+
+// getAdditionalAPIKeyFromMetadata is getAdditionalAPIKey for gRPC requests,
+// reading the headers from the incoming metadata.
+func (r *Router) getAdditionalAPIKeyFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return r.getAdditionalAPIKey(func(key string) string {
+		return getFirstValueFromMetadata(key, md)
+	})
+}
+
+// getEnvironmentNameWithOverride returns the override if one was supplied,
+// skipping the API key lookup entirely; otherwise it behaves like
+// getEnvironmentName.
+func (r *Router) getEnvironmentNameWithOverride(apiKey string, override string) (string, error) {
+	environment := override
+	if override != "" {
+		r.iopLogger.Debug().WithString("environment", override).Logf("using environment from override header")
+	} else {
+		var err error
+		if environment, err = r.getEnvironmentName(apiKey); err != nil {
+			return "", err
+		}
+	}
+	if err := r.checkEnvironmentLimit(environment); err != nil {
+		return "", err
+	}
+	return environment, nil
+}
+
+// resolveEnvironment is getEnvironmentNameWithOverride, with lookup failures
+// handled according to OnEnvironmentLookupFailure. If the events should be
+// sent somewhere other than their own dataset, it's returned as dataset.
+func (r *Router) resolveEnvironment(apiKey string, override string) (environment string, dataset string, err error) {
+	environment, err = r.getEnvironmentNameWithOverride(apiKey, override)
+	if !errors.Is(err, ErrEnvironmentLookupFailed) {
+		return environment, "", err
+	}
+
+	r.Metrics.Increment("incoming_router_environment_lookup_failed")
+	if r.Config.GetOnEnvironmentLookupFailure() != "route" {
+		return "", "", err
+	}
+	r.iopLogger.Debug().WithString("error", err.Error()).Logf("routing events whose environment couldn't be looked up")
+	return "", r.Config.GetEnvironmentLookupFailureDataset(), nil
+}
+
+// environmentError returns the handler error for a failure to work out the
+// environment of a request.
+func environmentError(err error) handlerError {
+	switch {
+	case errors.Is(err, ErrEnvironmentLookupBusy):
+		return ErrEnvironmentBusy
+	case errors.Is(err, ErrEnvironmentLookupFailed):
+		return ErrEnvironmentUnknown
+	case errors.Is(err, ErrEnvironmentLimitExceeded):
+		return ErrEnvironmentLimit
+	}
+	return ErrReqToEvent
+}
+
 func (r *Router) lookupEnvironment(apiKey string) (string, error) {
 	apiEndpoint := r.Config.GetHoneycombAPI()
 	authURL, err := url.Parse(apiEndpoint)
@@ -890,7 +2552,15 @@ func (r *Router) lookupEnvironment(apiKey string) (string, error) {
 	}
 
 	authURL.Path = "/1/auth"
-	req, err := http.NewRequest("GET", authURL.String(), nil)
+	// lookups hold up the requests waiting on them, so they get a deadline of
+	// their own rather than the proxy client's
+	ctx := context.Background()
+	if timeout := r.Config.GetAuthLookupTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL.String(), nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create AuthInfo request. %w", err)
 	}
@@ -911,8 +2581,23 @@ func (r *Router) lookupEnvironment(apiKey string) (string, error) {
 		return "", fmt.Errorf("received %d response for AuthInfo request from Honeycomb API", resp.StatusCode)
 	}
 
+	var body io.Reader = resp.Body
+	maxSize := int64(r.Config.GetMaxAuthResponseSize())
+	if maxSize > 0 {
+		// read one byte more than the limit, so that we can tell when the
+		// response was too large rather than failing to decode it
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("failed reading AuthInfo response from Honeycomb API. %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return "", fmt.Errorf("AuthInfo response from Honeycomb API is larger than MaxAuthResponseSize (%d bytes)", maxSize)
+	}
+
 	authinfo := AuthInfo{}
-	if err := json.NewDecoder(resp.Body).Decode(&authinfo); err != nil {
+	if err := json.Unmarshal(data, &authinfo); err != nil {
 		return "", fmt.Errorf("failed to JSON decode of AuthInfo response from Honeycomb API")
 	}
 	r.Logger.Debug().WithString("environment", authinfo.Environment.Name).Logf("Got environment")
@@ -944,7 +2629,7 @@ func (r *Router) healthchecker() {
 			select {
 			case <-watchticker.C:
 				alive := r.Health.IsAlive()
-				ready := r.Health.IsReady()
+				ready := r.Health.IsReady() && !r.draining.Load()
 
 				// we can just update everything because the grpc health server will only send updates if the status changes
 				setStatus(systemReady, ready)
@@ -957,10 +2642,34 @@ func (r *Router) healthchecker() {
 	}()
 }
 
+// addEventRoutes adds the events and batch APIs to muxxer. Paths with a
+// trailing slash are accepted too, as they are for OTLP; otherwise they'd fall
+// through to the proxy and be sent on without being sampled.
+func (r *Router) addEventRoutes(muxxer *mux.Router) {
+	// require an auth header for events and batches
+	authedMuxxer := muxxer.PathPrefix("/1/").Methods("POST").Subrouter()
+	authedMuxxer.UseEncodedPath()
+	authedMuxxer.Use(r.readinessChecker)
+	authedMuxxer.Use(r.apiKeyChecker)
+
+	// handle events and batches
+	authedMuxxer.HandleFunc("/events/{datasetName}", r.event).Name("event")
+	authedMuxxer.HandleFunc("/events/{datasetName}/", r.event).Name("event")
+	authedMuxxer.HandleFunc("/batch/{datasetName}", r.batch).Name("batch")
+	authedMuxxer.HandleFunc("/batch/{datasetName}/", r.batch).Name("batch")
+
+	refusedMuxxer := muxxer.PathPrefix("/1/").Subrouter()
+	refusedMuxxer.UseEncodedPath()
+	for _, path := range []string{"/events/{datasetName}", "/events/{datasetName}/", "/batch/{datasetName}", "/batch/{datasetName}/"} {
+		r.refuseOtherMethods(refusedMuxxer.Path(path), "POST").Name("method not allowed")
+	}
+}
+
 // AddOTLPMuxxer adds muxxer for OTLP requests
 func (r *Router) AddOTLPMuxxer(muxxer *mux.Router) {
 	// require an auth header for OTLP requests
 	otlpMuxxer := muxxer.PathPrefix("/v1/").Methods("POST").Subrouter()
+	otlpMuxxer.Use(r.readinessChecker)
 
 	// handle OTLP trace requests
 	otlpMuxxer.HandleFunc("/traces", r.postOTLPTrace).Name("otlp_traces")
@@ -969,12 +2678,19 @@ func (r *Router) AddOTLPMuxxer(muxxer *mux.Router) {
 	// handle OTLP logs requests
 	otlpMuxxer.HandleFunc("/logs", r.postOTLPLogs).Name("otlp_logs")
 	otlpMuxxer.HandleFunc("/logs/", r.postOTLPLogs).Name("otlp_logs")
+
+	refusedMuxxer := muxxer.PathPrefix("/v1/").Subrouter()
+	for _, path := range []string{"/traces", "/traces/", "/logs", "/logs/"} {
+		r.refuseOtherMethods(refusedMuxxer.Path(path), "POST").Name("method not allowed")
+	}
 }
 
-func getDatasetFromRequest(req *http.Request) (string, error) {
+// getDatasetFromRequest returns the dataset named in the request's path, or
+// DefaultDataset if it doesn't name one.
+func (r *Router) getDatasetFromRequest(req *http.Request) (string, error) {
 	dataset := mux.Vars(req)["datasetName"]
 	if dataset == "" {
-		return "", fmt.Errorf("missing dataset name")
+		return r.defaultDataset()
 	}
 	dataset, err := url.PathUnescape(dataset)
 	if err != nil {
@@ -982,3 +2698,82 @@ func getDatasetFromRequest(req *http.Request) (string, error) {
 	}
 	return dataset, nil
 }
+
+// defaultDataset returns DefaultDataset, for events that don't name a
+// dataset, or an error if it isn't set.
+func (r *Router) defaultDataset() (string, error) {
+	dataset := r.Config.GetDefaultDataset()
+	if dataset == "" {
+		return "", fmt.Errorf("missing dataset name")
+	}
+	r.Metrics.Increment("incoming_router_default_dataset")
+	return dataset, nil
+}
+
+// applyDefaultOTLPDataset sets DefaultDataset as the dataset of an OTLP
+// request with a Honeycomb Classic API key that doesn't name one, which would
+// otherwise be rejected. Other requests always get a dataset from their
+// service name.
+func (r *Router) applyDefaultOTLPDataset(ri *huskyotlp.RequestInfo) {
+	if ri.Dataset != "" || ri.ApiKey == "" || !types.IsLegacyAPIKey(ri.ApiKey) {
+		return
+	}
+	if dataset, err := r.defaultDataset(); err == nil {
+		ri.Dataset = dataset
+	}
+}
+
+// upstreamAPIKey returns the API key to send ev upstream with: the key
+// configured for its environment or, failing that, its dataset, or else the
+// key the client sent.
+func (r *Router) upstreamAPIKey(ev *types.Event) string {
+	if key := r.Config.GetUpstreamAPIKeyForDest(ev.Environment); key != "" {
+		return key
+	}
+	if key := r.Config.GetUpstreamAPIKeyForDest(ev.Dataset); key != "" {
+		return key
+	}
+	return ev.APIKey
+}
+
+// redactAPIKey hides all but the last few characters of an API key so that
+// it can be logged.
+func redactAPIKey(key string) string {
+	if len(key) < 8 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// getAPIKey returns the API key for the request from the API key headers,
+// then any AdditionalAPIKeyHeaders or, if APIKeyQueryParam is configured and
+// none of the headers is present, from the named query parameter.
+func (r *Router) getAPIKey(req *http.Request) string {
+	apiKey := req.Header.Get(types.APIKeyHeader)
+	if apiKey == "" {
+		apiKey = req.Header.Get(types.APIKeyHeaderShort)
+	}
+	if apiKey == "" {
+		apiKey = r.getAdditionalAPIKey(req.Header.Get)
+	}
+	if apiKey == "" {
+		if param := r.Config.GetAPIKeyQueryParam(); param != "" {
+			apiKey = req.URL.Query().Get(param)
+		}
+	}
+	return apiKey
+}
+
+// redactedURL returns the request URL as a string that is safe to log, with
+// the value of APIKeyQueryParam (if configured and present) replaced.
+func (r *Router) redactedURL(req *http.Request) string {
+	param := r.Config.GetAPIKeyQueryParam()
+	if param == "" || !req.URL.Query().Has(param) {
+		return req.URL.String()
+	}
+	u := *req.URL
+	q := u.Query()
+	q.Set(param, "REDACTED")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -3,13 +3,21 @@ package route
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,6 +35,7 @@ import (
 	"github.com/honeycombio/refinery/redis"
 	"github.com/honeycombio/refinery/sample"
 	"github.com/honeycombio/refinery/transmit"
+	"github.com/honeycombio/refinery/types"
 	"github.com/jonboulle/clockwork"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,6 +47,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/klauspost/compress/zstd"
 	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -51,12 +61,12 @@ func TestDecompression(t *testing.T) {
 		t.Errorf("unexpected err: %s", err.Error())
 	}
 
-	router := &Router{zstdDecoders: decoders}
+	router := &Router{Config: &config.MockConfig{}, zstdDecoders: decoders}
 	req := &http.Request{
 		Body:   io.NopCloser(pReader),
 		Header: http.Header{},
 	}
-	reader, err := router.getMaybeCompressedBody(req)
+	reader, err := router.decompressRequestBody(req)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 	}
@@ -79,7 +89,7 @@ func TestDecompression(t *testing.T) {
 
 	req.Body = io.NopCloser(buf)
 	req.Header.Set("Content-Encoding", "gzip")
-	reader, err = router.getMaybeCompressedBody(req)
+	reader, err = router.decompressRequestBody(req)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 	}
@@ -105,7 +115,7 @@ func TestDecompression(t *testing.T) {
 
 	req.Body = io.NopCloser(buf)
 	req.Header.Set("Content-Encoding", "zstd")
-	reader, err = router.getMaybeCompressedBody(req)
+	reader, err = router.decompressRequestBody(req)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 	}
@@ -119,6 +129,145 @@ func TestDecompression(t *testing.T) {
 	}
 }
 
+func TestZstdDecoderExhaustion(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:      []string{"trace.trace_id"},
+		ZstdDecoderWaitTimeout: 10 * time.Millisecond,
+	})
+
+	newRequest := func() *http.Request {
+		buf := &bytes.Buffer{}
+		zstdW, err := zstd.NewWriter(buf)
+		require.NoError(t, err)
+		zstdW.Write([]byte(`[{"data":{"trace.trace_id":"abc"}}]`))
+		zstdW.Close()
+
+		req := httptest.NewRequest("POST", "/1/batch/dataset", buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "zstd")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		return mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	}
+
+	// take the only decoder, so that the request has to wait and then fail
+	zReader := <-router.zstdDecoders
+	rr := httptest.NewRecorder()
+	router.batch(rr, newRequest())
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), ErrDecoderBusy.msg)
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["zstd_decoder_wait"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["zstd_decoder_timeout"])
+
+	// a decoder returned during the wait is used
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		router.zstdDecoders <- zReader
+	}()
+	router.Config.(*config.MockConfig).ZstdDecoderWaitTimeout = time.Second
+	rr = httptest.NewRecorder()
+	router.batch(rr, newRequest())
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["zstd_decoder_wait"])
+	assert.Equal(t, 1, mockMetrics.CounterIncrements["zstd_decoder_timeout"])
+	assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 1)
+}
+
+func TestMaxConcurrentDecompressions(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:           []string{"trace.trace_id"},
+		MaxConcurrentDecompressions: 1,
+	})
+	body := `[{"data":{"trace.trace_id":"abc"}}]`
+
+	newRequest := func(encoding string) *http.Request {
+		buf := &bytes.Buffer{}
+		switch encoding {
+		case "gzip":
+			w := gzip.NewWriter(buf)
+			w.Write([]byte(body))
+			w.Close()
+		case "zstd":
+			w, err := zstd.NewWriter(buf)
+			require.NoError(t, err)
+			w.Write([]byte(body))
+			w.Close()
+		default:
+			buf.WriteString(body)
+		}
+		req := httptest.NewRequest("POST", "/1/batch/dataset", buf)
+		req.Header.Set("Content-Type", "application/json")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		return mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	}
+
+	// as if another request were being decompressed
+	done, err := router.startDecompression()
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), mockMetrics.GaugeRecords["decompressions_in_flight"])
+
+	for _, encoding := range []string{"gzip", "zstd"} {
+		rr := httptest.NewRecorder()
+		router.batch(rr, newRequest(encoding))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, encoding)
+		assert.Equal(t, "1", rr.Header().Get("Retry-After"), encoding)
+		assert.Contains(t, rr.Body.String(), ErrDecoderBusy.msg, encoding)
+	}
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["decompression_rejected"])
+
+	// uncompressed bodies aren't limited
+	rr := httptest.NewRecorder()
+	router.batch(rr, newRequest(""))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	done()
+	assert.Equal(t, float64(0), mockMetrics.GaugeRecords["decompressions_in_flight"])
+	rr = httptest.NewRecorder()
+	router.batch(rr, newRequest("gzip"))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+	assert.Equal(t, 2, mockMetrics.CounterIncrements["decompression_rejected"])
+	assert.Equal(t, int64(0), router.decompressions.Load())
+}
+
+func TestCloseDecoders(t *testing.T) {
+	for cycle := 0; cycle < 3; cycle++ {
+		decoders, err := makeDecoders(numZstdDecoders)
+		require.NoError(t, err)
+		all := make([]*zstd.Decoder, 0, numZstdDecoders)
+		for i := 0; i < numZstdDecoders; i++ {
+			zReader := <-decoders
+			all = append(all, zReader)
+			decoders <- zReader
+		}
+
+		// one decoder is still in use, and is returned while we wait
+		inUse := <-decoders
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			decoders <- inUse
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		require.NoError(t, closeDecoders(ctx, decoders, numZstdDecoders))
+		cancel()
+
+		assert.Empty(t, decoders)
+		for _, zReader := range all {
+			assert.ErrorIs(t, zReader.Reset(nil), zstd.ErrDecoderClosed)
+		}
+	}
+
+	// a decoder that's never returned doesn't hold up shutdown forever
+	decoders, err := makeDecoders(2)
+	require.NoError(t, err)
+	<-decoders
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, closeDecoders(ctx, decoders, 2), context.DeadlineExceeded)
+}
+
 func unmarshalRequest(w *httptest.ResponseRecorder, content string, body io.Reader) {
 	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var data map[string]interface{}
@@ -325,12 +474,17 @@ func TestDebugTrace(t *testing.T) {
 	req = mux.SetURLVars(req, map[string]string{"traceID": "123abcdef"})
 
 	rr := httptest.NewRecorder()
-	router := &Router{}
+	router := &Router{Config: &config.MockConfig{}}
 
 	router.debugTrace(rr, req)
 	if body := rr.Body.String(); body != `{"traceID":"123abcdef"}` {
 		t.Error(body)
 	}
+
+	rr = httptest.NewRecorder()
+	router.Config = &config.MockConfig{DatasetShards: map[string]int{"big": 4, "unsharded": 1}}
+	router.debugTrace(rr, req)
+	assert.JSONEq(t, `{"traceID":"123abcdef","datasetShards":{"big":"`+shardDatasetName("big", "123abcdef", 4)+`"}}`, rr.Body.String())
 }
 
 func TestOTLPRequest(t *testing.T) {
@@ -397,6 +551,10 @@ func TestDebugAllRules(t *testing.T) {
 			format: "yaml",
 			expect: "RulesVersion: 0\nSamplers:\n    dataset1:\n        DeterministicSampler: {}\n",
 		},
+		{
+			format: "canonical",
+			expect: "{\n  \"rulesversion\": 0,\n  \"samplers\": {\n    \"dataset1\": {\n      \"deterministicsampler\": {\n        \"samplerate\": 0\n      }\n    }\n  }\n}\n",
+		},
 		{
 			format: "bogus",
 			expect: "invalid format 'bogus' when marshaling\n",
@@ -443,6 +601,11 @@ func TestDebugRules(t *testing.T) {
 			dataset: "dataset1",
 			expect:  "FakeSamplerName: FakeSamplerType\n",
 		},
+		{
+			format:  "canonical",
+			dataset: "dataset1",
+			expect:  "{\n  \"FakeSamplerName\": \"FakeSamplerType\"\n}\n",
+		},
 		{
 			format:  "bogus",
 			dataset: "dataset1",
@@ -473,6 +636,28 @@ func TestDebugRules(t *testing.T) {
 	}
 }
 
+func TestCanonicalJSONIsStable(t *testing.T) {
+	samplers := make(map[string]*config.RulesBasedSamplerConfig)
+	for i := 0; i < 50; i++ {
+		samplers[fmt.Sprintf("env%d", i)] = &config.RulesBasedSamplerConfig{
+			Rules: []*config.RulesBasedSamplerRule{
+				{Name: "keep errors", SampleRate: 1, Conditions: []*config.RulesBasedSamplerCondition{{Field: "error", Operator: "exists"}}},
+				{Name: "everything else", SampleRate: 10 + i},
+			},
+		}
+	}
+
+	first, err := canonicalJSON(samplers)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		body, err := canonicalJSON(samplers)
+		require.NoError(t, err)
+		require.Equal(t, string(first), string(body))
+	}
+	assert.Less(t, strings.Index(string(first), `"keep errors"`), strings.Index(string(first), `"everything else"`), "rules must keep their order")
+	assert.NotContains(t, string(first), "null")
+}
+
 func TestDependencyInjection(t *testing.T) {
 	var g inject.Graph
 	basicStore := &centralstore.RedisBasicStore{}
@@ -489,7 +674,10 @@ func TestDependencyInjection(t *testing.T) {
 		&inject.Object{Value: &config.MockConfig{}},
 		&inject.Object{Value: &logger.NullLogger{}},
 		&inject.Object{Value: http.DefaultTransport, Name: "upstreamTransport"},
+		&inject.Object{Value: &transmit.ConnLifetime{}, Name: "connLifetime"},
 		&inject.Object{Value: &transmit.MockTransmission{}, Name: "upstreamTransmission"},
+		&inject.Object{Value: &transmit.MockTransmission{}, Name: "mirrorTransmission"},
+		&inject.Object{Value: &transmit.MockTransmission{}, Name: "shadowTransmission"},
 		&inject.Object{Value: trace.Tracer(noop.Tracer{}), Name: "tracer"},
 		&inject.Object{Value: clockwork.NewRealClock()},
 		&inject.Object{Value: basicStore},
@@ -582,6 +770,164 @@ func TestEnvironmentCache(t *testing.T) {
 	})
 }
 
+func TestEnvironmentCacheLimitLookups(t *testing.T) {
+	t.Run("concurrent misses for the same key share a lookup", func(t *testing.T) {
+		var calls atomic.Int64
+		release := make(chan struct{})
+		cache := newEnvironmentCache(time.Second, func(key string) (string, error) {
+			calls.Add(1)
+			<-release
+			return key + "-env", nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				val, err := cache.get("key")
+				assert.NoError(t, err)
+				assert.Equal(t, "key-env", val)
+			}()
+		}
+		assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int64(1), calls.Load())
+	})
+
+	t.Run("lookups beyond the limit are rejected", func(t *testing.T) {
+		mockMetrics := &metrics.MockMetrics{}
+		mockMetrics.Start()
+		release := make(chan struct{})
+		cache := newEnvironmentCache(time.Second, func(key string) (string, error) {
+			<-release
+			return key + "-env", nil
+		})
+		cache.limitLookups(1, 50*time.Millisecond, mockMetrics)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			val, err := cache.get("slow")
+			assert.NoError(t, err)
+			assert.Equal(t, "slow-env", val)
+		}()
+		assert.Eventually(t, func() bool {
+			v, _ := mockMetrics.Get("environment_lookups_in_flight")
+			return v == 1
+		}, time.Second, time.Millisecond)
+
+		rejected := make(chan error)
+		go func() {
+			_, err := cache.get("other")
+			rejected <- err
+		}()
+		// the lookup is counted as waiting until it gives up
+		assert.Eventually(t, func() bool {
+			v, _ := mockMetrics.Get("environment_lookups_waiting")
+			return v == 1
+		}, time.Second, time.Millisecond)
+		assert.ErrorIs(t, <-rejected, ErrEnvironmentLookupBusy)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["environment_lookup_rejected"])
+		v, _ := mockMetrics.Get("environment_lookups_waiting")
+		assert.Equal(t, float64(0), v)
+
+		// a rejected lookup isn't cached, so it's retried once there's room
+		close(release)
+		<-done
+		val, err := cache.get("other")
+		assert.NoError(t, err)
+		assert.Equal(t, "other-env", val)
+		v, _ = mockMetrics.Get("environment_lookups_in_flight")
+		assert.Equal(t, float64(0), v)
+	})
+}
+
+func TestEnvironmentLookupBusyResponse(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+		return "", ErrEnvironmentLookupBusy
+	})
+
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"foo":"bar"}}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, "abcdefghijklmnopqrstuv")
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	w := httptest.NewRecorder()
+	router.batch(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	otlpErr := newOTLPError(ErrEnvironmentLookupBusy)
+	assert.Equal(t, http.StatusServiceUnavailable, otlpErr.HTTPStatusCode)
+	assert.Equal(t, codes.Unavailable, otlpErr.GRPCStatusCode)
+}
+
+func TestLookupEnvironment(t *testing.T) {
+	var body string
+	var delay time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	router := &Router{
+		Config: &config.MockConfig{
+			GetHoneycombAPIVal:  server.URL,
+			AuthLookupTimeout:   100 * time.Millisecond,
+			MaxAuthResponseSize: 64,
+		},
+		Logger:      &logger.NullLogger{},
+		proxyClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	body = `{"environment":{"name":"prod"}}`
+	env, err := router.lookupEnvironment("key")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", env)
+
+	body = `{"environment":{"name":"prod"},"padding":"` + strings.Repeat("x", 64) + `"}`
+	_, err = router.lookupEnvironment("key")
+	assert.ErrorContains(t, err, "larger than MaxAuthResponseSize")
+
+	body = `{"environment":{"name":"prod"}}`
+	delay = 200 * time.Millisecond
+	_, err = router.lookupEnvironment("key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestEnvironmentCacheTTLReload(t *testing.T) {
+	conf := &config.MockConfig{EnvironmentCacheTTL: time.Hour}
+	router, _ := newBatchTestRouter(t, conf)
+	router.Config.RegisterReloadCallback(router.reloadEnvironmentCacheTTL)
+	router.environmentCache = newEnvironmentCache(conf.GetEnvironmentCacheTTL(), func(key string) (string, error) {
+		return key + "-env", nil
+	})
+
+	_, err := router.environmentCache.get("warm")
+	require.NoError(t, err)
+	warmExpiry := router.environmentCache.items["warm"].expiresAt
+
+	conf.Mux.Lock()
+	conf.EnvironmentCacheTTL = time.Minute
+	conf.Mux.Unlock()
+	conf.ReloadConfig()
+
+	// the warm entry is still there, with its original expiry
+	require.Contains(t, router.environmentCache.items, "warm")
+	assert.Equal(t, warmExpiry, router.environmentCache.items["warm"].expiresAt)
+	assert.Equal(t, time.Minute, router.environmentCache.ttl)
+
+	// new entries use the new TTL
+	_, err = router.environmentCache.get("cold")
+	require.NoError(t, err)
+	coldExpiry := router.environmentCache.items["cold"].expiresAt
+	assert.True(t, coldExpiry.Before(warmExpiry), "new entry should expire before the warm one")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), coldExpiry, 5*time.Second)
+}
+
 func TestGRPCHealthProbeCheck(t *testing.T) {
 	router := &Router{
 		Config: &config.MockConfig{},
@@ -723,14 +1069,1327 @@ func TestGetDatasetFromRequest(t *testing.T) {
 		},
 	}
 
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			req, _ := http.NewRequest("GET", "/1/events/dataset", nil)
 			req = mux.SetURLVars(req, map[string]string{"datasetName": tc.datasetName})
 
-			dataset, err := getDatasetFromRequest(req)
+			dataset, err := router.getDatasetFromRequest(req)
 			assert.Equal(t, tc.expectedError, err)
 			assert.Equal(t, tc.expectedDatasetName, dataset)
 		})
 	}
+
+	t.Run("DefaultDataset", func(t *testing.T) {
+		router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{DefaultDataset: "catch-all"})
+		req, _ := http.NewRequest("GET", "/1/events/", nil)
+		dataset, err := router.getDatasetFromRequest(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "catch-all", dataset)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_default_dataset"])
+
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "foo"})
+		dataset, _ = router.getDatasetFromRequest(req)
+		assert.Equal(t, "foo", dataset)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_default_dataset"])
+	})
+}
+
+func TestNormalizeOTLPFields(t *testing.T) {
+	mappings := config.OTLPFieldMappingsConfig{
+		StatusCode:       "status.code",
+		StatusMessage:    "status.message",
+		TraceStatePrefix: "trace_state.",
+	}
+
+	testCases := []struct {
+		name     string
+		mappings config.OTLPFieldMappingsConfig
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "status and trace state are flattened",
+			mappings: mappings,
+			data: map[string]interface{}{
+				"status_code":       2,
+				"status_message":    "boom",
+				"trace.trace_state": "vendor=abc, other=x=y,,bogus",
+			},
+			expected: map[string]interface{}{
+				"status_code":        2,
+				"status_message":     "boom",
+				"trace.trace_state":  "vendor=abc, other=x=y,,bogus",
+				"status.code":        2,
+				"status.message":     "boom",
+				"trace_state.vendor": "abc",
+				"trace_state.other":  "x=y",
+			},
+		},
+		{
+			name:     "existing values are not overwritten",
+			mappings: mappings,
+			data: map[string]interface{}{
+				"status_code":        0,
+				"status.code":        "custom",
+				"trace.trace_state":  "vendor=abc",
+				"trace_state.vendor": "mine",
+			},
+			expected: map[string]interface{}{
+				"status_code":        0,
+				"status.code":        "custom",
+				"trace.trace_state":  "vendor=abc",
+				"trace_state.vendor": "mine",
+			},
+		},
+		{
+			name:     "empty mappings disable normalization",
+			mappings: config.OTLPFieldMappingsConfig{},
+			data: map[string]interface{}{
+				"status_code":       1,
+				"trace.trace_state": "vendor=abc",
+			},
+			expected: map[string]interface{}{
+				"status_code":       1,
+				"trace.trace_state": "vendor=abc",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			normalizeOTLPFields(tc.data, tc.mappings)
+			assert.Equal(t, tc.expected, tc.data)
+		})
+	}
+}
+
+func TestIsDatasetAllowed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		allowed  []string
+		denied   []string
+		dataset  string
+		expected bool
+	}{
+		{name: "empty lists allow everything", dataset: "anything", expected: true},
+		{name: "exact allow", allowed: []string{"foo"}, dataset: "foo", expected: true},
+		{name: "not in allow list", allowed: []string{"foo"}, dataset: "bar", expected: false},
+		{name: "prefix glob", allowed: []string{"prod-*"}, dataset: "prod-api", expected: true},
+		{name: "glob crosses slashes", allowed: []string{"team/*"}, dataset: "team/a/b", expected: true},
+		{name: "infix glob", allowed: []string{"a*b*c"}, dataset: "axxbyyc", expected: true},
+		{name: "infix glob mismatch", allowed: []string{"a*b*c"}, dataset: "axxcyyb", expected: false},
+		{name: "suffix overlap", allowed: []string{"ab*ba"}, dataset: "aba", expected: false},
+		{name: "denied", denied: []string{"test-*"}, dataset: "test-1", expected: false},
+		{name: "deny wins over allow", allowed: []string{"*"}, denied: []string{"junk"}, dataset: "junk", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := &Router{
+				Config: &config.MockConfig{
+					AllowedDatasets: tc.allowed,
+					DeniedDatasets:  tc.denied,
+				},
+			}
+			assert.Equal(t, tc.expected, router.isDatasetAllowed(tc.dataset))
+		})
+	}
+}
+
+// newBatchTestRouter returns a router with enough dependencies wired up to
+// exercise the event and batch handlers directly.
+func newBatchTestRouter(t *testing.T, conf *config.MockConfig) (*Router, *metrics.MockMetrics) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.Start()
+	mockTransmission := &transmit.MockTransmission{}
+	mockTransmission.Start()
+	decoders, err := makeDecoders(1)
+	require.NoError(t, err)
+	router := &Router{
+		Config:               conf,
+		Metrics:              mockMetrics,
+		UpstreamTransmission: mockTransmission,
+		Collector:            collect.NewMockCollector(),
+		iopLogger: iopLogger{
+			Logger:         &logger.MockLogger{},
+			incomingOrPeer: "incoming",
+		},
+		Logger:           &logger.MockLogger{},
+		zstdDecoders:     decoders,
+		environmentCache: newEnvironmentCache(time.Second, nil),
+	}
+	return router, mockMetrics
+}
+
+// busyCollector is a collector whose incoming queue is always full.
+type busyCollector struct {
+	*collect.MockCollector
+}
+
+func (b busyCollector) AddSpan(*types.Span) error {
+	return collect.ErrWouldBlock
+}
+
+// fullTraceCollector is a collector whose traces always have too many spans.
+type fullTraceCollector struct {
+	*collect.MockCollector
+}
+
+func (f fullTraceCollector) AddSpan(*types.Span) error {
+	return collect.ErrTraceSpanLimit
+}
+
+func TestEventResponses(t *testing.T) {
+	newEventRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/1/events/dataset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		return mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	}
+	newConf := func() *config.MockConfig {
+		return &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}}
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		setup      func(*Router, *config.MockConfig)
+		wantStatus int
+		wantCode   string
+		wantError  string
+	}{
+		{
+			name:       "accepted",
+			body:       `{"trace.trace_id":"abc"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name: "blocked",
+			body: `{"trace.trace_id":"abc"}`,
+			setup: func(r *Router, _ *config.MockConfig) {
+				r.Collector = busyCollector{collect.NewMockCollector()}
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   BatchCodeRateLimited,
+			wantError:  collect.ErrWouldBlock.Error(),
+		},
+		{
+			name: "dataset denied",
+			body: `{"trace.trace_id":"abc"}`,
+			setup: func(_ *Router, c *config.MockConfig) {
+				c.DeniedDatasets = []string{"dataset"}
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   BatchCodeDatasetDenied,
+			wantError:  "dataset not allowed",
+		},
+		{
+			name: "draining",
+			body: `{"trace.trace_id":"abc"}`,
+			setup: func(r *Router, _ *config.MockConfig) {
+				r.draining.Store(true)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   BatchCodeDraining,
+			wantError:  ErrDraining.Error(),
+		},
+		{
+			name: "too large",
+			body: `{"trace.trace_id":"abc","a":1,"b":2}`,
+			setup: func(_ *Router, c *config.MockConfig) {
+				c.MaxSpanAttributes = 2
+				c.OversizedSpanAction = "reject"
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   BatchCodeSpanTooLarge,
+			wantError:  ErrSpanTooLarge.Error(),
+		},
+		{
+			name: "trace too large",
+			body: `{"trace.trace_id":"abc"}`,
+			setup: func(r *Router, _ *config.MockConfig) {
+				r.Collector = fullTraceCollector{collect.NewMockCollector()}
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   BatchCodeTraceTooLarge,
+			wantError:  collect.ErrTraceSpanLimit.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := newConf()
+			router, _ := newBatchTestRouter(t, conf)
+			if tt.setup != nil {
+				tt.setup(router, conf)
+			}
+			rr := httptest.NewRecorder()
+			router.event(rr, newEventRequest(tt.body))
+
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			var resp BatchResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Equal(t, tt.wantCode, resp.Code)
+			if tt.wantError == "" {
+				assert.Empty(t, resp.Error)
+			} else {
+				assert.Contains(t, resp.Error, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("unparseable", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		rr := httptest.NewRecorder()
+		router.event(rr, newEventRequest(`{"trace.trace_id":`))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), ErrReqToEvent.msg)
+	})
+}
+
+func TestEventBodyTimestamp(t *testing.T) {
+	eventTime := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	headerTime := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        map[string]interface{}
+		header      string
+		want        time.Time
+	}{
+		{
+			name:        "msgpack timestamp",
+			contentType: "application/msgpack",
+			body:        map[string]interface{}{"trace.trace_id": "abc", "time": eventTime},
+			want:        eventTime,
+		},
+		{
+			name:        "string time",
+			contentType: "application/json",
+			body:        map[string]interface{}{"trace.trace_id": "abc", "time": eventTime.Format(time.RFC3339Nano)},
+			want:        eventTime,
+		},
+		{
+			name:        "header wins",
+			contentType: "application/msgpack",
+			body:        map[string]interface{}{"trace.trace_id": "abc", "time": eventTime},
+			header:      headerTime.Format(time.RFC3339Nano),
+			want:        headerTime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+
+			buf := &bytes.Buffer{}
+			if tt.contentType == "application/msgpack" {
+				require.NoError(t, msgpack.NewEncoder(buf).Encode(tt.body))
+			} else {
+				require.NoError(t, json.NewEncoder(buf).Encode(tt.body))
+			}
+			req := httptest.NewRequest("POST", "/1/events/dataset", buf)
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			if tt.header != "" {
+				req.Header.Set(types.TimestampHeader, tt.header)
+			}
+			req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+			rr := httptest.NewRecorder()
+			router.event(rr, req)
+			require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+			spans := router.Collector.(*collect.MockCollector).Spans
+			require.Len(t, spans, 1)
+			span := <-spans
+			assert.True(t, tt.want.Equal(span.Timestamp), "got %v", span.Timestamp)
+			if tt.header == "" {
+				assert.NotContains(t, span.Data, "time", "a time used as the timestamp isn't kept as a field")
+			}
+		})
+	}
+}
+
+func TestBatchDeniedDataset(t *testing.T) {
+	router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+		DeniedDatasets: []string{"junk-*"},
+	})
+
+	req := httptest.NewRequest("POST", "/1/batch/junk-1", strings.NewReader(`[{"data":{"foo":"bar"}}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "junk-1"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 1)
+	assert.Equal(t, http.StatusForbidden, responses[0].Status)
+	assert.Contains(t, responses[0].Error, "dataset not allowed")
+
+	count, _ := mockMetrics.Get("incoming_router_dataset_denied")
+	assert.Equal(t, float64(1), count)
+}
+
+func TestBatchMsgpackWithoutContentType(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+
+	buf := &bytes.Buffer{}
+	err := msgpack.NewEncoder(buf).Encode([]map[string]interface{}{
+		{"data": map[string]interface{}{"foo": "bar"}},
+		{"data": map[string]interface{}{"foo": "baz"}},
+	})
+	require.NoError(t, err)
+	require.True(t, looksLikeMsgpack(buf.Bytes()))
+
+	req := httptest.NewRequest("POST", "/1/batch/dataset", buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	for _, resp := range responses {
+		assert.Equal(t, http.StatusAccepted, resp.Status)
+	}
+
+	assert.False(t, looksLikeMsgpack([]byte(`[{"data":{}}]`)))
+	assert.False(t, looksLikeMsgpack(nil))
+}
+
+func TestBatchChunkedGzip(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{"trace.trace_id"}})
+	var transferEncoding []string
+	muxxer := mux.NewRouter()
+	muxxer.HandleFunc("/1/batch/{datasetName}", func(w http.ResponseWriter, req *http.Request) {
+		transferEncoding = req.TransferEncoding
+		router.batch(w, req)
+	})
+	server := httptest.NewServer(muxxer)
+	defer server.Close()
+
+	// post through an io.Pipe so that the client has no length to send and
+	// falls back to chunked encoding
+	post := func(t *testing.T, body []byte) *http.Response {
+		pr, pw := io.Pipe()
+		go func() {
+			gz := gzip.NewWriter(pw)
+			gz.Write(body)
+			pw.CloseWithError(gz.Close())
+		}()
+		req, err := http.NewRequest("POST", server.URL+"/1/batch/dataset", pr)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("within the limit", func(t *testing.T) {
+		resp := post(t, []byte(`[{"data":{"trace.trace_id":"abc"}},{"data":{"trace.trace_id":"def"}}]`))
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"chunked"}, transferEncoding)
+		assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 2)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		body := append([]byte(`[{"data":{"trace.trace_id":"abc","pad":"`), bytes.Repeat([]byte("x"), router.Config.GetMaxRequestBodySize())...)
+		body = append(body, []byte(`"}}]`)...)
+		resp := post(t, body)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+}
+
+func TestBodyLimitReader(t *testing.T) {
+	const limit = 1024 * 1024
+	b, err := io.ReadAll(newBodyLimitReader(bytes.NewReader(make([]byte, limit)), limit))
+	assert.NoError(t, err)
+	assert.Len(t, b, limit)
+
+	_, err = io.ReadAll(newBodyLimitReader(bytes.NewReader(make([]byte, limit+1)), limit))
+	assert.ErrorIs(t, err, ErrRequestBodyTooLarge)
+}
+
+func TestEventTooLarge(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:  []string{"trace.trace_id"},
+		MaxRequestBodySize: 1024,
+	})
+	event := append([]byte(`{"trace.trace_id":"abc","pad":"`), bytes.Repeat([]byte("x"), 2048)...)
+	event = append(event, []byte(`"}`)...)
+
+	post := func(t *testing.T, body []byte, encoding string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/1/events/dataset", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		if chunked {
+			req.ContentLength = -1
+		}
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		w := httptest.NewRecorder()
+		router.event(w, req)
+		return w
+	}
+
+	t.Run("by content length", func(t *testing.T) {
+		w := post(t, event, "", false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "request body is too large")
+	})
+
+	t.Run("as it's read", func(t *testing.T) {
+		w := post(t, event, "", true)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("once decompressed", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write(event)
+		gz.Close()
+		require.Less(t, buf.Len(), 1024)
+		w := post(t, buf.Bytes(), "gzip", false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	assert.Empty(t, router.Collector.(*collect.MockCollector).Spans)
+	assert.Equal(t, http.StatusAccepted, post(t, []byte(`{"trace.trace_id":"abc"}`), "", false).Code)
+	assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 1)
+}
+
+func TestDrain(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	h := &health.Health{Clock: clockwork.NewFakeClock()}
+	require.NoError(t, h.Start())
+	defer h.Stop()
+	h.Register("test", time.Second)
+	h.Ready("test", true)
+	router.Health = h
+
+	rr := httptest.NewRecorder()
+	router.ready(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.startDrain(rr, httptest.NewRequest("POST", "/query/drain", nil))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.JSONEq(t, `{"source":"refinery","draining":true,"remaining_traces":0}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	router.ready(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"trace.trace_id":"abc"}}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr = httptest.NewRecorder()
+	router.batch(rr, req)
+
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, responses[0].Status)
+
+	rr = httptest.NewRecorder()
+	router.getDrainStatus(rr, httptest.NewRequest("GET", "/query/drain", nil))
+	assert.JSONEq(t, `{"source":"refinery","draining":true,"remaining_traces":0}`, rr.Body.String())
+}
+
+func TestBatchNumericTraceID(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		DecodeJSONNumbers: true,
+		TraceIdFieldNames: []string{"trace.trace_id"},
+	})
+
+	const traceID = "1234567890123456789" // not exactly representable as a float64
+	body := `[{"data":{"trace.trace_id":` + traceID + `,"count":9007199254740993,"ratio":1.5,"nested":{"n":3}}}]`
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 1)
+	require.Equal(t, http.StatusAccepted, responses[0].Status, responses[0].Error)
+
+	mockCollector := router.Collector.(*collect.MockCollector)
+	require.Len(t, mockCollector.Spans, 1)
+	span := <-mockCollector.Spans
+	assert.Equal(t, traceID, span.TraceID)
+	// the ID is kept as a string, and other numbers are numbers again
+	assert.Equal(t, traceID, span.Data["trace.trace_id"])
+	assert.Equal(t, int64(9007199254740993), span.Data["count"])
+	assert.Equal(t, 1.5, span.Data["ratio"])
+	assert.Equal(t, map[string]interface{}{"n": int64(3)}, span.Data["nested"])
+}
+
+func TestBatchNestedIDFields(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		DecodeJSONNumbers:  true,
+		TraceIdFieldNames:  []string{"trace.trace_id"},
+		ParentIdFieldNames: []string{"trace.parent_id"},
+	})
+
+	body := `[
+		{"data":{"trace":{"trace_id":1234567890123456789,"parent_id":"p1"}}},
+		{"data":{"trace.trace_id":"flat","trace":{"other":"x"}}}
+	]`
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	for _, resp := range responses {
+		require.Equal(t, http.StatusAccepted, resp.Status, resp.Error)
+	}
+
+	mockCollector := router.Collector.(*collect.MockCollector)
+	require.Len(t, mockCollector.Spans, 2)
+	nested := <-mockCollector.Spans
+	assert.Equal(t, "1234567890123456789", nested.TraceID, "a nested numeric ID should keep its precision")
+	assert.False(t, nested.IsRoot, "a nested parent ID should be found")
+
+	// a flat key is still found when the nested object doesn't have the ID
+	flat := <-mockCollector.Spans
+	assert.Equal(t, "flat", flat.TraceID)
+	assert.True(t, flat.IsRoot)
+}
+
+func TestLookupField(t *testing.T) {
+	data := map[string]interface{}{
+		"trace":          map[string]interface{}{"trace_id": "nested"},
+		"trace.trace_id": "flat",
+		"trace.span_id":  "flat-span",
+		"service":        "svc",
+	}
+	v, ok := lookupField(data, "trace.trace_id")
+	assert.True(t, ok)
+	assert.Equal(t, "nested", v, "the nested path is preferred")
+	v, ok = lookupField(data, "trace.span_id")
+	assert.True(t, ok)
+	assert.Equal(t, "flat-span", v)
+	_, ok = lookupField(data, "service.name")
+	assert.False(t, ok, "a path through a non-object isn't found")
+	v, ok = lookupField(data, "service")
+	assert.True(t, ok)
+	assert.Equal(t, "svc", v)
+
+	setField(data, "trace.trace_id", "set-nested")
+	assert.Equal(t, "set-nested", data["trace"].(map[string]interface{})["trace_id"])
+	assert.Equal(t, "flat", data["trace.trace_id"])
+	setField(data, "trace.span_id", "set-flat")
+	assert.Equal(t, "set-flat", data["trace.span_id"])
+	assert.NotContains(t, data["trace"], "span_id")
+}
+
+func TestBatchInvalidTraceID(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+	})
+
+	body := `[{"data":{"trace.trace_id":12345}},{"data":{"trace.trace_id":{"not":"an id"}}}]`
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+
+	var responses []BatchResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	assert.Equal(t, http.StatusAccepted, responses[0].Status, responses[0].Error)
+	assert.Empty(t, responses[0].Code)
+	assert.Equal(t, http.StatusBadRequest, responses[1].Status)
+	assert.Equal(t, BatchCodeInvalidTraceID, responses[1].Code)
+	assert.Contains(t, responses[1].Error, "invalid trace ID in field trace.trace_id")
+
+	mockCollector := router.Collector.(*collect.MockCollector)
+	require.Len(t, mockCollector.Spans, 1)
+	span := <-mockCollector.Spans
+	assert.Equal(t, "12345", span.TraceID)
+}
+
+func TestBatchEmptyTraceID(t *testing.T) {
+	// the first event has an empty trace ID; the second has none at all
+	body := `[{"data":{"trace.trace_id":"","foo":"bar"}},{"data":{"foo":"baz"}}]`
+	for _, tc := range []struct {
+		action     string
+		wantStatus int
+		wantSpan   bool
+	}{
+		{action: "passthrough", wantStatus: http.StatusAccepted},
+		{action: "reject", wantStatus: http.StatusBadRequest},
+		{action: "generate", wantStatus: http.StatusAccepted, wantSpan: true},
+	} {
+		t.Run(tc.action, func(t *testing.T) {
+			router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:  []string{"trace.trace_id"},
+				EmptyTraceIDAction: tc.action,
+			})
+
+			req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+			rr := httptest.NewRecorder()
+			router.batch(rr, req)
+
+			var responses []BatchResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+			require.Len(t, responses, 2)
+			assert.Equal(t, tc.wantStatus, responses[0].Status, responses[0].Error)
+			if tc.wantStatus == http.StatusBadRequest {
+				assert.Equal(t, BatchCodeInvalidTraceID, responses[0].Code)
+			}
+			// an absent trace ID is never an error
+			assert.Equal(t, http.StatusAccepted, responses[1].Status, responses[1].Error)
+			assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_empty_traceid"])
+
+			mockCollector := router.Collector.(*collect.MockCollector)
+			if tc.wantSpan {
+				require.Len(t, mockCollector.Spans, 1)
+				span := <-mockCollector.Spans
+				assert.NotEmpty(t, span.TraceID)
+				assert.Equal(t, span.TraceID, span.Data["trace.trace_id"])
+				assert.Equal(t, "bar", span.Data["foo"])
+			} else {
+				assert.Len(t, mockCollector.Spans, 0)
+			}
+		})
+	}
+}
+
+func TestEnvironmentOverrideHeader(t *testing.T) {
+	const nonLegacyKey = "abcdefghijklmnopqrstuv"
+
+	t.Run("override skips lookup", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{
+			EnvironmentOverrideHeader: "X-Honeycomb-Environment",
+		})
+		router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+			t.Error("should not look up the environment when the override header is present")
+			return "", nil
+		})
+
+		req := httptest.NewRequest("POST", "/1/events/dataset", nil)
+		req.Header.Set(types.APIKeyHeader, nonLegacyKey)
+		req.Header.Set("X-Honeycomb-Environment", "override-env")
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+
+		ev, err := router.requestToEvent(req, []byte(`{"foo":"bar"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "override-env", ev.Environment)
+
+		md := metadata.New(map[string]string{"x-honeycomb-environment": "grpc-env"})
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		assert.Equal(t, "grpc-env", router.getEnvironmentOverrideFromMetadata(ctx))
+	})
+
+	t.Run("header ignored when not configured", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{})
+		router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+			return "looked-up-env", nil
+		})
+
+		req := httptest.NewRequest("POST", "/1/events/dataset", nil)
+		req.Header.Set(types.APIKeyHeader, nonLegacyKey)
+		req.Header.Set("X-Honeycomb-Environment", "override-env")
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+
+		ev, err := router.requestToEvent(req, []byte(`{"foo":"bar"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "looked-up-env", ev.Environment)
+	})
+}
+
+func TestDatasetTransforms(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		DatasetTransforms: []string{"lowercase", "trimprefix:prod-", "not-a-transform"},
+		DeniedDatasets:    []string{"denied"},
+	})
+
+	for _, tc := range []struct {
+		dataset string
+		want    string
+	}{
+		{"MyService", "myservice"},
+		{"prod-MyService", "myservice"},
+		{"myservice", "myservice"},
+		{"PROD-Denied", ""},
+	} {
+		t.Run(tc.dataset, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/1/batch/"+tc.dataset, strings.NewReader(`[{"data":{"trace.trace_id":"abc"}}]`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			req = mux.SetURLVars(req, map[string]string{"datasetName": tc.dataset})
+			router.batch(httptest.NewRecorder(), req)
+
+			spans := router.Collector.(*collect.MockCollector).Spans
+			if tc.want == "" {
+				// the deny list sees the canonical name
+				assert.Len(t, spans, 0)
+				return
+			}
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.want, (<-spans).Dataset)
+		})
+	}
+}
+
+func TestHoneycombAPIForEnvironment(t *testing.T) {
+	const nonLegacyKey = "abcdefghijklmnopqrstuv"
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		GetHoneycombAPIVal: "https://api.honeycomb.io",
+		HoneycombAPIByEnvironment: map[string]string{
+			"tenant-a": "https://tenant-a.example.com",
+		},
+	})
+	router.environmentCache = newEnvironmentCache(time.Second, func(key string) (string, error) {
+		if key == nonLegacyKey {
+			return "tenant-a", nil
+		}
+		return "tenant-b", nil
+	})
+
+	for _, tc := range []struct {
+		name   string
+		apiKey string
+		want   string
+	}{
+		{name: "configured environment", apiKey: nonLegacyKey, want: "https://tenant-a.example.com"},
+		{name: "other environment", apiKey: "zyxwvutsrqponmlkjihgfe", want: "https://api.honeycomb.io"},
+		{name: "classic key", apiKey: legacyAPIKey, want: "https://api.honeycomb.io"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/1/events/dataset", nil)
+			req.Header.Set(types.APIKeyHeader, tc.apiKey)
+			req.Header.Set("Content-Type", "application/json")
+			req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+
+			ev, err := router.requestToEvent(req, []byte(`{"foo":"bar"}`))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ev.APIHost)
+		})
+	}
+}
+
+func TestGetSamplerRulesStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		samplerErr error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "known dataset",
+			format:     "json",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"FakeSamplerName":"FakeSamplerType"}`,
+		},
+		{
+			name:       "unknown dataset",
+			format:     "json",
+			samplerErr: config.ErrNoSamplerFound,
+			wantStatus: http.StatusNotFound,
+			wantBody:   "got error no sampler found and no default configured trying to fetch config for dataset dataset1\n",
+		},
+		{
+			name:       "internal error",
+			format:     "json",
+			samplerErr: errors.New("boom"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "got error boom trying to fetch config for dataset dataset1\n",
+		},
+		{
+			name:       "bad format",
+			format:     "bogus",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid format 'bogus' when marshaling\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/query/rules/"+tt.format+"/dataset1", nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"format":  tt.format,
+				"dataset": "dataset1",
+			})
+
+			rr := httptest.NewRecorder()
+			router := &Router{
+				Config: &config.MockConfig{
+					GetSamplerTypeVal:  "FakeSamplerType",
+					GetSamplerTypeName: "FakeSamplerName",
+					GetSamplerTypeErr:  tt.samplerErr,
+				},
+			}
+
+			router.getSamplerRules(rr, req)
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, tt.wantBody, rr.Body.String())
+		})
+	}
+}
+
+func TestGetSamplerKeys(t *testing.T) {
+	many := make([]sample.SamplerKey, maxSamplerKeysResponse+1)
+	for i := range many {
+		many[i] = sample.SamplerKey{Key: strconv.Itoa(i), Count: 1}
+	}
+	router := &Router{
+		Collector: &collect.MockCollector{SamplerKeys: map[string][]sample.SamplerKey{
+			"dataset1": {{Key: "200•", Count: 5}, {Key: "500•", Count: 1}},
+			"dataset2": many,
+		}},
+	}
+	get := func(dataset string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/query/sampler-keys/"+dataset, nil)
+		req = mux.SetURLVars(req, map[string]string{"dataset": dataset})
+		rr := httptest.NewRecorder()
+		router.getSamplerKeys(rr, req)
+		return rr
+	}
+
+	rr := get("dataset1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"dataset":"dataset1","keys":[{"key":"200•","count":5},{"key":"500•","count":1}],"truncated":false}`, rr.Body.String())
+
+	rr = get("dataset2")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		Keys      []sample.SamplerKey `json:"keys"`
+		Truncated bool                `json:"truncated"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Len(t, resp.Keys, maxSamplerKeysResponse)
+	assert.True(t, resp.Truncated)
+
+	rr = get("unknown")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetDroppedSamples(t *testing.T) {
+	get := func(router *Router) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.getDroppedSamples(rr, httptest.NewRequest("GET", "/query/dropped-samples", nil))
+		return rr
+	}
+
+	rr := get(&Router{Collector: collect.NewMockCollector()})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = get(&Router{Collector: &collect.MockCollector{DroppedSamples: []collect.DroppedSample{{
+		TraceID:   "trace1",
+		Dataset:   "dataset1",
+		DroppedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		SpanCount: 1,
+		Spans:     []map[string]interface{}{{"name": "span"}},
+	}}}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"samples":[{
+		"trace_id": "trace1",
+		"dataset": "dataset1",
+		"dropped_at": "2024-01-02T03:04:05Z",
+		"span_count": 1,
+		"truncated": false,
+		"spans": [{"name": "span"}]
+	}]}`, rr.Body.String())
+}
+
+func TestGetSamplerResolution(t *testing.T) {
+	get := func(router *Router) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/query/sampler-resolution/dataset1", nil)
+		req = mux.SetURLVars(req, map[string]string{"dataset": "dataset1"})
+		rr := httptest.NewRecorder()
+		router.getSamplerResolution(rr, req)
+		return rr
+	}
+
+	rr := get(&Router{Config: &config.MockConfig{
+		GetSamplerTypeVal:  "FakeSamplerType",
+		GetSamplerTypeName: "FakeSamplerName",
+	}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{
+		"destination": "dataset1",
+		"checked": [{"key": "dataset1", "present": true}],
+		"matched": "dataset1",
+		"used_default": false,
+		"name": "FakeSamplerName",
+		"sampler": "FakeSamplerType",
+		"found": true,
+		"reason": "dataset1 has its own sampler"
+	}`, rr.Body.String())
+
+	rr = get(&Router{Config: &config.MockConfig{GetSamplerTypeErr: config.ErrNoSamplerFound}})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"found":false`)
+}
+
+func TestTraceIDFromHeaders(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id", "traceId"},
+		TraceIdHeaders: map[string]string{
+			"X-Amzn-Trace-Id":       `Root=([^;]+)`,
+			"X-Cloud-Trace-Context": `^[0-9a-f]+`,
+			"X-Plain-Trace":         "",
+		},
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		data    map[string]interface{}
+		want    interface{}
+	}{
+		{
+			name:    "capture group",
+			headers: map[string]string{"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"},
+			want:    "1-5759e988-bd862e3fe1be46a994272793",
+		},
+		{
+			name:    "whole match",
+			headers: map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			want:    "105445aa7843bc8bf206b12000100000",
+		},
+		{
+			name:    "empty pattern uses whole value",
+			headers: map[string]string{"X-Plain-Trace": "abc123"},
+			want:    "abc123",
+		},
+		{
+			name:    "first header alphabetically wins",
+			headers: map[string]string{"X-Plain-Trace": "plain", "X-Amzn-Trace-Id": "Root=amzn"},
+			want:    "amzn",
+		},
+		{
+			name:    "body field takes precedence",
+			headers: map[string]string{"X-Plain-Trace": "abc123"},
+			data:    map[string]interface{}{"traceId": "from-body"},
+			want:    nil,
+		},
+		{
+			name:    "no match",
+			headers: map[string]string{"X-Amzn-Trace-Id": "Self=1-abc"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.headers {
+				header.Set(k, v)
+			}
+			data := tt.data
+			if data == nil {
+				data = map[string]interface{}{}
+			}
+			router.addTraceIDFromHeaders(data, header)
+			assert.Equal(t, tt.want, data["trace.trace_id"])
+		})
+	}
+}
+
+func TestTraceIDFromHeadersNotAppliedToBatches(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		TraceIdHeaders:    map[string]string{"X-Plain-Trace": ""},
+	})
+
+	req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(`[{"data":{"a":1}},{"data":{"b":2}}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+	req.Header.Set("X-Plain-Trace", "abc123")
+	req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+	rr := httptest.NewRecorder()
+	router.batch(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// neither event has a trace ID, so both go straight upstream
+	mockCollector := router.Collector.(*collect.MockCollector)
+	assert.Len(t, mockCollector.Spans, 0)
+	mockTransmission := router.UpstreamTransmission.(*transmit.MockTransmission)
+	assert.Len(t, mockTransmission.Events, 2)
+}
+
+func TestListenUnix(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	dir := t.TempDir()
+
+	t.Run("removes a stale socket", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		stale, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+		require.FileExists(t, path)
+
+		l, err := router.listenUnix(path)
+		require.NoError(t, err)
+		defer l.Close()
+
+		go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}}
+		resp, err := client.Get("http://refinery/alive")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+	})
+
+	t.Run("leaves a socket that's in use", func(t *testing.T) {
+		path := filepath.Join(dir, "busy.sock")
+		busy, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer busy.Close()
+
+		_, err = router.listenUnix(path)
+		assert.ErrorContains(t, err, "already in use")
+	})
+
+	t.Run("refuses to replace a file", func(t *testing.T) {
+		path := filepath.Join(dir, "file.sock")
+		require.NoError(t, os.WriteFile(path, []byte("not a socket"), 0o600))
+
+		_, err := router.listenUnix(path)
+		assert.ErrorContains(t, err, "not a socket")
+		assert.FileExists(t, path)
+	})
+}
+
+func TestOTLPOnlyMuxxer(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	muxxer := router.otlpOnlyMuxxer()
+
+	for _, tc := range []struct {
+		method, path string
+		matched      bool
+	}{
+		{"POST", "/v1/traces", true},
+		{"POST", "/v1/logs", true},
+		{"GET", "/alive", true},
+		{"GET", "/ready", true},
+		{"POST", "/1/events/dataset", false},
+		{"POST", "/1/batch/dataset", false},
+		{"POST", "/api/traces", false},
+		{"GET", "/query/drain", false},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			var match mux.RouteMatch
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			assert.Equal(t, tc.matched, muxxer.Match(req, &match))
+		})
+	}
+}
+
+func TestCheckPeerListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		addr     string
+		wantLogs int
+		wantErr  bool
+	}{
+		{name: "unset", addr: ""},
+		{name: "resolves", addr: "127.0.0.1:8081", wantLogs: 1},
+		{name: "bad address", addr: "127.0.0.1", wantLogs: 1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockLogger := &logger.MockLogger{}
+			router := &Router{
+				Logger: mockLogger,
+				Config: &config.MockConfig{GetPeerListenAddrVal: tc.addr},
+			}
+			router.checkPeerListenAddr()
+
+			require.Len(t, mockLogger.Events, tc.wantLogs)
+			if tc.wantLogs == 0 {
+				return
+			}
+			fields := mockLogger.Events[0].Fields
+			assert.Equal(t, "peer", fields["router_iop"])
+			assert.Equal(t, tc.wantErr, fields["error"] != nil)
+		})
+	}
+}
+
+func TestUpstreamAPIKey(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		UpstreamAPIKeys: map[string]string{
+			"production": "upstream-production-key",
+			"frontend":   "upstream-frontend-key",
+		},
+	})
+
+	tests := []struct {
+		name        string
+		environment string
+		dataset     string
+		want        string
+	}{
+		{name: "environment override", environment: "production", dataset: "frontend", want: "upstream-production-key"},
+		{name: "dataset override", environment: "staging", dataset: "frontend", want: "upstream-frontend-key"},
+		{name: "classic key by dataset", dataset: "frontend", want: "upstream-frontend-key"},
+		{name: "fallback to client key", environment: "staging", dataset: "backend", want: "client-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &types.Event{
+				Context:     context.Background(),
+				APIKey:      "client-key",
+				Dataset:     tt.dataset,
+				Environment: tt.environment,
+				Data:        map[string]interface{}{"trace.trace_id": "abc"},
+			}
+			require.NoError(t, router.processEvent(ev, nil))
+			span := <-router.Collector.(*collect.MockCollector).Spans
+			assert.Equal(t, tt.want, span.APIKey)
+		})
+	}
+}
+
+func TestRedactAPIKey(t *testing.T) {
+	assert.Equal(t, "****", redactAPIKey("short"))
+	assert.Equal(t, "****6789", redactAPIKey("abcdef0123456789"))
+}
+
+type circuitBreakerTransmission struct {
+	transmit.MockTransmission
+	stats transmit.CircuitBreakerStats
+}
+
+func (c *circuitBreakerTransmission) CircuitBreakerStats() transmit.CircuitBreakerStats {
+	return c.stats
+}
+
+func TestGetStats(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{})
+	router.UpstreamTransmission = &circuitBreakerTransmission{
+		stats: transmit.CircuitBreakerStats{State: transmit.CircuitOpen, ConsecutiveFailures: 5, Dropped: 12},
+	}
+	router.MirrorTransmission = &transmit.MockTransmission{}
+
+	rr := httptest.NewRecorder()
+	router.getStats(rr, httptest.NewRequest("GET", "/query/stats", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var got map[string]map[string]transmit.CircuitBreakerStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, transmit.CircuitOpen, got["upstream"]["circuit_breaker"].State)
+	assert.Equal(t, int64(12), got["upstream"]["circuit_breaker"].Dropped)
+	assert.NotContains(t, got, "mirror", "transmissions without a breaker aren't reported")
+}
+
+func TestEventRoutes(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames: []string{"trace.trace_id"},
+		DatasetTransforms: []string{"lowercase"},
+	})
+	muxxer := mux.NewRouter()
+	router.addEventRoutes(muxxer)
+	proxied := false
+	muxxer.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, req *http.Request) { proxied = true })
+
+	for _, tt := range []struct {
+		path, body string
+		code       int
+	}{
+		{"/1/batch/MyDataset", `[{"data":{"trace.trace_id":"abc"}}]`, http.StatusOK},
+		{"/1/batch/MyDataset/", `[{"data":{"trace.trace_id":"abc"}}]`, http.StatusOK},
+		{"/1/batch/mydataset/", `[{"data":{"trace.trace_id":"abc"}}]`, http.StatusOK},
+		{"/1/batch/MYDATASET", `[{"data":{"trace.trace_id":"abc"}}]`, http.StatusOK},
+		{"/1/events/MyDataset", `{"trace.trace_id":"abc"}`, http.StatusAccepted},
+		{"/1/events/MyDataset/", `{"trace.trace_id":"abc"}`, http.StatusAccepted},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			w := httptest.NewRecorder()
+			muxxer.ServeHTTP(w, req)
+
+			assert.False(t, proxied, "the request shouldn't be proxied")
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+			spans := router.Collector.(*collect.MockCollector).Spans
+			require.Len(t, spans, 1)
+			assert.Equal(t, "mydataset", (<-spans).Dataset)
+		})
+	}
+}
+
+func TestNonTraceEventHandling(t *testing.T) {
+	for _, tt := range []struct {
+		handling string
+		dataset  string
+		want     string
+		metric   string
+	}{
+		{"", "", "logs", ""},
+		{"forward", "", "logs", ""},
+		{"drop", "", "", "incoming_router_nonspan_dropped"},
+		{"route", "non-trace", "non-trace", "incoming_router_nonspan_routed"},
+		{"route", "", "logs", ""},
+	} {
+		t.Run(tt.handling+"/"+tt.dataset, func(t *testing.T) {
+			router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:     []string{"trace.trace_id"},
+				NonTraceEventHandling: tt.handling,
+				NonTraceEventDataset:  tt.dataset,
+			})
+			require.NoError(t, router.processEvent(&types.Event{
+				Dataset: "logs",
+				Data:    map[string]interface{}{"message": "hello"},
+			}, nil))
+
+			assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_nonspan"])
+			if tt.metric != "" {
+				assert.Equal(t, 1, mockMetrics.CounterIncrements[tt.metric])
+			}
+			events := router.UpstreamTransmission.(*transmit.MockTransmission).Events
+			if tt.want == "" {
+				assert.Empty(t, events)
+				return
+			}
+			require.Len(t, events, 1)
+			assert.Equal(t, tt.want, events[0].Dataset)
+			assert.Empty(t, router.Collector.(*collect.MockCollector).Spans)
+		})
+	}
 }
@@ -0,0 +1,207 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+const (
+	defaultSelfTestSpans   = 100
+	maxSelfTestSpans       = 10000
+	defaultSelfTestTraces  = 10
+	maxSelfTestTraces      = 1000
+	defaultSelfTestDataset = "refinery-selftest"
+	maxSelfTestWait        = 5 * time.Minute
+)
+
+// selfTestParams are the query parameters accepted by /query/selftest.
+type selfTestParams struct {
+	spans        int
+	traces       int
+	dataset      string
+	environment  string
+	sendUpstream bool
+	wait         time.Duration
+}
+
+func parseSelfTestParams(req *http.Request) (selfTestParams, error) {
+	q := req.URL.Query()
+	p := selfTestParams{
+		spans:       defaultSelfTestSpans,
+		traces:      defaultSelfTestTraces,
+		dataset:     q.Get("dataset"),
+		environment: q.Get("environment"),
+	}
+	if p.dataset == "" {
+		p.dataset = defaultSelfTestDataset
+	}
+
+	var err error
+	if s := q.Get("spans"); s != "" {
+		if p.spans, err = strconv.Atoi(s); err != nil || p.spans < 1 || p.spans > maxSelfTestSpans {
+			return p, fmt.Errorf("spans must be between 1 and %d", maxSelfTestSpans)
+		}
+	}
+	if s := q.Get("traces"); s != "" {
+		if p.traces, err = strconv.Atoi(s); err != nil || p.traces < 1 || p.traces > maxSelfTestTraces {
+			return p, fmt.Errorf("traces must be between 1 and %d", maxSelfTestTraces)
+		}
+	}
+	if p.traces > p.spans {
+		return p, errors.New("traces must not be greater than spans")
+	}
+	if s := q.Get("send_upstream"); s != "" {
+		if p.sendUpstream, err = strconv.ParseBool(s); err != nil {
+			return p, errors.New("send_upstream must be a boolean")
+		}
+	}
+	if s := q.Get("wait"); s != "" {
+		if p.wait, err = time.ParseDuration(s); err != nil || p.wait < 0 || p.wait > maxSelfTestWait {
+			return p, fmt.Errorf("wait must be a duration no longer than %s", maxSelfTestWait)
+		}
+	}
+	return p, nil
+}
+
+// firstOr returns the first of names, or fallback if there are none.
+func firstOr(names []string, fallback string) string {
+	if len(names) == 0 {
+		return fallback
+	}
+	return names[0]
+}
+
+// selfTest generates synthetic spans and pushes them through processEvent, so
+// that they're collected and sampled exactly like real traffic. Unless the
+// caller asks for send_upstream (and Refinery isn't in dry run mode), the
+// transmission drops the spans rather than sending them.
+//
+// Sampling decisions are made asynchronously once the traces complete, so by
+// default the response only covers ingestion. If wait is given, the handler
+// waits up to that long for every span to be kept before reporting how many
+// were; spans that weren't were either dropped or still undecided.
+//
+// Only one self test runs at a time, so that repeated calls can't fill the
+// collector's queue.
+func (r *Router) selfTest(w http.ResponseWriter, req *http.Request) {
+	params, err := parseSelfTestParams(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "error": err.Error()}, "json")
+		return
+	}
+
+	apiHost := r.Config.GetHoneycombAPI()
+	apiKey := r.getAPIKey(req)
+	sendUpstream := params.sendUpstream && !r.Config.GetIsDryRunForEnvironment(params.environment)
+	if sendUpstream && !r.Config.IsAPIKeyValid(apiKey) {
+		r.handlerReturnWithError(w, ErrAuthNeeded, errors.New("send_upstream requires a valid API key"))
+		return
+	}
+
+	if !r.selfTestRunning.CompareAndSwap(false, true) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		r.marshalToFormat(w, map[string]interface{}{"source": "refinery", "error": "a self test is already running"}, "json")
+		return
+	}
+	defer r.selfTestRunning.Store(false)
+
+	runID := types.GenerateSpanID()
+	run := &types.SelfTestRun{SendUpstream: sendUpstream}
+	traceIDField := firstOr(r.Config.GetTraceIdFieldNames(), "trace.trace_id")
+	parentIDField := firstOr(r.Config.GetParentIdFieldNames(), "trace.parent_id")
+	spanIDField := firstOr(r.Config.GetSpanIdFieldNames(), "trace.span_id")
+
+	r.iopLogger.Info().
+		WithString("run_id", runID).
+		WithField("spans", params.spans).
+		WithField("traces", params.traces).
+		WithField("send_upstream", sendUpstream).
+		Logf("starting self test")
+
+	var accepted, rejected int
+	errs := make(map[string]int)
+	start := time.Now()
+	for i := 0; i < params.spans; i++ {
+		trace := i % params.traces
+		traceID := fmt.Sprintf("selftest-%s-%d", runID, trace)
+		data := map[string]interface{}{
+			traceIDField:           traceID,
+			spanIDField:            fmt.Sprintf("%s-%d", traceID, i),
+			"name":                 "refinery-selftest",
+			"meta.refinery.run_id": runID,
+		}
+		// the first span of each trace is its root; the rest are its children
+		if i >= params.traces {
+			data[parentIDField] = fmt.Sprintf("%s-%d", traceID, trace)
+		}
+
+		ev := &types.Event{
+			Context:     context.Background(),
+			APIHost:     apiHost,
+			APIKey:      apiKey,
+			Dataset:     params.dataset,
+			Environment: params.environment,
+			SampleRate:  1,
+			Timestamp:   time.Now().UTC(),
+			Data:        data,
+		}
+		ev.MarkSelfTest(run)
+		if err := r.processEvent(ev, runID); err != nil {
+			rejected++
+			errs[err.Error()]++
+			continue
+		}
+		accepted++
+	}
+	duration := time.Since(start)
+
+	waited := r.waitForSelfTest(req.Context(), run, accepted, params.wait)
+	kept := run.Kept()
+
+	r.marshalToFormat(w, map[string]interface{}{
+		"source":        "refinery",
+		"run_id":        runID,
+		"spans":         params.spans,
+		"traces":        params.traces,
+		"accepted":      accepted,
+		"rejected":      rejected,
+		"errors":        errs,
+		"duration_ms":   duration.Milliseconds(),
+		"waited_ms":     waited.Milliseconds(),
+		"kept":          kept,
+		"not_kept":      int64(accepted) - kept,
+		"dry_run":       r.Config.GetIsDryRunForEnvironment(params.environment),
+		"send_upstream": sendUpstream,
+	}, "json")
+}
+
+// waitForSelfTest waits until all accepted spans of the run have been kept,
+// the wait has passed, or the request is cancelled, and returns how long it
+// waited.
+func (r *Router) waitForSelfTest(ctx context.Context, run *types.SelfTestRun, accepted int, wait time.Duration) time.Duration {
+	start := time.Now()
+	if wait <= 0 {
+		return 0
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for run.Kept() < int64(accepted) {
+		select {
+		case <-ctx.Done():
+			return time.Since(start)
+		case <-timer.C:
+			return time.Since(start)
+		case <-ticker.C:
+		}
+	}
+	return time.Since(start)
+}
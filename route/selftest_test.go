@@ -0,0 +1,116 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	newConf := func() *config.MockConfig {
+		return &config.MockConfig{
+			TraceIdFieldNames:  []string{"trace.trace_id"},
+			ParentIdFieldNames: []string{"trace.parent_id"},
+		}
+	}
+
+	t.Run("marks synthetic spans", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		rr := httptest.NewRecorder()
+		router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest?spans=20&traces=4", nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.EqualValues(t, 20, resp["accepted"])
+		assert.EqualValues(t, 0, resp["rejected"])
+		assert.Equal(t, false, resp["send_upstream"])
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 20)
+		traces := make(map[string]int)
+		roots := 0
+		for i := 0; i < 20; i++ {
+			sp := <-spans
+			assert.Equal(t, defaultSelfTestDataset, sp.Dataset)
+			require.NotNil(t, sp.SelfTest())
+			assert.False(t, sp.SelfTest().SendUpstream)
+			assert.NotContains(t, sp.Data, "meta.refinery.selftest")
+			traces[sp.TraceID]++
+			if sp.IsRoot {
+				roots++
+			}
+		}
+		assert.Len(t, traces, 4)
+		assert.Equal(t, 4, roots)
+	})
+
+	t.Run("dry run never sends upstream", func(t *testing.T) {
+		dryRunConf := newConf()
+		dryRunConf.DryRun = true
+		router, _ := newBatchTestRouter(t, dryRunConf)
+		rr := httptest.NewRecorder()
+		router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest?spans=2&traces=1&send_upstream=true", nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 2)
+		for i := 0; i < 2; i++ {
+			assert.False(t, (<-spans).SelfTest().SendUpstream)
+		}
+	})
+
+	t.Run("waits for decisions", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		spans := router.Collector.(*collect.MockCollector).Spans
+		// stand in for the collector and transmission, keeping every other span
+		go func() {
+			for i := 0; i < 10; i++ {
+				if sp := <-spans; i%2 == 0 {
+					sp.SelfTest().RecordKept()
+				}
+			}
+		}()
+
+		rr := httptest.NewRecorder()
+		router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest?spans=10&traces=2&wait=200ms", nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.EqualValues(t, 5, resp["kept"])
+		assert.EqualValues(t, 5, resp["not_kept"])
+		assert.GreaterOrEqual(t, resp["waited_ms"], float64(200))
+	})
+
+	t.Run("uses default field names when none are configured", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, &config.MockConfig{TraceIdFieldNames: []string{}, ParentIdFieldNames: []string{}})
+		rr := httptest.NewRecorder()
+		router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest?spans=1&traces=1", nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("only one runs at a time", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		router.selfTestRunning.Store(true)
+		rr := httptest.NewRecorder()
+		router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest", nil))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Empty(t, router.Collector.(*collect.MockCollector).Spans)
+	})
+
+	for _, query := range []string{"spans=0", "spans=100000", "traces=5000", "spans=2&traces=3", "send_upstream=maybe", "wait=1h", "wait=soon"} {
+		t.Run("rejects "+query, func(t *testing.T) {
+			router, _ := newBatchTestRouter(t, newConf())
+			rr := httptest.NewRecorder()
+			router.selfTest(rr, httptest.NewRequest("POST", "/query/selftest?"+query, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Empty(t, router.Collector.(*collect.MockCollector).Spans)
+		})
+	}
+}
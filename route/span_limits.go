@@ -0,0 +1,159 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/types"
+)
+
+// ErrSpanTooLarge is returned by processEvent when an event exceeds
+// MaxSpanAttributes or MaxSpanBytes and OversizedSpanAction is "reject".
+var ErrSpanTooLarge = errors.New("span exceeds the configured size limits")
+
+const truncatedFieldsFieldName = "meta.refinery.truncated_fields"
+
+// enforceSpanLimits checks an event against the configured size limits. If
+// it's too large, it's either truncated in place or rejected with
+// ErrSpanTooLarge, depending on OversizedSpanAction. Truncation is logged to
+// debugLog, the event's debug log entry.
+func (r *Router) enforceSpanLimits(ev *types.Event, debugLog logger.Entry) error {
+	maxFields := r.Config.GetMaxSpanAttributes()
+	maxBytes := r.Config.GetMaxSpanBytes()
+	if maxFields <= 0 && maxBytes <= 0 {
+		return nil
+	}
+
+	size := 0
+	if maxBytes > 0 {
+		for k, v := range ev.Data {
+			size += fieldSize(k, v)
+		}
+	}
+	tooManyFields := maxFields > 0 && len(ev.Data) > maxFields
+	tooManyBytes := maxBytes > 0 && size > maxBytes
+	if !tooManyFields && !tooManyBytes {
+		return nil
+	}
+
+	if r.Config.GetOversizedSpanAction() == "reject" {
+		r.Metrics.Increment("incoming_router_span_rejected")
+		return fmt.Errorf("%w: %d fields, about %d bytes", ErrSpanTooLarge, len(ev.Data), size)
+	}
+
+	protected := r.protectedSpanFields(ev)
+
+	keptFields, keptBytes := 0, 0
+	others := make([]string, 0, len(ev.Data))
+	for k, v := range ev.Data {
+		if _, ok := protected[k]; ok {
+			keptFields++
+			keptBytes += fieldSize(k, v)
+			continue
+		}
+		others = append(others, k)
+	}
+	// sort so that the same fields are kept from every span of a given shape
+	sort.Strings(others)
+
+	// the marker we add counts against the limits too
+	if maxFields > 0 {
+		maxFields--
+	}
+	if maxBytes > 0 {
+		maxBytes -= fieldSize(truncatedFieldsFieldName, 0)
+	}
+	dropped := 0
+	for _, k := range others {
+		sz := fieldSize(k, ev.Data[k])
+		if (maxFields > 0 && keptFields >= maxFields) || (maxBytes > 0 && keptBytes+sz > maxBytes) {
+			delete(ev.Data, k)
+			dropped++
+			continue
+		}
+		keptFields++
+		keptBytes += sz
+	}
+	ev.Data[truncatedFieldsFieldName] = dropped
+
+	r.Metrics.Increment("incoming_router_span_truncated")
+	debugLog.WithField("dropped_fields", dropped).Logf("truncated oversized span")
+	return nil
+}
+
+// standardSpanFields are kept when truncating, because the Honeycomb UI and
+// most queries depend on them.
+var standardSpanFields = []string{
+	"name",
+	"service.name",
+	"duration_ms",
+	"status_code",
+	"status_message",
+	"error",
+	"meta.annotation_type",
+	"trace.span_id",
+}
+
+// protectedSpanFields returns the fields that truncation never drops: the ID
+// fields, so the trace still assembles; the fields the event's sampler looks
+// at, so the sampling decision doesn't change; and the standard span fields.
+func (r *Router) protectedSpanFields(ev *types.Event) map[string]struct{} {
+	protected := make(map[string]struct{})
+	add := func(names []string) {
+		for _, name := range names {
+			protected[name] = struct{}{}
+		}
+	}
+	idFields := r.idFields()
+	add(idFields.trace)
+	add(idFields.parent)
+	add(idFields.span)
+	add(standardSpanFields)
+
+	selector := types.SamplerSelector(ev.APIKey, ev.Dataset, ev.Environment, r.Config.GetDatasetPrefix())
+	if samplerConfig, _, err := r.Config.GetSamplerConfigForDestName(selector); err == nil {
+		if fielder, ok := samplerConfig.(config.GetSamplingFielder); ok {
+			for _, field := range fielder.GetSamplingFields() {
+				// rules can refer to fields of the root span as "root.<field>"
+				protected[strings.TrimPrefix(field, "root.")] = struct{}{}
+			}
+		}
+	}
+	return protected
+}
+
+// fieldSize estimates how much space a field takes up, in the same way as
+// types.Span.GetDataSize, but including the length of the name.
+func fieldSize(name string, v interface{}) int {
+	return len(name) + valueSize(v)
+}
+
+func valueSize(v interface{}) int {
+	switch value := v.(type) {
+	case bool:
+		return 1
+	case string:
+		return len(value)
+	case []byte:
+		return len(value)
+	case map[string]interface{}:
+		// nested JSON objects can be arbitrarily large
+		size := 0
+		for k, elem := range value {
+			size += fieldSize(k, elem)
+		}
+		return size
+	case []interface{}:
+		size := 0
+		for _, elem := range value {
+			size += valueSize(elem)
+		}
+		return size
+	default:
+		return 8
+	}
+}
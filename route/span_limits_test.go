@@ -0,0 +1,140 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforceSpanLimits(t *testing.T) {
+	newConf := func() *config.MockConfig {
+		return &config.MockConfig{
+			TraceIdFieldNames:  []string{"trace.trace_id"},
+			ParentIdFieldNames: []string{"trace.parent_id"},
+		}
+	}
+	makeData := func() map[string]interface{} {
+		data := map[string]interface{}{
+			"trace.trace_id":  "trace",
+			"trace.parent_id": "parent",
+			"trace.span_id":   "span",
+		}
+		for i := 0; i < 20; i++ {
+			data[fmt.Sprintf("attr%02d", i)] = "value"
+		}
+		return data
+	}
+
+	t.Run("no limits", func(t *testing.T) {
+		router, _ := newBatchTestRouter(t, newConf())
+		ev := &types.Event{Data: makeData()}
+		require.NoError(t, router.enforceSpanLimits(ev, &logger.NullLoggerEntry{}))
+		assert.Len(t, ev.Data, 23)
+	})
+
+	t.Run("truncate by count", func(t *testing.T) {
+		conf := newConf()
+		conf.MaxSpanAttributes = 10
+		router, mockMetrics := newBatchTestRouter(t, conf)
+		ev := &types.Event{Data: makeData()}
+		require.NoError(t, router.enforceSpanLimits(ev, &logger.NullLoggerEntry{}))
+
+		// 9 kept, plus the marker
+		assert.Len(t, ev.Data, 10)
+		assert.Equal(t, 14, ev.Data[truncatedFieldsFieldName])
+		assert.Equal(t, "trace", ev.Data["trace.trace_id"])
+		assert.Equal(t, "parent", ev.Data["trace.parent_id"])
+		assert.Equal(t, "span", ev.Data["trace.span_id"])
+		assert.Contains(t, ev.Data, "attr00")
+		assert.Contains(t, ev.Data, "attr05")
+		assert.NotContains(t, ev.Data, "attr06")
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_span_truncated"])
+	})
+
+	t.Run("truncate by size", func(t *testing.T) {
+		conf := newConf()
+		conf.MaxSpanBytes = 100
+		router, _ := newBatchTestRouter(t, conf)
+		data := makeData()
+		data["big"] = strings.Repeat("x", 1000)
+		ev := &types.Event{Data: data}
+		require.NoError(t, router.enforceSpanLimits(ev, &logger.NullLoggerEntry{}))
+		assert.NotContains(t, ev.Data, "big")
+		assert.Equal(t, "trace", ev.Data["trace.trace_id"])
+		size := 0
+		for k, v := range ev.Data {
+			size += fieldSize(k, v)
+		}
+		assert.LessOrEqual(t, size, 100)
+	})
+
+	t.Run("truncate keeps sampler and standard fields", func(t *testing.T) {
+		conf := newConf()
+		conf.MaxSpanAttributes = 10
+		conf.GetSamplerTypeVal = &config.RulesBasedSamplerConfig{
+			Rules: []*config.RulesBasedSamplerRule{{
+				Conditions: []*config.RulesBasedSamplerCondition{
+					{Field: "zz.rule_field"},
+					{Field: "root.zz.root_field"},
+				},
+			}},
+		}
+		router, _ := newBatchTestRouter(t, conf)
+		data := makeData()
+		data["name"] = "span name"
+		data["service.name"] = "svc"
+		data["duration_ms"] = 12.5
+		data["status_code"] = 200
+		data["zz.rule_field"] = "rule"
+		data["zz.root_field"] = "root"
+		ev := &types.Event{Data: data, APIKey: legacyAPIKey, Dataset: "dataset"}
+		require.NoError(t, router.enforceSpanLimits(ev, &logger.NullLoggerEntry{}))
+
+		for _, field := range []string{"name", "service.name", "duration_ms", "status_code", "zz.rule_field", "zz.root_field"} {
+			assert.Contains(t, ev.Data, field)
+		}
+		// with 9 protected fields and the marker, every attribute is dropped
+		assert.Len(t, ev.Data, 10)
+		assert.Equal(t, 20, ev.Data[truncatedFieldsFieldName])
+	})
+
+	t.Run("reject in batch", func(t *testing.T) {
+		conf := newConf()
+		conf.MaxSpanAttributes = 10
+		conf.OversizedSpanAction = "reject"
+		router, mockMetrics := newBatchTestRouter(t, conf)
+
+		big, err := json.Marshal(makeData())
+		require.NoError(t, err)
+		body := `[{"data":` + string(big) + `},{"data":{"trace.trace_id":"small"}}]`
+		req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+		req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+		rr := httptest.NewRecorder()
+		router.batch(rr, req)
+
+		var responses []BatchResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+		require.Len(t, responses, 2)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, responses[0].Status)
+		assert.Contains(t, responses[0].Error, "23 fields")
+		assert.Equal(t, http.StatusAccepted, responses[1].Status)
+		assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_span_rejected"])
+
+		spans := router.Collector.(*collect.MockCollector).Spans
+		require.Len(t, spans, 1)
+		assert.Equal(t, "small", (<-spans).TraceID)
+	})
+}
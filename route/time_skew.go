@@ -0,0 +1,44 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// ErrEventTimeSkewed is returned by processEvent for events whose timestamps
+// are outside MaxEventTimeSkewFuture or MaxEventTimeSkewPast when
+// EventTimeSkewAction is "reject".
+var ErrEventTimeSkewed = errors.New("event timestamp is too far from the current time")
+
+// checkEventTime enforces MaxEventTimeSkewFuture and MaxEventTimeSkewPast on
+// ev's timestamp, either replacing a timestamp outside them with the current
+// time or rejecting the event. Events without a timestamp are left alone.
+func (r *Router) checkEventTime(ev *types.Event) error {
+	if ev.Timestamp.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	future := r.Config.GetMaxEventTimeSkewFuture()
+	past := r.Config.GetMaxEventTimeSkewPast()
+	var direction string
+	switch {
+	case future > 0 && ev.Timestamp.After(now.Add(future)):
+		direction = "future"
+	case past > 0 && ev.Timestamp.Before(now.Add(-past)):
+		direction = "past"
+	default:
+		return nil
+	}
+
+	if r.Config.GetEventTimeSkewAction() == "reject" {
+		r.Metrics.Increment("incoming_router_time_skew_rejected")
+		return fmt.Errorf("%w: %s is too far in the %s", ErrEventTimeSkewed, ev.Timestamp.Format(time.RFC3339Nano), direction)
+	}
+	r.Metrics.Increment("incoming_router_time_skew_clamped")
+	ev.Timestamp = now
+	return nil
+}
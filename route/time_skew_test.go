@@ -0,0 +1,93 @@
+package route
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	huskyotlp "github.com/honeycombio/husky/otlp"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestEventTimeSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		action    string
+		timestamp time.Time
+		clamped   bool
+		rejected  bool
+	}{
+		{name: "in window", timestamp: now.Add(-time.Minute)},
+		{name: "no timestamp", timestamp: time.Time{}},
+		{name: "future clamped", timestamp: now.Add(24 * time.Hour), clamped: true},
+		{name: "past clamped", timestamp: time.Unix(0, 0), clamped: true},
+		{name: "future rejected", action: "reject", timestamp: time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), rejected: true},
+		{name: "past rejected", action: "reject", timestamp: now.Add(-48 * time.Hour), rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:      []string{"trace.trace_id"},
+				MaxEventTimeSkewFuture: time.Hour,
+				MaxEventTimeSkewPast:   24 * time.Hour,
+				EventTimeSkewAction:    tt.action,
+			})
+			ev := &types.Event{
+				Context:   context.Background(),
+				Dataset:   "dataset",
+				Timestamp: tt.timestamp,
+				Data:      map[string]interface{}{"trace.trace_id": "abc"},
+			}
+			err := router.processEvent(ev, nil)
+			spans := router.Collector.(*collect.MockCollector).Spans
+
+			if tt.rejected {
+				require.ErrorIs(t, err, ErrEventTimeSkewed)
+				assert.Empty(t, spans)
+				resp := newBatchResponse(err)
+				assert.Equal(t, http.StatusBadRequest, resp.Status)
+				assert.Equal(t, BatchCodeTimeSkewed, resp.Code)
+				assert.Equal(t, codes.InvalidArgument, newOTLPError(err).GRPCStatusCode)
+				assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_time_skew_rejected"])
+				return
+			}
+			require.NoError(t, err)
+			span := <-spans
+			if tt.clamped {
+				assert.WithinDuration(t, time.Now(), span.Timestamp, time.Minute)
+				assert.Equal(t, 1, mockMetrics.CounterIncrements["incoming_router_time_skew_clamped"])
+			} else {
+				assert.Equal(t, tt.timestamp, span.Timestamp)
+				assert.Zero(t, mockMetrics.CounterIncrements["incoming_router_time_skew_clamped"])
+			}
+		})
+	}
+}
+
+func TestOTLPEventTimeSkew(t *testing.T) {
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		TraceIdFieldNames:    []string{"trace.trace_id"},
+		MaxEventTimeSkewPast: time.Hour,
+		EventTimeSkewAction:  "reject",
+	})
+	batches := []huskyotlp.Batch{{
+		Dataset: "dataset",
+		Events: []huskyotlp.Event{
+			{Timestamp: time.Now(), Attributes: map[string]interface{}{"trace.trace_id": "abc"}},
+			{Timestamp: time.Unix(0, 0), Attributes: map[string]interface{}{"trace.trace_id": "abc"}},
+		},
+	}}
+	err := router.processOTLPRequest(context.Background(), batches, legacyAPIKey, "")
+	assert.ErrorIs(t, err, ErrEventTimeSkewed)
+	var rejectedErr *otlpRejectedError
+	require.ErrorAs(t, err, &rejectedErr)
+	assert.Equal(t, 1, rejectedErr.rejected)
+	assert.Len(t, router.Collector.(*collect.MockCollector).Spans, 1, "only the skewed span is rejected")
+}
@@ -0,0 +1,113 @@
+package route
+
+import (
+	"strings"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// lookupField returns the value of the named ID field in data. A dotted name
+// like trace.trace_id is looked up as a path through nested objects first, so
+// that it finds data["trace"]["trace_id"], and then as a flat key.
+func lookupField(data map[string]interface{}, name string) (interface{}, bool) {
+	if !strings.Contains(name, ".") {
+		v, ok := data[name]
+		return v, ok
+	}
+	if v, ok := lookupPath(data, name); ok {
+		return v, true
+	}
+	v, ok := data[name]
+	return v, ok
+}
+
+// lookupPath follows a dotted path through nested objects in data.
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	m := data
+	for {
+		key, rest, nested := strings.Cut(path, ".")
+		v, ok := m[key]
+		if !ok {
+			return nil, false
+		}
+		if !nested {
+			return v, true
+		}
+		if m, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+		path = rest
+	}
+}
+
+// setField sets the named ID field in data: in its nested object, if that's
+// where lookupField finds it, and as a flat key otherwise.
+func setField(data map[string]interface{}, name string, value interface{}) {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		if _, ok := lookupPath(data, name); ok {
+			if parent, ok := lookupPath(data, name[:i]); ok {
+				parent.(map[string]interface{})[name[i+1:]] = value
+				return
+			}
+		}
+	}
+	data[name] = value
+}
+
+// traceIDFieldName returns the trace ID field of ev that the trace ID should
+// come from, which is normally the first of the configured fields that's
+// present. Unless TraceNameConflictAction is ignore, any other trace ID fields
+// are checked too; if their values differ, the conflict is counted, and either
+// logged (warn) or settled in favor of PreferredTraceName (prefer).
+func (r *Router) traceIDFieldName(ev *types.Event) (string, bool) {
+	action := r.Config.GetTraceIdConflictAction()
+	fieldNames := r.idFields().trace
+
+	first := -1
+	for i, name := range fieldNames {
+		if _, ok := lookupField(ev.Data, name); ok {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+	chosen := fieldNames[first]
+	if action == "" || action == "ignore" {
+		return chosen, true
+	}
+
+	// values that aren't valid trace IDs are reported when the chosen field
+	// is converted, so they're only compared here if they convert cleanly
+	chosenValue, _ := lookupField(ev.Data, chosen)
+	chosenID, _ := traceIDToString(chosenValue)
+	var conflicting []string
+	for _, name := range fieldNames[first+1:] {
+		value, ok := lookupField(ev.Data, name)
+		if !ok {
+			continue
+		}
+		if id, err := traceIDToString(value); err == nil && id != chosenID {
+			conflicting = append(conflicting, name)
+		}
+	}
+	if len(conflicting) == 0 {
+		return chosen, true
+	}
+
+	r.Metrics.Increment("incoming_router_traceid_conflict")
+	if action == "prefer" {
+		preferred := r.Config.GetPreferredTraceIdFieldName()
+		if _, ok := lookupField(ev.Data, preferred); ok {
+			return preferred, true
+		}
+		return chosen, true
+	}
+	r.iopLogger.Warn().
+		WithString("dataset", ev.Dataset).
+		WithString("trace_id_field", chosen).
+		WithField("conflicting_fields", conflicting).
+		Logf("event has conflicting trace ID fields; using the first one")
+	return chosen, true
+}
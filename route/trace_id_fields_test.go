@@ -0,0 +1,95 @@
+package route
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/honeycombio/refinery/collect"
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceIDFieldConflicts(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		body         string
+		wantTraceID  string
+		wantConflict bool
+		wantWarning  bool
+	}{
+		{
+			name:        "ignore uses the first field",
+			action:      "ignore",
+			body:        `[{"data":{"trace.trace_id":"first","traceId":"second"}}]`,
+			wantTraceID: "first",
+		},
+		{
+			name:         "warn uses the first field and reports the conflict",
+			action:       "warn",
+			body:         `[{"data":{"trace.trace_id":"first","traceId":"second"}}]`,
+			wantTraceID:  "first",
+			wantConflict: true,
+			wantWarning:  true,
+		},
+		{
+			name:         "prefer uses the preferred field",
+			action:       "prefer",
+			body:         `[{"data":{"trace.trace_id":"first","traceId":"second"}}]`,
+			wantTraceID:  "second",
+			wantConflict: true,
+		},
+		{
+			name:        "matching values aren't a conflict",
+			action:      "warn",
+			body:        `[{"data":{"trace.trace_id":"same","traceId":"same"}}]`,
+			wantTraceID: "same",
+		},
+		{
+			name:        "prefer falls back to the first field",
+			action:      "prefer",
+			body:        `[{"data":{"trace.trace_id":"first"}}]`,
+			wantTraceID: "first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockMetrics := newBatchTestRouter(t, &config.MockConfig{
+				TraceIdFieldNames:         []string{"trace.trace_id", "traceId"},
+				TraceIdConflictAction:     tt.action,
+				PreferredTraceIdFieldName: "traceId",
+			})
+
+			req := httptest.NewRequest("POST", "/1/batch/dataset", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(types.APIKeyHeader, legacyAPIKey)
+			req = mux.SetURLVars(req, map[string]string{"datasetName": "dataset"})
+			router.batch(httptest.NewRecorder(), req)
+
+			spans := router.Collector.(*collect.MockCollector).Spans
+			require.Len(t, spans, 1)
+			assert.Equal(t, tt.wantTraceID, (<-spans).TraceID)
+
+			count, _ := mockMetrics.Get("incoming_router_traceid_conflict")
+			if tt.wantConflict {
+				assert.Equal(t, float64(1), count)
+			} else {
+				assert.Zero(t, count)
+			}
+
+			var warned bool
+			for _, ev := range router.iopLogger.Logger.(*logger.MockLogger).Events {
+				if _, ok := ev.Fields["conflicting_fields"]; ok {
+					warned = true
+				}
+			}
+			assert.Equal(t, tt.wantWarning, warned)
+		})
+	}
+}
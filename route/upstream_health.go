@@ -0,0 +1,89 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// upstreamHealthSource is the name the upstream check reports its readiness
+// under.
+const upstreamHealthSource = "upstream"
+
+// startUpstreamHealthCheck periodically checks that the upstream API can be
+// reached, and reports not ready after UpstreamHealthCheckFailureThreshold
+// checks in a row have failed, so that a load balancer can send traffic
+// elsewhere while we can't forward it.
+func (r *Router) startUpstreamHealthCheck() {
+	interval := r.Config.GetUpstreamHealthCheckInterval()
+	threshold := r.Config.GetUpstreamHealthCheckFailureThreshold()
+
+	// we start out ready so that a slow first check doesn't delay startup
+	r.HealthRecorder.Register(upstreamHealthSource, 5*interval)
+	r.HealthRecorder.Ready(upstreamHealthSource, true)
+
+	r.doneWG.Add(1)
+	go func() {
+		defer r.doneWG.Done()
+		defer r.HealthRecorder.Unregister(upstreamHealthSource)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			select {
+			case <-r.donech:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := r.checkUpstream(ctx)
+			cancel()
+
+			if err == nil {
+				if failures >= threshold {
+					r.iopLogger.Info().Logf("upstream API is reachable again")
+				}
+				failures = 0
+			} else {
+				failures++
+				r.Metrics.Increment("upstream_health_check_failed")
+				r.iopLogger.Debug().Logf("upstream health check failed: %s", err)
+				if failures == threshold {
+					r.iopLogger.Error().Logf("upstream API unreachable after %d checks, reporting not ready: %s", failures, err)
+				}
+			}
+			r.HealthRecorder.Ready(upstreamHealthSource, failures < threshold)
+		}
+	}()
+}
+
+// checkUpstream makes a request to the upstream API's auth endpoint. It's
+// sent without an API key, so any response short of a server error means the
+// API is reachable.
+func (r *Router) checkUpstream(ctx context.Context) error {
+	authURL, err := url.Parse(r.Config.GetHoneycombAPI())
+	if err != nil {
+		return fmt.Errorf("failed to parse Honeycomb API URL config value. %w", err)
+	}
+	authURL.Path = "/1/auth"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.proxyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("received %d response from %s", resp.StatusCode, authURL)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/internal/health"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	var failing atomic.Bool
+	var checks atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/1/auth", req.URL.Path)
+		checks.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// no API key is sent, so this is the usual answer
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		GetHoneycombAPIVal:                  upstream.URL,
+		UpstreamHealthCheckInterval:         10 * time.Millisecond,
+		UpstreamHealthCheckFailureThreshold: 3,
+	})
+	router.proxyClient = upstream.Client()
+	router.donech = make(chan struct{})
+
+	h := &health.Health{Clock: clockwork.NewFakeClock()}
+	require.NoError(t, h.Start())
+	defer h.Stop()
+	router.HealthRecorder = h
+
+	router.startUpstreamHealthCheck()
+	assert.True(t, h.IsReady())
+
+	// wait for a few checks to pass
+	assert.Eventually(t, func() bool { return checks.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	assert.True(t, h.IsReady())
+
+	// once enough checks in a row fail, we're not ready
+	failing.Store(true)
+	assert.Eventually(t, func() bool { return !h.IsReady() }, time.Second, 5*time.Millisecond)
+
+	// a single success makes us ready again
+	failing.Store(false)
+	assert.Eventually(t, h.IsReady, time.Second, 5*time.Millisecond)
+
+	close(router.donech)
+	router.doneWG.Wait()
+}
+
+func TestUpstreamHealthCheckThreshold(t *testing.T) {
+	var checks atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		checks.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	router, _ := newBatchTestRouter(t, &config.MockConfig{
+		GetHoneycombAPIVal:                  upstream.URL,
+		UpstreamHealthCheckInterval:         10 * time.Millisecond,
+		UpstreamHealthCheckFailureThreshold: 1000,
+	})
+	router.proxyClient = upstream.Client()
+	router.donech = make(chan struct{})
+
+	h := &health.Health{Clock: clockwork.NewFakeClock()}
+	require.NoError(t, h.Start())
+	defer h.Stop()
+	router.HealthRecorder = h
+
+	router.startUpstreamHealthCheck()
+
+	// failures below the threshold don't change readiness
+	assert.Eventually(t, func() bool { return checks.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	assert.True(t, h.IsReady())
+
+	close(router.donech)
+	router.doneWG.Wait()
+}
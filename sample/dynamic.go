@@ -24,6 +24,7 @@ type DynamicSampler struct {
 
 	key       *traceKey
 	keyFields []string
+	keys      *keyTable
 
 	dynsampler dynsampler.Sampler
 }
@@ -51,6 +52,7 @@ func (d *DynamicSampler) Start() error {
 		MaxKeys:                d.maxKeys,
 	}
 	d.dynsampler.Start()
+	d.keys = newKeyTable(d.maxKeys, time.Duration(d.clearFrequency))
 
 	// Register statistics this package will produce
 	d.lastMetrics = d.dynsampler.GetMetrics(d.prefix)
@@ -60,6 +62,7 @@ func (d *DynamicSampler) Start() error {
 	d.Metrics.Register(d.prefix+"num_dropped", "counter")
 	d.Metrics.Register(d.prefix+"num_kept", "counter")
 	d.Metrics.Register(d.prefix+"sample_rate", "histogram")
+	d.Metrics.Register(d.prefix+"sample_rate_capped", "counter")
 
 	return nil
 }
@@ -68,9 +71,14 @@ func (d *DynamicSampler) GetSampleRate(trace FieldsExtractor) (rate uint, keep b
 	key = d.key.build(trace)
 	count := int(trace.DescendantCount())
 	rate = uint(d.dynsampler.GetSampleRateMulti(key, count))
+	d.keys.record(key, count)
 	if rate < 1 { // protect against dynsampler being broken even though it shouldn't be
 		rate = 1
 	}
+	if maxRate := uint(d.Config.MaxSampleRate); maxRate > 0 && rate > maxRate {
+		rate = maxRate
+		d.Metrics.Increment(d.prefix + "sample_rate_capped")
+	}
 	shouldKeep := rand.Intn(int(rate)) == 0
 	d.Logger.Debug().WithFields(map[string]interface{}{
 		"sample_key":  key,
@@ -100,3 +108,7 @@ func (d *DynamicSampler) GetSampleRate(trace FieldsExtractor) (rate uint, keep b
 func (d *DynamicSampler) GetKeyFields() []string {
 	return d.key.fields
 }
+
+func (d *DynamicSampler) SamplerKeys() []SamplerKey {
+	return d.keys.SamplerKeys()
+}
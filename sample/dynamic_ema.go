@@ -28,6 +28,7 @@ type EMADynamicSampler struct {
 
 	key       *traceKey
 	keyFields []string
+	keys      *keyTable
 
 	dynsampler dynsampler.Sampler
 }
@@ -61,6 +62,7 @@ func (d *EMADynamicSampler) Start() error {
 		MaxKeys:                    d.maxKeys,
 	}
 	d.dynsampler.Start()
+	d.keys = newKeyTable(d.maxKeys, time.Duration(d.adjustmentInterval))
 
 	// Register statistics this package will produce
 	d.lastMetrics = d.dynsampler.GetMetrics(d.prefix)
@@ -70,6 +72,7 @@ func (d *EMADynamicSampler) Start() error {
 	d.Metrics.Register(d.prefix+"num_dropped", "counter")
 	d.Metrics.Register(d.prefix+"num_kept", "counter")
 	d.Metrics.Register(d.prefix+"sample_rate", "histogram")
+	d.Metrics.Register(d.prefix+"sample_rate_capped", "counter")
 	return nil
 }
 
@@ -77,9 +80,14 @@ func (d *EMADynamicSampler) GetSampleRate(trace FieldsExtractor) (rate uint, kee
 	key = d.key.build(trace)
 	count := int(trace.DescendantCount())
 	rate = uint(d.dynsampler.GetSampleRateMulti(key, count))
+	d.keys.record(key, count)
 	if rate < 1 { // protect against dynsampler being broken even though it shouldn't be
 		rate = 1
 	}
+	if maxRate := uint(d.Config.MaxSampleRate); maxRate > 0 && rate > maxRate {
+		rate = maxRate
+		d.Metrics.Increment(d.prefix + "sample_rate_capped")
+	}
 	shouldKeep := rand.Intn(int(rate)) == 0
 	d.Logger.Debug().WithFields(map[string]interface{}{
 		"sample_key":  key,
@@ -109,3 +117,7 @@ func (d *EMADynamicSampler) GetSampleRate(trace FieldsExtractor) (rate uint, kee
 func (d *EMADynamicSampler) GetKeyFields() []string {
 	return d.keyFields
 }
+
+func (d *EMADynamicSampler) SamplerKeys() []SamplerKey {
+	return d.keys.SamplerKeys()
+}
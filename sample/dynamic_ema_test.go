@@ -49,3 +49,33 @@ func TestDynamicEMAAddSampleRateKeyToTrace(t *testing.T) {
 	assert.Equal(t, "4•,200•,true•,/{slug}/fun•,", key)
 
 }
+
+func TestDynamicEMAMaxSampleRate(t *testing.T) {
+	metrics := metrics.MockMetrics{}
+	metrics.Start()
+
+	sampler := &EMADynamicSampler{
+		Config: &config.EMADynamicSamplerConfig{
+			GoalSampleRate: 100,
+			FieldList:      []string{"http.status_code"},
+			MaxSampleRate:  25,
+		},
+		Logger:  &logger.NullLogger{},
+		Metrics: &metrics,
+	}
+	sampler.Start()
+
+	trace := &types.Trace{}
+	trace.AddSpan(&types.Span{
+		Event: types.Event{
+			Data: map[string]interface{}{
+				"http.status_code": 200,
+			},
+		},
+	})
+	for i := 0; i < 3; i++ {
+		rate, _, _, _ := sampler.GetSampleRate(trace)
+		assert.Equal(t, uint(25), rate)
+	}
+	assert.Equal(t, 3, metrics.CounterIncrements["emadynamic_sample_rate_capped"])
+}
@@ -41,3 +41,40 @@ func TestDynamicAddSampleRateKeyToTrace(t *testing.T) {
 	spans := trace.GetSpans()
 	assert.Len(t, spans, spanCount, "should have the same number of spans as input")
 }
+
+func TestDynamicMaxSampleRate(t *testing.T) {
+	for _, maxSampleRate := range []int{0, 10, 1000} {
+		metrics := metrics.MockMetrics{}
+		metrics.Start()
+
+		sampler := &DynamicSampler{
+			Config: &config.DynamicSamplerConfig{
+				SampleRate:    100,
+				FieldList:     []string{"http.status_code"},
+				MaxSampleRate: maxSampleRate,
+			},
+			Logger:  &logger.NullLogger{},
+			Metrics: &metrics,
+		}
+		sampler.Start()
+
+		trace := &types.Trace{}
+		trace.AddSpan(&types.Span{
+			Event: types.Event{
+				Data: map[string]interface{}{
+					"http.status_code": "200",
+				},
+			},
+		})
+		// until it has seen some traffic, the sampler uses its goal rate
+		rate, _, _, _ := sampler.GetSampleRate(trace)
+
+		if maxSampleRate == 10 {
+			assert.Equal(t, uint(10), rate)
+			assert.Equal(t, 1, metrics.CounterIncrements["dynamic_sample_rate_capped"])
+		} else {
+			assert.Equal(t, uint(100), rate, "max %d", maxSampleRate)
+			assert.Equal(t, 0, metrics.CounterIncrements["dynamic_sample_rate_capped"])
+		}
+	}
+}
@@ -31,6 +31,7 @@ type EMAThroughputSampler struct {
 
 	key       *traceKey
 	keyFields []string
+	keys      *keyTable
 
 	dynsampler *dynsampler.EMAThroughput
 }
@@ -70,6 +71,7 @@ func (d *EMAThroughputSampler) Start() error {
 		MaxKeys:              d.maxKeys,
 	}
 	d.dynsampler.Start()
+	d.keys = newKeyTable(d.maxKeys, time.Duration(d.adjustmentInterval))
 
 	// Register statistics this package will produce
 	d.lastMetrics = d.dynsampler.GetMetrics(d.prefix)
@@ -94,6 +96,7 @@ func (d *EMAThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate uint,
 	key = d.key.build(trace)
 	count := int(trace.DescendantCount())
 	rate = uint(d.dynsampler.GetSampleRateMulti(key, count))
+	d.keys.record(key, count)
 	if rate < 1 { // protect against dynsampler being broken even though it shouldn't be
 		rate = 1
 	}
@@ -126,3 +129,7 @@ func (d *EMAThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate uint,
 func (d *EMAThroughputSampler) GetKeyFields() []string {
 	return d.keyFields
 }
+
+func (d *EMAThroughputSampler) SamplerKeys() []SamplerKey {
+	return d.keys.SamplerKeys()
+}
@@ -155,6 +155,23 @@ func (s *RulesBasedSampler) GetKeyFields() []string {
 	return s.keyFields
 }
 
+// SamplerKeys returns the keys seen by the samplers of all of the rules.
+func (s *RulesBasedSampler) SamplerKeys() []SamplerKey {
+	var keys []SamplerKey
+	for _, rule := range s.Config.Rules {
+		reporter, ok := s.samplers[rule.String()].(KeyReporter)
+		if !ok {
+			continue
+		}
+		for _, key := range reporter.SamplerKeys() {
+			key.Rule = rule.Name
+			keys = append(keys, key)
+		}
+	}
+	sortSamplerKeys(keys)
+	return keys
+}
+
 func ruleMatchesTrace(t FieldsExtractor, rule *config.RulesBasedSamplerRule, checkNestedFields bool) bool {
 	// We treat a rule with no conditions as a match.
 	if rule.Conditions == nil {
@@ -0,0 +1,104 @@
+package sample
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SamplerKey is a sampler key that a sampler has seen recently, and the
+// number of spans in the traces that had it. For a rules-based sampler, Rule
+// names the rule whose sampler saw the key.
+type SamplerKey struct {
+	Rule  string `json:"rule,omitempty"`
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// KeyReporter is implemented by samplers that choose sample rates by
+// sampler key, so that the keys they're tracking can be inspected.
+type KeyReporter interface {
+	// SamplerKeys returns the keys seen in the current and previous
+	// intervals, most frequent first.
+	SamplerKeys() []SamplerKey
+}
+
+// defaultKeyTableInterval is used when a sampler doesn't say how often it
+// starts over.
+const defaultKeyTableInterval = 30 * time.Second
+
+// keyTable counts the sampler keys a sampler sees. Like the samplers, it
+// tracks at most maxKeys keys at a time and starts over every interval, but
+// it keeps the previous interval's counts so that there's always something
+// to report.
+type keyTable struct {
+	maxKeys  int
+	interval time.Duration
+
+	mut      sync.Mutex
+	started  time.Time
+	current  map[string]int64
+	previous map[string]int64
+}
+
+func newKeyTable(maxKeys int, interval time.Duration) *keyTable {
+	if interval <= 0 {
+		interval = defaultKeyTableInterval
+	}
+	return &keyTable{
+		maxKeys:  maxKeys,
+		interval: interval,
+		started:  time.Now(),
+		current:  make(map[string]int64),
+	}
+}
+
+// record counts count spans for key.
+func (k *keyTable) record(key string, count int) {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+
+	if now := time.Now(); now.Sub(k.started) >= k.interval {
+		k.previous = k.current
+		k.current = make(map[string]int64, len(k.previous))
+		k.started = now
+	}
+	if _, found := k.current[key]; found || k.maxKeys <= 0 || len(k.current) < k.maxKeys {
+		k.current[key] += int64(count)
+	}
+}
+
+func (k *keyTable) SamplerKeys() []SamplerKey {
+	if k == nil {
+		return nil
+	}
+	k.mut.Lock()
+	counts := make(map[string]int64, len(k.current)+len(k.previous))
+	for key, count := range k.previous {
+		counts[key] += count
+	}
+	for key, count := range k.current {
+		counts[key] += count
+	}
+	k.mut.Unlock()
+
+	keys := make([]SamplerKey, 0, len(counts))
+	for key, count := range counts {
+		keys = append(keys, SamplerKey{Key: key, Count: count})
+	}
+	sortSamplerKeys(keys)
+	return keys
+}
+
+// sortSamplerKeys puts the most frequent keys first.
+func sortSamplerKeys(keys []SamplerKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		if keys[i].Rule != keys[j].Rule {
+			return keys[i].Rule < keys[j].Rule
+		}
+		return keys[i].Key < keys[j].Key
+	})
+}
@@ -0,0 +1,65 @@
+package sample
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/config"
+	"github.com/honeycombio/refinery/logger"
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTable(t *testing.T) {
+	k := newKeyTable(2, time.Hour)
+	k.record("a", 1)
+	k.record("b", 3)
+	k.record("a", 1)
+	// over MaxKeys, so it's not tracked
+	k.record("c", 10)
+
+	assert.Equal(t, []SamplerKey{{Key: "b", Count: 3}, {Key: "a", Count: 2}}, k.SamplerKeys())
+
+	// once the interval is up, the previous counts are still reported
+	k.started = k.started.Add(-2 * time.Hour)
+	k.record("c", 10)
+	assert.Equal(t, []SamplerKey{{Key: "c", Count: 10}, {Key: "b", Count: 3}, {Key: "a", Count: 2}}, k.SamplerKeys())
+
+	var nilTable *keyTable
+	assert.Empty(t, nilTable.SamplerKeys())
+}
+
+func TestDynamicSamplerKeys(t *testing.T) {
+	metrics := metrics.MockMetrics{}
+	metrics.Start()
+
+	sampler := &DynamicSampler{
+		Config: &config.DynamicSamplerConfig{
+			FieldList: []string{"http.status_code"},
+		},
+		Logger:  &logger.NullLogger{},
+		Metrics: &metrics,
+	}
+	require.NoError(t, sampler.Start())
+
+	trace := &types.Trace{}
+	for i := 0; i < 3; i++ {
+		trace.AddSpan(&types.Span{
+			Event: types.Event{
+				Data: map[string]interface{}{
+					"http.status_code": "200",
+				},
+			},
+		})
+	}
+	sampler.GetSampleRate(trace)
+
+	var reporter KeyReporter = sampler
+	keys := reporter.SamplerKeys()
+	require.Len(t, keys, 1)
+	assert.Contains(t, keys[0].Key, "200")
+	assert.Equal(t, int64(3), keys[0].Count)
+}
@@ -26,6 +26,7 @@ type TotalThroughputSampler struct {
 
 	key       *traceKey
 	keyFields []string
+	keys      *keyTable
 
 	dynsampler *dynsampler.TotalThroughput
 }
@@ -62,6 +63,7 @@ func (d *TotalThroughputSampler) Start() error {
 		MaxKeys:                d.maxKeys,
 	}
 	d.dynsampler.Start()
+	d.keys = newKeyTable(d.maxKeys, time.Duration(d.clearFrequency))
 
 	// Register statistics this package will produce
 	d.lastMetrics = d.dynsampler.GetMetrics(d.prefix)
@@ -86,6 +88,7 @@ func (d *TotalThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate uint
 	key = d.key.build(trace)
 	count := int(trace.DescendantCount())
 	rate = uint(d.dynsampler.GetSampleRateMulti(key, count))
+	d.keys.record(key, count)
 	if rate < 1 { // protect against dynsampler being broken even though it shouldn't be
 		rate = 1
 	}
@@ -118,3 +121,7 @@ func (d *TotalThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate uint
 func (d *TotalThroughputSampler) GetKeyFields() []string {
 	return d.keyFields
 }
+
+func (d *TotalThroughputSampler) SamplerKeys() []SamplerKey {
+	return d.keys.SamplerKeys()
+}
@@ -27,6 +27,7 @@ type WindowedThroughputSampler struct {
 
 	key       *traceKey
 	keyFields []string
+	keys      *keyTable
 
 	dynsampler *dynsampler.WindowedThroughput
 }
@@ -58,6 +59,7 @@ func (d *WindowedThroughputSampler) Start() error {
 		MaxKeys:                   d.maxKeys,
 	}
 	d.dynsampler.Start()
+	d.keys = newKeyTable(d.maxKeys, time.Duration(d.lookbackfrequency))
 
 	// Register statistics this package will produce
 	d.lastMetrics = d.dynsampler.GetMetrics(d.prefix)
@@ -82,6 +84,7 @@ func (d *WindowedThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate u
 	key = d.key.build(trace)
 	count := int(trace.DescendantCount())
 	rate = uint(d.dynsampler.GetSampleRateMulti(key, count))
+	d.keys.record(key, count)
 	if rate < 1 { // protect against dynsampler being broken even though it shouldn't be
 		rate = 1
 	}
@@ -114,3 +117,7 @@ func (d *WindowedThroughputSampler) GetSampleRate(trace FieldsExtractor) (rate u
 func (d *WindowedThroughputSampler) GetKeyFields() []string {
 	return d.keyFields
 }
+
+func (d *WindowedThroughputSampler) SamplerKeys() []SamplerKey {
+	return d.keys.SamplerKeys()
+}
@@ -318,7 +318,7 @@ func schemaType(typ string) string {
 		return "boolean"
 	case "duration":
 		return "string"
-	case "hostport", "url":
+	case "hostport", "url", "urlOrBlank", "srvurl":
 		return "string"
 	case "stringarray":
 		return "array"
@@ -0,0 +1,65 @@
+package transmit
+
+import (
+	"time"
+
+	libhoney "github.com/honeycombio/libhoney-go"
+
+	"github.com/honeycombio/refinery/types"
+)
+
+// NewClientFunc makes a libhoney client that sends batches of up to
+// maxBatchSize events, waiting at most batchTimeout for a batch to fill.
+type NewClientFunc func(maxBatchSize uint, batchTimeout time.Duration) (*libhoney.Client, error)
+
+// batchingSettings identifies the clients made for BatchingByDestination;
+// destinations with the same settings share a client.
+type batchingSettings struct {
+	maxBatchSize uint
+	batchTimeout time.Duration
+}
+
+// builderFor returns the builder for the client that batches the events sent
+// to ev's destination. That's the transmission's own client unless
+// BatchingByDestination gives the destination different settings, and
+// NewClient is set so that a client can be made for them.
+func (d *DefaultTransmission) builderFor(ev *types.Event) *libhoney.Builder {
+	if d.NewClient == nil {
+		return d.builder
+	}
+	maxBatchSize, batchTimeout := d.Config.GetBatchingForDestination(ev.Environment, ev.Dataset)
+	settings := batchingSettings{maxBatchSize: maxBatchSize, batchTimeout: batchTimeout}
+	if settings == d.defaultBatching {
+		return d.builder
+	}
+
+	d.batchingMut.Lock()
+	defer d.batchingMut.Unlock()
+	if builder, ok := d.batchingBuilders[settings]; ok {
+		return builder
+	}
+	client, err := d.NewClient(maxBatchSize, batchTimeout)
+	if err != nil {
+		d.Logger.Error().WithString("error", err.Error()).WithString("dataset", ev.Dataset).
+			Logf("failed to create client for BatchingByDestination; using the default batching")
+		return d.builder
+	}
+	d.Logger.Info().WithField("max_batch_size", maxBatchSize).WithString("batch_timeout", batchTimeout.String()).
+		Logf("created client for BatchingByDestination")
+	builder := client.NewBuilder()
+	builder.APIHost = d.builder.APIHost
+	d.batchingClients = append(d.batchingClients, client)
+	d.batchingBuilders[settings] = builder
+	go d.processResponses(d.processCtx, client.TxResponses())
+	return builder
+}
+
+// flushBatchingClients flushes the clients made for BatchingByDestination.
+func (d *DefaultTransmission) flushBatchingClients() {
+	d.batchingMut.Lock()
+	clients := append([]*libhoney.Client(nil), d.batchingClients...)
+	d.batchingMut.Unlock()
+	for _, client := range clients {
+		client.Flush()
+	}
+}
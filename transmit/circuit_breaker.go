@@ -0,0 +1,143 @@
+package transmit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+const (
+	gaugeCircuitBreakerState     = "circuit_breaker_state"
+	counterCircuitBreakerTripped = "circuit_breaker_tripped"
+	counterCircuitBreakerDrops   = "circuit_breaker_dropped"
+)
+
+// The breaker's states, as reported by CircuitBreakerStats. The numbers are
+// what the circuit_breaker_state gauge reports.
+const (
+	CircuitClosed   = "closed"
+	CircuitHalfOpen = "half-open"
+	CircuitOpen     = "open"
+)
+
+var circuitStateGauge = map[string]int{CircuitClosed: 0, CircuitHalfOpen: 1, CircuitOpen: 2}
+
+// CircuitBreakerStats describes the state of a transmission's circuit breaker.
+type CircuitBreakerStats struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+	Dropped             int64     `json:"dropped"`
+}
+
+// CircuitBreakerReporter is implemented by transmissions that have a circuit
+// breaker.
+type CircuitBreakerReporter interface {
+	CircuitBreakerStats() CircuitBreakerStats
+}
+
+// circuitBreaker stops a transmission from queueing events while its
+// destination is failing. After threshold send failures in a row it opens,
+// and events are dropped instead of queued. Once cooldown has passed, it's
+// half-open: a single event is let through as a probe, and the breaker closes
+// again if it's sent successfully, or opens for another cooldown if not.
+type circuitBreaker struct {
+	threshold func() int
+	cooldown  func() time.Duration
+	now       func() time.Time
+
+	mut      sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probeAt  time.Time
+	dropped  int64
+}
+
+func newCircuitBreaker(threshold func() int, cooldown func() time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     CircuitClosed,
+	}
+}
+
+// allow reports whether an event may be sent, and counts it as dropped if
+// not. It also returns the new state if deciding that changed it.
+func (b *circuitBreaker) allow() (ok bool, state string, changed bool) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	before := b.state
+	ok = b.allowLocked()
+	if !ok {
+		b.dropped++
+	}
+	return ok, b.state, b.state != before
+}
+
+func (b *circuitBreaker) allowLocked() bool {
+	if b.threshold() <= 0 {
+		b.state, b.failures = CircuitClosed, 0
+		return true
+	}
+	now := b.now()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) >= b.cooldown() {
+			b.state, b.probeAt = CircuitHalfOpen, now
+			return true
+		}
+		return false
+	case CircuitHalfOpen:
+		// if the probe's result never came back, try another
+		if now.Sub(b.probeAt) >= b.cooldown() {
+			b.probeAt = now
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// record notes the outcome of a send, and returns the new state if it
+// changed.
+func (b *circuitBreaker) record(success bool) (state string, changed bool) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	before := b.state
+	if success {
+		b.failures = 0
+		b.state = CircuitClosed
+	} else {
+		b.failures++
+		threshold := b.threshold()
+		if b.state == CircuitHalfOpen || (b.state == CircuitClosed && threshold > 0 && b.failures >= threshold) {
+			b.state, b.openedAt = CircuitOpen, b.now()
+		}
+	}
+	return b.state, b.state != before
+}
+
+func (b *circuitBreaker) stats() CircuitBreakerStats {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	s := CircuitBreakerStats{State: b.state, ConsecutiveFailures: b.failures, Dropped: b.dropped}
+	if b.state != CircuitClosed {
+		s.OpenedAt = b.openedAt
+	}
+	return s
+}
+
+// isSendFailure reports whether a response means that the destination is
+// unavailable, as opposed to having rejected something about the event, like
+// its API key, which doesn't say anything about whether the next send will
+// work.
+func isSendFailure(r transmission.Response) bool {
+	return r.Err != nil || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+}
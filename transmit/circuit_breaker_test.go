@@ -0,0 +1,84 @@
+package transmit
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	threshold := 3
+	now := time.Now()
+	b := newCircuitBreaker(func() int { return threshold }, func() time.Duration { return 30 * time.Second })
+	b.now = func() time.Time { return now }
+
+	allow := func() bool {
+		ok, _, _ := b.allow()
+		return ok
+	}
+
+	// failures below the threshold, or interrupted by a success, don't trip it
+	b.record(false)
+	b.record(false)
+	b.record(true)
+	b.record(false)
+	b.record(false)
+	assert.True(t, allow())
+	assert.Equal(t, CircuitClosed, b.stats().State)
+
+	state, changed := b.record(false)
+	assert.Equal(t, CircuitOpen, state)
+	assert.True(t, changed)
+	assert.False(t, allow())
+	assert.False(t, allow())
+	assert.Equal(t, int64(2), b.stats().Dropped)
+	assert.Equal(t, now, b.stats().OpenedAt)
+
+	// after the cooldown a single probe is let through
+	now = now.Add(30 * time.Second)
+	ok, state, changed := b.allow()
+	assert.True(t, ok)
+	assert.Equal(t, CircuitHalfOpen, state)
+	assert.True(t, changed)
+	assert.False(t, allow())
+
+	// a failed probe opens it for another cooldown
+	state, _ = b.record(false)
+	assert.Equal(t, CircuitOpen, state)
+	now = now.Add(29 * time.Second)
+	assert.False(t, allow())
+	now = now.Add(time.Second)
+	assert.True(t, allow())
+
+	// and a successful one closes it
+	state, changed = b.record(true)
+	assert.Equal(t, CircuitClosed, state)
+	assert.True(t, changed)
+	assert.True(t, allow())
+	assert.Equal(t, 0, b.stats().ConsecutiveFailures)
+
+	// a threshold of 0 disables it, even if it was open
+	b.record(false)
+	b.record(false)
+	b.record(false)
+	assert.False(t, allow())
+	threshold = 0
+	assert.True(t, allow())
+	assert.Equal(t, CircuitClosed, b.stats().State)
+	for i := 0; i < 10; i++ {
+		b.record(false)
+	}
+	assert.True(t, allow())
+}
+
+func TestIsSendFailure(t *testing.T) {
+	assert.True(t, isSendFailure(transmission.Response{Err: errors.New("connection refused")}))
+	assert.True(t, isSendFailure(transmission.Response{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, isSendFailure(transmission.Response{StatusCode: http.StatusServiceUnavailable}))
+	assert.False(t, isSendFailure(transmission.Response{StatusCode: http.StatusAccepted}))
+	assert.False(t, isSendFailure(transmission.Response{StatusCode: http.StatusUnauthorized}))
+}
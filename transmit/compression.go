@@ -0,0 +1,60 @@
+package transmit
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/klauspost/compress/zstd"
+)
+
+const histogramCompressionRatio = "compression_ratio"
+
+// compressionRatioTransport records how well the batches libhoney sends are
+// compressed. libhoney compresses each batch as a single zstd frame, whose
+// header carries the uncompressed size, so the ratio can be worked out without
+// decompressing anything.
+type compressionRatioTransport struct {
+	http.RoundTripper
+	Metrics metrics.Metrics
+}
+
+// NewCompressionRatioTransport wraps rt so that the compression ratio of each
+// zstd-encoded request is recorded in m. Requests that aren't compressed are
+// passed through untouched.
+func NewCompressionRatioTransport(rt http.RoundTripper, m metrics.Metrics) http.RoundTripper {
+	return &compressionRatioTransport{RoundTripper: rt, Metrics: m}
+}
+
+func (t *compressionRatioTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Content-Encoding") == "zstd" && req.ContentLength > 0 {
+		if size, ok := zstdContentSize(req); ok {
+			t.Metrics.Histogram(histogramCompressionRatio, float64(size)/float64(req.ContentLength))
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// zstdContentSize reads the uncompressed size from the frame header of req's
+// body, using a fresh copy of the body so that the request is unaffected.
+func zstdContentSize(req *http.Request) (uint64, bool) {
+	if req.GetBody == nil {
+		return 0, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return 0, false
+	}
+	defer body.Close()
+
+	buf := make([]byte, zstd.HeaderMaxSize)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, false
+	}
+	var header zstd.Header
+	if err := header.Decode(buf[:n]); err != nil || !header.HasFCS {
+		return 0, false
+	}
+	return header.FrameContentSize, true
+}
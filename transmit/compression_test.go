@@ -0,0 +1,52 @@
+package transmit
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestCompressionRatioTransport(t *testing.T) {
+	m := &metrics.MockMetrics{}
+	m.Start()
+	var sent []byte
+	rt := NewCompressionRatioTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(req.Body)
+		sent = buf.Bytes()
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}), m)
+
+	payload := []byte(strings.Repeat(`{"data":{"trace.trace_id":"abc"}}`, 100))
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	compressed := enc.EncodeAll(payload, nil)
+
+	req, err := http.NewRequest("POST", "http://example.com/1/batch/ds", bytes.NewReader(compressed))
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "zstd")
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+
+	// the body still reaches the wrapped transport intact
+	assert.Equal(t, compressed, sent)
+	require.Len(t, m.Histograms[histogramCompressionRatio], 1)
+	assert.InDelta(t, float64(len(payload))/float64(len(compressed)), m.Histograms[histogramCompressionRatio][0], 0.001)
+
+	// uncompressed requests aren't recorded
+	req, err = http.NewRequest("POST", "http://example.com/1/batch/ds", bytes.NewReader(payload))
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Len(t, m.Histograms[histogramCompressionRatio], 1)
+}
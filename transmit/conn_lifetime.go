@@ -0,0 +1,177 @@
+package transmit
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/refinery/metrics"
+)
+
+const counterConnsRecycled = "connections_recycled"
+
+// ConnLifetime closes a transport's connections once they've been open for
+// longer than a maximum lifetime, so that they're replaced before a load
+// balancer that recycles connections by age closes them from its end.
+//
+// A connection is only closed when no request is using it, and only requests
+// made through a round tripper from Wrap are known to be using a connection,
+// so every client of the transport has to go through Wrap.
+type ConnLifetime struct {
+	lifetime time.Duration
+	Metrics  metrics.Metrics
+}
+
+// LimitConnLifetime makes t's connections close after lifetime, counting
+// them in m. It wraps t's DialContext and DialTLSContext, so it must be called
+// after they're set; for HTTPS, t has to dial TLS connections itself, as
+// srv.Resolver.WrapTransport makes it, so that the connections the transport
+// uses are the ones being timed. If lifetime isn't positive, t is unchanged
+// and so are the round trippers the returned ConnLifetime wraps.
+func LimitConnLifetime(t *http.Transport, lifetime time.Duration, m metrics.Metrics) *ConnLifetime {
+	l := &ConnLifetime{lifetime: lifetime, Metrics: m}
+	if lifetime <= 0 {
+		return l
+	}
+	t.DialContext = l.wrapDial(t.DialContext)
+	if t.DialTLSContext != nil {
+		t.DialTLSContext = l.wrapDial(t.DialTLSContext)
+	}
+	return l
+}
+
+func (l *ConnLifetime) wrapDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := &lifetimeConn{Conn: conn, Metrics: l.Metrics}
+		c.timer = time.AfterFunc(l.lifetime, c.expire)
+		return c, nil
+	}
+}
+
+// Wrap returns a round tripper that keeps track of which connections rt's
+// requests are using. If there's no limit, or l is nil, rt is returned
+// unchanged.
+func (l *ConnLifetime) Wrap(rt http.RoundTripper) http.RoundTripper {
+	if l == nil || l.lifetime <= 0 {
+		return rt
+	}
+	return &connLifetimeTransport{RoundTripper: rt}
+}
+
+type connLifetimeTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip marks the request's connection as in use until the response body
+// is closed. If the transport retries the request on another connection, the
+// first one is released.
+func (t *connLifetimeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var conn *lifetimeConn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if conn != nil {
+				conn.release()
+				conn = nil
+			}
+			if c, ok := info.Conn.(*lifetimeConn); ok {
+				c.acquire()
+				conn = c
+			}
+		},
+	}
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if conn == nil {
+		return resp, err
+	}
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		conn.release()
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// releasingBody releases its connection once it's been closed.
+type releasingBody struct {
+	io.ReadCloser
+	conn *lifetimeConn
+	once sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.conn.release)
+	return err
+}
+
+// lifetimeConn is a connection that closes itself once its lifetime has
+// passed, as soon as no request is using it.
+type lifetimeConn struct {
+	net.Conn
+	Metrics metrics.Metrics
+	timer   *time.Timer
+
+	mut     sync.Mutex
+	inUse   int
+	expired bool
+	closed  bool
+}
+
+func (c *lifetimeConn) acquire() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.inUse++
+}
+
+func (c *lifetimeConn) release() {
+	c.mut.Lock()
+	c.inUse--
+	recycle := c.expired && c.inUse == 0
+	c.mut.Unlock()
+	if recycle {
+		c.recycle()
+	}
+}
+
+func (c *lifetimeConn) expire() {
+	c.mut.Lock()
+	c.expired = true
+	recycle := c.inUse == 0
+	c.mut.Unlock()
+	if recycle {
+		c.recycle()
+	}
+}
+
+// recycle closes the connection because it's expired. The transport notices
+// that an idle connection has been closed and stops using it.
+func (c *lifetimeConn) recycle() {
+	c.mut.Lock()
+	if c.closed {
+		c.mut.Unlock()
+		return
+	}
+	c.closed = true
+	c.mut.Unlock()
+	c.Metrics.Increment(counterConnsRecycled)
+	c.Conn.Close()
+}
+
+func (c *lifetimeConn) Close() error {
+	c.mut.Lock()
+	c.closed = true
+	c.mut.Unlock()
+	c.timer.Stop()
+	return c.Conn.Close()
+}
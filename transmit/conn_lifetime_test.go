@@ -0,0 +1,83 @@
+package transmit
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLifetime(t *testing.T) {
+	var newConns atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	m := &metrics.MockMetrics{}
+	m.Start()
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	lifetime := LimitConnLifetime(transport, 50*time.Millisecond, m)
+	client := &http.Client{Transport: lifetime.Wrap(transport)}
+
+	get := func(path string) {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	recycled := func() float64 {
+		v, _ := m.Get(counterConnsRecycled)
+		return v
+	}
+
+	get("/")
+	get("/")
+	assert.Equal(t, int64(1), newConns.Load(), "the connection is reused while it's young")
+
+	// an idle connection is closed once it expires
+	assert.Eventually(t, func() bool { return recycled() == 1 }, time.Second, 10*time.Millisecond)
+	get("/")
+	assert.Equal(t, int64(2), newConns.Load())
+
+	// a busy connection isn't closed until its request is done
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get("/slow")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, float64(1), recycled(), "the busy connection is still open")
+	close(release)
+	<-done
+	assert.Equal(t, float64(2), recycled())
+}
+
+func TestLimitConnLifetimeDisabled(t *testing.T) {
+	transport := &http.Transport{}
+	lifetime := LimitConnLifetime(transport, 0, &metrics.NullMetrics{})
+	assert.Nil(t, transport.DialContext)
+	assert.Same(t, transport, lifetime.Wrap(transport))
+
+	var unset *ConnLifetime
+	assert.Same(t, transport, unset.Wrap(transport))
+}
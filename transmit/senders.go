@@ -0,0 +1,43 @@
+package transmit
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/honeycombio/refinery/metrics"
+)
+
+const (
+	gaugeSendersInFlight   = "senders_in_flight"
+	gaugeSenderUtilization = "sender_utilization"
+)
+
+// senderUtilizationTransport records how many of libhoney's senders are busy.
+// Each sender makes one request at a time, so the requests in flight are the
+// senders in use.
+type senderUtilizationTransport struct {
+	http.RoundTripper
+	Metrics  metrics.Metrics
+	senders  float64
+	inFlight atomic.Int64
+}
+
+// NewSenderUtilizationTransport wraps rt so that the number of requests in
+// flight, and that number as a fraction of senders, are recorded in m.
+// senders should be the MaxConcurrentBatches of the libhoney client.
+func NewSenderUtilizationTransport(rt http.RoundTripper, m metrics.Metrics, senders uint) http.RoundTripper {
+	return &senderUtilizationTransport{RoundTripper: rt, Metrics: m, senders: float64(senders)}
+}
+
+func (t *senderUtilizationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.record(t.inFlight.Add(1))
+	defer func() { t.record(t.inFlight.Add(-1)) }()
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func (t *senderUtilizationTransport) record(inFlight int64) {
+	t.Metrics.Gauge(gaugeSendersInFlight, inFlight)
+	if t.senders > 0 {
+		t.Metrics.Gauge(gaugeSenderUtilization, float64(inFlight)/t.senders)
+	}
+}
@@ -0,0 +1,35 @@
+package transmit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/honeycombio/refinery/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderUtilizationTransport(t *testing.T) {
+	m := &metrics.MockMetrics{}
+	m.Start()
+	var rt http.RoundTripper
+	rt = NewSenderUtilizationTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		// while the request is in flight, one of the four senders is busy
+		v, ok := m.Get(gaugeSendersInFlight)
+		require.True(t, ok)
+		assert.Equal(t, float64(1), v)
+		v, _ = m.Get(gaugeSenderUtilization)
+		assert.Equal(t, 0.25, v)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}), m, 4)
+
+	req, err := http.NewRequest("POST", "http://example.com/1/batch/ds", nil)
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+
+	v, _ := m.Get(gaugeSendersInFlight)
+	assert.Equal(t, float64(0), v)
+	v, _ = m.Get(gaugeSenderUtilization)
+	assert.Equal(t, float64(0), v)
+}
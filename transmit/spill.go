@@ -0,0 +1,280 @@
+package transmit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	gaugeSpillDepth       = "spill_depth_bytes"
+	counterSpillWritten   = "spill_written"
+	counterSpillReplayed  = "spill_replayed"
+	counterSpillDropped   = "spill_dropped_bytes"
+	counterSpillCorrupted = "spill_corrupt_records"
+)
+
+// spillSegments is how many files the spill is split into. When it's
+// full, the oldest file is deleted to make room, so at most this fraction
+// of the spilled events is lost at a time.
+const spillSegments = 8
+
+const spillSuffix = ".spill"
+
+// each record is its length and checksum followed by the event
+const spillHeaderSize = 8
+
+// spilledEvent is what's written to disk for each event.
+type spilledEvent struct {
+	APIHost     string                 `msgpack:"h"`
+	APIKey      string                 `msgpack:"k"`
+	Dataset     string                 `msgpack:"d"`
+	Environment string                 `msgpack:"e"`
+	SampleRate  uint                   `msgpack:"r"`
+	Timestamp   time.Time              `msgpack:"t"`
+	Data        map[string]interface{} `msgpack:"f"`
+}
+
+// spillBuffer is a bounded ring of events on disk, kept in a directory of
+// numbered segment files. Events are appended to the newest segment and read
+// back from the oldest one, and a segment is deleted once it has been read.
+// When writing an event would use more than maxSize, the oldest segment is
+// deleted, events and all.
+//
+// Records that can't be read, like the last one written before a crash, are
+// skipped along with the rest of their segment, and counted by corrupt.
+type spillBuffer struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+
+	mut      sync.Mutex
+	segments []spillSegment // oldest first; the last one is being written
+	writer   *os.File
+	reader   *bufio.Reader
+	readFile *os.File
+	next     int
+	size     int64
+	dropped  int64
+	corrupt  int64
+}
+
+type spillSegment struct {
+	id   int
+	size int64
+}
+
+// newSpillBuffer opens the spill in dir, creating it if need be, and picks
+// up any events left there by a previous run.
+func newSpillBuffer(dir string, maxSize int64) (*spillBuffer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spill directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading spill directory: %w", err)
+	}
+	s := &spillBuffer{dir: dir, maxSize: maxSize, segmentSize: maxSize / spillSegments}
+	for _, entry := range entries {
+		var id int
+		name := entry.Name()
+		if !strings.HasSuffix(name, spillSuffix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, spillSuffix), "%d", &id); err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, spillSegment{id: id, size: info.Size()})
+		s.size += info.Size()
+		if id >= s.next {
+			s.next = id + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+	return s, nil
+}
+
+func (s *spillBuffer) path(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, spillSuffix))
+}
+
+// write appends an event to the spill. An event too big to fit at all is
+// dropped.
+func (s *spillBuffer) write(ev spilledEvent) error {
+	payload, err := msgpack.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, spillHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	copy(record[spillHeaderSize:], payload)
+	recordSize := int64(len(record))
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if recordSize > s.segmentSize {
+		s.dropped += recordSize
+		return errors.New("event is too large to spill")
+	}
+	if s.writer == nil || s.segments[len(s.segments)-1].size+recordSize > s.segmentSize {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	for s.size+recordSize > s.maxSize && len(s.segments) > 1 {
+		s.dropOldestLocked()
+	}
+	n, err := s.writer.Write(record)
+	s.segments[len(s.segments)-1].size += int64(n)
+	s.size += int64(n)
+	return err
+}
+
+// rotateLocked starts a new segment for writing.
+func (s *spillBuffer) rotateLocked() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	f, err := os.OpenFile(s.path(s.next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating spill segment: %w", err)
+	}
+	s.writer = f
+	s.segments = append(s.segments, spillSegment{id: s.next})
+	s.next++
+	return nil
+}
+
+// dropOldestLocked deletes the oldest segment without reading it.
+func (s *spillBuffer) dropOldestLocked() {
+	oldest := s.segments[0]
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile, s.reader = nil, nil
+	}
+	os.Remove(s.path(oldest.id))
+	s.segments = s.segments[1:]
+	s.size -= oldest.size
+	s.dropped += oldest.size
+}
+
+// read returns the oldest event in the spill, or false if it's empty.
+func (s *spillBuffer) read() (spilledEvent, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for len(s.segments) > 0 {
+		if s.reader == nil {
+			if len(s.segments) == 1 && s.writer != nil {
+				if s.segments[0].size == 0 {
+					return spilledEvent{}, false
+				}
+				// don't read the segment that's being written
+				if err := s.rotateLocked(); err != nil {
+					return spilledEvent{}, false
+				}
+			}
+			f, err := os.Open(s.path(s.segments[0].id))
+			if err != nil {
+				s.finishSegmentLocked()
+				continue
+			}
+			s.readFile, s.reader = f, bufio.NewReader(f)
+		}
+		ev, err := s.readRecordLocked()
+		if err == nil {
+			return ev, true
+		}
+		if err != io.EOF {
+			s.corrupt++
+		}
+		s.finishSegmentLocked()
+	}
+	return spilledEvent{}, false
+}
+
+func (s *spillBuffer) readRecordLocked() (spilledEvent, error) {
+	var ev spilledEvent
+	header := make([]byte, spillHeaderSize)
+	if _, err := io.ReadFull(s.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ev, errors.New("partial record header")
+		}
+		return ev, err
+	}
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > s.segmentSize {
+		return ev, errors.New("invalid record length")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, payload); err != nil {
+		return ev, errors.New("partial record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return ev, errors.New("record checksum mismatch")
+	}
+	if err := msgpack.Unmarshal(payload, &ev); err != nil {
+		return ev, err
+	}
+	return ev, nil
+}
+
+// finishSegmentLocked deletes the oldest segment once it's been read.
+func (s *spillBuffer) finishSegmentLocked() {
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile, s.reader = nil, nil
+	}
+	oldest := s.segments[0]
+	os.Remove(s.path(oldest.id))
+	s.segments = s.segments[1:]
+	s.size -= oldest.size
+}
+
+// depth returns how many bytes the spill is using on disk.
+func (s *spillBuffer) depth() int64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.size
+}
+
+// counts returns how many bytes of events have been dropped because the
+// spill was full, and how many records couldn't be read, and resets both.
+func (s *spillBuffer) counts() (dropped, corrupt int64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	dropped, corrupt = s.dropped, s.corrupt
+	s.dropped, s.corrupt = 0, 0
+	return dropped, corrupt
+}
+
+func (s *spillBuffer) close() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile, s.reader = nil, nil
+	}
+}
@@ -0,0 +1,120 @@
+package transmit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpilledEvent(i int) spilledEvent {
+	return spilledEvent{
+		APIHost:    "http://api",
+		APIKey:     "key",
+		Dataset:    "dataset",
+		SampleRate: 10,
+		Timestamp:  time.Unix(1700000000, 0),
+		Data:       map[string]interface{}{"i": int64(i), "name": "event"},
+	}
+}
+
+func TestSpillBuffer(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpillBuffer(dir, 8*1024)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.write(testSpilledEvent(i)))
+	}
+	assert.Greater(t, s.depth(), int64(0))
+
+	ev, ok := s.read()
+	require.True(t, ok)
+	assert.Equal(t, "key", ev.APIKey)
+	assert.Equal(t, uint(10), ev.SampleRate)
+	assert.True(t, ev.Timestamp.Equal(time.Unix(1700000000, 0)))
+	assert.Equal(t, int64(0), ev.Data["i"], "numbers keep their type")
+
+	// they survive a restart, in order; the segment that was being read
+	// starts again from the beginning
+	s.close()
+	s, err = newSpillBuffer(dir, 8*1024)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		ev, ok := s.read()
+		require.True(t, ok)
+		assert.Equal(t, int64(i), ev.Data["i"])
+	}
+	_, ok = s.read()
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), s.depth())
+
+	// writing and reading can be interleaved
+	require.NoError(t, s.write(testSpilledEvent(100)))
+	ev, ok = s.read()
+	require.True(t, ok)
+	assert.Equal(t, int64(100), ev.Data["i"])
+	_, ok = s.read()
+	assert.False(t, ok)
+	s.close()
+}
+
+func TestSpillBufferFull(t *testing.T) {
+	s, err := newSpillBuffer(t.TempDir(), 8*1024)
+	require.NoError(t, err)
+	defer s.close()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, s.write(testSpilledEvent(i)))
+	}
+	assert.LessOrEqual(t, s.depth(), int64(8*1024))
+	dropped, _ := s.counts()
+	assert.Greater(t, dropped, int64(0))
+
+	// the oldest events were the ones dropped
+	ev, ok := s.read()
+	require.True(t, ok)
+	assert.Greater(t, ev.Data["i"], int64(800))
+
+	big := testSpilledEvent(0)
+	big.Data["big"] = string(make([]byte, 2*1024))
+	assert.Error(t, s.write(big))
+}
+
+func TestSpillBufferCorruptRecords(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpillBuffer(dir, 64*1024)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.write(testSpilledEvent(i)))
+	}
+	s.close()
+
+	// damage the last record, as if a crash had cut it short, and add a
+	// segment that's all garbage
+	path := filepath.Join(dir, "00000000"+spillSuffix)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000001"+spillSuffix), []byte("not a spill record"), 0o600))
+
+	s, err = newSpillBuffer(dir, 64*1024)
+	require.NoError(t, err)
+	defer s.close()
+	require.NoError(t, s.write(testSpilledEvent(3)))
+
+	var got []interface{}
+	for {
+		ev, ok := s.read()
+		if !ok {
+			break
+		}
+		got = append(got, ev.Data["i"])
+	}
+	assert.Equal(t, []interface{}{int64(0), int64(1), int64(3)}, got)
+	_, corrupt := s.counts()
+	assert.Equal(t, int64(2), corrupt)
+}
@@ -3,6 +3,7 @@ package transmit
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	libhoney "github.com/honeycombio/libhoney-go"
@@ -26,6 +27,7 @@ const (
 	counterEnqueueErrors  = "enqueue_errors"
 	counterResponse20x    = "response_20x"
 	counterResponseErrors = "response_errors"
+	counterSelfTestDrops  = "selftest_dropped"
 	updownQueuedItems     = "queued_items"
 	histogramQueueTime    = "queue_time"
 )
@@ -39,11 +41,31 @@ type DefaultTransmission struct {
 
 	// Type is peer or upstream, and used only for naming metrics
 	Name string
+	// EnableSpill lets the transmission keep events in UpstreamSpillPath
+	// while they can't be sent
+	EnableSpill bool
+	// NewClient, if set, makes the clients for destinations that
+	// BatchingByDestination gives their own batching settings; LibhClient
+	// must have been made with the global settings
+	NewClient NewClientFunc
 
 	builder          *libhoney.Builder
+	processCtx       context.Context
 	responseCanceler context.CancelFunc
+	breaker          *circuitBreaker
+	spill            *spillBuffer
+	queued           atomic.Int64
+
+	defaultBatching  batchingSettings
+	batchingMut      sync.Mutex
+	batchingClients  []*libhoney.Client
+	batchingBuilders map[batchingSettings]*libhoney.Builder
 }
 
+// spillReplayInterval is how often spilled events are checked for, and sent
+// if there's room.
+const spillReplayInterval = time.Second
+
 var once sync.Once
 
 func NewDefaultTransmission(client *libhoney.Client, m metrics.Metrics, name string) *DefaultTransmission {
@@ -67,12 +89,45 @@ func (d *DefaultTransmission) Start() error {
 	d.Metrics.Register(counterEnqueueErrors, "counter")
 	d.Metrics.Register(counterResponse20x, "counter")
 	d.Metrics.Register(counterResponseErrors, "counter")
+	d.Metrics.Register(counterSelfTestDrops, "counter")
 	d.Metrics.Register(updownQueuedItems, "updown")
 	d.Metrics.Register(histogramQueueTime, "histogram")
+	d.Metrics.Register(histogramCompressionRatio, "histogram")
+	d.Metrics.Register(gaugeSendersInFlight, "gauge")
+	d.Metrics.Register(gaugeSenderUtilization, "gauge")
+	d.Metrics.Register(counterConnsRecycled, "counter")
+	d.Metrics.Register(gaugeCircuitBreakerState, "gauge")
+	d.Metrics.Register(counterCircuitBreakerTripped, "counter")
+	d.Metrics.Register(counterCircuitBreakerDrops, "counter")
+
+	d.breaker = newCircuitBreaker(d.Config.GetUpstreamCircuitBreakerThreshold, d.Config.GetUpstreamCircuitBreakerCooldown)
+
+	if path := d.Config.GetUpstreamSpillPath(); d.EnableSpill && path != "" {
+		d.Metrics.Register(gaugeSpillDepth, "gauge")
+		d.Metrics.Register(counterSpillWritten, "counter")
+		d.Metrics.Register(counterSpillReplayed, "counter")
+		d.Metrics.Register(counterSpillDropped, "counter")
+		d.Metrics.Register(counterSpillCorrupted, "counter")
+		spill, err := newSpillBuffer(path, d.Config.GetUpstreamSpillMaxSize())
+		if err != nil {
+			return err
+		}
+		d.spill = spill
+		if depth := spill.depth(); depth > 0 {
+			d.Logger.Info().WithString("path", path).WithField("bytes", depth).Logf("found spilled events from a previous run")
+		}
+	}
+
+	d.defaultBatching = batchingSettings{maxBatchSize: d.Config.GetMaxBatchSize(), batchTimeout: d.Config.GetBatchTimeout()}
+	d.batchingBuilders = make(map[batchingSettings]*libhoney.Builder)
 
 	processCtx, canceler := context.WithCancel(context.Background())
+	d.processCtx = processCtx
 	d.responseCanceler = canceler
 	go d.processResponses(processCtx, d.LibhClient.TxResponses())
+	if d.spill != nil {
+		go d.replaySpill(processCtx)
+	}
 
 	// listen for config reloads
 	d.Config.RegisterReloadCallback(d.reloadTransmissionBuilder)
@@ -92,7 +147,39 @@ func (d *DefaultTransmission) EnqueueEvent(ev *types.Event) {
 		WithString("api_host", ev.APIHost).
 		WithString("dataset", ev.Dataset).
 		Logf("transmit sending event")
-	libhEv := d.builder.NewEventSized(len(ev.Data))
+	// synthetic events from a self test made it all the way through; count
+	// them, but don't send them anywhere unless we were asked to
+	if run := ev.SelfTest(); run != nil {
+		run.RecordKept()
+		if !run.SendUpstream {
+			d.Metrics.Increment(counterSelfTestDrops)
+			return
+		}
+	}
+	// while the destination is failing, spill or drop events rather than let
+	// them pile up in the queue
+	if d.breaker != nil {
+		ok, state, changed := d.breaker.allow()
+		if changed {
+			d.Metrics.Gauge(gaugeCircuitBreakerState, circuitStateGauge[state])
+		}
+		if !ok {
+			if !d.spillEvent(ev) {
+				d.Metrics.Increment(counterCircuitBreakerDrops)
+			}
+			return
+		}
+	}
+	// rather than wait for room in a full queue, keep the event until there is
+	if d.spill != nil && d.queued.Load() >= int64(d.Config.GetUpstreamBufferSize()) && d.spillEvent(ev) {
+		return
+	}
+	d.send(ev)
+}
+
+// send hands an event to libhoney.
+func (d *DefaultTransmission) send(ev *types.Event) {
+	libhEv := d.builderFor(ev).NewEventSized(len(ev.Data))
 	libhEv.APIHost = ev.APIHost
 	libhEv.WriteKey = ev.APIKey
 	libhEv.Dataset = ev.Dataset
@@ -128,6 +215,7 @@ func (d *DefaultTransmission) EnqueueEvent(ev *types.Event) {
 			WithString("environment", ev.Environment).
 			Logf("failed to enqueue event")
 	}
+	d.queued.Add(1)
 	d.Metrics.Up(updownQueuedItems)
 }
 
@@ -138,6 +226,35 @@ func (d *DefaultTransmission) EnqueueSpan(sp *types.Span) {
 
 func (d *DefaultTransmission) Flush() {
 	d.LibhClient.Flush()
+	d.flushBatchingClients()
+}
+
+// CircuitBreakerStats reports the state of the transmission's circuit breaker.
+func (d *DefaultTransmission) CircuitBreakerStats() CircuitBreakerStats {
+	if d.breaker == nil {
+		return CircuitBreakerStats{State: CircuitClosed}
+	}
+	return d.breaker.stats()
+}
+
+// recordSendResult updates the circuit breaker with the outcome of a send.
+func (d *DefaultTransmission) recordSendResult(r transmission.Response) {
+	if d.breaker == nil {
+		return
+	}
+	state, changed := d.breaker.record(!isSendFailure(r))
+	if !changed {
+		return
+	}
+	d.Metrics.Gauge(gaugeCircuitBreakerState, circuitStateGauge[state])
+	switch state {
+	case CircuitOpen:
+		d.Metrics.Increment(counterCircuitBreakerTripped)
+		d.Logger.Warn().WithString("transmission", d.Name).WithField("cooldown", d.Config.GetUpstreamCircuitBreakerCooldown().String()).
+			Logf("sends are failing; dropping events until the circuit breaker's cooldown has passed")
+	case CircuitClosed:
+		d.Logger.Info().WithString("transmission", d.Name).Logf("sends are succeeding again; circuit breaker closed")
+	}
 }
 
 func (d *DefaultTransmission) Stop() error {
@@ -147,9 +264,88 @@ func (d *DefaultTransmission) Stop() error {
 	}
 	// purge the queue of any in-flight events
 	d.LibhClient.Flush()
+	d.flushBatchingClients()
+	if d.spill != nil {
+		d.spill.close()
+	}
 	return nil
 }
 
+// spillEvent writes an event to the spill, and reports whether it did.
+func (d *DefaultTransmission) spillEvent(ev *types.Event) bool {
+	if d.spill == nil {
+		return false
+	}
+	err := d.spill.write(spilledEvent{
+		APIHost:     ev.APIHost,
+		APIKey:      ev.APIKey,
+		Dataset:     ev.Dataset,
+		Environment: ev.Environment,
+		SampleRate:  ev.SampleRate,
+		Timestamp:   ev.Timestamp,
+		Data:        ev.Data,
+	})
+	if err != nil {
+		d.Logger.Error().WithString("error", err.Error()).WithString("dataset", ev.Dataset).Logf("failed to spill event")
+		return false
+	}
+	d.Metrics.Increment(counterSpillWritten)
+	return true
+}
+
+func (d *DefaultTransmission) replaySpill(ctx context.Context) {
+	ticker := time.NewTicker(spillReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.replaySpilled()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replaySpilled sends spilled events while the destination is healthy,
+// filling at most half of the queue so that there's room for new events.
+func (d *DefaultTransmission) replaySpilled() {
+	defer d.reportSpill()
+	if d.breaker != nil && d.breaker.stats().State != CircuitClosed {
+		return
+	}
+	room := int64(d.Config.GetUpstreamBufferSize()/2) - d.queued.Load()
+	for ; room > 0; room-- {
+		spilled, ok := d.spill.read()
+		if !ok {
+			return
+		}
+		d.send(&types.Event{
+			Context:     context.Background(),
+			APIHost:     spilled.APIHost,
+			APIKey:      spilled.APIKey,
+			Dataset:     spilled.Dataset,
+			Environment: spilled.Environment,
+			SampleRate:  spilled.SampleRate,
+			Timestamp:   spilled.Timestamp,
+			Data:        spilled.Data,
+		})
+		d.Metrics.Increment(counterSpillReplayed)
+	}
+}
+
+func (d *DefaultTransmission) reportSpill() {
+	d.Metrics.Gauge(gaugeSpillDepth, d.spill.depth())
+	dropped, corrupt := d.spill.counts()
+	if dropped > 0 {
+		d.Metrics.Count(counterSpillDropped, dropped)
+		d.Logger.Warn().WithField("bytes", dropped).Logf("spill is full; dropped the oldest spilled events")
+	}
+	if corrupt > 0 {
+		d.Metrics.Count(counterSpillCorrupted, corrupt)
+		d.Logger.Warn().WithField("records", corrupt).Logf("skipped unreadable spilled events")
+	}
+}
+
 func (d *DefaultTransmission) processResponses(
 	ctx context.Context,
 	responses chan transmission.Response,
@@ -191,6 +387,8 @@ func (d *DefaultTransmission) processResponses(
 				}
 				d.Metrics.Increment(counterResponse20x)
 			}
+			d.recordSendResult(r)
+			d.queued.Add(-1)
 			d.Metrics.Down(updownQueuedItems)
 			d.Metrics.Histogram(histogramQueueTime, dequeuedAt-enqueuedAt)
 		case <-ctx.Done():
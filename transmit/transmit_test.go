@@ -1,15 +1,21 @@
 package transmit
 
 import (
+	"context"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/facebookgo/inject"
 	"github.com/honeycombio/refinery/config"
 	"github.com/honeycombio/refinery/logger"
 	"github.com/honeycombio/refinery/metrics"
+	"github.com/honeycombio/refinery/types"
 
 	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDefaultTransmissionUpdatesUserAgentAdditionAfterStart(t *testing.T) {
@@ -45,3 +51,112 @@ func TestDependencyInjection(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDefaultTransmissionSpill(t *testing.T) {
+	sender := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{Transmission: sender})
+	require.NoError(t, err)
+	d := &DefaultTransmission{
+		Config: &config.MockConfig{
+			GetUpstreamBufferSizeVal:        100,
+			UpstreamCircuitBreakerThreshold: 1,
+			UpstreamCircuitBreakerCooldown:  time.Hour,
+			UpstreamSpillPath:               t.TempDir(),
+			UpstreamSpillMaxSize:            8 * 1024 * 1024,
+		},
+		Logger:      &logger.NullLogger{},
+		Metrics:     &metrics.NullMetrics{},
+		LibhClient:  client,
+		EnableSpill: true,
+	}
+	require.NoError(t, d.Start())
+	defer d.Stop()
+
+	ev := &types.Event{
+		Context: context.Background(),
+		APIHost: "http://api",
+		APIKey:  "key",
+		Dataset: "dataset",
+		Data:    map[string]interface{}{"a": 1},
+	}
+	d.recordSendResult(transmission.Response{StatusCode: http.StatusServiceUnavailable})
+	require.Equal(t, CircuitOpen, d.CircuitBreakerStats().State)
+	d.EnqueueEvent(ev)
+	d.EnqueueEvent(ev)
+	assert.Empty(t, sender.Events())
+	assert.Greater(t, d.spill.depth(), int64(0))
+
+	// nothing is replayed until the destination recovers
+	d.replaySpilled()
+	assert.Empty(t, sender.Events())
+
+	d.recordSendResult(transmission.Response{StatusCode: http.StatusAccepted})
+	d.replaySpilled()
+	require.Len(t, sender.Events(), 2)
+	assert.Equal(t, "dataset", sender.Events()[0].Dataset)
+	assert.Equal(t, int64(0), d.spill.depth())
+}
+
+func TestDefaultTransmissionBatchingByDestination(t *testing.T) {
+	newClient := func(t *testing.T) (*libhoney.Client, *transmission.MockSender) {
+		sender := &transmission.MockSender{}
+		client, err := libhoney.NewClient(libhoney.ClientConfig{Transmission: sender})
+		require.NoError(t, err)
+		return client, sender
+	}
+
+	defaultClient, defaultSender := newClient(t)
+	senders := make(map[batchingSettings]*transmission.MockSender)
+	d := &DefaultTransmission{
+		Config: &config.MockConfig{
+			GetMaxBatchSizeVal: 500,
+			GetBatchTimeoutVal: 100 * time.Millisecond,
+			BatchingByDestination: map[string]config.BatchingConfig{
+				"big":        {MaxBatchSize: 2000},
+				"also-big":   {MaxBatchSize: 2000},
+				"production": {BatchTimeout: config.Duration(20 * time.Millisecond)},
+				"same":       {MaxBatchSize: 500},
+			},
+		},
+		Logger:     &logger.NullLogger{},
+		Metrics:    &metrics.NullMetrics{},
+		LibhClient: defaultClient,
+		NewClient: func(maxBatchSize uint, batchTimeout time.Duration) (*libhoney.Client, error) {
+			client, sender := newClient(t)
+			senders[batchingSettings{maxBatchSize: maxBatchSize, batchTimeout: batchTimeout}] = sender
+			return client, nil
+		},
+	}
+	require.NoError(t, d.Start())
+	defer d.Stop()
+
+	send := func(environment, dataset string) {
+		d.EnqueueEvent(&types.Event{
+			Context:     context.Background(),
+			APIHost:     "http://api",
+			APIKey:      "key",
+			Dataset:     dataset,
+			Environment: environment,
+			Data:        map[string]interface{}{"a": 1},
+		})
+	}
+	send("", "small")
+	send("", "same")
+	send("", "big")
+	send("production", "also-big")
+	send("production", "other")
+	d.Flush()
+
+	require.Len(t, senders, 2, "destinations with the same settings share a client")
+	datasets := func(sender *transmission.MockSender) []string {
+		var names []string
+		for _, ev := range sender.Events() {
+			names = append(names, ev.Dataset)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"small", "same"}, datasets(defaultSender))
+	assert.Equal(t, []string{"big", "also-big"}, datasets(senders[batchingSettings{2000, 100 * time.Millisecond}]),
+		"a dataset's settings are used in preference to its environment's")
+	assert.Equal(t, []string{"other"}, datasets(senders[batchingSettings{500, 20 * time.Millisecond}]))
+}
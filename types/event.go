@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -19,6 +20,7 @@ const (
 	SampleRateHeader  = "X-Honeycomb-Samplerate"
 	TimestampHeader   = "X-Honeycomb-Event-Time"
 	QueryTokenHeader  = "X-Honeycomb-Refinery-Query"
+	RequestIDHeader   = "X-Refinery-Request-ID"
 )
 
 type Fielder interface {
@@ -38,12 +40,62 @@ type Event struct {
 	SampleRate  uint
 	Timestamp   time.Time
 	Data        map[string]interface{}
+
+	// SamplerKeyValues holds normalized values of fields in Data, from
+	// SamplerKeyNormalizations. They're used in place of the values in Data
+	// when building sampler keys, so that Data can be sent on unchanged.
+	SamplerKeyValues map[string]interface{}
+
+	// selfTest is set on synthetic events generated by /query/selftest. It
+	// isn't part of Data, so nothing a client sends can set it.
+	selfTest *SelfTestRun
 }
 
 func (e *Event) Fields() map[string]interface{} {
 	return e.Data
 }
 
+// SamplerKeyValue returns the value of field to use when building sampler
+// keys: the normalized value, if there is one, or the value in Data.
+func (e *Event) SamplerKeyValue(field string) (interface{}, bool) {
+	if val, ok := e.SamplerKeyValues[field]; ok {
+		return val, true
+	}
+	val, ok := e.Data[field]
+	return val, ok
+}
+
+// MarkSelfTest attaches the event to a self test run.
+func (e *Event) MarkSelfTest(run *SelfTestRun) {
+	e.selfTest = run
+}
+
+// SelfTest returns the self test run that generated the event, or nil for
+// real traffic.
+func (e *Event) SelfTest() *SelfTestRun {
+	return e.selfTest
+}
+
+// SelfTestRun tracks the synthetic events generated by one /query/selftest
+// request. Transmission reports each of its events that reaches it, which
+// means that its trace was kept, and only sends them upstream if SendUpstream
+// is set.
+type SelfTestRun struct {
+	SendUpstream bool
+
+	kept atomic.Int64
+}
+
+// RecordKept counts an event from the run that was kept.
+func (r *SelfTestRun) RecordKept() {
+	r.kept.Add(1)
+}
+
+// Kept returns the number of the run's events that have been kept so far.
+func (r *SelfTestRun) Kept() int64 {
+	return r.kept.Load()
+}
+
 // Trace isn't something that shows up on the wire; it gets created within
 // Refinery. Traces are not thread-safe; only one goroutine should be working
 // with a trace object at a time.
@@ -89,6 +141,16 @@ type Trace struct {
 
 	mut       sync.Mutex
 	BeingSent bool
+
+	// spanLimitHit is set once spans for this trace have been dropped because
+	// it reached MaxSpansPerTrace
+	spanLimitHit atomic.Bool
+
+	// intraTraceSeen and intraTraceDropped count the spans that arrived after
+	// this trace reached IntraTraceSamplingThreshold, and those of them that
+	// were sampled out
+	intraTraceSeen    atomic.Uint32
+	intraTraceDropped atomic.Uint32
 }
 
 // TryMarkTraceForSending atomically marks a trace as being sent, and returns true if it was
@@ -103,6 +165,34 @@ func (t *Trace) TryMarkTraceForSending() bool {
 	return true
 }
 
+// MarkSpanLimitHit records that spans were dropped from this trace because it
+// had too many.
+func (t *Trace) MarkSpanLimitHit() {
+	t.spanLimitHit.Store(true)
+}
+
+// SpanLimitHit reports whether spans were dropped from this trace because it
+// had too many.
+func (t *Trace) SpanLimitHit() bool {
+	return t.spanLimitHit.Load()
+}
+
+// SampleIntraTrace reports whether to keep a span that arrived after this
+// trace got too big to keep all of its spans. The first of every rate spans
+// is kept, and the rest are counted as dropped.
+func (t *Trace) SampleIntraTrace(rate uint32) bool {
+	if rate <= 1 || (t.intraTraceSeen.Add(1)-1)%rate == 0 {
+		return true
+	}
+	t.intraTraceDropped.Add(1)
+	return false
+}
+
+// IntraTraceDropped returns the number of spans SampleIntraTrace dropped.
+func (t *Trace) IntraTraceDropped() uint32 {
+	return t.intraTraceDropped.Load()
+}
+
 // AddSpan adds a span to this trace
 func (t *Trace) AddSpan(sp *Span) {
 	// We've done all the work to know this is a trace we are putting in our cache, so
@@ -213,23 +303,31 @@ func (t *Trace) SpanEventCount() uint32 {
 }
 
 func (t *Trace) GetSamplerSelector(datasetPrefix string) string {
-	var samplerSelector string
-	if IsLegacyAPIKey(t.APIKey) {
-		samplerSelector = t.Dataset
-		if datasetPrefix != "" {
-			samplerSelector = fmt.Sprintf("%s.%s", datasetPrefix, t.Dataset)
+	var environment string
+	if !IsLegacyAPIKey(t.APIKey) {
+		for _, sp := range t.GetSpans() {
+			if sp.Event.Environment != "" {
+				environment = sp.Event.Environment
+				break
+			}
 		}
-		return samplerSelector
 	}
+	return SamplerSelector(t.APIKey, t.Dataset, environment, datasetPrefix)
+}
 
-	for _, sp := range t.GetSpans() {
-		if sp.Event.Environment != "" {
-			samplerSelector = sp.Event.Environment
-			break
-		}
+// SamplerSelector returns the name that chooses the sampler for telemetry:
+// the dataset, with datasetPrefix if there is one, for a Honeycomb Classic
+// API key, and otherwise the environment. Everything that needs to know which
+// sampler applies uses this, so that it's the same however the telemetry
+// arrived.
+func SamplerSelector(apiKey, dataset, environment, datasetPrefix string) string {
+	if !IsLegacyAPIKey(apiKey) {
+		return environment
 	}
-	return samplerSelector
-
+	if datasetPrefix != "" {
+		return fmt.Sprintf("%s.%s", datasetPrefix, dataset)
+	}
+	return dataset
 }
 
 // Span is an event that shows up with a trace ID, so will be part of a Trace
@@ -240,6 +338,23 @@ type Span struct {
 	DataSize    int
 	ArrivalTime time.Time
 	IsRoot      bool
+	// RoutedDataset is the dataset that the span's DatasetRoutingField says
+	// its trace is sent to, if it has the field
+	RoutedDataset string
+	// ForceKeep is set when the span matches one of the ForceKeepConditions,
+	// so its trace is kept without consulting the sampler
+	ForceKeep bool
+	// Error is set when KeepErrorTraces is enabled and the span matches the
+	// ErrorTraceCondition, so its trace is kept without consulting the sampler
+	Error bool
+	// Synthetic is set when the span matches the SyntheticTraceCondition; its
+	// trace is kept without consulting the sampler or adding to its keys
+	Synthetic bool
+	// TraceComplete is set when the span matches the TraceCompleteCondition;
+	// like a root span, it starts its trace's SendDelay
+	TraceComplete bool
+	// EnqueuedAt is when the collector queued the span for processing
+	EnqueuedAt time.Time
 }
 
 // GetDataSize computes the size of the Data element of the Span.